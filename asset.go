@@ -1,6 +1,7 @@
 package interbuilder
 
 import (
+  "context"
   "net/url"
   "io"
   "time"
@@ -39,6 +40,7 @@ const (
   ASSET_MULTI_ARRAY     uint64 = 0b_100_001
   ASSET_MULTI_FUNC      uint64 = 0b_100_010
   ASSET_MULTI_GENERATOR uint64 = 0b_100_100
+  ASSET_MULTI_CHAN      uint64 = 0b_101_000
 )
 
 
@@ -67,6 +69,11 @@ type Asset struct {
 
   Mimetype  string
 
+  // Metadata holds structured information about an Asset, such
+  // as its front matter, for use by later Tasks.
+  //
+  Metadata  map[string]any
+
   //
   // Content:
   // Assets track content in two ways: a byte buffer
@@ -100,6 +107,30 @@ type Asset struct {
   FileSource string
   FileDest   string
 
+  // FileMode and FileModTime are the permission bits and
+  // modification time of the file an Asset was created from, if
+  // its Spec inherits a true "preserve_metadata" prop, for
+  // consumers which write files to restore.
+  //
+  FileMode     os.FileMode
+  FileModTime  time.Time
+
+  // ContentEncoding is the Content-Encoding of the content read
+  // and written by an Asset's byte reader and writer functions,
+  // such as "gzip" or "br", for assets whose files or bodies are
+  // compressed. Content read through the Asset, as by
+  // GetContentBytes, is decoded, and content written through it
+  // is encoded, so that Tasks work with the decoded content;
+  // consumers storing an Asset use EncodedContentBytes.
+  //
+  ContentEncoding  string
+
+  // ContentHash is the hash of an Asset's content in a
+  // ContentStore, once it is stored there and read back from it
+  // lazily, see Asset.StoreContent.
+  //
+  ContentHash  string
+
   // Asset types: An asset struct can represent a singular asset, an array of
   // assets, or a lazy asset generator.
   //
@@ -113,10 +144,19 @@ type Asset struct {
 
   // Asset Generator: One asset may function like a generator for
   // other assets. In order to act like a generator, an Asset can
-  // store pointers to generator functions which
+  // store pointers to generator functions which return the next
+  // asset, or nil once there are none. generator_start receives
+  // the context of the generation, which generators that block
+  // should select on.
   //
-  generator_start func (a *Asset) (next func () (*Asset, error), err error)
+  generator_start func (ctx context.Context, a *Asset) (next func () (*Asset, error), err error)
   generator_next  func () (*Asset, error)
+
+  // Asset Channel: assets streamed by a producer, received until
+  // the channel is closed. Unlike the other multi-assets, it can
+  // only be expanded once.
+  //
+  asset_chan  <-chan *Asset
 }
 
 
@@ -149,13 +189,30 @@ func (a *Asset) ExtendHistory (add_parents ...*HistoryEntry) *HistoryEntry {
   If this Asset is a generator, return a no-argument next()
   function which will return assets until it runs out, at which
   point it returns nil. If this Asset is not a generator, return
-  an err.
+  an err. Generation is cancelled with the context of the Asset's
+  Spec, as in GenerateAssetsContext.
 */
 func (a *Asset) GenerateAssets () (next_func func () (*Asset, error), err error) {
+  return a.GenerateAssetsContext(a.context())
+}
+
+
+/*
+  GenerateAssetsContext is GenerateAssets, with the context of the
+  generation, which is passed to the generator's start function.
+  Once the context is cancelled, next() returns its cause rather
+  than calling the generator, so that generators which do not
+  select on it still stop between assets.
+*/
+func (a *Asset) GenerateAssetsContext (ctx context.Context) (next_func func () (*Asset, error), err error) {
+  if err := ctx.Err(); err != nil {
+    return nil, context.Cause(ctx)
+  }
+
   var nextFunc func() (*Asset, error)
 
   if a.generator_start != nil {
-    nextFunc, err = a.generator_start(a)
+    nextFunc, err = a.generator_start(ctx, a)
     if err != nil { return nil, err }
   } else {
     nextFunc = a.generator_next
@@ -165,14 +222,24 @@ func (a *Asset) GenerateAssets () (next_func func () (*Asset, error), err error)
     return nil, fmt.Errorf("No generator next function defined")
   }
 
-  return nextFunc, nil
+  return func () (*Asset, error) {
+    if ctx.Err() != nil {
+      return nil, context.Cause(ctx)
+    }
+    return nextFunc()
+  }, nil
 }
 
 
 func (a *Asset) GenerateAssetsArray () ([]*Asset, error) {
+  return a.generateAssetsArray(a.context())
+}
+
+
+func (a *Asset) generateAssetsArray (ctx context.Context) ([]*Asset, error) {
   var assets = make([]*Asset, 0)
 
-  nextFunc, err := a.GenerateAssets()
+  nextFunc, err := a.GenerateAssetsContext(ctx)
   if err != nil { return nil, err }
 
   for {
@@ -186,6 +253,94 @@ func (a *Asset) GenerateAssetsArray () ([]*Asset, error) {
 }
 
 
+/*
+  SetAssetGenerator makes this Asset a generator of other assets,
+  whose start function is called with the context of each
+  generation, as in GenerateAssetsContext, and returns its next()
+  function.
+*/
+func (a *Asset) SetAssetGenerator (start func (ctx context.Context, a *Asset) (func () (*Asset, error), error)) error {
+  if a.TypeMask & ASSET_FIELDS_ACCESS != 0 {
+    return fmt.Errorf("Cannot set asset generator, type mask has existing access bits set, type mask: %O", a.TypeMask)
+  }
+
+  a.TypeMask |= ASSET_MULTI_GENERATOR
+  a.generator_start = start
+  return nil
+}
+
+
+/*
+  SetAssetChannel makes this Asset a multi-asset of the assets
+  received from a channel, so that producers can stream assets
+  without building an array of them. The producer closes the
+  channel once it has sent every asset. As the channel can only
+  be received from once, so can the Asset be expanded; Spec
+  EmitAsset, and Tasks passing it to ones which do not accept
+  multi-assets, receive its assets as they arrive.
+*/
+func (a *Asset) SetAssetChannel (assets <-chan *Asset) error {
+  if a.TypeMask & ASSET_FIELDS_ACCESS != 0 {
+    return fmt.Errorf("Cannot set asset channel, type mask has existing access bits set, type mask: %O", a.TypeMask)
+  }
+
+  a.TypeMask |= ASSET_MULTI_CHAN
+  a.asset_chan = assets
+  return nil
+}
+
+
+/*
+  IsChannel returns whether this Asset is a multi-asset of a
+  channel, as set by SetAssetChannel.
+*/
+func (a *Asset) IsChannel () bool {
+  return a.IsMulti() && a.TypeMask & ASSET_FIELDS_ACCESS & ASSET_MULTI_CHAN != 0
+}
+
+
+/*
+  ReceiveAssets calls fn with each asset received from the channel
+  of a channel multi-asset, as it arrives, until the channel is
+  closed, fn returns an error, or ctx is cancelled, when the cause
+  is returned.
+*/
+func (a *Asset) ReceiveAssets (ctx context.Context, fn func (*Asset) error) error {
+  if !a.IsChannel() {
+    return fmt.Errorf("Cannot receive assets, asset with URL \"%s\" is not a channel multi-asset", a.Url)
+  }
+
+  for {
+    select {
+    case <-ctx.Done():
+      return context.Cause(ctx)
+    case asset, ok := <-a.asset_chan:
+      if !ok {
+        return nil
+      } else if asset == nil {
+        return fmt.Errorf("Received a nil asset from the channel of asset with URL \"%s\"", a.Url)
+      }
+
+      if err := fn(asset); err != nil {
+        return err
+      }
+    }
+  }
+}
+
+
+/*
+  context returns the context assets are generated with by
+  default: that of the Asset's Spec, as in Spec.Context.
+*/
+func (a *Asset) context () context.Context {
+  if a.Spec == nil {
+    return context.Background()
+  }
+  return a.Spec.Context()
+}
+
+
 func (a *Asset) SetAssetArray (assets []*Asset) error {
   if a.TypeMask & ASSET_FIELDS_ACCESS != 0 {
     return fmt.Errorf("Cannot set asset array, type mask has existing access bits set, type mask: %O", a.TypeMask)
@@ -206,7 +361,7 @@ func (s *Spec) PathExists (local_path string) (bool, error) {
   spec_source, err := s.RequirePropString("source_dir")
   if err != nil { return false, err }
 
-  abs_path, err := filepath.Abs(path.Join(spec_source, local_path))
+  abs_path, err := filepath.Abs(filepath.Join(spec_source, KeyToPath(local_path)))
   if err != nil { return false, err }
 
   _, err = os.Stat(abs_path)
@@ -223,28 +378,24 @@ func (s *Spec) PathExists (local_path string) (bool, error) {
 
 /*
   Given a filesystem path inside the Spec's source_dir, return
-  the relative path to the source_dir. Errors if the path is not
-  within the Spec's source_dir.
+  its asset key, relative to the source_dir, as in PathKey. Errors
+  if the path is not within the Spec's source_dir.
 */
 func (s *Spec) GetPathKey (p string) (string, error) {
   spec_source, err := s.RequirePropString("source_dir")
   if err != nil { return "", err }
-  return filepath.Rel(spec_source, p)
+  return PathKey(spec_source, p)
 }
 
 
 /*
-  Convert a Spec Asset key into a filesystem path.
+  Convert a Spec Asset key into a filesystem path within its
+  source_dir, as in KeyToPath.
 */
 func (s *Spec) GetKeyPath (k string) (string, error) {
   spec_source, err := s.RequirePropString("source_dir")
   if err != nil { return "", err }
-
-  if os.PathSeparator != '/' {
-    k = strings.ReplaceAll(k, "/", string(os.PathSeparator))
-  }
-
-  return filepath.Join(spec_source, k), nil
+  return filepath.Join(spec_source, KeyToPath(k)), nil
 }
 
 
@@ -267,7 +418,31 @@ func (s *Spec) WriteFile (key string, data []byte, perm fs.FileMode) error {
 }
 
 
+/*
+  EmitAsset applies this Spec's path transformations to an Asset
+  and outputs it, after passing it through the Spec's emit
+  middleware. The assets of a channel multi-asset are emitted
+  individually, as they are received.
+*/
 func (s *Spec) EmitAsset (a *Asset) error {
+  // The channel could only be received from by one consumer
+  //
+  if a.IsChannel() {
+    return a.ReceiveAssets(s.Context(), s.EmitAsset)
+  }
+
+  s.emit_middleware_lock.RLock()
+  var emit = s.emit_chain
+  s.emit_middleware_lock.RUnlock()
+
+  if emit == nil {
+    return s.emitAsset(a)
+  }
+  return emit(a)
+}
+
+
+func (s *Spec) emitAsset (a *Asset) error {
   if a.Url == nil {
     return fmt.Errorf("Cannot emit a singular asset with a nil URL")
   }
@@ -300,6 +475,10 @@ func (s *Spec) EmitAsset (a *Asset) error {
   // Apply path transformations
   //
   for _, transformation := range s.PathTransformations {
+    if ! transformation.MatchCondition(suffix_path, a.Mimetype) {
+      continue
+    }
+
     suffix_path = transformation.TransformPath(suffix_path)
 
     if !modified && (suffix_path != suffix_path_original) {
@@ -316,16 +495,55 @@ func (s *Spec) EmitAsset (a *Asset) error {
     a           = & copied
   }
 
-  s.OutputAsset(a)
-
-  return nil
+  return s.OutputAsset(a)
 }
 
 
-func (s *Spec) OutputAsset (a *Asset) {
-  for _, output := range s.OutputChannels {
-    (*output) <- a
+/*
+  OutputAsset sends an Asset to each of this Spec's outputs. If
+  the inherited "output_buffer" prop is set, Assets are queued for
+  each output and forwarded as the output consumes them;
+  otherwise, this blocks until each output has received the
+  Asset, in turn. Assets are also recorded in the Spec's
+  checkpoint, if there is one. Outputting an Asset from a
+  different source at the path of one already output returns a
+  DuplicateOutputError.
+*/
+func (s *Spec) OutputAsset (a *Asset) error {
+  if err := s.Frame().add(a); err != nil {
+    return err
   }
+  if err := s.recordCheckpointAsset(a); err != nil {
+    return err
+  }
+
+  s.emitEvent(Event { Type: EVENT_ASSET_EMIT, Asset: a })
+
+  // The parent's merge conflict policy may keep another subspec's
+  // Asset at the same path instead
+  //
+  if s.Parent != nil {
+    if send, err := s.Parent.mergeInputAsset(s, a); err != nil || !send {
+      return err
+    }
+  }
+
+  queues, err := s.outputQueues()
+  if err != nil {
+    return err
+  }
+
+  if queues == nil {
+    for _, output := range s.OutputChannels {
+      (*output) <- a
+    }
+    return nil
+  }
+
+  for _, q := range queues {
+    q.push(a)
+  }
+  return nil
 }
 
 
@@ -346,6 +564,96 @@ func (s *Spec) EmitFileKey (file_path string, key_parts ...string) error {
 }
 
 
+/*
+  EmitFileGlob emits the files under this Spec's source_dir
+  matching a glob pattern as a multi-asset, as made by
+  MakeFileGlobAsset. If no files match, nothing is emitted.
+*/
+func (s *Spec) EmitFileGlob (pattern string, key_prefix ...string) error {
+  asset, err := s.MakeFileGlobAsset(pattern, key_prefix...)
+  if err != nil {
+    return fmt.Errorf("Error emitting files matching %s: %w", pattern, err)
+  } else if asset == nil {
+    return nil
+  }
+  return s.EmitAsset(asset)
+}
+
+
+/*
+  MakeFileGlobAsset creates a multi-asset of file assets for the
+  files under this Spec's source_dir matching a glob pattern,
+  relative to it and separated by forward slashes. "*", "?", and
+  "[...]" match within a path segment, and "**" matches across
+  them, as in IgnoreRules. Each asset is keyed by its path
+  relative to source_dir, joined to key_prefix. Files are walked
+  from the directory before the pattern's first wildcard, as in
+  Spec.WalkIgnoring, so ignored files do not match. If no files
+  match, the returned asset is nil.
+*/
+func (s *Spec) MakeFileGlobAsset (pattern string, key_prefix ...string) (*Asset, error) {
+  source_dir, err := s.RequirePropString("source_dir")
+  if err != nil { return nil, err }
+
+  pattern = strings.TrimPrefix(PathToKey(pattern), "./")
+  if path.IsAbs(pattern) || pattern == ".." || strings.HasPrefix(pattern, "../") {
+    return nil, fmt.Errorf("Glob pattern %s is not relative to the source_dir of spec %s", pattern, s.Name)
+  }
+
+  expression, err := ignorePatternRegexp(pattern, true)
+  if err != nil {
+    return nil, fmt.Errorf("Invalid glob pattern %s: %w", pattern, err)
+  }
+
+  // Walk from the directory of the segments before the first one
+  // with a wildcard, rather than all of source_dir
+  //
+  var base_segments []string
+  for _, segment := range strings.Split(pattern, "/") {
+    if strings.ContainsAny(segment, `*?[\`) {
+      break
+    }
+    base_segments = append(base_segments, segment)
+  }
+  var base = path.Join(base_segments...)
+
+  var base_dir = filepath.Join(source_dir, KeyToPath(base))
+  if info, err := os.Stat(base_dir); os.IsNotExist(err) {
+    return nil, nil
+  } else if err != nil {
+    return nil, err
+  } else if !info.IsDir() {
+    base     = path.Dir(base)
+    base_dir = filepath.Dir(base_dir)
+  }
+
+  var prefix = path.Join(key_prefix...)
+  var assets []*Asset
+  err = s.WalkIgnoring(base_dir, func (file_path, key string, _ fs.DirEntry) error {
+    key = path.Join(base, key)
+    if !expression.MatchString(key) {
+      return nil
+    }
+
+    asset, err := s.MakeFileKeyAsset(file_path, prefix, key)
+    if err != nil { return err }
+    assets = append(assets, asset)
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  } else if len(assets) == 0 {
+    return nil, nil
+  }
+
+  var asset = s.MakeAsset(prefix)
+  if err := asset.SetAssetArray(assets); err != nil {
+    return nil, err
+  }
+  return asset, nil
+}
+
+
 func (s *Spec) MakeAsset (key ...string) *Asset {
   var asset_url *url.URL = s.MakeUrl(key...)
 
@@ -386,7 +694,7 @@ func (s *Spec) MakeFileKeyAsset (source_path string, key_parts ...string) (*Asse
 
   var file_path string = source_path
 
-  if !strings.HasPrefix(file_path, source_dir) {
+  if !PathWithin(source_dir, file_path) {
     file_path = filepath.Join(source_dir, source_path)
   }
 
@@ -419,6 +727,15 @@ func (s *Spec) MakeFileKeyAsset (source_path string, key_parts ...string) (*Asse
     FileDest:     file_path,
   }
 
+  preserve_metadata, ok, found := s.InheritPropBool("preserve_metadata")
+  if found && !ok {
+    prop, _ := s.InheritProp("preserve_metadata")
+    return nil, fmt.Errorf("Prop \"preserve_metadata\" in spec %s is expected to be a boolean, got %T", s.Name, prop)
+  } else if preserve_metadata && !is_dir {
+    new_asset.FileMode    = file_info.Mode().Perm()
+    new_asset.FileModTime = file_info.ModTime()
+  }
+
   if is_dir {
     // This asset is a directory. Populate it with pluralistic callback functions
     new_asset.Mimetype = "inode/directory"
@@ -426,16 +743,13 @@ func (s *Spec) MakeFileKeyAsset (source_path string, key_parts ...string) (*Asse
     type_mask = ASSET_MULTI_FUNC | ASSET_MULTI_GENERATOR
     new_asset.TypeMask = type_mask
 
+    // Files ignored by the spec's ignore rules and files, such as
+    // .ibignore, are not part of the directory's assets
+    //
     var keys = make([]string, 0)
-    var walk_err error = nil
-
-    filepath.WalkDir(file_path, func (rooted_path string, entry fs.DirEntry, err error) error {
-      walk_err = err
-      if entry.IsDir() {
-        return nil
-      }
 
-      keys = append(keys, rooted_path[ len(file_path) : ])
+    var walk_err = s.WalkIgnoring(file_path, func (_, key string, _ fs.DirEntry) error {
+      keys = append(keys, key)
       return nil
     })
 
@@ -447,7 +761,7 @@ func (s *Spec) MakeFileKeyAsset (source_path string, key_parts ...string) (*Asse
       var assets = make([]*Asset, 0, len(keys))
 
       for _, key := range keys {
-        var file_path string = filepath.Join(file_path, key)
+        var file_path string = filepath.Join(file_path, KeyToPath(key))
         asset, err := s.MakeFileKeyAsset(file_path, base_asset.Url.Path, key)
 
         if err != nil { return nil, err }
@@ -466,7 +780,7 @@ func (s *Spec) MakeFileKeyAsset (source_path string, key_parts ...string) (*Asse
       var key string = keys[generator_index]
       generator_index++
 
-      var file_path string = filepath.Join(file_path, key)
+      var file_path string = filepath.Join(file_path, KeyToPath(key))
 
       asset, err :=  s.MakeFileKeyAsset(file_path, key)
       if err != nil { return nil, err }
@@ -478,8 +792,13 @@ func (s *Spec) MakeFileKeyAsset (source_path string, key_parts ...string) (*Asse
 
     new_asset.Mimetype  = mime.TypeByExtension(filepath.Ext(file_path))
 
-    err := new_asset.SetContentBytesGetReaderFunc(func (a *Asset) (io.Reader, error) {
-      return os.Open(a.FileSource)
+    // Files at least mmap_threshold in size are memory-mapped
+    //
+    mmap_threshold, err := s.mmapThreshold()
+    if err != nil { return nil, err }
+
+    err = new_asset.SetContentBytesGetReaderFunc(func (a *Asset) (io.Reader, error) {
+      return openFileReader(a.FileSource, mmap_threshold)
     })
     if err != nil { return nil, err }
 
@@ -488,9 +807,7 @@ func (s *Spec) MakeFileKeyAsset (source_path string, key_parts ...string) (*Asse
         return nil, fmt.Errorf("FileDest in asset %s not defined", a.Url)
       }
 
-      var directory, _ = path.Split(a.FileDest)
-
-      err = os.MkdirAll(directory, os.ModePerm)
+      err = os.MkdirAll(filepath.Dir(a.FileDest), os.ModePerm)
       if err != nil { return nil, err }
 
       return os.Create(a.FileDest)
@@ -522,7 +839,7 @@ func (s *Spec) AnnexAsset (a *Asset) (*Asset) {
     key = key[ len("/@emit") : ]
   }
 
-  annexed.FileDest = filepath.Join(source_dir, key)
+  annexed.FileDest = filepath.Join(source_dir, KeyToPath(key))
 
   var history_parents = make([]*HistoryEntry, 2, 2)
   history_parents[0] = a.History
@@ -548,7 +865,21 @@ func (a *Asset) IsMulti () bool {
 }
 
 
+/*
+  Expand returns the assets of a multi-asset, or a singular asset
+  by itself, with the context of the Asset's Spec, as in
+  ExpandContext.
+*/
 func (a *Asset) Expand () ([]*Asset, error) {
+  return a.ExpandContext(a.context())
+}
+
+
+/*
+  ExpandContext is Expand, cancelling the generation of a
+  generator asset, or receiving from a channel asset, with ctx.
+*/
+func (a *Asset) ExpandContext (ctx context.Context) ([]*Asset, error) {
   if a.IsSingle() {
     return []*Asset { a }, nil
   }
@@ -564,7 +895,16 @@ func (a *Asset) Expand () ([]*Asset, error) {
   }
 
   if access & ASSET_MULTI_GENERATOR != 0 {
-    return a.GenerateAssetsArray()
+    return a.generateAssetsArray(ctx)
+  }
+
+  if access & ASSET_MULTI_CHAN != 0 {
+    var assets = make([]*Asset, 0)
+    err := a.ReceiveAssets(ctx, func (asset *Asset) error {
+      assets = append(assets, asset)
+      return nil
+    })
+    return assets, err
   }
 
   return nil, fmt.Errorf("Unsupported asset type mask 0x%X", a.TypeMask)
@@ -574,12 +914,23 @@ func (a *Asset) Expand () ([]*Asset, error) {
 /*
   Return an array of Assets by flattening this Asset. If this is
   a multi-asset, expand it, then recursively flatten each
-  expanded Asset, until only singular Assets remain.
+  expanded Asset, until only singular Assets remain. Generator
+  assets are cancelled with the context of the Asset's Spec, as in
+  FlattenContext.
 */
 func (a *Asset) Flatten () ([]*Asset, error) {
+  return a.FlattenContext(a.context())
+}
+
+
+/*
+  FlattenContext is Flatten, cancelling the generation of nested
+  generator assets with ctx.
+*/
+func (a *Asset) FlattenContext (ctx context.Context) ([]*Asset, error) {
   var err error
 
-  root_assets, err := a.Expand()
+  root_assets, err := a.ExpandContext(ctx)
   if err != nil {
     return nil, fmt.Errorf(
       `Cannot flatten, encountered error when expanding asset with url "%s": %w`,
@@ -595,7 +946,7 @@ func (a *Asset) Flatten () ([]*Asset, error) {
       continue
     }
 
-    assets, err := root_asset.Flatten()
+    assets, err := root_asset.FlattenContext(ctx)
     if err != nil {
       return nil, fmt.Errorf(
         `Could not flatten, encountered error when recursively flattening asset with URL "%s": %w"`,
@@ -644,7 +995,11 @@ func (a *Asset) ContentBytesGetReader () (io.Reader, error) {
     return nil, fmt.Errorf("Cannot get reader, asset does not have a reader-getter function defined")
   }
 
-  return a.content_bytes_get_reader_func(a)
+  reader, err := a.content_bytes_get_reader_func(a)
+  if err != nil || a.ContentEncoding == "" {
+    return reader, err
+  }
+  return DecodeContentReader(a.ContentEncoding, reader)
 }
 
 
@@ -661,7 +1016,11 @@ func (a *Asset) ContentBytesGetWriter () (io.Writer, error) {
     return nil, fmt.Errorf("Cannot get writer, asset does not have a writer-getter function defined")
   }
 
-  return a.content_bytes_get_writer_func(a)
+  writer, err := a.content_bytes_get_writer_func(a)
+  if err != nil || a.ContentEncoding == "" {
+    return writer, err
+  }
+  return EncodeContentWriter(a.ContentEncoding, writer)
 }
 
 
@@ -723,8 +1082,11 @@ func (a *Asset) GetContentBytes () ([]byte, error) {
 
   reader, err := a.ContentBytesGetReader()
   if err != nil { return nil, err }
+  if closer, ok := reader.(io.Closer); ok {
+    defer closer.Close()
+  }
 
-  bytes, err := io.ReadAll(reader)
+  bytes, err := readAllContent(reader)
   if err != nil { return nil, err }
 
   a.ContentBytes = bytes
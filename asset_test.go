@@ -11,6 +11,10 @@ import (
   "io"
   "time"
   "bytes"
+  "context"
+  "errors"
+  "sort"
+  "strings"
 )
 
 
@@ -166,7 +170,7 @@ func TestAssetExpandGenerator (t *testing.T) {
     var type_mask uint64 = ASSET_MULTI_GENERATOR
     var base_url         = test_url.JoinPath(strconv.FormatUint(type_mask, 2))
 
-    var generator_start = func (a *Asset) (func()(*Asset, error), error) {
+    var generator_start = func (_ context.Context, a *Asset) (func()(*Asset, error), error) {
       var asset_array = make([]*Asset, 3)
       for i := 0 ; i < 3 ; i++ {
         asset_array[i] = & Asset {
@@ -207,6 +211,149 @@ func TestAssetExpandGenerator (t *testing.T) {
 }
 
 
+func TestAssetExpandGeneratorCancel (t *testing.T) {
+  var cause = errors.New("test cancelled")
+
+  // A generator which never ends is stopped between assets
+  //
+  TestWrapTimeout(t, func () {
+    ctx, cancel := context.WithCancelCause(context.Background())
+
+    var generated = 0
+    var asset = & Asset { Url: & url.URL { Path: "/endless" } }
+    err := asset.SetAssetGenerator(func (_ context.Context, a *Asset) (func () (*Asset, error), error) {
+      return func () (*Asset, error) {
+        generated++
+        if generated == 5 {
+          cancel(cause)
+        }
+        return & Asset { Url: a.Url.JoinPath(strconv.Itoa(generated)) }, nil
+      }, nil
+    })
+    if err != nil { t.Fatal(err) }
+
+    if _, err := asset.FlattenContext(ctx); !errors.Is(err, cause) {
+      t.Errorf("Expected the cause of the cancellation, got %v", err)
+    }
+    if generated != 5 {
+      t.Errorf("Expected generation to stop after 5 assets, got %d", generated)
+    }
+    if err := asset.SetAssetArray(nil); err == nil {
+      t.Errorf("Expected an error setting the asset array of a generator")
+    }
+  })
+
+  // A generator which blocks is cancelled with its Spec's run
+  //
+  var root  = NewSpec("root", nil)
+  var child = root.AddSubspec(NewSpec("child", nil))
+  root.Props["quiet"] = true
+
+  if root.Context() != context.Background() {
+    t.Errorf("Expected the background context outside of a run")
+  }
+
+  var blocking = child.MakeAsset("blocking")
+  blocking.SetAssetGenerator(func (ctx context.Context, _ *Asset) (func () (*Asset, error), error) {
+    return func () (*Asset, error) {
+      <-ctx.Done()
+      return nil, context.Cause(ctx)
+    }, nil
+  })
+
+  child.EnqueueTaskFunc("flatten", func (s *Spec, tk *Task) error {
+    if s.Context() == context.Background() {
+      return fmt.Errorf("Expected the context of the child's run")
+    }
+    go root.Cancel()
+    _, err := blocking.Flatten()
+    return err
+  })
+
+  TestWrapTimeout(t, func () {
+    if err := root.Run(); !errors.Is(err, ErrCancelled) {
+      t.Errorf("Expected the run to be cancelled, got %v", err)
+    }
+  })
+}
+
+
+func TestAssetExpandChannel (t *testing.T) {
+  var base_url, _ = url.Parse("ib://testing/stream")
+
+  TestWrapTimeout(t, func () {
+    var ch    = make(chan *Asset)
+    var asset = & Asset { Url: base_url }
+    if err := asset.SetAssetChannel(ch); err != nil {
+      t.Fatal(err)
+    }
+    if !asset.IsMulti() || !asset.IsChannel() {
+      t.Fatalf("Expected a channel multi-asset, got type mask %O", asset.TypeMask)
+    }
+    if err := asset.SetAssetChannel(ch); err == nil {
+      t.Errorf("Expected an error setting the channel of a channel asset twice")
+    }
+
+    go func () {
+      defer close(ch)
+      ch <- & Asset { Url: base_url.JoinPath("0") }
+
+      var nested = & Asset { Url: base_url.JoinPath("nested") }
+      nested.SetAssetArray([]*Asset {
+        { Url: base_url.JoinPath("nested", "1") },
+        { Url: base_url.JoinPath("nested", "2") },
+      })
+      ch <- nested
+    }()
+
+    assets, err := asset.Flatten()
+    if err != nil { t.Fatal(err) }
+    if len(assets) != 3 {
+      t.Fatalf("Expected 3 assets from the channel, got %d", len(assets))
+    }
+
+    // The channel is only received from once
+    //
+    if assets, err := asset.Expand(); err != nil || len(assets) != 0 {
+      t.Errorf("Expected no assets expanding a closed channel asset, got %d (%v)", len(assets), err)
+    }
+  })
+
+  // Receiving stops once the context is cancelled, or at a nil
+  // asset
+  //
+  TestWrapTimeout(t, func () {
+    var cause = errors.New("test cancelled")
+    ctx, cancel := context.WithCancelCause(context.Background())
+
+    var ch    = make(chan *Asset)
+    var asset = & Asset { Url: base_url }
+    asset.SetAssetChannel(ch)
+
+    go func () {
+      ch <- & Asset { Url: base_url.JoinPath("0") }
+      cancel(cause)
+    }()
+
+    if _, err := asset.ExpandContext(ctx); !errors.Is(err, cause) {
+      t.Errorf("Expected the cause of the cancellation, got %v", err)
+    }
+
+    var nil_ch = make(chan *Asset, 1)
+    nil_ch <- nil
+    var nil_asset = & Asset { Url: base_url }
+    nil_asset.SetAssetChannel(nil_ch)
+    if _, err := nil_asset.Expand(); err == nil {
+      t.Errorf("Expected an error receiving a nil asset")
+    }
+
+    if err := (& Asset { Url: base_url }).ReceiveAssets(ctx, nil); err == nil {
+      t.Errorf("Expected an error receiving from a singular asset")
+    }
+  })
+}
+
+
 func TestAssetFlattenNestedMultiAssets (t *testing.T) {
   var spec *Spec = NewSpec("spec", nil)
 
@@ -648,3 +795,142 @@ func TestAssetContentData (t *testing.T) {
     t.Fatalf("Expected asset content data to be \"%s\", got \"%s\"", expect, got)
   }
 }
+
+
+func TestSpecMakeFileKeyAssetPreserveMetadata (t *testing.T) {
+  var source_dir = t.TempDir()
+  var mod_time   = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+  root  := NewSpec("root", nil)
+  child := root.AddSubspec(NewSpec("child", nil))
+  child.Props["source_dir"] = source_dir
+
+  var file_path = filepath.Join(source_dir, "run.sh")
+  if err := os.WriteFile(file_path, []byte("#!/bin/sh\n"), 0o750); err != nil {
+    t.Fatal(err)
+  }
+  if err := os.Chmod(file_path, 0o750); err != nil {
+    t.Fatal(err)
+  }
+  if err := os.Chtimes(file_path, mod_time, mod_time); err != nil {
+    t.Fatal(err)
+  }
+
+  asset, err := child.MakeFileKeyAsset("run.sh")
+  if err != nil { t.Fatal(err) }
+  if asset.FileMode != 0 || !asset.FileModTime.IsZero() {
+    t.Errorf("Expected file metadata not to be captured by default, got %v, %v", asset.FileMode, asset.FileModTime)
+  }
+
+  root.Props["preserve_metadata"] = true
+  asset, err = child.MakeFileKeyAsset("run.sh")
+  if err != nil { t.Fatal(err) }
+  if asset.FileMode != 0o750 || !asset.FileModTime.Equal(mod_time) {
+    t.Errorf("Expected the inherited preserve_metadata prop to capture file metadata, got %v, %v", asset.FileMode, asset.FileModTime)
+  }
+
+  root.Props["preserve_metadata"] = "yes"
+  if _, err := child.MakeFileKeyAsset("run.sh"); err == nil {
+    t.Errorf("Expected an error from a string preserve_metadata prop")
+  }
+}
+
+
+func TestSpecEmitFileGlob (t *testing.T) {
+  var source_dir = t.TempDir()
+
+  root := NewSpec("root", nil)
+  root.Props["quiet"]      = true
+  root.Props["source_dir"] = source_dir
+
+  for _, key := range []string {
+    "index.html", "about.html", "style.css",
+    "posts/a.html", "posts/2024/b.html", "posts/2024/b.md",
+    "posts/.ibignore",
+    "drafts/c.html",
+  } {
+    var content = "content"
+    if key == "posts/.ibignore" {
+      content = "2024/*.md\n"
+    }
+    if err := root.WriteFile(key, []byte(content), 0o644); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  var glob_keys = func (pattern string, key_prefix ...string) string {
+    asset, err := root.MakeFileGlobAsset(pattern, key_prefix...)
+    if err != nil {
+      t.Fatalf("Error globbing %s: %v", pattern, err)
+    } else if asset == nil {
+      return ""
+    }
+
+    assets, err := asset.Flatten()
+    if err != nil { t.Fatal(err) }
+
+    var keys []string
+    for _, asset := range assets {
+      keys = append(keys, strings.TrimLeft(asset.Url.Path, "/"))
+    }
+    sort.Strings(keys)
+    return strings.Join(keys, " ")
+  }
+
+  var cases = []struct {
+    pattern  string
+    prefix   []string
+    expect   string
+  } {
+    { "*.html",          nil,                  "about.html index.html" },
+    { "./*.css",         nil,                  "style.css" },
+    { "posts/**/*.html", nil,                  "posts/2024/b.html posts/a.html" },
+    { "posts/**",        nil,                  "posts/2024/b.html posts/a.html" },
+    { "**/*.html",       []string { "@emit" }, "@emit/about.html @emit/drafts/c.html @emit/index.html @emit/posts/2024/b.html @emit/posts/a.html" },
+    { "index.html",      nil,                  "index.html" },
+    { "[ai]*.html",      nil,                  "about.html index.html" },
+    { "missing/*.html",  nil,                  "" },
+    { "*.txt",           nil,                  "" },
+  }
+  for _, c := range cases {
+    if got := glob_keys(c.pattern, c.prefix...); got != c.expect {
+      t.Errorf("Expected %s to match %q, got %q", c.pattern, c.expect, got)
+    }
+  }
+
+  for _, pattern := range []string { "../*.html", "/etc/*" } {
+    if _, err := root.MakeFileGlobAsset(pattern); err == nil {
+      t.Errorf("Expected an error for the glob pattern %s outside of source_dir", pattern)
+    }
+  }
+
+  // Emitting matches outputs them to the parent spec as one
+  // multi-asset
+  //
+  var child = root.AddSubspec(NewSpec("child", nil))
+  child.Props["source_dir"] = source_dir
+
+  var emitted []*Asset
+  child.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    if err := s.EmitFileGlob("*.txt"); err != nil {
+      return err
+    }
+    return s.EmitFileGlob("posts/*.html", "@emit")
+  })
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    for asset := range s.Input {
+      emitted = append(emitted, asset)
+    }
+    return nil
+  })
+  TestWrapTimeoutError(t, root.Run)
+
+  if len(emitted) != 1 || !emitted[0].IsMulti() {
+    t.Fatalf("Expected one multi-asset, got %d assets", len(emitted))
+  }
+  assets, err := emitted[0].Flatten()
+  if err != nil { t.Fatal(err) }
+  if len(assets) != 1 || strings.TrimLeft(assets[0].Url.Path, "/") != "@emit/posts/a.html" {
+    t.Errorf("Expected the asset @emit/posts/a.html, got %d assets", len(assets))
+  }
+}
@@ -0,0 +1,432 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "gilchrist.tech/interbuilder/proto"
+
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/metadata"
+  "google.golang.org/grpc/status"
+
+  "context"
+  "crypto/subtle"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "strings"
+  "time"
+)
+
+
+/*
+  AssetExchangeServer implements the AssetExchange gRPC service of
+  proto/interbuilder.proto over an ApiServer, whose stored
+  pipelines its calls run, and whose runs they are listed with.
+  Ingest runs the pipeline named by the "pipeline" metadata of the
+  call, or an empty pipeline, with the streamed assets emitted by
+  its root spec, and responds once the run ends. Emit runs the
+  pipeline of the request, and streams the assets reaching its
+  root spec which match each of the request's filters, until the
+  run ends.
+
+  If the ApiServer has a Token, calls must have it as a bearer
+  token in their "authorization" metadata, as AssetExchangeToken
+  sends it.
+*/
+type AssetExchangeServer struct {
+  interbuilderpb.UnimplementedAssetExchangeServer
+
+  Api  *ApiServer
+}
+
+
+/*
+  AssetExchangeToken returns a CallOption sending a bearer token
+  in the "authorization" metadata of AssetExchange calls.
+*/
+func AssetExchangeToken (token string) grpc.CallOption {
+  return grpc.PerRPCCredentials(assetExchangeToken(token))
+}
+
+
+type assetExchangeToken string
+
+func (token assetExchangeToken) GetRequestMetadata (ctx context.Context, uri ...string) (map[string]string, error) {
+  return map[string]string { "authorization": "Bearer " + string(token) }, nil
+}
+
+func (token assetExchangeToken) RequireTransportSecurity () bool {
+  return false
+}
+
+
+/*
+  authorize returns an Unauthenticated error if the ApiServer has
+  a Token which the call's metadata does not.
+*/
+func (es *AssetExchangeServer) authorize (ctx context.Context) error {
+  if es.Api.Token == "" {
+    return nil
+  }
+
+  var authorization string
+  if md, ok := metadata.FromIncomingContext(ctx); ok {
+    if values := md.Get("authorization"); len(values) > 0 {
+      authorization = values[0]
+    }
+  }
+
+  var expect = "Bearer " + es.Api.Token
+  if subtle.ConstantTimeCompare([]byte(authorization), []byte(expect)) != 1 {
+    return status.Error(codes.Unauthenticated, "Unauthorized")
+  }
+  return nil
+}
+
+
+/*
+  startRun starts a run of a stored pipeline, or of an empty
+  pipeline if its name is empty, with gRPC status errors.
+*/
+func (es *AssetExchangeServer) startRun (pipeline string, setup func (*ApiRun) error) (*ApiRun, error) {
+  var config = []byte("{}")
+  if pipeline != "" {
+    var found bool
+    if config, found = es.Api.getPipelineConfig(pipeline); !found {
+      return nil, status.Errorf(codes.NotFound, "No such pipeline: %s", pipeline)
+    }
+  }
+
+  run, status_code, err := es.Api.newRun(pipeline, config, setup)
+  if err != nil {
+    if status_code == http.StatusBadRequest {
+      return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    return nil, status.Error(codes.Internal, err.Error())
+  }
+  return run, nil
+}
+
+
+/*
+  waitRun waits for a run to end, cancelling it if the call ends
+  first, and returns an Aborted error if it failed.
+*/
+func (es *AssetExchangeServer) waitRun (ctx context.Context, run *ApiRun) error {
+  select {
+  case <-run.done:
+  case <-ctx.Done():
+    run.cancel()
+    <-run.done
+    return status.FromContextError(ctx.Err()).Err()
+  }
+
+  if run_status := run.Status(); run_status.Error != "" {
+    return status.Errorf(codes.Aborted, "Run %s failed: %s", run.Id, run_status.Error)
+  }
+  return nil
+}
+
+
+func (es *AssetExchangeServer) Ingest (stream interbuilderpb.AssetExchange_IngestServer) error {
+  var ctx = stream.Context()
+  if err := es.authorize(ctx); err != nil {
+    return err
+  }
+
+  var pipeline string
+  if md, ok := metadata.FromIncomingContext(ctx); ok {
+    if values := md.Get("pipeline"); len(values) > 0 {
+      pipeline = values[0]
+    }
+  }
+
+  // Messages are received here, rather than in the run's task, so
+  // that a run which ends first does not wait on the client
+  //
+  var received = make(chan *interbuilderpb.Asset)
+  var recv_err = make(chan error, 1)
+  var count    uint64
+
+  run, err := es.startRun(pipeline, func (run *ApiRun) error {
+    return run.root.EnqueueTaskFunc("grpc-ingest", func (s *Spec, tk *Task) error {
+      for {
+        select {
+        case <-tk.Context().Done():
+          return nil
+        case err := <-recv_err:
+          return err
+        case message, ok := <-received:
+          if !ok {
+            return nil
+          }
+          asset, err := AssetFromProto(message)
+          if err != nil {
+            return err
+          }
+          if err := tk.EmitAsset(s.AnnexAsset(asset)); err != nil {
+            return err
+          }
+          count++
+        }
+      }
+    })
+  })
+  if err != nil {
+    return err
+  }
+
+  go func () {
+    for {
+      message, err := stream.Recv()
+      if err == io.EOF {
+        close(received)
+        return
+      } else if err != nil {
+        recv_err <- err
+        return
+      }
+      select {
+      case received <- message:
+      case <-run.done:
+        return
+      }
+    }
+  }()
+
+  if err := es.waitRun(ctx, run); err != nil {
+    return err
+  }
+  return stream.SendAndClose(& interbuilderpb.IngestSummary {
+    Assets: count,
+    Run:    run.Id,
+  })
+}
+
+
+func (es *AssetExchangeServer) Emit (request *interbuilderpb.EmitRequest, stream interbuilderpb.AssetExchange_EmitServer) error {
+  var ctx = stream.Context()
+  if err := es.authorize(ctx); err != nil {
+    return err
+  }
+
+  run, err := es.startRun(request.Pipeline, func (run *ApiRun) error {
+    run.on_output = func (output_path string, asset *Asset) error {
+      if !assetExchangeFiltersMatch(request.Filters, output_path, asset) {
+        return nil
+      }
+      message, err := AssetToProto(asset)
+      if err != nil {
+        return err
+      }
+      return stream.Send(message)
+    }
+    return nil
+  })
+  if err != nil {
+    return err
+  }
+
+  return es.waitRun(ctx, run)
+}
+
+
+/*
+  assetExchangeFiltersMatch returns whether an asset matches each
+  filter of an EmitRequest, as the CLI's "filter:" output
+  arguments match, by its MIME type, and the prefix and suffix of
+  its output path.
+*/
+func assetExchangeFiltersMatch (filters []*interbuilderpb.Filter, output_path string, asset *Asset) bool {
+  var path = strings.TrimLeft(output_path, "/")
+
+  for _, filter := range filters {
+    if filter.Mimetype != "" && strings.HasPrefix(asset.Mimetype, filter.Mimetype) == filter.Invert {
+      return false
+    }
+    if filter.Suffix != "" && strings.HasSuffix(path, filter.Suffix) == filter.Invert {
+      return false
+    }
+    if filter.Prefix != "" && strings.HasPrefix(path, strings.TrimPrefix(filter.Prefix, "/")) == filter.Invert {
+      return false
+    }
+  }
+  return true
+}
+
+
+/*
+  IngestAssets streams assets to the Ingest call of an
+  AssetExchange client, which runs the named pipeline with them,
+  and returns its summary once the run ends.
+*/
+func IngestAssets (ctx context.Context, client interbuilderpb.AssetExchangeClient, pipeline string, assets []*Asset, opts ...grpc.CallOption) (*interbuilderpb.IngestSummary, error) {
+  if pipeline != "" {
+    ctx = metadata.AppendToOutgoingContext(ctx, "pipeline", pipeline)
+  }
+
+  stream, err := client.Ingest(ctx, opts...)
+  if err != nil {
+    return nil, err
+  }
+
+  for _, asset := range assets {
+    message, err := AssetToProto(asset)
+    if err != nil {
+      return nil, err
+    }
+    if err := stream.Send(message); err != nil {
+      // The error of the call is received when it is closed
+      //
+      if err == io.EOF {
+        break
+      }
+      return nil, err
+    }
+  }
+  return stream.CloseAndRecv()
+}
+
+
+/*
+  EmitAssets calls the Emit call of an AssetExchange client, and
+  calls receive with each asset it streams, until the run of its
+  pipeline ends.
+*/
+func EmitAssets (ctx context.Context, client interbuilderpb.AssetExchangeClient, request *interbuilderpb.EmitRequest, receive func (*Asset) error, opts ...grpc.CallOption) error {
+  ctx, cancel := context.WithCancel(ctx)
+  defer cancel()
+
+  stream, err := client.Emit(ctx, request, opts...)
+  if err != nil {
+    return err
+  }
+
+  for {
+    message, err := stream.Recv()
+    if err == io.EOF {
+      return nil
+    } else if err != nil {
+      return err
+    }
+
+    asset, err := AssetFromProto(message)
+    if err != nil {
+      return err
+    }
+    if err := receive(asset); err != nil {
+      return err
+    }
+  }
+}
+
+
+/*
+  AssetToProto encodes a single asset as an Asset message, with
+  its content and history.
+*/
+func AssetToProto (a *Asset) (*interbuilderpb.Asset, error) {
+  if a.Url == nil {
+    return nil, fmt.Errorf("Cannot encode an asset without a URL")
+  }
+
+  content, err := a.GetContentBytes()
+  if err != nil {
+    return nil, fmt.Errorf("Cannot encode asset %s: %w", a.Url, err)
+  }
+
+  var message = & interbuilderpb.Asset {
+    Url:      a.Url.String(),
+    Mimetype: a.Mimetype,
+    Content:  content,
+  }
+
+  if a.History == nil {
+    return message, nil
+  }
+
+  // The history graph is flattened as in the JSON encoding, with
+  // the asset's own entry first, followed by its ancestors
+  //
+  var indices = map[*HistoryEntry]uint32 { a.History: 0 }
+  var entries = []*HistoryEntry { a.History }
+
+  a.History.WalkParents(func (entry *HistoryEntry) error {
+    indices[entry] = uint32(len(entries))
+    entries = append(entries, entry)
+    return nil
+  })
+
+  for _, entry := range entries {
+    var encoded = & interbuilderpb.HistoryEntry {}
+    if entry.Url != nil {
+      encoded.Url = entry.Url.String()
+    }
+    if !entry.Time.IsZero() {
+      encoded.TimeUnixNano = entry.Time.UnixNano()
+    }
+    for _, parent := range entry.Parents {
+      if parent_index, found := indices[parent]; found {
+        encoded.Parents = append(encoded.Parents, parent_index)
+      }
+    }
+    message.History = append(message.History, encoded)
+  }
+
+  return message, nil
+}
+
+
+/*
+  AssetFromProto decodes an Asset message, rebuilding its history
+  graph.
+*/
+func AssetFromProto (message *interbuilderpb.Asset) (*Asset, error) {
+  if message.Url == "" {
+    return nil, fmt.Errorf("Cannot decode an asset message without a URL")
+  }
+
+  asset_url, err := url.Parse(message.Url)
+  if err != nil {
+    return nil, fmt.Errorf("Cannot parse the URL of an asset message: %w", err)
+  }
+
+  var asset = & Asset {
+    Url:      asset_url,
+    Mimetype: message.Mimetype,
+  }
+  if err := asset.SetContentBytes(message.Content); err != nil {
+    return nil, err
+  }
+
+  if len(message.History) == 0 {
+    return asset, nil
+  }
+
+  var entries = make([]*HistoryEntry, len(message.History))
+  for i, encoded := range message.History {
+    entries[i] = & HistoryEntry {}
+    if encoded.Url != "" {
+      if entries[i].Url, err = url.Parse(encoded.Url); err != nil {
+        return nil, fmt.Errorf("Cannot parse the URL of history entry %d of asset %s: %w", i, message.Url, err)
+      }
+    }
+    if encoded.TimeUnixNano != 0 {
+      entries[i].Time = time.Unix(0, encoded.TimeUnixNano)
+    }
+  }
+
+  for i, encoded := range message.History {
+    for _, parent_index := range encoded.Parents {
+      if int(parent_index) >= len(entries) || int(parent_index) == i {
+        return nil, fmt.Errorf("History entry %d of asset %s has an invalid parent index %d", i, message.Url, parent_index)
+      }
+      entries[i].Parents = append(entries[i].Parents, entries[parent_index])
+    }
+  }
+
+  asset.History = entries[0]
+  return asset, nil
+}
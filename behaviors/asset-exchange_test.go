@@ -0,0 +1,255 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "gilchrist.tech/interbuilder/proto"
+
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/credentials/insecure"
+  "google.golang.org/grpc/status"
+  "google.golang.org/grpc/test/bufconn"
+
+  "context"
+  "encoding/json"
+  "net"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "sort"
+  "strings"
+  "testing"
+  "time"
+)
+
+
+func TestAssetProtoRoundTrip (t *testing.T) {
+  var parse = func (u string) *url.URL {
+    parsed, err := url.Parse(u)
+    if err != nil { t.Fatal(err) }
+    return parsed
+  }
+
+  // The history is a diamond, whose source is shared by the
+  // render and the layout
+  //
+  var now    = time.Now()
+  var source = & HistoryEntry { Url: parse("ib://site/post.md") }
+  var render = & HistoryEntry { Url: parse("ib://site/render"), Parents: []*HistoryEntry { source } }
+  var layout = & HistoryEntry { Url: parse("ib://site/layout"), Parents: []*HistoryEntry { source } }
+
+  var asset = & Asset {
+    Url:      parse("ib://site/post.html"),
+    Mimetype: "text/html",
+    History:  & HistoryEntry {
+      Url:     parse("ib://site/post.html"),
+      Time:    now,
+      Parents: []*HistoryEntry { render, layout },
+    },
+  }
+  asset.SetContentBytes([]byte { 0, 1, 2, 0xff })
+
+  message, err := AssetToProto(asset)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(message.History) != 4 {
+    t.Fatalf("Expected 4 history entries, got %d", len(message.History))
+  }
+
+  decoded, err := AssetFromProto(message)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if decoded.Url.String() != asset.Url.String() || decoded.Mimetype != "text/html" {
+    t.Errorf("Expected the URL and MIME type of the asset, got %s %s", decoded.Url, decoded.Mimetype)
+  }
+  if content, _ := decoded.GetContentBytes(); string(content) != "\x00\x01\x02\xff" {
+    t.Errorf("Expected the binary content of the asset, got %q", content)
+  }
+
+  var history = decoded.History
+  if history == nil || !history.Time.Equal(now) || len(history.Parents) != 2 {
+    t.Fatalf("Expected the asset's own history entry, with two parents, got %+v", history)
+  }
+  var decoded_render, decoded_layout = history.Parents[0], history.Parents[1]
+  if decoded_render.Url.String() != "ib://site/render" || decoded_layout.Url.String() != "ib://site/layout" {
+    t.Errorf("Expected the render and layout parents, got %s and %s", decoded_render.Url, decoded_layout.Url)
+  }
+  if len(decoded_render.Parents) != 1 || len(decoded_layout.Parents) != 1 || decoded_render.Parents[0] != decoded_layout.Parents[0] {
+    t.Errorf("Expected the render and layout to share their source entry")
+  }
+
+  // Invalid messages are errors
+  //
+  message.History[1].Parents = []uint32 { 9 }
+  if _, err := AssetFromProto(message); err == nil {
+    t.Error("Expected an out of range parent index to be an error")
+  }
+  if _, err := AssetFromProto(& interbuilderpb.Asset {}); err == nil {
+    t.Error("Expected an asset message without a URL to be an error")
+  }
+}
+
+
+func TestAssetExchange (t *testing.T) {
+  // Runs emit the pages of their "pages" prop, as in
+  // TestApiServer
+  //
+  var api_server = & ApiServer {
+    Token: "secret",
+    MakeRoot: func (root_consume TaskFunc) (*Spec, error) {
+      root := NewSpec("root", nil)
+      root.Props["quiet"] = true
+
+      root.AddSpecBuilder(func (s *Spec) error {
+        return s.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+          pages, _ := s.Props["pages"].(map[string]any)
+          for key, content := range pages {
+            asset := s.MakeAsset(key)
+            asset.Mimetype = "text/html"
+            asset.SetContentBytes([]byte(content.(string)))
+            if err := tk.EmitAsset(asset); err != nil {
+              return err
+            }
+          }
+          return nil
+        })
+      })
+
+      root.DeferTaskFunc("root-consume", root_consume)
+      return root, nil
+    },
+  }
+  defer api_server.Close()
+
+  var request = func (method, path, body string) (int, string) {
+    t.Helper()
+    req := httptest.NewRequest(method, path, strings.NewReader(body))
+    req.Header.Set("Authorization", "Bearer secret")
+    recorder := httptest.NewRecorder()
+    api_server.ServeHTTP(recorder, req)
+    return recorder.Code, recorder.Body.String()
+  }
+
+  if code, body := request("PUT", "/pipelines/site", `{ "pages": { "index.html": "home", "posts/first.html": "first", "about.html": "about" } }`); code != http.StatusNoContent {
+    t.Fatalf("Expected the pipeline to be stored, got %d: %s", code, body)
+  }
+
+  // Serve the AssetExchange service in memory
+  //
+  var listener    = bufconn.Listen(1024 * 1024)
+  var grpc_server = grpc.NewServer()
+  interbuilderpb.RegisterAssetExchangeServer(grpc_server, & AssetExchangeServer { Api: api_server })
+  go grpc_server.Serve(listener)
+  defer grpc_server.Stop()
+
+  conn, err := grpc.NewClient("passthrough:///bufconn",
+    grpc.WithContextDialer(func (ctx context.Context, addr string) (net.Conn, error) {
+      return listener.DialContext(ctx)
+    }),
+    grpc.WithTransportCredentials(insecure.NewCredentials()),
+  )
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer conn.Close()
+
+  var client = interbuilderpb.NewAssetExchangeClient(conn)
+  var token  = AssetExchangeToken("secret")
+
+  // emit collects the assets of an Emit call by URL path
+  //
+  var emit = func (emit_request *interbuilderpb.EmitRequest, opts ...grpc.CallOption) (map[string]*Asset, error) {
+    var received = map[string]*Asset {}
+    var err error
+    TestWrapTimeout(t, func () {
+      err = EmitAssets(context.Background(), client, emit_request, func (asset *Asset) error {
+        received[asset.Url.Path] = asset
+        return nil
+      }, opts...)
+    })
+    return received, err
+  }
+
+  var test_cases = []struct {
+    Filters   []*interbuilderpb.Filter
+    Expected  []string
+  }{
+    { Expected: []string { "/about.html", "/index.html", "/posts/first.html" } },
+    { Filters:  []*interbuilderpb.Filter { { Prefix: "/posts/" } }, Expected: []string { "/posts/first.html" } },
+    { Filters:  []*interbuilderpb.Filter { { Prefix: "posts/", Invert: true }, { Suffix: "about.html", Invert: true } },
+      Expected: []string { "/index.html" } },
+    { Filters:  []*interbuilderpb.Filter { { Mimetype: "text/css" } }, Expected: []string {} },
+  }
+
+  for test_case_i, test_case := range test_cases {
+    received, err := emit(& interbuilderpb.EmitRequest { Pipeline: "site", Filters: test_case.Filters }, token)
+    if err != nil {
+      t.Errorf("Test case %d: unexpected error: %v", test_case_i, err)
+      continue
+    }
+
+    var paths = []string {}
+    for path := range received {
+      paths = append(paths, path)
+    }
+    sort.Strings(paths)
+    if strings.Join(paths, " ") != strings.Join(test_case.Expected, " ") {
+      t.Errorf("Test case %d: expected assets %v, got %v", test_case_i, test_case.Expected, paths)
+    }
+  }
+
+  // Emitted assets round-trip through Ingest, whose run collects
+  // them at their output paths
+  //
+  emitted, err := emit(& interbuilderpb.EmitRequest { Pipeline: "site" }, token)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if content, _ := emitted["/posts/first.html"].GetContentBytes(); string(content) != "first" {
+    t.Errorf("Expected the content of the emitted asset, got %q", content)
+  }
+
+  var assets = make([]*Asset, 0, len(emitted))
+  for _, asset := range emitted {
+    assets = append(assets, asset)
+  }
+
+  var summary *interbuilderpb.IngestSummary
+  TestWrapTimeout(t, func () {
+    summary, err = IngestAssets(context.Background(), client, "", assets, token)
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  if summary.Assets != 3 || summary.Run == "" {
+    t.Fatalf("Expected a summary of 3 ingested assets and their run, got %+v", summary)
+  }
+
+  code, body := request("GET", "/runs/" + summary.Run + "/assets", "")
+  var output_paths []string
+  if err := json.Unmarshal([]byte(body), &output_paths); err != nil || code != http.StatusOK {
+    t.Fatalf("Expected the output paths of the ingest run, got %d: %s", code, body)
+  }
+  sort.Strings(output_paths)
+  if strings.Join(output_paths, " ") != "/about.html /index.html /posts/first.html" {
+    t.Errorf("Expected the ingested assets at their output paths, got %v", output_paths)
+  }
+  if code, body := request("GET", "/runs/" + summary.Run + "/assets/posts/first.html", ""); code != http.StatusOK || body != "first" {
+    t.Errorf("Expected the content of an ingested asset, got %d: %q", code, body)
+  }
+
+  // Calls without the token, or of unknown pipelines, fail
+  //
+  if _, err := emit(& interbuilderpb.EmitRequest { Pipeline: "site" }); status.Code(err) != codes.Unauthenticated {
+    t.Errorf("Expected a call without the token to be unauthenticated, got %v", err)
+  }
+  if _, err := emit(& interbuilderpb.EmitRequest { Pipeline: "missing" }, token); status.Code(err) != codes.NotFound {
+    t.Errorf("Expected a call of an unknown pipeline to be not found, got %v", err)
+  }
+  if _, err := IngestAssets(context.Background(), client, "missing", assets, token); status.Code(err) != codes.NotFound {
+    t.Errorf("Expected an ingest into an unknown pipeline to be not found, got %v", err)
+  }
+}
@@ -0,0 +1,316 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "golang.org/x/net/html"
+  "golang.org/x/net/html/atom"
+
+  "bytes"
+  "fmt"
+  "net/url"
+  "path"
+  "strings"
+)
+
+
+/*
+  TaskResolverCanonicalUrls resolves the "canonical-urls" task,
+  which sets the canonical <link>, og:url <meta>, and optionally
+  the <base> of HTML documents from the spec's "site_url" prop.
+  It runs after Markdown is rendered, and before the root spec's
+  "root-consume" task.
+*/
+var TaskResolverCanonicalUrls = TaskResolver {
+  Id:   "canonical-urls",
+  Name: "canonical-urls",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "canonical-urls", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_MUTATE,
+    Func:   TaskCanonicalUrls,
+    After:  []string { "render-markdown", "apply-layouts" },
+    Before: []string { "emit-manifest", "precompress-assets", "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskCanonicalUrls defers the "canonical-urls" task if the
+  spec has a "site_url" prop. The prop is not inherited, so that
+  a site merged from several subspecs is handled once, by the
+  spec which merges them.
+*/
+func BuildTaskCanonicalUrls (s *Spec) error {
+  if s.GetTaskResolverById("canonical-urls") == nil {
+    canonical := TaskResolverCanonicalUrls
+    s.AddTaskResolver(&canonical)
+  }
+
+  if _, found := s.Props["site_url"]; !found {
+    return nil
+  }
+
+  if _, err := siteUrl(s); err != nil {
+    return err
+  }
+
+  task, err := s.GetTask("canonical-urls", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the canonical-urls task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  siteUrl reads the "site_url" prop, the absolute URL a site is
+  served from.
+*/
+func siteUrl (s *Spec) (*url.URL, error) {
+  site_url_str, ok := s.Props["site_url"].(string)
+  if !ok {
+    return nil, fmt.Errorf("Prop \"site_url\" in spec %s is expected to be a string, got %T", s.Name, s.Props["site_url"])
+  }
+
+  site_url, err := url.Parse(site_url_str)
+  if err != nil {
+    return nil, fmt.Errorf("Cannot parse site_url in spec %s: %w", s.Name, err)
+  } else if !site_url.IsAbs() || site_url.Host == "" {
+    return nil, fmt.Errorf("Prop \"site_url\" in spec %s is expected to be an absolute URL, got %s", s.Name, site_url_str)
+  }
+
+  return site_url, nil
+}
+
+
+/*
+  finalOutputPath returns the path an asset is output at from the
+  root spec, by applying the PathTransformations of a spec and each
+  of its ancestors, as they are applied when the asset is emitted
+  from each of them in turn. This keeps paths consistent when
+  subspecs are merged under different prefixes.
+*/
+func finalOutputPath (s *Spec, a *Asset) string {
+  return finalTransformPath(s, assetOutputPath(a), a.Mimetype)
+}
+
+
+/*
+  finalTransformPath is finalOutputPath for an output path and
+  MIME type, such as of an asset which is yet to be made.
+*/
+func finalTransformPath (s *Spec, asset_path, mimetype string) string {
+  asset_path = strings.TrimLeft(asset_path, "/")
+  for spec := s; spec != nil; spec = spec.Parent {
+    asset_path = strings.TrimLeft(specTransformPath(spec, asset_path, mimetype), "/")
+  }
+  return "/" + asset_path
+}
+
+
+func htmlAttr (node *html.Node, key string) (string, bool) {
+  for _, attr := range node.Attr {
+    if attr.Key == key {
+      return attr.Val, true
+    }
+  }
+  return "", false
+}
+
+
+/*
+  htmlSetAttr sets an attribute of an element, adding it if it is
+  missing.
+*/
+func htmlSetAttr (node *html.Node, key, value string) {
+  for i := range node.Attr {
+    if node.Attr[i].Key == key {
+      node.Attr[i].Val = value
+      return
+    }
+  }
+  node.Attr = append(node.Attr, html.Attribute { Key: key, Val: value })
+}
+
+
+/*
+  htmlFindElements returns the elements of a document for which
+  match returns true, in document order.
+*/
+func htmlFindElements (node *html.Node, match func (*html.Node) bool) []*html.Node {
+  var found []*html.Node
+  if node.Type == html.ElementNode && match(node) {
+    found = append(found, node)
+  }
+  for child := node.FirstChild; child != nil; child = child.NextSibling {
+    found = append(found, htmlFindElements(child, match)...)
+  }
+  return found
+}
+
+
+func isCanonicalLink (node *html.Node) bool {
+  if node.DataAtom != atom.Link {
+    return false
+  }
+  rel, _ := htmlAttr(node, "rel")
+  for _, token := range strings.Fields(rel) {
+    if strings.EqualFold(token, "canonical") {
+      return true
+    }
+  }
+  return false
+}
+
+
+func isOgUrlMeta (node *html.Node) bool {
+  if node.DataAtom != atom.Meta {
+    return false
+  }
+  property, _ := htmlAttr(node, "property")
+  return property == "og:url"
+}
+
+
+/*
+  HtmlNodeSetCanonicalUrl sets the href of a document's canonical
+  <link>, and the content of its og:url <meta>, to a URL, adding
+  them to the <head> if they are missing. If base_href is not
+  empty, the <base> element's href is also set, and a <base> is
+  inserted at the start of the <head> if there is none. The
+  document is mutated in-place, and true is returned if it was
+  modified.
+*/
+func HtmlNodeSetCanonicalUrl (doc *html.Node, canonical_url, base_href string) bool {
+  var heads = htmlFindElements(doc, func (node *html.Node) bool { return node.DataAtom == atom.Head })
+  if len(heads) == 0 {
+    return false
+  }
+  var head     = heads[0]
+  var modified bool
+
+  // setOrAppend sets an attribute of the elements matching a
+  // function, or appends an element to the head if there are none
+  //
+  var setOrAppend = func (match func (*html.Node) bool, key, value string, element *html.Node) {
+    var elements = htmlFindElements(doc, match)
+    if len(elements) == 0 {
+      head.AppendChild(element)
+      modified = true
+      return
+    }
+    for _, element := range elements {
+      if current, _ := htmlAttr(element, key); current != value {
+        htmlSetAttr(element, key, value)
+        modified = true
+      }
+    }
+  }
+
+  setOrAppend(isCanonicalLink, "href", canonical_url, & html.Node {
+    Type: html.ElementNode, Data: "link", DataAtom: atom.Link,
+    Attr: []html.Attribute { { Key: "rel", Val: "canonical" }, { Key: "href", Val: canonical_url } },
+  })
+
+  setOrAppend(isOgUrlMeta, "content", canonical_url, & html.Node {
+    Type: html.ElementNode, Data: "meta", DataAtom: atom.Meta,
+    Attr: []html.Attribute { { Key: "property", Val: "og:url" }, { Key: "content", Val: canonical_url } },
+  })
+
+  if base_href == "" {
+    return modified
+  }
+
+  var bases = htmlFindElements(doc, func (node *html.Node) bool { return node.DataAtom == atom.Base })
+  if len(bases) == 0 {
+    head.InsertBefore(& html.Node {
+      Type: html.ElementNode, Data: "base", DataAtom: atom.Base,
+      Attr: []html.Attribute { { Key: "href", Val: base_href } },
+    }, head.FirstChild)
+    return true
+  }
+
+  // Only the first <base> element's href is used by browsers
+  //
+  if current, _ := htmlAttr(bases[0], "href"); current != base_href {
+    htmlSetAttr(bases[0], "href", base_href)
+    modified = true
+  }
+  return modified
+}
+
+
+/*
+  TaskCanonicalUrls pools the spec's input assets, and sets the
+  canonical URL of each HTML asset to the "site_url" prop joined
+  to the path it is finally output at, after the path
+  transformations of this spec and its ancestors. Index documents
+  are addressed by their directory. It reads the following props:
+
+    - site_url:  The absolute URL the site is served from, such as
+      "https://example.com/". Not inherited.
+    - site_base: If true, also set the <base> href of each document
+      to the URL of its directory.
+*/
+func TaskCanonicalUrls (s *Spec, tk *Task) error {
+  site_url, err := siteUrl(s)
+  if err != nil { return err }
+
+  set_base, ok, found := s.GetPropBool("site_base")
+  if found && !ok {
+    return fmt.Errorf("Prop \"site_base\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["site_base"])
+  }
+
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets to set canonical URLs: %w", err)
+  }
+
+  var assets []*Asset
+  for _, input := range tk.Assets {
+    flattened, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+    assets = append(assets, flattened...)
+  }
+
+  for _, asset := range assets {
+    if !isHtmlAsset(asset) {
+      continue
+    }
+
+    var output_path   = finalOutputPath(s, asset)
+    var canonical_url = assetOutputUrl(site_url, output_path)
+    var base_href     string
+    if set_base {
+      base_href = assetOutputUrl(site_url, strings.TrimSuffix(path.Dir(output_path), "/") + "/")
+    }
+
+    content, err := asset.GetContentBytes()
+    if err != nil { return err }
+
+    doc, err := html.Parse(bytes.NewReader(content))
+    if err != nil {
+      return fmt.Errorf("Cannot parse HTML asset %s: %w", asset.Url, err)
+    }
+
+    if !HtmlNodeSetCanonicalUrl(doc, canonical_url, base_href) {
+      continue
+    }
+
+    var writer bytes.Buffer
+    if err := html.Render(&writer, doc); err != nil {
+      return err
+    }
+
+    asset.ClearContentDataCache()
+    if err := asset.SetContentBytes(writer.Bytes()); err != nil {
+      return err
+    }
+  }
+
+  return tk.EmitAssets(assets)
+}
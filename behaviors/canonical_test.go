@@ -0,0 +1,156 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "strings"
+  "testing"
+)
+
+
+func TestTaskCanonicalUrls (t *testing.T) {
+  var documents = map[string]string {
+    "index.html":    `<html><head><title>Home</title></head><body></body></html>`,
+    "post/a.html":   `<html><head><base href="/"><link rel="canonical" href="/old"><meta property="og:url" content="/old"></head></html>`,
+    "style.css":     `body { margin: 0 }`,
+  }
+
+  var test_cases = []struct {
+    name          string
+    merge_props   map[string]any
+    blog_props    map[string]any
+    expect        map[string][]string
+  } {
+    {
+      // The merging spec sees the prefixes of each subspec
+      //
+      name:        "merged site",
+      merge_props: map[string]any { "site_url": "https://example.com/site/" },
+      expect: map[string][]string {
+        "blog/index.html": {
+          `<link rel="canonical" href="https://example.com/site/blog/"/>`,
+          `<meta property="og:url" content="https://example.com/site/blog/"/>`,
+        },
+        "docs/post/a.html": {
+          `<base href="/"/>`,
+          `<link rel="canonical" href="https://example.com/site/docs/post/a.html"/>`,
+          `<meta property="og:url" content="https://example.com/site/docs/post/a.html"/>`,
+        },
+      },
+    },
+    {
+      // A subspec applies its own and its parent's prefixes
+      //
+      name:       "subspec with base",
+      blog_props: map[string]any { "site_url": "https://example.com", "site_base": true },
+      expect: map[string][]string {
+        "blog/index.html": {
+          `<head><base href="https://example.com/blog/"/><title>`,
+          `<link rel="canonical" href="https://example.com/blog/"/>`,
+        },
+        "blog/post/a.html": {
+          `<base href="https://example.com/blog/post/"/>`,
+          `<link rel="canonical" href="https://example.com/blog/post/a.html"/>`,
+        },
+        "docs/index.html": {
+          `<head><title>Home</title></head>`,
+        },
+      },
+    },
+  }
+
+  for _, test_case := range test_cases {
+    t.Run(test_case.name, func (t *testing.T) {
+      root  := NewSpec("root", nil)
+      merge := root.AddSubspec(NewSpec("merge", nil))
+      blog  := merge.AddSubspec(NewSpec("blog", nil))
+      docs  := merge.AddSubspec(NewSpec("docs", nil))
+
+      root.Props["quiet"] = true
+      for key, value := range test_case.merge_props {
+        merge.Props[key] = value
+      }
+      for key, value := range test_case.blog_props {
+        blog.Props[key] = value
+      }
+
+      var err error
+      if blog.PathTransformations, err = PathTransformationsFromAny("s`^/?`/blog/`"); err != nil {
+        t.Fatal(err)
+      }
+      if docs.PathTransformations, err = PathTransformationsFromAny("s`^/?`/docs/`"); err != nil {
+        t.Fatal(err)
+      }
+
+      for _, spec := range []*Spec { blog, docs } {
+        spec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+          for key, content := range documents {
+            asset := s.MakeAsset(key)
+            asset.SetContentBytes([]byte(content))
+            if err := tk.EmitAsset(asset); err != nil {
+              return err
+            }
+          }
+          return nil
+        })
+      }
+
+      var received = make(map[string]*Asset)
+
+      root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+        if err := tk.PoolSpecInputAssets(); err != nil {
+          return err
+        }
+        for _, asset := range tk.Assets {
+          assets, err := asset.Flatten()
+          if err != nil { return err }
+          for _, asset := range assets {
+            asset_path := strings.TrimLeft(asset.Url.Path, "/")
+            received[strings.TrimPrefix(asset_path, "@emit/")] = asset
+          }
+        }
+        return nil
+      })
+
+      root.AddSpecBuilder(BuildTaskCanonicalUrls)
+      for _, spec := range []*Spec { merge, blog, docs } {
+        if err := spec.Build(); err != nil {
+          t.Fatal(err)
+        }
+      }
+
+      TestWrapTimeoutError(t, root.Run)
+
+      if got, expect := len(received), len(documents) * 2; got != expect {
+        t.Errorf("Expected %d assets, got %d: %v", expect, got, received)
+      }
+
+      for key, elements := range test_case.expect {
+        asset, found := received[key]
+        if !found {
+          t.Errorf("Expected an asset at %s, got %v", key, received)
+          continue
+        }
+
+        content, err := asset.GetContentBytes()
+        if err != nil { t.Fatal(err) }
+
+        for _, element := range elements {
+          if !strings.Contains(string(content), element) {
+            t.Errorf("Expected %s to contain:\n%s\ngot:\n%s", key, element, content)
+          }
+        }
+      }
+    })
+  }
+}
+
+
+func TestBuildTaskCanonicalUrlsRelative (t *testing.T) {
+  spec := NewSpec("spec", nil)
+  spec.Props["site_url"] = "/site/"
+
+  if err := BuildTaskCanonicalUrls(spec); err == nil {
+    t.Error("Expected an error for a relative site_url")
+  }
+}
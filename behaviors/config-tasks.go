@@ -0,0 +1,269 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "fmt"
+)
+
+
+/*
+  BuildConfigTasks is a SpecBuilder which reads the "tasks" prop,
+  an array of task definition objects, and creates a
+  TaskResolver for each which runs a system command. This allows
+  simple shell steps to be declared in a spec file. Each task
+  definition has the following attributes:
+
+    - name:    The task name (required)
+    - command: A shell command string, ran with `sh -c`, or an
+               array of a program and its arguments (required).
+               Props can be referenced with {{prop "key"}}. In
+               command strings, values are quoted as shell words,
+               as in Task.ExpandShellTemplate, and raw_prop
+               inserts a value unquoted. Array arguments are each
+               expanded without quoting, as in
+               Task.ExpandTemplate
+    - mask:    A list of Task Mask names, separated by commas or
+               "|", as in ParseTaskMask
+    - match:   An asset condition object, as in
+               AssetConditionFromProp, restricting which assets
+               the task receives
+    - after:   An array of task names this task must run after
+    - before:  An array of task names this task must run before
+    - defer:   If true, defer the task rather than enqueue it
+    - enqueue: If false, only define the TaskResolver, so that
+               the task can be queued by name from other tasks
+    - protocol: If "ndjson", the command is an exec plugin, which
+               receives the task's assets and emits new ones, as
+               in RunExecPlugin. If "wasm", the command is the
+               path of a WebAssembly module, or an array of it and
+               its arguments, ran as a WASM plugin, as in
+               RunWasmPlugin
+
+  Assets received by these tasks are forwarded after their
+  command runs, if the task's mask allows it to emit assets.
+*/
+func BuildConfigTasks (s *Spec) error {
+  tasks_any, found := s.GetProp("tasks")
+  if !found {
+    return nil
+  }
+
+  tasks_array, ok := tasks_any.([]any)
+  if !ok {
+    return fmt.Errorf("[%s] BuildConfigTasks error: Spec property 'tasks' expects an array, got a %T", s.Name, tasks_any)
+  }
+
+  for task_i, task_any := range tasks_array {
+    task_prop, ok := task_any.(map[string]any)
+    if !ok {
+      return fmt.Errorf("[%s] BuildConfigTasks error: task definition %d expects an object, got a %T", s.Name, task_i, task_any)
+    }
+
+    resolver, do_enqueue, do_defer, err := TaskResolverFromConfig(task_prop)
+    if err != nil {
+      return fmt.Errorf("[%s] BuildConfigTasks error in task definition %d: %w", s.Name, task_i, err)
+    }
+
+    s.AddTaskResolver(resolver)
+
+    if !do_enqueue {
+      continue
+    }
+
+    if do_defer {
+      err = s.DeferTask(resolver.NewTask())
+    } else {
+      err = s.EnqueueTask(resolver.NewTask())
+    }
+
+    if err != nil {
+      return fmt.Errorf("[%s] BuildConfigTasks error queuing task %s: %w", s.Name, resolver.Name, err)
+    }
+  }
+
+  delete(s.Props, "tasks")
+  return nil
+}
+
+
+/*
+  TaskResolverFromConfig creates a TaskResolver from a task
+  definition object, as described in BuildConfigTasks. It also
+  returns whether the task should be enqueued, and whether it
+  should be deferred.
+*/
+func TaskResolverFromConfig (prop map[string]any) (resolver *TaskResolver, do_enqueue, do_defer bool, err error) {
+  var command       []string
+  var shell_command string
+  var task          Task
+  var protocol      string
+  var ok      bool
+
+  do_enqueue = true
+
+  for key, value := range prop {
+    switch key {
+      case "name":
+        if task.Name, ok = value.(string); !ok {
+          return nil, false, false, fmt.Errorf("Task definition 'name' expects a string, got %T", value)
+        }
+
+      case "command":
+        switch value := value.(type) {
+          case string:
+            shell_command = value
+            command       = []string { "sh", "-c", value }
+          case []any:
+            for _, arg_any := range value {
+              arg, is_string := arg_any.(string)
+              if !is_string {
+                return nil, false, false, fmt.Errorf("Task definition 'command' array expects strings, got %T", arg_any)
+              }
+              command = append(command, arg)
+            }
+          default:
+            return nil, false, false, fmt.Errorf("Task definition 'command' expects a string or array, got %T", value)
+        }
+
+      case "mask":
+        mask_src, is_string := value.(string)
+        if !is_string {
+          return nil, false, false, fmt.Errorf("Task definition 'mask' expects a string, got %T", value)
+        }
+        if task.Mask, err = ParseTaskMask(mask_src); err != nil {
+          return nil, false, false, err
+        }
+
+      case "match":
+        match_prop, is_object := value.(map[string]any)
+        if !is_object {
+          return nil, false, false, fmt.Errorf("Task definition 'match' expects an object, got %T", value)
+        }
+
+        condition, err := AssetConditionFromProp(match_prop)
+        if err != nil {
+          return nil, false, false, err
+        }
+
+        task.MatchFunc = func (tk *Task, a *Asset) (bool, error) {
+          return condition.MatchAsset(a), nil
+        }
+
+      case "after", "before":
+        names_any, is_array := value.([]any)
+        if !is_array {
+          return nil, false, false, fmt.Errorf("Task definition '%s' expects an array of task names, got %T", key, value)
+        }
+
+        var names = make([]string, 0, len(names_any))
+        for _, name_any := range names_any {
+          name, is_string := name_any.(string)
+          if !is_string {
+            return nil, false, false, fmt.Errorf("Task definition '%s' expects an array of task names, got a %T element", key, name_any)
+          }
+          names = append(names, name)
+        }
+
+        if key == "after" {
+          task.After = names
+        } else {
+          task.Before = names
+        }
+
+      case "defer":
+        if do_defer, ok = value.(bool); !ok {
+          return nil, false, false, fmt.Errorf("Task definition 'defer' expects a boolean, got %T", value)
+        }
+
+      case "enqueue":
+        if do_enqueue, ok = value.(bool); !ok {
+          return nil, false, false, fmt.Errorf("Task definition 'enqueue' expects a boolean, got %T", value)
+        }
+
+      case "protocol":
+        if protocol, ok = value.(string); !ok {
+          return nil, false, false, fmt.Errorf("Task definition 'protocol' expects a string, got %T", value)
+        } else if protocol != "ndjson" && protocol != "wasm" {
+          return nil, false, false, fmt.Errorf("Task definition 'protocol' expects \"ndjson\" or \"wasm\", got \"%s\"", protocol)
+        }
+
+      default:
+        return nil, false, false, fmt.Errorf("Task definition has an unrecognized property \"%s\"", key)
+    }
+  }
+
+  var name = task.Name
+
+  if name == "" {
+    return nil, false, false, fmt.Errorf("Task definition requires a 'name'")
+  }
+
+  if len(command) == 0 {
+    return nil, false, false, fmt.Errorf("Task definition %s requires a 'command'", name)
+  }
+
+  if protocol == "wasm" {
+    // A command string is the module path, as modules are not ran
+    // by a shell
+    //
+    if shell_command != "" {
+      command = []string { shell_command }
+    }
+    task.Func = wasmTaskFunc(command)
+  } else if protocol == "ndjson" {
+    task.Func = func (s *Spec, tk *Task) error {
+      if shell_command != "" {
+        expanded, err := tk.ExpandShellTemplate(shell_command)
+        if err != nil {
+          return err
+        }
+        return RunExecPlugin(tk, "sh", "-c", expanded)
+      }
+      var args = make([]string, len(command))
+      for i, arg := range command {
+        expanded, err := tk.ExpandTemplate(arg)
+        if err != nil {
+          return err
+        }
+        args[i] = expanded
+      }
+      return RunExecPlugin(tk, args[0], args[1:]...)
+    }
+  } else {
+    task.Func = configTaskCommandFunc(shell_command, command)
+  }
+
+  resolver = & TaskResolver {
+    Id:            "config-task-" + name,
+    Name:          name,
+    TaskPrototype: task,
+  }
+
+  return resolver, do_enqueue, do_defer, nil
+}
+
+
+/*
+  configTaskCommandFunc returns the Func of a config task which
+  runs a command, and then forwards the assets it received.
+*/
+func configTaskCommandFunc (shell_command string, command []string) TaskFunc {
+  return func (s *Spec, tk *Task) error {
+    if shell_command != "" {
+      expanded, err := tk.ExpandShellTemplate(shell_command)
+      if err != nil {
+        return err
+      }
+      if _, err := tk.CommandRun("sh", "-c", expanded); err != nil {
+        return err
+      }
+    } else if _, err := tk.CommandTemplateRun(command[0], command[1:]...); err != nil {
+      return err
+    }
+
+    if len(tk.Assets) == 0 || !TaskMaskContains(tk.Mask, TASK_ASSETS_EMIT) {
+      return nil
+    }
+    return tk.ForwardAssets()
+  }
+}
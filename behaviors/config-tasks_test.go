@@ -0,0 +1,102 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "testing"
+  "encoding/json"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+
+func TestBuildConfigTasks (t *testing.T) {
+  var source_dir = t.TempDir()
+
+  var root = NewSpec("root", nil)
+  root.Props["quiet"]      = true
+  root.Props["source_dir"] = source_dir
+  root.AddSpecBuilder(BuildConfigTasks)
+
+  var props_src = `{
+    "injection": "$(touch injected.txt); echo",
+    "tasks": [
+      { "name": "quoted", "command": "echo {{prop \"injection\"}} > quoted.txt" },
+      { "name": "last",   "command": "echo last >> order.txt", "defer": true },
+      { "name": "second", "command": ["sh", "-c", "echo second >> order.txt"], "after": ["first"] },
+      { "name": "first",  "command": "echo first >> order.txt" },
+      { "name": "unused", "command": "echo unused >> order.txt", "enqueue": false },
+      {
+        "name": "html-only", "command": "true",
+        "mask": "consume,emit", "match": { "mime": "text/html" }
+      }
+    ]
+  }`
+
+  if err := json.Unmarshal([]byte(props_src), &root.Props); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := root.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  if _, found := root.Props["tasks"]; found {
+    t.Errorf("Expected the tasks prop to be removed after building")
+  }
+
+  if root.GetTaskResolverById("config-task-unused") == nil {
+    t.Errorf("Expected a TaskResolver for a task which is not enqueued")
+  }
+
+  if task := root.GetTaskFromQueue("html-only"); task == nil {
+    t.Fatalf("Expected html-only task to be enqueued")
+  } else if task.Mask != TASK_ASSETS_CONSUME | TASK_ASSETS_EMIT {
+    t.Errorf("Expected html-only task mask to be %04O, got %04O", TASK_ASSETS_CONSUME | TASK_ASSETS_EMIT, task.Mask)
+  } else if matches, _ := task.MatchAsset(& Asset { Mimetype: "text/css" }); matches {
+    t.Errorf("Expected html-only task not to match a CSS asset")
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  order, err := os.ReadFile(filepath.Join(source_dir, "order.txt"))
+  if err != nil { t.Fatal(err) }
+
+  if got, expect := strings.Fields(string(order)), []string { "first", "second", "last" }; strings.Join(got, ",") != strings.Join(expect, ",") {
+    t.Errorf("Expected config tasks to run in the order %v, got %v", expect, got)
+  }
+
+  // Prop values in shell command strings are quoted
+  //
+  if _, err := os.Stat(filepath.Join(source_dir, "injected.txt")); err == nil {
+    t.Errorf("Expected a prop value in a command string to be quoted, but it was interpreted by the shell")
+  }
+
+  if quoted, err := os.ReadFile(filepath.Join(source_dir, "quoted.txt")); err != nil {
+    t.Fatal(err)
+  } else if got, expect := string(quoted), "$(touch injected.txt); echo\n"; got != expect {
+    t.Errorf("Expected quoted command output %q, got %q", expect, got)
+  }
+
+  // Invalid task definitions
+  //
+  var invalid_definitions = []string {
+    `{ "command": "true" }`,
+    `{ "name": "no-command" }`,
+    `{ "name": "bad-mask", "command": "true", "mask": "teleport" }`,
+    `{ "name": "bad-key",  "command": "true", "unknown": 1 }`,
+    `{ "name": "bad-args", "command": ["echo", 1] }`,
+    `{ "name": "bad-after", "command": "true", "after": "first" }`,
+  }
+
+  for _, definition_src := range invalid_definitions {
+    var definition map[string]any
+    if err := json.Unmarshal([]byte(definition_src), &definition); err != nil {
+      t.Fatal(err)
+    }
+
+    if _, _, _, err := TaskResolverFromConfig(definition); err == nil {
+      t.Errorf("Expected an error from task definition %s", definition_src)
+    }
+  }
+}
@@ -13,7 +13,7 @@ import (
   "regexp"
 )
 
-var css_url_regexp = regexp.MustCompile(`(\s*[uU][rR][lL]\(\s*"?)(.*)("?\s*\))`)
+var css_url_regexp = regexp.MustCompile(`^(\s*[uU][rR][lL]\(\s*["']?)(.*?)(["']?\s*\))$`)
 
 
 var TaskResolverApplyPathTransformationsToCssContent = TaskResolver {
@@ -33,12 +33,44 @@ var TaskResolverApplyPathTransformationsToCssContent = TaskResolver {
 }
 
 
+/*
+  CssReaderApplyPathTransformationsTo copies CSS from a reader to
+  a writer, applying transformations to the URL references in it,
+  resolved against base_url. It returns whether any reference was
+  changed.
+*/
 func CssReaderApplyPathTransformationsTo (reader io.Reader, writer io.Writer, base_url *url.URL, transformations []*PathTransformation) (modified bool, err error) {
+  return CssReaderMapUrls(reader, writer, func (ref string) (string, bool) {
+    return TransformUrlReference(base_url, ref, transformations)
+  })
+}
+
+
+/*
+  CssReaderMapUrls copies CSS from a reader to a writer, passing
+  each URL reference in it to map_func, which returns its
+  replacement, and whether it was changed. References are url()
+  functions, the strings of @import rules, and the strings of
+  image-set() functions. Strings elsewhere, such as in the format()
+  of a font's src list, and comments, are not references. The rest
+  of the CSS, including the spacing and capitalization of url()
+  functions, is copied as-is. It returns whether any reference was
+  changed.
+*/
+func CssReaderMapUrls (reader io.Reader, writer io.Writer, map_func func (ref string) (string, bool)) (modified bool, err error) {
   var input = parse.NewInput(reader)
   var lexer = css.NewLexer(input)
 
   var line_number int = 1
 
+  // functions is the stack of the names of the functions, and
+  // parenthesized blocks, which the current token is inside of,
+  // and in_import is whether the current token follows an @import
+  // keyword, before its URL
+  //
+  var functions []string
+  var in_import bool
+
   // Iterate over CSS lexer tokens
   //
   for {
@@ -51,91 +83,115 @@ func CssReaderApplyPathTransformationsTo (reader io.Reader, writer io.Writer, ba
       break
     }
 
-    if token_type != css.URLToken {
-      writer.Write(token_data)
-    } else {
+    line_number += bytes.Count(token_data, []byte("\n"))
 
-      // Match the URL definition to get the URL value for
-      // applying PathTransformations
-      //
-      var url_definition = string(token_data)
-      var url_definition_matches = css_url_regexp.FindStringSubmatch(url_definition)
+    var new_token []byte = nil
+    var changed   bool
 
-      if len(url_definition_matches) == 0 {
-        writer.Write(token_data)
-        continue
-      }
+    switch token_type {
+    case css.URLToken:
+      new_token, changed = cssMapUrlToken(token_data, map_func)
 
-      // This URL definition matches. Extract parts of its text
-      // to reconstruct it as-is, after changing the URL value
-      // itself. This will maintain spacing and capitalization of
-      // the "url" function itself (which is case-insensitive)
-      //
-      var new_url_token []byte = nil
+    case css.StringToken:
+      var in_image_set = len(functions) > 0 && strings.HasSuffix(functions[len(functions)-1], "image-set")
+      if in_import || in_image_set {
+        new_token, changed = cssMapStringToken(token_data, map_func)
+      }
 
-      var prefix  string = url_definition_matches[1]
-      var url_raw string = url_definition_matches[2]
-      var suffix  string = url_definition_matches[3]
+    case css.AtKeywordToken:
+      in_import = strings.EqualFold(string(token_data), "@import")
 
-      var url_parsed *url.URL
-      var url_value  *url.URL
+    case css.FunctionToken:
+      functions = append(functions, strings.ToLower(strings.TrimSuffix(string(token_data), "(")))
 
-      url_parsed, err := url.Parse(url_raw)
+    case css.LeftParenthesisToken:
+      functions = append(functions, "")
 
-      if err != nil {
-        return false, err
-      } else {
-        url_value = base_url.ResolveReference(url_parsed)
+    case css.RightParenthesisToken:
+      if len(functions) > 0 {
+        functions = functions[:len(functions)-1]
       }
 
-      // Filter out external URLs
-      //
-      if url_value.Host == "" {
-        // this is a relative URL, pass
-      } else if url_value.Host != base_url.Host {
-        // this is an external URL, do not modify
-        continue
-      }
+    case css.LeftBraceToken, css.RightBraceToken, css.SemicolonToken:
+      functions = functions[:0]
+    }
 
-      // Apply path transformations
-      //
-      var original_path string = url_value.Path
-      var path          string = original_path
+    // The URL of an @import rule is the first token after its
+    // keyword, other than whitespace and comments
+    //
+    switch token_type {
+    case css.AtKeywordToken, css.WhitespaceToken, css.CommentToken:
+    default:
+      in_import = false
+    }
 
-      for _, transformation := range transformations {
-        path = transformation.TransformPath(path)
-      }
+    // Write either the new token, or the old one
+    //
+    if changed {
+      modified = true
+      writer.Write(new_token)
+    } else {
+      writer.Write(token_data)
+    }
+  }
 
-      // If the path was changed, generate a new URL
-      //
-      if original_path != path {
-        modified = true
+  return modified, nil
+}
 
-        var new_url string
 
-        if url_parsed.Host == "" || url_value.Host == "" {
-          new_url = path
-        } else {
-          url_value.Path = path
-          new_url = url_value.String()
-        }
+/*
+  cssMapUrlToken maps the URL of a url() token, returning the new
+  token, and whether it was changed. The spacing, quotes, and
+  capitalization of the function are kept.
+*/
+func cssMapUrlToken (token []byte, map_func func (ref string) (string, bool)) ([]byte, bool) {
+  // Match the URL definition to get the URL value for mapping.
+  // Extract parts of its text to reconstruct it as-is, after
+  // changing the URL value itself. This will maintain spacing and
+  // capitalization of the "url" function itself (which is
+  // case-insensitive)
+  //
+  var matches = css_url_regexp.FindStringSubmatch(string(token))
+  if len(matches) == 0 {
+    return nil, false
+  }
 
-        new_url_token = []byte(prefix + new_url + suffix)
-      }
+  var prefix  string = matches[1]
+  var url_raw string = matches[2]
+  var suffix  string = matches[3]
 
-      // Write either the new url() token, or the old one
-      //
-      if new_url_token == nil {
-        writer.Write(token_data)
-      } else {
-        writer.Write(new_url_token)
-      }
-    }
+  new_url, changed := map_func(url_raw)
+  if !changed {
+    return nil, false
+  }
+  return []byte(prefix + new_url + suffix), true
+}
 
-    line_number += bytes.Count(token_data, []byte("\n"))
+
+/*
+  cssMapStringToken maps the URL in a quoted string token,
+  returning the new token, and whether it was changed. Strings
+  with escape sequences or line breaks are left as-is.
+*/
+func cssMapStringToken (token []byte, map_func func (ref string) (string, bool)) ([]byte, bool) {
+  if len(token) < 2 || token[len(token)-1] != token[0] {
+    return nil, false
   }
 
-  return modified, nil
+  var quote = string(token[:1])
+  var ref   = string(token[1:len(token)-1])
+  if strings.ContainsAny(ref, "\\\n") {
+    return nil, false
+  }
+
+  new_ref, changed := map_func(ref)
+  if !changed {
+    return nil, false
+  }
+
+  new_ref = strings.ReplaceAll(new_ref, `\`, `\\`)
+  new_ref = strings.ReplaceAll(new_ref, quote, `\` + quote)
+  return []byte(quote + new_ref + quote), true
 }
 
 
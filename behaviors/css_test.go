@@ -18,6 +18,13 @@ func TestCss (t *testing.T) {
       background: url(/static/background.png);
       background: var(--variable);
     }
+
+    @font-face {
+      src: url(/fonts/font.eot?#iefix);
+      src: url(/fonts/font.woff?v=2);
+      src: url("/fonts/font.ttf") format("truetype");
+      src: url( '/fonts/font.otf' );
+    }
   `)
 
   path_transformations, err := PathTransformationsFromAny("s`^/?`transformed/`")
@@ -41,6 +48,10 @@ func TestCss (t *testing.T) {
   var expected_strings = []string {
     `--variable: url(/transformed/static/background.png`,
     `background: url(/transformed/static/background.png)`,
+    `src: url(/transformed/fonts/font.eot?#iefix)`,
+    `src: url(/transformed/fonts/font.woff?v=2)`,
+    `src: url("/transformed/fonts/font.ttf")`,
+    `src: url( '/transformed/fonts/font.otf' )`,
   }
 
   var printed_css = false
@@ -55,3 +66,57 @@ func TestCss (t *testing.T) {
     }
   }
 }
+
+
+func TestCssReaderMapUrls (t *testing.T) {
+  var css_raw = `@import "/base.css" screen;
+@IMPORT /* comment */ '/print.css' print;
+@import url(/theme.css);
+/* url(/commented.png) "/commented.css" */
+.hero {
+  background-image: image-set("/hero.avif" type("image/avif") 1x, url(/hero.png) 2x);
+  background-image: -webkit-image-set('/hero.png' 1x);
+  content: "/not-a-url.png";
+}
+@font-face {
+  font-family: "Font";
+  src: local("Font"), url(/fonts/font.woff2) format("woff2"), url('/fonts/font.woff') format('woff');
+}`
+
+  var expected = `@import "/mapped/base.css" screen;
+@IMPORT /* comment */ '/mapped/print.css' print;
+@import url(/mapped/theme.css);
+/* url(/commented.png) "/commented.css" */
+.hero {
+  background-image: image-set("/mapped/hero.avif" type("image/avif") 1x, url(/mapped/hero.png) 2x);
+  background-image: -webkit-image-set('/mapped/hero.png' 1x);
+  content: "/not-a-url.png";
+}
+@font-face {
+  font-family: "Font";
+  src: local("Font"), url(/mapped/fonts/font.woff2) format("woff2"), url('/mapped/fonts/font.woff') format('woff');
+}`
+
+  var writer bytes.Buffer
+  modified, err := CssReaderMapUrls(strings.NewReader(css_raw), &writer, func (ref string) (string, bool) {
+    return "/mapped" + ref, true
+  })
+  if err != nil { t.Fatal(err) }
+
+  if !modified {
+    t.Error("Expected the CSS to be modified")
+  }
+  if writer.String() != expected {
+    t.Errorf("Expected:\n%s\ngot:\n%s", expected, writer.String())
+  }
+
+  // Quotes in new references are escaped
+  //
+  writer.Reset()
+  CssReaderMapUrls(strings.NewReader(`@import 'a.css';`), &writer, func (ref string) (string, bool) {
+    return "it's.css", true
+  })
+  if writer.String() != `@import 'it\'s.css';` {
+    t.Errorf("Expected the quote to be escaped, got %s", writer.String())
+  }
+}
@@ -0,0 +1,316 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "fmt"
+  "os"
+  "path"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+
+/*
+  TaskResolverDeployRemote resolves the "deploy-remote" task,
+  which copies the assets which reach it to a server with rsync
+  over SSH, or with an SFTP client, for traditional hosting. It
+  runs after other tasks which change or emit assets, and before
+  the root spec's "root-consume" task.
+*/
+var TaskResolverDeployRemote = TaskResolver {
+  Id:   "deploy-remote",
+  Name: "deploy-remote",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "deploy-remote", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_CONSUME | TASK_ASSETS_EMIT,
+    Func:   TaskDeployRemote,
+    After:  []string {
+      "fingerprint-assets", "canonical-urls", "emit-sitemap", "emit-feed",
+      "emit-manifest", "precompress-assets",
+    },
+    Before: []string { "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskDeployRemote defers the "deploy-remote" task if the
+  spec has a "remote_dest" prop.
+*/
+func BuildTaskDeployRemote (s *Spec) error {
+  if s.GetTaskResolverById("deploy-remote") == nil {
+    deploy := TaskResolverDeployRemote
+    s.AddTaskResolver(&deploy)
+  }
+
+  dest, ok, found := s.GetPropString("remote_dest")
+  if !found {
+    return nil
+  } else if !ok || dest == "" {
+    return fmt.Errorf("Prop \"remote_dest\" in spec %s is expected to be a destination, got %v", s.Name, s.Props["remote_dest"])
+  }
+
+  task, err := s.GetTask("deploy-remote", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the deploy-remote task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  stageAssets writes the content of assets to a directory, at the
+  paths they are finally output at from a spec, with the file
+  metadata they captured, if any. Paths are written in sorted
+  order, and are returned.
+*/
+func stageAssets (s *Spec, assets []*Asset, dir string) ([]string, error) {
+  var staged = make(map[string]*Asset, len(assets))
+  for _, asset := range assets {
+    staged[path.Clean(finalOutputPath(s, asset))] = asset
+  }
+
+  var asset_paths = make([]string, 0, len(staged))
+  for asset_path := range staged {
+    asset_paths = append(asset_paths, asset_path)
+  }
+  sort.Strings(asset_paths)
+
+  for _, asset_path := range asset_paths {
+    content, err := staged[asset_path].EncodedContentBytes()
+    if err != nil { return nil, err }
+
+    var dest = filepath.Join(dir, KeyToPath(asset_path))
+    if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+      return nil, err
+    }
+    if err := os.WriteFile(dest, content, 0o644); err != nil {
+      return nil, err
+    }
+
+    perm, mod_time, err := assetFileAttributes(staged[asset_path], false)
+    if err != nil { return nil, err }
+    if err := setFileAttributes(dest, perm, mod_time); err != nil {
+      return nil, err
+    }
+  }
+
+  return asset_paths, nil
+}
+
+
+/*
+  inheritExecutable returns an executable from an inherited prop,
+  or its default name.
+*/
+func inheritExecutable (s *Spec, key, name string) (string, error) {
+  if prop_name, ok, found := s.InheritPropString(key); found && !ok {
+    return "", fmt.Errorf("Prop \"%s\" in spec %s is expected to be a string, got %T", key, s.Name, s.Props[key])
+  } else if found && prop_name != "" {
+    return prop_name, nil
+  }
+  return name, nil
+}
+
+
+/*
+  sftpQuote quotes an argument of an sftp batch command.
+*/
+func sftpQuote (arg string) string {
+  return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+
+/*
+  sftpBatch returns sftp batch commands which create the
+  directories of staged paths under a remote directory, and put
+  each file. Commands prefixed with "-" may fail, as when a
+  directory already exists.
+*/
+func sftpBatch (staging_dir, remote_dir string, asset_paths []string) string {
+  var batch strings.Builder
+  var made  = make(map[string]bool)
+
+  var mkdir func (dir string)
+  mkdir = func (dir string) {
+    if dir == "/" || made[dir] {
+      return
+    }
+    mkdir(path.Dir(dir))
+    made[dir] = true
+    batch.WriteString("-mkdir " + sftpQuote(path.Join(remote_dir, dir)) + "\n")
+  }
+
+  for _, asset_path := range asset_paths {
+    mkdir(path.Dir(asset_path))
+    var local  = filepath.Join(staging_dir, KeyToPath(asset_path))
+    var remote = path.Join(remote_dir, asset_path)
+    batch.WriteString("put " + sftpQuote(local) + " " + sftpQuote(remote) + "\n")
+  }
+
+  return batch.String()
+}
+
+
+/*
+  TaskDeployRemote pools the spec's input assets, forwards them,
+  writes them to a staging directory at the paths they are finally
+  output at, and copies the directory to a server. It reads the
+  following props:
+
+    - remote_dest:    The destination, such as
+      "user@example.com:/var/www/site". Required.
+    - remote_client:  "rsync", the default, or "sftp".
+    - remote_delete:  If true, rsync deletes remote files which
+      are not output. Not supported by sftp.
+    - remote_exclude: A glob pattern, or list of them, passed to
+      rsync as --exclude, protecting remote files from deletion.
+    - remote_ssh:     The remote shell rsync uses, such as
+      "ssh -p 2222".
+    - rsync_flags:    A list of additional arguments for rsync.
+      Defaults to "-rlz --checksum".
+    - preserve_metadata: Inherited. If true, rsync is also ran
+      with --perms and --times, to sync the permissions and
+      modification times assets captured from their files.
+    - dry_run:        Inherited. If true, rsync is run with
+      --dry-run, and sftp commands are printed instead of run.
+    - rsync_bin, sftp_bin: Inherited. The rsync and sftp
+      executables.
+*/
+func TaskDeployRemote (s *Spec, tk *Task) error {
+  dest, _, _ := s.GetPropString("remote_dest")
+
+  client, ok, found := s.GetPropString("remote_client")
+  if found && !ok {
+    return fmt.Errorf("Prop \"remote_client\" in spec %s is expected to be a string, got %T", s.Name, s.Props["remote_client"])
+  } else if client == "" {
+    client = "rsync"
+  } else if client != "rsync" && client != "sftp" {
+    return fmt.Errorf("Prop \"remote_client\" in spec %s is expected to be rsync or sftp, got %s", s.Name, client)
+  }
+
+  delete_removed, ok, found := s.GetPropBool("remote_delete")
+  if found && !ok {
+    return fmt.Errorf("Prop \"remote_delete\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["remote_delete"])
+  } else if delete_removed && client == "sftp" {
+    return fmt.Errorf("Prop \"remote_delete\" in spec %s is not supported with sftp", s.Name)
+  }
+
+  exclude_patterns, err := getPropPathPatterns(s, "remote_exclude")
+  if err != nil { return err }
+
+  dry_run, ok, found := s.InheritPropBool("dry_run")
+  if found && !ok {
+    return fmt.Errorf("Prop \"dry_run\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["dry_run"])
+  }
+
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets to deploy: %w", err)
+  }
+
+  var assets []*Asset
+  for _, input := range tk.Assets {
+    flattened, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+    assets = append(assets, flattened...)
+  }
+
+  if err := tk.ForwardAssets(); err != nil {
+    return err
+  }
+
+  staging_dir, err := os.MkdirTemp("", "interbuilder-deploy-")
+  if err != nil { return err }
+  defer os.RemoveAll(staging_dir)
+
+  asset_paths, err := stageAssets(s, assets, staging_dir)
+  if err != nil { return err }
+
+  if client == "sftp" {
+    sftp, err := inheritExecutable(s, "sftp_bin", "sftp")
+    if err != nil { return err }
+
+    // Split "host:dir" into the host, and the remote directory
+    //
+    host, remote_dir, _ := strings.Cut(dest, ":")
+    if remote_dir == "" {
+      remote_dir = "."
+    }
+
+    var batch = sftpBatch(staging_dir, remote_dir, asset_paths)
+    if dry_run {
+      tk.Println("Would run sftp commands on " + host + ":\n" + strings.TrimSpace(batch))
+      return nil
+    }
+
+    var batch_path = filepath.Join(staging_dir, ".sftp-batch")
+    if err := os.WriteFile(batch_path, []byte(batch), 0o644); err != nil {
+      return err
+    }
+
+    if _, err := tk.CommandRun(sftp, "-b", batch_path, host); err != nil {
+      return fmt.Errorf("Cannot deploy to %s with sftp: %w", dest, err)
+    }
+    return nil
+  }
+
+  rsync, err := inheritExecutable(s, "rsync_bin", "rsync")
+  if err != nil { return err }
+
+  var args = []string { "-rlz", "--checksum" }
+
+  if flags_any, found := s.GetProp("rsync_flags"); found {
+    flags, ok := flags_any.([]any)
+    if !ok {
+      return fmt.Errorf("Prop \"rsync_flags\" in spec %s is expected to be a list of strings, got %T", s.Name, flags_any)
+    }
+    args = args[:0]
+    for _, flag_any := range flags {
+      flag, ok := flag_any.(string)
+      if !ok {
+        return fmt.Errorf("Prop \"rsync_flags\" in spec %s is expected to contain strings, got %T", s.Name, flag_any)
+      }
+      args = append(args, flag)
+    }
+  }
+
+  if remote_ssh, ok, found := s.GetPropString("remote_ssh"); found && !ok {
+    return fmt.Errorf("Prop \"remote_ssh\" in spec %s is expected to be a string, got %T", s.Name, s.Props["remote_ssh"])
+  } else if remote_ssh != "" {
+    args = append(args, "-e", remote_ssh)
+  }
+
+  if preserve_metadata, ok, found := s.InheritPropBool("preserve_metadata"); found && !ok {
+    prop, _ := s.InheritProp("preserve_metadata")
+    return fmt.Errorf("Prop \"preserve_metadata\" in spec %s is expected to be a boolean, got %T", s.Name, prop)
+  } else if preserve_metadata {
+    args = append(args, "--perms", "--times")
+  }
+
+  if delete_removed {
+    args = append(args, "--delete")
+  }
+  for _, pattern := range exclude_patterns {
+    args = append(args, "--exclude=" + pattern)
+  }
+  if dry_run {
+    args = append(args, "--dry-run", "--itemize-changes")
+  }
+
+  // The trailing slash copies the directory's contents, rather
+  // than the directory itself
+  //
+  args = append(args, staging_dir + string(filepath.Separator), dest)
+
+  if _, err := tk.CommandRun(rsync, args...); err != nil {
+    return fmt.Errorf("Cannot deploy to %s with rsync: %w", dest, err)
+  }
+  return nil
+}
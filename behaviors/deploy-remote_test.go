@@ -0,0 +1,190 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "os"
+  "path/filepath"
+  "regexp"
+  "strings"
+  "testing"
+)
+
+
+func TestTaskDeployRemote (t *testing.T) {
+  var dir      = t.TempDir()
+  var log_path = filepath.Join(dir, "client.log")
+
+  // The fake rsync copies its source to its destination, and the
+  // fake sftp logs its batch file
+  //
+  var rsync = filepath.Join(dir, "rsync")
+  var rsync_src = strings.Join([]string {
+    `#!/bin/sh`,
+    `echo "$@" > '` + log_path + `'`,
+    `for arg; do SRC="$DEST"; DEST="$arg"; done`,
+    `case "$*" in *--dry-run*) exit 0 ;; esac`,
+    `mkdir -p "$DEST" && cp -R "$SRC". "$DEST"`,
+  }, "\n")
+
+  var sftp = filepath.Join(dir, "sftp")
+  var sftp_src = strings.Join([]string {
+    `#!/bin/sh`,
+    `echo "$3" > '` + log_path + `'`,
+    `cat "$2" >> '` + log_path + `'`,
+  }, "\n")
+
+  for name, src := range map[string]string { rsync: rsync_src, sftp: sftp_src } {
+    if err := os.WriteFile(name, []byte(src), 0o755); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  var documents = map[string]string {
+    "index.html":    "<p>Hello</p>",
+    "css/style.css": "body { margin: 0 }",
+  }
+
+  var test_cases = []struct {
+    name    string
+    props   map[string]any
+    check   func (t *testing.T, log string)
+  } {
+    {
+      name: "rsync",
+      props: map[string]any {
+        "remote_dest":    filepath.Join(dir, "www"),
+        "remote_delete":  true,
+        "remote_exclude": []any { "uploads/*" },
+        "remote_ssh":     "ssh -p 2222",
+      },
+      check: func (t *testing.T, log string) {
+        if expect := "-rlz --checksum -e ssh -p 2222 --delete --exclude=/uploads/* "; !strings.HasPrefix(log, expect) {
+          t.Errorf("Expected rsync arguments to start with %q, got %q", expect, log)
+        }
+        content, err := os.ReadFile(filepath.Join(dir, "www", "css", "style.css"))
+        if err != nil || string(content) != documents["css/style.css"] {
+          t.Errorf("Expected the stylesheet to be deployed, got %q, %v", content, err)
+        }
+      },
+    },
+    {
+      name: "rsync preserve metadata",
+      props: map[string]any {
+        "remote_dest":       filepath.Join(dir, "www-metadata"),
+        "preserve_metadata": true,
+      },
+      check: func (t *testing.T, log string) {
+        if !strings.HasPrefix(log, "-rlz --checksum --perms --times ") {
+          t.Errorf("Expected rsync to be run with --perms and --times, got %q", log)
+        }
+        stat, err := os.Stat(filepath.Join(dir, "www-metadata", "index.html"))
+        if err != nil || stat.Mode().Perm() != 0o750 {
+          t.Errorf("Expected index.html to be staged with its captured mode, got %v", err)
+        }
+      },
+    },
+    {
+      name: "rsync dry run",
+      props: map[string]any {
+        "remote_dest": filepath.Join(dir, "dry"),
+        "dry_run":     true,
+      },
+      check: func (t *testing.T, log string) {
+        if !strings.Contains(log, "--dry-run") {
+          t.Errorf("Expected rsync to be run with --dry-run, got %q", log)
+        }
+        if _, err := os.Stat(filepath.Join(dir, "dry")); !os.IsNotExist(err) {
+          t.Errorf("Expected a dry run not to deploy, got %v", err)
+        }
+      },
+    },
+    {
+      name: "sftp",
+      props: map[string]any {
+        "remote_dest":   "user@example.com:/var/www",
+        "remote_client": "sftp",
+      },
+      check: func (t *testing.T, log string) {
+        // The staging directory is temporary
+        //
+        log = regexp.MustCompile(`"[^"]*/interbuilder-deploy-[^/]*/`).ReplaceAllString(log, `"STAGING/`)
+
+        var lines = strings.Split(strings.TrimSpace(log), "\n")
+        var expect = []string {
+          `user@example.com`,
+          `-mkdir "/var/www/css"`,
+          `put "STAGING/css/style.css" "/var/www/css/style.css"`,
+          `put "STAGING/index.html" "/var/www/index.html"`,
+        }
+
+        if len(lines) != len(expect) {
+          t.Fatalf("Expected %d lines, got:\n%s", len(expect), log)
+        }
+        for i := range expect {
+          if lines[i] != expect[i] {
+            t.Errorf("Expected line %d to be %s, got %s", i, expect[i], lines[i])
+          }
+        }
+      },
+    },
+  }
+
+  for _, test_case := range test_cases {
+    t.Run(test_case.name, func (t *testing.T) {
+      os.Remove(log_path)
+
+      root    := NewSpec("root", nil)
+      subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+      root.Props["quiet"]     = true
+      root.Props["rsync_bin"] = rsync
+      root.Props["sftp_bin"]  = sftp
+      for key, value := range test_case.props {
+        subspec.Props[key] = value
+      }
+
+      subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+        for key, content := range documents {
+          asset := s.MakeAsset(key)
+          asset.SetContentBytes([]byte(content))
+          if key == "index.html" {
+            asset.FileMode = 0o750
+          }
+          if err := tk.EmitAsset(asset); err != nil {
+            return err
+          }
+        }
+        return nil
+      })
+
+      var received int
+      root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+        if err := tk.PoolSpecInputAssets(); err != nil {
+          return err
+        }
+        for _, asset := range tk.Assets {
+          assets, err := asset.Flatten()
+          if err != nil { return err }
+          received += len(assets)
+        }
+        return nil
+      })
+
+      root.AddSpecBuilder(BuildTaskDeployRemote)
+      if err := subspec.Build(); err != nil {
+        t.Fatal(err)
+      }
+
+      TestWrapTimeoutError(t, root.Run)
+
+      if received != len(documents) {
+        t.Errorf("Expected %d assets to be forwarded, got %d", len(documents), received)
+      }
+
+      log, err := os.ReadFile(log_path)
+      if err != nil { t.Fatal(err) }
+      test_case.check(t, string(log))
+    })
+  }
+}
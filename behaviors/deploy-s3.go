@@ -0,0 +1,454 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bytes"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+  "slices"
+  "sort"
+  "strings"
+)
+
+
+/*
+  TaskResolverDeployS3 resolves the "deploy-s3" task, which
+  uploads the assets which reach it to an S3 bucket, or an
+  S3-compatible CDN origin, with the `aws` command. It runs after
+  other tasks which change or emit assets, and before the root
+  spec's "root-consume" task, so that a deployed site is also
+  written locally.
+*/
+var TaskResolverDeployS3 = TaskResolver {
+  Id:   "deploy-s3",
+  Name: "deploy-s3",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "deploy-s3", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_CONSUME | TASK_ASSETS_EMIT,
+    Func:   TaskDeployS3,
+    After:  []string {
+      "fingerprint-assets", "canonical-urls", "emit-sitemap", "emit-feed",
+      "emit-manifest", "precompress-assets",
+    },
+    Before: []string { "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskDeployS3 defers the "deploy-s3" task if the spec has
+  an "s3_bucket" prop.
+*/
+func BuildTaskDeployS3 (s *Spec) error {
+  if s.GetTaskResolverById("deploy-s3") == nil {
+    deploy := TaskResolverDeployS3
+    s.AddTaskResolver(&deploy)
+  }
+
+  bucket, ok, found := s.GetPropString("s3_bucket")
+  if !found {
+    return nil
+  } else if !ok || bucket == "" {
+    return fmt.Errorf("Prop \"s3_bucket\" in spec %s is expected to be a bucket name, got %v", s.Name, s.Props["s3_bucket"])
+  }
+
+  task, err := s.GetTask("deploy-s3", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the deploy-s3 task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  A DeployObject describes an object uploaded by a deploy, so that
+  the next deploy can skip unchanged objects and delete removed
+  ones.
+*/
+type DeployObject struct {
+  Key              string  `json:"key"`
+  Sha256           string  `json:"sha256"`
+  ContentType      string  `json:"content_type,omitempty"`
+  ContentEncoding  string  `json:"content_encoding,omitempty"`
+  CacheControl     string  `json:"cache_control,omitempty"`
+}
+
+
+/*
+  DeployState is the set of objects uploaded by a deploy, which
+  is stored alongside them.
+*/
+type DeployState struct {
+  Objects  []DeployObject  `json:"objects"`
+}
+
+
+/*
+  DeployDiff compares the objects of a previous deploy with those
+  of the next one, returning the objects to upload, because they
+  are new or changed, and the keys to delete, because they are no
+  longer output. Both are sorted by key.
+*/
+func DeployDiff (previous, next []DeployObject) (upload []DeployObject, remove []string) {
+  var previous_objects = make(map[string]DeployObject, len(previous))
+  for _, object := range previous {
+    previous_objects[object.Key] = object
+  }
+
+  var next_keys = make(map[string]bool, len(next))
+  for _, object := range next {
+    next_keys[object.Key] = true
+    if previous_object, found := previous_objects[object.Key]; !found || previous_object != object {
+      upload = append(upload, object)
+    }
+  }
+
+  for _, object := range previous {
+    if !next_keys[object.Key] {
+      remove = append(remove, object.Key)
+    }
+  }
+
+  sort.Slice(upload, func (i, j int) bool { return upload[i].Key < upload[j].Key })
+  sort.Strings(remove)
+  return upload, remove
+}
+
+
+/*
+  s3CacheControl returns the Cache-Control of an asset from the
+  "s3_cache_control" prop, which is either a string for every
+  asset, or an object mapping MIME type prefixes to values, where
+  the longest matching prefix is used. Without a match,
+  fingerprinted assets are cached indefinitely, and other assets
+  have no Cache-Control.
+*/
+func s3CacheControl (s *Spec, a *Asset, content_type string) (string, error) {
+  cache_control_any, found := s.GetProp("s3_cache_control")
+
+  switch cache_control := cache_control_any.(type) {
+    case nil:
+    case string:
+      return cache_control, nil
+    case map[string]any:
+      var match_prefix string
+      var match_value  string
+      var matched      bool
+      for prefix, value_any := range cache_control {
+        value, ok := value_any.(string)
+        if !ok {
+          return "", fmt.Errorf("Prop \"s3_cache_control\" in spec %s is expected to contain strings, got %T", s.Name, value_any)
+        }
+        if strings.HasPrefix(content_type, prefix) && (!matched || len(prefix) > len(match_prefix)) {
+          match_prefix, match_value, matched = prefix, value, true
+        }
+      }
+      if matched {
+        return match_value, nil
+      }
+    default:
+      if found {
+        return "", fmt.Errorf("Prop \"s3_cache_control\" in spec %s is expected to be a string or object, got %T", s.Name, cache_control_any)
+      }
+  }
+
+  if _, fingerprinted := a.Metadata["fingerprint_source"]; fingerprinted {
+    return "public, max-age=31536000, immutable", nil
+  }
+  return "", nil
+}
+
+
+/*
+  s3Command returns the `aws` command, from the inherited
+  "aws_bin" prop, and the arguments common to each call, from the
+  "s3_endpoint_url" prop.
+*/
+func s3Command (s *Spec) (string, []string, error) {
+  var aws = "aws"
+  if prop_aws, ok, found := s.InheritPropString("aws_bin"); found && !ok {
+    return "", nil, fmt.Errorf("Prop \"aws_bin\" in spec %s is expected to be a string, got %T", s.Name, s.Props["aws_bin"])
+  } else if found && prop_aws != "" {
+    aws = prop_aws
+  }
+
+  var args []string
+  if endpoint, ok, found := s.GetPropString("s3_endpoint_url"); found && !ok {
+    return "", nil, fmt.Errorf("Prop \"s3_endpoint_url\" in spec %s is expected to be a string, got %T", s.Name, s.Props["s3_endpoint_url"])
+  } else if endpoint != "" {
+    args = append(args, "--endpoint-url", endpoint)
+  }
+
+  return aws, args, nil
+}
+
+
+/*
+  s3ContentHash returns the hex SHA-256 hash of an asset's content
+  as it is uploaded, encoded with its ContentEncoding, streaming it
+  from the asset's EncodedContentReader rather than reading it
+  into memory.
+*/
+func s3ContentHash (a *Asset) (string, error) {
+  reader, err := a.EncodedContentReader()
+  if err != nil {
+    return "", fmt.Errorf("Cannot read asset %s for deploy: %w", a.Url, err)
+  }
+  if closer, ok := reader.(io.Closer); ok {
+    defer closer.Close()
+  }
+
+  var hasher = sha256.New()
+  if _, err := io.Copy(hasher, reader); err != nil {
+    return "", fmt.Errorf("Cannot read asset %s for deploy: %w", a.Url, err)
+  }
+  return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+
+/*
+  s3GetState downloads the state of the previous deploy. If there
+  was no previous deploy, an empty state is returned.
+*/
+func s3GetState (tk *Task, aws string, args []string, bucket, key, staging_dir string) (DeployState, error) {
+  var state       DeployState
+  var state_path  = filepath.Join(staging_dir, "state.json")
+  var output      bytes.Buffer
+
+  cmd := tk.Command(aws, slices.Concat(args, []string { "s3api", "get-object", "--bucket", bucket, "--key", key, state_path })...)
+  cmd.Stdout = &output
+  cmd.Stderr = &output
+
+  if err := cmd.Run(); err != nil {
+    if strings.Contains(output.String(), "NoSuchKey") {
+      return state, nil
+    }
+    return state, fmt.Errorf("Cannot download deploy state s3://%s/%s: %w: %s", bucket, key, err, strings.TrimSpace(output.String()))
+  }
+
+  state_json, err := os.ReadFile(state_path)
+  if err != nil { return state, err }
+
+  if err := json.Unmarshal(state_json, &state); err != nil {
+    return state, fmt.Errorf("Cannot parse deploy state s3://%s/%s: %w", bucket, key, err)
+  }
+  return state, nil
+}
+
+
+/*
+  TaskDeployS3 pools the spec's input assets, forwards them, and
+  uploads them to an S3 bucket with the `aws` command, at the
+  paths they are finally output at. Each object's Content-Type is
+  the asset's MIME type, and precompressed assets, as emitted by
+  the "precompress-assets" task, are uploaded with their
+  Content-Encoding and the MIME type of their source.
+
+  The objects of each deploy are recorded in a state object in the
+  bucket. Objects which are unchanged since the previous deploy
+  are not uploaded again, and objects which the previous deploy
+  uploaded but which are no longer output are deleted. Objects
+  which interbuilder did not upload are never deleted. It reads
+  the following props:
+
+    - s3_bucket:        The bucket name. Required.
+    - s3_prefix:        A key prefix to upload objects under, such
+      as "site/".
+    - s3_cache_control: A Cache-Control value for every object, or
+      an object mapping MIME type prefixes to values. By default,
+      only fingerprinted assets have a Cache-Control, caching them
+      indefinitely.
+    - s3_delete:        If false, objects removed since the
+      previous deploy are kept. Defaults to true.
+    - s3_state_key:     The key of the state object, under
+      s3_prefix. Defaults to ".interbuilder-deploy.json".
+    - s3_endpoint_url:  The endpoint of an S3-compatible service.
+    - dry_run:          Inherited. If true, print what would be
+      uploaded and deleted, without changing the bucket.
+    - aws_bin:          Inherited. The aws executable. Defaults to
+      "aws".
+*/
+func TaskDeployS3 (s *Spec, tk *Task) error {
+  bucket, _, _ := s.GetPropString("s3_bucket")
+
+  prefix, ok, found := s.GetPropString("s3_prefix")
+  if found && !ok {
+    return fmt.Errorf("Prop \"s3_prefix\" in spec %s is expected to be a string, got %T", s.Name, s.Props["s3_prefix"])
+  }
+  prefix = strings.TrimLeft(prefix, "/")
+  if prefix != "" && !strings.HasSuffix(prefix, "/") {
+    prefix += "/"
+  }
+
+  var state_key = ".interbuilder-deploy.json"
+  if prop_state_key, ok, found := s.GetPropString("s3_state_key"); found && (!ok || prop_state_key == "") {
+    return fmt.Errorf("Prop \"s3_state_key\" in spec %s is expected to be a key, got %v", s.Name, s.Props["s3_state_key"])
+  } else if found {
+    state_key = strings.TrimLeft(prop_state_key, "/")
+  }
+  state_key = prefix + state_key
+
+  var delete_removed = true
+  if prop_delete, ok, found := s.GetPropBool("s3_delete"); found && !ok {
+    return fmt.Errorf("Prop \"s3_delete\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["s3_delete"])
+  } else if found {
+    delete_removed = prop_delete
+  }
+
+  dry_run, ok, found := s.InheritPropBool("dry_run")
+  if found && !ok {
+    return fmt.Errorf("Prop \"dry_run\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["dry_run"])
+  }
+
+  aws, aws_args, err := s3Command(s)
+  if err != nil { return err }
+
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets to deploy: %w", err)
+  }
+
+  // Describe each asset as an object
+  //
+  var objects []DeployObject
+  var deployed = make(map[string]*Asset)
+
+  for _, input := range tk.Assets {
+    assets, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+
+    for _, asset := range assets {
+      sum, err := s3ContentHash(asset)
+      if err != nil { return err }
+
+      var key          = prefix + strings.TrimLeft(finalOutputPath(s, asset), "/")
+      var content_type = minifyMediaType(asset)
+      var encoding, _  = asset.Metadata["content_encoding"].(string)
+
+      if source_mimetype, ok := asset.Metadata["source_mimetype"].(string); ok && encoding != "" {
+        content_type = source_mimetype
+      }
+      if content_type == "" {
+        content_type = "application/octet-stream"
+      }
+
+      cache_control, err := s3CacheControl(s, asset, content_type)
+      if err != nil { return err }
+
+      objects = append(objects, DeployObject {
+        Key:             key,
+        Sha256:          sum,
+        ContentType:     content_type,
+        ContentEncoding: encoding,
+        CacheControl:    cache_control,
+      })
+      deployed[key] = asset
+    }
+  }
+
+  if err := tk.ForwardAssets(); err != nil {
+    return err
+  }
+
+  staging_dir, err := os.MkdirTemp("", "interbuilder-s3-")
+  if err != nil { return err }
+  defer os.RemoveAll(staging_dir)
+
+  previous, err := s3GetState(tk, aws, aws_args, bucket, state_key, staging_dir)
+  if err != nil { return err }
+
+  upload, removed := DeployDiff(previous.Objects, objects)
+
+  var remove = removed
+  if !delete_removed {
+    remove = nil
+  }
+
+  if dry_run {
+    for _, object := range upload {
+      tk.Println("Would upload", "s3://" + bucket + "/" + object.Key, "as", object.ContentType)
+    }
+    for _, key := range remove {
+      tk.Println("Would delete", "s3://" + bucket + "/" + key)
+    }
+    return nil
+  }
+
+  // putObject streams content to a staging file and uploads it
+  //
+  var putObject = func (object DeployObject, content io.Reader) error {
+    var body = filepath.Join(staging_dir, "body")
+    file, err := os.Create(body)
+    if err != nil {
+      return err
+    }
+    _, err = io.Copy(file, content)
+    if close_err := file.Close(); err == nil {
+      err = close_err
+    }
+    if err != nil {
+      return fmt.Errorf("Cannot stage s3://%s/%s: %w", bucket, object.Key, err)
+    }
+
+    args := slices.Concat(aws_args, []string {
+      "s3api", "put-object", "--bucket", bucket, "--key", object.Key,
+      "--body", body, "--content-type", object.ContentType,
+    })
+    if object.CacheControl != "" {
+      args = append(args, "--cache-control", object.CacheControl)
+    }
+    if object.ContentEncoding != "" {
+      args = append(args, "--content-encoding", object.ContentEncoding)
+    }
+
+    if _, err := tk.CommandRun(aws, args...); err != nil {
+      return fmt.Errorf("Cannot upload s3://%s/%s: %w", bucket, object.Key, err)
+    }
+    return nil
+  }
+
+  for _, object := range upload {
+    content, err := deployed[object.Key].EncodedContentReader()
+    if err != nil { return err }
+    err = putObject(object, content)
+    if closer, ok := content.(io.Closer); ok {
+      closer.Close()
+    }
+    if err != nil { return err }
+  }
+
+  for _, key := range remove {
+    args := slices.Concat(aws_args, []string { "s3api", "delete-object", "--bucket", bucket, "--key", key })
+    if _, err := tk.CommandRun(aws, args...); err != nil {
+      return fmt.Errorf("Cannot delete s3://%s/%s: %w", bucket, key, err)
+    }
+  }
+
+  // Record the deployed objects. If removed objects are kept,
+  // they remain in the state, so that a later deploy may delete
+  // them.
+  //
+  var state = DeployState { Objects: objects }
+  if !delete_removed {
+    for _, object := range previous.Objects {
+      if slices.Contains(removed, object.Key) {
+        state.Objects = append(state.Objects, object)
+      }
+    }
+  }
+
+  state_json, err := json.MarshalIndent(state, "", "  ")
+  if err != nil { return err }
+
+  return putObject(DeployObject { Key: state_key, ContentType: "application/json", CacheControl: "no-cache" }, bytes.NewReader(state_json))
+}
@@ -0,0 +1,224 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+/*
+  fakeAwsS3 writes an `aws` executable which stores objects in a
+  directory, and logs each call, returning the path of the
+  executable, the bucket directory, and the log file.
+*/
+func fakeAwsS3 (t *testing.T) (string, string, string) {
+  t.Helper()
+
+  var dir        = t.TempDir()
+  var bucket_dir = filepath.Join(dir, "bucket")
+  var log_path   = filepath.Join(dir, "aws.log")
+  var aws        = filepath.Join(dir, "aws")
+
+  var aws_src = strings.Join([]string {
+    `#!/bin/sh`,
+    `BUCKET_DIR='` + bucket_dir + `'`,
+    `echo "$@" >> '` + log_path + `'`,
+    `OPERATION="$2"; shift 2`,
+    `while [ $# -gt 0 ]; do`,
+    `  case "$1" in`,
+    `    --key)  KEY="$2"; shift 2 ;;`,
+    `    --body) BODY="$2"; shift 2 ;;`,
+    `    --*)    shift 2 ;;`,
+    `    *)      OUTFILE="$1"; shift ;;`,
+    `  esac`,
+    `done`,
+    `case "$OPERATION" in`,
+    `  get-object)`,
+    `    if [ ! -f "$BUCKET_DIR/$KEY" ]; then echo "An error occurred (NoSuchKey)" >&2; exit 255; fi`,
+    `    cp "$BUCKET_DIR/$KEY" "$OUTFILE" ;;`,
+    `  put-object)`,
+    `    mkdir -p "$(dirname "$BUCKET_DIR/$KEY")" && cp "$BODY" "$BUCKET_DIR/$KEY" ;;`,
+    `  delete-object)`,
+    `    rm "$BUCKET_DIR/$KEY" ;;`,
+    `esac`,
+  }, "\n")
+
+  if err := os.WriteFile(aws, []byte(aws_src), 0o755); err != nil {
+    t.Fatal(err)
+  }
+  return aws, bucket_dir, log_path
+}
+
+
+func runDeployS3Spec (t *testing.T, props map[string]any, documents map[string]string) {
+  t.Helper()
+
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"] = true
+  for key, value := range props {
+    subspec.Props[key] = value
+  }
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for key, content := range documents {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes([]byte(content))
+      if strings.HasPrefix(key, "js/") {
+        asset.Metadata = map[string]any { "fingerprint_source": "/js/app.js" }
+      }
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received int
+
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      assets, err := asset.Flatten()
+      if err != nil { return err }
+      received += len(assets)
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTaskDeployS3)
+  if err := subspec.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if received != len(documents) {
+    t.Errorf("Expected %d assets to be forwarded, got %d", len(documents), received)
+  }
+}
+
+
+func TestTaskDeployS3 (t *testing.T) {
+  aws, bucket_dir, log_path := fakeAwsS3(t)
+
+  var props = map[string]any {
+    "aws_bin":          aws,
+    "s3_bucket":        "example",
+    "s3_prefix":        "site",
+    "s3_cache_control": map[string]any { "text/": "no-cache", "text/css": "max-age=60", "text/javascript": "max-age=600" },
+  }
+
+  // readLog returns the logged calls since the last read
+  //
+  var readLog = func () []string {
+    t.Helper()
+    log, err := os.ReadFile(log_path)
+    if err != nil { t.Fatal(err) }
+    if err := os.Remove(log_path); err != nil { t.Fatal(err) }
+    return strings.Split(strings.TrimSpace(string(log)), "\n")
+  }
+
+  // expectCalls checks that each expected call, without its body
+  // argument, was logged, in any order
+  //
+  var expectCalls = func (calls []string, expect ...string) {
+    t.Helper()
+    if len(calls) != len(expect) {
+      t.Errorf("Expected %d calls, got %d:\n%s", len(expect), len(calls), strings.Join(calls, "\n"))
+    }
+    for _, call := range expect {
+      var found bool
+      for _, got := range calls {
+        words := strings.Fields(got)
+        for i := range words {
+          if words[i] == "--body" {
+            words = append(words[:i+1], words[i+2:]...)
+            break
+          }
+        }
+        if strings.Join(words, " ") == call {
+          found = true
+        }
+      }
+      if !found {
+        t.Errorf("Expected the call:\n%s\ngot:\n%s", call, strings.Join(calls, "\n"))
+      }
+    }
+  }
+
+  runDeployS3Spec(t, props, map[string]string {
+    "index.html":       "<p>Hello</p>",
+    "style.css":        "body { margin: 0 }",
+    "js/app.0123.js":   "console.log(1)",
+    "old.txt":          "old",
+  })
+
+  var calls = readLog()
+  if len(calls) == 0 || !strings.HasPrefix(calls[0], "s3api get-object --bucket example --key site/.interbuilder-deploy.json") {
+    t.Fatalf("Expected the deploy state to be read first, got %v", calls)
+  }
+  expectCalls(calls[1:],
+    "s3api put-object --bucket example --key site/index.html --body --content-type text/html --cache-control no-cache",
+    "s3api put-object --bucket example --key site/style.css --body --content-type text/css --cache-control max-age=60",
+    "s3api put-object --bucket example --key site/js/app.0123.js --body --content-type text/javascript --cache-control max-age=600",
+    "s3api put-object --bucket example --key site/old.txt --body --content-type text/plain --cache-control no-cache",
+    "s3api put-object --bucket example --key site/.interbuilder-deploy.json --body --content-type application/json --cache-control no-cache",
+  )
+
+  // Only changes are applied by the next deploy, and a dry run
+  // makes none
+  //
+  var next_documents = map[string]string {
+    "index.html":       "<p>Hello</p>",
+    "style.css":        "body { margin: 1em }",
+    "js/app.0123.js":   "console.log(1)",
+  }
+
+  runDeployS3Spec(t, map[string]any { "dry_run": true, "aws_bin": aws, "s3_bucket": "example", "s3_prefix": "site" }, next_documents)
+  expectCalls(readLog()[1:])
+
+  runDeployS3Spec(t, props, next_documents)
+  expectCalls(readLog()[1:],
+    "s3api put-object --bucket example --key site/style.css --body --content-type text/css --cache-control max-age=60",
+    "s3api delete-object --bucket example --key site/old.txt",
+    "s3api put-object --bucket example --key site/.interbuilder-deploy.json --body --content-type application/json --cache-control no-cache",
+  )
+
+  if content, err := os.ReadFile(filepath.Join(bucket_dir, "site/style.css")); err != nil || string(content) != next_documents["style.css"] {
+    t.Errorf("Expected the stylesheet to be updated in the bucket, got %q, %v", content, err)
+  }
+  if _, err := os.Stat(filepath.Join(bucket_dir, "site/old.txt")); !os.IsNotExist(err) {
+    t.Errorf("Expected old.txt to be deleted from the bucket, got %v", err)
+  }
+}
+
+
+func TestDeployDiff (t *testing.T) {
+  var previous = []DeployObject {
+    { Key: "a", Sha256: "1" },
+    { Key: "b", Sha256: "2" },
+    { Key: "c", Sha256: "3", CacheControl: "no-cache" },
+  }
+  var next = []DeployObject {
+    { Key: "d", Sha256: "4" },
+    { Key: "a", Sha256: "1" },
+    { Key: "c", Sha256: "3" },
+  }
+
+  upload, remove := DeployDiff(previous, next)
+
+  if len(upload) != 2 || upload[0].Key != "c" || upload[1].Key != "d" {
+    t.Errorf("Expected c and d to be uploaded, got %v", upload)
+  }
+  if len(remove) != 1 || remove[0] != "b" {
+    t.Errorf("Expected b to be removed, got %v", remove)
+  }
+}
@@ -0,0 +1,312 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bufio"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "net/url"
+  "os"
+  "strings"
+  "syscall"
+)
+
+
+/*
+  ExecProtocolVersion is the version of the NDJSON protocol exec
+  plugins speak, sent in the "start" message.
+*/
+const ExecProtocolVersion = 1
+
+
+/*
+  TaskResolverExec is the prototype of resolvers whose tasks run
+  an exec plugin: a subprocess, in any language, which transforms
+  assets by speaking an NDJSON protocol on its stdin and stdout,
+  as described in RunExecPlugin. Resolvers for a command are made
+  with NewTaskResolverExec, or by a config task with a "protocol"
+  of "ndjson".
+*/
+var TaskResolverExec = TaskResolver {
+  Id:   "exec",
+  Name: "exec",
+  TaskPrototype: Task {
+    Mask: TASK_ASSETS_MUTATE | TASK_ASSETS_FILTER | TASK_ASSETS_GENERATE,
+  },
+}
+
+
+/*
+  NewTaskResolverExec returns a copy of TaskResolverExec which
+  resolves tasks with a name, which run a command as an exec
+  plugin. Arguments are expanded as in Task.ExpandTemplate.
+*/
+func NewTaskResolverExec (name string, command ...string) *TaskResolver {
+  var resolver = TaskResolverExec
+  resolver.Id   = "exec-" + name
+  resolver.Name = name
+  resolver.MatchFunc = func (task_name string, s *Spec) (bool, error) {
+    return task_name == name, nil
+  }
+  resolver.TaskPrototype.Func = func (s *Spec, tk *Task) error {
+    if len(command) == 0 {
+      return fmt.Errorf("Exec task %s has no command", tk.Name)
+    }
+    var args = make([]string, len(command))
+    for i, arg := range command {
+      expanded, err := tk.ExpandTemplate(arg)
+      if err != nil { return err }
+      args[i] = expanded
+    }
+    return RunExecPlugin(tk, args[0], args[1:]...)
+  }
+  return &resolver
+}
+
+
+/*
+  ExecMessage is a line of the exec plugin protocol. Which fields
+  are set depends on its Type.
+*/
+type ExecMessage struct {
+  Type      string          `json:"type"`
+  Version   int             `json:"version,omitempty"`
+  Spec      string          `json:"spec,omitempty"`
+  Task      string          `json:"task,omitempty"`
+  Props     map[string]any  `json:"props,omitempty"`
+  Url       string          `json:"url,omitempty"`
+  Mimetype  string          `json:"mimetype,omitempty"`
+  Metadata  map[string]any  `json:"metadata,omitempty"`
+  Content   *string         `json:"content,omitempty"`
+  Text      *string         `json:"text,omitempty"`
+  Message   string          `json:"message,omitempty"`
+}
+
+
+/*
+  RunExecPlugin runs a command as an exec plugin, in the spec's
+  source_dir, sending it the assets of the task, and emitting the
+  assets it returns. Each message is a JSON object on one line.
+  Interbuilder writes, to the plugin's stdin:
+
+    - {"type": "start", "version": 1, "spec", "task", "props"}:
+      First, with the spec's path, the task's name, and the
+      spec's props, merged with those it inherits.
+    - {"type": "asset", "url", "mimetype", "metadata", "content"}:
+      For each asset the task received, with its content encoded
+      as base64.
+    - {"type": "end"}: Last, after which stdin is closed.
+
+  The plugin writes, to its stdout:
+
+    - {"type": "asset", "url", "mimetype", "metadata", "content"}:
+      Emits an asset. "content" is base64, or "text" may be given
+      instead as a string. A "url" which is relative is a key in
+      the spec, and one which names an asset the plugin received
+      emits it, keeping its history, content, and metadata unless
+      they are given. Assets which are not emitted are dropped.
+    - {"type": "log", "message"}: Prints a message.
+    - {"type": "error", "message"}: Fails the task.
+
+  The plugin's stderr is printed, and it fails the task if it
+  exits with an error.
+*/
+func RunExecPlugin (tk *Task, name string, args ...string) error {
+  var inputs = make(map[string]*Asset)
+  var assets []*Asset
+  for _, chunk := range tk.Assets {
+    flattened, err := chunk.FlattenContext(tk.Context())
+    if err != nil { return err }
+    for _, asset := range flattened {
+      assets = append(assets, asset)
+      inputs[asset.Url.String()] = asset
+    }
+  }
+  tk.Assets = nil
+
+  var cmd = tk.Command(name, args...)
+
+  stdin, err := cmd.StdinPipe()
+  if err != nil { return err }
+  stdout, err := cmd.StdoutPipe()
+  if err != nil { return err }
+  stderr := tk.CommandOutput("stderr")
+  cmd.Stderr = stderr
+  defer stderr.Flush()
+
+  if err := cmd.Start(); err != nil {
+    return fmt.Errorf("Cannot start exec plugin %s: %w", name, err)
+  }
+
+  // Write messages concurrently with reading them, so that
+  // plugins which stream their output do not block
+  //
+  var write_err = make(chan error, 1)
+  go func () {
+    write_err <- writeExecInput(stdin, tk, assets)
+  }()
+
+  var plugin_err = readExecOutput(tk, name, stdout, inputs)
+
+  // Let the plugin finish writing before waiting on it, as Wait
+  // closes its stdout
+  //
+  if plugin_err != nil {
+    cmd.Process.Kill()
+  }
+  io.Copy(io.Discard, stdout)
+  stdin.Close()
+
+  var wait_err = cmd.Wait()
+  if plugin_err != nil {
+    return plugin_err
+  }
+  // Plugins need not read each asset, such as those which only
+  // generate assets, so a closed stdin is not an error
+  //
+  if err := <-write_err; err != nil && !errors.Is(err, os.ErrClosed) && !errors.Is(err, syscall.EPIPE) {
+    return fmt.Errorf("Cannot write to exec plugin %s: %w", name, err)
+  }
+  if wait_err != nil {
+    return fmt.Errorf("Exec plugin %s failed: %w", name, wait_err)
+  }
+  return nil
+}
+
+
+/*
+  readExecOutput reads the messages a plugin writes to its stdout,
+  emitting the assets they describe, until the plugin closes its
+  stdout, or a message fails the task. Assets the plugin received
+  are in inputs, by their URL.
+*/
+func readExecOutput (tk *Task, name string, stdout io.Reader, inputs map[string]*Asset) error {
+  var scanner = bufio.NewScanner(stdout)
+  scanner.Buffer(nil, 1 << 30)
+
+  for scanner.Scan() {
+    if len(strings.TrimSpace(scanner.Text())) == 0 {
+      continue
+    }
+    var message ExecMessage
+    if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+      return fmt.Errorf("Cannot parse message from exec plugin %s: %w", name, err)
+    }
+
+    switch message.Type {
+    case "asset":
+      asset, err := execMessageAsset(tk.Spec, inputs, &message)
+      if err == nil {
+        err = tk.EmitAsset(asset)
+      }
+      if err != nil {
+        return err
+      }
+    case "log":
+      tk.Println(message.Message)
+    case "error":
+      return fmt.Errorf("Exec plugin %s failed: %s", name, message.Message)
+    default:
+      return fmt.Errorf("Exec plugin %s sent a message of unknown type %q", name, message.Type)
+    }
+  }
+  return scanner.Err()
+}
+
+
+/*
+  writeExecInput writes the start message, a message for each
+  asset, and the end message to a plugin, and closes its stdin.
+*/
+func writeExecInput (stdin io.WriteCloser, tk *Task, assets []*Asset) error {
+  defer stdin.Close()
+  var encoder = json.NewEncoder(stdin)
+
+  var props = make(map[string]any)
+  for key, value := range inheritedProps(tk.Spec) {
+    if _, err := json.Marshal(value); err == nil {
+      props[key] = value
+    }
+  }
+
+  var start = ExecMessage {
+    Type:    "start",
+    Version: ExecProtocolVersion,
+    Spec:    tk.Spec.SpecPath(),
+    Task:    tk.Name,
+    Props:   props,
+  }
+  if err := encoder.Encode(&start); err != nil {
+    return err
+  }
+
+  for _, asset := range assets {
+    content, err := asset.GetContentBytes()
+    if err != nil { return err }
+    var encoded = base64.StdEncoding.EncodeToString(content)
+
+    var message = ExecMessage {
+      Type:     "asset",
+      Url:      asset.Url.String(),
+      Mimetype: asset.Mimetype,
+      Metadata: asset.Metadata,
+      Content:  &encoded,
+    }
+    if err := encoder.Encode(&message); err != nil {
+      return err
+    }
+  }
+
+  return encoder.Encode(& ExecMessage { Type: "end" })
+}
+
+
+/*
+  execMessageAsset returns the asset an "asset" message emits.
+*/
+func execMessageAsset (s *Spec, inputs map[string]*Asset, message *ExecMessage) (*Asset, error) {
+  if message.Url == "" {
+    return nil, fmt.Errorf("Exec plugin asset message has no url")
+  }
+
+  var asset = inputs[message.Url]
+  if asset == nil {
+    asset_url, err := url.Parse(message.Url)
+    if err != nil {
+      return nil, fmt.Errorf("Cannot parse exec plugin asset url %s: %w", message.Url, err)
+    }
+    if asset_url.IsAbs() {
+      asset = s.MakeAsset()
+      asset.Url = asset_url
+      asset.History.Url = asset_url
+    } else {
+      asset = s.MakeAsset(strings.TrimPrefix(asset_url.Path, "/"))
+    }
+  }
+
+  if message.Mimetype != "" {
+    asset.Mimetype = message.Mimetype
+  }
+  if message.Metadata != nil {
+    asset.Metadata = message.Metadata
+  }
+
+  switch {
+  case message.Content != nil:
+    content, err := base64.StdEncoding.DecodeString(*message.Content)
+    if err != nil {
+      return nil, fmt.Errorf("Cannot decode content of exec plugin asset %s: %w", message.Url, err)
+    }
+    asset.ClearContentCache()
+    asset.SetContentBytes(content)
+  case message.Text != nil:
+    asset.ClearContentCache()
+    asset.SetContentBytes([]byte(*message.Text))
+  }
+
+  return asset, nil
+}
@@ -0,0 +1,125 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+const exec_test_plugin = `
+import base64, json, sys
+
+for line in sys.stdin:
+  message = json.loads(line)
+  if message["type"] == "start":
+    if message["props"].get("fail"):
+      print(json.dumps({ "type": "error", "message": "failed on purpose" }), flush=True)
+      sys.exit(0)
+    greeting = message["props"]["greeting"]
+  elif message["type"] == "asset":
+    if message["mimetype"] != "text/html":
+      continue
+    content = base64.b64decode(message["content"]).decode().upper()
+    print(json.dumps({
+      "type":    "asset",
+      "url":     message["url"],
+      "content": base64.b64encode(content.encode()).decode(),
+    }), flush=True)
+  elif message["type"] == "end":
+    print(json.dumps({ "type": "log", "message": "done" }), flush=True)
+    print(json.dumps({ "type": "asset", "url": "greeting.txt", "text": greeting }), flush=True)
+`
+
+
+func TestTaskExecPlugin (t *testing.T) {
+  if _, err := exec.LookPath("python3"); err != nil {
+    t.Skip("python3 is not installed")
+  }
+
+  var source_dir = t.TempDir()
+  if err := os.WriteFile(filepath.Join(source_dir, "plugin.py"), []byte(exec_test_plugin), 0o644); err != nil {
+    t.Fatal(err)
+  }
+
+  var run = func (props map[string]any) (map[string]string, error) {
+    root := NewSpec("root", nil)
+    site := root.AddSubspec(NewSpec("site", nil))
+
+    root.Props["quiet"]    = true
+    root.Props["greeting"] = "Hello"
+    site.Props["source_dir"] = source_dir
+    site.Props["tasks"] = []any {
+      map[string]any {
+        "name":     "shout",
+        "command":  []any { "python3", "plugin.py" },
+        "protocol": "ndjson",
+        "after":    []any { "emit" },
+      },
+    }
+    for key, value := range props {
+      site.Props[key] = value
+    }
+    site.AddSpecBuilder(BuildConfigTasks)
+
+    site.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+      for key, mimetype := range map[string]string { "index.html": "text/html", "notes.txt": "text/plain" } {
+        asset := s.MakeAsset(key)
+        asset.Mimetype = mimetype
+        asset.SetContentBytes([]byte("<p>" + key + "</p>"))
+        if err := tk.EmitAsset(asset); err != nil {
+          return err
+        }
+      }
+      return nil
+    })
+
+    var received = make(map[string]string)
+    root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+      if err := tk.PoolSpecInputAssets(); err != nil {
+        return err
+      }
+      for _, input := range tk.Assets {
+        assets, err := input.Flatten()
+        if err != nil { return err }
+        for _, asset := range assets {
+          content, err := asset.GetContentBytes()
+          if err != nil { return err }
+          received[strings.TrimPrefix(asset.Url.Path, "@emit/")] = string(content)
+        }
+      }
+      return nil
+    })
+
+    if err := site.Build(); err != nil {
+      t.Fatal(err)
+    }
+    return received, root.Run()
+  }
+
+  received, err := run(nil)
+  if err != nil { t.Fatal(err) }
+
+  var expect = map[string]string {
+    "index.html":   "<P>INDEX.HTML</P>",
+    "greeting.txt": "Hello",
+  }
+  if len(received) != len(expect) {
+    t.Errorf("Expected the assets %v, got %v", expect, received)
+  }
+  for key, content := range expect {
+    if received[key] != content {
+      t.Errorf("Expected %s to be %q, got %q", key, content, received[key])
+    }
+  }
+
+  // Plugins fail the task with an error message
+  //
+  if _, err := run(map[string]any { "fail": true }); err == nil || !strings.Contains(err.Error(), "failed on purpose") {
+    t.Errorf("Expected the plugin's error, got %v", err)
+  }
+}
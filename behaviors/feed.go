@@ -0,0 +1,505 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "golang.org/x/net/html"
+
+  "bytes"
+  "encoding/xml"
+  "fmt"
+  "io"
+  "slices"
+  "strings"
+  "time"
+)
+
+
+const feed_atom_xmlns = "http://www.w3.org/2005/Atom"
+
+
+/*
+  feed_date_layouts are the layouts of date strings accepted from
+  front matter and meta tags, in order of preference.
+*/
+var feed_date_layouts = []string {
+  time.RFC3339,
+  "2006-01-02T15:04:05",
+  "2006-01-02 15:04:05",
+  "2006-01-02",
+  time.RFC1123Z,
+  time.RFC1123,
+}
+
+
+/*
+  TaskResolverEmitFeed resolves the "emit-feed" task, which emits
+  an Atom or RSS feed of the dated HTML and Markdown assets which
+  reach it. It runs after Markdown is rendered, so that rendered
+  documents keep their front matter, and before the root spec's
+  "root-consume" task.
+*/
+var TaskResolverEmitFeed = TaskResolver {
+  Id:   "emit-feed",
+  Name: "emit-feed",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "emit-feed", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_CONSUME | TASK_ASSETS_GENERATE,
+    Func:   TaskEmitFeed,
+    After:  []string { "render-markdown" },
+    Before: []string { "root-consume" },
+  },
+}
+
+
+/*
+  A FeedItem is a document listed in a feed, read from an asset's
+  metadata.
+*/
+type FeedItem struct {
+  Title    string
+  Url      string
+  Date     time.Time
+  Summary  string
+}
+
+
+type AtomLink struct {
+  Href  string  `xml:"href,attr"`
+  Rel   string  `xml:"rel,attr,omitempty"`
+}
+
+
+type AtomEntry struct {
+  Title    string    `xml:"title"`
+  Id       string    `xml:"id"`
+  Link     AtomLink  `xml:"link"`
+  Updated  string    `xml:"updated"`
+  Summary  string    `xml:"summary,omitempty"`
+}
+
+
+type AtomFeed struct {
+  XMLName  xml.Name     `xml:"feed"`
+  Xmlns    string       `xml:"xmlns,attr"`
+  Title    string       `xml:"title"`
+  Id       string       `xml:"id"`
+  Updated  string       `xml:"updated"`
+  Links    []AtomLink   `xml:"link"`
+  Entries  []AtomEntry  `xml:"entry"`
+}
+
+
+type RssItem struct {
+  Title        string  `xml:"title"`
+  Link         string  `xml:"link"`
+  Guid         string  `xml:"guid"`
+  PubDate      string  `xml:"pubDate"`
+  Description  string  `xml:"description,omitempty"`
+}
+
+
+type RssChannel struct {
+  Title        string     `xml:"title"`
+  Link         string     `xml:"link"`
+  Description  string     `xml:"description"`
+  Items        []RssItem  `xml:"item"`
+}
+
+
+type RssFeed struct {
+  XMLName  xml.Name    `xml:"rss"`
+  Version  string      `xml:"version,attr"`
+  Channel  RssChannel  `xml:"channel"`
+}
+
+
+/*
+  BuildTaskEmitFeed defers the "emit-feed" task if the spec's
+  "feed" prop is true, or a path for the feed asset. The default
+  path is "feed.xml".
+*/
+func BuildTaskEmitFeed (s *Spec) error {
+  if s.GetTaskResolverById("emit-feed") == nil {
+    emit := TaskResolverEmitFeed
+    s.AddTaskResolver(&emit)
+  }
+
+  feed_any, found := s.GetProp("feed")
+  if !found {
+    return nil
+  }
+
+  switch feed := feed_any.(type) {
+    case bool:
+      if !feed {
+        return nil
+      }
+    case string:
+      if feed == "" {
+        return fmt.Errorf("Prop \"feed\" in spec %s is an empty path", s.Name)
+      }
+    default:
+      return fmt.Errorf("Prop \"feed\" in spec %s is expected to be a boolean or path, got %T", s.Name, feed_any)
+  }
+
+  task, err := s.GetTask("emit-feed", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the emit-feed task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  feedPath returns the key of the feed asset, from the "feed"
+  prop.
+*/
+func feedPath (s *Spec) string {
+  if feed_path, ok, _ := s.GetPropString("feed"); ok && feed_path != "" {
+    return strings.TrimLeft(feed_path, "/")
+  }
+  return "feed.xml"
+}
+
+
+/*
+  parseFeedDate reads a date from a front matter or meta tag
+  value.
+*/
+func parseFeedDate (value any) (time.Time, bool) {
+  switch value := value.(type) {
+    case time.Time:
+      return value, true
+    case string:
+      value = strings.TrimSpace(value)
+      for _, layout := range feed_date_layouts {
+        if date, err := time.Parse(layout, value); err == nil {
+          return date, true
+        }
+      }
+  }
+  return time.Time {}, false
+}
+
+
+/*
+  htmlHeadMetadata reads metadata from an HTML document's head:
+  its <title>, and the content of <meta> tags, keyed by their name
+  or property attribute.
+*/
+func htmlHeadMetadata (reader io.Reader) (map[string]string, error) {
+  var metadata  = make(map[string]string)
+  var tokenizer = html.NewTokenizer(reader)
+  var in_title  bool
+
+  for {
+    switch tokenizer.Next() {
+      case html.ErrorToken:
+        if err := tokenizer.Err(); err != io.EOF {
+          return nil, err
+        }
+        return metadata, nil
+
+      case html.TextToken:
+        if in_title {
+          metadata["title"] += string(tokenizer.Text())
+        }
+
+      case html.EndTagToken:
+        tag_name, _ := tokenizer.TagName()
+        switch string(tag_name) {
+          case "title":
+            in_title = false
+          case "head":
+            return metadata, nil
+        }
+
+      case html.StartTagToken, html.SelfClosingTagToken:
+        tag_name, has_attributes := tokenizer.TagName()
+        switch string(tag_name) {
+          case "title":
+            in_title = true
+          case "body":
+            return metadata, nil
+          case "meta":
+            var key, content string
+            for has_attributes {
+              var attr_key, attr_value []byte
+              attr_key, attr_value, has_attributes = tokenizer.TagAttr()
+              switch string(attr_key) {
+                case "name", "property":
+                  key = strings.ToLower(string(attr_value))
+                case "content":
+                  content = string(attr_value)
+              }
+            }
+            if key != "" {
+              metadata[key] = content
+            }
+        }
+    }
+  }
+}
+
+
+/*
+  feedItem reads a FeedItem from an asset's Metadata, front matter
+  for Markdown assets, or meta tags for HTML assets. Assets
+  without a date are not feed items, and false is returned.
+*/
+func feedItem (asset_url func (string) string, asset *Asset) (FeedItem, bool, error) {
+  var is_html     = isHtmlAsset(asset)
+  var is_markdown = isMarkdownAsset(asset)
+  if !is_html && !is_markdown {
+    return FeedItem {}, false, nil
+  }
+
+  var metadata = make(map[string]any)
+
+  if is_markdown && asset.Metadata == nil {
+    content, err := asset.GetContentBytes()
+    if err != nil { return FeedItem {}, false, err }
+
+    front_matter, _, err := ParseFrontMatter(content)
+    if err != nil {
+      return FeedItem {}, false, fmt.Errorf("Cannot read front matter of asset %s: %w", asset.Url, err)
+    }
+    for key, value := range front_matter {
+      metadata[key] = value
+    }
+  }
+
+  if is_html {
+    content, err := asset.GetContentBytes()
+    if err != nil { return FeedItem {}, false, err }
+
+    head_metadata, err := htmlHeadMetadata(bytes.NewReader(content))
+    if err != nil {
+      return FeedItem {}, false, fmt.Errorf("Cannot read meta tags of asset %s: %w", asset.Url, err)
+    }
+
+    for _, keys := range [][2]string {
+      { "title",       "title"                  },
+      { "title",       "og:title"               },
+      { "date",        "article:published_time" },
+      { "date",        "date"                   },
+      { "description", "description"            },
+      { "description", "og:description"         },
+    } {
+      if value, found := head_metadata[keys[1]]; found && metadata[keys[0]] == nil {
+        metadata[keys[0]] = strings.TrimSpace(value)
+      }
+    }
+  }
+
+  // Asset metadata, such as rendered front matter, takes
+  // precedence over meta tags
+  //
+  for key, value := range asset.Metadata {
+    metadata[key] = value
+  }
+
+  date, found := parseFeedDate(metadata["date"])
+  if !found {
+    return FeedItem {}, false, nil
+  }
+
+  var item = FeedItem {
+    Url:  asset_url(markdownHtmlPath(assetOutputPath(asset))),
+    Date: date,
+  }
+  item.Title,   _ = metadata["title"].(string)
+  item.Summary, _ = metadata["description"].(string)
+
+  if item.Title == "" {
+    item.Title = item.Url
+  }
+
+  return item, true, nil
+}
+
+
+/*
+  TaskEmitFeed pools the spec's input assets, forwards them, and
+  emits a feed of the HTML and Markdown documents among them which
+  have a date. Titles, dates, and descriptions are read from asset
+  metadata, such as Markdown front matter, and otherwise from the
+  <title> and <meta> tags of HTML documents. Items are ordered by
+  date, newest first, and linked where they are output from the
+  root spec, as in finalOutputPath. It reads the following props:
+
+    - base_url:    Inherited. The absolute URL the site is served
+      from, which asset paths are joined to.
+    - feed:        True, or the path of the feed asset. Defaults to
+      "feed.xml".
+    - feed_format: "atom" or "rss". Defaults to "atom".
+    - feed_title:  The title of the feed. Defaults to the spec's
+      name.
+    - feed_limit:  The most items listed. Defaults to 20, and zero
+      lists every item.
+*/
+func TaskEmitFeed (s *Spec, tk *Task) error {
+  base_url, err := inheritBaseUrl(s)
+  if err != nil { return err }
+
+  var format = "atom"
+  if prop_format, ok, found := s.GetPropString("feed_format"); found && !ok {
+    return fmt.Errorf("Prop \"feed_format\" in spec %s is expected to be a string, got %T", s.Name, s.Props["feed_format"])
+  } else if found {
+    format = strings.ToLower(prop_format)
+  }
+  if format != "atom" && format != "rss" {
+    return fmt.Errorf("Prop \"feed_format\" in spec %s is expected to be \"atom\" or \"rss\", got %q", s.Name, format)
+  }
+
+  var title = s.Name
+  if prop_title, ok, found := s.GetPropString("feed_title"); found && !ok {
+    return fmt.Errorf("Prop \"feed_title\" in spec %s is expected to be a string, got %T", s.Name, s.Props["feed_title"])
+  } else if found && prop_title != "" {
+    title = prop_title
+  }
+
+  var limit = 20
+  if prop_limit, ok, found := s.GetPropInt("feed_limit"); found && !ok {
+    return fmt.Errorf("Prop \"feed_limit\" in spec %s is expected to be an integer, got %T", s.Name, s.Props["feed_limit"])
+  } else if found {
+    limit = prop_limit
+  }
+
+  // Items and the feed itself are linked where they are served,
+  // once the path transformations of the spec and its ancestors
+  // are applied
+  //
+  var feed_path = feedPath(s)
+  var emit_url  = func (asset_path, mimetype string) string {
+    return assetOutputUrl(base_url, finalTransformPath(s, asset_path, mimetype))
+  }
+  var item_url = func (asset_path string) string {
+    return emit_url(asset_path, "text/html")
+  }
+
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets for feed: %w", err)
+  }
+
+  var items []FeedItem
+
+  for _, input := range tk.Assets {
+    assets, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+
+    for _, asset := range assets {
+      item, found, err := feedItem(item_url, asset)
+      if err != nil {
+        return err
+      } else if found {
+        items = append(items, item)
+      }
+    }
+  }
+
+  if err := tk.ForwardAssets(); err != nil {
+    return err
+  }
+
+  slices.SortStableFunc(items, func (a, b FeedItem) int {
+    if c := b.Date.Compare(a.Date); c != 0 {
+      return c
+    }
+    return strings.Compare(a.Url, b.Url)
+  })
+
+  if limit > 0 && len(items) > limit {
+    items = items[:limit]
+  }
+
+  var document any
+  var mimetype string
+
+  var site_url = assetOutputUrl(base_url, "/")
+
+  switch format {
+    case "atom":
+      mimetype = "application/atom+xml"
+      document = atomFeed(title, site_url, emit_url(feed_path, mimetype), items)
+    case "rss":
+      mimetype = "application/rss+xml"
+      document = rssFeed(title, site_url, items)
+  }
+
+  content, err := marshalXmlDocument(document)
+  if err != nil { return err }
+
+  feed_asset := s.MakeAsset(feed_path)
+  feed_asset.Mimetype = mimetype
+  if err := feed_asset.SetContentBytes(content); err != nil {
+    return err
+  }
+
+  return tk.EmitAsset(feed_asset)
+}
+
+
+func atomFeed (title, site_url, feed_url string, items []FeedItem) AtomFeed {
+  var feed = AtomFeed {
+    Xmlns: feed_atom_xmlns,
+    Title: title,
+    Id:    site_url,
+    Links: []AtomLink {
+      { Href: site_url },
+      { Href: feed_url, Rel: "self" },
+    },
+  }
+
+  var updated time.Time
+  for _, item := range items {
+    if item.Date.After(updated) {
+      updated = item.Date
+    }
+
+    feed.Entries = append(feed.Entries, AtomEntry {
+      Title:   item.Title,
+      Id:      item.Url,
+      Link:    AtomLink { Href: item.Url },
+      Updated: item.Date.Format(time.RFC3339),
+      Summary: item.Summary,
+    })
+  }
+
+  if updated.IsZero() {
+    updated = time.Now()
+  }
+  feed.Updated = updated.Format(time.RFC3339)
+
+  return feed
+}
+
+
+func rssFeed (title, site_url string, items []FeedItem) RssFeed {
+  var feed = RssFeed {
+    Version: "2.0",
+    Channel: RssChannel {
+      Title:       title,
+      Link:        site_url,
+      Description: title,
+    },
+  }
+
+  for _, item := range items {
+    feed.Channel.Items = append(feed.Channel.Items, RssItem {
+      Title:       item.Title,
+      Link:        item.Url,
+      Guid:        item.Url,
+      PubDate:     item.Date.Format(time.RFC1123Z),
+      Description: item.Summary,
+    })
+  }
+
+  return feed
+}
@@ -0,0 +1,208 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "encoding/xml"
+  "testing"
+  "strings"
+)
+
+
+func runFeedSpec (t *testing.T, props map[string]any) map[string]*Asset {
+  t.Helper()
+
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"]    = true
+  root.Props["base_url"] = "https://example.com/"
+  for key, value := range props {
+    subspec.Props[key] = value
+  }
+
+  var documents = map[string]string {
+    "posts/first.md": "---\ntitle: First post\ndate: 2024-01-02\n---\n# First\n",
+    "posts/second.html": strings.Join([]string {
+      `<html><head>`,
+      `<title>Second post</title>`,
+      `<meta name="description" content="The second one">`,
+      `<meta property="article:published_time" content="2024-03-04T05:06:07Z">`,
+      `</head><body><meta name="date" content="1999-01-01"></body></html>`,
+    }, "\n"),
+    "posts/third.md": "---\ntitle: Third post\ndate: \"2024-02-01 10:00:00\"\n---\n",
+    "about.html":     "<html><head><title>About</title></head></html>",
+  }
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for key, content := range documents {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes([]byte(content))
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]*Asset)
+
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      asset_path := strings.TrimLeft(asset.Url.Path, "/")
+      received[strings.TrimPrefix(asset_path, "@emit/")] = asset
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTransform)
+  root.AddSpecBuilder(BuildTaskEmitFeed)
+  root.AddSpecBuilder(BuildTaskRenderMarkdown)
+  if err := subspec.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+  return received
+}
+
+
+func TestTaskEmitFeedAtom (t *testing.T) {
+  received := runFeedSpec(t, map[string]any {
+    "feed":       true,
+    "feed_title": "Example",
+    "markdown":   true,
+  })
+
+  if _, found := received["about.html"]; !found {
+    t.Errorf("Expected assets to be forwarded, got %v", received)
+  }
+
+  feed_asset, found := received["feed.xml"]
+  if !found {
+    t.Fatalf("Expected a feed.xml asset, got %v", received)
+  }
+  if got, expect := feed_asset.Mimetype, "application/atom+xml"; got != expect {
+    t.Errorf("Expected MIME type %s, got %s", expect, got)
+  }
+
+  content, err := feed_asset.GetContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  var feed AtomFeed
+  if err := xml.Unmarshal(content, &feed); err != nil {
+    t.Fatal(err)
+  }
+
+  if feed.Title != "Example" || feed.Updated != "2024-03-04T05:06:07Z" {
+    t.Errorf("Unexpected feed title or update time: %q, %q", feed.Title, feed.Updated)
+  }
+
+  var entries []string
+  for _, entry := range feed.Entries {
+    entries = append(entries, entry.Title + " " + entry.Link.Href + " " + entry.Updated)
+  }
+
+  var expect = strings.Join([]string {
+    "Second post https://example.com/posts/second.html 2024-03-04T05:06:07Z",
+    "Third post https://example.com/posts/third.html 2024-02-01T10:00:00Z",
+    "First post https://example.com/posts/first.html 2024-01-02T00:00:00Z",
+  }, "\n")
+
+  if got := strings.Join(entries, "\n"); got != expect {
+    t.Errorf("Expected feed entries:\n%s\ngot:\n%s", expect, got)
+  }
+
+  if got := feed.Entries[0].Summary; got != "The second one" {
+    t.Errorf("Expected a summary from the description meta tag, got %q", got)
+  }
+}
+
+
+func TestTaskEmitFeedTransform (t *testing.T) {
+  received := runFeedSpec(t, map[string]any {
+    "feed":      true,
+    "markdown":  true,
+    "transform": map[string]any { "prefix": "blog" },
+  })
+
+  feed_asset, found := received["blog/feed.xml"]
+  if !found {
+    t.Fatalf("Expected a blog/feed.xml asset, got %v", received)
+  }
+
+  content, err := feed_asset.GetContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  var feed AtomFeed
+  if err := xml.Unmarshal(content, &feed); err != nil {
+    t.Fatal(err)
+  }
+
+  // Entries and the feed's own link are where the spec's path
+  // transformations place them
+  //
+  var links []string
+  for _, entry := range feed.Entries {
+    links = append(links, entry.Link.Href)
+  }
+  for _, link := range feed.Links {
+    links = append(links, link.Href)
+  }
+
+  for _, expect := range []string {
+    "https://example.com/blog/posts/second.html",
+    "https://example.com/blog/posts/first.html",
+    "https://example.com/blog/feed.xml",
+  } {
+    if !strings.Contains(strings.Join(links, " "), expect) {
+      t.Errorf("Expected a feed link to %s, got %v", expect, links)
+    }
+  }
+}
+
+
+func TestTaskEmitFeedRss (t *testing.T) {
+  received := runFeedSpec(t, map[string]any {
+    "feed":        "rss.xml",
+    "feed_format": "rss",
+    "feed_limit":  2,
+  })
+
+  feed_asset, found := received["rss.xml"]
+  if !found {
+    t.Fatalf("Expected an rss.xml asset, got %v", received)
+  }
+
+  content, err := feed_asset.GetContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  var feed RssFeed
+  if err := xml.Unmarshal(content, &feed); err != nil {
+    t.Fatal(err)
+  }
+
+  if got, expect := feed.Channel.Title, "subspec"; got != expect {
+    t.Errorf("Expected the feed title to default to %s, got %s", expect, got)
+  }
+
+  // Without rendering, Markdown front matter is still read, and
+  // linked to its rendered path
+  //
+  var links []string
+  for _, item := range feed.Channel.Items {
+    links = append(links, item.Link)
+  }
+
+  var expect = "https://example.com/posts/second.html https://example.com/posts/third.html"
+  if got := strings.Join(links, " "); got != expect {
+    t.Errorf("Expected feed items %s, got %s", expect, got)
+  }
+
+  if got, expect := feed.Channel.Items[0].PubDate, "Mon, 04 Mar 2024 05:06:07 +0000"; got != expect {
+    t.Errorf("Expected pubDate %s, got %s", expect, got)
+  }
+}
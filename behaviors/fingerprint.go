@@ -0,0 +1,434 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "github.com/tdewolff/parse/v2"
+  "github.com/tdewolff/parse/v2/js"
+  "golang.org/x/net/html"
+
+  "bytes"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/url"
+  "path"
+  "strings"
+)
+
+
+/*
+  fingerprint_mime_prefixes are the MIME type prefixes of static
+  assets which are renamed with a content hash. Documents, such as
+  HTML pages, keep their paths, as they are linked to from
+  outside the site.
+*/
+var fingerprint_mime_prefixes = []string {
+  "text/css",
+  "text/javascript",
+  "application/javascript",
+  "application/wasm",
+  "image/",
+  "font/",
+  "audio/",
+  "video/",
+}
+
+
+/*
+  fingerprint_hash_length is the number of hexadecimal digits of
+  an asset's SHA-256 hash inserted into its name.
+*/
+const fingerprint_hash_length = 10
+
+
+/*
+  TaskResolverFingerprintAssets resolves the "fingerprint-assets"
+  task, which renames static assets to include a hash of their
+  content, such as "style.0123456789.css", and rewrites
+  references to them in HTML, CSS, and JavaScript assets, so that
+  they may be cached indefinitely. It runs after tasks which
+  change asset content, and before the root spec's "root-consume"
+  task.
+*/
+var TaskResolverFingerprintAssets = TaskResolver {
+  Id:   "fingerprint-assets",
+  Name: "fingerprint-assets",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "fingerprint-assets", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_MUTATE | TASK_ASSETS_GENERATE,
+    Func:   TaskFingerprintAssets,
+    After:  []string { "render-markdown", "compile-sass", "minify-css", "minify-js" },
+    Before: []string { "root-consume", "emit-manifest", "emit-sitemap", "emit-feed" },
+  },
+}
+
+
+/*
+  BuildTaskFingerprintAssets defers the "fingerprint-assets" task
+  if the spec's "fingerprint" prop is true.
+*/
+func BuildTaskFingerprintAssets (s *Spec) error {
+  if s.GetTaskResolverById("fingerprint-assets") == nil {
+    fingerprint := TaskResolverFingerprintAssets
+    s.AddTaskResolver(&fingerprint)
+  }
+
+  fingerprint, ok, found := s.GetPropBool("fingerprint")
+  if !found {
+    return nil
+  } else if !ok {
+    return fmt.Errorf("Prop \"fingerprint\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["fingerprint"])
+  } else if !fingerprint {
+    return nil
+  }
+
+  task, err := s.GetTask("fingerprint-assets", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the fingerprint-assets task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  FingerprintPath inserts a hash into a path before its file
+  extension, such as "css/style.<hash>.css".
+*/
+func FingerprintPath (src, hash string) string {
+  var dir, name = path.Split(src)
+  var ext       = path.Ext(name)
+  if ext == name {
+    ext = ""
+  }
+  return dir + strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+
+func fingerprintHash (content []byte) string {
+  sum := sha256.Sum256(content)
+  return hex.EncodeToString(sum[:])[:fingerprint_hash_length]
+}
+
+
+func isFingerprintAsset (a *Asset) bool {
+  var mediatype = minifyMediaType(a)
+  for _, prefix := range fingerprint_mime_prefixes {
+    if strings.HasPrefix(mediatype, prefix) {
+      return true
+    }
+  }
+  return false
+}
+
+
+/*
+  specTransformPath applies a spec's PathTransformations to a
+  path, as they are applied when an asset is emitted from it.
+*/
+func specTransformPath (s *Spec, src, mimetype string) string {
+  for _, transformation := range s.PathTransformations {
+    if transformation.MatchCondition(src, mimetype) {
+      src = transformation.TransformPath(src)
+    }
+  }
+  return src
+}
+
+
+/*
+  JsReaderApplyPathTransformationsTo applies transformations to
+  root-relative paths in JavaScript string literals, such as
+  "/images/logo.png". Relative paths are left alone, as they are
+  resolved against the document which loads the script, rather
+  than the script itself. Returns whether anything was modified.
+*/
+func JsReaderApplyPathTransformationsTo (reader io.Reader, writer io.Writer, base_url *url.URL, transformations []*PathTransformation) (modified bool, err error) {
+  var lexer = js.NewLexer(parse.NewInput(reader))
+  var prev  js.TokenType = js.ErrorToken
+
+  for {
+    token_type, token_data := lexer.Next()
+
+    switch token_type {
+      case js.ErrorToken:
+        if err := lexer.Err(); err != io.EOF {
+          return false, fmt.Errorf("JavaScript error: %w", err)
+        }
+        return modified, nil
+
+      case js.DivToken, js.DivEqToken:
+        // A slash begins a regular expression, rather than a
+        // division, where an operand is expected
+        //
+        if !jsEndsOperand(prev) {
+          token_type, token_data = lexer.RegExp()
+          if token_type == js.ErrorToken {
+            return false, fmt.Errorf("JavaScript error: %w", lexer.Err())
+          }
+        }
+
+      case js.StringToken:
+        if new_string, changed := jsStringTransform(token_data, base_url, transformations); changed {
+          modified   = true
+          token_data = new_string
+        }
+    }
+
+    switch token_type {
+      case js.WhitespaceToken, js.LineTerminatorToken, js.CommentToken, js.CommentLineTerminatorToken:
+      default:
+        prev = token_type
+    }
+
+    writer.Write(token_data)
+  }
+}
+
+
+/*
+  jsEndsOperand returns whether a token can end an operand, after
+  which a slash is a division operator.
+*/
+func jsEndsOperand (tt js.TokenType) bool {
+  switch tt {
+    case js.StringToken, js.TemplateToken, js.TemplateEndToken, js.RegExpToken,
+         js.PrivateIdentifierToken, js.CloseParenToken, js.CloseBracketToken,
+         js.CloseBraceToken, js.ThisToken, js.SuperToken, js.TrueToken,
+         js.FalseToken, js.NullToken:
+      return true
+  }
+  return js.IsNumeric(tt) || js.IsIdentifier(tt)
+}
+
+
+/*
+  jsStringTransform transforms a JavaScript string literal
+  holding a root-relative path. Strings with escape sequences are
+  not modified.
+*/
+func jsStringTransform (literal []byte, base_url *url.URL, transformations []*PathTransformation) ([]byte, bool) {
+  if len(literal) < 2 || bytes.IndexByte(literal, '\\') >= 0 {
+    return literal, false
+  }
+
+  var quote = literal[0]
+  var value = string(literal[1 : len(literal) - 1])
+
+  if !strings.HasPrefix(value, "/") || strings.HasPrefix(value, "//") {
+    return literal, false
+  }
+
+  new_value, changed := TransformUrlReference(base_url, value, transformations)
+  if !changed || strings.IndexByte(new_value, quote) >= 0 {
+    return literal, false
+  }
+
+  return []byte(string(quote) + new_value + string(quote)), true
+}
+
+
+/*
+  fingerprintRewrite rewrites the references in HTML, CSS, or
+  JavaScript content with a rewrite transformation. Other content
+  is returned as-is.
+*/
+func fingerprintRewrite (a *Asset, content []byte, transformations []*PathTransformation) ([]byte, error) {
+  var writer bytes.Buffer
+  var modified bool
+  var err error
+
+  switch mediatype := minifyMediaType(a); {
+    case mediatype == "text/html":
+      var doc *html.Node
+      if doc, err = html.Parse(bytes.NewReader(content)); err != nil {
+        break
+      }
+
+      if modified = HtmlNodeApplyPathTransformations(doc, a.Url, transformations); modified {
+        err = html.Render(&writer, doc)
+      }
+    case mediatype == "text/css":
+      modified, err = CssReaderApplyPathTransformationsTo(bytes.NewReader(content), &writer, a.Url, transformations)
+    case minify_funcs[mediatype] != nil:
+      modified, err = JsReaderApplyPathTransformationsTo(bytes.NewReader(content), &writer, a.Url, transformations)
+  }
+
+  if err != nil {
+    return nil, fmt.Errorf("Cannot rewrite references in asset %s: %w", a.Url, err)
+  } else if !modified {
+    return content, nil
+  }
+  return writer.Bytes(), nil
+}
+
+
+/*
+  TaskFingerprintAssets pools the spec's input assets, renames
+  static assets, such as stylesheets, scripts, images, and fonts,
+  to include a hash of their content, and rewrites references to
+  them in HTML, CSS, and JavaScript. It reads the following props:
+
+    - fingerprint_exclude: A glob pattern, or list of them, of
+      asset paths which keep their names, such as "/favicon.ico".
+    - fingerprint_map:     If defined, the path of a JSON asset
+      mapping original paths to fingerprinted paths, in the same
+      format as a transform rewrite map file.
+
+  Stylesheets and scripts are hashed after their references are
+  rewritten, so that a change to an image also changes the names
+  of the stylesheets which use it. References are rewritten until
+  every hash is stable, and circular references between hashed
+  assets are an error.
+*/
+func TaskFingerprintAssets (s *Spec, tk *Task) error {
+  exclude_patterns, err := getPropPathPatterns(s, "fingerprint_exclude")
+  if err != nil { return err }
+
+  map_path, ok, found := s.GetPropString("fingerprint_map")
+  if found && !ok {
+    return fmt.Errorf("Prop \"fingerprint_map\" in spec %s is expected to be a string, got %T", s.Name, s.Props["fingerprint_map"])
+  }
+
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets for fingerprinting: %w", err)
+  }
+
+  var assets []*Asset
+  for _, input := range tk.Assets {
+    flattened, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+    assets = append(assets, flattened...)
+  }
+
+  var contents      = make(map[*Asset][]byte)
+  var fingerprints  = make(map[*Asset]bool)
+  var rewritable    []*Asset
+  var is_rewritable = make(map[*Asset]bool)
+
+  for _, asset := range assets {
+    var asset_path = assetOutputPath(asset)
+
+    switch mediatype := minifyMediaType(asset); {
+      case mediatype == "text/html", mediatype == "text/css", minify_funcs[mediatype] != nil:
+        rewritable = append(rewritable, asset)
+        is_rewritable[asset] = true
+      case !isFingerprintAsset(asset):
+        continue
+    }
+
+    content, err := asset.GetContentBytes()
+    if err != nil { return err }
+    contents[asset] = content
+
+    if isFingerprintAsset(asset) && !matchPathPatterns(exclude_patterns, asset_path) {
+      fingerprints[asset] = true
+    }
+  }
+
+  // Map each fingerprinted asset's path to its new path, both as
+  // it is within this spec and as it is once emitted, as
+  // references in content may already have had the spec's
+  // PathTransformations applied
+  //
+  var rewrite_map = make(map[string]string)
+  var emit_map    = make(map[string]string)
+
+  var setFingerprint = func (asset *Asset, content []byte) bool {
+    var old_path = strings.TrimLeft(asset.Url.Path, "/")
+    var new_path = FingerprintPath(old_path, fingerprintHash(content))
+    if rewrite_map[old_path] == new_path {
+      return false
+    }
+
+    var emit_old = specTransformPath(s, old_path, asset.Mimetype)
+    var emit_new = specTransformPath(s, new_path, asset.Mimetype)
+
+    rewrite_map[old_path] = new_path
+    rewrite_map[emit_old] = emit_new
+    emit_map[emit_old]    = emit_new
+    return true
+  }
+
+  for asset := range fingerprints {
+    if !is_rewritable[asset] {
+      setFingerprint(asset, contents[asset])
+    }
+  }
+
+  var rewrite = PathTransformation { RewriteMap: rewrite_map }
+  var rewritten = make(map[*Asset][]byte)
+
+  for iteration := 0 ; ; iteration++ {
+    if iteration > len(rewritable) {
+      return fmt.Errorf("Cannot fingerprint assets in spec %s with circular references", s.Name)
+    }
+
+    var changed bool
+
+    for _, asset := range rewritable {
+      content, err := fingerprintRewrite(asset, contents[asset], []*PathTransformation { &rewrite })
+      if err != nil { return err }
+      rewritten[asset] = content
+
+      if fingerprints[asset] && setFingerprint(asset, content) {
+        changed = true
+      }
+    }
+
+    if !changed {
+      break
+    }
+  }
+
+  // Apply the new content and paths
+  //
+  for _, asset := range rewritable {
+    if !bytes.Equal(rewritten[asset], contents[asset]) {
+      asset.ClearContentDataCache()
+      if err := asset.SetContentBytes(rewritten[asset]); err != nil {
+        return err
+      }
+    }
+  }
+
+  for asset := range fingerprints {
+    var old_path = strings.TrimLeft(asset.Url.Path, "/")
+
+    var new_url = *asset.Url
+    new_url.Path    = "/" + rewrite_map[old_path]
+    new_url.RawPath = ""
+    asset.Url       = &new_url
+
+    if asset.Metadata == nil {
+      asset.Metadata = make(map[string]any)
+    }
+    asset.Metadata["fingerprint_source"] = "/" + old_path
+  }
+
+  if err := tk.EmitAssets(assets); err != nil {
+    return err
+  }
+
+  if map_path == "" {
+    return nil
+  }
+
+  map_json, err := json.MarshalIndent(emit_map, "", "  ")
+  if err != nil { return err }
+
+  map_asset := s.MakeAsset(strings.TrimLeft(map_path, "/"))
+  map_asset.Mimetype = "application/json"
+  if err := map_asset.SetContentBytes(map_json); err != nil {
+    return err
+  }
+
+  return tk.EmitAsset(map_asset)
+}
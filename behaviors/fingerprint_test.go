@@ -0,0 +1,212 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bytes"
+  "encoding/json"
+  "net/url"
+  "regexp"
+  "strings"
+  "testing"
+)
+
+
+func TestFingerprintPath (t *testing.T) {
+  var test_cases = map[string]string {
+    "css/style.css":    "css/style.abc.css",
+    "app.min.js":       "app.min.abc.js",
+    "LICENSE":          "LICENSE.abc",
+    "/img/.hidden":     "/img/.hidden.abc",
+  }
+
+  for src, expect := range test_cases {
+    if got := FingerprintPath(src, "abc"); got != expect {
+      t.Errorf("Expected %s to become %s, got %s", src, expect, got)
+    }
+  }
+}
+
+
+func TestJsReaderApplyPathTransformations (t *testing.T) {
+  base_url, _ := url.Parse("ib://spec/js/app.js")
+  rewrite := PathTransformation {
+    RewriteMap: map[string]string { "img/a.png": "img/a.123.png" },
+  }
+
+  var src = strings.Join([]string {
+    `var a = "/img/a.png", b = 'img/a.png', c = "/img/a\x2epng";`,
+    `var r = /"\/img\/a.png"/g, d = a / 2 / "/img/a.png".length;`,
+  }, "\n")
+
+  var expect = strings.Join([]string {
+    `var a = "/img/a.123.png", b = 'img/a.png', c = "/img/a\x2epng";`,
+    `var r = /"\/img\/a.png"/g, d = a / 2 / "/img/a.123.png".length;`,
+  }, "\n")
+
+  var writer bytes.Buffer
+  modified, err := JsReaderApplyPathTransformationsTo(strings.NewReader(src), &writer, base_url, []*PathTransformation { &rewrite })
+  if err != nil {
+    t.Fatal(err)
+  } else if !modified {
+    t.Error("Expected the script to be modified")
+  }
+
+  if got := writer.String(); got != expect {
+    t.Errorf("Expected:\n%s\ngot:\n%s", expect, got)
+  }
+}
+
+
+func runFingerprintSpec (t *testing.T, props map[string]any, documents map[string]string) (map[string]*Asset, error) {
+  t.Helper()
+
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"] = true
+  for key, value := range props {
+    subspec.Props[key] = value
+  }
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for key, content := range documents {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes([]byte(content))
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]*Asset)
+
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      asset_path := strings.TrimLeft(asset.Url.Path, "/")
+      received[strings.TrimPrefix(asset_path, "@emit/")] = asset
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTaskFingerprintAssets)
+  if err := subspec.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  return received, root.Run()
+}
+
+
+func TestTaskFingerprintAssets (t *testing.T) {
+  var received map[string]*Asset
+
+  TestWrapTimeoutError(t, func () (err error) {
+    received, err = runFingerprintSpec(t,
+      map[string]any {
+        "fingerprint":         true,
+        "fingerprint_exclude": "/favicon.ico",
+        "fingerprint_map":     "fingerprints.json",
+      },
+      map[string]string {
+        "index.html":      `<link href="css/style.css" rel="stylesheet"><script src="/js/app.js"></script><img src="img/logo.png"><link rel="icon" href="/favicon.ico">`,
+        "css/style.css":   `body { background: url("../img/logo.png") }`,
+        "js/app.js":       `fetch("/css/style.css")`,
+        "img/logo.png":    "PNG",
+        "favicon.ico":     "ICO",
+      },
+    )
+    return err
+  })
+
+  var paths []string
+  for key := range received {
+    paths = append(paths, key)
+  }
+
+  // find returns the path of the received asset matching a
+  // pattern, and its content
+  //
+  var find = func (pattern string) (string, string) {
+    t.Helper()
+    var matcher = regexp.MustCompile(`^` + pattern + `$`)
+    for key, asset := range received {
+      if !matcher.MatchString(key) {
+        continue
+      }
+      content, err := asset.GetContentBytes()
+      if err != nil { t.Fatal(err) }
+      return key, string(content)
+    }
+    t.Fatalf("Expected an asset matching %s, got %v", pattern, paths)
+    return "", ""
+  }
+
+  logo_path,  _           := find(`img/logo\.[0-9a-f]{10}\.png`)
+  style_path, style       := find(`css/style\.[0-9a-f]{10}\.css`)
+  app_path,   app         := find(`js/app\.[0-9a-f]{10}\.js`)
+  _,          index       := find(`index\.html`)
+  _,          mapping_src := find(`fingerprints\.json`)
+  find(`favicon\.ico`)
+
+  if got := received[logo_path].Metadata["fingerprint_source"]; got != "/img/logo.png" {
+    t.Errorf("Expected the fingerprint source in metadata, got %v", got)
+  }
+
+  if expect := `url("/` + logo_path + `")`; !strings.Contains(style, expect) {
+    t.Errorf("Expected the stylesheet to contain %s, got %s", expect, style)
+  }
+
+  // The stylesheet is hashed after its references are rewritten
+  //
+  if expect := FingerprintPath("css/style.css", fingerprintHash([]byte(style))); style_path != expect {
+    t.Errorf("Expected the stylesheet at %s, got %s", expect, style_path)
+  }
+
+  if expect := `fetch("/` + style_path + `")`; app != expect {
+    t.Errorf("Expected the script to be %s, got %s", expect, app)
+  }
+
+  for _, expect := range []string {
+    `href="/` + style_path + `"`,
+    `src="/` + app_path + `"`,
+    `src="/` + logo_path + `"`,
+    `href="/favicon.ico"`,
+  } {
+    if !strings.Contains(index, expect) {
+      t.Errorf("Expected index.html to contain %s, got %s", expect, index)
+    }
+  }
+
+  var mapping map[string]string
+  if err := json.Unmarshal([]byte(mapping_src), &mapping); err != nil {
+    t.Fatal(err)
+  }
+  if got := mapping["css/style.css"]; got != style_path {
+    t.Errorf("Expected the fingerprint map to contain css/style.css, got %v", mapping)
+  }
+  if _, found := mapping["favicon.ico"]; found {
+    t.Errorf("Expected excluded assets to be left out of the fingerprint map, got %v", mapping)
+  }
+}
+
+
+func TestTaskFingerprintAssetsCircular (t *testing.T) {
+  TestWrapTimeoutError(t, func () error {
+    _, err := runFingerprintSpec(t,
+      map[string]any { "fingerprint": true },
+      map[string]string {
+        "a.css": `@font-face { src: url("b.css") }`,
+        "b.css": `@font-face { src: url("a.css") }`,
+      },
+    )
+    if err == nil || !strings.Contains(err.Error(), "circular") {
+      t.Errorf("Expected an error about circular references, got %v", err)
+    }
+    return nil
+  })
+}
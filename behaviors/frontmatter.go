@@ -0,0 +1,184 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bytes"
+  "fmt"
+  "maps"
+
+  "github.com/BurntSushi/toml"
+  "gopkg.in/yaml.v3"
+)
+
+
+/*
+  TaskResolverExtractFrontMatter resolves the "extract-front-matter"
+  task, which moves the front matter of Markdown and HTML assets
+  into their Metadata, for later tasks such as feeds, sitemaps, and
+  templates to read.
+*/
+var TaskResolverExtractFrontMatter = TaskResolver {
+  Id:   "extract-front-matter",
+  Name: "extract-front-matter",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "extract-front-matter", nil
+  },
+  TaskPrototype: Task {
+    Mask:      TASK_ASSETS_MUTATE,
+    MatchFunc: func (tk *Task, a *Asset) (bool, error) {
+      return isMarkdownAsset(a) || isHtmlAsset(a), nil
+    },
+    MapFunc: TaskMapExtractFrontMatter,
+    Before:  []string { "render-markdown", "canonical-urls", "emit-feed", "emit-sitemap", "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskExtractFrontMatter defers the "extract-front-matter"
+  task if the spec's "front_matter" prop is true.
+*/
+func BuildTaskExtractFrontMatter (s *Spec) error {
+  if s.GetTaskResolverById("extract-front-matter") == nil {
+    extract := TaskResolverExtractFrontMatter
+    s.AddTaskResolver(&extract)
+  }
+
+  front_matter, ok, found := s.GetPropBool("front_matter")
+  if !found {
+    return nil
+  } else if !ok {
+    return fmt.Errorf("Prop \"front_matter\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["front_matter"])
+  } else if !front_matter {
+    return nil
+  }
+
+  task, err := s.GetTask("extract-front-matter", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the extract-front-matter task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  TaskMapExtractFrontMatter strips the front matter from an asset's
+  content, and adds its fields to the asset's Metadata. Assets
+  without front matter are returned as-is.
+*/
+func TaskMapExtractFrontMatter (a *Asset) (*Asset, error) {
+  content, err := a.GetContentBytes()
+  if err != nil { return nil, err }
+
+  front_matter, rest, err := ParseFrontMatter(content)
+  if err != nil {
+    return nil, fmt.Errorf("Cannot extract front matter of asset %s: %w", a.Url, err)
+  } else if front_matter == nil {
+    return a, nil
+  }
+
+  // Metadata may be shared with the assets this was annexed from
+  //
+  var metadata = maps.Clone(a.Metadata)
+  if metadata == nil {
+    metadata = make(map[string]any, len(front_matter))
+  }
+  for key, value := range front_matter {
+    metadata[key] = value
+  }
+  a.Metadata = metadata
+
+  a.ClearContentDataCache()
+  if err := a.SetContentBytes(rest); err != nil {
+    return nil, err
+  }
+
+  return a, nil
+}
+
+
+/*
+  ParseFrontMatter splits front matter from the beginning of
+  content: YAML delimited by lines of "---", or TOML delimited by
+  lines of "+++". It returns the parsed front matter, or nil if
+  there is none, and the remaining content.
+*/
+func ParseFrontMatter (content []byte) (map[string]any, []byte, error) {
+  var delimiter = "---"
+  var rest, found = cutFrontMatterDelimiter(content, delimiter)
+  if !found {
+    delimiter = "+++"
+    if rest, found = cutFrontMatterDelimiter(content, delimiter); !found {
+      return nil, content, nil
+    }
+  }
+
+  // Find the closing delimiter, on a line of its own
+  //
+  var front_matter []byte
+  for offset := 0 ; ; {
+    line_end := bytes.IndexByte(rest[offset:], '\n')
+
+    var line []byte
+    if line_end < 0 {
+      line = rest[offset:]
+    } else {
+      line = rest[offset : offset + line_end]
+    }
+
+    if string(bytes.TrimRight(line, " \t\r")) == delimiter {
+      front_matter = rest[:offset]
+      if line_end < 0 {
+        rest = nil
+      } else {
+        rest = rest[offset + line_end + 1:]
+      }
+      break
+    }
+
+    if line_end < 0 {
+      return nil, content, fmt.Errorf("Front matter is not closed with a \"%s\" line", delimiter)
+    }
+    offset += line_end + 1
+  }
+
+  var metadata map[string]any
+  if delimiter == "+++" {
+    if err := toml.Unmarshal(front_matter, &metadata); err != nil {
+      return nil, content, fmt.Errorf("Cannot parse TOML front matter: %w", err)
+    }
+  } else if err := yaml.Unmarshal(front_matter, &metadata); err != nil {
+    return nil, content, fmt.Errorf("Cannot parse YAML front matter: %w", err)
+  }
+
+  if metadata == nil {
+    metadata = make(map[string]any)
+  }
+
+  return metadata, rest, nil
+}
+
+
+/*
+  cutFrontMatterDelimiter returns the content after an opening
+  front matter delimiter line, and whether content begins with
+  one.
+*/
+func cutFrontMatterDelimiter (content []byte, delimiter string) ([]byte, bool) {
+  content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+
+  rest, found := bytes.CutPrefix(content, []byte(delimiter))
+  if !found {
+    return content, false
+  }
+
+  rest = bytes.TrimLeft(rest, " \t")
+  if rest, found = bytes.CutPrefix(rest, []byte("\r\n")); found {
+    return rest, true
+  }
+  return bytes.CutPrefix(rest, []byte("\n"))
+}
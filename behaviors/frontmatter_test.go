@@ -0,0 +1,159 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "testing"
+  "reflect"
+  "strings"
+)
+
+
+func TestParseFrontMatter (t *testing.T) {
+  var cases = []struct {
+    Name     string
+    Content  string
+    Metadata map[string]any
+    Rest     string
+    Error    bool
+  } {
+    {
+      Name:    "none",
+      Content: "# Title\n",
+      Rest:    "# Title\n",
+    },
+    {
+      Name:     "yaml",
+      Content:  "---\ntitle: Hello\ndraft: true\n---\n# Title\n",
+      Metadata: map[string]any { "title": "Hello", "draft": true },
+      Rest:     "# Title\n",
+    },
+    {
+      Name:     "empty",
+      Content:  "---\n---\nBody",
+      Metadata: map[string]any {},
+      Rest:     "Body",
+    },
+    {
+      Name:     "windows line endings",
+      Content:  "---\r\ntitle: Hello\r\n---\r\nBody",
+      Metadata: map[string]any { "title": "Hello" },
+      Rest:     "Body",
+    },
+    {
+      Name:    "horizontal rule",
+      Content: "----\nBody",
+      Rest:    "----\nBody",
+    },
+    {
+      Name:     "toml",
+      Content:  "+++\ntitle = \"Hello\"\ntags = [\"a\"]\n+++\nBody",
+      Metadata: map[string]any { "title": "Hello", "tags": []any { "a" } },
+      Rest:     "Body",
+    },
+    {
+      Name:    "unclosed toml",
+      Content: "+++\ntitle = \"Hello\"\n---\n",
+      Error:   true,
+    },
+    {
+      Name:    "unclosed",
+      Content: "---\ntitle: Hello\n",
+      Error:   true,
+    },
+  }
+
+  for _, c := range cases {
+    t.Run(c.Name, func (t *testing.T) {
+      metadata, rest, err := ParseFrontMatter([]byte(c.Content))
+
+      if c.Error {
+        if err == nil {
+          t.Errorf("Expected an error")
+        }
+        return
+      } else if err != nil {
+        t.Fatal(err)
+      }
+
+      if !reflect.DeepEqual(metadata, c.Metadata) {
+        t.Errorf("Expected front matter %v, got %v", c.Metadata, metadata)
+      }
+      if string(rest) != c.Rest {
+        t.Errorf("Expected remaining content %q, got %q", c.Rest, rest)
+      }
+    })
+  }
+}
+
+
+func TestTaskExtractFrontMatter (t *testing.T) {
+  root := NewSpec("root", nil)
+  site := root.AddSubspec(NewSpec("site", nil))
+
+  root.Props["quiet"]        = true
+  site.Props["front_matter"] = true
+
+  var documents = map[string]string {
+    "post.md":    "---\ntitle: Post\n---\n# Post\n",
+    "page.html":  "+++\ntitle = \"Page\"\n+++\n<p>Page</p>",
+    "plain.html": "<p>Plain</p>",
+    "data.txt":   "---\ntitle: Text\n---\n",
+  }
+
+  site.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for key, content := range documents {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes([]byte(content))
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]*Asset)
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, input := range tk.Assets {
+      assets, err := input.Flatten()
+      if err != nil { return err }
+      for _, asset := range assets {
+        received[strings.TrimPrefix(asset.Url.Path, "@emit/")] = asset
+      }
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTaskExtractFrontMatter)
+  if err := site.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var expect = map[string]struct { Content string; Title any } {
+    "post.md":    { "# Post\n", "Post" },
+    "page.html":  { "<p>Page</p>", "Page" },
+    "plain.html": { "<p>Plain</p>", nil },
+    "data.txt":   { documents["data.txt"], nil },
+  }
+
+  for key, expected := range expect {
+    asset, found := received[key]
+    if !found {
+      t.Errorf("Expected an asset at %s", key)
+      continue
+    }
+    content, err := asset.GetContentBytes()
+    if err != nil { t.Fatal(err) }
+    if string(content) != expected.Content {
+      t.Errorf("Expected %s to have the content %q, got %q", key, expected.Content, content)
+    }
+    if title := asset.Metadata["title"]; title != expected.Title {
+      t.Errorf("Expected %s to have the title %v, got %v", key, expected.Title, title)
+    }
+  }
+}
@@ -0,0 +1,191 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "crypto/sha256"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "os"
+  "path"
+  "path/filepath"
+  "regexp"
+  "strings"
+  "sync"
+  "time"
+)
+
+
+/*
+  GitCacheLockTimeout is the age after which a git cache lock file
+  is assumed to have been left behind by a process which exited
+  without removing it, and is removed.
+*/
+var GitCacheLockTimeout = 10 * time.Minute
+
+
+var path_mutexes sync.Map
+
+
+/*
+  lockPathMutex locks a mutex for a path, shared by tasks in this
+  process, and returns a function which unlocks it.
+*/
+func lockPathMutex (lock_path string) func () {
+  mutex_any, _ := path_mutexes.LoadOrStore(lock_path, &sync.Mutex {})
+  mutex := mutex_any.(*sync.Mutex)
+  mutex.Lock()
+  return mutex.Unlock
+}
+
+
+/*
+  lockSourceDir locks the path mutex of a spec's source_dir, so
+  that tasks installing into the same directory, such as specs
+  sharing a source, run one at a time, while tasks in other
+  directories run concurrently. It returns a function which
+  unlocks it.
+*/
+func lockSourceDir (s *Spec) (func (), error) {
+  source_dir, err := s.RequirePropString("source_dir")
+  if err != nil { return nil, err }
+
+  source_dir, err = filepath.Abs(source_dir)
+  if err != nil { return nil, err }
+
+  return lockPathMutex(source_dir), nil
+}
+
+
+/*
+  lockFile locks a path for this process, and for other processes
+  by exclusively creating a lock file, waiting while either is held.
+  It returns a function which removes the lock file and unlocks the
+  path.
+*/
+func lockFile (lock_path string) (func (), error) {
+  unlock_mutex := lockPathMutex(lock_path)
+
+  if err := os.MkdirAll(filepath.Dir(lock_path), os.ModePerm); err != nil {
+    unlock_mutex()
+    return nil, err
+  }
+
+  for {
+    file, err := os.OpenFile(lock_path, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0o644)
+    if err == nil {
+      fmt.Fprintln(file, os.Getpid())
+      file.Close()
+      return func () {
+        os.Remove(lock_path)
+        unlock_mutex()
+      }, nil
+    }
+
+    if !errors.Is(err, os.ErrExist) {
+      unlock_mutex()
+      return nil, err
+    }
+
+    if info, err := os.Stat(lock_path); err == nil && time.Since(info.ModTime()) > GitCacheLockTimeout {
+      os.Remove(lock_path)
+      continue
+    }
+    time.Sleep(100 * time.Millisecond)
+  }
+}
+
+
+/*
+  gitCacheDir returns the git clone cache directory set by the
+  inherited "git_cache" prop, or an empty string if there is none.
+  A relative path is resolved against the source_dir of the spec
+  which sets the prop, and true selects "interbuilder/git" in the
+  user's cache directory.
+*/
+func gitCacheDir (s *Spec) (string, error) {
+  var owner = s
+  for owner.Parent != nil {
+    if _, found := owner.Props["git_cache"]; found {
+      break
+    }
+    owner = owner.Parent
+  }
+
+  switch cache := owner.Props["git_cache"].(type) {
+  case nil:
+    return "", nil
+
+  case bool:
+    if !cache {
+      return "", nil
+    }
+    user_cache_dir, err := os.UserCacheDir()
+    if err != nil {
+      return "", fmt.Errorf("Cannot find a cache directory for git_cache in spec %s: %w", s.Name, err)
+    }
+    return filepath.Join(user_cache_dir, "interbuilder", "git"), nil
+
+  case string:
+    if cache == "" {
+      return "", nil
+    }
+    if !filepath.IsAbs(cache) {
+      source_dir, _, _ := owner.InheritPropString("source_dir")
+      cache = filepath.Join(source_dir, cache)
+    }
+    return filepath.Abs(cache)
+  }
+
+  return "", fmt.Errorf("Prop \"git_cache\" in spec %s is expected to be a path or boolean, got %T", owner.Name, owner.Props["git_cache"])
+}
+
+
+var git_mirror_name_pattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+
+/*
+  GitMirrorPath returns the path of a repository's mirror in a
+  cache directory. Mirrors are named after the repository, with a
+  hash of its URL, so that repositories with the same name do not
+  share a mirror.
+*/
+func GitMirrorPath (cache_dir, repository string) string {
+  var name = strings.TrimSuffix(path.Base(strings.TrimRight(repository, "/")), ".git")
+  name = strings.Trim(git_mirror_name_pattern.ReplaceAllString(name, "-"), "-.")
+  if name == "" {
+    name = "repository"
+  }
+
+  var hash = sha256.Sum256([]byte(repository))
+  return filepath.Join(cache_dir, name + "-" + hex.EncodeToString(hash[:])[:12] + ".git")
+}
+
+
+/*
+  gitUpdateMirror creates a mirror of a repository, or fetches its
+  changes if it already exists. New mirrors are cloned to a
+  temporary directory and then renamed, so that an interrupted
+  clone does not leave an incomplete mirror. The mirror should be
+  locked with lockFile.
+*/
+func gitUpdateMirror (t *Task, git, repository, mirror string) error {
+  if _, err := os.Stat(mirror); err == nil {
+    if _, err := t.CommandRun(git, "-C", mirror, "fetch", "--quiet", "--prune", "origin"); err != nil {
+      return fmt.Errorf("Cannot update the git cache of %s: %w", repository, err)
+    }
+    return nil
+  } else if !os.IsNotExist(err) {
+    return err
+  }
+
+  temporary_mirror, err := os.MkdirTemp(filepath.Dir(mirror), filepath.Base(mirror) + ".tmp-")
+  if err != nil { return err }
+  defer os.RemoveAll(temporary_mirror)
+
+  if _, err := t.CommandRun(git, "clone", "--quiet", "--mirror", "--", repository, temporary_mirror); err != nil {
+    return fmt.Errorf("Cannot cache %s: %w", repository, err)
+  }
+  return os.Rename(temporary_mirror, mirror)
+}
@@ -0,0 +1,162 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+
+func TestTaskSourceGitCloneCache (t *testing.T) {
+  if _, err := exec.LookPath("git"); err != nil {
+    t.Skip("git is not installed")
+  }
+
+  var dir        = t.TempDir()
+  var repository = filepath.Join(dir, "site.git")
+  var source     = "file://" + filepath.ToSlash(repository)
+
+  // commit commits an index.html to the repository
+  //
+  var commit = func (content string) {
+    t.Helper()
+    if err := os.WriteFile(filepath.Join(repository, "index.html"), []byte(content), 0o644); err != nil {
+      t.Fatal(err)
+    }
+    for _, args := range [][]string { { "add", "index.html" }, { "commit", "--quiet", "-m", content } } {
+      cmd := exec.Command("git", append([]string { "-c", "user.name=Test", "-c", "user.email=test@example.com", "-C", repository }, args...)...)
+      if output, err := cmd.CombinedOutput(); err != nil {
+        t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, output)
+      }
+    }
+  }
+
+  if output, err := exec.Command("git", "init", "--quiet", repository).CombinedOutput(); err != nil {
+    t.Fatalf("Cannot create a repository: %v: %s", err, output)
+  }
+  commit("one")
+
+  // clone clones the repository in sibling specs, which share a
+  // relative cache directory
+  //
+  var clone = func (names ...string) {
+    t.Helper()
+
+    root := NewSpec("root", nil)
+    root.Props["quiet"]      = true
+    root.Props["source_dir"] = dir
+    root.Props["git_cache"]  = "cache"
+
+    for _, name := range names {
+      subspec := root.AddSubspec(NewSpec(name, nil))
+      subspec.Props["source"]     = source
+      subspec.Props["source_dir"] = filepath.Join(dir, name)
+
+      if err := BuildSourceURLType(subspec); err != nil {
+        t.Fatal(err)
+      }
+      if err := subspec.EnqueueTaskFunc("git-clone", TaskSourceGitClone); err != nil {
+        t.Fatal(err)
+      }
+    }
+
+    TestWrapTimeoutError(t, root.Run)
+  }
+
+  var expectContent = func (name, expect string) {
+    t.Helper()
+    content, err := os.ReadFile(filepath.Join(dir, name, "index.html"))
+    if err != nil || string(content) != expect {
+      t.Errorf("Expected %s to check out %q, got %q, %v", name, expect, content, err)
+    }
+    if _, err := os.Stat(filepath.Join(dir, name, ".git", "objects", "info", "alternates")); !os.IsNotExist(err) {
+      t.Errorf("Expected %s not to depend on the cache, got %v", name, err)
+    }
+  }
+
+  clone("a", "b")
+  expectContent("a", "one")
+  expectContent("b", "one")
+
+  cache_entries, err := os.ReadDir(filepath.Join(dir, "cache"))
+  if err != nil { t.Fatal(err) }
+  if len(cache_entries) != 1 || cache_entries[0].Name() != filepath.Base(GitMirrorPath("", source)) {
+    t.Fatalf("Expected one mirror and no lock files in the cache, got %v", cache_entries)
+  }
+
+  // The mirror is updated for later clones
+  //
+  commit("two")
+  clone("c")
+  expectContent("c", "two")
+}
+
+
+func TestGitMirrorPath (t *testing.T) {
+  var a = GitMirrorPath("/cache", "https://example.com/user/site.git")
+  var b = GitMirrorPath("/cache", "https://example.org/user/site.git")
+
+  if !strings.HasPrefix(a, "/cache/site-") || !strings.HasSuffix(a, ".git") {
+    t.Errorf("Expected the mirror to be named after the repository, got %s", a)
+  }
+  if a == b {
+    t.Errorf("Expected repositories on different hosts to have different mirrors, got %s", a)
+  }
+  if c := GitMirrorPath("/cache", "https://example.com/"); !strings.HasPrefix(c, "/cache/example.com-") {
+    t.Errorf("Expected a mirror of a repository without a path to be named after its host, got %s", c)
+  }
+}
+
+
+func TestLockSourceDir (t *testing.T) {
+  var shared_dir = t.TempDir()
+
+  var a = NewSpec("a", nil)
+  var b = NewSpec("b", nil)
+  var c = NewSpec("c", nil)
+  a.Props["source_dir"] = shared_dir
+  b.Props["source_dir"] = shared_dir + "/"
+  c.Props["source_dir"] = t.TempDir()
+
+  unlock_a, err := lockSourceDir(a)
+  if err != nil { t.Fatal(err) }
+
+  // Specs in other directories are not blocked
+  //
+  TestWrapTimeout(t, func () {
+    unlock_c, err := lockSourceDir(c)
+    if err != nil { t.Fatal(err) }
+    unlock_c()
+  })
+
+  // Specs in the same directory wait for the lock to be released
+  //
+  var locked_b = make(chan struct{})
+  go func () {
+    unlock_b, err := lockSourceDir(b)
+    if err != nil {
+      t.Error(err)
+    } else {
+      unlock_b()
+    }
+    close(locked_b)
+  }()
+
+  select {
+  case <-locked_b:
+    t.Fatalf("Expected a spec with the same source_dir to wait for its lock")
+  case <-time.After(20 * time.Millisecond):
+  }
+
+  unlock_a()
+  TestWrapTimeout(t, func () { <-locked_b })
+
+  if _, err := lockSourceDir(NewSpec("no-source", nil)); err == nil {
+    t.Errorf("Expected an error locking a spec without a source_dir")
+  }
+}
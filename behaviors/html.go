@@ -3,9 +3,11 @@ package behaviors
 import (
   . "gilchrist.tech/interbuilder"
   "net/url"
+  "bytes"
   "fmt"
   "golang.org/x/net/html"
   "io"
+  "regexp"
   "strings"
 )
 
@@ -30,68 +32,183 @@ var TaskResolverApplyPathTransformationsToHtmlContent = TaskResolver {
 /*
   HtmlNodeApplyPathTransformations, given an HTML document/node,
   a base URL, and an array of transformations, traverses the HTML
-  document looking for matching URLs in 'href' attributes, and
-  applies those transformations which match. The document is
-  mutated in-place, and true is returned if the document was
-  modified.
+  document looking for URL references, as htmlNodeMapUrls finds
+  them, and applies those transformations which match. The
+  document is mutated in-place, and true is returned if the
+  document was modified.
 */
 func HtmlNodeApplyPathTransformations (node *html.Node, base_url *url.URL, transformations []*PathTransformation) bool {
-  var modified bool = false
+  return htmlNodeMapUrls(node, func (ref string, navigation bool) (string, bool) {
+    return TransformUrlReference(base_url, ref, transformations)
+  })
+}
 
-  if node.Type == html.ElementNode {
-    for attr_i := range node.Attr {
-      var modify_attribute bool   = false
-      var attribute_key    string = node.Attr[attr_i].Key
-      var attribute_value  string = node.Attr[attr_i].Val
 
-      if strings.HasPrefix(attribute_value, "javascript:") {
-        continue
-      }
+/*
+  meta_refresh_regexp matches the content of a <meta
+  http-equiv="refresh"> element which redirects to a URL, such as
+  "0; url='/new/'", capturing the text before the URL, its quote,
+  the URL, and the text after it.
+*/
+var meta_refresh_regexp = regexp.MustCompile(`^(\s*[\d.]+\s*[;,]?\s*(?:[uU][rR][lL]\s*=\s*)?)(['"]?)(.*?)(['"]?\s*)$`)
 
-      switch attribute_key {
-        case "href", "src", "srcset":
-          modify_attribute = true
-      }
 
-      if modify_attribute == false {
-        continue
-      }
+func isHtmlSpace (c byte) bool {
+  return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
 
-      href_relative, err := url.Parse(attribute_value)
 
-      if href_relative.Host != "" {
-        continue
-      }
+/*
+  htmlMapSrcset passes the URL of each image candidate in a srcset
+  attribute value to map_func, which returns its replacement, and
+  whether it was changed. Candidates are parsed as browsers do, so
+  URLs may contain commas, such as in data: URLs, and descriptors
+  may contain parenthesized commas. The rest of the value is kept
+  as-is. It returns the new value, and whether it was changed.
+*/
+func htmlMapSrcset (srcset string, map_func func (ref string) (string, bool)) (string, bool) {
+  var builder strings.Builder
+  var changed bool
+  var last    int
+
+  for i := 0; i < len(srcset); {
+    // Skip whitespace and commas before a candidate
+    //
+    for i < len(srcset) && (isHtmlSpace(srcset[i]) || srcset[i] == ',') {
+      i++
+    }
+    if i >= len(srcset) {
+      break
+    }
 
-      if err != nil {
-        continue
-      }
+    // The URL is a run of non-whitespace. Commas at its end end
+    // the candidate, without descriptors.
+    //
+    var start = i
+    for i < len(srcset) && !isHtmlSpace(srcset[i]) {
+      i++
+    }
+    var end = i
+    for end > start && srcset[end-1] == ',' {
+      end--
+    }
 
-      href_url := base_url.ResolveReference(href_relative)
+    if new_url, url_changed := map_func(srcset[start:end]); url_changed {
+      builder.WriteString(srcset[last:start])
+      builder.WriteString(new_url)
+      last    = end
+      changed = true
+    }
 
-      var original_path string = href_url.Path
-      var path          string = original_path
+    if end < i {
+      continue
+    }
 
-      for _, transformation := range transformations {
-        path = transformation.TransformPath(path)
+    // Skip descriptors, up to a comma outside of parentheses
+    //
+    for depth := 0; i < len(srcset); i++ {
+      if srcset[i] == '(' {
+        depth++
+      } else if srcset[i] == ')' && depth > 0 {
+        depth--
+      } else if srcset[i] == ',' && depth == 0 {
+        break
       }
+    }
+  }
+
+  if !changed {
+    return srcset, false
+  }
+  builder.WriteString(srcset[last:])
+  return builder.String(), true
+}
 
-      if original_path != path {
-        modified = true
-        href_url.Path = path
 
-        if href_relative.Host == "" {
-          node.Attr[attr_i].Val = href_url.Path
-        } else {
-          node.Attr[attr_i].Val = href_url.String()
+/*
+  htmlNodeMapUrls passes the URL references in an HTML document's
+  href, src, srcset, poster, data-src, and data-srcset attributes,
+  the URLs of its <meta http-equiv="refresh"> redirects, and those
+  in its stylesheets, to map_func, which returns each replacement,
+  and whether it was changed. References which are navigated to,
+  rather than loaded by the page, such as links, are marked as
+  such. It returns whether the document was modified.
+*/
+func htmlNodeMapUrls (node *html.Node, map_func func (ref string, navigation bool) (string, bool)) bool {
+  var modified bool
+
+  var mapCss = func (content string) (string, bool) {
+    var writer bytes.Buffer
+    changed, err := CssReaderMapUrls(strings.NewReader(content), &writer, func (ref string) (string, bool) {
+      return map_func(ref, false)
+    })
+    if err != nil || !changed {
+      return content, false
+    }
+    return writer.String(), true
+  }
+
+  var mapResource = func (ref string) (string, bool) {
+    return map_func(ref, false)
+  }
+
+  if node.Type == html.ElementNode {
+    var is_refresh = false
+    if node.Data == "meta" {
+      http_equiv, _ := htmlAttr(node, "http-equiv")
+      is_refresh = strings.EqualFold(strings.TrimSpace(http_equiv), "refresh")
+    }
+
+    for attr_i := range node.Attr {
+      var attr = &node.Attr[attr_i]
+
+      switch attr.Key {
+      case "href", "src", "poster", "data-src":
+        if strings.HasPrefix(strings.TrimSpace(attr.Val), "javascript:") {
+          continue
+        }
+        var navigation = attr.Key == "href" && node.Data != "link"
+        if new_value, changed := map_func(strings.TrimSpace(attr.Val), navigation); changed {
+          attr.Val = new_value
+          modified = true
         }
+
+      case "srcset", "data-srcset":
+        if new_value, changed := htmlMapSrcset(attr.Val, mapResource); changed {
+          attr.Val = new_value
+          modified = true
+        }
+
+      case "content":
+        var matches = meta_refresh_regexp.FindStringSubmatch(attr.Val)
+        if !is_refresh || matches == nil || matches[3] == "" {
+          continue
+        }
+        if new_url, changed := map_func(matches[3], true); changed {
+          attr.Val = matches[1] + matches[2] + new_url + matches[4]
+          modified = true
+        }
+
+      case "style":
+        if new_value, changed := mapCss(attr.Val); changed {
+          attr.Val = new_value
+          modified = true
+        }
+      }
+    }
+
+    if node.Data == "style" && node.FirstChild != nil && node.FirstChild.Type == html.TextNode {
+      if new_content, changed := mapCss(node.FirstChild.Data); changed {
+        node.FirstChild.Data = new_content
+        modified = true
       }
     }
   }
 
   for child := node.FirstChild; child != nil; child = child.NextSibling {
-    child_modified := HtmlNodeApplyPathTransformations(child, base_url, transformations)
-    modified = modified || child_modified
+    if htmlNodeMapUrls(child, map_func) {
+      modified = true
+    }
   }
 
   return modified
@@ -2,8 +2,10 @@ package behaviors
 
 import (
   . "gilchrist.tech/interbuilder"
+  "golang.org/x/net/html"
   "testing"
   "fmt"
+  "net/url"
   "strings"
   "os"
   "path/filepath"
@@ -41,6 +43,9 @@ func TestHtmlPipeline (t *testing.T) {
       </head>
       <body>
         <a href="/page/">Internal link</a>
+        <a href="/page/?query=value#fragment">Internal link with query</a>
+        <a href="#fragment">Fragment link</a>
+        <a href="mailto:user@example.com">Email link</a>
         <a href="http://example.com">External link</a>
         <a href="javascript:alert();">Javascript link</a>
         <a href="javascript:;">Empty Javascript link</a>
@@ -119,6 +124,9 @@ func TestHtmlPipeline (t *testing.T) {
       case "index.html":
         var expected_cases = []string {
           "href=\"/transformed/page/\"",
+          "href=\"/transformed/page/?query=value#fragment\"",
+          "href=\"#fragment\"",
+          "href=\"mailto:user@example.com\"",
           "src=\"/transformed/bundle.js\"",
           "href=\"javascript:;\"",
           "href=\"javascript:alert();\"",
@@ -159,3 +167,77 @@ func TestHtmlPipeline (t *testing.T) {
     )
   }
 }
+
+
+func TestHtmlNodeApplyPathTransformations (t *testing.T) {
+  path_transformations, err := PathTransformationsFromAny("s`^/?`transformed/`")
+  if err != nil { t.Fatal(err) }
+  base_url, _ := url.Parse("/")
+
+  var test_cases = []struct { Document, Expect string } {
+    {
+      `<img srcset="/a.png 1x, /b.png 2x">`,
+      `<img srcset="/transformed/a.png 1x, /transformed/b.png 2x"/>`,
+    },
+    {
+      `<img srcset="/a,b.png 100w,/c.png,  data:image/png;base64,AA 3x">`,
+      `<img srcset="/transformed/a,b.png 100w,/transformed/c.png,  data:image/png;base64,AA 3x"/>`,
+    },
+    {
+      `<meta http-equiv="Refresh" content="0; URL='/new/'">`,
+      `<meta http-equiv="Refresh" content="0; URL=&#39;/transformed/new/&#39;"/>`,
+    },
+    {
+      `<meta http-equiv="refresh" content="5;/new/">`,
+      `<meta http-equiv="refresh" content="5;/transformed/new/"/>`,
+    },
+    {
+      `<meta http-equiv="refresh" content="30"><meta name="description" content="/not-a-url">`,
+      `<meta http-equiv="refresh" content="30"/><meta name="description" content="/not-a-url"/>`,
+    },
+    {
+      `<video poster="/poster.jpg"></video><img data-src="/lazy.png" data-srcset="/lazy.png 1x">`,
+      `<video poster="/transformed/poster.jpg"></video><img data-src="/transformed/lazy.png" data-srcset="/transformed/lazy.png 1x"/>`,
+    },
+    {
+      `<div style="background: url(/bg.png)"></div>`,
+      `<div style="background: url(/transformed/bg.png)"></div>`,
+    },
+  }
+
+  for _, test_case := range test_cases {
+    doc, err := html.Parse(strings.NewReader(test_case.Document))
+    if err != nil { t.Fatal(err) }
+
+    HtmlNodeApplyPathTransformations(doc, base_url, path_transformations)
+
+    var writer strings.Builder
+    html.Render(&writer, doc)
+    if !strings.Contains(writer.String(), test_case.Expect) {
+      t.Errorf("Expected %s to be transformed into %s, got:\n%s", test_case.Document, test_case.Expect, writer.String())
+    }
+  }
+}
+
+
+func TestHtmlMapSrcset (t *testing.T) {
+  var test_cases = []struct { Srcset, Expect string; Refs []string } {
+    { "a.png", "<a.png>", []string { "a.png" } },
+    { " a.png 1x ,b.png 2x", " <a.png> 1x ,<b.png> 2x", []string { "a.png", "b.png" } },
+    { "a.png, b.png,", "<a.png>, <b.png>,", []string { "a.png", "b.png" } },
+    { "a.png,b.png", "<a.png,b.png>", []string { "a.png,b.png" } },
+    { "a.png 1x (x, y), b.png", "<a.png> 1x (x, y), <b.png>", []string { "a.png", "b.png" } },
+    { " , ", " , ", nil },
+  }
+
+  for _, test_case := range test_cases {
+    var refs []string
+    got, _ := htmlMapSrcset(test_case.Srcset, func (ref string) (string, bool) {
+      refs = append(refs, ref)
+      return "<" + ref + ">", true
+    })
+    if got != test_case.Expect || fmt.Sprint(refs) != fmt.Sprint(test_case.Refs) {
+      t.Errorf("Expected %q to map %v into %q, got %v into %q", test_case.Srcset, test_case.Refs, test_case.Expect, refs, got)
+    }
+  }
+}
@@ -0,0 +1,376 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "golang.org/x/image/draw"
+  "golang.org/x/image/webp"
+
+  "bytes"
+  "fmt"
+  "image"
+  "image/jpeg"
+  "image/png"
+  "io"
+  "os"
+  "path"
+  "path/filepath"
+  "slices"
+  "strconv"
+  "strings"
+)
+
+
+/*
+  An ImageCodec decodes and encodes images of a media type, for
+  recompressing and resizing image assets.
+*/
+type ImageCodec struct {
+  Mediatype  string
+  Decode     func (io.Reader) (image.Image, error)
+  Encode     func (tk *Task, w io.Writer, img image.Image, quality int) error
+}
+
+
+var image_codecs = []ImageCodec {
+  {
+    Mediatype: "image/png",
+    Decode:    png.Decode,
+    Encode: func (tk *Task, w io.Writer, img image.Image, quality int) error {
+      var encoder = png.Encoder { CompressionLevel: png.BestCompression }
+      return encoder.Encode(w, img)
+    },
+  },
+  {
+    Mediatype: "image/jpeg",
+    Decode:    jpeg.Decode,
+    Encode: func (tk *Task, w io.Writer, img image.Image, quality int) error {
+      return jpeg.Encode(w, img, &jpeg.Options { Quality: quality })
+    },
+  },
+  {
+    Mediatype: "image/webp",
+    Decode:    webp.Decode,
+    Encode:    encodeWebp,
+  },
+}
+
+
+/*
+  TaskResolverOptimizeImages resolves the "optimize-images" task,
+  which recompresses PNG, JPEG, and WebP assets, and generates
+  resized variants of them. It runs before fingerprinting, so that
+  variants are fingerprinted too, and before the root spec's
+  "root-consume" task.
+*/
+var TaskResolverOptimizeImages = TaskResolver {
+  Id:   "optimize-images",
+  Name: "optimize-images",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "optimize-images", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_MUTATE | TASK_ASSETS_GENERATE,
+    Func:   TaskOptimizeImages,
+    Before: []string { "fingerprint-assets", "emit-manifest", "precompress-assets", "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskOptimizeImages defers the "optimize-images" task if
+  the spec's "optimize_images" prop is true, or if it has an
+  "image_sizes" prop.
+*/
+func BuildTaskOptimizeImages (s *Spec) error {
+  if s.GetTaskResolverById("optimize-images") == nil {
+    optimize := TaskResolverOptimizeImages
+    s.AddTaskResolver(&optimize)
+  }
+
+  optimize, ok, found := s.GetPropBool("optimize_images")
+  if found && !ok {
+    return fmt.Errorf("Prop \"optimize_images\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["optimize_images"])
+  }
+
+  sizes, err := imageSizes(s)
+  if err != nil { return err }
+
+  if !optimize && len(sizes) == 0 {
+    return nil
+  }
+
+  task, err := s.GetTask("optimize-images", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the optimize-images task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  imageSizes reads the "image_sizes" prop, a list of widths, in
+  pixels, of variants to generate. Widths are returned in
+  ascending order.
+*/
+func imageSizes (s *Spec) ([]int, error) {
+  sizes_any, found := s.GetProp("image_sizes")
+  if !found {
+    return nil, nil
+  }
+
+  sizes_list, ok := sizes_any.([]any)
+  if !ok {
+    if ints, ok := sizes_any.([]int); ok {
+      sizes_list = make([]any, len(ints))
+      for i, size := range ints {
+        sizes_list[i] = size
+      }
+    } else {
+      return nil, fmt.Errorf("Prop \"image_sizes\" in spec %s is expected to be a list of widths, got %T", s.Name, sizes_any)
+    }
+  }
+
+  var sizes []int
+  for _, size_any := range sizes_list {
+    var size int
+    switch value := size_any.(type) {
+      case int:
+        size = value
+      case float64:
+        size = int(value)
+        if float64(size) != value {
+          size = 0
+        }
+    }
+    if size <= 0 {
+      return nil, fmt.Errorf("Prop \"image_sizes\" in spec %s is expected to contain positive integers, got %v", s.Name, size_any)
+    }
+    sizes = append(sizes, size)
+  }
+
+  slices.Sort(sizes)
+  return slices.Compact(sizes), nil
+}
+
+
+func imageCodec (a *Asset) *ImageCodec {
+  var mediatype = minifyMediaType(a)
+  for i := range image_codecs {
+    if image_codecs[i].Mediatype == mediatype {
+      return &image_codecs[i]
+    }
+  }
+  return nil
+}
+
+
+/*
+  ImageVariantPath returns the path of a resized variant of an
+  image, with its width inserted before the file extension, such
+  as "photo-480w.jpg".
+*/
+func ImageVariantPath (src string, width int) string {
+  var ext = path.Ext(src)
+  return strings.TrimSuffix(src, ext) + "-" + strconv.Itoa(width) + "w" + ext
+}
+
+
+/*
+  resizeImage scales an image to a width, keeping its aspect
+  ratio.
+*/
+func resizeImage (img image.Image, width int) image.Image {
+  var bounds = img.Bounds()
+  var height = (bounds.Dy() * width + bounds.Dx() / 2) / bounds.Dx()
+  if height < 1 {
+    height = 1
+  }
+
+  var resized = image.NewNRGBA(image.Rect(0, 0, width, height))
+  draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+  return resized
+}
+
+
+/*
+  encodeWebp encodes an image as WebP with the `cwebp` command, or
+  the inherited "cwebp_bin" prop, as there is no WebP encoder in
+  the Go standard library.
+*/
+func encodeWebp (tk *Task, w io.Writer, img image.Image, quality int) error {
+  var cwebp = "cwebp"
+  if prop_cwebp, ok, found := tk.Spec.InheritPropString("cwebp_bin"); found && !ok {
+    return fmt.Errorf("Prop \"cwebp_bin\" in spec %s is expected to be a string, got %T", tk.Spec.Name, tk.Spec.Props["cwebp_bin"])
+  } else if found && prop_cwebp != "" {
+    cwebp = prop_cwebp
+  }
+
+  staging_dir, err := os.MkdirTemp("", "interbuilder-webp-")
+  if err != nil { return err }
+  defer os.RemoveAll(staging_dir)
+
+  var input_path  = filepath.Join(staging_dir, "input.png")
+  var output_path = filepath.Join(staging_dir, "output.webp")
+
+  input_file, err := os.Create(input_path)
+  if err != nil { return err }
+
+  err = png.Encode(input_file, img)
+  if close_err := input_file.Close(); err == nil {
+    err = close_err
+  }
+  if err != nil { return err }
+
+  args := []string { "-quiet", "-q", strconv.Itoa(quality), input_path, "-o", output_path }
+  if _, err := tk.CommandRun(cwebp, args...); err != nil {
+    return fmt.Errorf("Cannot encode WebP image: %w", err)
+  }
+
+  content, err := os.ReadFile(output_path)
+  if err != nil { return err }
+
+  _, err = w.Write(content)
+  return err
+}
+
+
+/*
+  imageVariantAsset creates an asset for a resized variant of an
+  image asset.
+*/
+func imageVariantAsset (source *Asset, width, height int, content []byte) (*Asset, error) {
+  var variant_url = *source.Url
+  variant_url.Path    = ImageVariantPath(source.Url.Path, width)
+  variant_url.RawPath = ""
+
+  var history = source.ExtendHistory()
+  history.Url = &variant_url
+
+  var variant = & Asset {
+    Url:      &variant_url,
+    Spec:     source.Spec,
+    History:  history,
+    Mimetype: source.Mimetype,
+    Metadata: map[string]any {
+      "width":      width,
+      "height":     height,
+      "variant_of": "/" + strings.TrimLeft(source.Url.Path, "/"),
+    },
+  }
+
+  if err := variant.SetContentBytes(content); err != nil {
+    return nil, err
+  }
+  return variant, nil
+}
+
+
+/*
+  TaskOptimizeImages pools the spec's input assets, and for each
+  PNG, JPEG, and WebP asset, recompresses it and emits resized
+  variants. Recompressed images replace the original only if they
+  are smaller. Image dimensions are recorded in the "width" and
+  "height" Metadata of originals and variants, and variants
+  record the URL path of their original, with a leading slash, in
+  "variant_of". It reads
+  the following props:
+
+    - optimize_images: If true, recompress images.
+    - image_sizes:     A list of widths, in pixels. For each width
+      smaller than an image, a variant is emitted with the width
+      inserted into its name, such as "photo-480w.jpg".
+    - image_quality:   The quality of JPEG and WebP images, from 1
+      to 100. Defaults to 85.
+    - cwebp_bin:       The cwebp executable, used to encode WebP
+      images. Defaults to "cwebp". Inherited.
+*/
+func TaskOptimizeImages (s *Spec, tk *Task) error {
+  optimize, _, _ := s.GetPropBool("optimize_images")
+
+  sizes, err := imageSizes(s)
+  if err != nil { return err }
+
+  var quality = 85
+  if prop_quality, ok, found := s.GetPropInt("image_quality"); found && !ok {
+    return fmt.Errorf("Prop \"image_quality\" in spec %s is expected to be an integer, got %T", s.Name, s.Props["image_quality"])
+  } else if found {
+    if prop_quality < 1 || prop_quality > 100 {
+      return fmt.Errorf("Prop \"image_quality\" in spec %s is expected to be from 1 to 100, got %d", s.Name, prop_quality)
+    }
+    quality = prop_quality
+  }
+
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets to optimize images: %w", err)
+  }
+
+  var assets   []*Asset
+  var variants []*Asset
+
+  for _, input := range tk.Assets {
+    flattened, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+    assets = append(assets, flattened...)
+  }
+
+  for _, asset := range assets {
+    codec := imageCodec(asset)
+    if codec == nil {
+      continue
+    }
+
+    content, err := asset.GetContentBytes()
+    if err != nil { return err }
+
+    img, err := codec.Decode(bytes.NewReader(content))
+    if err != nil {
+      return fmt.Errorf("Cannot decode image %s: %w", asset.Url, err)
+    }
+
+    var bounds = img.Bounds()
+    if asset.Metadata == nil {
+      asset.Metadata = make(map[string]any)
+    }
+    asset.Metadata["width"]  = bounds.Dx()
+    asset.Metadata["height"] = bounds.Dy()
+
+    if optimize {
+      var encoded bytes.Buffer
+      if err := codec.Encode(tk, &encoded, img, quality); err != nil {
+        return fmt.Errorf("Cannot encode image %s: %w", asset.Url, err)
+      }
+      if encoded.Len() < len(content) {
+        asset.ClearContentDataCache()
+        if err := asset.SetContentBytes(encoded.Bytes()); err != nil {
+          return err
+        }
+      }
+    }
+
+    for _, width := range sizes {
+      if width >= bounds.Dx() {
+        break
+      }
+
+      var resized = resizeImage(img, width)
+      var encoded bytes.Buffer
+      if err := codec.Encode(tk, &encoded, resized, quality); err != nil {
+        return fmt.Errorf("Cannot encode a %dpx variant of image %s: %w", width, asset.Url, err)
+      }
+
+      variant, err := imageVariantAsset(asset, width, resized.Bounds().Dy(), encoded.Bytes())
+      if err != nil { return err }
+      variants = append(variants, variant)
+    }
+  }
+
+  if err := tk.EmitAssets(assets); err != nil {
+    return err
+  }
+
+  return tk.EmitAssets(variants)
+}
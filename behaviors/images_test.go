@@ -0,0 +1,159 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bytes"
+  "image"
+  "image/color"
+  "image/jpeg"
+  "image/png"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+func testImage (width, height int) image.Image {
+  var img = image.NewNRGBA(image.Rect(0, 0, width, height))
+  for y := 0 ; y < height ; y++ {
+    for x := 0 ; x < width ; x++ {
+      img.Set(x, y, color.NRGBA { uint8(x * 255 / width), uint8(y * 255 / height), 128, 255 })
+    }
+  }
+  return img
+}
+
+
+func TestTaskOptimizeImages (t *testing.T) {
+  var png_content, jpeg_content bytes.Buffer
+
+  // An uncompressed PNG, which recompression shrinks
+  //
+  var encoder = png.Encoder { CompressionLevel: png.NoCompression }
+  if err := encoder.Encode(&png_content, testImage(100, 50)); err != nil {
+    t.Fatal(err)
+  }
+  if err := jpeg.Encode(&jpeg_content, testImage(60, 60), &jpeg.Options { Quality: 100 }); err != nil {
+    t.Fatal(err)
+  }
+
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"]              = true
+  subspec.Props["optimize_images"] = true
+  subspec.Props["image_sizes"]     = []any { 80.0, 40.0, 200.0 }
+
+  var documents = map[string][]byte {
+    "img/wide.png":   png_content.Bytes(),
+    "img/square.jpg": jpeg_content.Bytes(),
+    "notes.txt":      []byte("not an image"),
+  }
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for key, content := range documents {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes(content)
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]*Asset)
+
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      asset_path := strings.TrimLeft(asset.Url.Path, "/")
+      received[strings.TrimPrefix(asset_path, "@emit/")] = asset
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTaskOptimizeImages)
+  if err := subspec.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var expect_sizes = map[string][2]int {
+    "img/wide.png":       { 100, 50 },
+    "img/wide-40w.png":   { 40,  20 },
+    "img/wide-80w.png":   { 80,  40 },
+    "img/square.jpg":     { 60,  60 },
+    "img/square-40w.jpg": { 40,  40 },
+  }
+
+  if got, expect := len(received), len(expect_sizes) + 1; got != expect {
+    t.Errorf("Expected %d assets, got %d: %v", expect, got, received)
+  }
+
+  for key, size := range expect_sizes {
+    asset, found := received[key]
+    if !found {
+      t.Errorf("Expected an image at %s", key)
+      continue
+    }
+
+    content, err := asset.GetContentBytes()
+    if err != nil { t.Fatal(err) }
+
+    config, _, err := image.DecodeConfig(bytes.NewReader(content))
+    if err != nil {
+      t.Errorf("Cannot decode %s: %v", key, err)
+      continue
+    }
+
+    if config.Width != size[0] || config.Height != size[1] {
+      t.Errorf("Expected %s to be %dx%d, got %dx%d", key, size[0], size[1], config.Width, config.Height)
+    }
+    if asset.Metadata["width"] != size[0] || asset.Metadata["height"] != size[1] {
+      t.Errorf("Expected %s to have its size in metadata, got %v", key, asset.Metadata)
+    }
+  }
+
+  if got := received["img/wide-40w.png"].Metadata["variant_of"]; got != "/img/wide.png" {
+    t.Errorf("Expected the variant to refer to its original, got %v", got)
+  }
+
+  if content, _ := received["img/wide.png"].GetContentBytes(); len(content) >= png_content.Len() {
+    t.Errorf("Expected the PNG to be recompressed, got %d bytes from %d", len(content), png_content.Len())
+  }
+}
+
+
+func TestEncodeWebp (t *testing.T) {
+  var fake_cwebp = filepath.Join(t.TempDir(), "cwebp")
+  var fake_cwebp_src = strings.Join([]string {
+    `#!/bin/sh`,
+    `while [ $# -gt 0 ]; do`,
+    `  if [ "$1" = "-o" ]; then printf 'RIFF %s' "$QUALITY" > "$2"; fi`,
+    `  if [ "$1" = "-q" ]; then QUALITY="$2"; fi`,
+    `  shift`,
+    `done`,
+  }, "\n")
+
+  if err := os.WriteFile(fake_cwebp, []byte(fake_cwebp_src), 0o755); err != nil {
+    t.Fatal(err)
+  }
+
+  spec := NewSpec("spec", nil)
+  spec.Props["cwebp_bin"] = fake_cwebp
+  spec.Props["quiet"]     = true
+
+  var output bytes.Buffer
+  if err := encodeWebp(&Task { Spec: spec }, &output, testImage(4, 4), 70); err != nil {
+    t.Fatal(err)
+  }
+
+  if got, expect := output.String(), "RIFF 70"; got != expect {
+    t.Errorf("Expected %q from cwebp, got %q", expect, got)
+  }
+}
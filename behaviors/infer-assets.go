@@ -20,7 +20,8 @@ var TaskResolverAssetsInferRoot = TaskResolver {
 
 func ResolveTaskAssetsInfer (spec *Spec) error {
   if spec.GetTaskResolverById("assets-infer-root") == nil {
-    spec.AddTaskResolver( &TaskResolverAssetsInferRoot )
+    assets_infer := TaskResolverAssetsInferRoot
+    spec.AddTaskResolver(&assets_infer)
   }
 
   return nil
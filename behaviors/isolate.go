@@ -0,0 +1,287 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+)
+
+
+/*
+  TaskResolverIsolatedRun resolves the "isolated-run" task, which
+  runs a spec in a child interbuilder process, and emits the
+  assets it outputs as they are output, so that they reach the
+  spec's parent as if the spec ran in this process.
+*/
+var TaskResolverIsolatedRun = TaskResolver {
+  Id:   "isolated-run",
+  Name: "isolated-run",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "isolated-run", nil
+  },
+  TaskPrototype: Task {
+    Mask: TASK_ASSETS_GENERATE,
+  },
+}
+
+
+/*
+  isolate_local_props are the props of an isolated spec which are
+  kept by the local spec, rather than sent to its child process.
+*/
+var isolate_local_props = map[string]bool {
+  "isolate": true, "isolate_bin": true, "isolate_wrapper": true,
+  "transform": true, "quiet": true,
+}
+
+
+/*
+  BuildIsolatedSpec runs a subspec in a child interbuilder process
+  if its "isolate" prop is true, so that a memory-heavy or
+  crash-prone build cannot take down the rest of the pipeline.
+  Like BuildRemoteSpec, the spec's props, except those naming the
+  process, "transform", and "quiet", and its subspecs, become the
+  config of a subspec of the same name in the child's run, and are
+  removed from the local spec. Props inherited from ancestors are
+  not sent, except for "env" and "source_nest". The child runs in
+  this process's working directory. It reads the following props:
+
+    - isolate_bin:     The interbuilder executable, which defaults
+      to this one. Inherited.
+    - isolate_wrapper: An array of a program and arguments which
+      run the child, such as ["prlimit", "--as=4000000000", "--"]
+      or ["systemd-run", "--user", "--scope", "-p",
+      "MemoryMax=4G"], to limit its resources. Inherited.
+
+  It is meant to run after BuildRemoteSpec, and before the other
+  spec builders.
+*/
+func BuildIsolatedSpec (s *Spec) error {
+  isolate, ok, found := s.GetPropBool("isolate")
+  if found && !ok {
+    return fmt.Errorf("Prop \"isolate\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["isolate"])
+  } else if !isolate {
+    return nil
+  } else if s.Parent == nil {
+    return fmt.Errorf("Prop \"isolate\" in spec %s is only supported in subspecs", s.Name)
+  }
+
+  var run = & isolatedRun {}
+
+  if bin, ok, found := s.InheritPropString("isolate_bin"); found && (!ok || bin == "") {
+    prop, _ := s.InheritProp("isolate_bin")
+    return fmt.Errorf("Prop \"isolate_bin\" in spec %s is expected to be a path, got %v", s.Name, prop)
+  } else if found {
+    run.Bin = bin
+  }
+
+  if wrapper_any, found := s.InheritProp("isolate_wrapper"); found {
+    wrapper, ok := wrapper_any.([]any)
+    if !ok {
+      return fmt.Errorf("Prop \"isolate_wrapper\" in spec %s is expected to be an array of strings, got %T", s.Name, wrapper_any)
+    }
+    for _, arg_any := range wrapper {
+      arg, ok := arg_any.(string)
+      if !ok {
+        return fmt.Errorf("Prop \"isolate_wrapper\" in spec %s is expected to be an array of strings, got %T", s.Name, arg_any)
+      }
+      run.Wrapper = append(run.Wrapper, arg)
+    }
+  }
+
+  env, err := s.InheritEnv()
+  if err != nil { return err }
+
+  var config = make(map[string]any)
+  for key, value := range s.Props {
+    if !isolate_local_props[key] {
+      config[key] = value
+      delete(s.Props, key)
+    }
+  }
+  if len(env) > 0 {
+    config["env"] = env
+  }
+  if _, found := config["source_nest"]; !found {
+    if source_nest, ok, found := s.InheritPropString("source_nest"); found && ok {
+      config["source_nest"] = source_nest
+    }
+  }
+
+  // The spec is a subspec of the child's root, whose source_dir
+  // and spec_dir are the parent's, so that relative paths resolve
+  // as they would in this process
+  //
+  var root_config = map[string]any {
+    "subspecs": map[string]any { s.Name: config },
+  }
+  if parent_dir, ok, _ := s.Parent.InheritPropString("source_dir"); ok && parent_dir != "" {
+    if root_config["source_dir"], err = filepath.Abs(parent_dir); err != nil {
+      return err
+    }
+  }
+  if spec_dir, ok, _ := s.InheritPropString("spec_dir"); ok && spec_dir != "" {
+    if root_config["spec_dir"], err = filepath.Abs(spec_dir); err != nil {
+      return err
+    }
+  }
+
+  if run.Config, err = json.Marshal(root_config); err != nil {
+    return fmt.Errorf("Cannot encode the config of isolated spec %s: %w", s.Name, err)
+  }
+
+  // Each isolated spec has its own resolver, as its task runs its
+  // own config
+  //
+  resolver := TaskResolverIsolatedRun
+  resolver.TaskPrototype.Func = run.Run
+  s.AddTaskResolver(&resolver)
+
+  task, err := s.GetTask("isolated-run", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the isolated-run task in spec %s", s.Name)
+  }
+
+  return s.EnqueueTask(task)
+}
+
+
+/*
+  isolatedRun runs a spec's config in a child process.
+*/
+type isolatedRun struct {
+  Bin      string
+  Wrapper  []string
+  Config   []byte
+}
+
+
+/*
+  Run is the Func of an isolated-run task. It writes the spec's
+  config to a temporary file, and runs "interbuilder exec-spec"
+  with it, through the wrapper, if any. The child speaks the exec
+  plugin protocol on its stdout, as in RunExecPlugin, and its
+  output and errors are printed. Cancelling the task kills the
+  child.
+*/
+func (r *isolatedRun) Run (s *Spec, tk *Task) error {
+  var bin = r.Bin
+  if bin == "" {
+    executable, err := os.Executable()
+    if err != nil {
+      return fmt.Errorf("Cannot find the interbuilder executable for isolated spec %s: %w", s.Name, err)
+    }
+    bin = executable
+  }
+
+  config_file, err := os.CreateTemp("", "interbuilder-isolate-*.json")
+  if err != nil { return err }
+  defer os.Remove(config_file.Name())
+
+  _, err = config_file.Write(r.Config)
+  if close_err := config_file.Close(); err == nil {
+    err = close_err
+  }
+  if err != nil { return err }
+
+  var args = append(append([]string(nil), r.Wrapper...), bin, "exec-spec", config_file.Name())
+
+  // The child runs in this process's working directory, so that
+  // relative paths in its config are resolved as they would be in
+  // this process
+  //
+  var cmd = tk.Command(args[0], args[1:]...)
+  cmd.Dir = ""
+
+  stdout, err := cmd.StdoutPipe()
+  if err != nil { return err }
+  stderr := tk.CommandOutput("stderr")
+  cmd.Stderr = stderr
+  defer stderr.Flush()
+
+  if err := cmd.Start(); err != nil {
+    return fmt.Errorf("Cannot start isolated spec %s: %w", s.Name, err)
+  }
+
+  var output_err = readExecOutput(tk, args[0], stdout, nil)
+  if output_err != nil {
+    cmd.Process.Kill()
+  }
+  io.Copy(io.Discard, stdout)
+
+  var wait_err = cmd.Wait()
+  if output_err != nil {
+    return output_err
+  }
+  if wait_err != nil {
+    return fmt.Errorf("Isolated spec %s failed: %w", s.Name, wait_err)
+  }
+  return nil
+}
+
+
+/*
+  TaskConsumeExecOutput returns the root-consume TaskFunc of an
+  "interbuilder exec-spec" process, which writes an exec plugin
+  "asset" message to w for each asset which reaches it, at its
+  final output path, as they arrive.
+*/
+func TaskConsumeExecOutput (w io.Writer) TaskFunc {
+  var encoder = json.NewEncoder(w)
+
+  return func (s *Spec, tk *Task) error {
+    var write = func (chunk *Asset) error {
+      assets, err := chunk.FlattenContext(tk.Context())
+      if err != nil { return err }
+
+      for _, asset := range assets {
+        content, err := asset.GetContentBytes()
+        if err != nil { return err }
+        var encoded = base64.StdEncoding.EncodeToString(content)
+
+        var message = ExecMessage {
+          Type:     "asset",
+          Url:      finalOutputPath(s, asset),
+          Mimetype: asset.Mimetype,
+          Metadata: asset.Metadata,
+          Content:  &encoded,
+        }
+        if _, err := json.Marshal(message.Metadata); err != nil {
+          message.Metadata = nil
+        }
+        if err := encoder.Encode(&message); err != nil {
+          return err
+        }
+      }
+      return nil
+    }
+
+    for _, chunk := range tk.Assets {
+      if err := write(chunk); err != nil {
+        return err
+      }
+    }
+    tk.Assets = nil
+
+    for {
+      select {
+      case <-tk.Context().Done():
+        return nil
+      case chunk, ok := <-s.Input:
+        if !ok {
+          return nil
+        }
+        if err := write(chunk); err != nil {
+          return err
+        }
+      }
+    }
+  }
+}
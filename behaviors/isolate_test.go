@@ -0,0 +1,157 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bytes"
+  "encoding/json"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+func TestBuildIsolatedSpec (t *testing.T) {
+  // A stand-in for interbuilder, which outputs its config and
+  // whether it was ran through the wrapper as assets
+  //
+  var bin_dir  = t.TempDir()
+  var fake_bin = filepath.Join(bin_dir, "interbuilder")
+
+  var fake_bin_src = strings.Join([]string {
+    `#!/bin/sh`,
+    `[ "$1" = exec-spec ] || exit 2`,
+    `echo "building" >&2`,
+    `printf '{"type":"asset","url":"/config.json","text":%s}\n' "$(sed 's/"/\\"/g; s/^/"/; s/$/"/' "$2")"`,
+    `printf '{"type":"asset","url":"/wrapped.txt","text":"%s"}\n' "$ISOLATE_WRAPPED"`,
+  }, "\n")
+
+  if err := os.WriteFile(fake_bin, []byte(fake_bin_src), 0o755); err != nil {
+    t.Fatal(err)
+  }
+
+  var root = NewSpec("root", nil)
+  var site = root.AddSubspec(NewSpec("site", nil))
+
+  root.Props["quiet"]           = true
+  root.Props["source_dir"]      = bin_dir
+  root.Props["spec_dir"]        = bin_dir
+  root.Props["isolate_bin"]     = fake_bin
+  root.Props["isolate_wrapper"] = []any { "env", "ISOLATE_WRAPPED=yes" }
+  root.Props["env"]             = map[string]any { "GREETING": "hello" }
+
+  site.Props["isolate"]   = true
+  site.Props["source"]    = "git://example.com/site"
+  site.Props["markdown"]  = true
+  site.Props["transform"] = "s`^/?`site/`"
+
+  root.AddSpecBuilder(BuildIsolatedSpec)
+  root.AddSpecBuilder(BuildTransform)
+  if err := site.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  if _, found := site.Props["source"]; found {
+    t.Errorf("Expected the isolated spec's props to be sent to the child, got %v", site.Props)
+  }
+  if _, found := site.Props["transform"]; !found {
+    t.Errorf("Expected the isolated spec to keep its transform")
+  }
+
+  var received = make(map[string]string)
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      content, err := asset.GetContentBytes()
+      if err != nil { return err }
+      received[strings.TrimLeft(asset.Url.Path, "/")] = string(content)
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if got := received["@emit/site/wrapped.txt"]; got != "yes" {
+    t.Errorf("Expected the child to be ran through the wrapper, got %q in %v", got, received)
+  }
+
+  var config map[string]any
+  if err := json.Unmarshal([]byte(received["@emit/site/config.json"]), &config); err != nil {
+    t.Fatalf("Expected the child's config, got %q: %v", received["@emit/site/config.json"], err)
+  }
+
+  var site_config, _ = config["subspecs"].(map[string]any)["site"].(map[string]any)
+  if config["source_dir"] != bin_dir || config["spec_dir"] != bin_dir || site_config["source"] != "git://example.com/site" || site_config["markdown"] != true {
+    t.Errorf("Unexpected config of the child: %v", config)
+  }
+  if env, _ := site_config["env"].(map[string]any); env["GREETING"] != "hello" {
+    t.Errorf("Expected the child's config to have the inherited env, got %v", site_config["env"])
+  }
+  if _, found := site_config["isolate"]; found {
+    t.Errorf("Expected the child's config not to be isolated again, got %v", site_config)
+  }
+}
+
+
+func TestBuildIsolatedSpecFailure (t *testing.T) {
+  var root = NewSpec("root", nil)
+  var site = root.AddSubspec(NewSpec("site", nil))
+
+  root.Props["quiet"]       = true
+  root.Props["isolate_bin"] = "false"
+  site.Props["isolate"]     = true
+
+  root.AddSpecBuilder(BuildIsolatedSpec)
+  if err := site.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := root.Run(); err == nil || !strings.Contains(err.Error(), "Isolated spec site failed") {
+    t.Errorf("Expected the failed child to fail the spec, got %v", err)
+  }
+
+  root.Props["isolate"] = true
+  root.SpecBuilders = nil
+  root.AddSpecBuilder(BuildIsolatedSpec)
+  if err := root.Build(); err == nil {
+    t.Errorf("Expected the root spec not to be isolated")
+  }
+}
+
+
+func TestTaskConsumeExecOutput (t *testing.T) {
+  var output bytes.Buffer
+
+  var root = NewSpec("root", nil)
+  var site = root.AddSubspec(NewSpec("site", nil))
+  root.Props["quiet"] = true
+
+  site.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    asset := s.MakeAsset("docs/a b.html")
+    asset.Mimetype = "text/html"
+    asset.SetContentBytes([]byte("<p>Hello</p>"))
+    return tk.EmitAsset(asset)
+  })
+  root.DeferTaskFunc("root-consume", TaskConsumeExecOutput(&output))
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var message ExecMessage
+  if err := json.Unmarshal(output.Bytes(), &message); err != nil {
+    t.Fatalf("Expected one message, got %q: %v", output.String(), err)
+  }
+  if message.Type != "asset" || message.Url != "/docs/a b.html" || message.Mimetype != "text/html" || message.Content == nil {
+    t.Errorf("Unexpected message: %+v", message)
+  }
+
+  // The message is read back as the asset it describes
+  //
+  asset, err := execMessageAsset(site, nil, &message)
+  if err != nil { t.Fatal(err) }
+  if content, _ := asset.GetContentBytes(); string(content) != "<p>Hello</p>" {
+    t.Errorf("Expected the asset's content, got %q", content)
+  }
+}
@@ -0,0 +1,280 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bytes"
+  "fmt"
+  "html/template"
+  "io/fs"
+  "os"
+  "path"
+  "path/filepath"
+  "regexp"
+  "strings"
+  "sync"
+)
+
+
+/*
+  TaskResolverApplyLayouts resolves the "apply-layouts" task, which
+  wraps HTML assets in html/template layouts from the spec's
+  "templates_dir" prop. It runs after front matter is extracted and
+  Markdown is rendered, so that layouts receive rendered content
+  and its metadata, and before tasks which read whole documents.
+  Its MapFunc is set by BuildTaskApplyLayouts, in a copy of the
+  resolver for each spec with layouts.
+*/
+var TaskResolverApplyLayouts = TaskResolver {
+  Id:   "apply-layouts",
+  Name: "apply-layouts",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "apply-layouts", nil
+  },
+  TaskPrototype: Task {
+    Mask:      TASK_ASSETS_MUTATE,
+    MatchFunc: func (tk *Task, a *Asset) (bool, error) {
+      return isHtmlAsset(a), nil
+    },
+    After:  []string { "extract-front-matter", "render-markdown" },
+    Before: []string {
+      "canonical-urls", "live-reload", "fingerprint-assets",
+      "emit-sitemap", "emit-feed", "root-consume",
+    },
+  },
+}
+
+
+/*
+  LayoutData is the data layout templates are executed with.
+*/
+type LayoutData struct {
+  // Content is the HTML content of the asset being wrapped
+  //
+  Content template.HTML
+
+  // Metadata is the asset's Metadata, such as its front matter
+  //
+  Metadata map[string]any
+
+  // Props are the props of the spec applying the layout, with
+  // those inherited from its ancestors
+  //
+  Props map[string]any
+
+  // Path is the path the asset is finally output at
+  //
+  Path string
+}
+
+
+/*
+  BuildTaskApplyLayouts defers the "apply-layouts" task if the spec
+  has a "templates_dir" prop. The prop is not inherited. Templates
+  are loaded when the first asset is wrapped, so that they may be
+  in a source_dir which is populated while running.
+*/
+func BuildTaskApplyLayouts (s *Spec) error {
+  if _, found := s.Props["templates_dir"]; !found {
+    return nil
+  }
+
+  templates_dir, ok, _ := s.GetPropString("templates_dir")
+  if !ok || templates_dir == "" {
+    return fmt.Errorf("Prop \"templates_dir\" in spec %s is expected to be a path, got %T", s.Name, s.Props["templates_dir"])
+  }
+
+  default_layout, ok, found := s.GetPropString("layout")
+  if found && !ok {
+    return fmt.Errorf("Prop \"layout\" in spec %s is expected to be a string, got %T", s.Name, s.Props["layout"])
+  }
+
+  var layouts = & specLayouts {
+    Spec:          s,
+    TemplatesDir:  templates_dir,
+    Default:       default_layout,
+    DefaultNeeded: found,
+  }
+
+  apply := TaskResolverApplyLayouts
+  apply.TaskPrototype.MapFunc = layouts.Apply
+  s.AddTaskResolver(&apply)
+
+  return s.DeferTask(apply.NewTask())
+}
+
+
+/*
+  specLayouts applies the layouts of a spec's "templates_dir".
+*/
+type specLayouts struct {
+  Spec          *Spec
+  TemplatesDir  string
+
+  // Default is the layout of assets which do not name one in
+  // their metadata. If DefaultNeeded is false, the default is
+  // "default", and is skipped if there is no such template.
+  //
+  Default       string
+  DefaultNeeded bool
+
+  load_once     sync.Once
+  templates     *template.Template
+  load_err      error
+}
+
+
+/*
+  LoadLayoutTemplates parses every file in a directory as an
+  html/template, named by its slash-separated path relative to the
+  directory, so that layouts may include each other and partials,
+  such as with {{ template "partials/header.html" . }}.
+*/
+func LoadLayoutTemplates (dir string) (*template.Template, error) {
+  var templates = template.New("")
+
+  err := filepath.WalkDir(dir, func (file_path string, entry fs.DirEntry, err error) error {
+    if err != nil || entry.IsDir() {
+      return err
+    }
+
+    relative, err := PathKey(dir, file_path)
+    if err != nil { return err }
+
+    content, err := os.ReadFile(file_path)
+    if err != nil { return err }
+
+    if _, err := templates.New(relative).Parse(string(content)); err != nil {
+      return fmt.Errorf("Cannot parse template %s: %w", relative, err)
+    }
+    return nil
+  })
+
+  if err != nil {
+    return nil, err
+  }
+  return templates, nil
+}
+
+
+func (l *specLayouts) load () (*template.Template, error) {
+  l.load_once.Do(func () {
+    var templates_dir = l.TemplatesDir
+    if !filepath.IsAbs(templates_dir) {
+      source_dir, _, _ := l.Spec.InheritPropString("source_dir")
+      templates_dir = filepath.Join(source_dir, templates_dir)
+    }
+    l.templates, l.load_err = LoadLayoutTemplates(templates_dir)
+    if l.load_err != nil {
+      l.load_err = fmt.Errorf("Cannot load templates_dir in spec %s: %w", l.Spec.Name, l.load_err)
+    }
+  })
+  return l.templates, l.load_err
+}
+
+
+/*
+  html_document_pattern matches content which is a whole HTML
+  document, rather than a fragment to be wrapped.
+*/
+var html_document_pattern = regexp.MustCompile(`(?i)^\s*(<!--.*?-->\s*)*<(!doctype|html)[\s>]`)
+
+
+/*
+  layoutName returns the name of the layout an asset is wrapped
+  in, from its "layout" metadata, and whether a missing template
+  by that name is an error. Whole documents are only wrapped if
+  their metadata names a layout. An empty name means the asset is
+  not wrapped.
+*/
+func (l *specLayouts) layoutName (a *Asset, content []byte) (string, bool, error) {
+  switch layout := a.Metadata["layout"].(type) {
+  case nil:
+  case string:
+    return layout, true, nil
+  case bool:
+    if !layout {
+      return "", false, nil
+    }
+  default:
+    return "", false, fmt.Errorf("Metadata \"layout\" in asset %s is expected to be a string or false, got %T", a.Url, layout)
+  }
+
+  if html_document_pattern.Match(content) {
+    return "", false, nil
+  }
+  if l.DefaultNeeded {
+    return l.Default, true, nil
+  }
+  return "default", false, nil
+}
+
+
+/*
+  Apply is a Task MapFunc which wraps an HTML asset in its layout.
+  Layouts are named without their ".html" extension, such as
+  "post" for "post.html".
+*/
+func (l *specLayouts) Apply (a *Asset) (*Asset, error) {
+  content, err := a.GetContentBytes()
+  if err != nil { return nil, err }
+
+  name, needed, err := l.layoutName(a, content)
+  if err != nil || name == "" {
+    return a, err
+  }
+
+  templates, err := l.load()
+  if err != nil { return nil, err }
+
+  var layout = templates.Lookup(name)
+  if layout == nil && path.Ext(name) == "" {
+    layout = templates.Lookup(name + ".html")
+  }
+  if layout == nil {
+    if needed {
+      return nil, fmt.Errorf("Cannot find the layout %s of asset %s in spec %s", name, a.Url, l.Spec.Name)
+    }
+    return a, nil
+  }
+
+  var data = LayoutData {
+    Content:  template.HTML(content),
+    Metadata: a.Metadata,
+    Props:    inheritedProps(l.Spec),
+    Path:     finalOutputPath(l.Spec, a),
+  }
+  if data.Metadata == nil {
+    data.Metadata = make(map[string]any)
+  }
+
+  var rendered bytes.Buffer
+  if err := layout.Execute(&rendered, data); err != nil {
+    return nil, fmt.Errorf("Cannot apply the layout %s to asset %s: %w", strings.TrimSuffix(name, ".html"), a.Url, err)
+  }
+
+  a.ClearContentDataCache()
+  if err := a.SetContentBytes(rendered.Bytes()); err != nil {
+    return nil, err
+  }
+  return a, nil
+}
+
+
+/*
+  inheritedProps returns the props of a spec merged over those of
+  its ancestors.
+*/
+func inheritedProps (s *Spec) map[string]any {
+  var props = make(map[string]any)
+  var specs []*Spec
+  for spec := s; spec != nil; spec = spec.Parent {
+    specs = append(specs, spec)
+  }
+  for i := len(specs) - 1; i >= 0; i-- {
+    for key, value := range specs[i].Props {
+      props[key] = value
+    }
+  }
+  return props
+}
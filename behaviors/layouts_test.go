@@ -0,0 +1,115 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+func TestTaskApplyLayouts (t *testing.T) {
+  var source_dir = t.TempDir()
+  var templates = map[string]string {
+    "default.html":      `<html><head><title>{{ .Props.site_name }}</title></head><body>{{ template "partials/nav.html" . }}{{ .Content }}</body></html>`,
+    "post.html":         `<article data-path="{{ .Path }}"><h1>{{ .Metadata.title }}</h1>{{ .Content }}</article>`,
+    "partials/nav.html": `<nav>{{ .Metadata.title }}</nav>`,
+  }
+  for name, content := range templates {
+    var file_path = filepath.Join(source_dir, "layouts", name)
+    if err := os.MkdirAll(filepath.Dir(file_path), os.ModePerm); err != nil {
+      t.Fatal(err)
+    }
+    if err := os.WriteFile(file_path, []byte(content), 0o644); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  var documents = []struct {
+    Key, Content  string
+    Metadata      map[string]any
+  } {
+    { "index.html", `<p>Home</p>`, map[string]any { "title": "Home" } },
+    { "post.html", `<p>Post</p>`, map[string]any { "title": "A <Post>", "layout": "post" } },
+    { "raw.html", `<p>Raw</p>`, map[string]any { "layout": false } },
+    { "full.html", `<!DOCTYPE html><html><body>Full</body></html>`, nil },
+    { "style.css", `p { margin: 0 }`, nil },
+  }
+
+  // build builds a site with the layouts, whose assets are
+  // received by the root spec
+  //
+  var build = func (props map[string]any) (*Spec, map[string]string, error) {
+    root := NewSpec("root", nil)
+    site := root.AddSubspec(NewSpec("site", nil))
+
+    root.Props["quiet"]     = true
+    root.Props["site_name"] = "Example"
+    site.Props["source_dir"]    = source_dir
+    site.Props["templates_dir"] = "layouts"
+    for key, value := range props {
+      site.Props[key] = value
+    }
+    site.PathTransformations, _ = PathTransformationsFromAny("s`^`blog/`")
+
+    site.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+      for _, document := range documents {
+        asset := s.MakeAsset(document.Key)
+        asset.Metadata = document.Metadata
+        asset.SetContentBytes([]byte(document.Content))
+        if err := tk.EmitAsset(asset); err != nil {
+          return err
+        }
+      }
+      return nil
+    })
+
+    var received = make(map[string]string)
+    root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+      if err := tk.PoolSpecInputAssets(); err != nil {
+        return err
+      }
+      for _, input := range tk.Assets {
+        assets, err := input.Flatten()
+        if err != nil { return err }
+        for _, asset := range assets {
+          content, err := asset.GetContentBytes()
+          if err != nil { return err }
+          received[strings.TrimPrefix(asset.Url.Path, "@emit/")] = string(content)
+        }
+      }
+      return nil
+    })
+
+    root.AddSpecBuilder(BuildTaskApplyLayouts)
+    return root, received, site.Build()
+  }
+
+  root, received, err := build(nil)
+  if err != nil { t.Fatal(err) }
+  TestWrapTimeoutError(t, root.Run)
+
+  var expect = map[string]string {
+    "blog/index.html": `<html><head><title>Example</title></head><body><nav>Home</nav><p>Home</p></body></html>`,
+    "blog/post.html":  `<article data-path="/blog/post.html"><h1>A &lt;Post&gt;</h1><p>Post</p></article>`,
+    "blog/raw.html":   `<p>Raw</p>`,
+    "blog/full.html":  `<!DOCTYPE html><html><body>Full</body></html>`,
+    "blog/style.css":  `p { margin: 0 }`,
+  }
+  for key, content := range expect {
+    if received[key] != content {
+      t.Errorf("Expected %s to be:\n%s\ngot:\n%s", key, content, received[key])
+    }
+  }
+
+  // A default layout set by prop must exist
+  //
+  root, _, err = build(map[string]any { "layout": "missing" })
+  if err != nil { t.Fatal(err) }
+  root.Props["quiet"] = true
+  if err := root.Run(); err == nil || !strings.Contains(err.Error(), "missing") {
+    t.Errorf("Expected a missing layout to be an error, got %v", err)
+  }
+}
@@ -0,0 +1,152 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "golang.org/x/net/html"
+  "golang.org/x/net/html/atom"
+
+  "fmt"
+)
+
+
+/*
+  LiveReloadPath is the path an AssetServer streams reload events
+  from, as server-sent events, to the live-reload script.
+*/
+const LiveReloadPath = "/_interbuilder/live-reload"
+
+
+/*
+  live_reload_script_id is the id of the injected <script>
+  element, by which documents which already have it are skipped.
+*/
+const live_reload_script_id = "interbuilder-live-reload"
+
+
+/*
+  live_reload_script listens for reload events from the dev
+  server, and reloads the page once a burst of them has settled.
+  EventSource reconnects by itself if the server restarts.
+*/
+const live_reload_script = `(function () {
+  var source  = new EventSource("` + LiveReloadPath + `");
+  var timeout = null;
+  source.addEventListener("reload", function () {
+    clearTimeout(timeout);
+    timeout = setTimeout(function () { location.reload(); }, 100);
+  });
+})();`
+
+
+/*
+  TaskResolverLiveReload resolves the "live-reload" task, which
+  injects a script into HTML assets which reloads the page when
+  the dev server serves new content.
+*/
+var TaskResolverLiveReload = TaskResolver {
+  Id:   "live-reload",
+  Name: "live-reload",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "live-reload", nil
+  },
+  TaskPrototype: Task {
+    Mask:            TASK_ASSETS_MUTATE,
+    MatchMimePrefix: "text/html",
+    MapFunc:         TaskMapInjectLiveReload,
+    After:           []string { "render-markdown", "apply-layouts", "canonical-urls" },
+    Before:          []string { "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskLiveReload defers the "live-reload" task if the
+  inherited "dev" prop is true. The dev server reads the root
+  spec's input directly, so the task is deferred in every spec
+  below it, and documents passing through several are injected
+  once.
+*/
+func BuildTaskLiveReload (s *Spec) error {
+  if s.GetTaskResolverById("live-reload") == nil {
+    live_reload := TaskResolverLiveReload
+    s.AddTaskResolver(&live_reload)
+  }
+
+  dev, ok, found := s.InheritPropBool("dev")
+  if found && !ok {
+    prop, _ := s.InheritProp("dev")
+    return fmt.Errorf("Prop \"dev\" in spec %s is expected to be a boolean, got %T", s.Name, prop)
+  } else if !dev {
+    return nil
+  }
+
+  task, err := s.GetTask("live-reload", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the live-reload task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  HtmlNodeInjectLiveReload appends the live-reload script to a
+  document's <body>, or its <head> if it has no body. The document
+  is mutated in-place, and true is returned if it was modified;
+  documents which already have the script are left as-is.
+*/
+func HtmlNodeInjectLiveReload (doc *html.Node) bool {
+  var existing = htmlFindElements(doc, func (node *html.Node) bool {
+    id, _ := htmlAttr(node, "id")
+    return node.DataAtom == atom.Script && id == live_reload_script_id
+  })
+  if len(existing) > 0 {
+    return false
+  }
+
+  var parents = htmlFindElements(doc, func (node *html.Node) bool { return node.DataAtom == atom.Body })
+  if len(parents) == 0 {
+    parents = htmlFindElements(doc, func (node *html.Node) bool { return node.DataAtom == atom.Head })
+  }
+  if len(parents) == 0 {
+    return false
+  }
+
+  var script = & html.Node {
+    Type: html.ElementNode, Data: "script", DataAtom: atom.Script,
+    Attr: []html.Attribute { { Key: "id", Val: live_reload_script_id } },
+  }
+  script.AppendChild(& html.Node { Type: html.TextNode, Data: live_reload_script })
+  parents[0].AppendChild(script)
+  return true
+}
+
+
+/*
+  TaskMapInjectLiveReload is a Task MapFunc which injects the
+  live-reload script into HTML assets.
+*/
+func TaskMapInjectLiveReload (a *Asset) (*Asset, error) {
+  var err error
+
+  if a, err = TaskMapContentDataHtmlHandlers(a); err != nil {
+    return nil, err
+  }
+
+  doc_any, err := a.GetContentData()
+  if err != nil { return nil, err }
+  doc, ok := doc_any.(*html.Node)
+
+  if ! ok {
+    return nil, fmt.Errorf("Asset ContentData was expected to be a *html.Node, got a %T", doc_any)
+  }
+
+  if HtmlNodeInjectLiveReload(doc) {
+    a.SetContentData(doc)
+  }
+
+  return a, nil
+}
@@ -0,0 +1,107 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "golang.org/x/net/html"
+
+  "bytes"
+  "strings"
+  "testing"
+)
+
+
+func TestHtmlNodeInjectLiveReload (t *testing.T) {
+  var test_cases = []struct {
+    name, document, expect  string
+    modified                bool
+  } {
+    { "body", `<html><head></head><body><p>Hi</p></body></html>`, `<p>Hi</p><script id="interbuilder-live-reload">`, true },
+    { "fragment", `<p>Hi</p>`, `<body><p>Hi</p><script id="interbuilder-live-reload">`, true },
+    { "injected", `<p>Hi</p><script id="interbuilder-live-reload"></script>`, `<p>Hi</p><script id="interbuilder-live-reload"></script>`, false },
+  }
+
+  for _, test_case := range test_cases {
+    doc, err := html.Parse(strings.NewReader(test_case.document))
+    if err != nil { t.Fatal(err) }
+
+    if modified := HtmlNodeInjectLiveReload(doc); modified != test_case.modified {
+      t.Errorf("%s: expected modified to be %v, got %v", test_case.name, test_case.modified, modified)
+    }
+
+    var writer bytes.Buffer
+    html.Render(&writer, doc)
+    if !strings.Contains(writer.String(), test_case.expect) {
+      t.Errorf("%s: expected the document to contain %s, got:\n%s", test_case.name, test_case.expect, writer.String())
+    }
+    if count := strings.Count(writer.String(), LiveReloadPath); count != 1 && test_case.modified {
+      t.Errorf("%s: expected the script once, got %d times", test_case.name, count)
+    }
+  }
+}
+
+
+func TestTaskLiveReload (t *testing.T) {
+  root  := NewSpec("root", nil)
+  merge := root.AddSubspec(NewSpec("merge", nil))
+  site  := merge.AddSubspec(NewSpec("site", nil))
+
+  root.Props["quiet"] = true
+  root.Props["dev"]   = true
+
+  site.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for key, mimetype := range map[string]string { "index.html": "text/html", "style.css": "text/css" } {
+      asset := s.MakeAsset(key)
+      asset.Mimetype = mimetype
+      asset.SetContentBytes([]byte("<p>Hi</p>"))
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]string)
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, input := range tk.Assets {
+      assets, err := input.Flatten()
+      if err != nil { return err }
+      for _, asset := range assets {
+        content, err := asset.GetContentBytes()
+        if err != nil { return err }
+        received[strings.TrimPrefix(asset.Url.Path, "@emit/")] = string(content)
+      }
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTaskLiveReload)
+  for _, spec := range []*Spec { merge, site } {
+    if err := spec.Build(); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if count := strings.Count(received["index.html"], LiveReloadPath); count != 1 {
+    t.Errorf("Expected the script to be injected once, got %d times in:\n%s", count, received["index.html"])
+  }
+  if received["style.css"] != "<p>Hi</p>" {
+    t.Errorf("Expected non-HTML assets to be unchanged, got %s", received["style.css"])
+  }
+
+  // Without the dev prop, no task is deferred
+  //
+  var spec = NewSpec("spec", nil)
+  spec.AddSpecBuilder(BuildTaskLiveReload)
+  if err := spec.Build(); err != nil {
+    t.Fatal(err)
+  }
+  if spec.Tasks != nil {
+    t.Errorf("Expected no tasks without the dev prop, got %s", spec.Tasks.Name)
+  }
+}
@@ -0,0 +1,188 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "mime"
+  "path"
+  "sort"
+  "strings"
+)
+
+
+/*
+  TaskResolverEmitManifest resolves the "emit-manifest" task,
+  which emits a JSON manifest asset listing every asset which
+  reaches it, with each asset's path, size, MIME type, and SHA-256
+  hash. This is useful for deploy tooling and cache invalidation.
+  It runs before the root spec's "root-consume" task, so that the
+  manifest is written with the other assets.
+*/
+var TaskResolverEmitManifest = TaskResolver {
+  Id:   "emit-manifest",
+  Name: "emit-manifest",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "emit-manifest", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_CONSUME | TASK_ASSETS_GENERATE,
+    Func:   TaskEmitManifest,
+    Before: []string { "root-consume" },
+  },
+}
+
+
+type ManifestEntry struct {
+  Path      string  `json:"path"`
+  Size      int     `json:"size"`
+  Mimetype  string  `json:"mimetype,omitempty"`
+  Sha256    string  `json:"sha256"`
+}
+
+
+type Manifest struct {
+  Assets  []ManifestEntry  `json:"assets"`
+}
+
+
+/*
+  BuildTaskEmitManifest defers the "emit-manifest" task if the
+  spec's "manifest" prop is true, or a path for the manifest
+  asset. The default path is "manifest.json".
+*/
+func BuildTaskEmitManifest (s *Spec) error {
+  if s.GetTaskResolverById("emit-manifest") == nil {
+    emit := TaskResolverEmitManifest
+    s.AddTaskResolver(&emit)
+  }
+
+  manifest_any, found := s.GetProp("manifest")
+  if !found {
+    return nil
+  }
+
+  switch manifest := manifest_any.(type) {
+    case bool:
+      if !manifest {
+        return nil
+      }
+    case string:
+      if manifest == "" {
+        return fmt.Errorf("Prop \"manifest\" in spec %s is an empty path", s.Name)
+      }
+    default:
+      return fmt.Errorf("Prop \"manifest\" in spec %s is expected to be a boolean or path, got %T", s.Name, manifest_any)
+  }
+
+  task, err := s.GetTask("emit-manifest", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the emit-manifest task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  manifestPath returns the key of the manifest asset, from the
+  "manifest" prop.
+*/
+func manifestPath (s *Spec) string {
+  if manifest_path, ok, _ := s.GetPropString("manifest"); ok && manifest_path != "" {
+    return strings.TrimLeft(manifest_path, "/")
+  }
+  return "manifest.json"
+}
+
+
+/*
+  TaskEmitManifest pools the spec's input assets, forwards them,
+  and emits a manifest asset describing them.
+*/
+func TaskEmitManifest (s *Spec, tk *Task) error {
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets for manifest: %w", err)
+  }
+
+  var manifest = Manifest { Assets: []ManifestEntry {} }
+
+  for _, input := range tk.Assets {
+    assets, err := input.FlattenContext(tk.Context())
+    if err != nil {
+      return err
+    }
+
+    for _, asset := range assets {
+      entry, err := manifestEntry(s, asset)
+      if err != nil {
+        return err
+      }
+      manifest.Assets = append(manifest.Assets, entry)
+    }
+  }
+
+  sort.Slice(manifest.Assets, func (i, j int) bool {
+    return manifest.Assets[i].Path < manifest.Assets[j].Path
+  })
+
+  if err := tk.ForwardAssets(); err != nil {
+    return err
+  }
+
+  manifest_json, err := json.MarshalIndent(manifest, "", "  ")
+  if err != nil {
+    return err
+  }
+
+  manifest_asset := s.MakeAsset(manifestPath(s))
+  manifest_asset.Mimetype = "application/json"
+  if err := manifest_asset.SetContentBytes(manifest_json); err != nil {
+    return err
+  }
+
+  return tk.EmitAsset(manifest_asset)
+}
+
+
+/*
+  manifestEntry describes an asset in the manifest, at the path it
+  is finally output at from the root spec, hashing its content as
+  it is streamed from the asset's ContentReader, so that file
+  assets are not read into memory.
+*/
+func manifestEntry (s *Spec, asset *Asset) (ManifestEntry, error) {
+  reader, err := asset.ContentReader()
+  if err != nil {
+    return ManifestEntry {}, fmt.Errorf("Cannot read asset %s for manifest: %w", asset.Url, err)
+  }
+  if closer, ok := reader.(io.Closer); ok {
+    defer closer.Close()
+  }
+
+  var hasher = sha256.New()
+  size, err := io.Copy(hasher, reader)
+  if err != nil {
+    return ManifestEntry {}, fmt.Errorf("Cannot read asset %s for manifest: %w", asset.Url, err)
+  }
+
+  var asset_path = finalOutputPath(s, asset)
+
+  var mimetype = asset.Mimetype
+  if mimetype == "" {
+    mimetype = mime.TypeByExtension(path.Ext(asset_path))
+  }
+
+  return ManifestEntry {
+    Path:     asset_path,
+    Size:     int(size),
+    Mimetype: mimetype,
+    Sha256:   hex.EncodeToString(hasher.Sum(nil)),
+  }, nil
+}
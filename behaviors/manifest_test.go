@@ -0,0 +1,214 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bytes"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+func TestTaskEmitManifest (t *testing.T) {
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"]    = true
+  root.Props["manifest"] = "meta/manifest.json"
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for key, content := range map[string]string {
+      "index.html": "<h1>Hello</h1>",
+      "style.css":  "h1 {}",
+    } {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes([]byte(content))
+      if err := s.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]*Asset)
+
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    for _, asset := range tk.Assets {
+      received[asset.Url.Path] = asset
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTaskEmitManifest)
+  if err := root.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if len(received) != 3 {
+    t.Fatalf("Expected 3 assets after the manifest task, got %d", len(received))
+  }
+
+  manifest_asset, found := received["meta/manifest.json"]
+  if !found {
+    t.Fatalf("Expected a manifest asset, got %v", received)
+  }
+
+  content, err := manifest_asset.GetContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  var manifest Manifest
+  if err := json.Unmarshal(content, &manifest); err != nil {
+    t.Fatal(err)
+  }
+
+  var expect = []ManifestEntry {
+    {
+      Path:     "/index.html",
+      Size:     14,
+      Mimetype: "text/html; charset=utf-8",
+      Sha256:   "e2c6c0ea7c7900c31f953e48d30d5e839801ab90630d751e7c8426ed5859da47",
+    },
+    {
+      Path:     "/style.css",
+      Size:     5,
+      Mimetype: "text/css; charset=utf-8",
+      Sha256:   "a0109f77a9a25bfd69cec821ff7bd539e63ebb00a13753f9989fab1d5a278202",
+    },
+  }
+
+  if len(manifest.Assets) != len(expect) {
+    t.Fatalf("Expected %d manifest entries, got %v", len(expect), manifest.Assets)
+  }
+
+  for i, entry := range manifest.Assets {
+    if entry != expect[i] {
+      t.Errorf("Manifest entry %d is %+v, expected %+v", i, entry, expect[i])
+    }
+  }
+
+  // Invalid manifest props are errors
+  //
+  invalid := NewSpec("invalid", nil)
+  invalid.Props["manifest"] = 5
+  if err := BuildTaskEmitManifest(invalid); err == nil {
+    t.Errorf("Expected an error with a numeric manifest prop")
+  }
+}
+
+
+func TestTaskEmitManifestTransform (t *testing.T) {
+  // As the last task of its spec, the manifest task lists assets
+  // where the spec's path transformations place them
+  //
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"]        = true
+  subspec.Props["manifest"]  = true
+  subspec.Props["transform"] = map[string]any { "prefix": "blog" }
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for _, key := range []string { "index.html", "posts/first.html" } {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes([]byte("<p>" + key + "</p>"))
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]*Asset)
+
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      received[strings.TrimPrefix(strings.TrimLeft(asset.Url.Path, "/"), "@emit/")] = asset
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTransform)
+  root.AddSpecBuilder(BuildTaskEmitManifest)
+  if err := subspec.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  for _, key := range []string { "blog/index.html", "blog/posts/first.html", "blog/manifest.json" } {
+    if _, found := received[key]; !found {
+      t.Fatalf("Expected an asset at %s, got %v", key, received)
+    }
+  }
+
+  content, err := received["blog/manifest.json"].GetContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  var manifest Manifest
+  if err := json.Unmarshal(content, &manifest); err != nil {
+    t.Fatal(err)
+  }
+
+  var paths []string
+  for _, entry := range manifest.Assets {
+    paths = append(paths, entry.Path)
+  }
+
+  var expect = "/blog/index.html /blog/posts/first.html"
+  if got := strings.Join(paths, " "); got != expect {
+    t.Errorf("Expected manifest paths %s, got %s", expect, got)
+  }
+}
+
+func TestManifestEntryFileAsset (t *testing.T) {
+  var source_dir = t.TempDir()
+  var content    = bytes.Repeat([]byte("0123456789abcdef"), 1024)
+
+  root := NewSpec("root", nil)
+  root.Props["source_dir"]     = source_dir
+  root.Props["mmap_threshold"] = 1024
+  if err := root.WriteFile("large.bin", content, 0o644); err != nil {
+    t.Fatal(err)
+  }
+
+  asset, err := root.MakeFileKeyAsset(filepath.Join(source_dir, "large.bin"))
+  if err != nil { t.Fatal(err) }
+
+  // File assets are hashed as they are streamed, without their
+  // content being read into the asset
+  //
+  var sum = sha256.Sum256(content)
+
+  entry, err := manifestEntry(root, asset)
+  if err != nil { t.Fatal(err) }
+  if entry.Size != len(content) || entry.Sha256 != hex.EncodeToString(sum[:]) {
+    t.Errorf("Expected the size and hash of large.bin, got %+v", entry)
+  }
+
+  hash, err := s3ContentHash(asset)
+  if err != nil { t.Fatal(err) }
+  if hash != hex.EncodeToString(sum[:]) {
+    t.Errorf("Expected the deploy hash of large.bin to be %x, got %s", sum, hash)
+  }
+
+  if asset.ContentBytes != nil {
+    t.Errorf("Expected hashing not to fill the content of the asset, got %d bytes", len(asset.ContentBytes))
+  }
+
+  // Modified content is hashed instead of the file's
+  //
+  asset.SetContentBytes([]byte("modified"))
+  sum = sha256.Sum256([]byte("modified"))
+  if entry, err := manifestEntry(root, asset); err != nil || entry.Size != 8 || entry.Sha256 != hex.EncodeToString(sum[:]) {
+    t.Errorf("Expected the size and hash of the modified content, got %+v (%v)", entry, err)
+  }
+}
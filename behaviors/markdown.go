@@ -0,0 +1,197 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "github.com/yuin/goldmark"
+  "github.com/yuin/goldmark/ast"
+  "github.com/yuin/goldmark/extension"
+  "github.com/yuin/goldmark/text"
+
+  "bytes"
+  "fmt"
+  "net/url"
+  "path"
+  "strings"
+)
+
+
+var markdown_extensions = []string { ".md", ".markdown" }
+
+
+/*
+  TaskResolverRenderMarkdown resolves the "render-markdown" task,
+  which renders Markdown assets into HTML. Front matter is parsed
+  into the asset's Metadata, the asset's extension is rewritten to
+  ".html", and links to other Markdown documents are rewritten to
+  their rendered paths, with the spec's PathTransformations
+  applied.
+*/
+var TaskResolverRenderMarkdown = TaskResolver {
+  Id:   "render-markdown",
+  Name: "render-markdown",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "render-markdown", nil
+  },
+  TaskPrototype: Task {
+    Mask:      TASK_ASSETS_MUTATE,
+    MatchFunc: func (tk *Task, a *Asset) (bool, error) {
+      return isMarkdownAsset(a), nil
+    },
+    MapFunc: TaskMapRenderMarkdown,
+    Before:  []string { "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskRenderMarkdown defers the "render-markdown" task if the
+  spec's "markdown" prop is true.
+*/
+func BuildTaskRenderMarkdown (s *Spec) error {
+  if s.GetTaskResolverById("render-markdown") == nil {
+    render := TaskResolverRenderMarkdown
+    s.AddTaskResolver(&render)
+  }
+
+  markdown, ok, found := s.GetPropBool("markdown")
+  if !found {
+    return nil
+  } else if !ok {
+    return fmt.Errorf("Prop \"markdown\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["markdown"])
+  } else if !markdown {
+    return nil
+  }
+
+  task, err := s.GetTask("render-markdown", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the render-markdown task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+func isMarkdownAsset (a *Asset) bool {
+  if strings.HasPrefix(a.Mimetype, "text/markdown") {
+    return true
+  }
+
+  var ext = strings.ToLower(path.Ext(a.Url.Path))
+  for _, markdown_ext := range markdown_extensions {
+    if ext == markdown_ext {
+      return true
+    }
+  }
+  return false
+}
+
+
+/*
+  markdownHtmlPath replaces a Markdown file extension in a path
+  with ".html".
+*/
+func markdownHtmlPath (src string) string {
+  for _, ext := range markdown_extensions {
+    if strings.EqualFold(path.Ext(src), ext) {
+      return ReplacePathExtension(src, path.Ext(src), ".html")
+    }
+  }
+  return src
+}
+
+
+/*
+  markdownLinkDestination rewrites a link destination within a
+  Markdown document at base_url. Relative links to Markdown
+  documents are pointed at their rendered HTML, and then the
+  spec's PathTransformations are applied.
+*/
+func markdownLinkDestination (base_url *url.URL, destination string, transformations []*PathTransformation) string {
+  ref, err := url.Parse(destination)
+  if err != nil || ref.Scheme != "" || ref.Host != "" || ref.Path == "" {
+    return destination
+  }
+
+  if html_path := markdownHtmlPath(ref.Path); html_path != ref.Path {
+    var escaped_url = url.URL { Path: html_path }
+    _, suffix := SplitUrlPath(destination)
+    destination = escaped_url.EscapedPath() + suffix
+  }
+
+  if transformed, changed := TransformUrlReference(base_url, destination, transformations); changed {
+    return transformed
+  }
+  return destination
+}
+
+
+/*
+  TaskMapRenderMarkdown renders a Markdown asset into HTML, with
+  GitHub Flavored Markdown extensions.
+*/
+func TaskMapRenderMarkdown (a *Asset) (*Asset, error) {
+  content, err := a.GetContentBytes()
+  if err != nil { return nil, err }
+
+  front_matter, source, err := ParseFrontMatter(content)
+  if err != nil {
+    return nil, fmt.Errorf("Cannot render Markdown asset %s: %w", a.Url, err)
+  }
+
+  if front_matter != nil {
+    if a.Metadata == nil {
+      a.Metadata = make(map[string]any, len(front_matter))
+    }
+    for key, value := range front_matter {
+      a.Metadata[key] = value
+    }
+  }
+
+  // Rename the asset before rewriting links, as links are
+  // resolved relative to the rendered document
+  //
+  var html_url = *a.Url
+  html_url.Path    = markdownHtmlPath(html_url.Path)
+  html_url.RawPath = ""
+  a.Url = &html_url
+
+  var transformations []*PathTransformation
+  if a.Spec != nil {
+    transformations = a.Spec.PathTransformations
+  }
+
+  var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+  var document = markdown.Parser().Parse(text.NewReader(source))
+
+  err = ast.Walk(document, func (node ast.Node, entering bool) (ast.WalkStatus, error) {
+    if !entering {
+      return ast.WalkContinue, nil
+    }
+
+    switch node := node.(type) {
+      case *ast.Link:
+        node.Destination = []byte(markdownLinkDestination(a.Url, string(node.Destination), transformations))
+      case *ast.Image:
+        node.Destination = []byte(markdownLinkDestination(a.Url, string(node.Destination), transformations))
+    }
+    return ast.WalkContinue, nil
+  })
+  if err != nil { return nil, err }
+
+  var rendered bytes.Buffer
+  if err := markdown.Renderer().Render(&rendered, source, document); err != nil {
+    return nil, fmt.Errorf("Cannot render Markdown asset %s: %w", a.Url, err)
+  }
+
+  a.Mimetype = "text/html; charset=utf-8"
+  a.ClearContentDataCache()
+
+  if err := a.SetContentBytes(rendered.Bytes()); err != nil {
+    return nil, err
+  }
+
+  return a, nil
+}
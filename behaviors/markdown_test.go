@@ -0,0 +1,96 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "testing"
+  "strings"
+)
+
+
+func TestTaskRenderMarkdown (t *testing.T) {
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"]       = true
+  subspec.Props["markdown"] = true
+
+  transformations, err := PathTransformationsFromAny(map[string]any { "prefix": "docs" })
+  if err != nil { t.Fatal(err) }
+  subspec.PathTransformations = transformations
+
+  var markdown_src = strings.Join([]string {
+    `---`,
+    `title: Introduction`,
+    `tags: [a, b]`,
+    `---`,
+    `# Hello`,
+    ``,
+    `See the [guide](guide.md#setup), [the site](https://example.com/x.md),`,
+    `and ![a chart](chart.png).`,
+  }, "\n")
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for key, content := range map[string]string {
+      "intro.md":  markdown_src,
+      "notes.txt": "# Not markdown",
+    } {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes([]byte(content))
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]*Asset)
+
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      received[strings.TrimLeft(asset.Url.Path, "/")] = asset
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTaskRenderMarkdown)
+  if err := subspec.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if _, found := received["@emit/docs/notes.txt"]; !found {
+    t.Errorf("Expected notes.txt to pass through unrendered, got %v", received)
+  }
+
+  intro, found := received["@emit/docs/intro.html"]
+  if !found {
+    t.Fatalf("Expected a rendered intro.html asset, got %v", received)
+  }
+
+  if got, expect := intro.Mimetype, "text/html; charset=utf-8"; got != expect {
+    t.Errorf("Expected MIME type %s, got %s", expect, got)
+  }
+
+  if got := intro.Metadata["title"]; got != "Introduction" {
+    t.Errorf("Expected a title from front matter, got %v", intro.Metadata)
+  }
+
+  content, err := intro.GetContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  var expect = strings.Join([]string {
+    `<h1>Hello</h1>`,
+    `<p>See the <a href="/docs/guide.html#setup">guide</a>, <a href="https://example.com/x.md">the site</a>,`,
+    `and <img src="/docs/chart.png" alt="a chart">.</p>`,
+    ``,
+  }, "\n")
+
+  if got := string(content); got != expect {
+    t.Errorf("Expected rendered HTML:\n%s\ngot:\n%s", expect, got)
+  }
+}
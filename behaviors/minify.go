@@ -0,0 +1,186 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "github.com/tdewolff/minify/v2"
+  "github.com/tdewolff/minify/v2/css"
+  "github.com/tdewolff/minify/v2/js"
+
+  "fmt"
+  "mime"
+  "path"
+  "strings"
+)
+
+
+/*
+  minify_funcs maps media types, without parameters, to the
+  minifier which handles them.
+*/
+var minify_funcs = map[string]minify.MinifierFunc {
+  "text/css":               css.Minify,
+  "text/javascript":        js.Minify,
+  "application/javascript": js.Minify,
+  "application/ecmascript": js.Minify,
+  "text/ecmascript":        js.Minify,
+}
+
+
+var minifier = newMinifier()
+
+
+func newMinifier () *minify.M {
+  var m = minify.New()
+  for mediatype, fn := range minify_funcs {
+    m.AddFunc(mediatype, fn)
+  }
+  return m
+}
+
+
+/*
+  TaskResolverMinifyCss resolves the "minify-css" task, which
+  minifies CSS assets.
+*/
+var TaskResolverMinifyCss = TaskResolver {
+  Id:   "minify-css",
+  Name: "minify-css",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "minify-css", nil
+  },
+  TaskPrototype: Task {
+    Mask:      TASK_ASSETS_MUTATE,
+    MatchFunc: minifyMatchFunc("text/css"),
+    MapFunc:   TaskMapMinify,
+    Before:    []string { "root-consume" },
+  },
+}
+
+
+/*
+  TaskResolverMinifyJs resolves the "minify-js" task, which
+  minifies JavaScript assets.
+*/
+var TaskResolverMinifyJs = TaskResolver {
+  Id:   "minify-js",
+  Name: "minify-js",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "minify-js", nil
+  },
+  TaskPrototype: Task {
+    Mask:      TASK_ASSETS_MUTATE,
+    MatchFunc: minifyMatchFunc(
+      "text/javascript", "application/javascript",
+      "application/ecmascript", "text/ecmascript",
+    ),
+    MapFunc:   TaskMapMinify,
+    Before:    []string { "root-consume" },
+  },
+}
+
+
+/*
+  BuildTasksMinify defers the "minify-css" and "minify-js" tasks.
+  The "minify" prop enables both, and the "minify_css" and
+  "minify_js" props enable or disable each individually,
+  overriding "minify".
+*/
+func BuildTasksMinify (s *Spec) error {
+  minify_all, ok, found := s.GetPropBool("minify")
+  if found && !ok {
+    return fmt.Errorf("Prop \"minify\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["minify"])
+  }
+
+  for _, resolver := range []*TaskResolver { &TaskResolverMinifyCss, &TaskResolverMinifyJs } {
+    if s.GetTaskResolverById(resolver.Id) == nil {
+      build := *resolver
+      s.AddTaskResolver(&build)
+    }
+
+    var prop_key = strings.ReplaceAll(resolver.Id, "-", "_")
+    var enabled  = minify_all
+
+    if prop_enabled, ok, found := s.GetPropBool(prop_key); found && !ok {
+      return fmt.Errorf("Prop \"%s\" in spec %s is expected to be a boolean, got %T", prop_key, s.Name, s.Props[prop_key])
+    } else if found {
+      enabled = prop_enabled
+    }
+
+    if !enabled {
+      continue
+    }
+
+    task, err := s.GetTask(resolver.Name, s)
+    if err != nil {
+      return err
+    } else if task == nil {
+      return fmt.Errorf("Could not resolve the %s task in spec %s", resolver.Name, s.Name)
+    }
+
+    if err := s.DeferTask(task); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+
+/*
+  minifyMediaType returns an asset's media type without
+  parameters, such as a charset. If the asset has no Mimetype, it
+  is inferred from the URL's file extension.
+*/
+func minifyMediaType (a *Asset) string {
+  var mimetype = a.Mimetype
+  if mimetype == "" && a.Url != nil {
+    mimetype = mime.TypeByExtension(path.Ext(a.Url.Path))
+  }
+
+  mediatype, _, err := mime.ParseMediaType(mimetype)
+  if err != nil {
+    return ""
+  }
+  return mediatype
+}
+
+
+func minifyMatchFunc (mediatypes ...string) func (*Task, *Asset) (bool, error) {
+  return func (tk *Task, a *Asset) (bool, error) {
+    var asset_mediatype = minifyMediaType(a)
+    for _, mediatype := range mediatypes {
+      if asset_mediatype == mediatype {
+        return true, nil
+      }
+    }
+    return false, nil
+  }
+}
+
+
+/*
+  TaskMapMinify minifies an asset with the minifier for its media
+  type. Assets of other media types are returned unchanged.
+*/
+func TaskMapMinify (a *Asset) (*Asset, error) {
+  var mediatype = minifyMediaType(a)
+  if _, found := minify_funcs[mediatype]; !found {
+    return a, nil
+  }
+
+  content, err := a.GetContentBytes()
+  if err != nil { return nil, err }
+
+  minified, err := minifier.Bytes(mediatype, content)
+  if err != nil {
+    return nil, fmt.Errorf("Cannot minify asset %s: %w", a.Url, err)
+  }
+
+  a.ClearContentDataCache()
+  if err := a.SetContentBytes(minified); err != nil {
+    return nil, err
+  }
+
+  return a, nil
+}
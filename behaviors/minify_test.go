@@ -0,0 +1,110 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "testing"
+  "strings"
+)
+
+
+func TestTasksMinify (t *testing.T) {
+  var test_cases = []struct {
+    name   string
+    props  map[string]any
+    expect map[string]string
+  } {
+    {
+      name:  "all",
+      props: map[string]any { "minify": true },
+      expect: map[string]string {
+        "@emit/style.css": "body{color:red}",
+        "@emit/app.js":    "function add(e,t){return e+t}",
+      },
+    },
+    {
+      name:  "css only",
+      props: map[string]any { "minify": true, "minify_js": false },
+      expect: map[string]string {
+        "@emit/style.css": "body{color:red}",
+        "@emit/app.js":    "function add (a, b) {\n  return a + b;\n}\n",
+      },
+    },
+    {
+      name:  "disabled",
+      props: map[string]any {},
+      expect: map[string]string {
+        "@emit/style.css": "body {\n  color: red;\n}\n",
+        "@emit/app.js":    "function add (a, b) {\n  return a + b;\n}\n",
+      },
+    },
+  }
+
+  for _, test_case := range test_cases {
+    t.Run(test_case.name, func (t *testing.T) {
+      root    := NewSpec("root", nil)
+      subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+      root.Props["quiet"] = true
+      for key, value := range test_case.props {
+        subspec.Props[key] = value
+      }
+
+      subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+        for key, content := range map[string]string {
+          "style.css":  "body {\n  color: red;\n}\n",
+          "app.js":     "function add (a, b) {\n  return a + b;\n}\n",
+          "notes.txt":  "  spaced  \n",
+        } {
+          asset := s.MakeAsset(key)
+          asset.SetContentBytes([]byte(content))
+          if err := tk.EmitAsset(asset); err != nil {
+            return err
+          }
+        }
+        return nil
+      })
+
+      var received = make(map[string]string)
+
+      root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+        if err := tk.PoolSpecInputAssets(); err != nil {
+          return err
+        }
+        for _, asset := range tk.Assets {
+          content, err := asset.GetContentBytes()
+          if err != nil { return err }
+          received[strings.TrimLeft(asset.Url.Path, "/")] = string(content)
+        }
+        return nil
+      })
+
+      root.AddSpecBuilder(BuildTasksMinify)
+      if err := subspec.Build(); err != nil {
+        t.Fatal(err)
+      }
+
+      TestWrapTimeoutError(t, root.Run)
+
+      if got, expect := received["@emit/notes.txt"], "  spaced  \n"; got != expect {
+        t.Errorf("Expected notes.txt to pass through unchanged, got %q", got)
+      }
+
+      for key, expect := range test_case.expect {
+        if got := received[key]; got != expect {
+          t.Errorf("Expected %s to be %q, got %q", key, expect, got)
+        }
+      }
+    })
+  }
+}
+
+
+func TestBuildTasksMinifyPropType (t *testing.T) {
+  spec := NewSpec("spec", nil)
+  spec.Props["minify_css"] = "yes"
+
+  if err := BuildTasksMinify(spec); err == nil {
+    t.Error("Expected an error for a non-boolean minify_css prop")
+  }
+}
@@ -0,0 +1,148 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "encoding/json"
+  "fmt"
+  "os"
+  "path"
+  "regexp"
+)
+
+
+var (
+  // outDir: "site" in Vite configurations
+  //
+  vite_out_dir_regexp = regexp.MustCompile(`\boutDir\s*:\s*['"` + "`" + `]([^'"` + "`" + `]+)['"` + "`" + `]`)
+
+  // output: { path: path.resolve(__dirname, "site") } in webpack
+  // configurations, or with a plain string path
+  //
+  webpack_output_path_regexp = regexp.MustCompile(
+    `\boutput\s*:\s*\{[^}]*?\bpath\s*:\s*(?:path\.(?:resolve|join)\(\s*__dirname\s*,\s*)?['"` + "`" + `]([^'"` + "`" + `]+)['"` + "`" + `]`,
+  )
+
+  // --outDir site, --out-dir=site, or --output-path site in a
+  // package.json build script
+  //
+  script_out_dir_regexp = regexp.MustCompile(`--(?:outDir|out-dir|output-path)(?:=|\s+)(['"]?)([^'"\s]+)(['"]?)`)
+)
+
+
+var nodejs_config_extensions = []string { ".js", ".mjs", ".cjs", ".ts", ".mts", ".cts" }
+
+
+/*
+  nodeJSEmitDir returns the build directory configured by the
+  "emit" prop, either as a path string or as an object with a
+  "dir" path. Returns an empty string if neither is defined.
+*/
+func nodeJSEmitDir (s *Spec) (string, error) {
+  emit_any, found := s.GetProp("emit")
+  if !found {
+    return "", nil
+  }
+
+  switch emit := emit_any.(type) {
+    case string:
+      return emit, nil
+    case map[string]any:
+      dir_any, found := emit["dir"]
+      if !found {
+        return "", nil
+      }
+      if dir, ok := dir_any.(string); ok {
+        return dir, nil
+      }
+      return "", fmt.Errorf("Prop \"emit.dir\" in spec %s is expected to be a string, got %T", s.Name, dir_any)
+  }
+
+  return "", fmt.Errorf("Prop \"emit\" in spec %s is expected to be a string or object, got %T", s.Name, emit_any)
+}
+
+
+/*
+  nodeJSConfigOutDir searches the spec's source_dir for
+  configuration files with the given base name, such as
+  "vite.config", and returns the first output directory matched
+  by the expression, if any.
+*/
+func nodeJSConfigOutDir (s *Spec, base string, expr *regexp.Regexp) (string, error) {
+  for _, ext := range nodejs_config_extensions {
+    config_path, err := s.GetKeyPath(base + ext)
+    if err != nil { return "", err }
+
+    content, err := os.ReadFile(config_path)
+    if os.IsNotExist(err) {
+      continue
+    } else if err != nil {
+      return "", err
+    }
+
+    if match := expr.FindSubmatch(content); match != nil {
+      return string(match[1]), nil
+    }
+  }
+  return "", nil
+}
+
+
+/*
+  nodeJSScriptOutDir returns the output directory passed as a
+  flag in the package.json build script, if any.
+*/
+func nodeJSScriptOutDir (s *Spec) (string, error) {
+  package_path, err := s.GetKeyPath("package.json")
+  if err != nil { return "", err }
+
+  content, err := os.ReadFile(package_path)
+  if os.IsNotExist(err) {
+    return "", nil
+  } else if err != nil {
+    return "", err
+  }
+
+  var package_json struct {
+    Scripts map[string]string `json:"scripts"`
+  }
+  if err := json.Unmarshal(content, &package_json); err != nil {
+    return "", fmt.Errorf("Cannot parse package.json in spec %s: %w", s.Name, err)
+  }
+
+  if match := script_out_dir_regexp.FindStringSubmatch(package_json.Scripts["build"]); match != nil {
+    return match[2], nil
+  }
+  return "", nil
+}
+
+
+/*
+  nodeJSBuildDirs returns the directories, relative to
+  source_dir, in which a NodeJS build may write its output, in
+  order of precedence. If the output directory is configured, by
+  the "emit" prop, a build script flag, or a Vite or webpack
+  configuration file, only that directory is returned, and
+  configured is true. Otherwise, the conventional "dist" and
+  "build" directories are returned.
+*/
+func nodeJSBuildDirs (s *Spec) (dirs []string, configured bool, err error) {
+  var finders = []func () (string, error) {
+    func () (string, error) { return nodeJSEmitDir(s) },
+    func () (string, error) { return nodeJSScriptOutDir(s) },
+    func () (string, error) { return nodeJSConfigOutDir(s, "vite.config",    vite_out_dir_regexp) },
+    func () (string, error) { return nodeJSConfigOutDir(s, "webpack.config", webpack_output_path_regexp) },
+  }
+
+  for _, find := range finders {
+    dir, err := find()
+    if err != nil {
+      return nil, false, err
+    }
+
+    if dir != "" {
+      return []string { path.Clean(dir) }, true, nil
+    }
+  }
+
+  return []string { "dist", "build" }, false, nil
+}
@@ -0,0 +1,91 @@
+package behaviors
+
+import (
+  "testing"
+  . "gilchrist.tech/interbuilder"
+
+  "slices"
+)
+
+
+func TestNodeJSBuildDirs (t *testing.T) {
+  var cases = []struct {
+    Name       string
+    Files      map[string]string
+    Emit       any
+    Expect     []string
+    Configured bool
+  } {
+    {
+      Name:   "defaults",
+      Files:  map[string]string { "package.json": `{"scripts": {"build": "node build.js"}}` },
+      Expect: []string { "dist", "build" },
+    },
+    {
+      Name:       "vite outDir",
+      Files:      map[string]string { "vite.config.ts": "export default defineConfig({\n  build: { outDir: 'public/app' },\n})" },
+      Expect:     []string { "public/app" },
+      Configured: true,
+    },
+    {
+      Name:       "webpack output path",
+      Files:      map[string]string { "webpack.config.js": "module.exports = {\n  output: {\n    filename: 'main.js',\n    path: path.resolve(__dirname, \"out\"),\n  },\n}" },
+      Expect:     []string { "out" },
+      Configured: true,
+    },
+    {
+      Name: "build script flag over vite",
+      Files: map[string]string {
+        "package.json":   `{"scripts": {"build": "vite build --outDir=./www"}}`,
+        "vite.config.js": "export default { build: { outDir: 'ignored' } }",
+      },
+      Expect:     []string { "www" },
+      Configured: true,
+    },
+    {
+      Name:       "emit prop string",
+      Files:      map[string]string { "vite.config.js": "export default { build: { outDir: 'ignored' } }" },
+      Emit:       "site",
+      Expect:     []string { "site" },
+      Configured: true,
+    },
+    {
+      Name:       "emit.dir prop",
+      Emit:       map[string]any { "dir": "site/" },
+      Expect:     []string { "site" },
+      Configured: true,
+    },
+  }
+
+  for _, c := range cases {
+    t.Run(c.Name, func (t *testing.T) {
+      spec := NewSpec("node", nil)
+      spec.Props["source_dir"] = t.TempDir()
+      if c.Emit != nil {
+        spec.Props["emit"] = c.Emit
+      }
+
+      for key, content := range c.Files {
+        if err := spec.WriteFile(key, []byte(content), 0o660); err != nil {
+          t.Fatal(err)
+        }
+      }
+
+      dirs, configured, err := nodeJSBuildDirs(spec)
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      if !slices.Equal(dirs, c.Expect) || configured != c.Configured {
+        t.Errorf("Expected %v (configured: %v), got %v (configured: %v)", c.Expect, c.Configured, dirs, configured)
+      }
+    })
+  }
+
+  invalid := NewSpec("invalid", nil)
+  invalid.Props["source_dir"] = t.TempDir()
+  invalid.Props["emit"]       = map[string]any { "dir": 5 }
+  if _, _, err := nodeJSBuildDirs(invalid); err == nil {
+    t.Errorf("Expected an error with a numeric emit.dir prop")
+  }
+}
@@ -0,0 +1,211 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "errors"
+  "fmt"
+  "io"
+  "math/rand"
+  "os"
+  "path/filepath"
+  "strconv"
+  "syscall"
+  "time"
+)
+
+
+/*
+  outputMode returns the inherited "output_mode" prop, which
+  defaults to "link".
+*/
+func outputMode (s *Spec) (string, error) {
+  mode, ok, found := s.InheritPropString("output_mode")
+  if found && !ok {
+    prop, _ := s.InheritProp("output_mode")
+    return "", fmt.Errorf("Prop \"output_mode\" in spec %s is expected to be a string, got %T", s.Name, prop)
+  }
+
+  switch mode {
+  case "":
+    return "link", nil
+  case "link", "copy", "reflink", "symlink":
+    return mode, nil
+  }
+  return "", fmt.Errorf("Prop \"output_mode\" in spec %s is expected to be link, copy, reflink, or symlink, got %s", s.Name, mode)
+}
+
+
+/*
+  tempSiblingPath returns an unused path in the same directory as
+  a file, which it can be written at and then renamed over the
+  file, so that the file is replaced atomically.
+*/
+func tempSiblingPath (file_path string) string {
+  var dir, base = filepath.Split(file_path)
+  return filepath.Join(dir, "." + base + ".tmp-" + strconv.FormatUint(rand.Uint64(), 36))
+}
+
+
+/*
+  replaceFile creates a file at a temporary path next to
+  file_path with the create function, and renames it over
+  file_path. If creating or renaming it fails, the temporary file
+  is removed.
+*/
+func replaceFile (file_path string, create func (temp_path string) error) error {
+  var temp_path = tempSiblingPath(file_path)
+
+  if err := create(temp_path); err != nil {
+    os.Remove(temp_path)
+    return err
+  }
+  if err := os.Rename(temp_path, file_path); err != nil {
+    os.Remove(temp_path)
+    return err
+  }
+  return nil
+}
+
+
+/*
+  writeFileAtomic writes the content of a reader to a file, by
+  renaming a temporary file over it, so that readers never see a
+  partially written file. If perm is not zero, or mod_time is not
+  the zero time, the file's permissions or modification time are
+  set to them.
+*/
+func writeFileAtomic (file_path string, content io.Reader, perm os.FileMode, mod_time time.Time) error {
+  return replaceFile(file_path, func (temp_path string) error {
+    file, err := os.OpenFile(temp_path, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0o666)
+    if err != nil { return err }
+
+    if _, err := io.Copy(file, content); err != nil {
+      file.Close()
+      return err
+    }
+    if err := file.Close(); err != nil {
+      return err
+    }
+    return setFileAttributes(temp_path, perm, mod_time)
+  })
+}
+
+
+/*
+  setFileAttributes sets the permissions of a file if perm is not
+  zero, and its modification time if mod_time is not zero.
+*/
+func setFileAttributes (file_path string, perm os.FileMode, mod_time time.Time) error {
+  if perm != 0 {
+    if err := os.Chmod(file_path, perm); err != nil {
+      return err
+    }
+  }
+  if !mod_time.IsZero() {
+    return os.Chtimes(file_path, time.Now(), mod_time)
+  }
+  return nil
+}
+
+
+/*
+  reflinkFileAtomic clones a file where the filesystem supports
+  sharing its blocks copy-on-write, and otherwise copies it, and
+  renames the result over dest.
+*/
+func reflinkFileAtomic (src, dest string, perm os.FileMode, mod_time time.Time) error {
+  return replaceFile(dest, func (temp_path string) error {
+    src_file, err := os.Open(src)
+    if err != nil { return err }
+    defer src_file.Close()
+
+    file, err := os.OpenFile(temp_path, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0o666)
+    if err != nil { return err }
+
+    if err := reflinkFile(file, src_file); err != nil {
+      if _, err := io.Copy(file, src_file); err != nil {
+        file.Close()
+        return err
+      }
+    }
+    if err := file.Close(); err != nil {
+      return err
+    }
+    return setFileAttributes(temp_path, perm, mod_time)
+  })
+}
+
+
+/*
+  assetFileAttributes returns the permissions and modification
+  time a file written from an asset is given: those captured from
+  its source file with the "preserve_metadata" prop, or else, if
+  preserve is true, those of its FileSource. Files whose content
+  was modified are only given permissions, so that their new
+  content is not mistaken for the old by tools comparing
+  modification times, such as rsync.
+*/
+func assetFileAttributes (asset *Asset, preserve bool) (os.FileMode, time.Time, error) {
+  var perm, mod_time = asset.FileMode, asset.FileModTime
+
+  if perm == 0 && mod_time.IsZero() && preserve && asset.FileSource != "" {
+    info, err := os.Stat(asset.FileSource)
+    if err != nil { return 0, time.Time{}, err }
+    perm, mod_time = info.Mode().Perm(), info.ModTime()
+  }
+
+  if asset.ContentModified {
+    mod_time = time.Time{}
+  }
+  return perm, mod_time, nil
+}
+
+
+/*
+  outputFile places the file at src at dest, according to an
+  output mode:
+
+    - link:    Hard-link the file. If src and dest are on different
+      filesystems, the file is copied instead.
+    - copy:    Copy the file's content.
+    - reflink: Clone the file copy-on-write, where the filesystem
+      supports it, and otherwise copy it.
+    - symlink: Make dest a symbolic link to the absolute path of
+      src.
+
+  Files are created at a temporary path and renamed over dest, so
+  that existing files are replaced atomically. If perm is not
+  zero, or mod_time is not the zero time, copies are given them,
+  as in assetFileAttributes.
+*/
+func outputFile (mode, src, dest string, perm os.FileMode, mod_time time.Time) error {
+  switch mode {
+  case "link":
+    err := replaceFile(dest, func (temp_path string) error {
+      return os.Link(src, temp_path)
+    })
+    if !errors.Is(err, syscall.EXDEV) {
+      return err
+    }
+    fallthrough
+
+  case "copy":
+    src_file, err := os.Open(src)
+    if err != nil { return err }
+    defer src_file.Close()
+    return writeFileAtomic(dest, src_file, perm, mod_time)
+
+  case "reflink":
+    return reflinkFileAtomic(src, dest, perm, mod_time)
+
+  case "symlink":
+    target, err := filepath.Abs(src)
+    if err != nil { return err }
+    return replaceFile(dest, func (temp_path string) error {
+      return os.Symlink(target, temp_path)
+    })
+  }
+
+  return fmt.Errorf("Unknown output mode %s", mode)
+}
@@ -0,0 +1,289 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "github.com/andybalholm/brotli"
+
+  "bytes"
+  "compress/gzip"
+  "fmt"
+  "io"
+  "strings"
+)
+
+
+/*
+  precompress_mime_prefixes are the MIME type prefixes of assets
+  which compress well. Most image, audio, and video formats are
+  already compressed.
+*/
+var precompress_mime_prefixes = []string {
+  "text/",
+  "application/javascript",
+  "application/json",
+  "application/manifest+json",
+  "application/xml",
+  "application/atom+xml",
+  "application/rss+xml",
+  "application/wasm",
+  "image/svg+xml",
+  "image/x-icon",
+  "image/vnd.microsoft.icon",
+  "font/ttf",
+  "font/otf",
+}
+
+
+/*
+  A PrecompressEncoding writes a compressed sibling of an asset,
+  with a file extension appended to its path.
+*/
+type PrecompressEncoding struct {
+  Name       string
+  Extension  string
+  Mimetype   string
+  NewWriter  func (io.Writer) io.WriteCloser
+}
+
+
+var precompress_encodings = []PrecompressEncoding {
+  {
+    Name:      "gzip",
+    Extension: ".gz",
+    Mimetype:  "application/gzip",
+    NewWriter: func (w io.Writer) io.WriteCloser {
+      writer, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+      return writer
+    },
+  },
+  {
+    Name:      "brotli",
+    Extension: ".br",
+    Mimetype:  "application/x-brotli",
+    NewWriter: func (w io.Writer) io.WriteCloser {
+      return brotli.NewWriterLevel(w, brotli.BestCompression)
+    },
+  },
+}
+
+
+/*
+  TaskResolverPrecompressAssets resolves the "precompress-assets"
+  task, which emits gzip and Brotli compressed copies of
+  compressible assets alongside them, such as "app.js.gz" and
+  "app.js.br", for static hosts which serve precompressed
+  content. It runs after other tasks which emit assets, and
+  before the root spec's "root-consume" task.
+*/
+var TaskResolverPrecompressAssets = TaskResolver {
+  Id:   "precompress-assets",
+  Name: "precompress-assets",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "precompress-assets", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_CONSUME | TASK_ASSETS_GENERATE,
+    Func:   TaskPrecompressAssets,
+    After:  []string { "fingerprint-assets", "emit-sitemap", "emit-feed", "emit-manifest" },
+    Before: []string { "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskPrecompressAssets defers the "precompress-assets" task
+  if the spec's "precompress" prop is true, or a list of
+  encodings.
+*/
+func BuildTaskPrecompressAssets (s *Spec) error {
+  if s.GetTaskResolverById("precompress-assets") == nil {
+    precompress := TaskResolverPrecompressAssets
+    s.AddTaskResolver(&precompress)
+  }
+
+  if _, found := s.GetProp("precompress"); !found {
+    return nil
+  }
+
+  encodings, err := precompressEncodings(s)
+  if err != nil {
+    return err
+  } else if len(encodings) == 0 {
+    return nil
+  }
+
+  task, err := s.GetTask("precompress-assets", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the precompress-assets task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  precompressEncodings reads the "precompress" prop, which is
+  either a boolean, enabling every encoding, or a list of
+  encoding names, "gzip" and "brotli".
+*/
+func precompressEncodings (s *Spec) ([]PrecompressEncoding, error) {
+  precompress_any, found := s.GetProp("precompress")
+  if !found {
+    return nil, nil
+  }
+
+  var names []string
+
+  switch precompress := precompress_any.(type) {
+    case bool:
+      if precompress {
+        return precompress_encodings, nil
+      }
+      return nil, nil
+    case string:
+      names = []string { precompress }
+    case []any:
+      for _, name_any := range precompress {
+        name, ok := name_any.(string)
+        if !ok {
+          return nil, fmt.Errorf("Prop \"precompress\" in spec %s is expected to contain strings, got %T", s.Name, name_any)
+        }
+        names = append(names, name)
+      }
+    default:
+      return nil, fmt.Errorf("Prop \"precompress\" in spec %s is expected to be a boolean or list of encodings, got %T", s.Name, precompress_any)
+  }
+
+  var encodings []PrecompressEncoding
+
+  names_loop:
+  for _, name := range names {
+    for _, encoding := range precompress_encodings {
+      if strings.EqualFold(name, encoding.Name) {
+        encodings = append(encodings, encoding)
+        continue names_loop
+      }
+    }
+    return nil, fmt.Errorf("Unknown precompress encoding %q in spec %s, expected gzip or brotli", name, s.Name)
+  }
+
+  return encodings, nil
+}
+
+
+func isCompressibleAsset (a *Asset) bool {
+  var mediatype = minifyMediaType(a)
+  for _, prefix := range precompress_mime_prefixes {
+    if strings.HasPrefix(mediatype, prefix) {
+      return true
+    }
+  }
+  return false
+}
+
+
+/*
+  precompressContent compresses content with an encoding.
+*/
+func precompressContent (encoding PrecompressEncoding, content []byte) ([]byte, error) {
+  var buffer bytes.Buffer
+  var writer = encoding.NewWriter(&buffer)
+
+  if _, err := writer.Write(content); err != nil {
+    return nil, err
+  }
+  if err := writer.Close(); err != nil {
+    return nil, err
+  }
+  return buffer.Bytes(), nil
+}
+
+
+/*
+  TaskPrecompressAssets pools the spec's input assets, forwards
+  them, and emits compressed copies of those with compressible
+  MIME types, such as text, scripts, and SVG images. Copies which
+  are not smaller than the original are not emitted. It reads the
+  following props:
+
+    - precompress:          True, or a list of encodings, "gzip"
+      and "brotli".
+    - precompress_min_size: The size, in bytes, below which assets
+      are not compressed. Defaults to 1024.
+*/
+func TaskPrecompressAssets (s *Spec, tk *Task) error {
+  encodings, err := precompressEncodings(s)
+  if err != nil { return err }
+
+  var min_size = 1024
+  if prop_min_size, ok, found := s.GetPropInt("precompress_min_size"); found && !ok {
+    return fmt.Errorf("Prop \"precompress_min_size\" in spec %s is expected to be an integer, got %T", s.Name, s.Props["precompress_min_size"])
+  } else if found {
+    min_size = prop_min_size
+  }
+
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets for precompression: %w", err)
+  }
+
+  var compressed []*Asset
+
+  for _, input := range tk.Assets {
+    assets, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+
+    for _, asset := range assets {
+      if !isCompressibleAsset(asset) {
+        continue
+      }
+
+      content, err := asset.GetContentBytes()
+      if err != nil { return err }
+
+      if len(content) < min_size {
+        continue
+      }
+
+      for _, encoding := range encodings {
+        compressed_content, err := precompressContent(encoding, content)
+        if err != nil {
+          return fmt.Errorf("Cannot compress asset %s with %s: %w", asset.Url, encoding.Name, err)
+        } else if len(compressed_content) >= len(content) {
+          continue
+        }
+
+        var compressed_url = *asset.Url
+        compressed_url.Path    = asset.Url.Path + encoding.Extension
+        compressed_url.RawPath = ""
+
+        var history = asset.ExtendHistory()
+        history.Url = &compressed_url
+
+        var compressed_asset = & Asset {
+          Url:      &compressed_url,
+          Spec:     asset.Spec,
+          History:  history,
+          Mimetype: encoding.Mimetype,
+          Metadata: map[string]any {
+            "content_encoding": encoding.Name,
+            "source_mimetype":  minifyMediaType(asset),
+          },
+        }
+        if err := compressed_asset.SetContentBytes(compressed_content); err != nil {
+          return err
+        }
+
+        compressed = append(compressed, compressed_asset)
+      }
+    }
+  }
+
+  if err := tk.ForwardAssets(); err != nil {
+    return err
+  }
+
+  return tk.EmitAssets(compressed)
+}
@@ -0,0 +1,127 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "github.com/andybalholm/brotli"
+
+  "bytes"
+  "compress/gzip"
+  "io"
+  "strings"
+  "testing"
+)
+
+
+func TestTaskPrecompressAssets (t *testing.T) {
+  var large_text = strings.Repeat("interbuilder ", 200)
+
+  var test_cases = []struct {
+    name   string
+    props  map[string]any
+    expect []string
+  } {
+    {
+      name:   "all encodings",
+      props:  map[string]any { "precompress": true },
+      expect: []string { "app.js.br", "app.js.gz", "index.html.br", "index.html.gz" },
+    },
+    {
+      name:   "gzip with a threshold",
+      props:  map[string]any { "precompress": []any { "gzip" }, "precompress_min_size": 3000 },
+      expect: []string { "index.html.gz" },
+    },
+  }
+
+  for _, test_case := range test_cases {
+    t.Run(test_case.name, func (t *testing.T) {
+      root    := NewSpec("root", nil)
+      subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+      root.Props["quiet"] = true
+      for key, value := range test_case.props {
+        subspec.Props[key] = value
+      }
+
+      var documents = map[string]string {
+        "index.html": "<p>" + large_text + large_text + "</p>",
+        "app.js":     "console.log(`" + large_text + "`)",
+        "small.css":  "body { margin: 0 }",
+        "photo.png":  large_text,
+      }
+
+      subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+        for key, content := range documents {
+          asset := s.MakeAsset(key)
+          asset.SetContentBytes([]byte(content))
+          if err := tk.EmitAsset(asset); err != nil {
+            return err
+          }
+        }
+        return nil
+      })
+
+      var received = make(map[string]*Asset)
+
+      root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+        if err := tk.PoolSpecInputAssets(); err != nil {
+          return err
+        }
+        for _, asset := range tk.Assets {
+          asset_path := strings.TrimLeft(asset.Url.Path, "/")
+          received[strings.TrimPrefix(asset_path, "@emit/")] = asset
+        }
+        return nil
+      })
+
+      root.AddSpecBuilder(BuildTaskPrecompressAssets)
+      if err := subspec.Build(); err != nil {
+        t.Fatal(err)
+      }
+
+      TestWrapTimeoutError(t, root.Run)
+
+      if got, expect := len(received), len(documents) + len(test_case.expect); got != expect {
+        t.Errorf("Expected %d assets, got %d: %v", expect, got, received)
+      }
+
+      for _, key := range test_case.expect {
+        asset, found := received[key]
+        if !found {
+          t.Errorf("Expected a compressed asset at %s, got %v", key, received)
+          continue
+        }
+
+        content, err := asset.GetContentBytes()
+        if err != nil { t.Fatal(err) }
+
+        var reader io.Reader
+        if strings.HasSuffix(key, ".gz") {
+          if reader, err = gzip.NewReader(bytes.NewReader(content)); err != nil {
+            t.Fatal(err)
+          }
+        } else {
+          reader = brotli.NewReader(bytes.NewReader(content))
+        }
+
+        decompressed, err := io.ReadAll(reader)
+        if err != nil { t.Fatal(err) }
+
+        var source = documents[key[:strings.LastIndexByte(key, '.')]]
+        if string(decompressed) != source {
+          t.Errorf("Expected %s to decompress to its source", key)
+        }
+      }
+    })
+  }
+}
+
+
+func TestBuildTaskPrecompressAssetsEncodings (t *testing.T) {
+  spec := NewSpec("spec", nil)
+  spec.Props["precompress"] = []any { "zstd" }
+
+  if err := BuildTaskPrecompressAssets(spec); err == nil {
+    t.Error("Expected an error for an unknown encoding")
+  }
+}
@@ -0,0 +1,133 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "fmt"
+  "strings"
+)
+
+
+/*
+  PresetStaticSite emits a directory of static files, unless its
+  source is inferred to have a build, renders Markdown, minifies
+  CSS and JavaScript, and emits a sitemap if the spec has a
+  "base_url".
+*/
+var PresetStaticSite = SpecPreset {
+  Name:        "static-site",
+  Description: "Emit static files, render Markdown, minify CSS and JavaScript, and emit a sitemap with a base_url",
+  Props:       map[string]any { "source_static": true, "markdown": true, "minify": true },
+  Builders:    []SpecBuilder { BuildTasksStatic, buildPresetSitemap },
+}
+
+
+/*
+  PresetNodeApp installs and builds a NodeJS package, emitting its
+  build directory, and precompresses its output.
+*/
+var PresetNodeApp = SpecPreset {
+  Name:        "node-app",
+  Description: "Install and build a NodeJS package, and precompress its output",
+  Props:       map[string]any { "precompress": true },
+  Builders:    []SpecBuilder { BuildTasksNodeJS },
+}
+
+
+/*
+  PresetMergeSites combines the output of subspecs, failing if
+  two of them output an asset at the same path, and receives
+  their assets in a reproducible order.
+*/
+var PresetMergeSites = SpecPreset {
+  Name:        "merge-sites",
+  Description: "Merge the output of subspecs, failing on conflicting paths, in a reproducible order",
+  Props:       map[string]any { "merge_conflicts": "error", "deterministic": "url" },
+}
+
+
+func init () {
+  RegisterPreset(&PresetStaticSite)
+  RegisterPreset(&PresetNodeApp)
+  RegisterPreset(&PresetMergeSites)
+}
+
+
+/*
+  BuildPreset applies the presets named by the spec's "preset"
+  prop, a name or an array of names, in order. Props set by the
+  spec take precedence over those of its presets, and those of
+  earlier presets over later ones. It is meant to run before the
+  builders which read the props presets set.
+*/
+func BuildPreset (s *Spec) error {
+  preset_any, found := s.GetProp("preset")
+  if !found {
+    return nil
+  }
+
+  presets, err := presetsFromProp(preset_any)
+  if err != nil {
+    return fmt.Errorf("Prop \"preset\" in spec %s %v", s.Name, err)
+  }
+
+  for _, preset := range presets {
+    if err := preset.Apply(s); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+
+/*
+  presetsFromProp returns the registered presets named by a
+  "preset" prop value. Its errors describe the value, to follow
+  the prop's name.
+*/
+func presetsFromProp (preset_any any) ([]*SpecPreset, error) {
+  var names []string
+
+  switch preset := preset_any.(type) {
+  case string:
+    names = []string { preset }
+  case []any:
+    for _, name_any := range preset {
+      name, ok := name_any.(string)
+      if !ok {
+        return nil, fmt.Errorf("is expected to contain preset names, got %T", name_any)
+      }
+      names = append(names, name)
+    }
+  default:
+    return nil, fmt.Errorf("is expected to be a preset name or an array of them, got %T", preset_any)
+  }
+
+  var presets = make([]*SpecPreset, 0, len(names))
+  for _, name := range names {
+    preset := GetPreset(name)
+    if preset == nil {
+      return nil, fmt.Errorf(
+        "names an unknown preset \"%s\", expected one of: %s",
+        name, strings.Join(PresetNames(), ", "),
+      )
+    }
+    presets = append(presets, preset)
+  }
+  return presets, nil
+}
+
+
+/*
+  buildPresetSitemap enables the "sitemap" prop of a spec which
+  has a "base_url" to list its pages under, if it is unset.
+*/
+func buildPresetSitemap (s *Spec) error {
+  if _, found := s.GetProp("sitemap"); found {
+    return nil
+  }
+  if base_url, ok, _ := s.InheritPropString("base_url"); ok && base_url != "" {
+    s.Props["sitemap"] = true
+  }
+  return nil
+}
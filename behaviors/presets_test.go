@@ -0,0 +1,108 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "testing"
+  "strings"
+)
+
+
+func TestBuildPreset (t *testing.T) {
+  root := NewSpec("root", nil)
+  site := root.AddSubspec(NewSpec("site", nil))
+
+  root.Props["quiet"]    = true
+  root.Props["base_url"] = "https://example.com/"
+  root.Props["preset"]   = "merge-sites"
+  site.Props["preset"]   = "static-site"
+
+  site.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for key, content := range map[string]string {
+      "page.md":   "# Page",
+      "style.css": "body {\n  color: red;\n}\n",
+    } {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes([]byte(content))
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]string)
+
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      content, err := asset.GetContentBytes()
+      if err != nil {
+        return err
+      }
+      asset_path := strings.TrimLeft(asset.Url.Path, "/")
+      received[strings.TrimPrefix(asset_path, "@emit/")] = string(content)
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildPreset)
+  root.AddSpecBuilder(BuildTaskRenderMarkdown)
+  root.AddSpecBuilder(BuildTasksMinify)
+  root.AddSpecBuilder(BuildTaskEmitSitemap)
+  for _, spec := range []*Spec { root, site } {
+    if err := spec.Build(); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if root.Props["merge_conflicts"] != "error" || root.Props["deterministic"] != "url" {
+    t.Errorf("Expected the merge-sites props in the root, got %v", root.Props)
+  }
+  if site.Props["markdown"] != true || site.Props["sitemap"] != true {
+    t.Errorf("Expected the static-site props in the subspec, got %v", site.Props)
+  }
+  if _, found := site.Props["merge_conflicts"]; found {
+    t.Errorf("Expected the root's preset not to apply to its subspec")
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if !strings.Contains(received["page.html"], "<h1>Page</h1>") {
+    t.Errorf("Expected the rendered page, got %v", received)
+  }
+  if received["style.css"] != "body{color:red}" {
+    t.Errorf("Expected the minified stylesheet, got %q", received["style.css"])
+  }
+  if !strings.Contains(received["sitemap.xml"], "https://example.com/page.html") {
+    t.Errorf("Expected a sitemap listing the page, got %q", received["sitemap.xml"])
+  }
+
+  // Props set by the spec take precedence over its presets, and
+  // earlier presets over later ones
+  //
+  var spec = NewSpec("spec", nil)
+  spec.Props["preset"] = []any { "merge-sites", "static-site" }
+  spec.Props["minify"] = false
+  spec.AddSpecBuilder(BuildPreset)
+  if err := spec.Build(); err != nil {
+    t.Fatal(err)
+  }
+  if spec.Props["minify"] != false || spec.Props["markdown"] != true || spec.Props["merge_conflicts"] != "error" {
+    t.Errorf("Expected the spec's minify prop and both presets' props, got %v", spec.Props)
+  }
+  if _, found := spec.Props["sitemap"]; found {
+    t.Errorf("Expected no sitemap without a base_url, got %v", spec.Props["sitemap"])
+  }
+
+  for _, preset := range []any { "blog-site", []any { "static-site", 1 }, 42 } {
+    var spec = NewSpec("spec", nil)
+    spec.Props["preset"] = preset
+    spec.AddSpecBuilder(BuildPreset)
+    if err := spec.Build(); err == nil || !strings.Contains(err.Error(), "Prop \"preset\"") {
+      t.Errorf("Preset %v: expected a prop error, got %v", preset, err)
+    }
+  }
+}
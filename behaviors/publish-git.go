@@ -0,0 +1,244 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "errors"
+  "fmt"
+  "net/mail"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+)
+
+
+/*
+  TaskResolverPublishGit resolves the "publish-git" task, which
+  commits the assets which reach it to a branch of a git
+  repository and pushes it, as with GitHub Pages' "gh-pages"
+  branch. It runs after other tasks which change or emit assets,
+  and before the root spec's "root-consume" task.
+*/
+var TaskResolverPublishGit = TaskResolver {
+  Id:   "publish-git",
+  Name: "publish-git",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "publish-git", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_CONSUME | TASK_ASSETS_EMIT,
+    Func:   TaskPublishGit,
+    After:  []string {
+      "fingerprint-assets", "canonical-urls", "emit-sitemap", "emit-feed",
+      "emit-manifest", "precompress-assets",
+    },
+    Before: []string { "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskPublishGit defers the "publish-git" task if the spec
+  has a "git_publish" prop.
+*/
+func BuildTaskPublishGit (s *Spec) error {
+  if s.GetTaskResolverById("publish-git") == nil {
+    publish := TaskResolverPublishGit
+    s.AddTaskResolver(&publish)
+  }
+
+  repository, ok, found := s.GetPropString("git_publish")
+  if !found {
+    return nil
+  } else if !ok || repository == "" {
+    return fmt.Errorf("Prop \"git_publish\" in spec %s is expected to be a repository, got %v", s.Name, s.Props["git_publish"])
+  }
+
+  task, err := s.GetTask("publish-git", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the publish-git task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  clearWorkTree removes everything in a git work tree except its
+  .git directory.
+*/
+func clearWorkTree (work_dir string) error {
+  dirents, err := os.ReadDir(work_dir)
+  if err != nil { return err }
+
+  for _, dirent := range dirents {
+    if dirent.Name() == ".git" {
+      continue
+    }
+    if err := os.RemoveAll(filepath.Join(work_dir, dirent.Name())); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+
+/*
+  TaskPublishGit pools the spec's input assets, forwards them, and
+  publishes them to a branch of a git repository. The branch is
+  fetched into a temporary work tree, or created without history
+  if it does not exist, its files are replaced with the assets,
+  and if anything changed, a commit is made and pushed. It reads
+  the following props:
+
+    - git_publish:         The repository URL or path. Required.
+    - git_publish_branch:  The branch to publish to. Defaults to
+      "gh-pages".
+    - git_publish_message: The commit message, a template as in
+      Task.ExpandTemplate. Defaults to "Publish {{spec}}".
+    - git_publish_author:  The commit author, such as
+      "Name <name@example.com>". Defaults to the git configuration.
+    - dry_run:             Inherited. If true, print the changes
+      which would be committed, without committing or pushing.
+    - git_bin:             Inherited. The git executable. Defaults
+      to "git".
+*/
+func TaskPublishGit (s *Spec, tk *Task) error {
+  repository, _, _ := s.GetPropString("git_publish")
+
+  // Git runs in a temporary work tree, so relative repository
+  // paths are resolved against source_dir. URLs, and scp-style
+  // "host:path" locations, contain a colon.
+  //
+  if !strings.Contains(repository, ":") && !filepath.IsAbs(repository) {
+    source_dir, _, _ := s.InheritPropString("source_dir")
+    repository_path, err := filepath.Abs(filepath.Join(source_dir, repository))
+    if err != nil { return err }
+    repository = repository_path
+  }
+
+  git, err := inheritExecutable(s, "git_bin", "git")
+  if err != nil { return err }
+
+  var branch = "gh-pages"
+  if prop_branch, ok, found := s.GetPropString("git_publish_branch"); found && (!ok || prop_branch == "") {
+    return fmt.Errorf("Prop \"git_publish_branch\" in spec %s is expected to be a branch name, got %v", s.Name, s.Props["git_publish_branch"])
+  } else if found {
+    branch = prop_branch
+  }
+
+  var message = "Publish {{spec}}"
+  if prop_message, ok, found := s.GetPropString("git_publish_message"); found && !ok {
+    return fmt.Errorf("Prop \"git_publish_message\" in spec %s is expected to be a string, got %T", s.Name, s.Props["git_publish_message"])
+  } else if found && prop_message != "" {
+    message = prop_message
+  }
+  if message, err = tk.ExpandTemplate(message); err != nil {
+    return fmt.Errorf("Cannot expand git_publish_message in spec %s: %w", s.Name, err)
+  }
+
+  if author, ok, found := s.GetPropString("git_publish_author"); found && !ok {
+    return fmt.Errorf("Prop \"git_publish_author\" in spec %s is expected to be a string, got %T", s.Name, s.Props["git_publish_author"])
+  } else if author != "" {
+    address, err := mail.ParseAddress(author)
+    if err != nil {
+      return fmt.Errorf("Cannot parse git_publish_author in spec %s: %w", s.Name, err)
+    }
+    if tk.Env == nil {
+      tk.Env = make(map[string]string)
+    }
+    tk.Env["GIT_AUTHOR_NAME"]     = address.Name
+    tk.Env["GIT_AUTHOR_EMAIL"]    = address.Address
+    tk.Env["GIT_COMMITTER_NAME"]  = address.Name
+    tk.Env["GIT_COMMITTER_EMAIL"] = address.Address
+  }
+
+  dry_run, ok, found := s.InheritPropBool("dry_run")
+  if found && !ok {
+    return fmt.Errorf("Prop \"dry_run\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["dry_run"])
+  }
+
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets to publish: %w", err)
+  }
+
+  var assets []*Asset
+  for _, input := range tk.Assets {
+    flattened, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+    assets = append(assets, flattened...)
+  }
+
+  if err := tk.ForwardAssets(); err != nil {
+    return err
+  }
+
+  work_dir, err := os.MkdirTemp("", "interbuilder-publish-")
+  if err != nil { return err }
+  defer os.RemoveAll(work_dir)
+
+  // runGit runs a git command in the work tree
+  //
+  var runGit = func (args ...string) error {
+    if _, err := tk.CommandRun(git, append([]string { "-C", work_dir }, args...)...); err != nil {
+      return fmt.Errorf("Cannot publish to %s: git %s: %w", repository, args[0], err)
+    }
+    return nil
+  }
+
+  if err := runGit("init", "--quiet"); err != nil {
+    return err
+  }
+
+  // Check out the branch, or start it without history
+  //
+  heads, err := tk.Command(git, "-C", work_dir, "ls-remote", "--heads", repository, branch).Output()
+  if err != nil {
+    return fmt.Errorf("Cannot list branches of %s: %w", repository, err)
+  }
+
+  if strings.TrimSpace(string(heads)) == "" {
+    if err := runGit("checkout", "--quiet", "--orphan", branch); err != nil {
+      return err
+    }
+  } else {
+    if err := runGit("fetch", "--quiet", "--depth", "1", repository, branch); err != nil {
+      return err
+    }
+    if err := runGit("checkout", "--quiet", "-B", branch, "FETCH_HEAD"); err != nil {
+      return err
+    }
+  }
+
+  if err := clearWorkTree(work_dir); err != nil {
+    return err
+  }
+  if _, err := stageAssets(s, assets, work_dir); err != nil {
+    return err
+  }
+  if err := runGit("add", "--all"); err != nil {
+    return err
+  }
+
+  // `git diff --cached --quiet` exits with 1 if there are changes
+  //
+  var exit_err *exec.ExitError
+  if err := tk.Command(git, "-C", work_dir, "diff", "--cached", "--quiet").Run(); err == nil {
+    tk.Println("No changes to publish to", repository, branch)
+    return nil
+  } else if !errors.As(err, &exit_err) || exit_err.ExitCode() != 1 {
+    return fmt.Errorf("Cannot compare changes to publish: %w", err)
+  }
+
+  if dry_run {
+    return runGit("status", "--short")
+  }
+
+  if err := runGit("commit", "--quiet", "-m", message); err != nil {
+    return err
+  }
+  return runGit("push", "--quiet", repository, "HEAD:refs/heads/" + branch)
+}
@@ -0,0 +1,114 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+func TestTaskPublishGit (t *testing.T) {
+  if _, err := exec.LookPath("git"); err != nil {
+    t.Skip("git is not installed")
+  }
+
+  var dir        = t.TempDir()
+  var repository = filepath.Join(dir, "site.git")
+
+  if output, err := exec.Command("git", "init", "--quiet", "--bare", repository).CombinedOutput(); err != nil {
+    t.Fatalf("Cannot create a repository: %v: %s", err, output)
+  }
+
+  // gitOutput runs git in the repository
+  //
+  var gitOutput = func (args ...string) string {
+    t.Helper()
+    output, err := exec.Command("git", append([]string { "--git-dir", repository }, args...)...).CombinedOutput()
+    if err != nil {
+      t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, output)
+    }
+    return strings.TrimSpace(string(output))
+  }
+
+  var publish = func (props map[string]any, documents map[string]string) {
+    t.Helper()
+
+    root    := NewSpec("root", nil)
+    subspec := root.AddSubspec(NewSpec("site", nil))
+
+    root.Props["quiet"]      = true
+    root.Props["source_dir"] = dir
+    subspec.Props["git_publish"]        = "site.git"
+    subspec.Props["git_publish_author"] = "Publisher <publisher@example.com>"
+    for key, value := range props {
+      subspec.Props[key] = value
+    }
+
+    subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+      for key, content := range documents {
+        asset := s.MakeAsset(key)
+        asset.SetContentBytes([]byte(content))
+        if err := tk.EmitAsset(asset); err != nil {
+          return err
+        }
+      }
+      return nil
+    })
+
+    root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+      return tk.PoolSpecInputAssets()
+    })
+
+    root.AddSpecBuilder(BuildTaskPublishGit)
+    if err := subspec.Build(); err != nil {
+      t.Fatal(err)
+    }
+
+    TestWrapTimeoutError(t, root.Run)
+  }
+
+  publish(nil, map[string]string {
+    "index.html":    "<p>One</p>",
+    "css/style.css": "body { margin: 0 }",
+  })
+
+  if got := gitOutput("log", "--format=%s %an", "gh-pages"); got != "Publish site Publisher" {
+    t.Errorf("Expected a commit on gh-pages, got %q", got)
+  }
+
+  // A dry run, or a publish without changes, makes no commit
+  //
+  publish(map[string]any { "dry_run": true }, map[string]string { "index.html": "<p>Two</p>" })
+  publish(nil, map[string]string {
+    "index.html":    "<p>One</p>",
+    "css/style.css": "body { margin: 0 }",
+  })
+
+  if got := gitOutput("rev-list", "--count", "gh-pages"); got != "1" {
+    t.Errorf("Expected one commit, got %s", got)
+  }
+
+  // Removed files are removed from the branch
+  //
+  publish(
+    map[string]any { "git_publish_message": "Update {{prop \"git_publish_branch\"}}", "git_publish_branch": "pages" },
+    map[string]string { "index.html": "<p>Two</p>", "css/style.css": "body { margin: 0 }" },
+  )
+  publish(
+    map[string]any { "git_publish_message": "Update {{prop \"git_publish_branch\"}}", "git_publish_branch": "pages" },
+    map[string]string { "index.html": "<p>Three</p>" },
+  )
+
+  if got := gitOutput("log", "--format=%s", "pages"); got != "Update pages\nUpdate pages" {
+    t.Errorf("Expected two commits on pages, got %q", got)
+  }
+  if got := gitOutput("ls-tree", "-r", "--name-only", "pages"); got != "index.html" {
+    t.Errorf("Expected only index.html on pages, got %q", got)
+  }
+  if got := gitOutput("show", "pages:index.html"); got != "<p>Three</p>" {
+    t.Errorf("Expected the latest index.html, got %q", got)
+  }
+}
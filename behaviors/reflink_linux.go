@@ -0,0 +1,27 @@
+package behaviors
+
+import (
+  "os"
+  "syscall"
+)
+
+
+/*
+  linux_ficlone is the FICLONE ioctl request, _IOW(0x94, 9, int),
+  which shares the blocks of one file with another on filesystems
+  such as Btrfs and XFS.
+*/
+const linux_ficlone = 0x40049409
+
+
+/*
+  reflinkFile clones the content of src into dest, copy-on-write.
+  An error is returned if the filesystem does not support it.
+*/
+func reflinkFile (dest, src *os.File) error {
+  _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dest.Fd(), linux_ficlone, src.Fd())
+  if errno != 0 {
+    return errno
+  }
+  return nil
+}
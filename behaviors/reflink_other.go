@@ -0,0 +1,17 @@
+//go:build !linux
+
+package behaviors
+
+import (
+  "errors"
+  "os"
+)
+
+
+/*
+  reflinkFile is only supported on Linux. Elsewhere, it returns an
+  error, so that files are copied instead.
+*/
+func reflinkFile (dest, src *os.File) error {
+  return errors.New("Reflinks are not supported on this platform")
+}
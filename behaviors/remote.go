@@ -0,0 +1,325 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bufio"
+  "bytes"
+  "context"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "time"
+)
+
+
+/*
+  TaskResolverRemoteRun resolves the "remote-run" task, which runs
+  a spec on a worker, an "interbuilder serve-api" process, and
+  emits the assets the worker outputs as they are output, so that
+  they reach the spec's parent and AssetFrame as if the spec ran
+  locally.
+*/
+var TaskResolverRemoteRun = TaskResolver {
+  Id:   "remote-run",
+  Name: "remote-run",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "remote-run", nil
+  },
+  TaskPrototype: Task {
+    Mask: TASK_ASSETS_GENERATE,
+  },
+}
+
+
+/*
+  remote_local_props are the props of a remote spec which are kept
+  by the local spec, rather than sent to its worker. Path
+  transformations are applied locally, as the spec is the root of
+  the worker's pipeline.
+*/
+var remote_local_props = map[string]bool {
+  "worker": true, "worker_token": true, "transform": true, "quiet": true,
+}
+
+
+/*
+  BuildRemoteSpec schedules a subspec onto a worker if it has a
+  "worker" prop, the URL of an "interbuilder serve-api" process.
+  The spec's props, except "worker", "worker_token", "transform",
+  and "quiet", and its subspecs, become the config of a run on the
+  worker, and are removed from the local spec, so that later
+  builders do not add tasks to it. Props inherited from ancestors
+  are not sent, except for "env". It is meant to be the first
+  spec builder. The inherited "worker_token" prop is sent as a
+  bearer token.
+*/
+func BuildRemoteSpec (s *Spec) error {
+  worker_any, found := s.Props["worker"]
+  if !found {
+    return nil
+  }
+
+  worker, ok := worker_any.(string)
+  if !ok || worker == "" {
+    return fmt.Errorf("Prop \"worker\" in spec %s is expected to be a URL, got %T", s.Name, worker_any)
+  } else if s.Parent == nil {
+    return fmt.Errorf("Prop \"worker\" in spec %s is only supported in subspecs", s.Name)
+  }
+
+  worker_url, err := url.Parse(worker)
+  if err != nil {
+    return fmt.Errorf("Prop \"worker\" in spec %s is not a valid URL: %w", s.Name, err)
+  }
+
+  token, ok, found := s.InheritPropString("worker_token")
+  if found && !ok {
+    prop, _ := s.InheritProp("worker_token")
+    return fmt.Errorf("Prop \"worker_token\" in spec %s is expected to be a string, got %T", s.Name, prop)
+  }
+
+  env, err := s.InheritEnv()
+  if err != nil { return err }
+
+  var config = make(map[string]any)
+  for key, value := range s.Props {
+    if !remote_local_props[key] {
+      config[key] = value
+      delete(s.Props, key)
+    }
+  }
+  if len(env) > 0 {
+    config["env"] = env
+  }
+
+  config_json, err := json.Marshal(config)
+  if err != nil {
+    return fmt.Errorf("Cannot encode the config of remote spec %s: %w", s.Name, err)
+  }
+
+  var remote = & remoteRun {
+    Worker: worker_url,
+    Token:  token,
+    Config: config_json,
+  }
+
+  // Each remote spec has its own resolver, as its task runs its
+  // own config
+  //
+  resolver := TaskResolverRemoteRun
+  resolver.TaskPrototype.Func = remote.Run
+  s.AddTaskResolver(&resolver)
+
+  task, err := s.GetTask("remote-run", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the remote-run task in spec %s", s.Name)
+  }
+
+  return s.EnqueueTask(task)
+}
+
+
+/*
+  remoteRun runs a spec's config on a worker.
+*/
+type remoteRun struct {
+  Worker  *url.URL
+  Token   string
+  Config  []byte
+}
+
+
+func (r *remoteRun) request (ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+  var request_url = r.Worker.JoinPath(path)
+
+  var body_reader io.Reader
+  if body != nil {
+    body_reader = bytes.NewReader(body)
+  }
+
+  request, err := http.NewRequestWithContext(ctx, method, request_url.String(), body_reader)
+  if err != nil { return nil, err }
+  if r.Token != "" {
+    request.Header.Set("Authorization", "Bearer " + r.Token)
+  }
+  if body != nil {
+    request.Header.Set("Content-Type", "application/json")
+  }
+  return http.DefaultClient.Do(request)
+}
+
+
+/*
+  responseError returns an error describing an unexpected
+  response from the worker, from its JSON "error" field, if it has
+  one.
+*/
+func responseError (response *http.Response) error {
+  var body struct { Error string `json:"error"` }
+  content, _ := io.ReadAll(io.LimitReader(response.Body, 1 << 16))
+  if json.Unmarshal(content, &body) == nil && body.Error != "" {
+    return fmt.Errorf("Worker responded with %s: %s", response.Status, body.Error)
+  }
+  return fmt.Errorf("Worker responded with %s", response.Status)
+}
+
+
+/*
+  Run is the Func of a remote-run task. It starts a run on the
+  worker, follows its events, fetching and emitting each asset the
+  worker outputs, and returns the run's error, if it fails. If the
+  task is cancelled, so is the run. Finished runs are removed from
+  the worker.
+*/
+func (r *remoteRun) Run (s *Spec, tk *Task) error {
+  var ctx = tk.Context()
+
+  response, err := r.request(ctx, "POST", "runs", r.Config)
+  if err != nil {
+    return fmt.Errorf("Cannot start a run on worker %s: %w", r.Worker, err)
+  }
+  if response.StatusCode != http.StatusAccepted {
+    defer response.Body.Close()
+    return fmt.Errorf("Cannot start a run on worker %s: %w", r.Worker, responseError(response))
+  }
+
+  var run ApiRunStatus
+  err = json.NewDecoder(response.Body).Decode(&run)
+  response.Body.Close()
+  if err != nil {
+    return fmt.Errorf("Cannot read the run started on worker %s: %w", r.Worker, err)
+  }
+
+  tk.Println("Running on worker " + r.Worker.String() + " as run " + run.Id)
+
+  // Clean up the run once it is finished, or cancel it if this
+  // task is cancelled
+  //
+  defer func () {
+    cleanup_ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+    defer cancel()
+    if response, err := r.request(cleanup_ctx, "DELETE", "runs/" + run.Id, nil); err == nil {
+      response.Body.Close()
+    }
+  }()
+
+  // Follow the run's events, reconnecting from the last event
+  // received if the stream is interrupted
+  //
+  var last_id   = -1
+  var failures  = 0
+  for {
+    end, err := r.followEvents(s, tk, run.Id, &last_id)
+    if end != nil {
+      if end.Status != SPEC_STATUS_SUCCEEDED.String() {
+        return fmt.Errorf("Run %s on worker %s %s: %s", run.Id, r.Worker, end.Status, end.Error)
+      }
+      return nil
+    }
+    if ctx.Err() != nil {
+      return context.Cause(ctx)
+    }
+
+    failures++
+    if failures > 3 {
+      return fmt.Errorf("Lost the event stream of run %s on worker %s: %w", run.Id, r.Worker, err)
+    }
+    select {
+    case <-ctx.Done():
+      return context.Cause(ctx)
+    case <-time.After(time.Duration(failures) * time.Second):
+    }
+  }
+}
+
+
+/*
+  followEvents reads the event stream of a run after last_id,
+  emitting the assets of its "output" events. It returns the
+  "run-end" event, or nil and an error if the stream ended before
+  it.
+*/
+func (r *remoteRun) followEvents (s *Spec, tk *Task, run_id string, last_id *int) (*ApiEvent, error) {
+  request_url := r.Worker.JoinPath("runs", run_id, "events")
+  request, err := http.NewRequestWithContext(tk.Context(), "GET", request_url.String(), nil)
+  if err != nil { return nil, err }
+  if r.Token != "" {
+    request.Header.Set("Authorization", "Bearer " + r.Token)
+  }
+  if *last_id >= 0 {
+    request.Header.Set("Last-Event-ID", strconv.Itoa(*last_id))
+  }
+
+  response, err := http.DefaultClient.Do(request)
+  if err != nil { return nil, err }
+  defer response.Body.Close()
+  if response.StatusCode != http.StatusOK {
+    return nil, responseError(response)
+  }
+
+  var scanner = bufio.NewScanner(response.Body)
+  scanner.Buffer(nil, 1 << 24)
+
+  for scanner.Scan() {
+    data, found := strings.CutPrefix(scanner.Text(), "data: ")
+    if !found {
+      continue
+    }
+
+    var event ApiEvent
+    if err := json.Unmarshal([]byte(data), &event); err != nil {
+      return nil, fmt.Errorf("Cannot parse an event from worker %s: %w", r.Worker, err)
+    }
+
+    switch event.Type {
+    case "output":
+      if err := r.emitOutput(s, tk, run_id, event.Asset); err != nil {
+        return nil, err
+      }
+    case "task-error":
+      tk.Println("Remote " + event.Spec + "/" + event.Task + ": " + event.Error)
+    case "run-end":
+      return &event, nil
+    }
+    *last_id = event.Id
+  }
+
+  if err := scanner.Err(); err != nil {
+    return nil, err
+  }
+  return nil, io.ErrUnexpectedEOF
+}
+
+
+/*
+  emitOutput fetches an asset a worker output, and emits it at
+  the same path in the spec.
+*/
+func (r *remoteRun) emitOutput (s *Spec, tk *Task, run_id, asset_path string) error {
+  var escaped = (& url.URL { Path: strings.TrimPrefix(asset_path, "/") }).EscapedPath()
+  response, err := r.request(tk.Context(), "GET", "runs/" + run_id + "/assets/" + escaped, nil)
+  if err != nil {
+    return fmt.Errorf("Cannot fetch asset %s from worker %s: %w", asset_path, r.Worker, err)
+  }
+  defer response.Body.Close()
+
+  if response.StatusCode != http.StatusOK {
+    return fmt.Errorf("Cannot fetch asset %s from worker %s: %w", asset_path, r.Worker, responseError(response))
+  }
+
+  content, err := io.ReadAll(response.Body)
+  if err != nil {
+    return fmt.Errorf("Cannot fetch asset %s from worker %s: %w", asset_path, r.Worker, err)
+  }
+
+  var asset = s.MakeAsset(strings.TrimPrefix(asset_path, "/"))
+  asset.Mimetype = response.Header.Get("Content-Type")
+  asset.SetContentBytes(content)
+  return tk.EmitAsset(asset)
+}
@@ -0,0 +1,122 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "errors"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+
+func TestBuildRemoteSpec (t *testing.T) {
+  // Worker runs emit the pages of their "pages" prop, and fail if
+  // "fail" is set
+  //
+  var api_server = & ApiServer {
+    Token: "secret",
+    MakeRoot: func (root_consume TaskFunc) (*Spec, error) {
+      root := NewSpec("root", nil)
+      root.Props["quiet"] = true
+
+      root.AddSpecBuilder(func (s *Spec) error {
+        return s.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+          if fail, _, _ := s.GetPropBool("fail"); fail {
+            return errors.New("Failed on purpose")
+          }
+          pages, _ := s.Props["pages"].(map[string]any)
+          for key, content := range pages {
+            asset := s.MakeAsset(key)
+            asset.Mimetype = "text/html"
+            asset.SetContentBytes([]byte(content.(string)))
+            if err := tk.EmitAsset(asset); err != nil {
+              return err
+            }
+          }
+          return nil
+        })
+      })
+
+      root.DeferTaskFunc("root-consume", root_consume)
+      return root, nil
+    },
+  }
+  defer api_server.Close()
+
+  server := httptest.NewServer(api_server)
+  defer server.Close()
+
+  var run = func (props map[string]any) (map[string]string, error) {
+    t.Helper()
+    root := NewSpec("root", nil)
+    site := root.AddSubspec(NewSpec("site", nil))
+    root.Props["quiet"]        = true
+    root.Props["worker_token"] = "secret"
+    site.Props["worker"]       = server.URL
+    site.Props["transform"]    = "s`^`site/`"
+    for key, value := range props {
+      site.Props[key] = value
+    }
+
+    root.AddSpecBuilder(BuildRemoteSpec)
+    root.AddSpecBuilder(BuildTransform)
+
+    var received = make(map[string]string)
+    root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+      if err := tk.PoolSpecInputAssets(); err != nil {
+        return err
+      }
+      for _, asset := range tk.Assets {
+        assets, err := asset.Flatten()
+        if err != nil { return err }
+        for _, asset := range assets {
+          content, err := asset.GetContentBytes()
+          if err != nil { return err }
+          received[strings.TrimPrefix(asset.Url.Path, "@emit/")] = asset.Mimetype + ":" + string(content)
+        }
+      }
+      return nil
+    })
+
+    if err := site.Build(); err != nil {
+      t.Fatal(err)
+    }
+    if _, found := site.Props["pages"]; found {
+      t.Errorf("Expected the remote props to be removed from the local spec")
+    }
+    return received, root.Run()
+  }
+
+  // Assets output by the worker are emitted by the local spec, and
+  // transformed locally
+  //
+  received, err := run(map[string]any {
+    "pages": map[string]any { "index.html": "<p>Home</p>", "docs/a b.html": "<p>Docs</p>" },
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  for key, expect := range map[string]string {
+    "site/index.html":    "text/html:<p>Home</p>",
+    "site/docs/a b.html": "text/html:<p>Docs</p>",
+  } {
+    if got := received[key]; got != expect {
+      t.Errorf("Expected %s to be %s, got %q in %v", key, expect, got, received)
+    }
+  }
+
+  // Failed worker runs fail the local spec
+  //
+  if _, err := run(map[string]any { "fail": true }); err == nil || !strings.Contains(err.Error(), "failed") {
+    t.Errorf("Expected the worker run to fail, got %v", err)
+  }
+
+  // The worker prop is only supported in subspecs
+  //
+  root := NewSpec("root", nil)
+  root.Props["worker"] = server.URL
+  if err := BuildRemoteSpec(root); err == nil {
+    t.Errorf("Expected a worker on the root spec to be an error")
+  }
+}
@@ -9,6 +9,11 @@ import (
 )
 
 
+/*
+  ResolveSubspecs is a SpecBuilder which adds a subspec for each
+  entry of the "subspecs" prop, an object of spec names to their
+  props, as described by SubspecConfig, and builds them.
+*/
 func ResolveSubspecs (s *Spec) error {
   subspecs_json, ok, found := s.GetPropJson("subspecs")
 
@@ -24,8 +29,12 @@ func ResolveSubspecs (s *Spec) error {
   i := 0
 
   for name, subspec_any := range subspecs_json {
+    subspec_props, ok := subspec_any.(map[string]any)
+    if !ok {
+      return fmt.Errorf("Subspec %s in spec %s is expected to be an object of props, got %T", name, s.Name, subspec_any)
+    }
     subspec := NewSpec(name, nil)
-    subspec.Props = subspec_any.(map[string]any)  // TODO: can panic
+    subspec.Props = subspec_props
     s.AddSubspec(subspec)
     subspecs[i] = subspec
     i++
@@ -107,7 +116,8 @@ func BuildSourceDir (s *Spec) error {
 
 func BuildTaskSourceGitClone (s *Spec) error {
   if s.GetTaskResolverById("source-git-clone") == nil {
-    s.AddTaskResolver(&TaskResolverSourceGitClone)
+    git_clone := TaskResolverSourceGitClone
+    s.AddTaskResolver(&git_clone)
   }
 
   source, ok, _ := s.GetPropUrl("source")
@@ -118,8 +128,10 @@ func BuildTaskSourceGitClone (s *Spec) error {
   var is_git_scheme bool = source.Scheme == "git"
   var is_github     bool = source.Host == "github.com"
   var is_git_file   bool = strings.HasSuffix(source.Path, ".git") // TODO: suppose this is a URL with form parameters; this would not pick up such a case
+  var is_archive    bool = archiveFormat(source) != ""
+  is_crawled, _, _       := s.GetPropBool("source_crawl")
 
-  if ( is_git_scheme || is_github || is_git_file ){
+  if ( is_git_scheme || is_github || is_git_file ) && !is_archive && !is_crawled {
     if _, err := s.EnqueueUniqueTaskName("git-clone"); err != nil {
       return err
     }
@@ -133,9 +145,16 @@ func BuildTaskSourceGitClone (s *Spec) error {
 }
 
 
+/*
+  BuildTaskInferSource adds the "source-infer" resolver tree.
+  Resolvers are copied before being added, as adding a resolver
+  to a spec links it to the spec's other resolvers, and the
+  package-level resolvers are shared between specs.
+*/
 func BuildTaskInferSource (s *Spec) error {
   if s.GetTaskResolverById("source-infer-root") == nil {
-    s.AddTaskResolver(&TaskResolverInferSource)
+    infer := TaskResolverInferSource
+    s.AddTaskResolver(&infer)
   }
   return nil
 }
@@ -143,11 +162,13 @@ func BuildTaskInferSource (s *Spec) error {
 
 func BuildTasksNodeJS (s *Spec) error {
   if s.GetTaskResolverById("source-install-nodejs") == nil {
-    s.AddTaskResolver(&TaskResolverSourceInstallNodeJS)
+    install := TaskResolverSourceInstallNodeJS
+    s.AddTaskResolver(&install)
   }
 
   if s.GetTaskResolverById("source-build-nodejs") == nil {
-    s.AddTaskResolver(&TaskResolverSourceBuildNodeJS)
+    build := TaskResolverSourceBuildNodeJS
+    s.AddTaskResolver(&build)
   }
 
   return nil
@@ -160,7 +181,12 @@ func BuildTransform (s *Spec) error {
     return nil
   }
 
-  transformations, err := PathTransformationsFromAny(transform_any)
+  // Relative file paths in transformations, such as map_file,
+  // are relative to the source directory
+  //
+  source_dir, _, _ := s.InheritPropString("source_dir")
+
+  transformations, err := PathTransformationsFromAnyDir(transform_any, source_dir)
   if err != nil { return err }
 
   if len(s.PathTransformations) == 0 {
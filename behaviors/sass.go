@@ -0,0 +1,254 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "fmt"
+  "net/url"
+  "os"
+  "path"
+  "path/filepath"
+  "strings"
+)
+
+
+/*
+  TaskResolverCompileSass resolves the "compile-sass" task, which
+  compiles SCSS and Sass assets into CSS with the `sass` command.
+  Partials, whose names begin with an underscore, are available
+  to imports but are not emitted.
+*/
+var TaskResolverCompileSass = TaskResolver {
+  Id:   "compile-sass",
+  Name: "compile-sass",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "compile-sass", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_MUTATE | TASK_ASSETS_FILTER | TASK_ASSETS_GENERATE,
+    Func:   TaskCompileSass,
+    Before: []string { "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskCompileSass defers the "compile-sass" task if the
+  spec's "sass" prop is true.
+*/
+func BuildTaskCompileSass (s *Spec) error {
+  if s.GetTaskResolverById("compile-sass") == nil {
+    compile := TaskResolverCompileSass
+    s.AddTaskResolver(&compile)
+  }
+
+  sass, ok, found := s.GetPropBool("sass")
+  if !found {
+    return nil
+  } else if !ok {
+    return fmt.Errorf("Prop \"sass\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["sass"])
+  } else if !sass {
+    return nil
+  }
+
+  task, err := s.GetTask("compile-sass", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the compile-sass task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+func isSassAsset (a *Asset) bool {
+  switch strings.ToLower(path.Ext(a.Url.Path)) {
+    case ".scss", ".sass":
+      return true
+  }
+  return false
+}
+
+
+func isSassPartial (a *Asset) bool {
+  return strings.HasPrefix(path.Base(a.Url.Path), "_")
+}
+
+
+/*
+  TaskCompileSass pools the spec's input assets and compiles its
+  SCSS and Sass assets into CSS assets, forwarding other assets.
+  The Sass sources, including partials, are staged into a
+  temporary directory so that imports between them resolve, and
+  compiled with a single `sass` command. It reads the following
+  props:
+
+    - sass_bin:        The sass executable. Defaults to "sass".
+      Inherited.
+    - sass_style:      The output style, "expanded" or
+      "compressed".
+    - sass_source_map: If true, emit a ".css.map" source map
+      asset alongside each CSS asset.
+*/
+func TaskCompileSass (s *Spec, tk *Task) error {
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets to compile Sass: %w", err)
+  }
+
+  var sources []*Asset
+  var others  []*Asset
+
+  for _, input := range tk.Assets {
+    assets, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+
+    for _, asset := range assets {
+      if isSassAsset(asset) {
+        sources = append(sources, asset)
+      } else {
+        others = append(others, asset)
+      }
+    }
+  }
+
+  if err := tk.EmitAssets(others); err != nil {
+    return err
+  }
+
+  if len(sources) == 0 {
+    return nil
+  }
+
+  compiled, err := compileSass(s, tk, sources)
+  if err != nil { return err }
+
+  return tk.EmitAssets(compiled)
+}
+
+
+func compileSass (s *Spec, tk *Task, sources []*Asset) ([]*Asset, error) {
+  var sass = "sass"
+  var args []string
+
+  if prop_sass, ok, found := s.InheritPropString("sass_bin"); found && !ok {
+    return nil, fmt.Errorf("Prop \"sass_bin\" in spec %s is expected to be a string, got %T", s.Name, s.Props["sass_bin"])
+  } else if found && prop_sass != "" {
+    sass = prop_sass
+  }
+
+  if style, ok, found := s.GetPropString("sass_style"); found && !ok {
+    return nil, fmt.Errorf("Prop \"sass_style\" in spec %s is expected to be a string, got %T", s.Name, s.Props["sass_style"])
+  } else if found && style != "" {
+    args = append(args, "--style=" + style)
+  }
+
+  source_map, ok, found := s.GetPropBool("sass_source_map")
+  if found && !ok {
+    return nil, fmt.Errorf("Prop \"sass_source_map\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["sass_source_map"])
+  }
+  if source_map {
+    args = append(args, "--source-map")
+  } else {
+    args = append(args, "--no-source-map")
+  }
+
+  staging_dir, err := os.MkdirTemp("", "interbuilder-sass-")
+  if err != nil { return nil, err }
+  defer os.RemoveAll(staging_dir)
+
+  var input_dir  = filepath.Join(staging_dir, "input")
+  var output_dir = filepath.Join(staging_dir, "output")
+
+  args = append(args, "--load-path=" + input_dir)
+  if source_dir, ok, _ := s.InheritPropString("source_dir"); ok && source_dir != "" {
+    args = append(args, "--load-path=" + source_dir)
+  }
+
+  // Stage every source, and compile each one which is not a
+  // partial, as input:output pairs
+  //
+  var compiling []*Asset
+
+  for _, source := range sources {
+    content, err := source.GetContentBytes()
+    if err != nil { return nil, err }
+
+    key := KeyToPath(source.Url.Path)
+    input_path := filepath.Join(input_dir, key)
+
+    if err := os.MkdirAll(filepath.Dir(input_path), os.ModePerm); err != nil {
+      return nil, err
+    }
+    if err := os.WriteFile(input_path, content, 0o644); err != nil {
+      return nil, err
+    }
+
+    if isSassPartial(source) {
+      continue
+    }
+
+    output_path := filepath.Join(output_dir, ReplacePathExtension(key, filepath.Ext(key), ".css"))
+    args = append(args, input_path + ":" + output_path)
+    compiling = append(compiling, source)
+  }
+
+  if len(compiling) == 0 {
+    return nil, nil
+  }
+
+  if _, err := tk.CommandRun(sass, args...); err != nil {
+    return nil, fmt.Errorf("Cannot compile Sass in spec %s: %w", s.Name, err)
+  }
+
+  // Read the compiled CSS, and source maps, into new assets
+  //
+  var compiled []*Asset
+
+  for _, source := range compiling {
+    css_path := ReplacePathExtension(source.Url.Path, path.Ext(source.Url.Path), ".css")
+    output_path := filepath.Join(output_dir, KeyToPath(css_path))
+
+    css_asset, err := sassOutputAsset(source, css_path, output_path, "text/css; charset=utf-8")
+    if err != nil { return nil, err }
+    compiled = append(compiled, css_asset)
+
+    if source_map {
+      map_asset, err := sassOutputAsset(source, css_path + ".map", output_path + ".map", "application/json")
+      if err != nil { return nil, err }
+      compiled = append(compiled, map_asset)
+    }
+  }
+
+  return compiled, nil
+}
+
+
+/*
+  sassOutputAsset creates an asset derived from a Sass source
+  asset, at a new URL path, with the content of a compiled file.
+*/
+func sassOutputAsset (source *Asset, url_path, file_path, mimetype string) (*Asset, error) {
+  content, err := os.ReadFile(file_path)
+  if err != nil {
+    return nil, fmt.Errorf("Cannot read compiled Sass output for %s: %w", source.Url, err)
+  }
+
+  var asset_url url.URL = *source.Url
+  asset_url.Path    = url_path
+  asset_url.RawPath = ""
+
+  var history = source.ExtendHistory()
+  history.Url = &asset_url
+
+  var asset = & Asset {
+    Url:      &asset_url,
+    Spec:     source.Spec,
+    History:  history,
+    Mimetype: mimetype,
+  }
+
+  if err := asset.SetContentBytes(content); err != nil {
+    return nil, err
+  }
+  return asset, nil
+}
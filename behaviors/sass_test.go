@@ -0,0 +1,105 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "testing"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+
+func TestTaskCompileSass (t *testing.T) {
+  // A stand-in for sass, which copies each input to its output
+  // with a comment of the flags it received
+  //
+  var fake_sass = filepath.Join(t.TempDir(), "sass")
+
+  var fake_sass_src = strings.Join([]string {
+    `#!/bin/sh`,
+    `flags=""; map=""`,
+    `for arg in "$@"; do`,
+    `  case "$arg" in`,
+    `    --load-path=*) ;;`,
+    `    --source-map) map=1; flags="$flags $arg" ;;`,
+    `    --*) flags="$flags $arg" ;;`,
+    `    *:*)`,
+    `      in="${arg%%:*}"; out="${arg#*:}"`,
+    `      mkdir -p "$(dirname "$out")"`,
+    `      { printf '/*%s */\n' "$flags"; cat "$in"; } > "$out"`,
+    `      if [ -n "$map" ]; then printf '{}' > "$out.map"; fi ;;`,
+    `  esac`,
+    `done`,
+  }, "\n")
+
+  if err := os.WriteFile(fake_sass, []byte(fake_sass_src), 0o755); err != nil {
+    t.Fatal(err)
+  }
+
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"]              = true
+  root.Props["sass_bin"]           = fake_sass
+  subspec.Props["sass"]            = true
+  subspec.Props["sass_style"]      = "compressed"
+  subspec.Props["sass_source_map"] = true
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for key, content := range map[string]string {
+      "css/main.scss":      "@use 'colors';",
+      "css/_colors.scss":   "$red: #f00;",
+      "css/print.sass":     "body\n  color: black",
+      "index.html":         "<h1>Hello</h1>",
+    } {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes([]byte(content))
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]string)
+
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      content, err := asset.GetContentBytes()
+      if err != nil { return err }
+      received[strings.TrimPrefix(asset.Url.Path, "@emit/")] = string(content)
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTaskCompileSass)
+  if err := subspec.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var expect = map[string]string {
+    "css/main.css":      "/* --style=compressed --source-map */\n@use 'colors';",
+    "css/main.css.map":  "{}",
+    "css/print.css":     "/* --style=compressed --source-map */\nbody\n  color: black",
+    "css/print.css.map": "{}",
+    "index.html":        "<h1>Hello</h1>",
+  }
+
+  if len(received) != len(expect) {
+    t.Errorf("Expected %d assets, got %d: %v", len(expect), len(received), received)
+  }
+
+  for key, expect_content := range expect {
+    if got, found := received[key]; !found {
+      t.Errorf("Expected an asset at %s", key)
+    } else if got != expect_content {
+      t.Errorf("Expected %s to have content %q, got %q", key, expect_content, got)
+    }
+  }
+}
@@ -0,0 +1,693 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "context"
+  "crypto/subtle"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/http"
+  "sort"
+  "strconv"
+  "sync"
+  "time"
+)
+
+
+/*
+  ApiServer is an http.Handler exposing a REST API to drive
+  pipelines, such as from CI systems and web UIs. Pipeline configs
+  are build specification JSON, as read by "interbuilder run",
+  which are stored by name, or submitted with a run. Runs are kept
+  in memory, and their assets are collected by the root spec
+  rather than written to files. Its routes are:
+
+    - GET    /pipelines                   Names of stored configs
+    - PUT    /pipelines/{name}            Store a config
+    - GET    /pipelines/{name}            Get a stored config
+    - DELETE /pipelines/{name}            Remove a stored config
+    - POST   /pipelines/{name}/runs       Run a stored config
+    - POST   /runs                        Run a config in the body
+    - GET    /runs                        List runs
+    - GET    /runs/{id}                   Get the status of a run
+    - DELETE /runs/{id}                   Cancel and discard a run
+    - POST   /runs/{id}/cancel            Cancel a run
+    - GET    /runs/{id}/events            Stream events, as SSE
+    - GET    /runs/{id}/assets            List emitted asset paths
+    - GET    /runs/{id}/assets/{path...}  Download an asset
+
+  If Token is set, requests must have it as a bearer token in
+  their Authorization header.
+*/
+type ApiServer struct {
+  // MakeRoot returns a root spec with the behaviors runs are
+  // built with, whose "root-consume" task runs root_consume
+  //
+  MakeRoot  func (root_consume TaskFunc) (*Spec, error)
+  Token     string
+
+  lock       sync.RWMutex
+  pipelines  map[string][]byte
+  runs       map[string]*ApiRun
+  next_id    int
+  mux        *http.ServeMux
+  init_once  sync.Once
+}
+
+
+/*
+  ApiEvent is a Spec Event of a run, as it is streamed to API
+  clients. An "output" event is added when an asset reaches the
+  root spec, whose Asset is the output path it can be downloaded
+  from. The final event of a run is "run-end", whose Status is
+  the outcome of the run.
+*/
+type ApiEvent struct {
+  Id      int        `json:"id"`
+  Type    string     `json:"type"`
+  Time    time.Time  `json:"time"`
+  Spec    string     `json:"spec,omitempty"`
+  Task    string     `json:"task,omitempty"`
+  Asset   string     `json:"asset,omitempty"`
+  Status  string     `json:"status,omitempty"`
+  Error   string     `json:"error,omitempty"`
+}
+
+
+/*
+  ApiSpecStatus is the status of a spec in a run, with the asset
+  content its task buffers hold in memory.
+*/
+type ApiSpecStatus struct {
+  Path    string       `json:"path"`
+  Status  string       `json:"status"`
+  Error   string       `json:"error,omitempty"`
+  Memory  MemoryStats  `json:"memory"`
+}
+
+
+/*
+  ApiRunStatus is the status of a run, as it is responded with.
+*/
+type ApiRunStatus struct {
+  Id        string           `json:"id"`
+  Pipeline  string           `json:"pipeline,omitempty"`
+  Status    string           `json:"status"`
+  Error     string           `json:"error,omitempty"`
+  Started   time.Time        `json:"started"`
+  Finished  *time.Time       `json:"finished,omitempty"`
+  Specs     []ApiSpecStatus  `json:"specs"`
+}
+
+
+/*
+  ApiRun is a run of a pipeline started by an ApiServer. Its
+  events are recorded, so that clients streaming them from any
+  point in the run receive each of them.
+*/
+type ApiRun struct {
+  Id        string
+  Pipeline  string
+  Started   time.Time
+
+  root      *Spec
+  assets    *AssetServer
+  cancel    context.CancelFunc
+  done      chan struct{}
+
+  // on_output, if set, is called with each asset reaching the
+  // root spec, and its output path
+  //
+  on_output func (output_path string, asset *Asset) error
+
+  lock      sync.Mutex
+  events    []ApiEvent
+  notify    chan struct{}
+  finished  time.Time
+  err       error
+}
+
+
+func (as *ApiServer) init () {
+  as.init_once.Do(func () {
+    as.pipelines = make(map[string][]byte)
+    as.runs      = make(map[string]*ApiRun)
+
+    as.mux = http.NewServeMux()
+    as.mux.HandleFunc("GET /pipelines",               as.listPipelines)
+    as.mux.HandleFunc("PUT /pipelines/{name}",        as.putPipeline)
+    as.mux.HandleFunc("GET /pipelines/{name}",        as.getPipeline)
+    as.mux.HandleFunc("DELETE /pipelines/{name}",     as.deletePipeline)
+    as.mux.HandleFunc("POST /pipelines/{name}/runs",  as.runPipeline)
+    as.mux.HandleFunc("POST /runs",                   as.runConfig)
+    as.mux.HandleFunc("GET /runs",                    as.listRuns)
+    as.mux.HandleFunc("GET /runs/{id}",               as.getRun)
+    as.mux.HandleFunc("DELETE /runs/{id}",            as.deleteRun)
+    as.mux.HandleFunc("POST /runs/{id}/cancel",       as.cancelRun)
+    as.mux.HandleFunc("GET /runs/{id}/events",        as.streamRunEvents)
+    as.mux.HandleFunc("GET /runs/{id}/assets",        as.listRunAssets)
+    as.mux.HandleFunc("GET /runs/{id}/assets/{path...}", as.getRunAsset)
+  })
+}
+
+
+func (as *ApiServer) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  as.init()
+
+  if as.Token != "" {
+    var expect = "Bearer " + as.Token
+    if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expect)) != 1 {
+      w.Header().Set("WWW-Authenticate", "Bearer")
+      apiError(w, http.StatusUnauthorized, "Unauthorized")
+      return
+    }
+  }
+
+  as.mux.ServeHTTP(w, r)
+}
+
+
+/*
+  Close cancels every running run, and waits for them to return.
+*/
+func (as *ApiServer) Close () {
+  as.init()
+
+  as.lock.RLock()
+  var runs = make([]*ApiRun, 0, len(as.runs))
+  for _, run := range as.runs {
+    runs = append(runs, run)
+  }
+  as.lock.RUnlock()
+
+  for _, run := range runs {
+    run.cancel()
+  }
+  for _, run := range runs {
+    <-run.done
+  }
+}
+
+
+func apiJson (w http.ResponseWriter, status int, value any) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(value)
+}
+
+
+func apiError (w http.ResponseWriter, status int, message string) {
+  apiJson(w, status, map[string]string { "error": message })
+}
+
+
+/*
+  readConfig reads a pipeline config from a request body, which
+  must be a JSON object, valid as a SpecConfig.
+*/
+func readConfig (r *http.Request) ([]byte, error) {
+  config, err := io.ReadAll(r.Body)
+  if err != nil { return nil, err }
+
+  var spec_config *SpecConfig
+  if err := json.Unmarshal(config, &spec_config); err != nil {
+    return nil, fmt.Errorf("Cannot parse pipeline config: %w", err)
+  } else if spec_config == nil {
+    return nil, fmt.Errorf("Pipeline config is expected to be a JSON object")
+  } else if err := spec_config.Validate(); err != nil {
+    return nil, err
+  }
+  return config, nil
+}
+
+
+func (as *ApiServer) listPipelines (w http.ResponseWriter, r *http.Request) {
+  as.lock.RLock()
+  var names = make([]string, 0, len(as.pipelines))
+  for name := range as.pipelines {
+    names = append(names, name)
+  }
+  as.lock.RUnlock()
+
+  sort.Strings(names)
+  apiJson(w, http.StatusOK, names)
+}
+
+
+func (as *ApiServer) putPipeline (w http.ResponseWriter, r *http.Request) {
+  config, err := readConfig(r)
+  if err != nil {
+    apiError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+
+  as.lock.Lock()
+  as.pipelines[r.PathValue("name")] = config
+  as.lock.Unlock()
+
+  w.WriteHeader(http.StatusNoContent)
+}
+
+
+func (as *ApiServer) getPipeline (w http.ResponseWriter, r *http.Request) {
+  as.lock.RLock()
+  config, found := as.pipelines[r.PathValue("name")]
+  as.lock.RUnlock()
+
+  if !found {
+    apiError(w, http.StatusNotFound, "No such pipeline")
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  w.Write(config)
+}
+
+
+func (as *ApiServer) deletePipeline (w http.ResponseWriter, r *http.Request) {
+  as.lock.Lock()
+  _, found := as.pipelines[r.PathValue("name")]
+  delete(as.pipelines, r.PathValue("name"))
+  as.lock.Unlock()
+
+  if !found {
+    apiError(w, http.StatusNotFound, "No such pipeline")
+    return
+  }
+  w.WriteHeader(http.StatusNoContent)
+}
+
+
+func (as *ApiServer) runPipeline (w http.ResponseWriter, r *http.Request) {
+  var name = r.PathValue("name")
+
+  as.lock.RLock()
+  config, found := as.pipelines[name]
+  as.lock.RUnlock()
+
+  if !found {
+    apiError(w, http.StatusNotFound, "No such pipeline")
+    return
+  }
+  as.startRun(w, name, config)
+}
+
+
+func (as *ApiServer) runConfig (w http.ResponseWriter, r *http.Request) {
+  config, err := readConfig(r)
+  if err != nil {
+    apiError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  as.startRun(w, "", config)
+}
+
+
+/*
+  startRun builds a root spec from a pipeline config, and runs it
+  in the background. Configs which cannot be built are responded
+  to as bad requests, without starting a run.
+*/
+func (as *ApiServer) startRun (w http.ResponseWriter, pipeline string, config []byte) {
+  run, status, err := as.newRun(pipeline, config, nil)
+  if err != nil {
+    apiError(w, status, err.Error())
+    return
+  }
+
+  w.Header().Set("Location", "/runs/" + run.Id)
+  apiJson(w, http.StatusAccepted, run.Status())
+}
+
+
+/*
+  newRun builds a root spec from a pipeline config, calls setup
+  with it, if it is not nil, and runs it in the background. If the
+  run cannot be started, the HTTP status of the error is returned
+  with it.
+*/
+func (as *ApiServer) newRun (pipeline string, config []byte, setup func (*ApiRun) error) (*ApiRun, int, error) {
+  as.init()
+
+  var run = & ApiRun {
+    Pipeline: pipeline,
+    assets:   NewAssetServer(),
+    done:     make(chan struct{}),
+    notify:   make(chan struct{}),
+  }
+
+  var spec_config SpecConfig
+  if err := json.Unmarshal(config, &spec_config); err != nil {
+    return nil, http.StatusBadRequest, fmt.Errorf("Cannot parse pipeline config: %v", err)
+  }
+
+  root, err := as.MakeRoot(run.consume)
+  if err != nil {
+    return nil, http.StatusInternalServerError, err
+  }
+  if err := BuildSpecFromConfig(root, &spec_config); err != nil {
+    return nil, http.StatusBadRequest, fmt.Errorf("Cannot build pipeline: %v", err)
+  }
+
+  run.root = root
+  root.OnEvent(run.record)
+
+  if setup != nil {
+    if err := setup(run); err != nil {
+      return nil, http.StatusInternalServerError, err
+    }
+  }
+
+  var ctx context.Context
+  ctx, run.cancel = context.WithCancel(context.Background())
+  run.Started     = time.Now()
+
+  as.lock.Lock()
+  as.next_id++
+  run.Id = strconv.Itoa(as.next_id)
+  as.runs[run.Id] = run
+  as.lock.Unlock()
+
+  go func () {
+    defer close(run.done)
+    defer run.cancel()
+    run.finish(root.RunContext(ctx))
+  }()
+
+  return run, 0, nil
+}
+
+
+/*
+  getPipelineConfig returns the stored config of a pipeline.
+*/
+func (as *ApiServer) getPipelineConfig (name string) ([]byte, bool) {
+  as.init()
+
+  as.lock.RLock()
+  defer as.lock.RUnlock()
+  config, found := as.pipelines[name]
+  return config, found
+}
+
+
+/*
+  getRunById returns the run with the id of a request's path, or
+  responds with a 404 and returns nil.
+*/
+func (as *ApiServer) getRunById (w http.ResponseWriter, r *http.Request) *ApiRun {
+  as.lock.RLock()
+  run := as.runs[r.PathValue("id")]
+  as.lock.RUnlock()
+
+  if run == nil {
+    apiError(w, http.StatusNotFound, "No such run")
+  }
+  return run
+}
+
+
+func (as *ApiServer) listRuns (w http.ResponseWriter, r *http.Request) {
+  as.lock.RLock()
+  var runs = make([]*ApiRun, 0, len(as.runs))
+  for _, run := range as.runs {
+    runs = append(runs, run)
+  }
+  as.lock.RUnlock()
+
+  sort.Slice(runs, func (i, j int) bool {
+    id_i, _ := strconv.Atoi(runs[i].Id)
+    id_j, _ := strconv.Atoi(runs[j].Id)
+    return id_i < id_j
+  })
+
+  var statuses = make([]ApiRunStatus, len(runs))
+  for i, run := range runs {
+    statuses[i] = run.Status()
+  }
+  apiJson(w, http.StatusOK, statuses)
+}
+
+
+func (as *ApiServer) getRun (w http.ResponseWriter, r *http.Request) {
+  if run := as.getRunById(w, r); run != nil {
+    apiJson(w, http.StatusOK, run.Status())
+  }
+}
+
+
+func (as *ApiServer) cancelRun (w http.ResponseWriter, r *http.Request) {
+  if run := as.getRunById(w, r); run != nil {
+    run.cancel()
+    <-run.done
+    apiJson(w, http.StatusOK, run.Status())
+  }
+}
+
+
+func (as *ApiServer) deleteRun (w http.ResponseWriter, r *http.Request) {
+  var run = as.getRunById(w, r)
+  if run == nil {
+    return
+  }
+  run.cancel()
+  <-run.done
+
+  as.lock.Lock()
+  delete(as.runs, run.Id)
+  as.lock.Unlock()
+
+  w.WriteHeader(http.StatusNoContent)
+}
+
+
+/*
+  streamRunEvents streams the events of a run as server-sent
+  events, from its start, or after the event of the Last-Event-ID
+  header when a client reconnects. The stream ends after the
+  run's "run-end" event.
+*/
+func (as *ApiServer) streamRunEvents (w http.ResponseWriter, r *http.Request) {
+  var run = as.getRunById(w, r)
+  if run == nil {
+    return
+  }
+
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    apiError(w, http.StatusInternalServerError, "Streaming is not supported")
+    return
+  }
+
+  var next = 0
+  if last_id, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+    next = last_id + 1
+  }
+
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.WriteHeader(http.StatusOK)
+  flusher.Flush()
+
+  for {
+    events, notify, finished := run.eventsFrom(next)
+    for _, event := range events {
+      data, err := json.Marshal(event)
+      if err != nil { return }
+      if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data); err != nil {
+        return
+      }
+    }
+    flusher.Flush()
+    next += len(events)
+
+    if finished {
+      return
+    }
+
+    select {
+    case <-r.Context().Done():
+      return
+    case <-notify:
+    }
+  }
+}
+
+
+func (as *ApiServer) listRunAssets (w http.ResponseWriter, r *http.Request) {
+  if run := as.getRunById(w, r); run != nil {
+    apiJson(w, http.StatusOK, run.assets.Paths())
+  }
+}
+
+
+func (as *ApiServer) getRunAsset (w http.ResponseWriter, r *http.Request) {
+  if run := as.getRunById(w, r); run != nil {
+    http.StripPrefix("/runs/" + run.Id + "/assets", run.assets).ServeHTTP(w, r)
+  }
+}
+
+
+/*
+  consume is the root-consume TaskFunc of a run, which collects
+  the assets which reach it, to be downloaded by their final
+  output paths, recording an "output" event for each.
+*/
+func (run *ApiRun) consume (s *Spec, tk *Task) error {
+  var store = func (chunk *Asset) error {
+    assets, err := chunk.FlattenContext(tk.Context())
+    if err != nil { return err }
+    for _, asset := range assets {
+      var output_path = finalOutputPath(s, asset)
+      if err := run.assets.SetAsset(output_path, asset); err != nil {
+        return err
+      }
+      if run.on_output != nil {
+        if err := run.on_output(output_path, asset); err != nil {
+          return err
+        }
+      }
+      run.append(ApiEvent {
+        Type:  "output",
+        Time:  time.Now(),
+        Asset: output_path,
+      })
+    }
+    return nil
+  }
+
+  for _, chunk := range tk.Assets {
+    if err := store(chunk); err != nil {
+      return err
+    }
+  }
+  tk.Assets = nil
+
+  for {
+    select {
+    case <-tk.Context().Done():
+      return nil
+    case chunk, ok := <-s.Input:
+      if !ok {
+        return nil
+      }
+      if err := store(chunk); err != nil {
+        return err
+      }
+    }
+  }
+}
+
+
+/*
+  append records an event, and wakes its streaming clients.
+*/
+func (run *ApiRun) append (event ApiEvent) {
+  run.lock.Lock()
+  defer run.lock.Unlock()
+
+  event.Id   = len(run.events)
+  run.events = append(run.events, event)
+  close(run.notify)
+  run.notify = make(chan struct{})
+}
+
+
+/*
+  record is the EventHandler of a run's root spec.
+*/
+func (run *ApiRun) record (e Event) {
+  var event = ApiEvent {
+    Type: e.Type.String(),
+    Time: e.Time,
+    Spec: e.Spec.SpecPath(),
+  }
+  if e.Task != nil {
+    event.Task = e.Task.Name
+  }
+  if e.Asset != nil && e.Asset.Url != nil {
+    event.Asset = e.Asset.Url.String()
+  }
+  if e.Err != nil {
+    event.Error = e.Err.Error()
+  }
+  run.append(event)
+}
+
+
+func (run *ApiRun) finish (err error) {
+  run.lock.Lock()
+  run.finished = time.Now()
+  run.err      = err
+  run.lock.Unlock()
+
+  var status = run.Status()
+  run.append(ApiEvent {
+    Type:   "run-end",
+    Time:   *status.Finished,
+    Status: status.Status,
+    Error:  status.Error,
+  })
+}
+
+
+/*
+  eventsFrom returns the events of a run from an index, a channel
+  which is closed when another is recorded, and whether the run
+  has ended, after which no more are.
+*/
+func (run *ApiRun) eventsFrom (i int) ([]ApiEvent, <-chan struct{}, bool) {
+  run.lock.Lock()
+  defer run.lock.Unlock()
+
+  var events []ApiEvent
+  if i < len(run.events) {
+    events = append(events, run.events[i:]...)
+  }
+  var ended = len(run.events) > 0 && run.events[len(run.events)-1].Type == "run-end"
+  return events, run.notify, ended
+}
+
+
+/*
+  Status returns the status of a run and each of its specs.
+*/
+func (run *ApiRun) Status () ApiRunStatus {
+  run.lock.Lock()
+  var finished, run_err = run.finished, run.err
+  run.lock.Unlock()
+
+  var status = ApiRunStatus {
+    Id:       run.Id,
+    Pipeline: run.Pipeline,
+    Started:  run.Started,
+    Specs:    []ApiSpecStatus {},
+  }
+
+  for _, report := range run.root.StatusReport() {
+    var spec_status = ApiSpecStatus {
+      Path:   report.Path,
+      Status: report.Status.String(),
+      Memory: report.Spec.MemoryStats(),
+    }
+    if report.Err != nil {
+      spec_status.Error = report.Err.Error()
+    }
+    status.Specs = append(status.Specs, spec_status)
+  }
+
+  root_status, _ := run.root.Status()
+  status.Status = root_status.String()
+
+  if !finished.IsZero() {
+    status.Finished = &finished
+    if run_err != nil {
+      status.Error = run_err.Error()
+    }
+  } else if root_status == SPEC_STATUS_PENDING {
+    status.Status = SPEC_STATUS_RUNNING.String()
+  }
+
+  return status
+}
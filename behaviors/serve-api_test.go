@@ -0,0 +1,162 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bufio"
+  "encoding/json"
+  "errors"
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+
+func TestApiServer (t *testing.T) {
+  // Runs emit the pages of their "pages" prop, fail if "fail" is
+  // set, and wait to be cancelled if "wait" is set
+  //
+  var api_server = & ApiServer {
+    Token: "secret",
+    MakeRoot: func (root_consume TaskFunc) (*Spec, error) {
+      root := NewSpec("root", nil)
+      root.Props["quiet"] = true
+
+      root.AddSpecBuilder(func (s *Spec) error {
+        return s.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+          if fail, _, _ := s.GetPropBool("fail"); fail {
+            return errors.New("Failed on purpose")
+          }
+          pages, _ := s.Props["pages"].(map[string]any)
+          for key, content := range pages {
+            asset := s.MakeAsset(key)
+            asset.Mimetype = "text/html"
+            asset.SetContentBytes([]byte(content.(string)))
+            if err := tk.EmitAsset(asset); err != nil {
+              return err
+            }
+          }
+          if wait, _, _ := s.GetPropBool("wait"); wait {
+            <-tk.Context().Done()
+          }
+          return nil
+        })
+      })
+
+      root.DeferTaskFunc("root-consume", root_consume)
+      return root, nil
+    },
+  }
+  defer api_server.Close()
+
+  server := httptest.NewServer(api_server)
+  defer server.Close()
+
+  var request = func (method, path, body string) (int, []byte) {
+    t.Helper()
+    req, err := http.NewRequest(method, server.URL + path, strings.NewReader(body))
+    if err != nil { t.Fatal(err) }
+    req.Header.Set("Authorization", "Bearer secret")
+    response, err := http.DefaultClient.Do(req)
+    if err != nil { t.Fatal(err) }
+    defer response.Body.Close()
+    content, _ := io.ReadAll(response.Body)
+    return response.StatusCode, content
+  }
+
+  // streamEvents reads the events of a run until it ends
+  //
+  var streamEvents = func (id string) []ApiEvent {
+    t.Helper()
+    req, _ := http.NewRequest("GET", server.URL + "/runs/" + id + "/events", nil)
+    req.Header.Set("Authorization", "Bearer secret")
+    response, err := http.DefaultClient.Do(req)
+    if err != nil { t.Fatal(err) }
+    defer response.Body.Close()
+
+    var events []ApiEvent
+    var scanner = bufio.NewScanner(response.Body)
+    for scanner.Scan() {
+      if data, found := strings.CutPrefix(scanner.Text(), "data: "); found {
+        var event ApiEvent
+        if err := json.Unmarshal([]byte(data), &event); err != nil {
+          t.Fatal(err)
+        }
+        events = append(events, event)
+      }
+    }
+    return events
+  }
+
+  // Requests without the token are unauthorized
+  //
+  if response, err := http.Get(server.URL + "/runs"); err != nil {
+    t.Fatal(err)
+  } else if response.Body.Close(); response.StatusCode != http.StatusUnauthorized {
+    t.Errorf("Expected a request without a token to be unauthorized, got %d", response.StatusCode)
+  }
+
+  // Store and run a pipeline
+  //
+  if status, body := request("PUT", "/pipelines/site", `{ "pages": { "index.html": "<p>Home</p>" } }`); status != http.StatusNoContent {
+    t.Fatalf("Expected the pipeline to be stored, got %d: %s", status, body)
+  }
+  if status, body := request("PUT", "/pipelines/broken", `[]`); status != http.StatusBadRequest {
+    t.Errorf("Expected a config which is not an object to be rejected, got %d: %s", status, body)
+  }
+  if _, body := request("GET", "/pipelines", ""); strings.TrimSpace(string(body)) != `["site"]` {
+    t.Errorf("Expected the stored pipelines to be listed, got %s", body)
+  }
+
+  status, body := request("POST", "/pipelines/site/runs", "")
+  if status != http.StatusAccepted {
+    t.Fatalf("Expected the run to be started, got %d: %s", status, body)
+  }
+  var run ApiRunStatus
+  if err := json.Unmarshal(body, &run); err != nil {
+    t.Fatal(err)
+  }
+
+  var events = streamEvents(run.Id)
+  if len(events) == 0 || events[len(events)-1].Type != "run-end" || events[len(events)-1].Status != "succeeded" {
+    t.Fatalf("Expected the events to end with a succeeded run, got %+v", events)
+  }
+  if events[0].Type != "spec-start" || events[0].Spec != "root" {
+    t.Errorf("Expected the first event to be the root starting, got %+v", events[0])
+  }
+
+  if _, body := request("GET", "/runs/" + run.Id + "/assets", ""); strings.TrimSpace(string(body)) != `["/index.html"]` {
+    t.Errorf("Expected the emitted assets to be listed, got %s", body)
+  }
+  if status, body := request("GET", "/runs/" + run.Id + "/assets/index.html", ""); status != http.StatusOK || string(body) != "<p>Home</p>" {
+    t.Errorf("Expected the emitted asset to be downloaded, got %d: %s", status, body)
+  }
+
+  // Failing runs report their error
+  //
+  _, body = request("POST", "/runs", `{ "fail": true }`)
+  json.Unmarshal(body, &run)
+  events = streamEvents(run.Id)
+  if end := events[len(events)-1]; end.Status != "failed" || !strings.Contains(end.Error, "Failed on purpose") {
+    t.Errorf("Expected the run to fail, got %+v", end)
+  }
+
+  // Runs can be cancelled
+  //
+  _, body = request("POST", "/runs", `{ "wait": true }`)
+  json.Unmarshal(body, &run)
+  if status, body := request("POST", "/runs/" + run.Id + "/cancel", ""); status != http.StatusOK {
+    t.Errorf("Expected the run to be cancelled, got %d: %s", status, body)
+  } else if json.Unmarshal(body, &run); run.Status != "cancelled" || run.Finished == nil {
+    t.Errorf("Expected a cancelled run, got %+v", run)
+  }
+
+  if status, _ := request("DELETE", "/runs/" + run.Id, ""); status != http.StatusNoContent {
+    t.Errorf("Expected the run to be deleted, got %d", status)
+  }
+  if status, _ := request("GET", "/runs/" + run.Id, ""); status != http.StatusNotFound {
+    t.Errorf("Expected a deleted run to be missing, got %d", status)
+  }
+}
@@ -0,0 +1,351 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bytes"
+  "context"
+  "errors"
+  "fmt"
+  "net"
+  "net/http"
+  "path"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+
+/*
+  TaskResolverServeHttp resolves the "serve-http" task, which
+  serves the assets which reach it over HTTP.
+*/
+var TaskResolverServeHttp = TaskResolver {
+  Id:   "serve-http",
+  Name: "serve-http",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "serve-http", nil
+  },
+  TaskPrototype: Task {
+    Mask: TASK_ASSETS_CONSUME,
+    Func: TaskServeHttp,
+  },
+}
+
+
+/*
+  servedAsset is the content of an asset served by an AssetServer.
+*/
+type servedAsset struct {
+  Content   []byte
+  Mimetype  string
+  ModTime   time.Time
+}
+
+
+/*
+  AssetServer is an http.Handler which serves asset content from
+  memory, by output path. Assets may be replaced while it serves,
+  so that it responds with the latest emitted content. Clients of
+  the live-reload script are streamed an event at LiveReloadPath
+  when Reload is called.
+*/
+type AssetServer struct {
+  lock        sync.RWMutex
+  assets      map[string]*servedAsset
+  reloads     map[chan struct{}]bool
+  closed      chan struct{}
+  close_once  sync.Once
+}
+
+
+func NewAssetServer () *AssetServer {
+  return &AssetServer {
+    assets:  make(map[string]*servedAsset),
+    reloads: make(map[chan struct{}]bool),
+    closed:  make(chan struct{}),
+  }
+}
+
+
+/*
+  Set serves content at an output path, replacing any content
+  already served there.
+*/
+func (as *AssetServer) Set (asset_path string, content []byte, mimetype string) {
+  as.lock.Lock()
+  defer as.lock.Unlock()
+  as.assets[path.Clean("/" + asset_path)] = &servedAsset {
+    Content:  content,
+    Mimetype: mimetype,
+    ModTime:  time.Now(),
+  }
+}
+
+
+/*
+  SetAsset serves an asset's content at its output path.
+*/
+func (as *AssetServer) SetAsset (asset_path string, a *Asset) error {
+  content, err := a.EncodedContentBytes()
+  if err != nil { return err }
+  as.Set(asset_path, content, a.Mimetype)
+  return nil
+}
+
+
+/*
+  Paths returns the output paths of the served assets, sorted.
+*/
+func (as *AssetServer) Paths () []string {
+  as.lock.RLock()
+  defer as.lock.RUnlock()
+
+  var paths = make([]string, 0, len(as.assets))
+  for asset_path := range as.assets {
+    paths = append(paths, asset_path)
+  }
+  sort.Strings(paths)
+  return paths
+}
+
+
+func (as *AssetServer) get (asset_path string) *servedAsset {
+  as.lock.RLock()
+  defer as.lock.RUnlock()
+  return as.assets[asset_path]
+}
+
+
+/*
+  Reload sends a reload event to the connected live-reload
+  clients. Reloads sent before a client has received the last
+  are coalesced.
+*/
+func (as *AssetServer) Reload () {
+  as.lock.RLock()
+  defer as.lock.RUnlock()
+  for reload := range as.reloads {
+    select {
+    case reload <- struct{}{}:
+    default:
+    }
+  }
+}
+
+
+/*
+  Close ends the event streams of the connected live-reload
+  clients, which otherwise stay open, so that the server can shut
+  down.
+*/
+func (as *AssetServer) Close () {
+  as.close_once.Do(func () { close(as.closed) })
+}
+
+
+/*
+  serveReloadEvents streams reload events to a live-reload client
+  until it disconnects or the server is closed.
+*/
+func (as *AssetServer) serveReloadEvents (w http.ResponseWriter, r *http.Request) {
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+    return
+  }
+
+  var reload = make(chan struct{}, 1)
+  as.lock.Lock()
+  as.reloads[reload] = true
+  as.lock.Unlock()
+
+  defer func () {
+    as.lock.Lock()
+    delete(as.reloads, reload)
+    as.lock.Unlock()
+  }()
+
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.WriteHeader(http.StatusOK)
+  flusher.Flush()
+
+  for {
+    select {
+    case <-r.Context().Done():
+      return
+    case <-as.closed:
+      return
+    case <-reload:
+      if _, err := fmt.Fprint(w, "event: reload\ndata: \n\n"); err != nil {
+        return
+      }
+      flusher.Flush()
+    }
+  }
+}
+
+
+/*
+  ServeHTTP responds with the asset at the request path.
+  Directories are served by their index.html, and paths to them
+  without a trailing slash are redirected. Missing paths are
+  responded to with a 404.html asset, if there is one.
+*/
+func (as *AssetServer) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodGet && r.Method != http.MethodHead {
+    w.Header().Set("Allow", "GET, HEAD")
+    http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+
+  if r.URL.Path == LiveReloadPath {
+    as.serveReloadEvents(w, r)
+    return
+  }
+
+  var request_path = path.Clean("/" + r.URL.Path)
+  var asset_path   = request_path
+  if strings.HasSuffix(r.URL.Path, "/") {
+    asset_path = path.Join(request_path, "index.html")
+  }
+
+  var served = as.get(asset_path)
+
+  if served == nil && !strings.HasSuffix(r.URL.Path, "/") && as.get(path.Join(request_path, "index.html")) != nil {
+    var location = request_path + "/"
+    if r.URL.RawQuery != "" {
+      location += "?" + r.URL.RawQuery
+    }
+    http.Redirect(w, r, location, http.StatusMovedPermanently)
+    return
+  }
+
+  if served == nil {
+    if not_found := as.get("/404.html"); not_found != nil {
+      w.Header().Set("Content-Type", "text/html; charset=utf-8")
+      w.WriteHeader(http.StatusNotFound)
+      w.Write(not_found.Content)
+      return
+    }
+    http.NotFound(w, r)
+    return
+  }
+
+  if served.Mimetype != "" {
+    w.Header().Set("Content-Type", served.Mimetype)
+  }
+  w.Header().Set("Cache-Control", "no-cache")
+  http.ServeContent(w, r, asset_path, served.ModTime, bytes.NewReader(served.Content))
+}
+
+
+/*
+  serveAddr returns the address of the inherited "serve_addr"
+  prop, defaulting to port 8080 on the loopback interface.
+*/
+func serveAddr (s *Spec) (string, error) {
+  addr, ok, found := s.InheritPropString("serve_addr")
+  if found && !ok {
+    return "", fmt.Errorf("Prop \"serve_addr\" in spec %s is expected to be a string, got %T", s.Name, s.Props["serve_addr"])
+  } else if addr == "" {
+    addr = "127.0.0.1:8080"
+  }
+  return addr, nil
+}
+
+
+/*
+  TaskServeHttp serves the assets which reach it over HTTP, at the
+  paths they are finally output at. Assets buffered from earlier
+  tasks are served first, and then the spec's input assets are
+  served as they arrive, replacing earlier content at the same
+  path, and signalling live-reload clients to reload. Once the
+  input is closed, it serves until the run is cancelled, unless
+  "serve_wait" is false. It reads the following
+  props:
+
+    - serve_addr: Inherited. The address to listen on. Defaults
+      to "127.0.0.1:8080".
+    - serve_wait: If false, the server stops once the input is
+      closed. Defaults to true.
+*/
+func TaskServeHttp (s *Spec, tk *Task) error {
+  addr, err := serveAddr(s)
+  if err != nil { return err }
+
+  var wait = true
+  if prop_wait, ok, found := s.GetPropBool("serve_wait"); found && !ok {
+    return fmt.Errorf("Prop \"serve_wait\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["serve_wait"])
+  } else if found {
+    wait = prop_wait
+  }
+
+  var asset_server = NewAssetServer()
+
+  // serve serves an asset chunk, flattening multi-assets
+  //
+  var serve = func (chunk *Asset) error {
+    assets, err := chunk.FlattenContext(tk.Context())
+    if err != nil { return err }
+    for _, asset := range assets {
+      if err := asset_server.SetAsset(finalOutputPath(s, asset), asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  }
+
+  for _, chunk := range tk.Assets {
+    if err := serve(chunk); err != nil {
+      return err
+    }
+  }
+  tk.Assets = nil
+
+  listener, err := net.Listen("tcp", addr)
+  if err != nil {
+    return fmt.Errorf("Cannot serve on %s: %w", addr, err)
+  }
+
+  var http_server = &http.Server { Handler: asset_server }
+  http_server.RegisterOnShutdown(asset_server.Close)
+  var serve_err   = make(chan error, 1)
+  go func () { serve_err <- http_server.Serve(listener) }()
+
+  defer func () {
+    shutdown_ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+    http_server.Shutdown(shutdown_ctx)
+  }()
+
+  tk.Println("Serving on http://" + listener.Addr().String())
+
+  var input = s.Input
+  for input != nil || wait {
+    select {
+    case <-tk.Context().Done():
+      return nil
+
+    case err := <-serve_err:
+      if errors.Is(err, http.ErrServerClosed) {
+        return nil
+      }
+      return fmt.Errorf("Cannot serve on %s: %w", addr, err)
+
+    case chunk, ok := <-input:
+      if !ok {
+        input = nil
+        continue
+      }
+      if err := serve(chunk); err != nil {
+        return err
+      }
+      asset_server.Reload()
+    }
+  }
+
+  return nil
+}
@@ -0,0 +1,180 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "context"
+  "io"
+  "net"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+
+func TestAssetServer (t *testing.T) {
+  var asset_server = NewAssetServer()
+  asset_server.Set("index.html", []byte("<p>Home</p>"), "text/html")
+  asset_server.Set("/about/index.html", []byte("<p>About</p>"), "text/html")
+  asset_server.Set("/style.css", []byte("body { margin: 0 }"), "text/css")
+  asset_server.Set("/404.html", []byte("<p>Not found</p>"), "text/html")
+
+  server := httptest.NewServer(asset_server)
+  defer server.Close()
+
+  var client = &http.Client {
+    CheckRedirect: func (*http.Request, []*http.Request) error {
+      return http.ErrUseLastResponse
+    },
+  }
+
+  var test_cases = []struct {
+    method, path  string
+    status        int
+    body, header  string
+  } {
+    { method: "GET",  path: "/",           status: 200, body: "<p>Home</p>", header: "text/html" },
+    { method: "GET",  path: "/style.css",  status: 200, body: "body { margin: 0 }", header: "text/css" },
+    { method: "GET",  path: "/about",      status: 301, header: "/about/" },
+    { method: "GET",  path: "/about/",     status: 200, body: "<p>About</p>" },
+    { method: "GET",  path: "/missing",    status: 404, body: "<p>Not found</p>" },
+    { method: "POST", path: "/",           status: 405 },
+  }
+
+  for _, test_case := range test_cases {
+    request, err := http.NewRequest(test_case.method, server.URL + test_case.path, nil)
+    if err != nil { t.Fatal(err) }
+
+    response, err := client.Do(request)
+    if err != nil { t.Fatal(err) }
+    body, _ := io.ReadAll(response.Body)
+    response.Body.Close()
+
+    if response.StatusCode != test_case.status {
+      t.Errorf("%s %s: expected status %d, got %d", test_case.method, test_case.path, test_case.status, response.StatusCode)
+    }
+    if test_case.body != "" && string(body) != test_case.body {
+      t.Errorf("%s %s: expected %q, got %q", test_case.method, test_case.path, test_case.body, body)
+    }
+    if test_case.status == 301 && response.Header.Get("Location") != test_case.header {
+      t.Errorf("%s %s: expected a redirect to %s, got %s", test_case.method, test_case.path, test_case.header, response.Header.Get("Location"))
+    } else if test_case.status == 200 && test_case.header != "" && response.Header.Get("Content-Type") != test_case.header {
+      t.Errorf("%s %s: expected the type %s, got %s", test_case.method, test_case.path, test_case.header, response.Header.Get("Content-Type"))
+    }
+  }
+}
+
+
+func TestAssetServerReload (t *testing.T) {
+  var asset_server = NewAssetServer()
+  server := httptest.NewServer(asset_server)
+  defer server.Close()
+
+  // Headers are sent once the client is listening for reloads
+  //
+  response, err := http.Get(server.URL + LiveReloadPath)
+  if err != nil { t.Fatal(err) }
+  defer response.Body.Close()
+
+  if content_type := response.Header.Get("Content-Type"); content_type != "text/event-stream" {
+    t.Errorf("Expected an event stream, got %s", content_type)
+  }
+
+  asset_server.Reload()
+
+  var event = make([]byte, len("event: reload"))
+  if _, err := io.ReadFull(response.Body, event); err != nil || string(event) != "event: reload" {
+    t.Errorf("Expected a reload event, got %q, %v", event, err)
+  }
+
+  // Closing the server ends the stream
+  //
+  asset_server.Close()
+  var done = make(chan error, 1)
+  go func () {
+    _, err := io.ReadAll(response.Body)
+    done <- err
+  }()
+
+  select {
+  case err := <-done:
+    if err != nil {
+      t.Errorf("Expected the stream to end, got %v", err)
+    }
+  case <-time.After(5 * time.Second):
+    t.Error("Expected the stream to end once the server is closed")
+  }
+}
+
+
+func TestTaskServeHttp (t *testing.T) {
+  // Find a free port
+  //
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil { t.Fatal(err) }
+  var addr = listener.Addr().String()
+  listener.Close()
+
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("site", nil))
+
+  root.Props["quiet"]      = true
+  root.Props["serve_addr"] = addr
+  subspec.PathTransformations, _ = PathTransformationsFromAny("s`^`blog/`")
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    asset := s.MakeAsset("index.html")
+    asset.Mimetype = "text/html"
+    asset.SetContentBytes([]byte("<p>Hello</p>"))
+    return tk.EmitAsset(asset)
+  })
+
+  root.DeferTaskFunc("root-consume", TaskServeHttp)
+
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+
+  var run_err = make(chan error, 1)
+  go func () { run_err <- root.RunContext(ctx) }()
+
+  // Poll until the asset is served
+  //
+  var body string
+  for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+    response, err := http.Get("http://" + addr + "/blog/")
+    if err != nil {
+      continue
+    }
+    content, _ := io.ReadAll(response.Body)
+    response.Body.Close()
+    if response.StatusCode == http.StatusOK {
+      body = string(content)
+      break
+    }
+  }
+
+  if body != "<p>Hello</p>" {
+    t.Errorf("Expected the emitted page to be served, got %q", body)
+  }
+
+  // The server runs until the run is cancelled
+  //
+  select {
+  case err := <-run_err:
+    t.Fatalf("Expected the server to wait, but the run returned %v", err)
+  default:
+  }
+
+  cancel()
+  select {
+  case <-run_err:
+  case <-time.After(5 * time.Second):
+    t.Fatal("Expected the run to return once cancelled")
+  }
+
+  if _, err := http.Get("http://" + addr + "/blog/"); err == nil || !strings.Contains(err.Error(), "refused") {
+    t.Errorf("Expected the server to be shut down, got %v", err)
+  }
+}
@@ -0,0 +1,352 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "encoding/xml"
+  "fmt"
+  "mime"
+  "net/url"
+  "path"
+  "slices"
+  "strings"
+)
+
+
+/*
+  sitemap_url_limit is the most URLs the sitemap protocol allows
+  in a single sitemap file. Beyond it, the URLs are split across
+  several sitemaps, listed by a sitemap index.
+*/
+var sitemap_url_limit = 50000
+
+
+const sitemap_xmlns = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+
+/*
+  TaskResolverEmitSitemap resolves the "emit-sitemap" task, which
+  emits a sitemap.xml asset listing the HTML assets which reach
+  it. It runs after Markdown is rendered, and before the root
+  spec's "root-consume" task, so that the sitemap describes the
+  assets the spec outputs.
+*/
+var TaskResolverEmitSitemap = TaskResolver {
+  Id:   "emit-sitemap",
+  Name: "emit-sitemap",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "emit-sitemap", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_CONSUME | TASK_ASSETS_GENERATE,
+    Func:   TaskEmitSitemap,
+    After:  []string { "render-markdown" },
+    Before: []string { "root-consume" },
+  },
+}
+
+
+type SitemapUrl struct {
+  Loc  string  `xml:"loc"`
+}
+
+
+type SitemapUrlSet struct {
+  XMLName  xml.Name      `xml:"urlset"`
+  Xmlns    string        `xml:"xmlns,attr"`
+  Urls     []SitemapUrl  `xml:"url"`
+}
+
+
+type SitemapEntry struct {
+  Loc  string  `xml:"loc"`
+}
+
+
+type SitemapIndex struct {
+  XMLName   xml.Name        `xml:"sitemapindex"`
+  Xmlns     string          `xml:"xmlns,attr"`
+  Sitemaps  []SitemapEntry  `xml:"sitemap"`
+}
+
+
+/*
+  BuildTaskEmitSitemap defers the "emit-sitemap" task if the
+  spec's "sitemap" prop is true, or a path for the sitemap asset.
+  The default path is "sitemap.xml".
+*/
+func BuildTaskEmitSitemap (s *Spec) error {
+  if s.GetTaskResolverById("emit-sitemap") == nil {
+    emit := TaskResolverEmitSitemap
+    s.AddTaskResolver(&emit)
+  }
+
+  sitemap_any, found := s.GetProp("sitemap")
+  if !found {
+    return nil
+  }
+
+  switch sitemap := sitemap_any.(type) {
+    case bool:
+      if !sitemap {
+        return nil
+      }
+    case string:
+      if sitemap == "" {
+        return fmt.Errorf("Prop \"sitemap\" in spec %s is an empty path", s.Name)
+      }
+    default:
+      return fmt.Errorf("Prop \"sitemap\" in spec %s is expected to be a boolean or path, got %T", s.Name, sitemap_any)
+  }
+
+  task, err := s.GetTask("emit-sitemap", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the emit-sitemap task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  sitemapPath returns the key of the sitemap asset, from the
+  "sitemap" prop.
+*/
+func sitemapPath (s *Spec) string {
+  if sitemap_path, ok, _ := s.GetPropString("sitemap"); ok && sitemap_path != "" {
+    return strings.TrimLeft(sitemap_path, "/")
+  }
+  return "sitemap.xml"
+}
+
+
+/*
+  getPropPathPatterns reads a prop holding a glob pattern, or list
+  of glob patterns, as used by path.Match. Patterns are matched
+  against asset paths with a leading slash, such as "/drafts/*".
+*/
+func getPropPathPatterns (s *Spec, key string) ([]string, error) {
+  patterns_any, found := s.GetProp(key)
+  if !found {
+    return nil, nil
+  }
+
+  var patterns []string
+
+  switch patterns_value := patterns_any.(type) {
+    case string:
+      patterns = []string { patterns_value }
+    case []string:
+      patterns = slices.Clone(patterns_value)
+    case []any:
+      for _, pattern_any := range patterns_value {
+        pattern, ok := pattern_any.(string)
+        if !ok {
+          return nil, fmt.Errorf("Prop \"%s\" in spec %s is expected to contain strings, got %T", key, s.Name, pattern_any)
+        }
+        patterns = append(patterns, pattern)
+      }
+    default:
+      return nil, fmt.Errorf("Prop \"%s\" in spec %s is expected to be a string or list of strings, got %T", key, s.Name, patterns_any)
+  }
+
+  for i, pattern := range patterns {
+    pattern = "/" + strings.TrimLeft(pattern, "/")
+    if _, err := path.Match(pattern, ""); err != nil {
+      return nil, fmt.Errorf("Invalid %s pattern %q in spec %s: %w", key, pattern, s.Name, err)
+    }
+    patterns[i] = pattern
+  }
+
+  return patterns, nil
+}
+
+
+/*
+  matchPathPatterns returns whether an asset path matches any of
+  a list of patterns from getPropPathPatterns.
+*/
+func matchPathPatterns (patterns []string, asset_path string) bool {
+  for _, pattern := range patterns {
+    if matched, _ := path.Match(pattern, asset_path); matched {
+      return true
+    }
+  }
+  return false
+}
+
+
+func isHtmlAsset (a *Asset) bool {
+  var mimetype = a.Mimetype
+  if mimetype == "" {
+    mimetype = mime.TypeByExtension(path.Ext(a.Url.Path))
+  }
+  mediatype, _, _ := mime.ParseMediaType(mimetype)
+  return mediatype == "text/html"
+}
+
+
+/*
+  assetOutputPath returns an asset's output path, without its
+  @emit directive and with a leading slash.
+*/
+func assetOutputPath (a *Asset) string {
+  var asset_path = strings.TrimPrefix(a.Url.Path, "/")
+  asset_path     = strings.TrimPrefix(asset_path, "@emit")
+  return "/" + strings.TrimLeft(asset_path, "/")
+}
+
+
+/*
+  assetOutputUrl joins an asset output path to the base URL.
+  Index documents are addressed by their directory.
+*/
+func assetOutputUrl (base_url *url.URL, asset_path string) string {
+  if strings.HasSuffix(asset_path, "/index.html") {
+    asset_path = strings.TrimSuffix(asset_path, "index.html")
+  }
+
+  var loc = *base_url
+  loc.Path     = strings.TrimRight(base_url.Path, "/") + asset_path
+  loc.RawPath  = ""
+  loc.RawQuery = ""
+  loc.Fragment = ""
+  return loc.String()
+}
+
+
+/*
+  inheritBaseUrl reads the inherited "base_url" prop, the absolute
+  URL a site is served from.
+*/
+func inheritBaseUrl (s *Spec) (*url.URL, error) {
+  base_url_str, ok, found := s.InheritPropString("base_url")
+  if found && !ok {
+    return nil, fmt.Errorf("Prop \"base_url\" in spec %s is expected to be a string, got %T", s.Name, s.Props["base_url"])
+  } else if !found || base_url_str == "" {
+    return nil, fmt.Errorf("Prop \"base_url\" is required in spec %s", s.Name)
+  }
+
+  base_url, err := url.Parse(base_url_str)
+  if err != nil {
+    return nil, fmt.Errorf("Cannot parse base_url in spec %s: %w", s.Name, err)
+  } else if !base_url.IsAbs() {
+    return nil, fmt.Errorf("Prop \"base_url\" in spec %s is expected to be an absolute URL, got %s", s.Name, base_url_str)
+  }
+
+  return base_url, nil
+}
+
+
+func marshalXmlDocument (v any) ([]byte, error) {
+  content, err := xml.MarshalIndent(v, "", "  ")
+  if err != nil { return nil, err }
+  return append([]byte(xml.Header), append(content, '\n')...), nil
+}
+
+
+/*
+  TaskEmitSitemap pools the spec's input assets, forwards them,
+  and emits a sitemap listing the HTML assets among them. It reads
+  the following props:
+
+    - base_url:        Inherited. The absolute URL the site is
+      served from, which asset paths are joined to.
+    - sitemap:         True, or the path of the sitemap asset.
+      Defaults to "sitemap.xml".
+    - sitemap_exclude: A glob pattern, or list of them, of asset
+      paths to leave out of the sitemap, such as "/drafts/*".
+      Patterns match paths before the spec's path
+      transformations are applied.
+
+  The URLs listed are those of the assets once they are output
+  from the root spec, with the path transformations of the spec
+  and its ancestors applied, as in finalOutputPath.
+
+  If there are more URLs than a single sitemap allows, they are
+  split across numbered sitemaps, such as "sitemap-1.xml", and
+  the sitemap path is written as a sitemap index listing them.
+*/
+func TaskEmitSitemap (s *Spec, tk *Task) error {
+  base_url, err := inheritBaseUrl(s)
+  if err != nil { return err }
+
+  exclude_patterns, err := getPropPathPatterns(s, "sitemap_exclude")
+  if err != nil { return err }
+
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets for sitemap: %w", err)
+  }
+
+  var asset_paths = make(map[string]bool)
+
+  for _, input := range tk.Assets {
+    assets, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+
+    for _, asset := range assets {
+      if !isHtmlAsset(asset) {
+        continue
+      }
+
+      // Sitemaps list the URLs assets are served at, once the
+      // path transformations of the spec and its ancestors are
+      // applied
+      //
+      if !matchPathPatterns(exclude_patterns, assetOutputPath(asset)) {
+        asset_paths[finalOutputPath(s, asset)] = true
+      }
+    }
+  }
+
+  if err := tk.ForwardAssets(); err != nil {
+    return err
+  }
+
+  var urls = make([]SitemapUrl, 0, len(asset_paths))
+  for asset_path := range asset_paths {
+    urls = append(urls, SitemapUrl { Loc: assetOutputUrl(base_url, asset_path) })
+  }
+  slices.SortFunc(urls, func (a, b SitemapUrl) int {
+    return strings.Compare(a.Loc, b.Loc)
+  })
+
+  var sitemap_path = sitemapPath(s)
+
+  if len(urls) <= sitemap_url_limit {
+    return sitemapEmit(s, tk, sitemap_path, SitemapUrlSet { Xmlns: sitemap_xmlns, Urls: urls })
+  }
+
+  var index     = SitemapIndex { Xmlns: sitemap_xmlns }
+  var extension = path.Ext(sitemap_path)
+  var stem      = strings.TrimSuffix(sitemap_path, extension)
+
+  for i := 0; i * sitemap_url_limit < len(urls); i++ {
+    var chunk = urls[i * sitemap_url_limit : min((i + 1) * sitemap_url_limit, len(urls))]
+    var chunk_path = fmt.Sprintf("%s-%d%s", stem, i + 1, extension)
+
+    err := sitemapEmit(s, tk, chunk_path, SitemapUrlSet { Xmlns: sitemap_xmlns, Urls: chunk })
+    if err != nil { return err }
+
+    var chunk_url = assetOutputUrl(base_url, finalTransformPath(s, chunk_path, "application/xml"))
+    index.Sitemaps = append(index.Sitemaps, SitemapEntry { Loc: chunk_url })
+  }
+
+  return sitemapEmit(s, tk, sitemap_path, index)
+}
+
+
+func sitemapEmit (s *Spec, tk *Task, key string, document any) error {
+  content, err := marshalXmlDocument(document)
+  if err != nil { return err }
+
+  asset := s.MakeAsset(key)
+  asset.Mimetype = "application/xml"
+  if err := asset.SetContentBytes(content); err != nil {
+    return err
+  }
+
+  return tk.EmitAsset(asset)
+}
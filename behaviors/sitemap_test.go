@@ -0,0 +1,211 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "encoding/xml"
+  "fmt"
+  "testing"
+  "strings"
+)
+
+
+func runSitemapSpec (t *testing.T, props map[string]any, keys []string) map[string]*Asset {
+  t.Helper()
+
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"]    = true
+  root.Props["base_url"] = "https://example.com/site/"
+  for key, value := range props {
+    subspec.Props[key] = value
+  }
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for _, key := range keys {
+      asset := s.MakeAsset(key)
+      asset.SetContentBytes([]byte("<p>" + key + "</p>"))
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var received = make(map[string]*Asset)
+
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      asset_path := strings.TrimLeft(asset.Url.Path, "/")
+      received[strings.TrimPrefix(asset_path, "@emit/")] = asset
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildTransform)
+  root.AddSpecBuilder(BuildTaskRenderMarkdown)
+  root.AddSpecBuilder(BuildTaskEmitSitemap)
+  if err := subspec.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+  return received
+}
+
+
+func TestTaskEmitSitemap (t *testing.T) {
+  received := runSitemapSpec(t,
+    map[string]any {
+      "sitemap":         true,
+      "sitemap_exclude": []any { "/drafts/*", "404.html" },
+    },
+    []string {
+      "index.html", "about/index.html", "a b.html", "style.css",
+      "404.html", "drafts/wip.html",
+    },
+  )
+
+  if _, found := received["style.css"]; !found {
+    t.Errorf("Expected assets to be forwarded, got %v", received)
+  }
+
+  sitemap, found := received["sitemap.xml"]
+  if !found {
+    t.Fatalf("Expected a sitemap.xml asset, got %v", received)
+  }
+
+  content, err := sitemap.GetContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  var urlset SitemapUrlSet
+  if err := xml.Unmarshal(content, &urlset); err != nil {
+    t.Fatal(err)
+  }
+
+  var locs []string
+  for _, u := range urlset.Urls {
+    locs = append(locs, u.Loc)
+  }
+
+  var expect = strings.Join([]string {
+    "https://example.com/site/",
+    "https://example.com/site/a%20b.html",
+    "https://example.com/site/about/",
+  }, " ")
+
+  if got := strings.Join(locs, " "); got != expect {
+    t.Errorf("Expected sitemap URLs %s, got %s", expect, got)
+  }
+}
+
+
+func TestTaskEmitSitemapTransform (t *testing.T) {
+  // As the last task of its spec, the sitemap task forwards pages
+  // through the spec's emit path, and lists them where the
+  // spec's path transformations place them
+  //
+  received := runSitemapSpec(t,
+    map[string]any {
+      "sitemap":   true,
+      "transform": map[string]any { "prefix": "blog" },
+    },
+    []string { "index.html", "posts/first.html" },
+  )
+
+  for _, key := range []string { "blog/index.html", "blog/posts/first.html", "blog/sitemap.xml" } {
+    asset, found := received[key]
+    if !found {
+      t.Fatalf("Expected an asset at %s, got %v", key, received)
+    }
+    if got, expect := strings.TrimLeft(asset.Url.Path, "/"), "@emit/" + key; got != expect {
+      t.Errorf("Expected the asset to be emitted at %s, got %s", expect, got)
+    }
+  }
+
+  content, err := received["blog/sitemap.xml"].GetContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  var urlset SitemapUrlSet
+  if err := xml.Unmarshal(content, &urlset); err != nil {
+    t.Fatal(err)
+  }
+
+  var locs []string
+  for _, u := range urlset.Urls {
+    locs = append(locs, u.Loc)
+  }
+
+  var expect = "https://example.com/site/blog/ https://example.com/site/blog/posts/first.html"
+  if got := strings.Join(locs, " "); got != expect {
+    t.Errorf("Expected sitemap URLs %s, got %s", expect, got)
+  }
+}
+
+
+func TestTaskEmitSitemapMarkdown (t *testing.T) {
+  // Markdown rendering is deferred before the sitemap task, as
+  // the CLI's builders do, and pages are listed by their
+  // rendered paths
+  //
+  received := runSitemapSpec(t,
+    map[string]any { "sitemap": true, "markdown": true },
+    []string { "index.html", "post.md" },
+  )
+
+  sitemap, found := received["sitemap.xml"]
+  if !found {
+    t.Fatalf("Expected a sitemap.xml asset, got %v", received)
+  }
+
+  content, err := sitemap.GetContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  if !strings.Contains(string(content), "https://example.com/site/post.html") {
+    t.Errorf("Expected the rendered post in the sitemap, got:\n%s", content)
+  }
+}
+
+
+func TestTaskEmitSitemapIndex (t *testing.T) {
+  var limit = sitemap_url_limit
+  sitemap_url_limit = 2
+  defer func () { sitemap_url_limit = limit }()
+
+  var keys []string
+  for i := range 5 {
+    keys = append(keys, fmt.Sprintf("page-%d.html", i))
+  }
+
+  received := runSitemapSpec(t, map[string]any { "sitemap": "maps/sitemap.xml" }, keys)
+
+  index_asset, found := received["maps/sitemap.xml"]
+  if !found {
+    t.Fatalf("Expected a sitemap index asset, got %v", received)
+  }
+
+  content, err := index_asset.GetContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  var index SitemapIndex
+  if err := xml.Unmarshal(content, &index); err != nil {
+    t.Fatal(err)
+  }
+
+  if got := len(index.Sitemaps); got != 3 {
+    t.Fatalf("Expected 3 sitemaps in the index, got %d", got)
+  }
+  if got, expect := index.Sitemaps[2].Loc, "https://example.com/site/maps/sitemap-3.xml"; got != expect {
+    t.Errorf("Expected the last sitemap at %s, got %s", expect, got)
+  }
+
+  for i := 1; i <= 3; i++ {
+    if _, found := received[fmt.Sprintf("maps/sitemap-%d.xml", i)]; !found {
+      t.Errorf("Expected a sitemap-%d.xml asset, got %v", i, received)
+    }
+  }
+}
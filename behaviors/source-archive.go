@@ -0,0 +1,350 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "archive/tar"
+  "archive/zip"
+  "compress/bzip2"
+  "compress/gzip"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "os"
+  "path"
+  "path/filepath"
+  "regexp"
+  "strings"
+)
+
+
+/*
+  TaskResolverSourceArchive resolves the "source-archive" task,
+  which downloads a tarball or zip archive from the spec's "source"
+  URL and extracts it into its source_dir.
+*/
+var TaskResolverSourceArchive = TaskResolver {
+  Id:         "source-archive",
+  Name:       "source-archive",
+  AcceptMask: TASK_MASK_DEFINED,
+  TaskPrototype: Task {
+    Mask: TASK_MASK_DEFINED,
+    Func: TaskSourceArchive,
+  },
+}
+
+
+var sha256_pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+
+/*
+  archive_suffixes maps the file extensions of supported archives
+  to their formats.
+*/
+var archive_suffixes = []struct { Suffix, Format string } {
+  { ".tar.gz",  "tar.gz"  },
+  { ".tgz",     "tar.gz"  },
+  { ".tar.bz2", "tar.bz2" },
+  { ".tbz2",    "tar.bz2" },
+  { ".tar",     "tar"     },
+  { ".zip",     "zip"     },
+}
+
+
+/*
+  archiveFormat returns the format of an HTTP or HTTPS URL to an
+  archive, from its file extension, or an empty string if it is
+  not one.
+*/
+func archiveFormat (source *url.URL) string {
+  if source.Scheme != "http" && source.Scheme != "https" {
+    return ""
+  }
+
+  var source_path = strings.ToLower(source.Path)
+  for _, archive_suffix := range archive_suffixes {
+    if strings.HasSuffix(source_path, archive_suffix.Suffix) {
+      return archive_suffix.Format
+    }
+  }
+  return ""
+}
+
+
+/*
+  BuildTaskSourceArchive enqueues the "source-archive" task, and
+  source inference after it, if the spec's "source" is a URL to an
+  archive.
+*/
+func BuildTaskSourceArchive (s *Spec) error {
+  if s.GetTaskResolverById("source-archive") == nil {
+    archive := TaskResolverSourceArchive
+    s.AddTaskResolver(&archive)
+  }
+
+  source, ok, _ := s.GetPropUrl("source")
+  if !ok || archiveFormat(source) == "" {
+    return nil
+  }
+
+  if sha, ok, found := s.GetPropString("source_sha256"); found && (!ok || !sha256_pattern.MatchString(sha)) {
+    return fmt.Errorf("Prop \"source_sha256\" in spec %s is expected to be a hexadecimal SHA-256 hash, got %v", s.Name, s.Props["source_sha256"])
+  }
+
+  if _, err := s.EnqueueUniqueTaskName("source-archive"); err != nil {
+    return err
+  }
+  if _, err := s.EnqueueUniqueTaskName("source-infer"); err != nil {
+    return err
+  }
+  return nil
+}
+
+
+/*
+  archiveEntryPath returns the path of an archive entry within a
+  directory, or an error if the entry would be written outside of
+  it.
+*/
+func archiveEntryPath (dir, name string) (string, error) {
+  var clean = path.Clean(strings.ReplaceAll(name, `\`, "/"))
+  if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+    return "", fmt.Errorf("Archive entry %q is outside of the archive", name)
+  }
+  return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+
+/*
+  writeArchiveFile writes the content of an archive entry to a
+  file, creating its directory.
+*/
+func writeArchiveFile (file_path string, mode os.FileMode, content io.Reader) error {
+  if err := os.MkdirAll(filepath.Dir(file_path), os.ModePerm); err != nil {
+    return err
+  }
+
+  file, err := os.OpenFile(file_path, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, mode.Perm() | 0o600)
+  if err != nil { return err }
+
+  if _, err := io.Copy(file, content); err != nil {
+    file.Close()
+    return err
+  }
+  return file.Close()
+}
+
+
+/*
+  extractTar extracts the directories, files, and symbolic links of
+  a tar archive into a directory. Symbolic links may not point
+  outside of the directory. Other entries, such as hard links, are
+  skipped.
+*/
+func extractTar (archive io.Reader, dir string) error {
+  var reader = tar.NewReader(archive)
+
+  for {
+    header, err := reader.Next()
+    if err == io.EOF {
+      return nil
+    } else if err != nil {
+      return err
+    }
+
+    entry_path, err := archiveEntryPath(dir, header.Name)
+    if err != nil { return err }
+
+    switch header.Typeflag {
+    case tar.TypeDir:
+      if err := os.MkdirAll(entry_path, os.ModePerm); err != nil {
+        return err
+      }
+
+    case tar.TypeReg:
+      if err := writeArchiveFile(entry_path, header.FileInfo().Mode(), reader); err != nil {
+        return err
+      }
+
+    case tar.TypeSymlink:
+      if err := os.MkdirAll(filepath.Dir(entry_path), os.ModePerm); err != nil {
+        return err
+      }
+
+      // The link's directory may itself be reached through links,
+      // so its target is checked from its real directory
+      //
+      real_dir, err := filepath.EvalSymlinks(dir)
+      if err != nil { return err }
+      real_parent, err := filepath.EvalSymlinks(filepath.Dir(entry_path))
+      if err != nil { return err }
+
+      var target = filepath.FromSlash(header.Linkname)
+      relative, err := filepath.Rel(real_dir, filepath.Join(real_parent, target))
+      if filepath.IsAbs(target) || err != nil || relative == ".." || strings.HasPrefix(relative, ".." + string(filepath.Separator)) {
+        return fmt.Errorf("Archive symbolic link %q points outside of the archive", header.Name)
+      }
+
+      if err := os.Symlink(target, entry_path); err != nil {
+        return err
+      }
+    }
+  }
+}
+
+
+/*
+  extractZip extracts the directories and files of a zip archive
+  into a directory.
+*/
+func extractZip (archive *os.File, dir string) error {
+  info, err := archive.Stat()
+  if err != nil { return err }
+
+  reader, err := zip.NewReader(archive, info.Size())
+  if err != nil { return err }
+
+  for _, entry := range reader.File {
+    entry_path, err := archiveEntryPath(dir, entry.Name)
+    if err != nil { return err }
+
+    if entry.FileInfo().IsDir() {
+      if err := os.MkdirAll(entry_path, os.ModePerm); err != nil {
+        return err
+      }
+      continue
+    }
+
+    content, err := entry.Open()
+    if err != nil { return err }
+
+    err = writeArchiveFile(entry_path, entry.Mode(), content)
+    content.Close()
+    if err != nil { return err }
+  }
+
+  return nil
+}
+
+
+/*
+  ExtractArchive extracts an archive file of a format returned by
+  archiveFormat into a directory.
+*/
+func ExtractArchive (archive *os.File, format, dir string) error {
+  switch format {
+  case "zip":
+    return extractZip(archive, dir)
+
+  case "tar":
+    return extractTar(archive, dir)
+
+  case "tar.bz2":
+    return extractTar(bzip2.NewReader(archive), dir)
+
+  case "tar.gz":
+    decompressed, err := gzip.NewReader(archive)
+    if err != nil { return err }
+    defer decompressed.Close()
+    return extractTar(decompressed, dir)
+  }
+
+  return fmt.Errorf("Unsupported archive format: %s", format)
+}
+
+
+/*
+  TaskSourceArchive downloads the archive at the spec's "source"
+  URL and extracts it into the spec's source_dir, unless the
+  source_dir already exists. If the archive's entries are all in
+  one directory, as with GitHub's archives, that directory's
+  contents become the source_dir. It reads the following props:
+
+    - source_sha256: The hexadecimal SHA-256 hash of the archive.
+      If set, the archive is only extracted if it matches.
+*/
+func TaskSourceArchive (s *Spec, tk *Task) error {
+  source, err := s.RequirePropUrl("source")
+  if err != nil { return err }
+
+  var format = archiveFormat(source)
+  if format == "" {
+    return fmt.Errorf("Source of spec %s is not an archive URL: %s", s.Name, source)
+  }
+
+  expect_sha, _, _ := s.GetPropString("source_sha256")
+
+  source_dir, err := s.RequirePropString("source_dir")
+  if err != nil { return err }
+
+  source_dir, err = filepath.Abs(source_dir)
+  if err != nil { return err }
+
+  defer lockPathMutex(source_dir)()
+
+  if exists, err := s.PathExists("./"); err != nil || exists {
+    return err
+  }
+
+  if err := os.MkdirAll(filepath.Dir(source_dir), os.ModePerm); err != nil {
+    return err
+  }
+
+  // Download the archive next to the source_dir, hashing it as
+  // it is written
+  //
+  tk.Println("Downloading", source.String())
+
+  response, err := http.Get(source.String())
+  if err != nil {
+    return fmt.Errorf("Cannot download %s: %w", source, err)
+  }
+  defer response.Body.Close()
+
+  if response.StatusCode != http.StatusOK {
+    return fmt.Errorf("Cannot download %s: %s", source, response.Status)
+  }
+
+  archive, err := os.CreateTemp(filepath.Dir(source_dir), filepath.Base(source_dir) + ".download-")
+  if err != nil { return err }
+  defer os.Remove(archive.Name())
+  defer archive.Close()
+
+  var hash = sha256.New()
+  if _, err := io.Copy(io.MultiWriter(archive, hash), response.Body); err != nil {
+    return fmt.Errorf("Cannot download %s: %w", source, err)
+  }
+
+  if sha := hex.EncodeToString(hash.Sum(nil)); expect_sha != "" && !strings.EqualFold(sha, expect_sha) {
+    return fmt.Errorf("Archive %s has the SHA-256 hash %s, expected %s", source, sha, expect_sha)
+  }
+
+  if _, err := archive.Seek(0, io.SeekStart); err != nil {
+    return err
+  }
+
+  // Extract into a temporary directory, which is renamed once the
+  // archive is extracted in full
+  //
+  extract_dir, err := os.MkdirTemp(filepath.Dir(source_dir), filepath.Base(source_dir) + ".extract-")
+  if err != nil { return err }
+  defer os.RemoveAll(extract_dir)
+
+  if err := ExtractArchive(archive, format, extract_dir); err != nil {
+    return fmt.Errorf("Cannot extract %s: %w", source, err)
+  }
+
+  var extracted = extract_dir
+  if dirents, err := os.ReadDir(extract_dir); err != nil {
+    return err
+  } else if len(dirents) == 1 && dirents[0].IsDir() {
+    extracted = filepath.Join(extract_dir, dirents[0].Name())
+  } else if err := os.Chmod(extract_dir, 0o755); err != nil {
+    return err
+  }
+
+  return os.Rename(extracted, source_dir)
+}
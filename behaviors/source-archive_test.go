@@ -0,0 +1,186 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "archive/tar"
+  "archive/zip"
+  "bytes"
+  "compress/gzip"
+  "crypto/sha256"
+  "encoding/hex"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+/*
+  archiveEntry is a file, or a symbolic link if Link is set, in a
+  test archive.
+*/
+type archiveEntry struct {
+  Name, Content, Link string
+}
+
+
+func makeTarGz (t *testing.T, entries []archiveEntry) []byte {
+  t.Helper()
+
+  var buffer bytes.Buffer
+  compressor := gzip.NewWriter(&buffer)
+  writer     := tar.NewWriter(compressor)
+
+  for _, entry := range entries {
+    var header = &tar.Header { Name: entry.Name, Mode: 0o644, Size: int64(len(entry.Content)) }
+    if entry.Link != "" {
+      header = &tar.Header { Name: entry.Name, Typeflag: tar.TypeSymlink, Linkname: entry.Link }
+    }
+    if err := writer.WriteHeader(header); err != nil {
+      t.Fatal(err)
+    }
+    if _, err := writer.Write([]byte(entry.Content)); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if err := writer.Close(); err != nil { t.Fatal(err) }
+  if err := compressor.Close(); err != nil { t.Fatal(err) }
+  return buffer.Bytes()
+}
+
+
+func makeZip (t *testing.T, entries []archiveEntry) []byte {
+  t.Helper()
+
+  var buffer bytes.Buffer
+  writer := zip.NewWriter(&buffer)
+
+  for _, entry := range entries {
+    file, err := writer.Create(entry.Name)
+    if err != nil { t.Fatal(err) }
+    if _, err := file.Write([]byte(entry.Content)); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if err := writer.Close(); err != nil { t.Fatal(err) }
+  return buffer.Bytes()
+}
+
+
+func TestTaskSourceArchive (t *testing.T) {
+  var archives = map[string][]byte {
+    "/site/archive/main.tar.gz": makeTarGz(t, []archiveEntry {
+      { Name: "site-main/index.html", Content: "<p>Hello</p>" },
+      { Name: "site-main/css/style.css", Content: "body { margin: 0 }" },
+      { Name: "site-main/style.css", Link: "css/style.css" },
+    }),
+    "/site.zip": makeZip(t, []archiveEntry {
+      { Name: "index.html", Content: "<p>Zip</p>" },
+      { Name: "css/style.css", Content: "body { margin: 0 }" },
+    }),
+    "/escape.tar.gz": makeTarGz(t, []archiveEntry {
+      { Name: "../escape.html", Content: "<p>Escape</p>" },
+    }),
+    "/escape-link.tar.gz": makeTarGz(t, []archiveEntry {
+      { Name: "dir/up", Link: ".." },
+      { Name: "dir/up/escape", Link: ".." },
+    }),
+  }
+
+  server := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+    if archive, found := archives[r.URL.Path]; found {
+      w.Write(archive)
+      return
+    }
+    http.NotFound(w, r)
+  }))
+  defer server.Close()
+
+  var tar_gz_hash = sha256.Sum256(archives["/site/archive/main.tar.gz"])
+
+  // extract runs a spec with an archive source, returning its
+  // source_dir
+  //
+  var extract = func (source_path string, props map[string]any) (string, error) {
+    t.Helper()
+
+    var source_dir = filepath.Join(t.TempDir(), "site")
+
+    root    := NewSpec("root", nil)
+    subspec := root.AddSubspec(NewSpec("site", nil))
+
+    root.Props["quiet"]         = true
+    subspec.Props["source"]     = server.URL + source_path
+    subspec.Props["source_dir"] = source_dir
+    for key, value := range props {
+      subspec.Props[key] = value
+    }
+
+    root.AddSpecBuilder(BuildSourceURLType)
+    root.AddSpecBuilder(BuildTaskInferSource)
+    root.AddSpecBuilder(BuildTaskSourceGitClone)
+    root.AddSpecBuilder(BuildTaskSourceArchive)
+    if err := subspec.Build(); err != nil {
+      return source_dir, err
+    }
+
+    return source_dir, root.Run()
+  }
+
+  var expectFile = func (file_path, expect string) {
+    t.Helper()
+    content, err := os.ReadFile(file_path)
+    if err != nil || string(content) != expect {
+      t.Errorf("Expected %s to contain %q, got %q, %v", file_path, expect, content, err)
+    }
+  }
+
+  t.Run("tar.gz", func (t *testing.T) {
+    source_dir, err := extract("/site/archive/main.tar.gz", map[string]any {
+      "source_sha256": strings.ToUpper(hex.EncodeToString(tar_gz_hash[:])),
+    })
+    if err != nil { t.Fatal(err) }
+
+    expectFile(filepath.Join(source_dir, "index.html"), "<p>Hello</p>")
+    expectFile(filepath.Join(source_dir, "style.css"), "body { margin: 0 }")
+  })
+
+  t.Run("zip", func (t *testing.T) {
+    source_dir, err := extract("/site.zip", nil)
+    if err != nil { t.Fatal(err) }
+
+    expectFile(filepath.Join(source_dir, "index.html"), "<p>Zip</p>")
+    expectFile(filepath.Join(source_dir, "css", "style.css"), "body { margin: 0 }")
+  })
+
+  t.Run("checksum mismatch", func (t *testing.T) {
+    source_dir, err := extract("/site/archive/main.tar.gz", map[string]any {
+      "source_sha256": strings.Repeat("0", 64),
+    })
+    if err == nil || !strings.Contains(err.Error(), "SHA-256") {
+      t.Errorf("Expected a checksum error, got %v", err)
+    }
+    if _, err := os.Stat(source_dir); !os.IsNotExist(err) {
+      t.Errorf("Expected nothing to be extracted, got %v", err)
+    }
+  })
+
+  t.Run("invalid checksum", func (t *testing.T) {
+    if _, err := extract("/site.zip", map[string]any { "source_sha256": "abc" }); err == nil {
+      t.Error("Expected an invalid source_sha256 to be an error")
+    }
+  })
+
+  for _, source_path := range []string { "/escape.tar.gz", "/escape-link.tar.gz", "/missing.zip" } {
+    t.Run(source_path, func (t *testing.T) {
+      if _, err := extract(source_path, nil); err == nil {
+        t.Errorf("Expected extracting %s to fail", source_path)
+      }
+    })
+  }
+}
@@ -0,0 +1,421 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bytes"
+  "fmt"
+  "io"
+  "mime"
+  "net/http"
+  "net/url"
+  "path"
+  "strings"
+  "time"
+
+  "golang.org/x/net/html"
+)
+
+
+/*
+  TaskResolverSourceCrawl resolves the "source-crawl" task, which
+  crawls the website at the spec's "source" URL and emits its
+  pages and the assets they reference.
+*/
+var TaskResolverSourceCrawl = TaskResolver {
+  Id:   "source-crawl",
+  Name: "source-crawl",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "source-crawl", nil
+  },
+  TaskPrototype: Task {
+    Mask: TASK_ASSETS_GENERATE,
+    Func: TaskSourceCrawl,
+  },
+}
+
+
+/*
+  CrawlClient is the HTTP client used to crawl websites.
+*/
+var CrawlClient = &http.Client { Timeout: 30 * time.Second }
+
+
+/*
+  BuildTaskSourceCrawl enqueues the "source-crawl" task if the
+  spec has a "source_crawl" prop of true, and an HTTP or HTTPS
+  "source".
+*/
+func BuildTaskSourceCrawl (s *Spec) error {
+  if s.GetTaskResolverById("source-crawl") == nil {
+    crawl := TaskResolverSourceCrawl
+    s.AddTaskResolver(&crawl)
+  }
+
+  crawl, ok, found := s.GetPropBool("source_crawl")
+  if !found {
+    return nil
+  } else if !ok {
+    return fmt.Errorf("Prop \"source_crawl\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["source_crawl"])
+  } else if !crawl {
+    return nil
+  }
+
+  source, ok, _ := s.GetPropUrl("source")
+  if !ok || (source.Scheme != "http" && source.Scheme != "https") {
+    return fmt.Errorf("Spec %s has source_crawl set, but its source is not an HTTP or HTTPS URL", s.Name)
+  }
+
+  _, err := s.EnqueueUniqueTaskName("source-crawl")
+  return err
+}
+
+
+/*
+  crawlKey returns the form of a URL which identifies a crawled
+  resource, without its query string or fragment.
+*/
+func crawlKey (u *url.URL) string {
+  var key = url.URL { Scheme: u.Scheme, Host: u.Host, Path: u.Path }
+  if key.Path == "" {
+    key.Path = "/"
+  }
+  return key.String()
+}
+
+
+/*
+  CrawlAssetPath returns the path at which a crawled resource is
+  emitted. Paths ending in a slash, and HTML pages without a file
+  extension, are emitted as index.html files. Resources from hosts
+  other than the crawled site's are emitted in a directory named
+  after their host.
+*/
+func CrawlAssetPath (site_host string, u *url.URL, mimetype string) string {
+  var asset_path = u.Path
+  if asset_path == "" {
+    asset_path = "/"
+  }
+
+  if strings.HasSuffix(asset_path, "/") {
+    asset_path += "index.html"
+  } else if strings.HasPrefix(mimetype, "text/html") && path.Ext(asset_path) == "" {
+    asset_path += "/index.html"
+  }
+
+  if u.Host != site_host {
+    asset_path = "/" + u.Host + asset_path
+  }
+  return asset_path
+}
+
+
+/*
+  crawlResource is a resource fetched while crawling.
+*/
+type crawlResource struct {
+  Url       *url.URL
+  Mimetype  string
+  Content   []byte
+}
+
+
+/*
+  crawlRequest is a URL queued to be crawled. Depth counts the
+  links followed from the source to reach it.
+*/
+type crawlRequest struct {
+  Url         *url.URL
+  Depth       int
+  Navigation  bool
+}
+
+
+/*
+  Crawler fetches a website, following links and references to
+  the hosts it is limited to.
+*/
+type Crawler struct {
+  Hosts     []string
+  MaxDepth  int
+  Limit     int
+
+  resources  []*crawlResource
+  fetched    map[string]*crawlResource
+  queued     map[string]bool
+}
+
+
+func (c *Crawler) allowed (u *url.URL) bool {
+  if u.Scheme != "http" && u.Scheme != "https" {
+    return false
+  }
+  for _, host := range c.Hosts {
+    if strings.EqualFold(u.Host, host) {
+      return true
+    }
+  }
+  return false
+}
+
+
+/*
+  fetch gets a URL, returning the resource, or nil if it could not
+  be fetched. Redirects are followed within the crawled hosts.
+*/
+func (c *Crawler) fetch (u *url.URL) (*crawlResource, error) {
+  response, err := CrawlClient.Get(u.String())
+  if err != nil { return nil, err }
+  defer response.Body.Close()
+
+  if response.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("%s", response.Status)
+  }
+  if !c.allowed(response.Request.URL) {
+    return nil, fmt.Errorf("Redirected to %s", response.Request.URL)
+  }
+
+  // Bodies which the client did not decode, such as those
+  // compressed with Brotli, are decoded so that their references
+  // can be parsed
+  //
+  body, err := DecodeContentReader(response.Header.Get("Content-Encoding"), response.Body)
+  if err != nil { return nil, err }
+
+  content, err := io.ReadAll(body)
+  if err != nil { return nil, err }
+
+  mimetype, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+  if err != nil || mimetype == "" {
+    mimetype, _, _ = mime.ParseMediaType(mime.TypeByExtension(path.Ext(response.Request.URL.Path)))
+  }
+  if mimetype == "" {
+    mimetype, _, _ = mime.ParseMediaType(http.DetectContentType(content))
+  }
+
+  return &crawlResource { Url: response.Request.URL, Mimetype: mimetype, Content: content }, nil
+}
+
+
+/*
+  references returns the URLs a resource refers to, resolved
+  against its own URL, and whether each is navigated to.
+*/
+func (r *crawlResource) references () (map[string]bool, error) {
+  var refs = make(map[string]bool)
+
+  var add = func (ref string, navigation bool) (string, bool) {
+    if ref_url, err := r.Url.Parse(ref); err == nil {
+      refs[ref_url.String()] = refs[ref_url.String()] || navigation
+    }
+    return ref, false
+  }
+
+  switch {
+  case strings.HasPrefix(r.Mimetype, "text/html"):
+    doc, err := html.Parse(bytes.NewReader(r.Content))
+    if err != nil { return nil, err }
+    htmlNodeMapUrls(doc, add)
+
+  case strings.HasPrefix(r.Mimetype, "text/css"):
+    if _, err := CssReaderMapUrls(bytes.NewReader(r.Content), io.Discard, func (ref string) (string, bool) {
+      return add(ref, false)
+    }); err != nil {
+      return nil, err
+    }
+  }
+
+  return refs, nil
+}
+
+
+/*
+  Crawl fetches a URL, and the pages and resources it refers to,
+  breadth-first. Links to pages are followed up to MaxDepth links
+  from the source, unless it is negative, while the resources
+  pages load, such as images and stylesheets, are always fetched.
+  At most Limit resources are fetched. Resources which cannot be
+  fetched are skipped, except for the source itself.
+*/
+func (c *Crawler) Crawl (tk *Task, source *url.URL) error {
+  c.fetched = make(map[string]*crawlResource)
+  c.queued  = map[string]bool { crawlKey(source): true }
+
+  var queue = []crawlRequest { { Url: source, Navigation: true } }
+
+  for len(queue) > 0 {
+    var request = queue[0]
+    queue = queue[1:]
+
+    if len(c.resources) >= c.Limit {
+      tk.Println("Stopped crawling after", c.Limit, "resources")
+      break
+    }
+
+    resource, err := c.fetch(request.Url)
+    if err != nil {
+      if request.Depth == 0 {
+        return fmt.Errorf("Cannot crawl %s: %w", request.Url, err)
+      }
+      tk.Println("Skipping", request.Url.String() + ":", err)
+      continue
+    }
+
+    // Redirected resources are known by both URLs
+    //
+    if existing, found := c.fetched[crawlKey(resource.Url)]; found {
+      c.fetched[crawlKey(request.Url)] = existing
+      continue
+    }
+    c.fetched[crawlKey(request.Url)]  = resource
+    c.fetched[crawlKey(resource.Url)] = resource
+    c.queued[crawlKey(resource.Url)]  = true
+    c.resources = append(c.resources, resource)
+
+    refs, err := resource.references()
+    if err != nil {
+      tk.Println("Cannot read references in", resource.Url.String() + ":", err)
+      continue
+    }
+
+    for ref, navigation := range refs {
+      ref_url, err := url.Parse(ref)
+      if err != nil || !c.allowed(ref_url) || c.queued[crawlKey(ref_url)] {
+        continue
+      }
+
+      // Pages which are not navigated to, such as iframes, are
+      // fetched, but their links are not followed
+      //
+      var depth = request.Depth + 1
+      if navigation && (!request.Navigation || (c.MaxDepth >= 0 && depth > c.MaxDepth)) {
+        continue
+      }
+
+      c.queued[crawlKey(ref_url)] = true
+      queue = append(queue, crawlRequest { Url: ref_url, Depth: depth, Navigation: navigation })
+    }
+  }
+
+  return nil
+}
+
+
+/*
+  Assets returns the crawled resources as assets of a spec, at
+  the paths returned by CrawlAssetPath. References in HTML and CSS
+  to other crawled resources are rewritten to their paths.
+*/
+func (c *Crawler) Assets (s *Spec, site_host string) ([]*Asset, error) {
+  var assets = make([]*Asset, 0, len(c.resources))
+
+  // localUrl returns the root-relative URL of a crawled resource
+  //
+  var localUrl = func (r *crawlResource) string {
+    return strings.TrimSuffix(CrawlAssetPath(site_host, r.Url, r.Mimetype), "index.html")
+  }
+
+  for _, resource := range c.resources {
+    var rewrite = func (ref string, navigation bool) (string, bool) {
+      ref_url, err := resource.Url.Parse(ref)
+      if err != nil || strings.HasPrefix(ref, "#") {
+        return ref, false
+      }
+      target, found := c.fetched[crawlKey(ref_url)]
+      if !found {
+        return ref, false
+      }
+      var local_url = url.URL { Path: localUrl(target), Fragment: ref_url.Fragment }
+      return local_url.String(), local_url.String() != ref
+    }
+
+    var content = resource.Content
+
+    switch {
+    case strings.HasPrefix(resource.Mimetype, "text/html"):
+      doc, err := html.Parse(bytes.NewReader(content))
+      if err != nil { return nil, err }
+      if htmlNodeMapUrls(doc, rewrite) {
+        var buffer bytes.Buffer
+        if err := html.Render(&buffer, doc); err != nil {
+          return nil, err
+        }
+        content = buffer.Bytes()
+      }
+
+    case strings.HasPrefix(resource.Mimetype, "text/css"):
+      var buffer bytes.Buffer
+      modified, err := CssReaderMapUrls(bytes.NewReader(content), &buffer, func (ref string) (string, bool) {
+        return rewrite(ref, false)
+      })
+      if err != nil { return nil, err }
+      if modified {
+        content = buffer.Bytes()
+      }
+    }
+
+    var asset = s.MakeAsset(strings.TrimPrefix(CrawlAssetPath(site_host, resource.Url, resource.Mimetype), "/"))
+    asset.Mimetype = resource.Mimetype
+    asset.SetContentBytes(content)
+    assets = append(assets, asset)
+  }
+
+  return assets, nil
+}
+
+
+/*
+  TaskSourceCrawl crawls the website at the spec's "source" URL,
+  and emits its pages, and the resources they load, as assets. It
+  reads the following props:
+
+    - crawl_depth: The number of links to follow from the source.
+      Defaults to no limit.
+    - crawl_hosts: A host name, or list of them, other than the
+      source's, to fetch from, such as a CDN.
+    - crawl_limit: The most resources to fetch. Defaults to 1000.
+*/
+func TaskSourceCrawl (s *Spec, tk *Task) error {
+  source, err := s.RequirePropUrl("source")
+  if err != nil { return err }
+
+  var crawler = Crawler { Hosts: []string { source.Host }, MaxDepth: -1, Limit: 1000 }
+
+  if depth, ok, found := s.GetPropInt("crawl_depth"); found && (!ok || depth < 0) {
+    return fmt.Errorf("Prop \"crawl_depth\" in spec %s is expected to be a non-negative integer, got %v", s.Name, s.Props["crawl_depth"])
+  } else if found {
+    crawler.MaxDepth = depth
+  }
+
+  if limit, ok, found := s.GetPropInt("crawl_limit"); found && (!ok || limit <= 0) {
+    return fmt.Errorf("Prop \"crawl_limit\" in spec %s is expected to be a positive integer, got %v", s.Name, s.Props["crawl_limit"])
+  } else if found {
+    crawler.Limit = limit
+  }
+
+  switch hosts := s.Props["crawl_hosts"].(type) {
+  case nil:
+  case string:
+    crawler.Hosts = append(crawler.Hosts, hosts)
+  case []any:
+    for _, host_any := range hosts {
+      host, ok := host_any.(string)
+      if !ok {
+        return fmt.Errorf("Prop \"crawl_hosts\" in spec %s is expected to contain strings, got %T", s.Name, host_any)
+      }
+      crawler.Hosts = append(crawler.Hosts, host)
+    }
+  default:
+    return fmt.Errorf("Prop \"crawl_hosts\" in spec %s is expected to be a string or list of strings, got %T", s.Name, hosts)
+  }
+
+  tk.Println("Crawling", source.String())
+  if err := crawler.Crawl(tk, source); err != nil {
+    return err
+  }
+
+  assets, err := crawler.Assets(s, source.Host)
+  if err != nil { return err }
+
+  return tk.EmitAssets(assets)
+}
@@ -0,0 +1,145 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "github.com/andybalholm/brotli"
+
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "strings"
+  "testing"
+)
+
+
+func TestTaskSourceCrawl (t *testing.T) {
+  cdn := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/javascript")
+    w.Write([]byte("console.log(1)"))
+  }))
+  defer cdn.Close()
+
+  var pages = map[string]string {
+    "/": `<html><head>` +
+      `<link rel="stylesheet" href="/style.css">` +
+      `<script src="` + cdn.URL + `/lib.js"></script>` +
+      `</head><body>` +
+      `<a href="about">About</a> <a href="/old#team">Team</a> <a href="#top">Top</a>` +
+      `<a href="https://example.com/">Elsewhere</a>` +
+      `<img src="/img/a.png" srcset="/img/a.png 1x, /img/missing.png 2x">` +
+      `</body></html>`,
+    "/about": `<html><body><a href="/deep/">Deep</a><img src="http://HOST/img/a.png"></body></html>`,
+    "/deep/": `<html><body>Deep</body></html>`,
+  }
+
+  var server *httptest.Server
+  server = httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+    switch r.URL.Path {
+    case "/old":
+      http.Redirect(w, r, "/about", http.StatusMovedPermanently)
+    case "/style.css":
+      // A Brotli-encoded body, which the client does not decode
+      //
+      w.Header().Set("Content-Type", "text/css")
+      w.Header().Set("Content-Encoding", "br")
+      encoder := brotli.NewWriter(w)
+      encoder.Write([]byte(`body { background: url("fonts/../bg.png") }`))
+      encoder.Close()
+    case "/bg.png", "/img/a.png":
+      w.Header().Set("Content-Type", "image/png")
+      w.Write([]byte("PNG"))
+    default:
+      page, found := pages[r.URL.Path]
+      if !found {
+        http.NotFound(w, r)
+        return
+      }
+      w.Header().Set("Content-Type", "text/html; charset=utf-8")
+      w.Write([]byte(strings.ReplaceAll(page, "HOST", r.Host)))
+    }
+  }))
+  defer server.Close()
+
+  cdn_url, _ := url.Parse(cdn.URL)
+
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("site", nil))
+
+  root.Props["quiet"]           = true
+  subspec.Props["source"]       = server.URL + "/"
+  subspec.Props["source_crawl"] = true
+  subspec.Props["crawl_depth"]  = 1
+  subspec.Props["crawl_hosts"]  = []any { cdn_url.Host }
+
+  var received = make(map[string]string)
+  root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, input := range tk.Assets {
+      assets, err := input.Flatten()
+      if err != nil { return err }
+      for _, asset := range assets {
+        content, err := asset.GetContentBytes()
+        if err != nil { return err }
+        received[strings.TrimPrefix(asset.Url.Path, "@emit/")] = string(content)
+      }
+    }
+    return nil
+  })
+
+  root.AddSpecBuilder(BuildSourceURLType)
+  root.AddSpecBuilder(BuildTaskSourceGitClone)
+  root.AddSpecBuilder(BuildTaskSourceCrawl)
+  if err := subspec.Build(); err != nil {
+    t.Fatal(err)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var cdn_path = cdn_url.Host + "/lib.js"
+  for _, key := range []string { "index.html", "about/index.html", "style.css", "bg.png", "img/a.png", cdn_path } {
+    if _, found := received[key]; !found {
+      t.Errorf("Expected %s to be emitted, got %d assets", key, len(received))
+    }
+  }
+  if len(received) != 6 {
+    t.Errorf("Expected 6 assets, beyond the crawl depth, got %d", len(received))
+  }
+
+  var index = received["index.html"]
+  for _, expect := range []string {
+    `href="/about/"`, `href="/about/#team"`, `href="#top"`, `href="https://example.com/"`,
+    `src="/` + cdn_path + `"`, `srcset="/img/a.png 1x, /img/missing.png 2x"`,
+  } {
+    if !strings.Contains(index, expect) {
+      t.Errorf("Expected index.html to contain %s, got:\n%s", expect, index)
+    }
+  }
+
+  if about := received["about/index.html"]; !strings.Contains(about, `href="/deep/"`) || !strings.Contains(about, `src="/img/a.png"`) {
+    t.Errorf("Expected about/index.html to refer to the site root-relatively, got:\n%s", about)
+  }
+  if css := received["style.css"]; css != `body { background: url("/bg.png") }` {
+    t.Errorf("Expected style.css to be rewritten, got %s", css)
+  }
+}
+
+
+func TestCrawlAssetPath (t *testing.T) {
+  var test_cases = []struct { Url, Mimetype, Expect string } {
+    { "https://example.com",            "text/html", "/index.html" },
+    { "https://example.com/blog/",      "text/html", "/blog/index.html" },
+    { "https://example.com/about",      "text/html", "/about/index.html" },
+    { "https://example.com/page.html",  "text/html", "/page.html" },
+    { "https://example.com/data",       "application/json", "/data" },
+    { "https://cdn.example.com/lib.js", "text/javascript", "/cdn.example.com/lib.js" },
+  }
+
+  for _, test_case := range test_cases {
+    u, _ := url.Parse(test_case.Url)
+    if got := CrawlAssetPath("example.com", u, test_case.Mimetype); got != test_case.Expect {
+      t.Errorf("Expected %s to be emitted at %s, got %s", test_case.Url, test_case.Expect, got)
+    }
+  }
+}
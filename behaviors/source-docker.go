@@ -0,0 +1,146 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "fmt"
+  "os"
+  "path"
+  "path/filepath"
+  "strings"
+)
+
+
+/*
+  TaskResolverInferSourceDocker infers Docker builds from a
+  Dockerfile, building the image and extracting its files with
+  the "source-build-docker" task. This allows toolchains which
+  cannot run on the host to produce assets.
+*/
+var TaskResolverInferSourceDocker = TaskResolver {
+  Id:   "source-infer-docker",
+  Name: "source-infer",
+  Next: &TaskResolverInferSourceStatic,
+  MatchFunc: func (name string, sp *Spec) (bool, error) {
+    return sp.PathExists("Dockerfile")
+  },
+  TaskPrototype: Task {
+    Func: func (sp *Spec, tk *Task) error {
+      if _, e := tk.EnqueueTaskName("source-build-docker"); e != nil { return e }
+      if _, e := tk.EnqueueTaskName("assets-infer");        e != nil { return e }
+      return nil
+    },
+  },
+}
+
+
+var TaskResolverSourceBuildDocker = TaskResolver {
+  Id:   "source-build-docker",
+  Name: "source-build-docker",
+  TaskPrototype: Task { Func: TaskSourceBuildDocker },
+}
+
+
+func BuildTasksDocker (s *Spec) error {
+  if s.GetTaskResolverById("source-build-docker") == nil {
+    build := TaskResolverSourceBuildDocker
+    s.AddTaskResolver(&build)
+  }
+  return nil
+}
+
+
+/*
+  TaskSourceBuildDocker builds the Dockerfile in the spec's
+  source_dir, creates a container from the image without running
+  it, and copies a path out of the container, which is emitted as
+  assets. It reads the following props:
+
+    - docker_path:        The path inside the image to extract.
+      Required.
+    - docker:             The Docker executable. Defaults to
+      "docker". Inherited.
+    - docker_build_flags: Space-separated flags for
+      `docker build`, such as "--target site".
+    - docker_destination: The directory, relative to source_dir,
+      into which the path is extracted. It is emptied beforehand.
+      Defaults to ".interbuilder-docker".
+*/
+func TaskSourceBuildDocker (s *Spec, tk *Task) error {
+  var docker      = "docker"
+  var destination = ".interbuilder-docker"
+  var build_flags []string
+
+  image_path, err := s.RequirePropString("docker_path")
+  if err != nil { return err }
+
+  if prop_docker, ok, found := s.InheritPropString("docker"); found && !ok {
+    return fmt.Errorf("Prop \"docker\" in spec %s is expected to be a string, got %T", s.Name, s.Props["docker"])
+  } else if found && prop_docker != "" {
+    docker = prop_docker
+  }
+
+  if prop_flags, ok, found := s.GetPropString("docker_build_flags"); found && !ok {
+    return fmt.Errorf("Prop \"docker_build_flags\" in spec %s is expected to be a string, got %T", s.Name, s.Props["docker_build_flags"])
+  } else if found {
+    build_flags = strings.Fields(prop_flags)
+  }
+
+  if prop_destination, ok, found := s.GetPropString("docker_destination"); found && !ok {
+    return fmt.Errorf("Prop \"docker_destination\" in spec %s is expected to be a string, got %T", s.Name, s.Props["docker_destination"])
+  } else if found && prop_destination != "" {
+    destination = path.Clean(prop_destination)
+  }
+
+  destination_path, err := s.GetKeyPath(destination)
+  if err != nil { return err }
+
+  if err := os.RemoveAll(destination_path); err != nil {
+    return fmt.Errorf("Cannot clear Docker destination %s: %w", destination_path, err)
+  }
+
+  // Build the image, recording its ID
+  //
+  iid_file, err := os.CreateTemp("", "interbuilder-docker-iid-")
+  if err != nil { return err }
+  iid_file.Close()
+  defer os.Remove(iid_file.Name())
+
+  build_args := append([]string { "build", "--iidfile", iid_file.Name() }, build_flags...)
+  build_args  = append(build_args, ".")
+
+  if _, err := tk.CommandRun(docker, build_args...); err != nil {
+    return err
+  }
+
+  image_id, err := os.ReadFile(iid_file.Name())
+  if err != nil { return err }
+
+  // Create a container to copy from, which is never started, and
+  // remove it afterwards
+  //
+  container_id, err := tk.Command(docker, "create", strings.TrimSpace(string(image_id))).Output()
+  if err != nil {
+    return fmt.Errorf("Cannot create container from image in spec %s: %w", s.Name, err)
+  }
+
+  container := strings.TrimSpace(string(container_id))
+  defer tk.Command(docker, "rm", container).Run()
+
+  if _, err := tk.CommandRun(docker, "cp", container + ":" + image_path, destination); err != nil {
+    return err
+  }
+
+  // A single file is emitted with its own name
+  //
+  var key = "/"
+  if stat, err := os.Stat(destination_path); err != nil {
+    return err
+  } else if !stat.IsDir() {
+    key = path.Base(filepath.ToSlash(image_path))
+  }
+
+  extracted_asset, err := s.MakeFileKeyAsset(destination, key)
+  if err != nil { return err }
+
+  return tk.EmitAsset(extracted_asset)
+}
@@ -0,0 +1,102 @@
+package behaviors
+
+import (
+  "testing"
+  . "gilchrist.tech/interbuilder"
+
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+
+func TestTaskInferSourceDocker (t *testing.T) {
+  // A stand-in for Docker, which records its invocations, and
+  // copies a directory with one file out of the "container"
+  //
+  var bin_dir     = t.TempDir()
+  var fake_docker = filepath.Join(bin_dir, "docker")
+  var log_path    = filepath.Join(bin_dir, "log")
+
+  var fake_docker_src = strings.Join([]string {
+    `#!/bin/sh`,
+    `echo "$*" >> ` + ShellQuote(log_path),
+    `case "$1" in`,
+    `  build)  printf 'sha256:abc' > "$3" ;;`,
+    `  create) echo container-1 ;;`,
+    `  cp)     mkdir -p "$3" && printf 'from %s' "$2" > "$3/index.html" ;;`,
+    `esac`,
+  }, "\n")
+
+  if err := os.WriteFile(fake_docker, []byte(fake_docker_src), 0o755); err != nil {
+    t.Fatal(err)
+  }
+
+  var root        *Spec = NewSpec("root", nil)
+  var docker_spec *Spec = root.AddSubspec(NewSpec("docker_spec", nil))
+
+  root.AddSpecBuilder(BuildTaskInferSource)
+  root.AddSpecBuilder(BuildTasksDocker)
+
+  root.Props["docker"]                    = fake_docker
+  docker_spec.Props["source_dir"]         = t.TempDir()
+  docker_spec.Props["docker_path"]        = "/app/dist"
+  docker_spec.Props["docker_build_flags"] = "--target site"
+
+  if err := docker_spec.WriteFile("Dockerfile", []byte("FROM scratch\n"), 0o660); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := root.Build(); err != nil {
+    t.Fatal("Could not build root spec:", err)
+  }
+
+  if _, err := docker_spec.EnqueueTaskName("source-infer"); err != nil {
+    t.Fatal(err)
+  }
+
+  var received = make(map[string]string)
+
+  root.EnqueueTaskFunc("consume-site", func (s *Spec, tk *Task) error {
+    for asset_chunk := range s.Input {
+      assets, err := asset_chunk.Flatten()
+      if err != nil { return err }
+
+      for _, asset := range assets {
+        content, err := asset.GetContentBytes()
+        if err != nil { return err }
+        received[strings.TrimLeft(asset.Url.Path, "/")] = string(content)
+      }
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if got, expect := received["@emit/index.html"], "from container-1:/app/dist"; got != expect {
+    t.Errorf("Expected extracted index.html with content %q, got %q in %v", expect, got, received)
+  }
+
+  log, err := os.ReadFile(log_path)
+  if err != nil { t.Fatal(err) }
+
+  var commands []string
+  for _, line := range strings.Split(strings.TrimSpace(string(log)), "\n") {
+    fields := strings.Fields(line)
+    if fields[0] == "build" {
+      fields[2] = "<iidfile>"
+    }
+    commands = append(commands, strings.Join(fields, " "))
+  }
+
+  var expect = []string {
+    "build --iidfile <iidfile> --target site .",
+    "create sha256:abc",
+    "cp container-1:/app/dist .interbuilder-docker",
+    "rm container-1",
+  }
+
+  if got := strings.Join(commands, "\n"); got != strings.Join(expect, "\n") {
+    t.Errorf("Expected Docker commands:\n%s\ngot:\n%s", strings.Join(expect, "\n"), got)
+  }
+}
@@ -0,0 +1,128 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "fmt"
+  "path"
+  "path/filepath"
+  "strings"
+)
+
+
+/*
+  TaskResolverInferSourceGo infers Go modules from a go.mod file,
+  building them with the "source-build-go" task.
+*/
+var TaskResolverInferSourceGo = TaskResolver {
+  Id:   "source-infer-go",
+  Name: "source-infer",
+  Next: &TaskResolverInferSourcePython,
+  MatchFunc: func (name string, sp *Spec) (bool, error) {
+    return sp.PathExists("go.mod")
+  },
+  TaskPrototype: Task {
+    Func: func (sp *Spec, tk *Task) error {
+      if _, e := tk.EnqueueTaskName("source-build-go"); e != nil { return e }
+      if _, e := tk.EnqueueTaskName("assets-infer");    e != nil { return e }
+      return nil
+    },
+  },
+}
+
+
+var TaskResolverSourceBuildGo = TaskResolver {
+  Id:   "source-build-go",
+  Name: "source-build-go",
+  TaskPrototype: Task { Func: TaskSourceBuildGo },
+}
+
+
+func BuildTasksGo (s *Spec) error {
+  if s.GetTaskResolverById("source-build-go") == nil {
+    build := TaskResolverSourceBuildGo
+    s.AddTaskResolver(&build)
+  }
+  return nil
+}
+
+
+/*
+  TaskSourceBuildGo builds a Go module in the spec's source_dir,
+  and emits the output directory as assets. It reads the
+  following props:
+
+    - go_generate:    If true, run `go generate` on the packages
+      before building.
+    - go_build:       If false, do not run `go build`, such as
+      when `go generate` produces the output directory. Defaults
+      to true.
+    - go_packages:    Space-separated packages to build. Defaults
+      to "./...".
+    - go_build_flags: Space-separated flags for `go build`, such
+      as "-trimpath".
+    - go_output:      The directory, relative to source_dir, to
+      which binaries are written and from which assets are
+      emitted. Defaults to "bin".
+*/
+func TaskSourceBuildGo (sp *Spec, tk *Task) error {
+  var packages    = []string { "./..." }
+  var build_flags []string
+  var output_dir  = "bin"
+
+  if prop_packages, ok, found := sp.GetPropString("go_packages"); found && !ok {
+    return fmt.Errorf("Prop \"go_packages\" in spec %s is expected to be a string, got %T", sp.Name, sp.Props["go_packages"])
+  } else if found {
+    packages = strings.Fields(prop_packages)
+  }
+
+  if prop_flags, ok, found := sp.GetPropString("go_build_flags"); found && !ok {
+    return fmt.Errorf("Prop \"go_build_flags\" in spec %s is expected to be a string, got %T", sp.Name, sp.Props["go_build_flags"])
+  } else if found {
+    build_flags = strings.Fields(prop_flags)
+  }
+
+  if prop_output, ok, found := sp.GetPropString("go_output"); found && !ok {
+    return fmt.Errorf("Prop \"go_output\" in spec %s is expected to be a string, got %T", sp.Name, sp.Props["go_output"])
+  } else if found && prop_output != "" {
+    output_dir = path.Clean(prop_output)
+  }
+
+  if go_generate, _, _ := sp.GetPropBool("go_generate"); go_generate {
+    args := append([]string { "generate" }, packages...)
+    if _, err := tk.CommandRun("go", args...); err != nil {
+      return err
+    }
+  }
+
+  go_build, ok, found := sp.GetPropBool("go_build")
+  if found && !ok {
+    return fmt.Errorf("Prop \"go_build\" in spec %s is expected to be a boolean, got %T", sp.Name, sp.Props["go_build"])
+  }
+
+  if !found || go_build {
+    // A trailing separator tells `go build` to write each main
+    // package's binary into the directory
+    //
+    output_path, err := sp.GetKeyPath(output_dir)
+    if err != nil { return err }
+
+    args := []string { "build", "-o", output_path + string(filepath.Separator) }
+    args  = append(args, build_flags...)
+    args  = append(args, packages...)
+
+    if _, err := tk.CommandRun("go", args...); err != nil {
+      return err
+    }
+  }
+
+  if exists, err := sp.PathExists(output_dir); err != nil {
+    return err
+  } else if !exists {
+    return fmt.Errorf("Go output directory %s does not exist in spec %s", output_dir, sp.Name)
+  }
+
+  output_asset, err := sp.MakeFileKeyAsset(output_dir, "/")
+  if err != nil { return err }
+
+  return tk.EmitAsset(output_asset)
+}
@@ -0,0 +1,69 @@
+package behaviors
+
+import (
+  "testing"
+  . "gilchrist.tech/interbuilder"
+
+  "os/exec"
+  "strings"
+)
+
+
+func TestTaskInferSourceGo (t *testing.T) {
+  if _, err := exec.LookPath("go"); err != nil {
+    t.Skip("go is not installed")
+  }
+
+  var root    *Spec = NewSpec("root", nil)
+  var go_spec *Spec = root.AddSubspec(NewSpec("go_spec", nil))
+
+  root.AddSpecBuilder(BuildTaskInferSource)
+  root.AddSpecBuilder(BuildTasksNodeJS)
+  root.AddSpecBuilder(BuildTasksGo)
+
+  go_spec.Props["source_dir"]    = t.TempDir()
+  go_spec.Props["go_generate"]   = true
+  go_spec.Props["go_packages"]   = "./cmd/hello"
+  go_spec.Props["go_output"]     = "out"
+
+  var files = map[string]string {
+    "go.mod":           "module example.com/hello\n\ngo 1.22\n",
+    "cmd/hello/main.go": "package main\n\n//go:generate sh -c \"mkdir -p ../../out && echo generated > ../../out/notes.txt\"\n\nfunc main () {}\n",
+  }
+
+  for key, content := range files {
+    if err := go_spec.WriteFile(key, []byte(content), 0o660); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if err := root.Build(); err != nil {
+    t.Fatal("Could not build root spec:", err)
+  }
+
+  if _, err := go_spec.EnqueueTaskName("source-infer"); err != nil {
+    t.Fatal(err)
+  }
+
+  var received = make(map[string]bool)
+
+  root.EnqueueTaskFunc("consume-out", func (s *Spec, tk *Task) error {
+    for asset_chunk := range s.Input {
+      assets, err := asset_chunk.Flatten()
+      if err != nil { return err }
+
+      for _, asset := range assets {
+        received[strings.TrimLeft(asset.Url.Path, "/")] = true
+      }
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  for _, expect := range []string { "@emit/hello", "@emit/notes.txt" } {
+    if !received[expect] {
+      t.Errorf("Expected an asset at %s, got %v", expect, received)
+    }
+  }
+}
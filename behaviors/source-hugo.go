@@ -0,0 +1,118 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "fmt"
+  "os"
+  "path"
+  "strings"
+)
+
+
+/*
+  TaskResolverInferSourceHugo infers Hugo sites from a hugo.toml
+  or config.toml file, rendering them with the
+  "source-build-hugo" task.
+*/
+var TaskResolverInferSourceHugo = TaskResolver {
+  Id:   "source-infer-hugo",
+  Name: "source-infer",
+  Next: &TaskResolverInferSourceJekyll,
+  MatchFunc: func (name string, sp *Spec) (bool, error) {
+    for _, file := range []string { "hugo.toml", "hugo.yaml", "hugo.json", "config.toml" } {
+      if exists, err := sp.PathExists(file); exists || err != nil {
+        return exists, err
+      }
+    }
+    return false, nil
+  },
+  TaskPrototype: Task {
+    Func: func (sp *Spec, tk *Task) error {
+      if _, e := tk.EnqueueTaskName("source-build-hugo"); e != nil { return e }
+      if _, e := tk.EnqueueTaskName("assets-infer");      e != nil { return e }
+      return nil
+    },
+  },
+}
+
+
+var TaskResolverSourceBuildHugo = TaskResolver {
+  Id:   "source-build-hugo",
+  Name: "source-build-hugo",
+  TaskPrototype: Task { Func: TaskSourceBuildHugo },
+}
+
+
+func BuildTasksHugo (s *Spec) error {
+  if s.GetTaskResolverById("source-build-hugo") == nil {
+    build := TaskResolverSourceBuildHugo
+    s.AddTaskResolver(&build)
+  }
+  return nil
+}
+
+
+/*
+  TaskSourceBuildHugo renders a Hugo site into a destination
+  directory, which is emptied beforehand so that no stale pages
+  are emitted, and emits it as assets. It reads the following
+  props:
+
+    - base_url:         Passed to Hugo as --baseURL, so that
+      links rendered by Hugo agree with where path
+      transformations place the site. Inherited.
+    - hugo:             The Hugo executable. Defaults to "hugo".
+    - hugo_flags:       Space-separated flags for Hugo, such as
+      "--minify".
+    - hugo_destination: The destination directory, relative to
+      source_dir. Defaults to ".interbuilder-hugo".
+*/
+func TaskSourceBuildHugo (s *Spec, tk *Task) error {
+  var hugo        = "hugo"
+  var destination = ".interbuilder-hugo"
+  var flags       []string
+
+  if prop_hugo, ok, found := s.GetPropString("hugo"); found && !ok {
+    return fmt.Errorf("Prop \"hugo\" in spec %s is expected to be a string, got %T", s.Name, s.Props["hugo"])
+  } else if found && prop_hugo != "" {
+    hugo = prop_hugo
+  }
+
+  if prop_flags, ok, found := s.GetPropString("hugo_flags"); found && !ok {
+    return fmt.Errorf("Prop \"hugo_flags\" in spec %s is expected to be a string, got %T", s.Name, s.Props["hugo_flags"])
+  } else if found {
+    flags = strings.Fields(prop_flags)
+  }
+
+  if prop_destination, ok, found := s.GetPropString("hugo_destination"); found && !ok {
+    return fmt.Errorf("Prop \"hugo_destination\" in spec %s is expected to be a string, got %T", s.Name, s.Props["hugo_destination"])
+  } else if found && prop_destination != "" {
+    destination = path.Clean(prop_destination)
+  }
+
+  destination_path, err := s.GetKeyPath(destination)
+  if err != nil { return err }
+
+  if err := os.RemoveAll(destination_path); err != nil {
+    return fmt.Errorf("Cannot clear Hugo destination %s: %w", destination_path, err)
+  }
+
+  var args = []string { "--destination", destination }
+
+  if base_url, ok, found := s.InheritPropString("base_url"); found && !ok {
+    return fmt.Errorf("Prop \"base_url\" in spec %s is expected to be a string, got %T", s.Name, s.Props["base_url"])
+  } else if found && base_url != "" {
+    args = append(args, "--baseURL", base_url)
+  }
+
+  args = append(args, flags...)
+
+  if _, err := tk.CommandRun(hugo, args...); err != nil {
+    return err
+  }
+
+  site_asset, err := s.MakeFileKeyAsset(destination, "/")
+  if err != nil { return err }
+
+  return tk.EmitAsset(site_asset)
+}
@@ -0,0 +1,91 @@
+package behaviors
+
+import (
+  "testing"
+  . "gilchrist.tech/interbuilder"
+
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+
+func TestTaskInferSourceHugo (t *testing.T) {
+  // A stand-in for Hugo, which writes the arguments it receives
+  // into the destination directory
+  //
+  var bin_dir   = t.TempDir()
+  var fake_hugo = filepath.Join(bin_dir, "hugo")
+
+  var fake_hugo_src = strings.Join([]string {
+    `#!/bin/sh`,
+    `dest=""; base=""`,
+    `while [ $# -gt 0 ]; do`,
+    `  case "$1" in`,
+    `    --destination) dest="$2"; shift ;;`,
+    `    --baseURL)     base="$2"; shift ;;`,
+    `  esac`,
+    `  shift`,
+    `done`,
+    `mkdir -p "$dest"`,
+    `printf '%s' "$base" > "$dest/index.html"`,
+  }, "\n")
+
+  if err := os.WriteFile(fake_hugo, []byte(fake_hugo_src), 0o755); err != nil {
+    t.Fatal(err)
+  }
+
+  var root      *Spec = NewSpec("root", nil)
+  var hugo_spec *Spec = root.AddSubspec(NewSpec("hugo_spec", nil))
+
+  root.AddSpecBuilder(BuildTaskInferSource)
+  root.AddSpecBuilder(BuildTasksHugo)
+
+  root.Props["base_url"]        = "https://example.com/docs/"
+  hugo_spec.Props["source_dir"] = t.TempDir()
+  hugo_spec.Props["hugo"]       = fake_hugo
+
+  if err := hugo_spec.WriteFile("hugo.toml", []byte("title = 'Test'\n"), 0o660); err != nil {
+    t.Fatal(err)
+  }
+
+  // Stale output from an earlier build is removed
+  //
+  if err := hugo_spec.WriteFile(".interbuilder-hugo/stale.html", []byte("stale"), 0o660); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := root.Build(); err != nil {
+    t.Fatal("Could not build root spec:", err)
+  }
+
+  if _, err := hugo_spec.EnqueueTaskName("source-infer"); err != nil {
+    t.Fatal(err)
+  }
+
+  var received = make(map[string]string)
+
+  root.EnqueueTaskFunc("consume-site", func (s *Spec, tk *Task) error {
+    for asset_chunk := range s.Input {
+      assets, err := asset_chunk.Flatten()
+      if err != nil { return err }
+
+      for _, asset := range assets {
+        content, err := asset.GetContentBytes()
+        if err != nil { return err }
+        received[strings.TrimLeft(asset.Url.Path, "/")] = string(content)
+      }
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if len(received) != 1 {
+    t.Errorf("Expected only index.html to be emitted, got %v", received)
+  }
+
+  if got, expect := received["@emit/index.html"], "https://example.com/docs/"; got != expect {
+    t.Errorf("Expected Hugo to receive base URL %q, got %q", expect, got)
+  }
+}
@@ -0,0 +1,195 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "fmt"
+  "os"
+  "path"
+  "path/filepath"
+  "strings"
+)
+
+
+/*
+  TaskResolverInferSourceJekyll infers Jekyll sites from a
+  _config.yml file, installing their gems and building them.
+*/
+var TaskResolverInferSourceJekyll = TaskResolver {
+  Id:   "source-infer-jekyll",
+  Name: "source-infer",
+  Next: &TaskResolverInferSourceDocker,
+  MatchFunc: func (name string, sp *Spec) (bool, error) {
+    return sp.PathExists("_config.yml")
+  },
+  TaskPrototype: Task {
+    Func: func (sp *Spec, tk *Task) error {
+      if _, e := tk.EnqueueTaskName("source-install-jekyll"); e != nil { return e }
+      if _, e := tk.EnqueueTaskName("source-build-jekyll");   e != nil { return e }
+      if _, e := tk.EnqueueTaskName("assets-infer");          e != nil { return e }
+      return nil
+    },
+  },
+}
+
+
+var TaskResolverSourceInstallJekyll = TaskResolver {
+  Id:   "source-install-jekyll",
+  Name: "source-install-jekyll",
+  TaskPrototype: Task { Func: TaskSourceInstallJekyll },
+}
+
+
+var TaskResolverSourceBuildJekyll = TaskResolver {
+  Id:   "source-build-jekyll",
+  Name: "source-build-jekyll",
+  TaskPrototype: Task { Func: TaskSourceBuildJekyll },
+}
+
+
+func BuildTasksJekyll (s *Spec) error {
+  if s.GetTaskResolverById("source-install-jekyll") == nil {
+    install := TaskResolverSourceInstallJekyll
+    s.AddTaskResolver(&install)
+  }
+
+  if s.GetTaskResolverById("source-build-jekyll") == nil {
+    build := TaskResolverSourceBuildJekyll
+    s.AddTaskResolver(&build)
+  }
+
+  return nil
+}
+
+
+/*
+  rubyCommand returns the executable for a Ruby program, from a
+  prop naming it, or the program's default name. If the inherited
+  "ruby_bin" prop is set, it is prepended to the Task's PATH, and
+  program names are resolved within it.
+*/
+func rubyCommand (s *Spec, tk *Task, prop, program string) (string, error) {
+  if prop_program, ok, found := s.InheritPropString(prop); found && !ok {
+    return "", fmt.Errorf("Prop \"%s\" in spec %s is expected to be a string, got %T", prop, s.Name, s.Props[prop])
+  } else if found && prop_program != "" {
+    program = prop_program
+  }
+
+  ruby_bin, ok, found := s.InheritPropString("ruby_bin")
+  if found && !ok {
+    return "", fmt.Errorf("Prop \"ruby_bin\" in spec %s is expected to be a string, got %T", s.Name, s.Props["ruby_bin"])
+  } else if !found || ruby_bin == "" {
+    return program, nil
+  }
+
+  ruby_bin, err := filepath.Abs(ruby_bin)
+  if err != nil { return "", err }
+
+  environ, err := tk.Environ()
+  if err != nil { return "", err }
+
+  var env_path string
+  for _, entry := range environ {
+    if value, found := strings.CutPrefix(entry, "PATH="); found {
+      env_path = value
+    }
+  }
+
+  if tk.Env == nil {
+    tk.Env = make(map[string]string)
+  }
+  if !strings.HasPrefix(env_path, ruby_bin + string(os.PathListSeparator)) {
+    tk.Env["PATH"] = ruby_bin + string(os.PathListSeparator) + env_path
+  }
+
+  if !strings.ContainsRune(program, '/') {
+    if _, err := os.Stat(filepath.Join(ruby_bin, program)); err == nil {
+      program = filepath.Join(ruby_bin, program)
+    }
+  }
+
+  return program, nil
+}
+
+
+/*
+  TaskSourceInstallJekyll runs `bundle install` for sites with a
+  Gemfile. The bundler executable is read from the "bundle" prop.
+*/
+func TaskSourceInstallJekyll (s *Spec, tk *Task) error {
+  if gemfile_exists, err := s.PathExists("Gemfile"); !gemfile_exists || err != nil {
+    return err
+  }
+
+  unlock, err := lockSourceDir(s)
+  if err != nil { return err }
+  defer unlock()
+
+  bundle, err := rubyCommand(s, tk, "bundle", "bundle")
+  if err != nil { return err }
+
+  _, err = tk.CommandRun(bundle, "install")
+  return err
+}
+
+
+/*
+  TaskSourceBuildJekyll builds a Jekyll site, and emits it as
+  assets. Sites with a Gemfile are built with `bundle exec`. It
+  reads the following props:
+
+    - ruby_bin:           A directory of Ruby executables, such as
+      from a version manager, prepended to PATH. Inherited.
+    - bundle:             The bundler executable. Defaults to
+      "bundle".
+    - jekyll:             The Jekyll executable. Defaults to
+      "jekyll".
+    - jekyll_flags:       Space-separated flags for `jekyll build`,
+      such as "--future".
+    - jekyll_destination: The destination directory, relative to
+      source_dir. Defaults to "_site".
+*/
+func TaskSourceBuildJekyll (s *Spec, tk *Task) error {
+  var destination = "_site"
+  var flags       []string
+
+  if prop_flags, ok, found := s.GetPropString("jekyll_flags"); found && !ok {
+    return fmt.Errorf("Prop \"jekyll_flags\" in spec %s is expected to be a string, got %T", s.Name, s.Props["jekyll_flags"])
+  } else if found {
+    flags = strings.Fields(prop_flags)
+  }
+
+  if prop_destination, ok, found := s.GetPropString("jekyll_destination"); found && !ok {
+    return fmt.Errorf("Prop \"jekyll_destination\" in spec %s is expected to be a string, got %T", s.Name, s.Props["jekyll_destination"])
+  } else if found && prop_destination != "" {
+    destination = path.Clean(prop_destination)
+  }
+
+  jekyll, err := rubyCommand(s, tk, "jekyll", "jekyll")
+  if err != nil { return err }
+
+  var args = []string { jekyll, "build", "--destination", destination }
+  args = append(args, flags...)
+
+  if gemfile_exists, err := s.PathExists("Gemfile"); err != nil {
+    return err
+  } else if gemfile_exists {
+    bundle, err := rubyCommand(s, tk, "bundle", "bundle")
+    if err != nil { return err }
+
+    // Bundler finds jekyll itself, from the bundle
+    //
+    if _, found := s.InheritProp("jekyll"); !found {
+      args[0] = "jekyll"
+    }
+    args = append([]string { bundle, "exec" }, args...)
+  }
+
+  if _, err := tk.CommandRun(args[0], args[1:]...); err != nil {
+    return err
+  }
+
+  site_asset, err := s.MakeFileKeyAsset(destination, "/")
+  if err != nil { return err }
+
+  return tk.EmitAsset(site_asset)
+}
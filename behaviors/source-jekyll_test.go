@@ -0,0 +1,80 @@
+package behaviors
+
+import (
+  "testing"
+  . "gilchrist.tech/interbuilder"
+
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+
+func TestTaskInferSourceJekyll (t *testing.T) {
+  // A stand-in for bundler in a ruby_bin directory, which records
+  // installation and writes its arguments into the destination
+  //
+  var ruby_bin = t.TempDir()
+
+  var fake_bundle_src = strings.Join([]string {
+    `#!/bin/sh`,
+    `if [ "$1" = install ]; then touch installed; exit 0; fi`,
+    `dest="$5"`,
+    `mkdir -p "$dest"`,
+    `printf '%s' "$*" > "$dest/index.html"`,
+  }, "\n")
+
+  if err := os.WriteFile(filepath.Join(ruby_bin, "bundle"), []byte(fake_bundle_src), 0o755); err != nil {
+    t.Fatal(err)
+  }
+
+  var root        *Spec = NewSpec("root", nil)
+  var jekyll_spec *Spec = root.AddSubspec(NewSpec("jekyll_spec", nil))
+
+  root.AddSpecBuilder(BuildTaskInferSource)
+  root.AddSpecBuilder(BuildTasksJekyll)
+
+  root.Props["ruby_bin"]            = ruby_bin
+  jekyll_spec.Props["source_dir"]   = t.TempDir()
+  jekyll_spec.Props["jekyll_flags"] = "--future"
+
+  for _, key := range []string { "_config.yml", "Gemfile" } {
+    if err := jekyll_spec.WriteFile(key, []byte{}, 0o660); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if err := root.Build(); err != nil {
+    t.Fatal("Could not build root spec:", err)
+  }
+
+  if _, err := jekyll_spec.EnqueueTaskName("source-infer"); err != nil {
+    t.Fatal(err)
+  }
+
+  var received = make(map[string]string)
+
+  root.EnqueueTaskFunc("consume-site", func (s *Spec, tk *Task) error {
+    for asset_chunk := range s.Input {
+      assets, err := asset_chunk.Flatten()
+      if err != nil { return err }
+
+      for _, asset := range assets {
+        content, err := asset.GetContentBytes()
+        if err != nil { return err }
+        received[strings.TrimLeft(asset.Url.Path, "/")] = string(content)
+      }
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if exists, _ := jekyll_spec.PathExists("installed"); !exists {
+    t.Errorf("Expected bundle install to run")
+  }
+
+  if got, expect := received["@emit/index.html"], "exec jekyll build --destination _site --future"; got != expect {
+    t.Errorf("Expected bundler to receive %q, got %q", expect, got)
+  }
+}
@@ -0,0 +1,243 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "fmt"
+  "io/fs"
+  "net/url"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+
+/*
+  TaskResolverSourceLocal resolves the "source-local" task, which
+  prepares the spec's source_dir from a local directory source.
+*/
+var TaskResolverSourceLocal = TaskResolver {
+  Id:         "source-local",
+  Name:       "source-local",
+  AcceptMask: TASK_MASK_DEFINED,
+  TaskPrototype: Task {
+    Mask: TASK_MASK_DEFINED,
+    Func: TaskSourceLocal,
+  },
+}
+
+
+/*
+  localSourcePath returns the absolute path of a "source" URL
+  which is a local directory, such as "file:///srv/site" or
+  "../site", or an empty string if it is not one. Paths ending in
+  ".git" are left to be cloned. Relative paths are resolved
+  against the inherited "spec_dir" prop, the directory of the
+  build specification file, or else the working directory.
+*/
+func localSourcePath (s *Spec, source *url.URL) (string, error) {
+  if (source.Scheme != "" && source.Scheme != "file") || source.Host != "" || source.Path == "" {
+    return "", nil
+  }
+  if strings.HasSuffix(source.Path, ".git") {
+    return "", nil
+  }
+
+  var local_path = FileUrlPath(source.Path)
+  if !filepath.IsAbs(local_path) {
+    spec_dir, ok, found := s.InheritPropString("spec_dir")
+    if found && !ok {
+      spec_dir, _ := s.InheritProp("spec_dir")
+      return "", fmt.Errorf("Prop \"spec_dir\" in spec %s is expected to be a string, got %T", s.Name, spec_dir)
+    }
+    if spec_dir != "" {
+      local_path = filepath.Join(spec_dir, local_path)
+    }
+  }
+  return filepath.Abs(local_path)
+}
+
+
+/*
+  sourceMode returns the spec's "source_mode" prop, which defaults
+  to "in-place".
+*/
+func sourceMode (s *Spec) (string, error) {
+  mode, ok, found := s.GetPropString("source_mode")
+  if found && !ok {
+    return "", fmt.Errorf("Prop \"source_mode\" in spec %s is expected to be a string, got %T", s.Name, s.Props["source_mode"])
+  }
+
+  switch mode {
+  case "":
+    return "in-place", nil
+  case "in-place", "copy", "symlink":
+    return mode, nil
+  }
+  return "", fmt.Errorf("Prop \"source_mode\" in spec %s is expected to be in-place, copy, or symlink, got %s", s.Name, mode)
+}
+
+
+/*
+  BuildSourceLocal sets up specs whose "source" is a local
+  directory. In the "in-place" mode, the directory becomes the
+  source_dir. In the "copy" mode, the source_dir defaults to a
+  temporary directory, removed after the run. Then the
+  "source-local" task is enqueued, followed by source inference.
+  It must run before BuildSourceDir, which sets the default
+  source_dir.
+*/
+func BuildSourceLocal (s *Spec) error {
+  if s.GetTaskResolverById("source-local") == nil {
+    local := TaskResolverSourceLocal
+    s.AddTaskResolver(&local)
+  }
+
+  source, ok, _ := s.GetPropUrl("source")
+  if !ok {
+    return nil
+  }
+
+  local_path, err := localSourcePath(s, source)
+  if err != nil || local_path == "" {
+    return err
+  }
+
+  mode, err := sourceMode(s)
+  if err != nil { return err }
+
+  source_dir, ok, found := s.GetPropString("source_dir")
+  if found && !ok {
+    return fmt.Errorf("Prop \"source_dir\" in spec %s is expected to be a string, got %T", s.Name, s.Props["source_dir"])
+  }
+
+  switch mode {
+  case "in-place":
+    if found {
+      if source_dir, err = filepath.Abs(source_dir); err != nil {
+        return err
+      }
+    }
+    if found && source_dir != local_path {
+      return fmt.Errorf("Spec %s builds %s in place, and cannot also have the source_dir %s", s.Name, local_path, source_dir)
+    }
+    s.Props["source_dir"] = local_path
+
+  case "copy":
+    if !found {
+      staging_dir, err := s.StagingDir()
+      if err != nil { return err }
+      copy_dir, err := os.MkdirTemp(staging_dir, "source-" + s.Name + "-")
+      if err != nil { return err }
+      s.Props["source_dir"] = copy_dir
+    }
+  }
+
+  if _, err := s.EnqueueUniqueTaskName("source-local"); err != nil {
+    return err
+  }
+  if _, err := s.EnqueueUniqueTaskName("source-infer"); err != nil {
+    return err
+  }
+  return nil
+}
+
+
+/*
+  copyDir copies the directories, files, and symbolic links in a
+  directory into another, replacing files which already exist.
+*/
+func copyDir (src, dest string) error {
+  return filepath.WalkDir(src, func (src_path string, entry fs.DirEntry, err error) error {
+    if err != nil { return err }
+
+    relative, err := filepath.Rel(src, src_path)
+    if err != nil { return err }
+    var dest_path = filepath.Join(dest, relative)
+
+    info, err := entry.Info()
+    if err != nil { return err }
+
+    switch {
+    case entry.IsDir():
+      return os.MkdirAll(dest_path, info.Mode().Perm() | 0o700)
+
+    case entry.Type() & fs.ModeSymlink != 0:
+      target, err := os.Readlink(src_path)
+      if err != nil { return err }
+      if err := os.Remove(dest_path); err != nil && !os.IsNotExist(err) {
+        return err
+      }
+      return os.Symlink(target, dest_path)
+
+    case entry.Type().IsRegular():
+      src_file, err := os.Open(src_path)
+      if err != nil { return err }
+      defer src_file.Close()
+      return writeArchiveFile(dest_path, info.Mode(), src_file)
+    }
+
+    return nil
+  })
+}
+
+
+/*
+  TaskSourceLocal prepares the source_dir of a spec whose "source"
+  is a local directory, according to its "source_mode" prop:
+
+    - in-place: The directory is built where it is, as the
+      source_dir. This is the default.
+    - copy:     The directory is copied into the source_dir, so
+      that builds do not modify it.
+    - symlink:  The source_dir is made a symbolic link to the
+      directory, so that it may be nested with other specs.
+*/
+func TaskSourceLocal (s *Spec, tk *Task) error {
+  source, err := s.RequirePropUrl("source")
+  if err != nil { return err }
+
+  local_path, err := localSourcePath(s, source)
+  if err != nil { return err }
+
+  if info, err := os.Stat(local_path); err != nil {
+    return fmt.Errorf("Cannot read the source of spec %s: %w", s.Name, err)
+  } else if !info.IsDir() {
+    return fmt.Errorf("Source of spec %s is not a directory: %s", s.Name, local_path)
+  }
+
+  mode, err := sourceMode(s)
+  if err != nil { return err }
+
+  source_dir, err := s.RequirePropString("source_dir")
+  if err != nil { return err }
+
+  source_dir, err = filepath.Abs(source_dir)
+  if err != nil { return err }
+
+  defer lockPathMutex(source_dir)()
+
+  switch mode {
+  case "copy":
+    if relative, err := filepath.Rel(local_path, source_dir); err == nil && !strings.HasPrefix(relative, "..") {
+      return fmt.Errorf("Cannot copy the source of spec %s into itself: %s", s.Name, source_dir)
+    }
+    tk.Println("Copying", local_path, "to", source_dir)
+    return copyDir(local_path, source_dir)
+
+  case "symlink":
+    if target, err := os.Readlink(source_dir); err == nil && target == local_path {
+      return nil
+    } else if _, err := os.Lstat(source_dir); err == nil {
+      return fmt.Errorf("Cannot link %s to the source of spec %s, it already exists", source_dir, s.Name)
+    }
+
+    if err := os.MkdirAll(filepath.Dir(source_dir), os.ModePerm); err != nil {
+      return err
+    }
+    return os.Symlink(local_path, source_dir)
+  }
+
+  return nil
+}
+
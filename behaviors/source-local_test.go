@@ -0,0 +1,165 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "net/url"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+
+func TestTaskSourceLocal (t *testing.T) {
+  var dir       = t.TempDir()
+  var local_dir = filepath.Join(dir, "site")
+
+  if err := os.MkdirAll(filepath.Join(local_dir, "css"), os.ModePerm); err != nil {
+    t.Fatal(err)
+  }
+  if err := os.WriteFile(filepath.Join(local_dir, "css", "style.css"), []byte("body { margin: 0 }"), 0o644); err != nil {
+    t.Fatal(err)
+  }
+
+  // build builds a spec with a local source, which reads the
+  // stylesheet from its source_dir
+  //
+  var build = func (source string, props map[string]any) (*Spec, *Spec, error) {
+    t.Helper()
+
+    root    := NewSpec("root", nil)
+    subspec := root.AddSubspec(NewSpec("site", nil))
+
+    root.Props["quiet"]      = true
+    root.Props["spec_dir"]   = dir
+    subspec.Props["source"]  = source
+    for key, value := range props {
+      subspec.Props[key] = value
+    }
+
+    root.AddSpecBuilder(BuildSourceURLType)
+    root.AddSpecBuilder(BuildSourceLocal)
+    root.AddSpecBuilder(BuildSourceDir)
+    root.AddSpecBuilder(BuildTaskInferSource)
+    if err := subspec.Build(); err != nil {
+      return root, subspec, err
+    }
+
+    err := subspec.EnqueueTaskFunc("read", func (s *Spec, tk *Task) error {
+      source_dir, _, _ := s.GetPropString("source_dir")
+      content, err := os.ReadFile(filepath.Join(source_dir, "css", "style.css"))
+      if err != nil { return err }
+      if string(content) != "body { margin: 0 }" {
+        t.Errorf("Expected the stylesheet in the source_dir, got %q", content)
+      }
+      return nil
+    })
+    return root, subspec, err
+  }
+
+  t.Run("in-place", func (t *testing.T) {
+    root, subspec, err := build("site", nil)
+    if err != nil { t.Fatal(err) }
+    TestWrapTimeoutError(t, root.Run)
+
+    if source_dir, _, _ := subspec.GetPropString("source_dir"); source_dir != local_dir {
+      t.Errorf("Expected the source_dir to be %s, got %s", local_dir, source_dir)
+    }
+  })
+
+  t.Run("copy", func (t *testing.T) {
+    root, subspec, err := build("file://" + filepath.ToSlash(local_dir), map[string]any { "source_mode": "copy" })
+    if err != nil { t.Fatal(err) }
+
+    source_dir, _, _ := subspec.GetPropString("source_dir")
+    if source_dir == local_dir {
+      t.Fatalf("Expected the source to be copied")
+    }
+
+    TestWrapTimeoutError(t, root.Run)
+
+    if _, err := os.Stat(source_dir); !os.IsNotExist(err) {
+      t.Errorf("Expected the temporary source_dir to be removed, got %v", err)
+    }
+  })
+
+  t.Run("symlink", func (t *testing.T) {
+    var source_dir = filepath.Join(dir, "links", "site")
+    root, _, err := build("site", map[string]any { "source_mode": "symlink", "source_dir": source_dir })
+    if err != nil { t.Fatal(err) }
+    TestWrapTimeoutError(t, root.Run)
+
+    if target, err := os.Readlink(source_dir); err != nil || target != local_dir {
+      t.Errorf("Expected the source_dir to link to %s, got %s, %v", local_dir, target, err)
+    }
+  })
+
+  t.Run("in-place with source_dir", func (t *testing.T) {
+    if _, _, err := build("site", map[string]any { "source_dir": filepath.Join(dir, "other") }); err == nil {
+      t.Error("Expected an in-place source with another source_dir to be an error")
+    }
+  })
+
+  t.Run("invalid mode", func (t *testing.T) {
+    if _, _, err := build("site", map[string]any { "source_mode": "move" }); err == nil {
+      t.Error("Expected an invalid source_mode to be an error")
+    }
+  })
+}
+
+
+func TestLocalSourcePath (t *testing.T) {
+  var spec_dir   = t.TempDir()
+  var source_dir = t.TempDir()
+
+  cwd, err := os.Getwd()
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  var test_cases = []struct {
+    Source      string
+    SpecDir     any
+    Expected    string
+    ExpectError bool
+  }{
+    { Source: "./site",    SpecDir: spec_dir, Expected: filepath.Join(spec_dir, "site") },
+    { Source: "../site",   SpecDir: spec_dir, Expected: filepath.Join(filepath.Dir(spec_dir), "site") },
+    { Source: "site",      SpecDir: nil,      Expected: filepath.Join(cwd, "site") },
+    { Source: "/srv/site", SpecDir: spec_dir, Expected: "/srv/site" },
+    { Source: "file:///srv/site", SpecDir: spec_dir, Expected: "/srv/site" },
+    { Source: "site.git",  SpecDir: spec_dir, Expected: "" },
+    { Source: "https://example.com/site", SpecDir: spec_dir, Expected: "" },
+    { Source: "site",      SpecDir: 1,        ExpectError: true },
+  }
+
+  for test_case_i, test_case := range test_cases {
+    // The parent's source_dir is not where relative sources are
+    // resolved from
+    //
+    root    := NewSpec("root", nil)
+    subspec := root.AddSubspec(NewSpec("site", nil))
+    root.Props["source_dir"] = source_dir
+    if test_case.SpecDir != nil {
+      root.Props["spec_dir"] = test_case.SpecDir
+    }
+
+    source, err := url.Parse(test_case.Source)
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    local_path, err := localSourcePath(subspec, source)
+    if test_case.ExpectError {
+      if err == nil {
+        t.Errorf("Test case %d: expected an error, got %q", test_case_i, local_path)
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("Test case %d: unexpected error: %v", test_case_i, err)
+    } else if local_path != test_case.Expected {
+      t.Errorf("Test case %d: expected %q to resolve to %q, got %q", test_case_i, test_case.Source, test_case.Expected, local_path)
+    }
+  }
+}
@@ -0,0 +1,256 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "fmt"
+  "os"
+  "path"
+  "path/filepath"
+  "strings"
+)
+
+
+/*
+  TaskResolverInferSourcePython infers Python projects from a
+  pyproject.toml or requirements.txt file, installing them into a
+  virtual environment and running a build command.
+*/
+var TaskResolverInferSourcePython = TaskResolver {
+  Id:   "source-infer-python",
+  Name: "source-infer",
+  Next: &TaskResolverInferSourceHugo,
+  MatchFunc: func (name string, sp *Spec) (bool, error) {
+    for _, file := range []string { "pyproject.toml", "requirements.txt" } {
+      if exists, err := sp.PathExists(file); exists || err != nil {
+        return exists, err
+      }
+    }
+    return false, nil
+  },
+  TaskPrototype: Task {
+    Func: func (sp *Spec, tk *Task) error {
+      if _, e := tk.EnqueueTaskName("source-install-python"); e != nil { return e }
+      if _, e := tk.EnqueueTaskName("source-build-python");   e != nil { return e }
+      if _, e := tk.EnqueueTaskName("assets-infer");          e != nil { return e }
+      return nil
+    },
+  },
+}
+
+
+var TaskResolverSourceInstallPython = TaskResolver {
+  Id:   "source-install-python",
+  Name: "source-install-python",
+  TaskPrototype: Task { Func: TaskSourceInstallPython },
+}
+
+
+var TaskResolverSourceBuildPython = TaskResolver {
+  Id:   "source-build-python",
+  Name: "source-build-python",
+  TaskPrototype: Task { Func: TaskSourceBuildPython },
+}
+
+
+func BuildTasksPython (s *Spec) error {
+  if s.GetTaskResolverById("source-install-python") == nil {
+    install := TaskResolverSourceInstallPython
+    s.AddTaskResolver(&install)
+  }
+
+  if s.GetTaskResolverById("source-build-python") == nil {
+    build := TaskResolverSourceBuildPython
+    s.AddTaskResolver(&build)
+  }
+
+  return nil
+}
+
+
+/*
+  pythonVenv returns the virtual environment directory, relative
+  to source_dir, from the "python_venv" prop. Defaults to ".venv".
+*/
+func pythonVenv (s *Spec) (string, error) {
+  venv, ok, found := s.GetPropString("python_venv")
+  if found && !ok {
+    return "", fmt.Errorf("Prop \"python_venv\" in spec %s is expected to be a string, got %T", s.Name, s.Props["python_venv"])
+  } else if !found || venv == "" {
+    return ".venv", nil
+  }
+  return path.Clean(venv), nil
+}
+
+
+/*
+  pythonCommand splits a command string and, if its program is
+  installed in the virtual environment, resolves it to the
+  virtual environment's executable, as commands are looked up in
+  the process PATH rather than the command's environment.
+*/
+func pythonCommand (venv_bin string, command string) []string {
+  args := strings.Fields(command)
+  if len(args) == 0 || strings.ContainsRune(args[0], '/') {
+    return args
+  }
+
+  if _, err := os.Stat(filepath.Join(venv_bin, args[0])); err == nil {
+    args[0] = filepath.Join(venv_bin, args[0])
+  }
+  return args
+}
+
+
+/*
+  pythonVenvEnv sets the Task's environment to use the virtual
+  environment, returning the path of its executables.
+*/
+func pythonVenvEnv (s *Spec, tk *Task) (string, error) {
+  venv, err := pythonVenv(s)
+  if err != nil { return "", err }
+
+  venv_path, err := s.GetKeyPath(venv)
+  if err != nil { return "", err }
+
+  venv_path, err = filepath.Abs(venv_path)
+  if err != nil { return "", err }
+
+  venv_bin := filepath.Join(venv_path, "bin")
+
+  environ, err := tk.Environ()
+  if err != nil { return "", err }
+
+  var env_path string
+  for _, entry := range environ {
+    if value, found := strings.CutPrefix(entry, "PATH="); found {
+      env_path = value
+    }
+  }
+
+  if tk.Env == nil {
+    tk.Env = make(map[string]string)
+  }
+  tk.Env["VIRTUAL_ENV"] = venv_path
+  tk.Env["PATH"]        = venv_bin + string(os.PathListSeparator) + env_path
+
+  return venv_bin, nil
+}
+
+
+/*
+  TaskSourceInstallPython creates a virtual environment and
+  installs the project's dependencies into it. It is skipped if
+  the virtual environment already exists. It reads the following
+  props:
+
+    - python:             The interpreter used to create the
+      virtual environment. Defaults to "python3".
+    - python_venv:        The virtual environment directory,
+      relative to source_dir. Defaults to ".venv".
+    - python_install_cmd: A command which installs dependencies
+      in place of `pip install -r requirements.txt`, or
+      `pip install .` for projects with only a pyproject.toml.
+*/
+func TaskSourceInstallPython (s *Spec, tk *Task) error {
+  unlock, err := lockSourceDir(s)
+  if err != nil { return err }
+  defer unlock()
+
+  venv, err := pythonVenv(s)
+  if err != nil { return err }
+
+  if venv_exists, err := s.PathExists(venv); venv_exists || err != nil {
+    return err
+  }
+
+  python, ok, found := s.GetPropString("python")
+  if found && !ok {
+    return fmt.Errorf("Prop \"python\" in spec %s is expected to be a string, got %T", s.Name, s.Props["python"])
+  } else if !found || python == "" {
+    python = "python3"
+  }
+
+  if _, err := tk.CommandRun(python, "-m", "venv", venv); err != nil {
+    return err
+  }
+
+  venv_bin, err := pythonVenvEnv(s, tk)
+  if err != nil { return err }
+
+  var install_cmd []string
+
+  if prop_install_cmd, ok, found := s.GetPropString("python_install_cmd"); found && !ok {
+    return fmt.Errorf("Prop \"python_install_cmd\" in spec %s is expected to be a string, got %T", s.Name, s.Props["python_install_cmd"])
+  } else if found {
+    install_cmd = pythonCommand(venv_bin, prop_install_cmd)
+  } else if requirements_exists, err := s.PathExists("requirements.txt"); err != nil {
+    return err
+  } else if requirements_exists {
+    install_cmd = pythonCommand(venv_bin, "pip install -r requirements.txt")
+  } else {
+    install_cmd = pythonCommand(venv_bin, "pip install .")
+  }
+
+  if len(install_cmd) >= 1 {
+    if _, err := tk.CommandRun(install_cmd[0], install_cmd[1:]...); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+
+/*
+  TaskSourceBuildPython runs a build command in the virtual
+  environment, and emits the output directory as assets. It reads
+  the following props:
+
+    - python_build_cmd: The build command, such as
+      "sphinx-build docs site". Defaults to "mkdocs build" if the
+      source has a mkdocs.yml file.
+    - python_output:    The output directory, relative to
+      source_dir. Defaults to "site".
+*/
+func TaskSourceBuildPython (s *Spec, tk *Task) error {
+  venv_bin, err := pythonVenvEnv(s, tk)
+  if err != nil { return err }
+
+  var output_dir = "site"
+
+  if prop_output, ok, found := s.GetPropString("python_output"); found && !ok {
+    return fmt.Errorf("Prop \"python_output\" in spec %s is expected to be a string, got %T", s.Name, s.Props["python_output"])
+  } else if found && prop_output != "" {
+    output_dir = path.Clean(prop_output)
+  }
+
+  build_cmd, ok, found := s.GetPropString("python_build_cmd")
+  if found && !ok {
+    return fmt.Errorf("Prop \"python_build_cmd\" in spec %s is expected to be a string, got %T", s.Name, s.Props["python_build_cmd"])
+  }
+
+  if !found {
+    if mkdocs_exists, err := s.PathExists("mkdocs.yml"); err != nil {
+      return err
+    } else if mkdocs_exists {
+      build_cmd = "mkdocs build"
+    }
+  }
+
+  if args := pythonCommand(venv_bin, build_cmd); len(args) >= 1 {
+    if _, err := tk.CommandRun(args[0], args[1:]...); err != nil {
+      return err
+    }
+  }
+
+  if exists, err := s.PathExists(output_dir); err != nil {
+    return err
+  } else if !exists {
+    return fmt.Errorf("Python output directory %s does not exist in spec %s", output_dir, s.Name)
+  }
+
+  output_asset, err := s.MakeFileKeyAsset(output_dir, "/")
+  if err != nil { return err }
+
+  return tk.EmitAsset(output_asset)
+}
@@ -0,0 +1,76 @@
+package behaviors
+
+import (
+  "testing"
+  . "gilchrist.tech/interbuilder"
+
+  "os/exec"
+  "strings"
+)
+
+
+func TestTaskInferSourcePython (t *testing.T) {
+  if _, err := exec.LookPath("python3"); err != nil {
+    t.Skip("python3 is not installed")
+  }
+
+  var root    *Spec = NewSpec("root", nil)
+  var py_spec *Spec = root.AddSubspec(NewSpec("py_spec", nil))
+
+  root.AddSpecBuilder(BuildTaskInferSource)
+  root.AddSpecBuilder(BuildTasksPython)
+
+  // The build command is resolved to the virtual environment's
+  // python, which writes the output directory
+  //
+  py_spec.Props["source_dir"]       = t.TempDir()
+  py_spec.Props["python_build_cmd"] = "python build.py"
+  py_spec.Props["python_output"]    = "public"
+
+  var files = map[string]string {
+    "requirements.txt": "",
+    "build.py": strings.Join([]string {
+      "import os, sys",
+      "os.makedirs('public', exist_ok=True)",
+      "open('public/index.html', 'w').write(sys.prefix == sys.base_prefix and 'system' or 'venv')",
+    }, "\n"),
+  }
+
+  for key, content := range files {
+    if err := py_spec.WriteFile(key, []byte(content), 0o660); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if err := root.Build(); err != nil {
+    t.Fatal("Could not build root spec:", err)
+  }
+
+  if _, err := py_spec.EnqueueTaskName("source-infer"); err != nil {
+    t.Fatal(err)
+  }
+
+  var received = make(map[string]string)
+
+  root.EnqueueTaskFunc("consume-site", func (s *Spec, tk *Task) error {
+    for asset_chunk := range s.Input {
+      assets, err := asset_chunk.Flatten()
+      if err != nil { return err }
+
+      for _, asset := range assets {
+        content, err := asset.GetContentBytes()
+        if err != nil { return err }
+        received[strings.TrimLeft(asset.Url.Path, "/")] = string(content)
+      }
+    }
+    return nil
+  })
+
+  if err := root.Run(); err != nil {
+    t.Fatal(err)
+  }
+
+  if got, expect := received["@emit/index.html"], "venv"; got != expect {
+    t.Errorf("Expected index.html to be built in a virtual environment with content %q, got %q in %v", expect, got, received)
+  }
+}
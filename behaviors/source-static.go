@@ -0,0 +1,66 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "fmt"
+  "io/fs"
+)
+
+
+/*
+  TaskResolverInferSourceStatic infers a directory of static files
+  from the "source_static" prop, emitting them with the
+  "source-emit-static" task. It is the last inference, so that
+  sources with a build are still built.
+*/
+var TaskResolverInferSourceStatic = TaskResolver {
+  Id:   "source-infer-static",
+  Name: "source-infer",
+  MatchFunc: func (name string, sp *Spec) (bool, error) {
+    source_static, ok, found := sp.GetPropBool("source_static")
+    if found && !ok {
+      return false, fmt.Errorf("Prop \"source_static\" in spec %s is expected to be a boolean, got %T", sp.Name, sp.Props["source_static"])
+    }
+    return source_static, nil
+  },
+  TaskPrototype: Task {
+    Func: func (sp *Spec, tk *Task) error {
+      _, err := tk.EnqueueTaskName("source-emit-static")
+      return err
+    },
+  },
+}
+
+
+var TaskResolverSourceEmitStatic = TaskResolver {
+  Id:   "source-emit-static",
+  Name: "source-emit-static",
+  TaskPrototype: Task { Func: TaskSourceEmitStatic },
+}
+
+
+func BuildTasksStatic (s *Spec) error {
+  if s.GetTaskResolverById("source-emit-static") == nil {
+    emit := TaskResolverSourceEmitStatic
+    s.AddTaskResolver(&emit)
+  }
+  return nil
+}
+
+
+/*
+  TaskSourceEmitStatic emits the files in the spec's source_dir,
+  keyed by their paths relative to it, except for those ignored,
+  as in Spec.WalkIgnoring, such as version control directories.
+*/
+func TaskSourceEmitStatic (s *Spec, tk *Task) error {
+  source_dir, err := s.RequirePropString("source_dir")
+  if err != nil { return err }
+
+  return s.WalkIgnoring(source_dir, func (file_path, key string, entry fs.DirEntry) error {
+    asset, err := s.MakeFileKeyAsset(file_path, key)
+    if err != nil { return err }
+    return tk.EmitAsset(asset)
+  })
+}
@@ -0,0 +1,71 @@
+package behaviors
+
+import (
+  "testing"
+  . "gilchrist.tech/interbuilder"
+
+  "sort"
+  "strings"
+)
+
+
+func TestTaskInferSourceStatic (t *testing.T) {
+  var root        *Spec = NewSpec("root", nil)
+  var static_spec *Spec = root.AddSubspec(NewSpec("static_spec", nil))
+
+  root.AddSpecBuilder(BuildTaskInferSource)
+  root.AddSpecBuilder(BuildTasksStatic)
+
+  root.Props["quiet"]                = true
+  static_spec.Props["source_dir"]    = t.TempDir()
+  static_spec.Props["source_static"] = true
+
+  for key, content := range map[string]string {
+    "index.html":    "<p>Home</p>",
+    "css/style.css": "body {}",
+    ".well-known/x": "x",
+    ".git/HEAD":     "ref: refs/heads/main",
+    ".ibignore":     "node_modules/\n*.log\n",
+    "node_modules/lib/index.js": "module.exports = {}",
+    "css/.ibignore": "/cache\n!keep.log\n",
+    "css/cache/a.css": "a {}",
+    "css/debug.log": "debug",
+    "css/keep.log":  "keep",
+  } {
+    if err := static_spec.WriteFile(key, []byte(content), 0o660); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if err := root.Build(); err != nil {
+    t.Fatal("Could not build root spec:", err)
+  }
+
+  if _, err := static_spec.EnqueueTaskName("source-infer"); err != nil {
+    t.Fatal(err)
+  }
+
+  var received []string
+
+  root.EnqueueTaskFunc("consume-site", func (s *Spec, tk *Task) error {
+    for asset_chunk := range s.Input {
+      assets, err := asset_chunk.Flatten()
+      if err != nil { return err }
+
+      for _, asset := range assets {
+        content, err := asset.GetContentBytes()
+        if err != nil { return err }
+        received = append(received, strings.TrimLeft(asset.Url.Path, "/") + "=" + string(content))
+      }
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  sort.Strings(received)
+  var expect = "@emit/.well-known/x=x @emit/css/keep.log=keep @emit/css/style.css=body {} @emit/index.html=<p>Home</p>"
+  if got := strings.Join(received, " "); got != expect {
+    t.Errorf("Expected static files %s, got %s", expect, got)
+  }
+}
@@ -0,0 +1,325 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "gopkg.in/yaml.v3"
+
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/url"
+  "sort"
+  "strings"
+)
+
+
+/*
+  SubspecConfig is the schema of a spec's props in a build
+  specification, as read by ResolveSubspecs. The props most
+  pipelines use are fields, and any others, such as those of
+  behaviors, are kept in Props. It can be encoded as JSON or YAML,
+  in which Props are flattened into the same object as the other
+  fields.
+*/
+type SubspecConfig struct {
+  // Source is the URL or path of the spec's content, such as a
+  // git repository
+  //
+  Source      string  `json:"source,omitempty"      yaml:"source,omitempty"`
+  SourceDir   string  `json:"source_dir,omitempty"  yaml:"source_dir,omitempty"`
+  SourceNest  string  `json:"source_nest,omitempty" yaml:"source_nest,omitempty"`
+
+  // Transform is a path transformation string, object, or array
+  // of them, as in PathTransformationsFromAny
+  //
+  Transform   any     `json:"transform,omitempty"   yaml:"transform,omitempty"`
+
+  // Env is an object of environment variables, whose values are
+  // strings, numbers, or booleans
+  //
+  Env         map[string]any  `json:"env,omitempty" yaml:"env,omitempty"`
+
+  // Tasks are task definitions, as in BuildConfigTasks
+  //
+  Tasks       []map[string]any           `json:"tasks,omitempty"    yaml:"tasks,omitempty"`
+  Subspecs    map[string]*SubspecConfig  `json:"subspecs,omitempty" yaml:"subspecs,omitempty"`
+
+  // Props are the spec's other props
+  //
+  Props       map[string]any  `json:"-" yaml:",inline"`
+}
+
+
+/*
+  SpecConfig is the schema of a build specification file, the
+  props of the root spec.
+*/
+type SpecConfig struct {
+  Name           string  `json:"name,omitempty" yaml:"name,omitempty"`
+  SubspecConfig  `yaml:",inline"`
+}
+
+
+/*
+  subspec_config_keys are the props which are fields of a
+  SubspecConfig, rather than in its Props.
+*/
+var subspec_config_keys = map[string]bool {
+  "source": true, "source_dir": true, "source_nest": true,
+  "transform": true, "env": true, "tasks": true, "subspecs": true,
+}
+
+
+func (c *SubspecConfig) UnmarshalJSON (data []byte) error {
+  // fields has no methods, so that it is decoded by its tags
+  //
+  type fields SubspecConfig
+  var parsed fields
+  if err := json.Unmarshal(data, &parsed); err != nil {
+    return err
+  }
+
+  var props map[string]any
+  if err := json.Unmarshal(data, &props); err != nil {
+    return err
+  }
+  for key := range props {
+    if subspec_config_keys[key] {
+      delete(props, key)
+    }
+  }
+  if len(props) > 0 {
+    parsed.Props = props
+  }
+
+  *c = SubspecConfig(parsed)
+  return nil
+}
+
+
+func (c SubspecConfig) MarshalJSON () ([]byte, error) {
+  return json.Marshal(c.ToProps())
+}
+
+
+func (c *SpecConfig) UnmarshalJSON (data []byte) error {
+  if err := c.SubspecConfig.UnmarshalJSON(data); err != nil {
+    return err
+  }
+  return c.takeName()
+}
+
+
+/*
+  takeName moves the "name" prop, decoded into Props with the
+  others, into Name.
+*/
+func (c *SpecConfig) takeName () error {
+  name_any, found := c.Props["name"]
+  if !found {
+    return nil
+  }
+  name, ok := name_any.(string)
+  if !ok || name == "" {
+    return fmt.Errorf("Spec config \"name\" is expected to be a non-empty string, got %v", name_any)
+  }
+  c.Name = name
+  delete(c.Props, "name")
+  return nil
+}
+
+
+func (c SpecConfig) MarshalJSON () ([]byte, error) {
+  return json.Marshal(c.ToProps())
+}
+
+
+/*
+  UnmarshalYAML decodes a SpecConfig like its JSON counterpart,
+  as YAML does not collect props into the map of an embedded
+  struct.
+*/
+func (c *SpecConfig) UnmarshalYAML (node *yaml.Node) error {
+  if err := node.Decode(&c.SubspecConfig); err != nil {
+    return err
+  }
+  return c.takeName()
+}
+
+
+func (c SpecConfig) MarshalYAML () (any, error) {
+  return c.ToProps(), nil
+}
+
+
+/*
+  ToProps returns the config as a spec's props, with its subspecs
+  as prop objects, as ResolveSubspecs reads them.
+*/
+func (c *SubspecConfig) ToProps () map[string]any {
+  var props = make(map[string]any, len(c.Props))
+  for key, value := range c.Props {
+    props[key] = value
+  }
+
+  var set = func (key, value string) {
+    if value != "" {
+      props[key] = value
+    }
+  }
+  set("source",      c.Source)
+  set("source_dir",  c.SourceDir)
+  set("source_nest", c.SourceNest)
+
+  if c.Transform != nil {
+    props["transform"] = c.Transform
+  }
+  if c.Env != nil {
+    props["env"] = c.Env
+  }
+  if c.Tasks != nil {
+    var tasks = make([]any, len(c.Tasks))
+    for i, task := range c.Tasks {
+      tasks[i] = task
+    }
+    props["tasks"] = tasks
+  }
+  if c.Subspecs != nil {
+    var subspecs = make(map[string]any, len(c.Subspecs))
+    for name, subspec := range c.Subspecs {
+      if subspec == nil {
+        subspec = & SubspecConfig {}
+      }
+      subspecs[name] = subspec.ToProps()
+    }
+    props["subspecs"] = subspecs
+  }
+
+  return props
+}
+
+
+func (c *SpecConfig) ToProps () map[string]any {
+  var props = c.SubspecConfig.ToProps()
+  if c.Name != "" {
+    props["name"] = c.Name
+  }
+  return props
+}
+
+
+/*
+  Validate checks the config and those of its subspecs, returning
+  the errors found, joined with errors.Join. Which props are
+  recognized depends on the spec builders a pipeline has, so
+  Props are not checked, except that "preset" must name
+  registered presets.
+*/
+func (c *SpecConfig) Validate () error {
+  var name = c.Name
+  if name == "" {
+    name = "root"
+  }
+  return errors.Join(c.SubspecConfig.validate(name)...)
+}
+
+
+func (c *SubspecConfig) Validate () error {
+  return errors.Join(c.validate("subspec")...)
+}
+
+
+func (c *SubspecConfig) validate (spec_path string) []error {
+  var errs []error
+  var fail = func (format string, a ...any) {
+    errs = append(errs, fmt.Errorf("Spec %s: " + format, append([]any { spec_path }, a...)...))
+  }
+
+  if c.Source != "" {
+    if _, err := url.Parse(c.Source); err != nil {
+      fail("source is not a valid URL: %v", err)
+    }
+  }
+
+  // Transformation strings are parsed here, but objects may name
+  // files which do not exist until the spec runs, and are left to
+  // be parsed when building
+  //
+  switch transform := c.Transform.(type) {
+  case nil, map[string]any:
+  case string:
+    if _, err := PathTransformationsFromAny(transform); err != nil {
+      fail("%v", err)
+    }
+  case []any:
+    for _, element := range transform {
+      switch element := element.(type) {
+      case string:
+        if _, err := PathTransformationsFromAny(element); err != nil {
+          fail("%v", err)
+        }
+      case map[string]any:
+      default:
+        fail("transform is expected to contain strings and objects, got %T", element)
+      }
+    }
+  default:
+    fail("transform is expected to be a string, object, or array, got %T", transform)
+  }
+
+  for key, value := range c.Env {
+    switch value.(type) {
+    case string, bool, int, int64, float64:
+    default:
+      fail("env variable %s is expected to be a string, number, or boolean, got %T", key, value)
+    }
+  }
+
+  if preset, found := c.Props["preset"]; found {
+    if _, err := presetsFromProp(preset); err != nil {
+      fail("preset %v", err)
+    }
+  }
+
+  for task_i, task := range c.Tasks {
+    if _, _, _, err := TaskResolverFromConfig(task); err != nil {
+      fail("task definition %d: %v", task_i, err)
+    }
+  }
+
+  var names = make([]string, 0, len(c.Subspecs))
+  for name := range c.Subspecs {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  for _, name := range names {
+    if name == "" || strings.Contains(name, "/") {
+      fail("subspec name %q is expected to be non-empty, without slashes", name)
+      continue
+    }
+    if subspec := c.Subspecs[name]; subspec != nil {
+      errs = append(errs, subspec.validate(spec_path + "/" + name)...)
+    }
+  }
+
+  return errs
+}
+
+
+/*
+  BuildSpecFromConfig validates a config, sets its props in a
+  root spec, and builds the spec, resolving its subspecs. The
+  root spec is expected to have the builders which read the
+  config's props, such as ResolveSubspecs.
+*/
+func BuildSpecFromConfig (root *Spec, config *SpecConfig) error {
+  if err := config.Validate(); err != nil {
+    return err
+  }
+  for key, value := range config.ToProps() {
+    root.Props[key] = value
+  }
+  return root.Build()
+}
@@ -0,0 +1,116 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "gopkg.in/yaml.v3"
+
+  "encoding/json"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+
+func TestSpecConfig (t *testing.T) {
+  var json_src = `{
+    "name":        "root",
+    "source_nest": "build",
+    "quiet":       true,
+    "subspecs": {
+      "site": {
+        "source":    "git://example.com/site",
+        "transform": "s` + "`^`site/`" + `",
+        "env":       { "NODE_ENV": "production" },
+        "tasks":     [ { "name": "hello", "command": "echo hello" } ],
+        "markdown":  true
+      }
+    }
+  }`
+
+  var yaml_src = "name: root\n" +
+    "source_nest: build\n" +
+    "quiet: true\n" +
+    "subspecs:\n" +
+    "  site:\n" +
+    "    source: git://example.com/site\n" +
+    "    transform: s`^`site/`\n" +
+    "    env: { NODE_ENV: production }\n" +
+    "    tasks: [ { name: hello, command: echo hello } ]\n" +
+    "    markdown: true\n"
+
+  var from_json, from_yaml SpecConfig
+  if err := json.Unmarshal([]byte(json_src), &from_json); err != nil {
+    t.Fatal(err)
+  }
+  if err := yaml.Unmarshal([]byte(yaml_src), &from_yaml); err != nil {
+    t.Fatal(err)
+  }
+
+  for format, config := range map[string]*SpecConfig { "json": &from_json, "yaml": &from_yaml } {
+    var site = config.Subspecs["site"]
+    if config.Name != "root" || config.SourceNest != "build" || config.Props["quiet"] != true {
+      t.Errorf("%s: Expected the root fields and props, got %+v", format, config)
+    }
+    if site == nil || site.Source != "git://example.com/site" || site.Props["markdown"] != true || len(site.Tasks) != 1 {
+      t.Errorf("%s: Expected the subspec fields and props, got %+v", format, site)
+    }
+    if err := config.Validate(); err != nil {
+      t.Errorf("%s: Expected the config to be valid, got %v", format, err)
+    }
+  }
+
+  // Props round-trip through JSON
+  //
+  encoded, err := json.Marshal(from_json)
+  if err != nil { t.Fatal(err) }
+
+  var expect, got map[string]any
+  json.Unmarshal([]byte(json_src), &expect)
+  json.Unmarshal(encoded, &got)
+  if !reflect.DeepEqual(expect, got) {
+    t.Errorf("Expected the encoded config to be:\n%v\ngot:\n%v", expect, got)
+  }
+
+  // Each invalid field is reported with its spec's path
+  //
+  var invalid = SpecConfig {
+    SubspecConfig: SubspecConfig {
+      Subspecs: map[string]*SubspecConfig {
+        "a/b":  {},
+        "site": {
+          Transform: 42,
+          Env:       map[string]any { "LIST": []any { 1 } },
+          Tasks:     []map[string]any { { "command": "true" } },
+        },
+        "blog": { Props: map[string]any { "preset": "blog-site" } },
+      },
+    },
+  }
+  err = invalid.Validate()
+  for _, message := range []string { `"a/b"`, "root/site: transform", "root/site: env variable LIST", "root/site: task definition 0", `root/blog: preset names an unknown preset "blog-site"` } {
+    if err == nil || !strings.Contains(err.Error(), message) {
+      t.Errorf("Expected a validation error containing %s, got %v", message, err)
+    }
+  }
+
+  // Building resolves the subspecs
+  //
+  root := NewSpec("root", nil)
+  root.AddSpecBuilder(ResolveSubspecs)
+  var config = SpecConfig {
+    SubspecConfig: SubspecConfig {
+      Props:    map[string]any { "quiet": true },
+      Subspecs: map[string]*SubspecConfig { "site": { SourceDir: "site-dir" } },
+    },
+  }
+  if err := BuildSpecFromConfig(root, &config); err != nil {
+    t.Fatal(err)
+  }
+  if site := root.Subspecs["site"]; site == nil || site.Props["source_dir"] != "site-dir" {
+    t.Errorf("Expected the subspec to be resolved, got %v", root.Subspecs)
+  }
+  if err := BuildSpecFromConfig(NewSpec("root", nil), &invalid); err == nil {
+    t.Errorf("Expected an invalid config not to be built")
+  }
+}
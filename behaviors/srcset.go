@@ -0,0 +1,320 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "golang.org/x/net/html"
+
+  "bytes"
+  "fmt"
+  "net/url"
+  "path"
+  "slices"
+  "strconv"
+  "strings"
+)
+
+
+/*
+  TaskResolverResponsiveImages resolves the "responsive-images"
+  task, which adds "srcset" and "sizes" attributes to <img>
+  elements referencing images with resized variants. It runs
+  after images are resized, and before fingerprinting and the
+  root spec's "root-consume" task.
+*/
+var TaskResolverResponsiveImages = TaskResolver {
+  Id:   "responsive-images",
+  Name: "responsive-images",
+  MatchFunc: func (name string, spec *Spec) (bool, error) {
+    return name == "responsive-images", nil
+  },
+  TaskPrototype: Task {
+    Mask:   TASK_ASSETS_MUTATE,
+    Func:   TaskResponsiveImages,
+    After:  []string { "optimize-images", "render-markdown" },
+    Before: []string { "fingerprint-assets", "emit-manifest", "precompress-assets", "root-consume" },
+  },
+}
+
+
+/*
+  BuildTaskResponsiveImages defers the "responsive-images" task if
+  the spec's "responsive_images" prop is true.
+*/
+func BuildTaskResponsiveImages (s *Spec) error {
+  if s.GetTaskResolverById("responsive-images") == nil {
+    responsive := TaskResolverResponsiveImages
+    s.AddTaskResolver(&responsive)
+  }
+
+  responsive, ok, found := s.GetPropBool("responsive_images")
+  if !found {
+    return nil
+  } else if !ok {
+    return fmt.Errorf("Prop \"responsive_images\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["responsive_images"])
+  } else if !responsive {
+    return nil
+  }
+
+  task, err := s.GetTask("responsive-images", s)
+  if err != nil {
+    return err
+  } else if task == nil {
+    return fmt.Errorf("Could not resolve the responsive-images task in spec %s", s.Name)
+  }
+
+  return s.DeferTask(task)
+}
+
+
+/*
+  An ImageCandidate is an image which can be listed in a srcset
+  attribute, with its width in pixels.
+*/
+type ImageCandidate struct {
+  Path   string
+  Width  int
+}
+
+
+/*
+  imageCandidates indexes resized image variants, and the widths
+  of their originals, by the path of the original image.
+*/
+type imageCandidates struct {
+  variants  map[string][]ImageCandidate
+  widths    map[string]int
+}
+
+
+/*
+  responsivePath normalizes an asset URL path, removing its @emit/
+  directive and ensuring a leading slash.
+*/
+func responsivePath (p string) string {
+  p = strings.TrimPrefix(p, "/")
+  p = strings.TrimPrefix(p, "@emit/")
+  return "/" + strings.TrimLeft(p, "/")
+}
+
+
+/*
+  imageVariantSource returns the path of the original image of a
+  variant, by removing the width which ImageVariantPath inserted
+  into its name, and whether it was found.
+*/
+func imageVariantSource (variant_path string, width int) (string, bool) {
+  var ext    = path.Ext(variant_path)
+  var suffix = "-" + strconv.Itoa(width) + "w" + ext
+  if !strings.HasSuffix(variant_path, suffix) {
+    return "", false
+  }
+  return strings.TrimSuffix(variant_path, suffix) + ext, true
+}
+
+
+/*
+  add records an asset in the index if it is an image with a
+  width in its Metadata. Variants are recognized by their
+  "variant_of" Metadata, which optimize-images sets.
+*/
+func (c *imageCandidates) add (a *Asset) {
+  if a.Url == nil || a.Metadata == nil {
+    return
+  }
+
+  width, ok := a.Metadata["width"].(int)
+  if !ok || width <= 0 {
+    return
+  }
+
+  var asset_path = responsivePath(a.Url.Path)
+
+  if _, is_variant := a.Metadata["variant_of"]; !is_variant {
+    c.widths[asset_path] = width
+    return
+  }
+
+  source, ok := imageVariantSource(asset_path, width)
+  if !ok {
+    return
+  }
+
+  for _, candidate := range c.variants[source] {
+    if candidate.Path == asset_path {
+      return
+    }
+  }
+  c.variants[source] = append(c.variants[source], ImageCandidate { asset_path, width })
+}
+
+
+/*
+  ImgSrcset returns the srcset attribute value of an image
+  reference, listing its variants and the original by ascending
+  width. Variant references keep the form of the original
+  reference, so relative references stay relative. The original
+  is only listed if its width is known.
+*/
+func ImgSrcset (src *url.URL, variants []ImageCandidate, original_width int) string {
+  variants = slices.Clone(variants)
+  slices.SortFunc(variants, func (a, b ImageCandidate) int {
+    return a.Width - b.Width
+  })
+
+  var candidates []string
+  for _, variant := range variants {
+    var variant_url = *src
+    variant_url.Path    = ImageVariantPath(src.Path, variant.Width)
+    variant_url.RawPath = ""
+    candidates = append(candidates, variant_url.String() + " " + strconv.Itoa(variant.Width) + "w")
+  }
+
+  if original_width > 0 {
+    candidates = append(candidates, src.String() + " " + strconv.Itoa(original_width) + "w")
+  }
+
+  return strings.Join(candidates, ", ")
+}
+
+
+/*
+  htmlNodeAddSrcset traverses an HTML document, and adds srcset
+  and sizes attributes to <img> elements without a srcset whose
+  src has variants. The document is mutated in-place, and true is
+  returned if it was modified.
+*/
+func htmlNodeAddSrcset (node *html.Node, page_path string, candidates *imageCandidates, sizes string) bool {
+  var modified bool
+
+  if node.Type == html.ElementNode && node.Data == "img" {
+    var src_value  string
+    var has_srcset bool
+    var has_sizes  bool
+
+    for _, attr := range node.Attr {
+      switch attr.Key {
+        case "src":
+          src_value = attr.Val
+        case "srcset":
+          has_srcset = true
+        case "sizes":
+          has_sizes = true
+      }
+    }
+
+    src, err := url.Parse(src_value)
+
+    if err == nil && !has_srcset && src.Scheme == "" && src.Host == "" && src.Path != "" {
+      var image_path string
+      if strings.HasPrefix(src.Path, "/") {
+        image_path = path.Clean(src.Path)
+      } else {
+        image_path = path.Join(path.Dir(page_path), src.Path)
+      }
+
+      if variants := candidates.variants[image_path]; len(variants) > 0 {
+        node.Attr = append(node.Attr, html.Attribute {
+          Key: "srcset",
+          Val: ImgSrcset(src, variants, candidates.widths[image_path]),
+        })
+        if !has_sizes && sizes != "" {
+          node.Attr = append(node.Attr, html.Attribute { Key: "sizes", Val: sizes })
+        }
+        modified = true
+      }
+    }
+  }
+
+  for child := node.FirstChild; child != nil; child = child.NextSibling {
+    if htmlNodeAddSrcset(child, page_path, candidates, sizes) {
+      modified = true
+    }
+  }
+
+  return modified
+}
+
+
+/*
+  TaskResponsiveImages pools the spec's input assets, and adds
+  srcset and sizes attributes to <img> elements in HTML assets
+  which reference images with resized variants. Variants are
+  found among the pooled assets, and in the AssetFrames of the
+  spec's subspecs, by their "variant_of" and "width" Metadata, as
+  set by the "optimize-images" task. It reads the following props:
+
+    - srcset_sizes: The sizes attribute added alongside srcset.
+      Defaults to "100vw". If empty, no sizes attribute is added.
+*/
+func TaskResponsiveImages (s *Spec, tk *Task) error {
+  var sizes = "100vw"
+  if prop_sizes, ok, found := s.GetPropString("srcset_sizes"); found && !ok {
+    return fmt.Errorf("Prop \"srcset_sizes\" in spec %s is expected to be a string, got %T", s.Name, s.Props["srcset_sizes"])
+  } else if found {
+    sizes = prop_sizes
+  }
+
+  if err := tk.PoolSpecInputAssets(); err != nil {
+    return fmt.Errorf("Cannot pool assets to add srcset attributes: %w", err)
+  }
+
+  var assets []*Asset
+  for _, input := range tk.Assets {
+    flattened, err := input.FlattenContext(tk.Context())
+    if err != nil { return err }
+    assets = append(assets, flattened...)
+  }
+
+  var candidates = imageCandidates {
+    variants: make(map[string][]ImageCandidate),
+    widths:   make(map[string]int),
+  }
+
+  for _, asset := range assets {
+    candidates.add(asset)
+  }
+
+  // Subspecs have finished once their assets are pooled, so their
+  // frames are complete
+  //
+  for _, frame := range tk.InputAssetFrames() {
+    for _, key := range frame.Keys() {
+      if asset, found := frame.Get(key); found {
+        candidates.add(asset)
+      }
+    }
+  }
+
+  if len(candidates.variants) > 0 {
+    for _, asset := range assets {
+      if !isHtmlAsset(asset) {
+        continue
+      }
+
+      content, err := asset.GetContentBytes()
+      if err != nil { return err }
+
+      doc, err := html.Parse(bytes.NewReader(content))
+      if err != nil {
+        return fmt.Errorf("Cannot parse HTML asset %s: %w", asset.Url, err)
+      }
+
+      if !htmlNodeAddSrcset(doc, responsivePath(asset.Url.Path), &candidates, sizes) {
+        continue
+      }
+
+      var writer bytes.Buffer
+      if err := html.Render(&writer, doc); err != nil {
+        return err
+      }
+
+      asset.ClearContentDataCache()
+      if err := asset.SetContentBytes(writer.Bytes()); err != nil {
+        return err
+      }
+    }
+  }
+
+  return tk.EmitAssets(assets)
+}
@@ -0,0 +1,136 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "strings"
+  "testing"
+)
+
+
+func TestTaskResponsiveImages (t *testing.T) {
+  var images = map[string]map[string]any {
+    "img/photo.jpg":       { "width": 1200 },
+    "img/photo-800w.jpg":  { "width": 800, "variant_of": "/img/photo.jpg" },
+    "img/photo-480w.jpg":  { "width": 480, "variant_of": "/img/photo.jpg" },
+    "img/icon.png":        { "width": 32 },
+  }
+
+  var page = strings.Join([]string {
+    `<img src="img/photo.jpg">`,
+    `<img src="/img/photo.jpg?v=1" sizes="50vw">`,
+    `<img src="img/photo.jpg" srcset="img/photo.jpg 1x">`,
+    `<img src="img/icon.png">`,
+  }, "")
+
+  var expect = []string {
+    `<img src="img/photo.jpg" srcset="img/photo-480w.jpg 480w, img/photo-800w.jpg 800w, img/photo.jpg 1200w" sizes="100vw"/>`,
+    `<img src="/img/photo.jpg?v=1" sizes="50vw" srcset="/img/photo-480w.jpg?v=1 480w, /img/photo-800w.jpg?v=1 800w, /img/photo.jpg?v=1 1200w"/>`,
+    `<img src="img/photo.jpg" srcset="img/photo.jpg 1x"/>`,
+    `<img src="img/icon.png"/>`,
+  }
+
+  // emitDocuments returns a task emitting images and a page
+  //
+  var emitDocuments = func (with_images, with_page bool) TaskFunc {
+    return func (s *Spec, tk *Task) error {
+      if with_images {
+        for key, metadata := range images {
+          asset := s.MakeAsset(key)
+          asset.SetContentBytes([]byte(key))
+          asset.Metadata = metadata
+          if err := tk.EmitAsset(asset); err != nil {
+            return err
+          }
+        }
+      }
+      if with_page {
+        asset := s.MakeAsset("index.html")
+        asset.SetContentBytes([]byte(page))
+        return tk.EmitAsset(asset)
+      }
+      return nil
+    }
+  }
+
+  var test_cases = []struct {
+    name      string
+    setup     func (root *Spec) *Spec
+  } {
+    {
+      // Variants are pooled in the spec which renders the page
+      //
+      name: "pooled variants",
+      setup: func (root *Spec) *Spec {
+        subspec := root.AddSubspec(NewSpec("subspec", nil))
+        subspec.Props["responsive_images"] = true
+        subspec.EnqueueTaskFunc("emit", emitDocuments(true, true))
+        return subspec
+      },
+    },
+    {
+      // Variants are output by a sibling spec, and found in its
+      // AssetFrame
+      //
+      name: "subspec frames",
+      setup: func (root *Spec) *Spec {
+        root.Props["responsive_images"] = true
+        images := root.AddSubspec(NewSpec("images", nil))
+        pages  := root.AddSubspec(NewSpec("pages", nil))
+        images.EnqueueTaskFunc("emit", emitDocuments(true, false))
+        pages.EnqueueTaskFunc("emit", emitDocuments(false, true))
+        return root
+      },
+    },
+  }
+
+  for _, test_case := range test_cases {
+    t.Run(test_case.name, func (t *testing.T) {
+      root := NewSpec("root", nil)
+      root.Props["quiet"] = true
+
+      var build_spec = test_case.setup(root)
+      var received   = make(map[string]*Asset)
+
+      root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+        if err := tk.PoolSpecInputAssets(); err != nil {
+          return err
+        }
+        for _, asset := range tk.Assets {
+          assets, err := asset.Flatten()
+          if err != nil { return err }
+          for _, asset := range assets {
+            asset_path := strings.TrimLeft(asset.Url.Path, "/")
+            received[strings.TrimPrefix(asset_path, "@emit/")] = asset
+          }
+        }
+        return nil
+      })
+
+      root.AddSpecBuilder(BuildTaskResponsiveImages)
+      if err := build_spec.Build(); err != nil {
+        t.Fatal(err)
+      }
+
+      TestWrapTimeoutError(t, root.Run)
+
+      if got, expect := len(received), len(images) + 1; got != expect {
+        t.Errorf("Expected %d assets, got %d: %v", expect, got, received)
+      }
+
+      index, found := received["index.html"]
+      if !found {
+        t.Fatalf("Expected index.html, got %v", received)
+      }
+
+      content, err := index.GetContentBytes()
+      if err != nil { t.Fatal(err) }
+
+      for _, element := range expect {
+        if !strings.Contains(string(content), element) {
+          t.Errorf("Expected index.html to contain:\n%s\ngot:\n%s", element, content)
+        }
+      }
+    })
+  }
+}
@@ -1,20 +1,19 @@
 package behaviors
 
 import (
+  "bytes"
+  "encoding/json"
   "fmt"
   . "gilchrist.tech/interbuilder"
-  "sync"
-  "path"
   "path/filepath"
   "os"
+  "regexp"
+  "slices"
+  "strconv"
   "strings"
 )
 
 
-
-var DownloaderMutex sync.Mutex
-
-
 var TaskResolverSourceGitClone = TaskResolver {
   Id: "source-git-clone",
   Name: "git-clone", // TODO: consider renaming to source-get-git
@@ -25,10 +24,29 @@ var TaskResolverSourceGitClone = TaskResolver {
   },
 }
 
+/*
+  TaskSourceGitClone clones the spec's "source" repository into its
+  source_dir. If source_dir already exists, it is used as-is,
+  unless a ref is requested, in which case it must be a checkout of
+  that ref. It reads the following props:
+
+    - source_ref:     A branch, tag, or commit to check out.
+      Defaults to the repository's default branch.
+    - git_depth:      If set, a shallow clone with this many
+      commits of history.
+    - git_submodules: If true, submodules are cloned recursively,
+      with the same depth.
+    - git_cache:      Inherited. A directory of repository
+      mirrors which clones reference, so that specs and runs
+      cloning the same repository only fetch its changes. True
+      selects a directory in the user's cache directory.
+    - git_bin:        Inherited. The git executable. Defaults to
+      "git".
+
+  Clones into the same source_dir, and uses of the same mirror,
+  are locked so that only one runs at a time.
+*/
 func TaskSourceGitClone (s *Spec, t *Task) error {
-  DownloaderMutex.Lock()
-  defer DownloaderMutex.Unlock()
-
   source, err := s.RequirePropUrl("source")
   if err != nil { return err }
 
@@ -44,20 +62,179 @@ func TaskSourceGitClone (s *Spec, t *Task) error {
   source_dir, err = filepath.Abs(source_dir)
   if err != nil { return err }
 
+  defer lockPathMutex(source_dir)()
+
+  options, err := gitCloneOptionsFromProps(s)
+  if err != nil { return err }
+
+  git, err := inheritExecutable(s, "git_bin", "git")
+  if err != nil { return err }
+
   // Check whether source directory already exists;
   // exit if it exists or if an error occurred.
-  // TODO: check for .git/ existence and `git status --porcelain`
   //
-  if exists, err := s.PathExists("./"); exists || err != nil {
+  if exists, err := s.PathExists("./"); err != nil {
     return err
+  } else if exists {
+    if options.Ref == "" {
+      return nil
+    }
+    return gitVerifyCheckout(t, git, source_dir, options.Ref)
   }
 
   if err := os.MkdirAll(source_dir, os.ModePerm); err != nil {
     return err
   }
 
-  _, err = t.CommandRun("git", "clone", source.String(), source_dir)
-  return err
+  cache_dir, err := gitCacheDir(s)
+  if err != nil { return err }
+
+  if cache_dir != "" {
+    var mirror = GitMirrorPath(cache_dir, source.String())
+
+    unlock, err := lockFile(mirror + ".lock")
+    if err != nil { return err }
+    defer unlock()
+
+    if err := gitUpdateMirror(t, git, source.String(), mirror); err != nil {
+      return err
+    }
+    options.Reference = mirror
+  }
+
+  for _, args := range options.Commands(source.String(), source_dir) {
+    if _, err := t.CommandRun(git, args...); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+
+/*
+  GitCloneOptions select what TaskSourceGitClone checks out.
+  Reference is a local repository whose objects are copied rather
+  than fetched, such as a mirror in the git cache.
+*/
+type GitCloneOptions struct {
+  Ref         string
+  Depth       int
+  Submodules  bool
+  Reference   string
+}
+
+
+func gitCloneOptionsFromProps (s *Spec) (GitCloneOptions, error) {
+  var options GitCloneOptions
+  var ok, found bool
+
+  if options.Ref, ok, found = s.GetPropString("source_ref"); found && !ok {
+    return options, fmt.Errorf("Prop \"source_ref\" in spec %s is expected to be a string, got %T", s.Name, s.Props["source_ref"])
+  } else if strings.HasPrefix(options.Ref, "-") {
+    return options, fmt.Errorf("Prop \"source_ref\" in spec %s is not a valid ref, got %s", s.Name, options.Ref)
+  }
+
+  if options.Depth, ok, found = s.GetPropInt("git_depth"); found && (!ok || options.Depth < 0) {
+    return options, fmt.Errorf("Prop \"git_depth\" in spec %s is expected to be a positive integer, got %v", s.Name, s.Props["git_depth"])
+  }
+
+  if options.Submodules, ok, found = s.GetPropBool("git_submodules"); found && !ok {
+    return options, fmt.Errorf("Prop \"git_submodules\" in spec %s is expected to be a boolean, got %T", s.Name, s.Props["git_submodules"])
+  }
+
+  return options, nil
+}
+
+
+var git_commit_pattern = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+
+/*
+  Commands returns the arguments of the git commands which clone a
+  repository into a directory with these options. Branches and
+  tags are cloned directly. Refs which look like commit hashes
+  cannot be, so the repository is cloned without a checkout, and
+  the commit is checked out. With a depth, full hashes are
+  fetched alone instead, but abbreviated hashes cannot be fetched,
+  and are cloned in full, as are commits with a reference.
+*/
+func (o GitCloneOptions) Commands (repository, dir string) [][]string {
+  var depth []string
+  if o.Depth > 0 {
+    depth = []string { "--depth", strconv.Itoa(o.Depth) }
+  }
+
+  // The clone copies the reference's objects, rather than
+  // depending on them, in case the cache is removed
+  //
+  var reference []string
+  if o.Reference != "" {
+    reference = []string { "--reference", o.Reference, "--dissociate" }
+  }
+
+  if !git_commit_pattern.MatchString(o.Ref) {
+    var args = []string { "clone" }
+    if o.Ref != "" {
+      args = append(args, "--branch", o.Ref)
+    }
+    args = append(args, depth...)
+    args = append(args, reference...)
+    if o.Submodules {
+      args = append(args, "--recurse-submodules")
+      if o.Depth > 0 {
+        args = append(args, "--shallow-submodules")
+      }
+    }
+    return [][]string { append(args, "--", repository, dir) }
+  }
+
+  var commands [][]string
+  if o.Depth > 0 && o.Reference == "" && (len(o.Ref) == 40 || len(o.Ref) == 64) {
+    commands = [][]string {
+      { "-C", dir, "init", "--quiet" },
+      { "-C", dir, "remote", "add", "origin", repository },
+      append(append([]string { "-C", dir, "fetch", "--quiet" }, depth...), "origin", o.Ref),
+      { "-C", dir, "checkout", "--quiet", "FETCH_HEAD" },
+    }
+  } else {
+    commands = [][]string {
+      slices.Concat([]string { "clone", "--no-checkout" }, reference, []string { "--", repository, dir }),
+      { "-C", dir, "checkout", "--quiet", o.Ref },
+    }
+  }
+
+  if o.Submodules {
+    commands = append(commands, append([]string { "-C", dir, "submodule", "update", "--init", "--recursive" }, depth...))
+  }
+  return commands
+}
+
+
+/*
+  gitVerifyCheckout returns an error if a directory is not a git
+  checkout whose HEAD is at a ref.
+*/
+func gitVerifyCheckout (t *Task, git, dir, ref string) error {
+  head, err := t.Command(git, "-C", dir, "rev-parse", "HEAD").Output()
+  if err != nil {
+    return fmt.Errorf("Existing source_dir %s is not a git checkout of %s: %w", dir, ref, err)
+  }
+
+  // Tags are peeled to the commit they point to. Branches other
+  // than the one checked out only exist as remote branches.
+  //
+  ref_commit, err := t.Command(git, "-C", dir, "rev-parse", "--verify", "--quiet", ref + "^{commit}").Output()
+  if err != nil {
+    ref_commit, err = t.Command(git, "-C", dir, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/" + ref + "^{commit}").Output()
+  }
+  if err != nil {
+    return fmt.Errorf("Existing checkout in %s does not have ref %s, remove it to clone again", dir, ref)
+  }
+
+  if got, expect := strings.TrimSpace(string(head)), strings.TrimSpace(string(ref_commit)); got != expect {
+    return fmt.Errorf("Existing checkout in %s is at %s, not %s (%s), remove it to clone again", dir, got, ref, expect)
+  }
+  return nil
 }
 
 
@@ -89,6 +266,7 @@ var TaskResolverInferSource = TaskResolver {
 var TaskResolverInferSourceNodeJS = TaskResolver {
   Id:   "source-infer-nodejs",
   Name: "source-infer",
+  Next: &TaskResolverInferSourceGo,
   MatchFunc: func (name string, sp *Spec) (bool, error) {
     return sp.PathExists("package.json")
   },
@@ -109,20 +287,134 @@ var TaskResolverSourceInstallNodeJS = TaskResolver {
   TaskPrototype: Task { Func: TaskSourceInstallNodeJS },
 }
 
-func TaskSourceInstallNodeJS (s *Spec, t *Task) error {
-  DownloaderMutex.Lock()
-  defer DownloaderMutex.Unlock()
+/*
+  nodejs_lockfiles maps lockfiles to the package managers which
+  write them, in order of detection.
+*/
+var nodejs_lockfiles = []struct { File, PackageManager string } {
+  { "pnpm-lock.yaml",    "pnpm" },
+  { "yarn.lock",         "yarn" },
+  { "bun.lockb",         "bun"  },
+  { "bun.lock",          "bun"  },
+  { "package-lock.json", "npm"  },
+}
 
-  install_cmd := [] string {"npm", "i"}
 
-  if lock_exists, _ := s.PathExists("package.lock"); lock_exists {
-    install_cmd[1] = "ci"
+/*
+  nodeJSInstallCommand returns the command which installs a
+  NodeJS package's dependencies. The package manager is read from
+  the "package_manager" prop, or detected from the package's
+  lockfile, defaulting to npm. If the package has a lockfile for
+  the package manager, the lockfile is installed exactly, with
+  --immutable for Yarn 2 and later.
+*/
+func nodeJSInstallCommand (s *Spec) ([]string, error) {
+  package_manager, ok, found := s.InheritPropString("package_manager")
+  if found && !ok {
+    return nil, fmt.Errorf("Prop \"package_manager\" in spec %s is expected to be a string, got %T", s.Name, s.Props["package_manager"])
   }
 
+  var locked bool
+
+  for _, lockfile := range nodejs_lockfiles {
+    if package_manager != "" && lockfile.PackageManager != package_manager {
+      continue
+    }
+
+    exists, err := s.PathExists(lockfile.File)
+    if err != nil { return nil, err }
+
+    if exists {
+      package_manager = lockfile.PackageManager
+      locked          = true
+      break
+    }
+  }
+
+  switch package_manager {
+    case "", "npm":
+      if locked {
+        return []string { "npm", "ci" }, nil
+      }
+      return []string { "npm", "i" }, nil
+
+    case "yarn":
+      if !locked {
+        return []string { "yarn", "install" }, nil
+      }
+
+      // Yarn 2 and later replace --frozen-lockfile with --immutable
+      //
+      berry, err := isYarnBerry(s)
+      if err != nil {
+        return nil, err
+      } else if berry {
+        return []string { "yarn", "install", "--immutable" }, nil
+      }
+      return []string { "yarn", "install", "--frozen-lockfile" }, nil
+
+    case "pnpm", "bun":
+      if locked {
+        return []string { package_manager, "install", "--frozen-lockfile" }, nil
+      }
+      return []string { package_manager, "install" }, nil
+  }
+
+  return nil, fmt.Errorf("Prop \"package_manager\" in spec %s is not one of npm, pnpm, yarn, or bun, got %s", s.Name, package_manager)
+}
+
+
+/*
+  isYarnBerry returns whether a NodeJS package uses Yarn 2 or
+  later, "Berry", as configured by a .yarnrc.yml file, or by a
+  Yarn version of 2 or later in the packageManager field of its
+  package.json.
+*/
+func isYarnBerry (s *Spec) (bool, error) {
+  if exists, err := s.PathExists(".yarnrc.yml"); err != nil || exists {
+    return exists, err
+  }
+
+  package_path, err := s.GetKeyPath("package.json")
+  if err != nil { return false, err }
+
+  content, err := os.ReadFile(package_path)
+  if os.IsNotExist(err) {
+    return false, nil
+  } else if err != nil {
+    return false, err
+  }
+
+  var package_json struct {
+    PackageManager string `json:"packageManager"`
+  }
+  if err := json.Unmarshal(content, &package_json); err != nil {
+    return false, fmt.Errorf("Cannot parse package.json in spec %s: %w", s.Name, err)
+  }
+
+  version, is_yarn := strings.CutPrefix(package_json.PackageManager, "yarn@")
+  if !is_yarn {
+    return false, nil
+  }
+
+  major, _, _ := strings.Cut(version, ".")
+  major_version, err := strconv.Atoi(major)
+  return err == nil && major_version >= 2, nil
+}
+
+
+func TaskSourceInstallNodeJS (s *Spec, t *Task) error {
+  unlock, err := lockSourceDir(s)
+  if err != nil { return err }
+  defer unlock()
+
   if node_modules_exists, _ := s.PathExists("node_modules"); node_modules_exists {
     return nil
   }
 
+  install_cmd, err := nodeJSInstallCommand(s)
+  if err != nil { return err }
+
   prop_install_cmd, ok, found := s.GetPropString("install_cmd")
   if found && ok {
     install_cmd = strings.Split(prop_install_cmd, " ")
@@ -146,30 +438,40 @@ var TaskResolverSourceBuildNodeJS = TaskResolver {
 }
 
 
+/*
+  TaskSourceBuildNodeJS emits the build directory of a NodeJS
+  package, running `npm run build` if it does not already exist.
+  The build directory is read from the "emit" prop, as a path or
+  an object with a "dir" path, then from an output directory flag
+  in the package.json build script, then from the outDir of a Vite
+  configuration or the output.path of a webpack configuration,
+  falling back to "dist" or "build".
+*/
 func TaskSourceBuildNodeJS (sp *Spec, tk *Task) error {
-  // Check if build path already exists and emit it, if so
-  //
-
-  // TODO: check props: emit (as a filepath-like string), emit.dir (string)
   // TODO: this should accept @source assets
 
-  var check_paths = []string {
-    "dist",
-    "build",
-  }
+  check_paths, configured, err := nodeJSBuildDirs(sp)
+  if err != nil { return err }
 
-  for _, path := range check_paths {
-    if dist_exists, err := sp.PathExists(path); err != nil {
-      return err
+  // Check if build path already exists and emit it, if so
+  //
+  var emitBuildDir = func () (bool, error) {
+    for _, path := range check_paths {
+      if dist_exists, err := sp.PathExists(path); err != nil {
+        return false, err
 
-    } else if dist_exists {
-      dist_asset, err := sp.MakeFileKeyAsset(path, "/")
-      if err != nil { return err }
+      } else if dist_exists {
+        dist_asset, err := sp.MakeFileKeyAsset(path, "/")
+        if err != nil { return false, err }
 
-      err = tk.EmitAsset(dist_asset)
-      if err != nil { return err }
-      return nil
+        return true, tk.EmitAsset(dist_asset)
+      }
     }
+    return false, nil
+  }
+
+  if emitted, err := emitBuildDir(); emitted || err != nil {
+    return err
   }
 
   // Run build command
@@ -180,21 +482,15 @@ func TaskSourceBuildNodeJS (sp *Spec, tk *Task) error {
 
   // TODO: emit @emit assets
 
-  for _, path := range check_paths {
-    if dist_exists, err := sp.PathExists(path); err != nil {
-      return err
-
-    } else if dist_exists {
-      dist_asset, err := sp.MakeFileKeyAsset(path, "/")
-      if err != nil { return err }
+  if emitted, err := emitBuildDir(); emitted || err != nil {
+    return err
+  }
 
-      err = tk.EmitAsset(dist_asset)
-      if err != nil { return err }
-      return nil
-    }
+  if configured {
+    return fmt.Errorf("NodeJS build directory %s does not exist in spec %s after building", check_paths[0], sp.Name)
   }
 
-  _, err := tk.EnqueueTaskName("infer-assets")
+  _, err = tk.EnqueueTaskName("infer-assets")
   return err
 }
 
@@ -203,6 +499,15 @@ func TaskConsumeLinkFiles (s *Spec, task *Task) error {
   source_dir, err := s.RequirePropString("source_dir")
   if err != nil { return err }
 
+  mode, err := outputMode(s)
+  if err != nil { return err }
+
+  preserve, ok, found := s.InheritPropBool("output_preserve")
+  if found && !ok {
+    prop, _ := s.InheritProp("output_preserve")
+    return fmt.Errorf("Prop \"output_preserve\" in spec %s is expected to be a boolean, got %T", s.Name, prop)
+  }
+
   // Remove directory contents, if it exists
   //
   if stat, _ := os.Stat(source_dir); stat != nil {
@@ -220,13 +525,15 @@ func TaskConsumeLinkFiles (s *Spec, task *Task) error {
     if err != nil { return err }
   }
 
-  // TODO: find a way not to have to load everything into memory
+  // Pooled asset content is staged to disk beyond the
+  // asset_buffer_limit prop, bounding memory for large sites
+  //
   if err := task.PoolSpecInputAssets(); err != nil {
     return fmt.Errorf("Cannot pool assets to write/link files, encountered error: %w", err)
   }
 
   for _, input := range task.Assets {
-    assets, err := input.Flatten()
+    assets, err := input.FlattenContext(task.Context())
     if err != nil { return err }
 
     for _, asset := range assets {
@@ -246,20 +553,22 @@ func TaskConsumeLinkFiles (s *Spec, task *Task) error {
         continue
       }
 
-      var dest string  = filepath.Join(source_dir, key)
-      var directory, _ = path.Split(dest)
-      if err != nil { return err }
+      var dest string = filepath.Join(source_dir, KeyToPath(key))
 
-      err = os.MkdirAll(directory, os.ModePerm)
+      err = os.MkdirAll(filepath.Dir(dest), os.ModePerm)
       if err != nil { return err }
 
-      // In the filesystem, either link the asset's source file,
-      // or if the asset is moified, copy the new content into
-      // this spec's source_dir
+      // In the filesystem, either link or copy the asset's
+      // source file, or if the asset is modified, write the new
+      // content into this spec's source_dir
       //
+      perm, mod_time, err := assetFileAttributes(asset, preserve)
+      if err != nil { return err }
+
       if asset.ContentModified == false {
-        err = os.Link(asset.FileSource, dest)
-        if err != nil { return err }
+        if err := outputFile(mode, asset.FileSource, dest, perm, mod_time); err != nil {
+          return err
+        }
 
         new_asset := s.AnnexAsset(asset)
         new_asset.FileSource = dest
@@ -267,14 +576,11 @@ func TaskConsumeLinkFiles (s *Spec, task *Task) error {
           return err
         }
       } else {
-        content, err := asset.GetContentBytes()
-        if err != nil { return err }
-
-        new_asset   := s.AnnexAsset(asset)
-        writer, err := new_asset.ContentBytesGetWriter()
+        content, err := asset.EncodedContentBytes()
         if err != nil { return err }
 
-        if _, err := writer.Write(content); err != nil {
+        new_asset := s.AnnexAsset(asset)
+        if err := writeFileAtomic(new_asset.FileDest, bytes.NewReader(content), perm, mod_time); err != nil {
           return err
         }
 
@@ -7,9 +7,12 @@ import (
   "strings"
 
   "io"
+  "os/exec"
   "os"
   "path/filepath"
   "fmt"
+  "slices"
+  "time"
 )
 
 
@@ -134,6 +137,95 @@ func TestTaskInferSourceNodeJS (t *testing.T) {
 }
 
 
+func TestNodeJSInstallCommand (t *testing.T) {
+  var cases = []struct {
+    Name           string
+    Files          []string
+    PackageJson    string
+    PackageManager string
+    Expect         []string
+  } {
+    { Name: "no lockfile",     Expect: []string { "npm", "i" } },
+    { Name: "npm lockfile",    Files: []string { "package-lock.json" }, Expect: []string { "npm", "ci" } },
+    { Name: "pnpm lockfile",   Files: []string { "pnpm-lock.yaml" },    Expect: []string { "pnpm", "install", "--frozen-lockfile" } },
+    { Name: "yarn lockfile",   Files: []string { "yarn.lock" },         Expect: []string { "yarn", "install", "--frozen-lockfile" } },
+    { Name: "bun lockfile",    Files: []string { "bun.lockb" },         Expect: []string { "bun", "install", "--frozen-lockfile" } },
+    {
+      Name:   "yarn berry rc file",
+      Files:  []string { "yarn.lock", ".yarnrc.yml" },
+      Expect: []string { "yarn", "install", "--immutable" },
+    },
+    {
+      Name:        "yarn berry package manager",
+      Files:       []string { "yarn.lock" },
+      PackageJson: `{ "packageManager": "yarn@4.1.0" }`,
+      Expect:      []string { "yarn", "install", "--immutable" },
+    },
+    {
+      Name:        "yarn classic package manager",
+      Files:       []string { "yarn.lock" },
+      PackageJson: `{ "packageManager": "yarn@1.22.19" }`,
+      Expect:      []string { "yarn", "install", "--frozen-lockfile" },
+    },
+    {
+      Name:           "yarn berry without lockfile",
+      Files:          []string { ".yarnrc.yml" },
+      PackageManager: "yarn",
+      Expect:         []string { "yarn", "install" },
+    },
+    {
+      Name:           "override with another lockfile",
+      Files:          []string { "package-lock.json" },
+      PackageManager: "pnpm",
+      Expect:         []string { "pnpm", "install" },
+    },
+    {
+      Name:           "override with its lockfile",
+      Files:          []string { "package-lock.json", "yarn.lock" },
+      PackageManager: "yarn",
+      Expect:         []string { "yarn", "install", "--frozen-lockfile" },
+    },
+  }
+
+  for _, c := range cases {
+    t.Run(c.Name, func (t *testing.T) {
+      spec := NewSpec("node", nil)
+      spec.Props["source_dir"] = t.TempDir()
+      if c.PackageManager != "" {
+        spec.Props["package_manager"] = c.PackageManager
+      }
+
+      for _, key := range c.Files {
+        if err := spec.WriteFile(key, []byte{}, 0o660); err != nil {
+          t.Fatal(err)
+        }
+      }
+      if c.PackageJson != "" {
+        if err := spec.WriteFile("package.json", []byte(c.PackageJson), 0o660); err != nil {
+          t.Fatal(err)
+        }
+      }
+
+      install_cmd, err := nodeJSInstallCommand(spec)
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      if !slices.Equal(install_cmd, c.Expect) {
+        t.Errorf("Expected install command %v, got %v", c.Expect, install_cmd)
+      }
+    })
+  }
+
+  invalid := NewSpec("invalid", nil)
+  invalid.Props["source_dir"]      = t.TempDir()
+  invalid.Props["package_manager"] = "pip"
+  if _, err := nodeJSInstallCommand(invalid); err == nil {
+    t.Errorf("Expected an error with an unknown package manager")
+  }
+}
+
+
 func TestTaskConsumeLinkFilesSingularFiles (t *testing.T) {
   // Create root spec
   //
@@ -346,3 +438,342 @@ func TestTaskConsumeLinkFilesModifiedFile (t *testing.T) {
     t.Errorf("File %s has content \"%s\", expected \"%s\"", "modified.txt", content, expect)
   }
 }
+
+
+func TestTaskConsumeLinkFilesModes (t *testing.T) {
+  var mod_time = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+  for _, mode := range []string { "link", "copy", "reflink", "symlink" } {
+    t.Run(mode, func (t *testing.T) {
+      var consume *Spec = NewSpec("consume", nil)
+      var produce *Spec = consume.AddSubspec(NewSpec("produce", nil))
+
+      var output_dir string = t.TempDir()
+      consume.Props["quiet"]           = true
+      consume.Props["source_dir"]      = output_dir
+      consume.Props["output_mode"]     = mode
+      consume.Props["output_preserve"] = true
+      produce.Props["source_dir"]      = t.TempDir()
+
+      var source_path string
+      produce.EnqueueTaskFunc("produce", func (s *Spec, tk *Task) error {
+        if err := s.WriteFile("file.txt", []byte("content"), 0o640); err != nil {
+          return err
+        }
+        source_path = filepath.Join(s.Props["source_dir"].(string), "file.txt")
+        if err := os.Chtimes(source_path, mod_time, mod_time); err != nil {
+          return err
+        }
+        return s.EmitFileKey("file.txt")
+      })
+
+      consume.EnqueueTaskFunc("consume", TaskConsumeLinkFiles)
+      TestWrapTimeoutError(t, consume.Run)
+
+      var output_path = filepath.Join(output_dir, "file.txt")
+      if content, err := os.ReadFile(output_path); err != nil || string(content) != "content" {
+        t.Fatalf("Expected the output file to have the source's content, got %q, %v", content, err)
+      }
+
+      entries, _ := os.ReadDir(output_dir)
+      if len(entries) != 1 {
+        t.Errorf("Expected only the output file, without temporary files, got %d entries", len(entries))
+      }
+
+      lstat, err := os.Lstat(output_path)
+      if err != nil { t.Fatal(err) }
+      source_stat, err := os.Stat(source_path)
+      if err != nil { t.Fatal(err) }
+
+      switch mode {
+      case "link":
+        if !os.SameFile(lstat, source_stat) {
+          t.Error("Expected the output file to be a hard link")
+        }
+      case "symlink":
+        if target, err := os.Readlink(output_path); err != nil || target != source_path {
+          t.Errorf("Expected the output file to link to %s, got %s, %v", source_path, target, err)
+        }
+      default:
+        if os.SameFile(lstat, source_stat) {
+          t.Error("Expected the output file to be a copy")
+        }
+        if lstat.Mode().Perm() != 0o640 || !lstat.ModTime().Equal(mod_time) {
+          t.Errorf("Expected the mode and modification time to be preserved, got %v, %v", lstat.Mode().Perm(), lstat.ModTime())
+        }
+      }
+    })
+  }
+
+  var spec = NewSpec("spec", nil)
+  spec.Props["output_mode"] = "move"
+  if _, err := outputMode(spec); err == nil {
+    t.Error("Expected an invalid output_mode to be an error")
+  }
+}
+
+
+func TestTaskConsumeLinkFilesPreserveMetadata (t *testing.T) {
+  var mod_time = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+  var consume *Spec = NewSpec("consume", nil)
+  var produce *Spec = consume.AddSubspec(NewSpec("produce", nil))
+
+  var output_dir string = t.TempDir()
+  consume.Props["quiet"]             = true
+  consume.Props["source_dir"]        = output_dir
+  consume.Props["output_mode"]       = "copy"
+  consume.Props["preserve_metadata"] = true
+  produce.Props["source_dir"]        = t.TempDir()
+
+  // Metadata is captured when the assets are made, so it is kept
+  // even once their source files change
+  //
+  produce.EnqueueTaskFunc("produce", func (s *Spec, tk *Task) error {
+    var assets []*Asset
+    for _, key := range []string { "run.sh", "modified.sh" } {
+      if err := s.WriteFile(key, []byte("#!/bin/sh\n"), 0o644); err != nil {
+        return err
+      }
+      file_path, _ := s.GetKeyPath(key)
+      if err := os.Chmod(file_path, 0o750); err != nil {
+        return err
+      }
+      if err := os.Chtimes(file_path, mod_time, mod_time); err != nil {
+        return err
+      }
+
+      asset, err := s.MakeFileKeyAsset(key)
+      if err != nil { return err }
+      assets = append(assets, asset)
+
+      if err := os.Chmod(file_path, 0o600); err != nil {
+        return err
+      }
+    }
+
+    assets[1].SetContentBytes([]byte("#!/bin/sh\necho modified\n"))
+    for _, asset := range assets {
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  consume.EnqueueTaskFunc("consume", TaskConsumeLinkFiles)
+  TestWrapTimeoutError(t, consume.Run)
+
+  for _, key := range []string { "run.sh", "modified.sh" } {
+    stat, err := os.Stat(filepath.Join(output_dir, key))
+    if err != nil { t.Fatal(err) }
+
+    if stat.Mode().Perm() != 0o750 {
+      t.Errorf("Expected %s to keep its captured mode, got %v", key, stat.Mode().Perm())
+    }
+
+    // Modified content is not given the old modification time
+    //
+    if kept := stat.ModTime().Equal(mod_time); kept != (key == "run.sh") {
+      t.Errorf("Expected %s to keep its modification time: %v, got %v", key, key == "run.sh", stat.ModTime())
+    }
+  }
+}
+
+
+func TestTaskSourceGitClone (t *testing.T) {
+  if _, err := exec.LookPath("git"); err != nil {
+    t.Skip("git is not installed")
+  }
+
+  var dir = t.TempDir()
+
+  // Cloning submodules from file paths must be allowed
+  //
+  var env = map[string]any {
+    "GIT_CONFIG_COUNT":   "1",
+    "GIT_CONFIG_KEY_0":   "protocol.file.allow",
+    "GIT_CONFIG_VALUE_0": "always",
+  }
+
+  // git runs a git command in the test directory
+  //
+  var git = func (args ...string) string {
+    t.Helper()
+    cmd := exec.Command("git", append([]string {
+      "-c", "user.name=Test", "-c", "user.email=test@example.com",
+      "-c", "init.defaultBranch=main", "-c", "protocol.file.allow=always",
+    }, args...)...)
+    cmd.Dir = dir
+    output, err := cmd.CombinedOutput()
+    if err != nil {
+      t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, output)
+    }
+    return strings.TrimSpace(string(output))
+  }
+
+  // The upstream repository has a tag, a later commit with a
+  // submodule, and a branch
+  //
+  git("init", "--quiet", "module")
+  if err := os.WriteFile(filepath.Join(dir, "module", "module.txt"), []byte("module"), 0o644); err != nil {
+    t.Fatal(err)
+  }
+  git("-C", "module", "add", "module.txt")
+  git("-C", "module", "commit", "--quiet", "-m", "Module")
+
+  git("init", "--quiet", "site.git")
+  for _, content := range []string { "one", "two" } {
+    if err := os.WriteFile(filepath.Join(dir, "site.git", "index.html"), []byte(content), 0o644); err != nil {
+      t.Fatal(err)
+    }
+    git("-C", "site.git", "add", "index.html")
+    git("-C", "site.git", "commit", "--quiet", "-m", content)
+  }
+  git("-C", "site.git", "tag", "v1", "HEAD~1")
+  git("-C", "site.git", "submodule", "--quiet", "add", filepath.Join(dir, "module"), "module")
+  git("-C", "site.git", "commit", "--quiet", "-m", "Add module")
+  git("-C", "site.git", "branch", "next", "HEAD~1")
+  var first_commit = git("-C", "site.git", "rev-parse", "v1")
+
+  var clone = func (source_dir string, props map[string]any) error {
+    t.Helper()
+
+    root    := NewSpec("root", nil)
+    subspec := root.AddSubspec(NewSpec("site", nil))
+
+    root.Props["quiet"] = true
+    root.Props["env"]   = env
+    subspec.Props["source"]     = "file://" + filepath.ToSlash(filepath.Join(dir, "site.git"))
+    subspec.Props["source_dir"] = source_dir
+    for key, value := range props {
+      subspec.Props[key] = value
+    }
+
+    if err := BuildSourceURLType(subspec); err != nil {
+      t.Fatal(err)
+    }
+    if err := subspec.EnqueueTaskFunc("git-clone", TaskSourceGitClone); err != nil {
+      t.Fatal(err)
+    }
+    return root.Run()
+  }
+
+  var readFile = func (file_path string) string {
+    t.Helper()
+    content, _ := os.ReadFile(file_path)
+    return string(content)
+  }
+
+  var test_cases = []struct {
+    name     string
+    props    map[string]any
+    content  string
+    module   bool
+    commits  string
+  } {
+    { name: "default branch", content: "two", commits: "3" },
+    { name: "branch", props: map[string]any { "source_ref": "next", "git_depth": 1 }, content: "two", commits: "1" },
+    { name: "tag", props: map[string]any { "source_ref": "v1" }, content: "one", commits: "1" },
+    { name: "commit", props: map[string]any { "source_ref": first_commit, "git_depth": 1 }, content: "one", commits: "1" },
+    { name: "abbreviated commit", props: map[string]any { "source_ref": first_commit[:12], "git_depth": 1 }, content: "one", commits: "1" },
+    { name: "submodules", props: map[string]any { "git_submodules": true, "git_depth": 2 }, content: "two", module: true, commits: "2" },
+  }
+
+  for _, test_case := range test_cases {
+    t.Run(test_case.name, func (t *testing.T) {
+      var source_dir = filepath.Join(t.TempDir(), "site")
+      if err := clone(source_dir, test_case.props); err != nil {
+        t.Fatal(err)
+      }
+
+      if content := readFile(filepath.Join(source_dir, "index.html")); content != test_case.content {
+        t.Errorf("Expected index.html to be %q, got %q", test_case.content, content)
+      }
+      if content := readFile(filepath.Join(source_dir, "module", "module.txt")); test_case.module != (content == "module") {
+        t.Errorf("Expected the submodule to be cloned: %v, got %q", test_case.module, content)
+      }
+      if commits := git("-C", source_dir, "rev-list", "--count", "HEAD"); commits != test_case.commits {
+        t.Errorf("Expected %s commits of history, got %s", test_case.commits, commits)
+      }
+    })
+  }
+
+  // An existing checkout is verified against the requested ref
+  //
+  var source_dir = filepath.Join(t.TempDir(), "site")
+  if err := clone(source_dir, map[string]any { "source_ref": "v1" }); err != nil {
+    t.Fatal(err)
+  }
+  if err := clone(source_dir, map[string]any { "source_ref": first_commit }); err != nil {
+    t.Errorf("Expected the existing checkout to match %s, got %v", first_commit, err)
+  }
+  if err := clone(source_dir, map[string]any { "source_ref": "main" }); err == nil || !strings.Contains(err.Error(), "is at " + first_commit) {
+    t.Errorf("Expected the existing checkout not to match main, got %v", err)
+  }
+
+  if err := clone(t.TempDir(), map[string]any { "source_ref": "main" }); err == nil {
+    t.Error("Expected a directory which is not a checkout to fail verification")
+  }
+  if err := clone(filepath.Join(t.TempDir(), "site"), map[string]any { "git_depth": "1" }); err == nil {
+    t.Error("Expected a string git_depth to be an error")
+  }
+}
+
+
+func TestTaskSourceNodeJSMockCommands (t *testing.T) {
+  var root = NewSpec("root", nil)
+  var site = root.AddSubspec(NewSpec("site", nil))
+
+  root.Props["quiet"]      = true
+  site.Props["source_dir"] = t.TempDir()
+
+  if err := site.WriteFile("package-lock.json", []byte("{}"), 0o660); err != nil {
+    t.Fatal(err)
+  }
+
+  // The build writes its output, as npm would
+  //
+  var runner = & MockCommandRunner {
+    Handler: func (cmd *RecordedCommand) MockCommandResult {
+      if cmd.String() == "npm run build" {
+        if err := os.MkdirAll(filepath.Join(cmd.Dir, "dist"), 0o755); err != nil {
+          return MockCommandResult { Stderr: err.Error(), ExitCode: 1 }
+        }
+        if err := os.WriteFile(filepath.Join(cmd.Dir, "dist", "index.html"), []byte("<p>Built</p>"), 0o644); err != nil {
+          return MockCommandResult { Stderr: err.Error(), ExitCode: 1 }
+        }
+      }
+      return MockCommandResult {}
+    },
+  }
+  root.SetCommandRunner(runner)
+
+  site.EnqueueTaskFunc("source-install-nodejs", TaskSourceInstallNodeJS)
+  site.EnqueueTaskFunc("source-build-nodejs",   TaskSourceBuildNodeJS)
+
+  var received []string
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    for asset_chunk := range s.Input {
+      assets, err := asset_chunk.Flatten()
+      if err != nil { return err }
+      for _, asset := range assets {
+        received = append(received, asset.Url.Path)
+      }
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var commands []string
+  for _, cmd := range runner.Commands() {
+    commands = append(commands, cmd.String())
+  }
+  if got := strings.Join(commands, ", "); got != "npm ci, npm run build" {
+    t.Errorf("Expected npm ci and npm run build, got %s", got)
+  }
+  if !slices.Equal(received, []string { "@emit/index.html" }) {
+    t.Errorf("Expected the build output to be emitted, got %v", received)
+  }
+}
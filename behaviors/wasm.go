@@ -0,0 +1,194 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "github.com/tetratelabs/wazero"
+  "github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+  "github.com/tetratelabs/wazero/sys"
+
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+)
+
+
+/*
+  wasm_compilation_cache is shared by the runtimes of WASM plugins,
+  so that a module run by several tasks is compiled once.
+*/
+var wasm_compilation_cache = wazero.NewCompilationCache()
+
+
+/*
+  TaskResolverWasm is the prototype of resolvers whose tasks run a
+  WASM plugin: a WebAssembly module, compiled from any language
+  targeting WASI, which transforms assets as described in
+  RunWasmPlugin. Resolvers for a module are made with
+  NewTaskResolverWasm, or by a config task with a "protocol" of
+  "wasm".
+*/
+var TaskResolverWasm = TaskResolver {
+  Id:   "wasm",
+  Name: "wasm",
+  TaskPrototype: Task {
+    Mask: TASK_ASSETS_MUTATE | TASK_ASSETS_FILTER | TASK_ASSETS_GENERATE,
+  },
+}
+
+
+/*
+  NewTaskResolverWasm returns a copy of TaskResolverWasm which
+  resolves tasks with a name, which run a module as a WASM plugin.
+  The module path and arguments are expanded as in
+  Task.ExpandTemplate.
+*/
+func NewTaskResolverWasm (name string, module_path string, args ...string) *TaskResolver {
+  var resolver = TaskResolverWasm
+  resolver.Id   = "wasm-" + name
+  resolver.Name = name
+  resolver.MatchFunc = func (task_name string, s *Spec) (bool, error) {
+    return task_name == name, nil
+  }
+  resolver.TaskPrototype.Func = wasmTaskFunc(append([]string { module_path }, args...))
+  return &resolver
+}
+
+
+/*
+  wasmTaskFunc returns a TaskFunc which expands a module path and
+  its arguments, and runs it as a WASM plugin.
+*/
+func wasmTaskFunc (command []string) TaskFunc {
+  return func (s *Spec, tk *Task) error {
+    if len(command) == 0 || command[0] == "" {
+      return fmt.Errorf("WASM task %s has no module", tk.Name)
+    }
+    var args = make([]string, len(command))
+    for i, arg := range command {
+      expanded, err := tk.ExpandTemplate(arg)
+      if err != nil { return err }
+      args[i] = expanded
+    }
+    return RunWasmPlugin(tk, args[0], args[1:]...)
+  }
+}
+
+
+/*
+  RunWasmPlugin runs a WebAssembly module as a WASM plugin,
+  sending it the assets of the task, and emitting the assets it
+  returns. A relative module path is in the spec's source_dir.
+
+  The ABI of a plugin is a WASI (wasi_snapshot_preview1) command
+  module, whose _start function speaks the exec plugin protocol of
+  RunExecPlugin on its stdin and stdout: it reads the "start",
+  "asset", and "end" messages, and writes "asset", "log", and
+  "error" messages, as NDJSON. Its arguments are the module's base
+  name followed by args, and what it writes to stderr is printed.
+  Exiting with a non-zero code fails the task.
+
+  Plugins are sandboxed: they have clocks, but no filesystem,
+  network, or environment variables, so assets are only exchanged
+  as messages. Cancelling the task closes the module.
+*/
+func RunWasmPlugin (tk *Task, module_path string, args ...string) error {
+  if !filepath.IsAbs(module_path) {
+    if source_dir, ok, _ := tk.Spec.InheritPropString("source_dir"); ok && source_dir != "" {
+      module_path = filepath.Join(source_dir, module_path)
+    }
+  }
+  var name = filepath.Base(module_path)
+
+  module_bytes, err := os.ReadFile(module_path)
+  if err != nil {
+    return fmt.Errorf("Cannot read WASM plugin %s: %w", module_path, err)
+  }
+
+  var inputs = make(map[string]*Asset)
+  var assets []*Asset
+  for _, chunk := range tk.Assets {
+    flattened, err := chunk.FlattenContext(tk.Context())
+    if err != nil { return err }
+    for _, asset := range flattened {
+      assets = append(assets, asset)
+      inputs[asset.Url.String()] = asset
+    }
+  }
+  tk.Assets = nil
+
+  var ctx = tk.Context()
+
+  var runtime_config = wazero.NewRuntimeConfig().
+    WithCompilationCache(wasm_compilation_cache).
+    WithCloseOnContextDone(true)
+  var runtime = wazero.NewRuntimeWithConfig(ctx, runtime_config)
+  defer runtime.Close(ctx)
+
+  if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+    return fmt.Errorf("Cannot instantiate WASI for WASM plugin %s: %w", name, err)
+  }
+
+  compiled, err := runtime.CompileModule(ctx, module_bytes)
+  if err != nil {
+    return fmt.Errorf("Cannot compile WASM plugin %s: %w", name, err)
+  }
+
+  stdin_reader,  stdin_writer  := io.Pipe()
+  stdout_reader, stdout_writer := io.Pipe()
+  stderr := tk.CommandOutput("stderr")
+  defer stderr.Flush()
+
+  var module_config = wazero.NewModuleConfig().
+    WithName("").
+    WithArgs(append([]string { name }, args...)...).
+    WithStdin(stdin_reader).
+    WithStdout(stdout_writer).
+    WithStderr(stderr).
+    WithSysWalltime().
+    WithSysNanotime().
+    WithSysNanosleep()
+
+  // Write messages and run the module concurrently with reading
+  // its output, so that plugins which stream their output do not
+  // block. When the module exits, its stdin is closed, as plugins
+  // need not read each asset, and its stdout, to end the reading
+  //
+  var write_err = make(chan error, 1)
+  go func () {
+    write_err <- writeExecInput(stdin_writer, tk, assets)
+  }()
+
+  var run_err = make(chan error, 1)
+  go func () {
+    _, err := runtime.InstantiateModule(ctx, compiled, module_config)
+    stdin_reader.CloseWithError(os.ErrClosed)
+    stdout_writer.Close()
+    run_err <- err
+  }()
+
+  var plugin_err = readExecOutput(tk, name, stdout_reader, inputs)
+  if plugin_err != nil {
+    stdin_reader.CloseWithError(os.ErrClosed)
+    runtime.Close(ctx)
+  }
+  io.Copy(io.Discard, stdout_reader)
+
+  var exit_err = <-run_err
+  if plugin_err != nil {
+    return plugin_err
+  }
+  if err := <-write_err; err != nil && !errors.Is(err, os.ErrClosed) {
+    return fmt.Errorf("Cannot write to WASM plugin %s: %w", name, err)
+  }
+
+  var exit *sys.ExitError
+  if errors.As(exit_err, &exit) && exit.ExitCode() == 0 {
+    return nil
+  } else if exit_err != nil {
+    return fmt.Errorf("WASM plugin %s failed: %w", name, exit_err)
+  }
+  return nil
+}
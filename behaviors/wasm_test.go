@@ -0,0 +1,213 @@
+package behaviors
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+/*
+  wasm_test_plugin is the source of a Go WASM plugin, which
+  upper-cases HTML assets and emits a greeting from the "greeting"
+  prop, as the exec plugin of TestTaskExecPlugin does.
+*/
+const wasm_test_plugin = `package main
+
+import (
+  "bufio"
+  "encoding/json"
+  "os"
+  "strings"
+)
+
+func main () {
+  var greeting string
+  var output = json.NewEncoder(os.Stdout)
+  var scanner = bufio.NewScanner(os.Stdin)
+  scanner.Buffer(nil, 1 << 24)
+
+  if _, err := os.ReadFile("/etc/passwd"); err == nil {
+    output.Encode(map[string]any { "type": "error", "message": "the filesystem is not sandboxed" })
+    return
+  }
+
+  for scanner.Scan() {
+    var message map[string]any
+    if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+      panic(err)
+    }
+
+    switch message["type"] {
+    case "start":
+      props := message["props"].(map[string]any)
+      if props["fail"] == true {
+        output.Encode(map[string]any { "type": "error", "message": "failed on purpose" })
+        return
+      }
+      if props["exit"] == true {
+        os.Exit(3)
+      }
+      greeting, _ = props["greeting"].(string)
+      if len(os.Args) > 1 {
+        greeting += os.Args[1]
+      }
+    case "asset":
+      if message["mimetype"] != "text/html" {
+        continue
+      }
+      var content []byte
+      json.Unmarshal([]byte("\"" + message["content"].(string) + "\""), &content)
+      output.Encode(map[string]any {
+        "type":    "asset",
+        "url":     message["url"],
+        "content": []byte(strings.ToUpper(string(content))),
+      })
+    case "end":
+      output.Encode(map[string]any { "type": "log", "message": "done" })
+      output.Encode(map[string]any { "type": "asset", "url": "greeting.txt", "text": greeting })
+    }
+  }
+}
+`
+
+
+/*
+  buildWasmTestPlugin compiles wasm_test_plugin for WASI into a
+  directory, or skips the test if Go is not installed.
+*/
+func buildWasmTestPlugin (t *testing.T, dir string) string {
+  t.Helper()
+
+  go_bin, err := exec.LookPath("go")
+  if err != nil {
+    t.Skip("go is not installed")
+  }
+
+  var plugin_dir = filepath.Join(t.TempDir(), "plugin")
+  if err := os.MkdirAll(plugin_dir, os.ModePerm); err != nil {
+    t.Fatal(err)
+  }
+  if err := os.WriteFile(filepath.Join(plugin_dir, "go.mod"), []byte("module example.com/wasmplugin\n\ngo 1.21\n"), 0o644); err != nil {
+    t.Fatal(err)
+  }
+  if err := os.WriteFile(filepath.Join(plugin_dir, "main.go"), []byte(wasm_test_plugin), 0o644); err != nil {
+    t.Fatal(err)
+  }
+
+  var module_path = filepath.Join(dir, "plugin.wasm")
+  var cmd = exec.Command(go_bin, "build", "-o", module_path, ".")
+  cmd.Dir = plugin_dir
+  cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm", "GOFLAGS=", "GOWORK=off")
+  if output, err := cmd.CombinedOutput(); err != nil {
+    t.Fatalf("Cannot build the WASM test plugin: %v\n%s", err, output)
+  }
+  return module_path
+}
+
+
+func TestTaskWasmPlugin (t *testing.T) {
+  var source_dir = t.TempDir()
+  buildWasmTestPlugin(t, source_dir)
+
+  var run = func (command any, props map[string]any) (map[string]string, error) {
+    root := NewSpec("root", nil)
+    site := root.AddSubspec(NewSpec("site", nil))
+
+    root.Props["quiet"]    = true
+    root.Props["greeting"] = "Hello"
+    site.Props["source_dir"] = source_dir
+    site.Props["tasks"] = []any {
+      map[string]any {
+        "name":     "shout",
+        "command":  command,
+        "protocol": "wasm",
+        "after":    []any { "emit" },
+      },
+    }
+    for key, value := range props {
+      site.Props[key] = value
+    }
+    site.AddSpecBuilder(BuildConfigTasks)
+
+    site.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+      for key, mimetype := range map[string]string { "index.html": "text/html", "notes.txt": "text/plain" } {
+        asset := s.MakeAsset(key)
+        asset.Mimetype = mimetype
+        asset.SetContentBytes([]byte("<p>" + key + "</p>"))
+        if err := tk.EmitAsset(asset); err != nil {
+          return err
+        }
+      }
+      return nil
+    })
+
+    var received = make(map[string]string)
+    root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+      if err := tk.PoolSpecInputAssets(); err != nil {
+        return err
+      }
+      for _, input := range tk.Assets {
+        assets, err := input.Flatten()
+        if err != nil { return err }
+        for _, asset := range assets {
+          content, err := asset.GetContentBytes()
+          if err != nil { return err }
+          received[strings.TrimPrefix(asset.Url.Path, "@emit/")] = string(content)
+        }
+      }
+      return nil
+    })
+
+    if err := site.Build(); err != nil {
+      t.Fatal(err)
+    }
+    return received, root.Run()
+  }
+
+  var test_cases = []struct {
+    Command   any
+    Greeting  string
+  }{
+    { Command: "plugin.wasm",                           Greeting: "Hello" },
+    { Command: []any { "plugin.wasm", "{{prop \"punctuation\"}}" }, Greeting: "Hello!" },
+  }
+
+  for test_case_i, test_case := range test_cases {
+    received, err := run(test_case.Command, map[string]any { "punctuation": "!" })
+    if err != nil {
+      t.Errorf("Test case %d: unexpected error: %v", test_case_i, err)
+      continue
+    }
+
+    var expect = map[string]string {
+      "index.html":   "<P>INDEX.HTML</P>",
+      "greeting.txt": test_case.Greeting,
+    }
+    if len(received) != len(expect) {
+      t.Errorf("Test case %d: expected the assets %v, got %v", test_case_i, expect, received)
+    }
+    for key, content := range expect {
+      if received[key] != content {
+        t.Errorf("Test case %d: expected %s to be %q, got %q", test_case_i, key, content, received[key])
+      }
+    }
+  }
+
+  // Plugins fail the task with an error message, or by exiting
+  // with an error
+  //
+  if _, err := run("plugin.wasm", map[string]any { "fail": true }); err == nil || !strings.Contains(err.Error(), "failed on purpose") {
+    t.Errorf("Expected the plugin's error, got %v", err)
+  }
+  if _, err := run("plugin.wasm", map[string]any { "exit": true }); err == nil || !strings.Contains(err.Error(), "exit_code(3)") {
+    t.Errorf("Expected the plugin's exit code, got %v", err)
+  }
+  if _, err := run("missing.wasm", nil); err == nil {
+    t.Error("Expected a missing module to be an error")
+  }
+}
@@ -0,0 +1,422 @@
+package interbuilder
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "io/fs"
+  "net/url"
+  "os"
+  "path"
+  "path/filepath"
+  "sync"
+  "time"
+)
+
+
+/*
+  A checkpoint records the progress of a Spec run in a state
+  directory: the Tasks which have finished, the Assets the Spec has
+  output, and whether the run completed. When a run is resumed, a
+  Spec with a complete checkpoint re-outputs its recorded Assets
+  instead of running its tasks and subspecs.
+
+  Checkpoints are enabled with the inherited "state_dir" prop, and
+  resuming with the inherited "resume" prop. The root Spec is not
+  checkpointed, as its outputs are outside of the Spec tree.
+*/
+type checkpoint struct {
+  lock    sync.Mutex
+  dir     string
+  record  checkpointRecord
+}
+
+
+type checkpointRecord struct {
+  Spec      string             `json:"spec"`
+  Props     string             `json:"props,omitempty"`
+  Complete  bool               `json:"complete"`
+  Partial   bool               `json:"partial,omitempty"`
+  Tasks     []string           `json:"tasks"`
+  Assets    []checkpointAsset  `json:"assets"`
+}
+
+
+type checkpointAsset struct {
+  Url       string       `json:"url"`
+  Path      string       `json:"path"`
+  Mimetype  string       `json:"mimetype,omitempty"`
+  File      string       `json:"file,omitempty"`
+  Hash      string       `json:"hash,omitempty"`
+  Mode      os.FileMode  `json:"mode,omitempty"`
+  Encoding  string       `json:"encoding,omitempty"`
+  ModTime   *time.Time   `json:"mod_time,omitempty"`
+}
+
+
+const CHECKPOINT_FILE = "checkpoint.json"
+
+
+/*
+  SpecPath returns the names of this Spec and its parents,
+  starting from the root, joined by slashes. Names are path
+  escaped.
+*/
+func (s *Spec) SpecPath () string {
+  if s.Parent == nil {
+    return url.PathEscape(s.Name)
+  }
+  return s.Parent.SpecPath() + "/" + url.PathEscape(s.Name)
+}
+
+
+/*
+  checkpoint_run_props are props which control how a run is
+  checkpointed or logged, rather than what it builds, and which are
+  not fingerprinted, so that a run may set them to resume.
+*/
+var checkpoint_run_props = []string { "resume", "state_dir", "log_files", "quiet" }
+
+
+/*
+  propsFingerprint returns a hash of the props this Spec resolves,
+  including those inherited from its ancestors, so that a
+  checkpoint is not resumed after its Spec's configuration
+  changes. Props which cannot be encoded as JSON are not
+  fingerprinted.
+*/
+func (s *Spec) propsFingerprint () string {
+  var props = make(map[string]any)
+  for spec := s; spec != nil; spec = spec.Parent {
+    for key, value := range spec.Props {
+      if _, found := props[key]; !found {
+        props[key] = value
+      }
+    }
+  }
+  for _, key := range checkpoint_run_props {
+    delete(props, key)
+  }
+
+  props_json, err := json.Marshal(props)
+  if err != nil {
+    return ""
+  }
+  sum := sha256.Sum256(props_json)
+  return hex.EncodeToString(sum[:])
+}
+
+
+/*
+  startCheckpoint begins recording this Spec's checkpoint, if the
+  "state_dir" prop is inherited. If the run is being resumed and
+  the Spec's checkpoint is complete, the recorded Assets are
+  output, and true is returned, meaning the Spec should not run.
+*/
+func (s *Spec) startCheckpoint () (resumed bool, err error) {
+  if s.Parent == nil {
+    return false, nil
+  }
+
+  state_dir, ok, found := s.InheritPropString("state_dir")
+  if !found || state_dir == "" {
+    return false, nil
+  } else if !ok {
+    return false, fmt.Errorf("Prop \"state_dir\" in Spec %s is not a string", s.Name)
+  }
+
+  cp := & checkpoint {
+    dir: filepath.Join(state_dir, url.PathEscape(s.SpecPath())),
+    record: checkpointRecord {
+      Spec:   s.SpecPath(),
+      Props:  s.propsFingerprint(),
+      Tasks:  []string {},
+      Assets: []checkpointAsset {},
+    },
+  }
+
+  if resume, _, _ := s.InheritPropBool("resume"); resume {
+    record, err := cp.read()
+    if err != nil {
+      return false, fmt.Errorf("Error reading checkpoint of Spec %s: %w", s.Name, err)
+    }
+
+    // A partial checkpoint, which could not record every asset,
+    // is run again instead
+    //
+    if record != nil && record.Complete && !record.Partial && record.Props == cp.record.Props {
+      s.Printf("[%s] Resuming from checkpoint\n", s.Name)
+      s.emitEvent(Event { Type: EVENT_SPEC_RESUME })
+      return true, s.outputCheckpointAssets(cp.dir, record)
+    }
+  }
+
+  // Start a new checkpoint. Checkpoints are not nested in the
+  // directories of their parents, so clearing this one keeps the
+  // checkpoints of subspecs, which may be resumed.
+  //
+  if err := os.RemoveAll(cp.dir); err != nil {
+    return false, fmt.Errorf("Error clearing checkpoint of Spec %s: %w", s.Name, err)
+  }
+  if err := os.MkdirAll(filepath.Join(cp.dir, "assets"), 0o755); err != nil {
+    return false, fmt.Errorf("Error creating checkpoint of Spec %s: %w", s.Name, err)
+  }
+
+  s.checkpoint = cp
+  return false, cp.write()
+}
+
+
+/*
+  outputCheckpointAssets outputs the Assets recorded in a
+  checkpoint, with their content read from the state directory,
+  or from the Spec's ContentStore.
+*/
+func (s *Spec) outputCheckpointAssets (dir string, record *checkpointRecord) error {
+  mmap_threshold, err := s.mmapThreshold()
+  if err != nil { return err }
+
+  for _, recorded := range record.Assets {
+    asset_url, err := url.Parse(recorded.Url)
+    if err != nil {
+      return fmt.Errorf("Error parsing checkpointed asset URL %s: %w", recorded.Url, err)
+    }
+
+    // Emitted asset paths may not have a leading slash, which is
+    // not preserved by a URL string
+    //
+    asset_url.Path    = recorded.Path
+    asset_url.RawPath = ""
+
+    var file_path = filepath.Join(dir, filepath.FromSlash(recorded.File))
+
+    var asset = & Asset {
+      Url:        asset_url,
+      Spec:       s,
+      Mimetype:   recorded.Mimetype,
+      FileSource: file_path,
+      FileMode:   recorded.Mode,
+      History:    & HistoryEntry {
+        Url:     asset_url,
+        Parents: [] *HistoryEntry { &s.History },
+        Time:    time.Now(),
+      },
+    }
+
+    if recorded.ModTime != nil {
+      asset.FileModTime = *recorded.ModTime
+    }
+
+    // The recorded content is encoded, as the Asset's was stored
+    //
+    asset.ContentEncoding = recorded.Encoding
+
+    if recorded.Hash != "" {
+      cs, err := s.ContentStore()
+      if err != nil {
+        return err
+      } else if cs == nil {
+        return fmt.Errorf("Checkpointed asset %s is in a content store, but Spec %s has no content_store prop", recorded.Path, s.Name)
+      }
+
+      asset.FileSource = cs.Path(recorded.Hash)
+      err = asset.setContentStoreReader(cs, recorded.Hash)
+    } else {
+      err = asset.SetContentBytesGetReaderFunc(func (*Asset) (io.Reader, error) {
+        reader, err := openFileReader(file_path, mmap_threshold)
+        if err != nil {
+          return nil, fmt.Errorf("Error reading checkpointed asset content: %w", err)
+        }
+        return reader, nil
+      })
+    }
+    if err != nil {
+      return err
+    }
+
+    if err := s.OutputAsset(asset); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+
+/*
+  recordCheckpointAsset writes an output Asset's content to this
+  Spec's checkpoint, if one is being recorded. Content which is not
+  already in memory is streamed from the Asset's reader, rather
+  than read into it. Generator and channel assets can only be
+  expanded by their receiver, so they are not recorded, and the
+  checkpoint is marked partial instead.
+*/
+func (s *Spec) recordCheckpointAsset (a *Asset) error {
+  cp := s.checkpoint
+  if cp == nil {
+    return nil
+  }
+
+  if a.IsMulti() {
+    var access = a.TypeMask & ASSET_FIELDS_ACCESS
+    if access & (ASSET_MULTI_GENERATOR | ASSET_MULTI_CHAN) != 0 {
+      cp.lock.Lock()
+      cp.record.Partial = true
+      cp.lock.Unlock()
+      return nil
+    }
+
+    assets, err := a.Expand()
+    if err != nil {
+      return fmt.Errorf("Error recording checkpoint of Spec %s: %w", s.Name, err)
+    }
+    for _, asset := range assets {
+      if err := s.recordCheckpointAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  }
+
+  // With a content store, content is recorded by its hash in it,
+  // so that content unchanged between runs is only written once
+  //
+  cs, err := s.ContentStore()
+  if err != nil {
+    return err
+  }
+
+  // Captured file metadata is recorded, as resumed assets are
+  // read from the checkpoint's own files
+  //
+  var mod_time *time.Time
+  if !a.FileModTime.IsZero() {
+    var recorded_time = a.FileModTime
+    mod_time = &recorded_time
+  }
+
+  var recorded = checkpointAsset {
+    Url:      a.Url.String(),
+    Path:     a.Url.Path,
+    Mimetype: a.Mimetype,
+    Mode:     a.FileMode,
+    Encoding: a.ContentEncoding,
+    ModTime:  mod_time,
+  }
+
+  reader, err := a.EncodedContentReader()
+  if err != nil {
+    return fmt.Errorf("Error recording asset %s in checkpoint of Spec %s: %w", a.Url, s.Name, err)
+  }
+  if closer, ok := reader.(io.Closer); ok {
+    defer closer.Close()
+  }
+
+  if cs != nil {
+    if recorded.Hash, err = cs.PutReader(reader); err != nil {
+      return fmt.Errorf("Error recording asset %s in checkpoint of Spec %s: %w", a.Url, s.Name, err)
+    }
+
+    cp.lock.Lock()
+    cp.record.Assets = append(cp.record.Assets, recorded)
+    cp.lock.Unlock()
+    return nil
+  }
+
+  cp.lock.Lock()
+  recorded.File = fmt.Sprintf("assets/%d%s", len(cp.record.Assets), path.Ext(a.Url.Path))
+  cp.record.Assets = append(cp.record.Assets, recorded)
+  cp.lock.Unlock()
+
+  file, err := os.Create(filepath.Join(cp.dir, filepath.FromSlash(recorded.File)))
+  if err == nil {
+    _, err = io.Copy(file, reader)
+    if close_err := file.Close(); err == nil {
+      err = close_err
+    }
+  }
+  if err != nil {
+    return fmt.Errorf("Error recording asset %s in checkpoint of Spec %s: %w", a.Url, s.Name, err)
+  }
+
+  return nil
+}
+
+
+/*
+  checkpointTask records that a Task in this Spec has finished.
+*/
+func (s *Spec) checkpointTask (tk *Task) error {
+  cp := s.checkpoint
+  if cp == nil {
+    return nil
+  }
+
+  cp.lock.Lock()
+  cp.record.Tasks = append(cp.record.Tasks, tk.Name)
+  cp.lock.Unlock()
+
+  return cp.write()
+}
+
+
+/*
+  completeCheckpoint marks this Spec's checkpoint as complete, so
+  that a resumed run can skip it.
+*/
+func (s *Spec) completeCheckpoint () error {
+  cp := s.checkpoint
+  if cp == nil {
+    return nil
+  }
+
+  cp.lock.Lock()
+  cp.record.Complete = true
+  cp.lock.Unlock()
+
+  return cp.write()
+}
+
+
+func (cp *checkpoint) read () (*checkpointRecord, error) {
+  record_json, err := os.ReadFile(filepath.Join(cp.dir, CHECKPOINT_FILE))
+  if errors.Is(err, fs.ErrNotExist) {
+    return nil, nil
+  } else if err != nil {
+    return nil, err
+  }
+
+  var record checkpointRecord
+  if err := json.Unmarshal(record_json, &record); err != nil {
+    return nil, err
+  }
+  return &record, nil
+}
+
+
+/*
+  write saves the checkpoint record, replacing the previous one
+  atomically so that an interrupted write does not corrupt it.
+*/
+func (cp *checkpoint) write () error {
+  cp.lock.Lock()
+  record_json, err := json.MarshalIndent(cp.record, "", "  ")
+  cp.lock.Unlock()
+  if err != nil {
+    return err
+  }
+
+  var record_path = filepath.Join(cp.dir, CHECKPOINT_FILE)
+  var temp_path   = record_path + ".tmp"
+
+  if err := os.WriteFile(temp_path, record_json, 0o644); err != nil {
+    return fmt.Errorf("Error writing checkpoint: %w", err)
+  }
+  if err := os.Rename(temp_path, record_path); err != nil {
+    return fmt.Errorf("Error writing checkpoint: %w", err)
+  }
+  return nil
+}
@@ -0,0 +1,255 @@
+package interbuilder
+
+import (
+  "testing"
+  "context"
+  "fmt"
+  "sort"
+  "net/url"
+  "os"
+  "path"
+  "path/filepath"
+  "time"
+)
+
+
+func TestSpecCheckpointResume (t *testing.T) {
+  var state_dir = t.TempDir()
+
+  var runs_a, runs_b int
+  var captured_time = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+  var resumed_mode os.FileMode
+  var resumed_time time.Time
+
+  // Build a tree where subspec "a" emits an asset and subspec "b"
+  // may fail, and return the content of the assets the root
+  // receives
+  //
+  var makeTree = func (b_fails, resume bool) (*Spec, *map[string]string) {
+    root := NewSpec("root", nil)
+    root.Props["quiet"]     = true
+    root.Props["state_dir"] = state_dir
+    root.Props["resume"]    = resume
+
+    a := root.AddSubspec(NewSpec("a", nil))
+    b := root.AddSubspec(NewSpec("b", nil))
+
+    a.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+      runs_a++
+      asset := s.MakeAsset("a.txt")
+      asset.Mimetype    = "text/plain"
+      asset.FileMode    = 0o750
+      asset.FileModTime = captured_time
+      asset.SetContentBytes([]byte("content a"))
+      return s.EmitAsset(asset)
+    })
+
+    b.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+      runs_b++
+      if b_fails {
+        // Fail once the checkpoint of "a" is complete, so that it
+        // is not cancelled
+        //
+        a_checkpoint := & checkpoint {
+          dir: filepath.Join(state_dir, url.PathEscape(a.SpecPath())),
+        }
+        for {
+          if record, _ := a_checkpoint.read(); record != nil && record.Complete {
+            break
+          }
+          time.Sleep(time.Millisecond)
+        }
+        return fmt.Errorf("Expected error")
+      }
+      asset := s.MakeAsset("b.txt")
+      asset.SetContentBytes([]byte("content b"))
+      return s.EmitAsset(asset)
+    })
+
+    var received = make(map[string]string)
+
+    root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+      for asset := range s.Input {
+        content, err := asset.GetContentBytes()
+        if err != nil {
+          return err
+        }
+        received[asset.Url.Path] = string(content)
+        if asset.Url.Path == "@emit/a.txt" {
+          resumed_mode, resumed_time = asset.FileMode, asset.FileModTime
+        }
+      }
+      return nil
+    })
+
+    return root, &received
+  }
+
+  // The first run fails in subspec "b", after "a" has finished
+  //
+  root, _ := makeTree(true, false)
+  TestWrapTimeout(t, func () {
+    if err := root.Run(); err == nil {
+      t.Error("Expected the first run to fail")
+    }
+  })
+
+  // Resuming skips "a", but its assets are still received
+  //
+  root, received := makeTree(false, true)
+  TestWrapTimeoutError(t, root.Run)
+
+  if runs_a != 1 || runs_b != 2 {
+    t.Errorf("Expected subspec a to run once and b twice, got %d and %d", runs_a, runs_b)
+  }
+
+  var paths []string
+  for path := range *received {
+    paths = append(paths, path)
+  }
+  sort.Strings(paths)
+
+  if fmt.Sprint(paths) != "[@emit/a.txt @emit/b.txt]" {
+    t.Errorf("Unexpected assets received after resuming: %v", paths)
+  }
+  if got := (*received)["@emit/a.txt"]; got != "content a" {
+    t.Errorf("Expected resumed asset content \"content a\", got %q", got)
+  }
+  if resumed_mode != 0o750 || !resumed_time.Equal(captured_time) {
+    t.Errorf("Expected the resumed asset to keep its file metadata, got %v, %v", resumed_mode, resumed_time)
+  }
+
+  // A run which does not resume runs every spec again
+  //
+  root, _ = makeTree(false, false)
+  TestWrapTimeoutError(t, root.Run)
+
+  if runs_a != 2 {
+    t.Errorf("Expected subspec a to run again without resuming, ran %d times", runs_a)
+  }
+
+  // Changing a spec's props invalidates its checkpoint
+  //
+  root, _ = makeTree(false, true)
+  root.Subspecs["a"].Props["changed"] = true
+  TestWrapTimeoutError(t, root.Run)
+
+  if runs_a != 3 || runs_b != 3 {
+    t.Errorf("Expected only subspec a to run after its props changed, got %d and %d runs", runs_a, runs_b)
+  }
+
+  // Changing a prop inherited from an ancestor invalidates the
+  // checkpoints of its subspecs
+  //
+  root, _ = makeTree(false, true)
+  root.Subspecs["a"].Props["changed"] = true
+  root.Props["base_url"] = "https://example.com/"
+  TestWrapTimeoutError(t, root.Run)
+
+  if runs_a != 4 || runs_b != 4 {
+    t.Errorf("Expected both subspecs to run after an inherited prop changed, got %d and %d runs", runs_a, runs_b)
+  }
+}
+
+
+func TestSpecCheckpointStreamsAssets (t *testing.T) {
+  var state_dir  = t.TempDir()
+  var source_dir = t.TempDir()
+
+  if err := os.WriteFile(filepath.Join(source_dir, "file.txt"), []byte("file content"), 0o644); err != nil {
+    t.Fatal(err)
+  }
+
+  var runs, generator_starts int
+
+  var makeTree = func (resume bool) (*Spec, *map[string]string) {
+    root := NewSpec("root", nil)
+    root.Props["quiet"]     = true
+    root.Props["state_dir"] = state_dir
+    root.Props["resume"]    = resume
+
+    a := root.AddSubspec(NewSpec("a", nil))
+    a.Props["source_dir"] = source_dir
+
+    a.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+      runs++
+      if err := s.EmitFileKey("file.txt"); err != nil {
+        return err
+      }
+
+      generator := s.MakeAsset("generated")
+      generator.SetAssetGenerator(func (ctx context.Context, _ *Asset) (func () (*Asset, error), error) {
+        generator_starts++
+        var done bool
+        return func () (*Asset, error) {
+          if done {
+            return nil, nil
+          }
+          done = true
+          asset := s.MakeAsset("generated.txt")
+          asset.SetContentBytes([]byte("generated content"))
+          return asset, nil
+        }, nil
+      })
+      return s.EmitAsset(generator)
+    })
+
+    var received = make(map[string]string)
+
+    root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+      for input := range s.Input {
+        assets, err := input.Flatten()
+        if err != nil {
+          return err
+        }
+        for _, asset := range assets {
+          // Recording the checkpoint streams file content, and does
+          // not read it into the asset
+          //
+          if path.Base(asset.Url.Path) == "file.txt" && asset.ContentBytes != nil {
+            t.Errorf("Expected the checkpoint not to read file content into the asset")
+          }
+          content, err := asset.GetContentBytes()
+          if err != nil {
+            return err
+          }
+          received[path.Base(asset.Url.Path)] = string(content)
+        }
+      }
+      return nil
+    })
+
+    return root, &received
+  }
+
+  root, received := makeTree(false)
+  TestWrapTimeoutError(t, root.Run)
+
+  if generator_starts != 1 {
+    t.Errorf("Expected the generator to only be started by its receiver, started %d times", generator_starts)
+  }
+  if got := (*received)["file.txt"]; got != "file content" {
+    t.Errorf("Expected the file asset content, got %q", got)
+  }
+  if got := (*received)["generated.txt"]; got != "generated content" {
+    t.Errorf("Expected the generated asset content, got %q", got)
+  }
+
+  // A checkpoint which could not record its generated assets is
+  // partial, and its spec runs again rather than resuming
+  //
+  cp := & checkpoint { dir: filepath.Join(state_dir, url.PathEscape(root.Subspecs["a"].SpecPath())) }
+  if record, err := cp.read(); err != nil || record == nil || !record.Complete || !record.Partial {
+    t.Fatalf("Expected a complete, partial checkpoint, got %+v (%v)", record, err)
+  }
+
+  root, received = makeTree(true)
+  TestWrapTimeoutError(t, root.Run)
+
+  if runs != 2 {
+    t.Errorf("Expected a spec with a partial checkpoint to run again, ran %d times", runs)
+  }
+  if got := (*received)["generated.txt"]; got != "generated content" {
+    t.Errorf("Expected the generated asset after resuming, got %q", got)
+  }
+}
@@ -0,0 +1,65 @@
+package interbuilder
+
+
+/*
+  Clone returns a copy of this Spec's definition, named new_name,
+  so that one pipeline can be built more than once, such as for
+  several branches of a repository with different "source_ref"
+  props. Its props are copied, including nested objects and
+  arrays, along with its SpecBuilders, TaskResolvers, path
+  transformations, emit middleware, and subspecs, which are
+  cloned with their own names.
+
+  The clone has no parent, and is added to a tree with
+  AddSubspec. Runtime state is not copied: the clone has not run,
+  and has none of this Spec's queued Tasks, event handlers, or
+  Console. Tasks are added by builders, so a Spec should be
+  cloned before it is built, and each clone built.
+*/
+func (s *Spec) Clone (new_name string) *Spec {
+  var clone = NewSpec(new_name, nil)
+
+  for key, value := range s.Props {
+    clone.Props[key] = copyPropValue(value)
+  }
+
+  clone.SpecBuilders = append(clone.SpecBuilders, s.SpecBuilders...)
+
+  // TaskResolvers are copied along with their children, as adding
+  // the originals would link the two Specs' resolver lists
+  //
+  var last_resolver *TaskResolver
+  for tr := s.TaskResolvers ; tr != nil ; tr = tr.Next {
+    var tr_copy = copyTaskResolverTree(tr)
+    if last_resolver == nil {
+      clone.TaskResolvers = tr_copy
+    } else {
+      last_resolver.Next = tr_copy
+    }
+    last_resolver = tr_copy
+  }
+
+  for _, pt := range s.PathTransformations {
+    var pt_copy = *pt
+    if pt.RewriteMap != nil {
+      pt_copy.RewriteMap = make(map[string]string, len(pt.RewriteMap))
+      for from, to := range pt.RewriteMap {
+        pt_copy.RewriteMap[from] = to
+      }
+    }
+    clone.PathTransformations = append(clone.PathTransformations, &pt_copy)
+  }
+
+  s.emit_middleware_lock.RLock()
+  var middleware = append([]EmitMiddleware(nil), s.emit_middleware...)
+  s.emit_middleware_lock.RUnlock()
+  for _, m := range middleware {
+    clone.UseEmitMiddleware(m)
+  }
+
+  for name, subspec := range s.Subspecs {
+    clone.AddSubspec(subspec.Clone(name))
+  }
+
+  return clone
+}
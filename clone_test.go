@@ -0,0 +1,94 @@
+package interbuilder
+
+import (
+  "sort"
+  "strings"
+  "testing"
+)
+
+
+func TestSpecClone (t *testing.T) {
+  // A site definition, which emits a page naming its branch, is
+  // cloned for two branches
+  //
+  var site = NewSpec("site", nil)
+  site.Props["source_ref"] = "main"
+  site.Props["options"]    = map[string]any { "tags": []any { "a" } }
+
+  site.AddTaskResolver(& TaskResolver {
+    Id: "clone-test", Name: "emit-branch",
+    TaskPrototype: Task {
+      Func: func (s *Spec, tk *Task) error {
+        ref, _, _ := s.GetPropString("source_ref")
+        asset := s.MakeAsset(ref + ".html")
+        asset.SetContentBytes([]byte(s.Name))
+        return tk.EmitAsset(asset)
+      },
+    },
+  })
+
+  var built []string
+  site.AddSpecBuilder(func (s *Spec) error {
+    built = append(built, s.Name)
+    _, err := s.EnqueueTaskName("emit-branch")
+    return err
+  })
+
+  transformations, err := PathTransformationsFromAny("s`^/?`branches/`")
+  if err != nil { t.Fatal(err) }
+  site.PathTransformations = transformations
+
+  site.UseEmitMiddleware(func (a *Asset, next func (*Asset) error) error {
+    a.Mimetype = "text/x-branch"
+    return next(a)
+  })
+
+  site.AddSubspec(NewSpec("docs", nil))
+
+  var root = NewSpec("root", nil)
+  root.Props["quiet"] = true
+
+  for _, ref := range []string { "main", "dev" } {
+    var clone = site.Clone("site-" + ref)
+    clone.Props["source_ref"] = ref
+    clone.Props["options"].(map[string]any)["tags"].([]any)[0] = ref
+    root.AddSubspec(clone)
+
+    if clone.Subspecs["docs"] == nil || clone.Subspecs["docs"] == site.Subspecs["docs"] || clone.Subspecs["docs"].Parent != clone {
+      t.Errorf("Expected the clone to have its own docs subspec")
+    }
+    if err := clone.Build(); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if site.Props["source_ref"] != "main" || site.Props["options"].(map[string]any)["tags"].([]any)[0] != "a" {
+    t.Errorf("Expected the original's props to be unmodified, got %v", site.Props)
+  }
+  if site.TaskResolvers.Next != nil || site.Tasks != nil {
+    t.Errorf("Expected the original's resolvers and tasks to be unmodified")
+  }
+  if got := strings.Join(built, " "); got != "site-main site-dev" {
+    t.Errorf("Expected each clone to be built, got %s", got)
+  }
+
+  var received []string
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, asset := range tk.Assets {
+      content, _ := asset.GetContentBytes()
+      received = append(received, asset.Url.Path + "=" + string(content) + "," + asset.Mimetype)
+    }
+    sort.Strings(received)
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var expect = "@emit/branches/dev.html=site-dev,text/x-branch @emit/branches/main.html=site-main,text/x-branch"
+  if got := strings.Join(received, " "); got != expect {
+    t.Errorf("Expected %s, got %s", expect, got)
+  }
+}
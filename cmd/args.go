@@ -8,23 +8,85 @@ import (
   "strings"
   "fmt"
   "regexp"
+  "sync"
+  "encoding/json"
 )
 
 
 var Flag_print_spec    bool
 var Flag_outputs       []string
 var Flag_inputs        []string
+var Flag_state_dir     string
+var Flag_resume        bool
+var Flag_log_files     bool
+var Flag_history_dot   string
+var Flag_serve_addr    string
+var Flag_api_token     string
+var Flag_grpc_addr     string
+var Flag_pprof_addr    string
+var Flag_no_progress   bool
+var Flag_log_level     string
+var Flag_log_format    string
+var Flag_report        string
+var Flag_tui           bool
 
 
 func init () {
   cmd_root.AddCommand(cmd_run)
   cmd_root.AddCommand(cmd_assets)
+  cmd_root.AddCommand(cmd_serve)
+  cmd_root.AddCommand(cmd_serve_api)
+  cmd_root.AddCommand(cmd_exec_spec)
+
+  cmd_root.PersistentPreRun = cmdStartDiagnostics
+  cmd_root.PersistentFlags().StringVar(
+    &Flag_pprof_addr, "pprof", "",
+    "Serve net/http/pprof and a report of running specs and their goroutines on an address, such as :6060",
+  )
 
   cmdAddSpecRunFlags(cmd_run)
   cmdAddSpecRunFlags(cmd_assets)
+  cmdAddSpecRunFlags(cmd_serve)
+
+  cmd_serve.Flags().StringVar(
+    &Flag_serve_addr, "addr", "",
+    "The address to serve on, overriding the serve_addr prop (default 127.0.0.1:8080)",
+  )
+
+  cmd_serve_api.Flags().StringVar(
+    &Flag_serve_addr, "addr", "",
+    "The address to serve the API on (default 127.0.0.1:8080)",
+  )
+
+  cmd_serve_api.Flags().StringVar(
+    &Flag_api_token, "token", "",
+    "Require requests to have a bearer token (default $INTERBUILDER_API_TOKEN)",
+  )
+
+  cmd_serve_api.Flags().StringVar(
+    &Flag_grpc_addr, "grpc-addr", "",
+    "Also serve the AssetExchange gRPC service on an address",
+  )
 
   cmdAddAssetIOFlags(cmd_run)
   cmdAddAssetIOFlags(cmd_assets)
+
+  cmdAddCheckpointFlags(cmd_run)
+
+  cmd_run.Flags().BoolVar(
+    &Flag_no_progress, "no-progress", false,
+    "Print line-prefixed logs instead of a progress tree, even if stdout is a terminal",
+  )
+
+  cmd_run.Flags().BoolVar(
+    &Flag_tui, "tui", false,
+    "Show the spec tree, task states, recent assets, and logs of each spec in an interactive terminal UI",
+  )
+
+  cmd_run.Flags().StringVar(
+    &Flag_report, "report", "",
+    "Write a JSON report of each spec and task's duration, outputs, and errors to a file",
+  )
 }
 
 
@@ -33,6 +95,39 @@ func cmdAddSpecRunFlags (cmd *cobra.Command) {
     &Flag_print_spec, "print-spec", false,
     "Print the build specification tree when execution is finished",
   )
+
+  cmd.PersistentFlags().StringVar(
+    &Flag_history_dot, "history-dot", "",
+    "Write the provenance graph of emitted assets to a file in the Graphviz DOT language",
+  )
+
+  cmd.PersistentFlags().StringVar(
+    &Flag_log_level, "log-level", "",
+    "Log structured records of spec events and output at or above a level: debug, info, warn, or error",
+  )
+
+  cmd.PersistentFlags().StringVar(
+    &Flag_log_format, "log-format", "",
+    "Log structured records of spec events and output as text or json, one record per line",
+  )
+}
+
+
+func cmdAddCheckpointFlags (cmd *cobra.Command) {
+  cmd.Flags().StringVar(
+    &Flag_state_dir, "state-dir", "",
+    "Record checkpoints of finished specs in a state directory",
+  )
+
+  cmd.Flags().BoolVar(
+    &Flag_resume, "resume", false,
+    "Skip specs which finished in a previous run, using checkpoints in the state directory",
+  )
+
+  cmd.Flags().BoolVar(
+    &Flag_log_files, "log-files", false,
+    "Copy the output of each spec to logs/<spec>.log in the state directory",
+  )
 }
 
 
@@ -49,6 +144,70 @@ func cmdAddAssetIOFlags (cmd *cobra.Command) {
 }
 
 
+/*
+  cmdRecordHistory collects the history of each asset emitted in
+  the Spec tree, if the --history-dot flag is set, and returns a
+  function which writes their provenance graph.
+*/
+func cmdRecordHistory (root *Spec) func () error {
+  if Flag_history_dot == "" {
+    return func () error { return nil }
+  }
+
+  var lock    sync.Mutex
+  var entries []*HistoryEntry
+
+  root.OnEvent(func (e Event) {
+    if e.Type != EVENT_ASSET_EMIT || e.Asset.History == nil {
+      return
+    }
+    lock.Lock()
+    entries = append(entries, e.Asset.History)
+    lock.Unlock()
+  })
+
+  return func () error {
+    writer, closer, err := outputStringToWriter(Flag_history_dot)
+    if err != nil {
+      return fmt.Errorf("Error opening history output: %w", err)
+    }
+    if closer != nil {
+      defer closer.Close()
+    }
+
+    lock.Lock()
+    defer lock.Unlock()
+    return HistoryToDOT(writer, entries...)
+  }
+}
+
+
+/*
+  cmdWriteReport writes the report of a run as JSON to the file of
+  the --report flag, if it is set.
+*/
+func cmdWriteReport (report *BuildReport) error {
+  if Flag_report == "" || report == nil {
+    return nil
+  }
+
+  writer, closer, err := outputStringToWriter(Flag_report)
+  if err != nil {
+    return fmt.Errorf("Error opening report output: %w", err)
+  }
+  if closer != nil {
+    defer closer.Close()
+  }
+
+  var encoder = json.NewEncoder(writer)
+  encoder.SetIndent("", "  ")
+  if err := encoder.Encode(report); err != nil {
+    return fmt.Errorf("Error writing report: %w", err)
+  }
+  return nil
+}
+
+
 func outputStringToWriter (output_str string) (io.Writer, io.Closer, error) {
   if output_str == "-" {
     return os.Stdout, nil, nil
@@ -109,7 +268,7 @@ func (od *cliOutputDefinition) EnqueueTasks (name string, spec *Spec) (error) {
     }
 
     for { select {
-    case <-tk.CancelChan:
+    case <-tk.Context().Done():
       return nil
     case asset_chunk, ok := <- s.Input:
       if !ok {
@@ -269,6 +428,10 @@ func (od *cliOutputDefinition) SetEncodingField (field_name string) error {
 
     case "length":   field_values &= ASSET_ENCODING_CONTENT_LENGTH
                      field_domain  = ASSET_ENCODING_CONTENT_LENGTH
+
+    /* Provenance fields */
+    case "history":  field_values &= ASSET_ENCODING_HISTORY
+                     field_domain  = ASSET_ENCODING_HISTORY
   }
 
   od.Encoding = (od.Encoding & ^field_domain) | field_values
@@ -303,7 +466,7 @@ func parseOutputArgs (args []string) ([]cliOutputDefinition, error) {
       case "format", "filter":
         section = matched_section
       default:
-        return nil, fmt.Errorf(`Error parsing argument, unknown section "%w"`, matched_section)
+        return nil, fmt.Errorf(`Error parsing argument, unknown section "%s"`, matched_section)
       }
     }
 
@@ -328,7 +491,7 @@ func parseOutputArgs (args []string) ([]cliOutputDefinition, error) {
         if got, expect := node.NodeType, EXPRESSION_NODE_SECTION; got != expect {
           return nil, fmt.Errorf(
             "Argument %d expected to parse a section node of type %s, got %s",
-            expect, got,
+            arg_i+1, expect, got,
           )
         }
 
@@ -0,0 +1,207 @@
+package main
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "github.com/spf13/cobra"
+  "github.com/spf13/pflag"
+
+  "fmt"
+  "testing"
+)
+
+
+/*
+  resetCommandFlags sets the flags of a command and its parents
+  back to their defaults, as flags are parsed into package
+  variables shared between tests.
+*/
+func resetCommandFlags (cmd *cobra.Command) {
+  var reset = func (flag *pflag.Flag) {
+    if slice, ok := flag.Value.(pflag.SliceValue); ok {
+      slice.Replace([]string {})
+    } else {
+      flag.Value.Set(flag.DefValue)
+    }
+    flag.Changed = false
+  }
+
+  for c := cmd; c != nil; c = c.Parent() {
+    c.Flags().VisitAll(reset)
+    c.PersistentFlags().VisitAll(reset)
+  }
+}
+
+
+func TestCommandFlags (t *testing.T) {
+  var test_cases = []struct {
+    Command    *cobra.Command
+    Args       []string
+    WillError  bool
+    Check      func () bool
+  } {
+    { Command: cmd_run, Args: []string { "--report", "report.json" },
+      Check: func () bool { return Flag_report == "report.json" } },
+
+    { Command: cmd_run, Args: []string { "--history-dot", "-" },
+      Check: func () bool { return Flag_history_dot == "-" } },
+
+    { Command: cmd_assets, Args: []string { "--history-dot", "history.dot" },
+      Check: func () bool { return Flag_history_dot == "history.dot" } },
+
+    { Command: cmd_run, Args: []string { "--log-level", "debug", "--log-format", "json" },
+      Check: func () bool { return Flag_log_level == "debug" && Flag_log_format == "json" } },
+
+    { Command: cmd_run, Args: []string { "--tui" },
+      Check: func () bool { return Flag_tui && !Flag_no_progress } },
+
+    { Command: cmd_run, Args: []string { "--no-progress" },
+      Check: func () bool { return Flag_no_progress && !Flag_tui } },
+
+    { Command: cmd_run, Args: []string { "--state-dir", "state", "--resume", "--log-files" },
+      Check: func () bool { return Flag_state_dir == "state" && Flag_resume && Flag_log_files } },
+
+    { Command: cmd_run, Args: []string { "-o", "format:text", "-o", "-" },
+      Check: func () bool { return fmt.Sprint(Flag_outputs) == "[format:text -]" } },
+
+    { Command: cmd_run, Args: []string { "--pprof", ":6060" },
+      Check: func () bool { return Flag_pprof_addr == ":6060" } },
+
+    { Command: cmd_serve, Args: []string { "--addr", ":9000", "--log-format", "text" },
+      Check: func () bool { return Flag_serve_addr == ":9000" && Flag_log_format == "text" } },
+
+    { Command: cmd_serve_api, Args: []string { "--addr", ":9001", "--token", "secret" },
+      Check: func () bool { return Flag_serve_addr == ":9001" && Flag_api_token == "secret" } },
+    { Command: cmd_serve_api, Args: []string { "--grpc-addr", ":9002" },
+      Check: func () bool { return Flag_grpc_addr == ":9002" && Flag_serve_addr == "" } },
+
+    // Flags are only defined on the commands they apply to
+    //
+    { Command: cmd_serve_api, Args: []string { "--tui" },          WillError: true },
+    { Command: cmd_serve,     Args: []string { "--report", "r" },  WillError: true },
+    { Command: cmd_assets,    Args: []string { "--resume" },       WillError: true },
+    { Command: cmd_run,       Args: []string { "--token", "t" },   WillError: true },
+  }
+
+  for test_case_i, test_case := range test_cases {
+    resetCommandFlags(test_case.Command)
+
+    err := test_case.Command.ParseFlags(test_case.Args)
+    if test_case.WillError {
+      if err == nil {
+        t.Errorf("Test case #%d expected an error parsing %s %v", test_case_i, test_case.Command.Name(), test_case.Args)
+      }
+      continue
+    } else if err != nil {
+      t.Errorf("Test case #%d: error parsing %s %v: %v", test_case_i, test_case.Command.Name(), test_case.Args, err)
+      continue
+    }
+
+    if !test_case.Check() {
+      t.Errorf("Test case #%d: unexpected flag values after parsing %s %v", test_case_i, test_case.Command.Name(), test_case.Args)
+    }
+  }
+
+  resetCommandFlags(cmd_run)
+  resetCommandFlags(cmd_serve)
+  resetCommandFlags(cmd_serve_api)
+}
+
+
+func TestCommandConfigureLogging (t *testing.T) {
+  var test_cases = []struct {
+    Level      string
+    Format     string
+    Logging    bool
+    WillError  bool
+  } {
+    { Level: "",      Format: "",     Logging: false },
+    { Level: "debug", Format: "",     Logging: true  },
+    { Level: "",      Format: "json", Logging: true  },
+    { Level: "warn",  Format: "TEXT", Logging: true  },
+    { Level: "loud",  Format: "",     WillError: true },
+    { Level: "",      Format: "xml",  WillError: true },
+  }
+
+  defer func () { Flag_log_level, Flag_log_format = "", "" }()
+
+  for test_case_i, test_case := range test_cases {
+    Flag_log_level, Flag_log_format = test_case.Level, test_case.Format
+
+    logging, err := cmdConfigureLogging(NewSpec("root", nil))
+    if test_case.WillError {
+      if err == nil {
+        t.Errorf("Test case #%d expected an error configuring logging", test_case_i)
+      }
+    } else if err != nil {
+      t.Errorf("Test case #%d: error configuring logging: %v", test_case_i, err)
+    } else if logging != test_case.Logging {
+      t.Errorf("Test case #%d expected logging to be %v, got %v", test_case_i, test_case.Logging, logging)
+    }
+  }
+}
+
+
+func TestParseOutputArgs (t *testing.T) {
+  var test_cases = []struct {
+    Args       []string
+    WillError  bool
+    Expect     []cliOutputDefinition
+  } {
+    { Args: []string {}, Expect: []cliOutputDefinition {} },
+
+    { Args:   []string { "-" },
+      Expect: []cliOutputDefinition { { Dest: "-", Encoding: ASSET_ENCODING_DEFAULT } } },
+
+    { Args:   []string { "a.json", "b.json" },
+      Expect: []cliOutputDefinition {
+        { Dest: "a.json", Encoding: ASSET_ENCODING_DEFAULT },
+        { Dest: "b.json", Encoding: ASSET_ENCODING_DEFAULT },
+      } },
+
+    { Args:   []string { "format:text,url,length", "out.txt" },
+      Expect: []cliOutputDefinition {
+        { Dest: "out.txt", Encoding: ASSET_ENCODING_TEXT | ASSET_ENCODING_URL | ASSET_ENCODING_CONTENT_LENGTH },
+      } },
+
+    { Args:   []string { "format:default,history,no-content", "provenance.ndjson" },
+      Expect: []cliOutputDefinition {
+        { Dest: "provenance.ndjson", Encoding: ASSET_ENCODING_JSON | ASSET_ENCODING_URL | ASSET_ENCODING_MIMETYPE | ASSET_ENCODING_HISTORY },
+      } },
+
+    { Args:   []string { "format:default,history,no-history", "-" },
+      Expect: []cliOutputDefinition { { Dest: "-", Encoding: ASSET_ENCODING_DEFAULT } } },
+
+    { Args:   []string { "filter:ext=html,-prefix=drafts", "-" },
+      Expect: []cliOutputDefinition {
+        { Dest: "-", Encoding: ASSET_ENCODING_DEFAULT, Filters: []cliFilterDefinition {
+          { Suffix: ".html" },
+          { Prefix: "drafts", Invert: true },
+        } },
+      } },
+
+    // A format must be followed by a destination
+    //
+    { Args: []string { "format:json" },                  WillError: true },
+    { Args: []string { "format:json,no-such-field", "-" }, WillError: true },
+    { Args: []string { "filter:size=10", "-" },          WillError: true },
+    { Args: []string { "section:json", "-" },            WillError: true },
+  }
+
+  for test_case_i, test_case := range test_cases {
+    outputs, err := parseOutputArgs(test_case.Args)
+    if test_case.WillError {
+      if err == nil {
+        t.Errorf("Test case #%d expected an error parsing %v", test_case_i, test_case.Args)
+      }
+      continue
+    } else if err != nil {
+      t.Errorf("Test case #%d: error parsing %v: %v", test_case_i, test_case.Args, err)
+      continue
+    }
+
+    if got, expect := fmt.Sprintf("%+v", outputs), fmt.Sprintf("%+v", test_case.Expect); got != expect {
+      t.Errorf("Test case #%d parsing %v expected outputs %s, got %s", test_case_i, test_case.Args, expect, got)
+    }
+  }
+}
@@ -10,25 +10,29 @@ import (
   "bufio"
   "bytes"
   "fmt"; "io"; "os"
+  "time"
 )
 
 
 var (
-  ASSET_ENCODING_FIELDS            uint64 = 0b11_111_111
-  ASSET_ENCODING_FIELDS_PROPERTIES uint64 = 0b00_000_111
-  ASSET_ENCODING_FIELDS_CONTENT    uint64 = 0b00_111_000
-  ASSET_ENCODING_FIELDS_FORMAT     uint64 = 0b11_000_000
+  ASSET_ENCODING_FIELDS            uint64 = 0b1_11_111_111
+  ASSET_ENCODING_FIELDS_PROPERTIES uint64 = 0b0_00_000_111
+  ASSET_ENCODING_FIELDS_CONTENT    uint64 = 0b0_00_111_000
+  ASSET_ENCODING_FIELDS_FORMAT     uint64 = 0b0_11_000_000
+  ASSET_ENCODING_FIELDS_PROVENANCE uint64 = 0b1_00_000_000
 
-  ASSET_ENCODING_JSON              uint64 = 0b01_000_000
-  ASSET_ENCODING_TEXT              uint64 = 0b10_000_000
+  ASSET_ENCODING_JSON              uint64 = 0b0_01_000_000
+  ASSET_ENCODING_TEXT              uint64 = 0b0_10_000_000
 
-  ASSET_ENCODING_URL               uint64 = 0b00_000_001
-  ASSET_ENCODING_MIMETYPE          uint64 = 0b00_000_010
-  ASSET_ENCODING_FORMAT            uint64 = 0b00_000_100
+  ASSET_ENCODING_URL               uint64 = 0b0_00_000_001
+  ASSET_ENCODING_MIMETYPE          uint64 = 0b0_00_000_010
+  ASSET_ENCODING_FORMAT            uint64 = 0b0_00_000_100
 
-  ASSET_ENCODING_CONTENT_STRING    uint64 = 0b00_001_000
-  ASSET_ENCODING_CONTENT_BASE64    uint64 = 0b00_010_000
-  ASSET_ENCODING_CONTENT_LENGTH    uint64 = 0b00_100_000
+  ASSET_ENCODING_CONTENT_STRING    uint64 = 0b0_00_001_000
+  ASSET_ENCODING_CONTENT_BASE64    uint64 = 0b0_00_010_000
+  ASSET_ENCODING_CONTENT_LENGTH    uint64 = 0b0_00_100_000
+
+  ASSET_ENCODING_HISTORY           uint64 = 0b1_00_000_000
 )
 
 
@@ -45,6 +49,7 @@ type AssetEncoding struct {
   Mimetype string `json:"mimetype,omitempty"`
 
   Content *AssetEncodingContent `json:"content,omitempty"`
+  History []AssetEncodingHistory `json:"history,omitempty"`
 }
 
 type AssetEncodingContent struct {
@@ -53,6 +58,18 @@ type AssetEncodingContent struct {
   Base64 string `json:"base64,omitempty"`
 }
 
+/*
+  AssetEncodingHistory is one entry of an asset's provenance. The
+  first entry of an encoded history is the asset's own, followed
+  by its ancestors. Parents are indices into the same history
+  array, since entries may share parents.
+*/
+type AssetEncodingHistory struct {
+  Url     string `json:"url"`
+  Time    string `json:"time,omitempty"`
+  Parents []int  `json:"parents,omitempty"`
+}
+
 
 func AssetJsonUnmarshal (data []byte) (*Asset, error) {
   var json_data AssetEncoding
@@ -80,7 +97,10 @@ func AssetJsonUnmarshal (data []byte) (*Asset, error) {
 
   // Decode content
   //
-  if json_data.Content.String != "" {
+  if json_data.Content == nil {
+    return asset, nil
+
+  } else if json_data.Content.String != "" {
     if err := asset.SetContentBytes([]byte(json_data.Content.String)); err != nil {
       return nil, fmt.Errorf("Error setting asset content from content.string: %w", err)
     }
@@ -132,6 +152,7 @@ func AssetJsonMarshal (a *Asset, encoding_mask uint64) ([]byte, error) {
   var encode_content_string = encoding_mask & ASSET_ENCODING_CONTENT_STRING != 0
   var encode_content_base64 = encoding_mask & ASSET_ENCODING_CONTENT_BASE64 != 0
   var encode_content_length = encoding_mask & ASSET_ENCODING_CONTENT_LENGTH != 0
+  var encode_history        = encoding_mask & ASSET_ENCODING_HISTORY        != 0
 
   if encode_json == false {
     return nil, fmt.Errorf("Asset encoding is not JSON")
@@ -186,10 +207,53 @@ func AssetJsonMarshal (a *Asset, encoding_mask uint64) ([]byte, error) {
     }
   }
 
+  if encode_history {
+    marshal_data.History = AssetHistoryEncode(a.History)
+  }
+
   return json.Marshal(&marshal_data)
 }
 
 
+/*
+  AssetHistoryEncode flattens a history graph into a list of
+  entries, starting with the given entry and followed by its
+  ancestors, depth-first. Returns nil for a nil entry.
+*/
+func AssetHistoryEncode (history *HistoryEntry) []AssetEncodingHistory {
+  if history == nil {
+    return nil
+  }
+
+  var indices = map[*HistoryEntry]int { history: 0 }
+  var entries = []*HistoryEntry { history }
+
+  history.WalkParents(func (entry *HistoryEntry) error {
+    indices[entry] = len(entries)
+    entries = append(entries, entry)
+    return nil
+  })
+
+  var encoded = make([]AssetEncodingHistory, len(entries))
+
+  for i, entry := range entries {
+    if entry.Url != nil {
+      encoded[i].Url = entry.Url.String()
+    }
+    if !entry.Time.IsZero() {
+      encoded[i].Time = entry.Time.Format(time.RFC3339Nano)
+    }
+    for _, parent := range entry.Parents {
+      if parent_index, found := indices[parent]; found {
+        encoded[i].Parents = append(encoded[i].Parents, parent_index)
+      }
+    }
+  }
+
+  return encoded
+}
+
+
 func AssetTextMarshal (a *Asset, encoding_mask uint64) ([]byte, error) {
   if encoding_mask == 0 {
     encoding_mask  = ASSET_ENCODING_DEFAULT & ^ASSET_ENCODING_FIELDS_FORMAT
@@ -203,6 +267,7 @@ func AssetTextMarshal (a *Asset, encoding_mask uint64) ([]byte, error) {
   var encode_content_string = encoding_mask & ASSET_ENCODING_CONTENT_STRING != 0
   var encode_content_base64 = encoding_mask & ASSET_ENCODING_CONTENT_BASE64 != 0
   var encode_content_length = encoding_mask & ASSET_ENCODING_CONTENT_LENGTH != 0
+  var encode_history        = encoding_mask & ASSET_ENCODING_HISTORY        != 0
 
   if encode_text == false {
     return nil, fmt.Errorf("Asset encoding is not text")
@@ -229,6 +294,17 @@ func AssetTextMarshal (a *Asset, encoding_mask uint64) ([]byte, error) {
     }
   }
 
+  if encode_history {
+    // Histories are written as a space-separated list of URLs,
+    // starting with the asset's own entry
+    //
+    if writen_field { encoded.WriteString("\t") }; writen_field = true
+    for i, entry := range AssetHistoryEncode(a.History) {
+      if i > 0 { encoded.WriteString(" ") }
+      encoded.WriteString(entry.Url)
+    }
+  }
+
   if encode_content {
     content, err := a.GetContentBytes()
     if writen_field { encoded.WriteString("\t") }; writen_field = true
@@ -325,6 +401,10 @@ var cmd_assets = & cobra.Command {
     // Set up a root spec
     //
     var root = NewSpec("root", nil)
+    if _, err := cmdConfigureLogging(root); err != nil {
+      fmt.Println(err)
+      os.Exit(1)
+    }
 
     if Flag_print_spec {
       defer PrintSpec(root)
@@ -345,7 +425,7 @@ var cmd_assets = & cobra.Command {
       var spec_name string = fmt.Sprintf("cli-output-%d", output_i)
 
       if output_spec, err := output_definition.MakeSpec(spec_name); err != nil {
-        fmt.Println("Error while making output spec from arguments in output %d:\n%v\n", output_i, err)
+        fmt.Printf("Error while making output spec from arguments in output %d:\n%v\n", output_i, err)
         os.Exit(1)
       } else {
         root.AddSubspec(output_spec)
@@ -402,7 +482,7 @@ var cmd_assets = & cobra.Command {
         }
 
         if err := input_spec.DeferTask(close_task); err != nil {
-          fmt.Println("Error while deferring CLI read-assets-close task queue in Spec with name \"%s\":\n%v", spec_name, err)
+          fmt.Printf("Error while deferring CLI read-assets-close task queue in Spec with name \"%s\":\n%v\n", spec_name, err)
           os.Exit(1)
         }
 
@@ -410,7 +490,15 @@ var cmd_assets = & cobra.Command {
       }
     }
 
-    if err := root.Run(); err != nil {
+    var writeHistory = cmdRecordHistory(root)
+
+    err = root.Run()
+
+    if history_err := writeHistory(); history_err != nil {
+      fmt.Println(history_err)
+    }
+
+    if err != nil {
       fmt.Printf("Error while running root spec:\n%v", err)
       os.Exit(1)
     }
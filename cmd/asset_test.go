@@ -0,0 +1,122 @@
+package main
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "encoding/json"
+  "fmt"
+  "net/url"
+  "testing"
+  "time"
+)
+
+
+/*
+  makeHistoryAsset returns an asset whose history has two parents
+  which share an origin.
+*/
+func makeHistoryAsset (t *testing.T) *Asset {
+  t.Helper()
+
+  var parseUrl = func (raw string) *url.URL {
+    u, err := url.Parse(raw)
+    if err != nil { t.Fatal(err) }
+    return u
+  }
+
+  var origin = & HistoryEntry { Url: parseUrl("ib://source/page.md") }
+  var left   = & HistoryEntry { Url: parseUrl("ib://render/page.html"), Parents: []*HistoryEntry { origin } }
+  var right  = & HistoryEntry { Url: parseUrl("ib://layout/base.html"), Parents: []*HistoryEntry { origin } }
+
+  var asset = & Asset {
+    Url:      parseUrl("ib://site/@emit/page.html"),
+    Mimetype: "text/html",
+    History:  & HistoryEntry {
+      Url:     parseUrl("ib://site/@emit/page.html"),
+      Parents: []*HistoryEntry { left, right },
+      Time:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+    },
+  }
+  asset.SetContentBytes([]byte("page text"))
+  return asset
+}
+
+
+func TestAssetHistoryEncode (t *testing.T) {
+  if encoded := AssetHistoryEncode(nil); encoded != nil {
+    t.Errorf("Expected a nil history to encode as nil, got %v", encoded)
+  }
+
+  var encoded = AssetHistoryEncode(makeHistoryAsset(t).History)
+
+  // The shared origin is only encoded once, and referenced by both
+  // of its children
+  //
+  var expect = []AssetEncodingHistory {
+    { Url: "ib://site/@emit/page.html", Time: "2024-01-02T03:04:05Z", Parents: []int { 1, 3 } },
+    { Url: "ib://render/page.html", Parents: []int { 2 } },
+    { Url: "ib://source/page.md" },
+    { Url: "ib://layout/base.html", Parents: []int { 2 } },
+  }
+
+  if got, want := fmt.Sprintf("%+v", encoded), fmt.Sprintf("%+v", expect); got != want {
+    t.Errorf("Expected history encoding %s, got %s", want, got)
+  }
+}
+
+
+func TestAssetMarshalHistory (t *testing.T) {
+  var asset = makeHistoryAsset(t)
+
+  var test_cases = []struct {
+    Format  []string
+    Expect  string
+  } {
+    { Format: []string { "format:default" },
+      Expect: `{"url":"ib://site/@emit/page.html","mimetype":"text/html","content":{"string":"page text"}}` },
+
+    { Format: []string { "format:json,url,history" },
+      Expect: `{"url":"ib://site/@emit/page.html","history":[` +
+        `{"url":"ib://site/@emit/page.html","time":"2024-01-02T03:04:05Z","parents":[1,3]},` +
+        `{"url":"ib://render/page.html","parents":[2]},` +
+        `{"url":"ib://source/page.md"},` +
+        `{"url":"ib://layout/base.html","parents":[2]}]}` },
+
+    { Format: []string { "format:text,url,history" },
+      Expect: "ib://site/@emit/page.html\tib://site/@emit/page.html ib://render/page.html ib://source/page.md ib://layout/base.html" },
+
+    { Format: []string { "format:text,history,length" },
+      Expect: "ib://site/@emit/page.html ib://render/page.html ib://source/page.md ib://layout/base.html\t9" },
+  }
+
+  for test_case_i, test_case := range test_cases {
+    outputs, err := parseOutputArgs(append(test_case.Format, "-"))
+    if err != nil {
+      t.Errorf("Test case #%d: error parsing %v: %v", test_case_i, test_case.Format, err)
+      continue
+    }
+
+    encoded, err := AssetMarshal(asset, outputs[0].Encoding)
+    if err != nil {
+      t.Errorf("Test case #%d: error encoding asset: %v", test_case_i, err)
+      continue
+    }
+
+    if string(encoded) != test_case.Expect {
+      t.Errorf("Test case #%d with %v expected:\n%s\ngot:\n%s", test_case_i, test_case.Format, test_case.Expect, encoded)
+    }
+  }
+
+  // Encoded histories can be decoded, with their parent indices
+  //
+  encoded, err := AssetJsonMarshal(asset, ASSET_ENCODING_JSON | ASSET_ENCODING_URL | ASSET_ENCODING_HISTORY)
+  if err != nil { t.Fatal(err) }
+
+  var decoded AssetEncoding
+  if err := json.Unmarshal(encoded, &decoded); err != nil {
+    t.Fatal(err)
+  }
+  if len(decoded.History) != 4 || decoded.History[3].Parents[0] != 2 {
+    t.Errorf("Unexpected decoded history: %+v", decoded.History)
+  }
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "github.com/spf13/cobra"
+
+  "fmt"
+  "net"
+  "net/http"
+  "net/http/pprof"
+  "os"
+)
+
+
+/*
+  cmdStartDiagnostics serves net/http/pprof, and an annotated
+  report of the running specs and their goroutines, on the
+  address of the --pprof flag, if it is set. It runs before every
+  subcommand.
+*/
+func cmdStartDiagnostics (cmd *cobra.Command, args []string) {
+  if Flag_pprof_addr == "" {
+    return
+  }
+
+  var mux = http.NewServeMux()
+  mux.HandleFunc("/debug/pprof/",        pprof.Index)
+  mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+  mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+  mux.HandleFunc("/debug/pprof/symbol",  pprof.Symbol)
+  mux.HandleFunc("/debug/pprof/trace",   pprof.Trace)
+
+  mux.HandleFunc("/debug/interbuilder", func (w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    if err := WriteDiagnostics(w); err != nil {
+      fmt.Fprintf(w, "\nError writing diagnostics: %v\n", err)
+    }
+  })
+
+  // Listen before returning, so that an unusable address fails
+  // the command rather than going unnoticed
+  //
+  listener, err := net.Listen("tcp", Flag_pprof_addr)
+  if err != nil {
+    fmt.Printf("Error serving diagnostics: %v\n", err)
+    os.Exit(1)
+  }
+
+  fmt.Fprintf(os.Stderr, "Serving diagnostics on http://%s/debug/interbuilder\n", listener.Addr())
+  go http.Serve(listener, mux)
+}
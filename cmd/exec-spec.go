@@ -0,0 +1,47 @@
+package main
+
+import (
+  "gilchrist.tech/interbuilder/behaviors"
+
+  "github.com/spf13/cobra"
+
+  "fmt"
+  "os"
+)
+
+
+/*
+  cmd_exec_spec runs a spec in a child process for the "isolate"
+  prop, as in behaviors.BuildIsolatedSpec. The assets it outputs
+  are written to stdout as exec plugin messages, and everything it
+  prints is written to stderr.
+*/
+var cmd_exec_spec = & cobra.Command {
+  Use: "exec-spec [file]",
+  Short: "Run a build specification file, writing its assets to stdout as NDJSON messages",
+  Args: cobra.ExactArgs(1),
+  Hidden: true,
+  Run: func (cmd *cobra.Command, args []string) {
+    root, err := MakeRootSpec(behaviors.TaskConsumeExecOutput(os.Stdout))
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "Error creating root spec: %v\n", err)
+      os.Exit(1)
+    }
+    root.SetOutput(os.Stderr, os.Stderr)
+
+    if err := cmdLoadSpecFile(root, args[0]); err != nil {
+      fmt.Fprintln(os.Stderr, err)
+      os.Exit(1)
+    }
+
+    if err := root.Build(); err != nil {
+      fmt.Fprintf(os.Stderr, "Error while building build specs: %v\n", err)
+      os.Exit(1)
+    }
+
+    if err := root.Run(); err != nil {
+      fmt.Fprintf(os.Stderr, "Error while running build specs: %v\n", err)
+      os.Exit(1)
+    }
+  },
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "fmt"
+  "log/slog"
+  "os"
+  "strings"
+)
+
+
+/*
+  cmdConfigureLogging sets a structured logger on a root spec if
+  --log-level or --log-format is set, writing text or JSON records
+  to stdout. Returns whether a logger was set; otherwise, output is
+  printed as prefixed lines.
+*/
+func cmdConfigureLogging (root *Spec) (bool, error) {
+  if Flag_log_level == "" && Flag_log_format == "" {
+    return false, nil
+  }
+
+  var level slog.Level
+  if Flag_log_level != "" {
+    if err := level.UnmarshalText([]byte(Flag_log_level)); err != nil {
+      return false, fmt.Errorf("Invalid --log-level %q, expected debug, info, warn, or error", Flag_log_level)
+    }
+  }
+
+  var options = & slog.HandlerOptions { Level: level }
+  var handler slog.Handler
+
+  switch strings.ToLower(Flag_log_format) {
+  case "", "text":
+    handler = slog.NewTextHandler(os.Stdout, options)
+  case "json":
+    handler = slog.NewJSONHandler(os.Stdout, options)
+  default:
+    return false, fmt.Errorf("Invalid --log-format %q, expected text or json", Flag_log_format)
+  }
+
+  root.SetLogger(slog.New(handler))
+  return true, nil
+}
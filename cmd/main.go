@@ -11,12 +11,26 @@ import (
 )
 
 
-func MakeDefaultRootSpec () *Spec {
+func MakeDefaultRootSpec () (*Spec, error) {
+  return MakeRootSpec(behaviors.TaskConsumeLinkFiles)
+}
+
+
+/*
+  MakeRootSpec returns a root spec with the default behaviors,
+  whose "root-consume" task, which receives the output of the
+  pipeline, runs root_consume.
+*/
+func MakeRootSpec (root_consume TaskFunc) (*Spec, error) {
   root := NewSpec("root", nil)
 
   // Prop preprocessing layer
   //
+  root.AddSpecBuilder(behaviors.BuildRemoteSpec)
+  root.AddSpecBuilder(behaviors.BuildIsolatedSpec)
+  root.AddSpecBuilder(behaviors.BuildPreset)
   root.AddSpecBuilder(behaviors.BuildSourceURLType)
+  root.AddSpecBuilder(behaviors.BuildSourceLocal)
   root.AddSpecBuilder(behaviors.BuildSourceDir)
   root.AddSpecBuilder(behaviors.BuildTransform)
 
@@ -24,27 +38,70 @@ func MakeDefaultRootSpec () *Spec {
   //
   root.AddSpecBuilder(behaviors.BuildTaskInferSource) // TODO: rename to match TaskAssetsInfer?
   root.AddSpecBuilder(behaviors.BuildTaskSourceGitClone)
+  root.AddSpecBuilder(behaviors.BuildTaskSourceArchive)
+  root.AddSpecBuilder(behaviors.BuildTaskSourceCrawl)
   root.AddSpecBuilder(behaviors.BuildTasksNodeJS)
+  root.AddSpecBuilder(behaviors.BuildTasksGo)
+  root.AddSpecBuilder(behaviors.BuildTasksPython)
+  root.AddSpecBuilder(behaviors.BuildTasksHugo)
+  root.AddSpecBuilder(behaviors.BuildTasksJekyll)
+  root.AddSpecBuilder(behaviors.BuildTasksDocker)
+  root.AddSpecBuilder(behaviors.BuildTasksStatic)
+
+  // Declarative task layer
+  //
+  root.AddSpecBuilder(behaviors.BuildConfigTasks)
+  root.AddSpecBuilder(behaviors.BuildTaskExtractFrontMatter)
+  root.AddSpecBuilder(behaviors.BuildTaskRenderMarkdown)
+  root.AddSpecBuilder(behaviors.BuildTaskApplyLayouts)
+  root.AddSpecBuilder(behaviors.BuildTaskCompileSass)
+  root.AddSpecBuilder(behaviors.BuildTasksMinify)
+  root.AddSpecBuilder(behaviors.BuildTaskOptimizeImages)
+  root.AddSpecBuilder(behaviors.BuildTaskResponsiveImages)
+  root.AddSpecBuilder(behaviors.BuildTaskFingerprintAssets)
+  root.AddSpecBuilder(behaviors.BuildTaskCanonicalUrls)
+  root.AddSpecBuilder(behaviors.BuildTaskLiveReload)
+  root.AddSpecBuilder(behaviors.BuildTaskEmitSitemap)
+  root.AddSpecBuilder(behaviors.BuildTaskEmitFeed)
+  root.AddSpecBuilder(behaviors.BuildTaskEmitManifest)
+  root.AddSpecBuilder(behaviors.BuildTaskPrecompressAssets)
+  root.AddSpecBuilder(behaviors.BuildTaskDeployS3)
+  root.AddSpecBuilder(behaviors.BuildTaskDeployRemote)
+  root.AddSpecBuilder(behaviors.BuildTaskPublishGit)
 
   // Asset content inference
   //
-  assets_infer      := & behaviors.TaskResolverAssetsInferRoot
-  assets_infer_html := & behaviors.TaskResolverAssetsInferHtml
-  assets_infer_css  := & behaviors.TaskResolverAssetsInferCss
-  assets_infer.AddTaskResolver(assets_infer_html)
-  assets_infer.AddTaskResolver(assets_infer_css)
-  root.AddTaskResolver(assets_infer)
-
-  root.AddTaskResolver(& behaviors.TaskResolverApplyPathTransformationsToHtmlContent)
-  root.AddTaskResolver(& behaviors.TaskResolverApplyPathTransformationsToCssContent)
-
-  root.DeferTaskFunc("root-consume", behaviors.TaskConsumeLinkFiles)
+  // Resolvers are copied, as adding one links it into the tree
+  // of this root, and roots are made for each run of the API
+  //
+  assets_infer      := behaviors.TaskResolverAssetsInferRoot
+  assets_infer_html := behaviors.TaskResolverAssetsInferHtml
+  assets_infer_css  := behaviors.TaskResolverAssetsInferCss
+  assets_infer.AddTaskResolver(&assets_infer_html)
+  assets_infer.AddTaskResolver(&assets_infer_css)
+  root.AddTaskResolver(&assets_infer)
+
+  apply_html := behaviors.TaskResolverApplyPathTransformationsToHtmlContent
+  apply_css  := behaviors.TaskResolverApplyPathTransformationsToCssContent
+  serve_http := behaviors.TaskResolverServeHttp
+  root.AddTaskResolver(&apply_html)
+  root.AddTaskResolver(&apply_css)
+  root.AddTaskResolver(&serve_http)
+
+  root.DeferTaskFunc("root-consume", root_consume)
+
+  // Registered behavior layer, contributed by packages through
+  // interbuilder.RegisterTaskResolver and RegisterSpecBuilder
+  //
+  if err := ApplyRegistry(root); err != nil {
+    return nil, err
+  }
 
   // Subspec layer
   //
   root.AddSpecBuilder(behaviors.ResolveSubspecs)
 
-  return root
+  return root, nil
 }
 
 
@@ -0,0 +1,292 @@
+package main
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "bytes"
+  "fmt"
+  "io"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+
+var progress_spinner = []string { "⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏" }
+
+
+/*
+  progressSpec is the state of a spec shown by a progressRenderer.
+*/
+type progressSpec struct {
+  Started     time.Time
+  Finished    time.Time
+  Task        string
+  TaskStart   time.Time
+  Assets      int
+  Failed      bool
+}
+
+
+/*
+  progressRenderer draws a spec tree with the running task,
+  emitted asset count, and elapsed time of each spec below the log
+  lines of a pipeline, redrawing it in place on a terminal. It is
+  the writer of the pipeline's output, so that log lines are
+  printed above the tree rather than through it.
+*/
+type progressRenderer struct {
+  root     *Spec
+  out      io.Writer
+  width    int
+
+  lock     sync.Mutex
+  specs    map[*Spec]*progressSpec
+  pending  []byte
+  lines    int
+  frame    int
+  stopped  bool
+
+  stop     chan struct{}
+  done     chan struct{}
+}
+
+
+/*
+  cmdStartProgress renders the progress of a root spec if stdout
+  is a terminal, and progress is not disabled with --no-progress.
+  It returns a function which stops rendering, drawing the final
+  state of the tree. Otherwise, output is left as line-prefixed
+  logs, and the returned function does nothing.
+*/
+func cmdStartProgress (root *Spec) (stop func ()) {
+  if Flag_no_progress || !IsTerminal(os.Stdout) {
+    return func () {}
+  }
+
+  var width = 80
+  if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns >= 20 {
+    width = columns
+  }
+
+  var renderer = & progressRenderer {
+    root:  root,
+    out:   os.Stdout,
+    width: width,
+    specs: make(map[*Spec]*progressSpec),
+    stop:  make(chan struct{}),
+    done:  make(chan struct{}),
+  }
+
+  // The renderer writes to a terminal, so command output keeps
+  // its colors
+  //
+  root.SetConsole(& Console {
+    Stdout: renderer,
+    Stderr: renderer,
+    Color:  os.Getenv("NO_COLOR") == "",
+  })
+  root.OnEvent(renderer.record)
+
+  go func () {
+    defer close(renderer.done)
+    var ticker = time.NewTicker(100 * time.Millisecond)
+    defer ticker.Stop()
+    for {
+      select {
+      case <-renderer.stop:
+        renderer.draw(true)
+        return
+      case <-ticker.C:
+        renderer.draw(false)
+      }
+    }
+  }()
+
+  return func () {
+    close(renderer.stop)
+    <-renderer.done
+  }
+}
+
+
+/*
+  Write buffers log lines, to be printed above the tree when it
+  is next drawn. Once rendering has stopped, such as for commands
+  which outlive their task, lines are written through.
+*/
+func (r *progressRenderer) Write (p []byte) (int, error) {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+  if r.stopped {
+    return r.out.Write(p)
+  }
+  r.pending = append(r.pending, p...)
+  return len(p), nil
+}
+
+
+/*
+  record is the EventHandler of the root spec, which updates the
+  state of the spec of each event.
+*/
+func (r *progressRenderer) record (e Event) {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+
+  var spec = r.specs[e.Spec]
+  if spec == nil {
+    spec = & progressSpec {}
+    r.specs[e.Spec] = spec
+  }
+  spec.record(e)
+}
+
+
+/*
+  record updates the state of a spec with one of its events.
+*/
+func (spec *progressSpec) record (e Event) {
+  switch e.Type {
+  case EVENT_SPEC_START:
+    spec.Started = e.Time
+  case EVENT_SPEC_END:
+    spec.Finished = e.Time
+    spec.Task     = ""
+    spec.Failed   = e.Err != nil
+  case EVENT_TASK_START:
+    spec.Task      = e.Task.Name
+    spec.TaskStart = e.Time
+  case EVENT_TASK_END, EVENT_TASK_ERROR:
+    spec.Task = ""
+  case EVENT_ASSET_EMIT:
+    spec.Assets++
+  }
+}
+
+
+/*
+  draw erases the tree, prints the complete log lines written
+  since it was last drawn, and draws the tree again. The final
+  draw also prints an incomplete last line.
+*/
+func (r *progressRenderer) draw (final bool) {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+
+  var buffer bytes.Buffer
+
+  // Move to the start of the tree, and clear it
+  //
+  if r.lines > 0 {
+    fmt.Fprintf(&buffer, "\x1b[%dA\r\x1b[J", r.lines)
+  }
+
+  var end = bytes.LastIndexByte(r.pending, '\n') + 1
+  if final && end < len(r.pending) {
+    r.pending = append(r.pending, '\n')
+    end = len(r.pending)
+  }
+  buffer.Write(r.pending[:end])
+  r.pending = append(r.pending[:0], r.pending[end:]...)
+
+  var lines = r.treeLines(r.root, 0, time.Now())
+  for _, line := range lines {
+    buffer.WriteString(line)
+    buffer.WriteByte('\n')
+  }
+  r.lines   = len(lines)
+  r.frame++
+  r.stopped = final
+
+  r.out.Write(buffer.Bytes())
+}
+
+
+/*
+  treeLines returns the lines of a spec and its subspecs, in the
+  order of their names, indented by their depth.
+*/
+func (r *progressRenderer) treeLines (s *Spec, depth int, now time.Time) []string {
+  var spec = r.specs[s]
+  if spec == nil {
+    spec = & progressSpec {}
+  }
+
+  var line = strings.Repeat("  ", depth) + spec.summary(s.Name, r.frame, now)
+
+  // Lines are kept within the terminal's width, as wrapped lines
+  // would not be erased
+  //
+  var text = []rune(line)
+  if len(text) >= r.width {
+    text = append(text[:r.width - 2], '…')
+  }
+
+  var lines = []string { string(text) }
+
+  var names = make([]string, 0, len(s.Subspecs))
+  for name := range s.Subspecs {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    lines = append(lines, r.treeLines(s.Subspecs[name], depth + 1, now)...)
+  }
+  return lines
+}
+
+
+/*
+  summary returns the line describing a spec: an icon of its
+  state, its name, its running task, how many assets it has
+  output, and its elapsed time. Running specs have a spinner, at
+  the given frame.
+*/
+func (spec *progressSpec) summary (name string, frame int, now time.Time) string {
+  var icon, elapsed string
+  switch {
+  case spec.Started.IsZero():
+    icon = "·"
+  case spec.Failed:
+    icon    = "✗"
+    elapsed = spec.Finished.Sub(spec.Started).Round(100 * time.Millisecond).String()
+  case !spec.Finished.IsZero():
+    icon    = "✓"
+    elapsed = spec.Finished.Sub(spec.Started).Round(100 * time.Millisecond).String()
+  default:
+    icon    = progress_spinner[frame % len(progress_spinner)]
+    elapsed = now.Sub(spec.Started).Round(100 * time.Millisecond).String()
+  }
+
+  var line strings.Builder
+  line.WriteString(icon + " " + name)
+  if spec.Task != "" {
+    fmt.Fprintf(&line, "  %s (%s)", spec.Task, now.Sub(spec.TaskStart).Round(100 * time.Millisecond))
+  }
+  if spec.Assets > 0 {
+    fmt.Fprintf(&line, "  %d assets", spec.Assets)
+  }
+  if elapsed != "" {
+    line.WriteString("  " + elapsed)
+  }
+
+  return line.String()
+}
+
+
+/*
+  cmdOutputsToStdout returns whether any asset, history, or report
+  output is written to stdout, where progress would corrupt it.
+*/
+func cmdOutputsToStdout (output_definitions []cliOutputDefinition) bool {
+  for _, output_definition := range output_definitions {
+    if output_definition.Dest == "-" {
+      return true
+    }
+  }
+  return Flag_history_dot == "-" || Flag_report == "-"
+}
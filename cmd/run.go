@@ -2,12 +2,17 @@ package main
 
 import (
   . "gilchrist.tech/interbuilder"
+  "gilchrist.tech/interbuilder/behaviors"
 
   "github.com/spf13/cobra"
+  "gopkg.in/yaml.v3"
 
+  "context"
   "fmt"
   "os"
   "encoding/json"
+  "path/filepath"
+  "strings"
 )
 
 
@@ -38,7 +43,19 @@ var cmd_run = & cobra.Command {
       output_definitions = append(output_definitions, flag_outputs...)
     }
 
-    var root *Spec = MakeDefaultRootSpec()
+    root, err := MakeDefaultRootSpec()
+    if err != nil {
+      fmt.Printf("Error creating root spec: %v\n", err)
+      os.Exit(1)
+    }
+
+    // handle flags: --log-level and --log-format
+    //
+    logging, err := cmdConfigureLogging(root)
+    if err != nil {
+      fmt.Println(err)
+      os.Exit(1)
+    }
 
     // handle flag: --print-spec
     //
@@ -51,15 +68,29 @@ var cmd_run = & cobra.Command {
 
     // Load spec configuration from file
     //
-    specs_bytes, err := os.ReadFile(spec_file)
-    if err != nil {
-      fmt.Printf("Could not read spec file: %v\n", err)
+    if err := cmdLoadSpecFile(root, spec_file); err != nil {
+      fmt.Println(err)
       os.Exit(1)
     }
 
-    if err := json.Unmarshal(specs_bytes, &root.Props); err != nil {
-      fmt.Printf("Could not parse spec json file: %v\n", err)
-      os.Exit(1)
+    // handle flags: --state-dir, --resume, and --log-files
+    //
+    if Flag_state_dir != "" {
+      root.Props["state_dir"] = Flag_state_dir
+    }
+
+    if Flag_resume {
+      if _, found := root.Props["state_dir"]; !found {
+        root.Props["state_dir"] = ".interbuilder-state"
+      }
+      root.Props["resume"] = true
+    }
+
+    if Flag_log_files {
+      if _, found := root.Props["state_dir"]; !found {
+        root.Props["state_dir"] = ".interbuilder-state"
+      }
+      root.Props["log_files"] = true
     }
 
     // Create tasks for outputs
@@ -67,7 +98,7 @@ var cmd_run = & cobra.Command {
     for output_i, output_definition := range output_definitions {
       var task_name = fmt.Sprintf("cli-output-%d", output_i)
       if err := output_definition.EnqueueTasks(task_name, root); err != nil {
-        fmt.Println("Error while creating creating output tasks:\n\t%v\n", err)
+        fmt.Printf("Error while creating creating output tasks:\n\t%v\n", err)
         os.Exit(1)
       }
     }
@@ -79,14 +110,100 @@ var cmd_run = & cobra.Command {
       os.Exit(1)
     }
 
-    // Run tasks
+    // handle flag: --history-dot
     //
-    if err = root.Run() ; err != nil {
+    var writeHistory = cmdRecordHistory(root)
+
+    // Render progress on a terminal, unless an output or the log
+    // is written to stdout. With --tui, the run is shown in an
+    // interactive UI instead, which the user exits once it is done.
+    //
+    var stopProgress = func () {}
+    if Flag_tui {
+      if logging || cmdOutputsToStdout(output_definitions) || !IsTerminal(os.Stdout) {
+        fmt.Println("The --tui flag requires stdout to be a terminal, without outputs or logs written to it")
+        os.Exit(1)
+      }
+      stopProgress = cmdStartTUI(root)
+    } else if !logging && !cmdOutputsToStdout(output_definitions) {
+      stopProgress = cmdStartProgress(root)
+    }
+
+    // Run tasks, with a report if the --report flag is set
+    //
+    var report *BuildReport
+    if Flag_report != "" {
+      report, err = root.RunReport(context.Background())
+    } else {
+      err = root.Run()
+    }
+    stopProgress()
+
+    if history_err := writeHistory(); history_err != nil {
+      fmt.Println(history_err)
+    }
+
+    if report_err := cmdWriteReport(report); report_err != nil {
+      fmt.Println(report_err)
+    }
+
+    if err != nil {
       if Flag_print_spec {
         PrintSpec(root)
       }
       fmt.Printf("Error while running build specs: %v\n", err)
+
+      for _, report := range root.StatusReport() {
+        if report.Status != SPEC_STATUS_SUCCEEDED {
+          fmt.Printf("\t%s: %s\n", report.Path, report.Status)
+        }
+      }
       os.Exit(1)
     }
   },
 }
+
+
+/*
+  cmdLoadSpecFile reads the props of a root spec from a build
+  specification file, in JSON, or in YAML if its extension is
+  .yaml or .yml, and validates them. Unless the file sets it, the
+  "spec_dir" prop is the directory of the file, which relative
+  local sources are resolved against.
+*/
+func cmdLoadSpecFile (root *Spec, spec_file string) error {
+  specs_bytes, err := os.ReadFile(spec_file)
+  if err != nil {
+    return fmt.Errorf("Could not read spec file: %v", err)
+  }
+
+  var config behaviors.SpecConfig
+
+  switch strings.ToLower(filepath.Ext(spec_file)) {
+  case ".yaml", ".yml":
+    if err := yaml.Unmarshal(specs_bytes, &config); err != nil {
+      return fmt.Errorf("Could not parse spec yaml file: %v", err)
+    }
+  default:
+    if err := json.Unmarshal(specs_bytes, &config); err != nil {
+      return fmt.Errorf("Could not parse spec json file: %v", err)
+    }
+  }
+
+  if err := config.Validate(); err != nil {
+    return fmt.Errorf("Invalid spec file: %w", err)
+  }
+
+  for key, value := range config.ToProps() {
+    root.Props[key] = value
+  }
+
+  if _, found := root.Props["spec_dir"]; !found {
+    spec_dir, err := filepath.Abs(filepath.Dir(spec_file))
+    if err != nil {
+      return fmt.Errorf("Could not resolve the spec file directory: %v", err)
+    }
+    root.Props["spec_dir"] = spec_dir
+  }
+  return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+  . "gilchrist.tech/interbuilder"
+
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "testing"
+)
+
+
+func TestCmdLoadSpecFile (t *testing.T) {
+  var dir = t.TempDir()
+
+  var test_cases = []struct {
+    File     string
+    Content  string
+    SpecDir  string
+  }{
+    { File: "site.json", Content: `{ "source": "./site" }`,                    SpecDir: dir },
+    { File: "site.yaml", Content: "source: ./site\n",                            SpecDir: dir },
+    { File: "sub/site.yml", Content: "source: ./site\n",                         SpecDir: filepath.Join(dir, "sub") },
+    { File: "isolated.json", Content: `{ "source": "./site", "spec_dir": "/srv" }`, SpecDir: "/srv" },
+  }
+
+  for test_case_i, test_case := range test_cases {
+    var spec_file = filepath.Join(dir, test_case.File)
+    if err := os.MkdirAll(filepath.Dir(spec_file), os.ModePerm); err != nil {
+      t.Fatal(err)
+    }
+    if err := os.WriteFile(spec_file, []byte(test_case.Content), 0o644); err != nil {
+      t.Fatal(err)
+    }
+
+    var root = NewSpec("root", nil)
+    if err := cmdLoadSpecFile(root, spec_file); err != nil {
+      t.Errorf("Test case %d: unexpected error: %v", test_case_i, err)
+      continue
+    }
+    if root.Props["source"] != "./site" {
+      t.Errorf("Test case %d: expected the source prop, got %v", test_case_i, root.Props["source"])
+    }
+    if root.Props["spec_dir"] != test_case.SpecDir {
+      t.Errorf("Test case %d: expected the spec_dir to be %s, got %v", test_case_i, test_case.SpecDir, root.Props["spec_dir"])
+    }
+  }
+}
+
+
+func TestPresetStaticSiteOutput (t *testing.T) {
+  var test_cases = []struct {
+    SpecFile  string
+    Expected  []string
+    Sitemap   []string
+  }{
+    // The sitemap builder of the preset only emits a sitemap with
+    // a base_url
+    //
+    {
+      SpecFile: `{ "subspecs": { "site": { "source": "./site", "preset": "static-site" } } }`,
+      Expected: []string { "@emit/about.html", "@emit/css/style.css", "@emit/index.html" },
+    },
+    {
+      SpecFile: `{
+        "base_url": "https://example.com/",
+        "subspecs": { "site": { "source": "./site", "preset": "static-site" } }
+      }`,
+      Expected: []string { "@emit/about.html", "@emit/css/style.css", "@emit/index.html", "@emit/sitemap.xml" },
+      Sitemap:  []string { "https://example.com/", "https://example.com/about.html" },
+    },
+    // Every asset is output under the prefix, and the sitemap lists
+    // the pages at their transformed URLs
+    //
+    {
+      SpecFile: `{
+        "base_url": "https://example.com/",
+        "subspecs": {
+          "site": {
+            "source":    "./site",
+            "preset":    "static-site",
+            "transform": { "prefix": "blog" }
+          }
+        }
+      }`,
+      Expected: []string { "@emit/blog/about.html", "@emit/blog/css/style.css", "@emit/blog/index.html", "@emit/blog/sitemap.xml" },
+      Sitemap:  []string { "https://example.com/blog/", "https://example.com/blog/about.html" },
+    },
+  }
+
+  for test_case_i, test_case := range test_cases {
+    var dir = t.TempDir()
+
+    for file_path, content := range map[string]string {
+      "site/index.html":    "<h1>Home</h1>",
+      "site/about.md":      "# About",
+      "site/css/style.css": "body {\n  color: red;\n}\n",
+      "site.spec.json":     test_case.SpecFile,
+    } {
+      file_path = filepath.Join(dir, file_path)
+      if err := os.MkdirAll(filepath.Dir(file_path), os.ModePerm); err != nil {
+        t.Fatal(err)
+      }
+      if err := os.WriteFile(file_path, []byte(content), 0o644); err != nil {
+        t.Fatal(err)
+      }
+    }
+
+    var received = make(map[string]string)
+    root, err := MakeRootSpec(func (s *Spec, tk *Task) error {
+      if err := tk.PoolSpecInputAssets(); err != nil {
+        return err
+      }
+      for _, chunk := range tk.Assets {
+        assets, err := chunk.Flatten()
+        if err != nil { return err }
+        for _, asset := range assets {
+          content, err := asset.GetContentBytes()
+          if err != nil { return err }
+          received[asset.Url.Path] = string(content)
+        }
+      }
+      return nil
+    })
+    if err != nil {
+      t.Fatal(err)
+    }
+    root.Props["quiet"] = true
+
+    if err := cmdLoadSpecFile(root, filepath.Join(dir, "site.spec.json")); err != nil {
+      t.Fatal(err)
+    }
+    if err := root.Build(); err != nil {
+      t.Fatal(err)
+    }
+    TestWrapTimeoutError(t, root.Run)
+
+    var paths = make([]string, 0, len(received))
+    for asset_path := range received {
+      paths = append(paths, asset_path)
+    }
+    sort.Strings(paths)
+
+    if strings.Join(paths, " ") != strings.Join(test_case.Expected, " ") {
+      t.Errorf("Test case %d: expected the output paths %v, got %v", test_case_i, test_case.Expected, paths)
+      continue
+    }
+
+    var prefix = strings.TrimSuffix(test_case.Expected[0], "about.html")
+    if page := received[prefix + "about.html"]; !strings.Contains(page, "<h1>About</h1>") {
+      t.Errorf("Test case %d: expected the rendered page, got %q", test_case_i, page)
+    }
+    if stylesheet := received[prefix + "css/style.css"]; stylesheet != "body{color:red}" {
+      t.Errorf("Test case %d: expected the minified stylesheet, got %q", test_case_i, stylesheet)
+    }
+
+    var sitemap = received[prefix + "sitemap.xml"]
+    for _, location := range test_case.Sitemap {
+      if !strings.Contains(sitemap, "<loc>" + location + "</loc>") {
+        t.Errorf("Test case %d: expected the sitemap to list %s, got %q", test_case_i, location, sitemap)
+      }
+    }
+    if strings.Count(sitemap, "<loc>") != len(test_case.Sitemap) {
+      t.Errorf("Test case %d: expected the sitemap to list %d URLs, got %q", test_case_i, len(test_case.Sitemap), sitemap)
+    }
+  }
+}
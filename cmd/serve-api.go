@@ -0,0 +1,88 @@
+package main
+
+import (
+  "gilchrist.tech/interbuilder/behaviors"
+  "gilchrist.tech/interbuilder/proto"
+
+  "github.com/spf13/cobra"
+  "google.golang.org/grpc"
+
+  "context"
+  "errors"
+  "fmt"
+  "net"
+  "net/http"
+  "os"
+  "os/signal"
+  "syscall"
+  "time"
+)
+
+
+var cmd_serve_api = & cobra.Command {
+  Use: "serve-api",
+  Short: "Serve an HTTP API to submit pipeline configs, run them, and download their assets",
+  Args: cobra.NoArgs,
+  Run: func (cmd *cobra.Command, args []string) {
+    var addr = Flag_serve_addr
+    if addr == "" {
+      addr = "127.0.0.1:8080"
+    }
+
+    var token = Flag_api_token
+    if token == "" {
+      token = os.Getenv("INTERBUILDER_API_TOKEN")
+    }
+
+    var api_server = & behaviors.ApiServer {
+      MakeRoot: MakeRootSpec,
+      Token:    token,
+    }
+    var http_server = & http.Server { Addr: addr, Handler: api_server }
+
+    // Serve until interrupted, and then cancel the running runs
+    //
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    var serve_err = make(chan error, 2)
+    go func () { serve_err <- http_server.ListenAndServe() }()
+
+    fmt.Println("Serving the API on http://" + addr)
+
+    // The AssetExchange gRPC service runs the same stored
+    // pipelines, and its runs are listed with the API's
+    //
+    var grpc_server *grpc.Server
+    if Flag_grpc_addr != "" {
+      listener, err := net.Listen("tcp", Flag_grpc_addr)
+      if err != nil {
+        fmt.Printf("Error while serving the gRPC API: %v\n", err)
+        os.Exit(1)
+      }
+
+      grpc_server = grpc.NewServer()
+      interbuilderpb.RegisterAssetExchangeServer(grpc_server, & behaviors.AssetExchangeServer { Api: api_server })
+      go func () { serve_err <- grpc_server.Serve(listener) }()
+
+      fmt.Println("Serving the gRPC API on " + listener.Addr().String())
+    }
+
+    select {
+    case err := <-serve_err:
+      if err != nil && !errors.Is(err, http.ErrServerClosed) {
+        fmt.Printf("Error while serving the API: %v\n", err)
+        os.Exit(1)
+      }
+    case <-ctx.Done():
+    }
+
+    shutdown_ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+    http_server.Shutdown(shutdown_ctx)
+    api_server.Close()
+    if grpc_server != nil {
+      grpc_server.GracefulStop()
+    }
+  },
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+  . "gilchrist.tech/interbuilder"
+  "gilchrist.tech/interbuilder/behaviors"
+
+  "github.com/spf13/cobra"
+
+  "context"
+  "fmt"
+  "os"
+  "os/signal"
+  "syscall"
+)
+
+
+var cmd_serve = & cobra.Command {
+  Use: "serve [file]",
+  Short: "Run a build specification file, and serve its output over HTTP",
+  Args: cobra.ExactArgs(1),
+  Run: func (cmd *cobra.Command, args []string) {
+    var spec_file string = args[0]
+
+    // The pipeline's output is served from memory, rather than
+    // written to files
+    //
+    root, err := MakeRootSpec(behaviors.TaskServeHttp)
+    if err != nil {
+      fmt.Printf("Error creating root spec: %v\n", err)
+      os.Exit(1)
+    }
+
+    if _, err := cmdConfigureLogging(root); err != nil {
+      fmt.Println(err)
+      os.Exit(1)
+    }
+
+    if Flag_print_spec {
+      defer func () {
+        fmt.Println()
+        PrintSpec(root)
+      }()
+    }
+
+    if err := cmdLoadSpecFile(root, spec_file); err != nil {
+      fmt.Println(err)
+      os.Exit(1)
+    }
+
+    // Pages reload when served content changes, unless the spec
+    // file disables it
+    //
+    if _, found := root.Props["dev"]; !found {
+      root.Props["dev"] = true
+    }
+
+    if Flag_serve_addr != "" {
+      root.Props["serve_addr"] = Flag_serve_addr
+    }
+
+    if err = root.Build() ; err != nil {
+      fmt.Printf("Error while building build specs: %v\n", err)
+      os.Exit(1)
+    }
+
+    var writeHistory = cmdRecordHistory(root)
+
+    // Serve until interrupted
+    //
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    err = root.RunContext(ctx)
+
+    if history_err := writeHistory(); history_err != nil {
+      fmt.Println(history_err)
+    }
+
+    if err != nil && ctx.Err() == nil {
+      fmt.Printf("Error while running build specs: %v\n", err)
+      os.Exit(1)
+    }
+  },
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+  . "gilchrist.tech/interbuilder"
+  tea "github.com/charmbracelet/bubbletea"
+
+  "bytes"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+
+const (
+  TUI_LOG_LINES     = 500
+  TUI_RECENT_ASSETS = 20
+  TUI_TASK_LINES    = 8
+  TUI_ASSET_LINES   = 5
+)
+
+
+/*
+  tuiSpec is the state of a spec shown by the TUI: its progress,
+  the tasks it has started or skipped, the paths of the assets it
+  output most recently, and the tail of its log.
+*/
+type tuiSpec struct {
+  progressSpec
+  Tasks    []tuiTask
+  Recent   []string
+  Log      []string
+  partial  []byte
+}
+
+
+type tuiTask struct {
+  Name      string
+  Icon      string
+  Start     time.Time
+  Duration  time.Duration
+  Running   bool
+}
+
+
+/*
+  tuiState is the state of a run shared by the TUI's model, the
+  event handler of the root spec, and the log writers of each
+  spec. Once the TUI exits, logs are written through to stdout.
+*/
+type tuiState struct {
+  lock    sync.Mutex
+  specs   map[*Spec]*tuiSpec
+  closed  bool
+}
+
+
+/*
+  spec returns the state of a spec, with the lock held.
+*/
+func (st *tuiState) spec (s *Spec) *tuiSpec {
+  var spec = st.specs[s]
+  if spec == nil {
+    spec = & tuiSpec {}
+    st.specs[s] = spec
+  }
+  return spec
+}
+
+
+/*
+  record is the EventHandler of the root spec.
+*/
+func (st *tuiState) record (e Event) {
+  st.lock.Lock()
+  defer st.lock.Unlock()
+
+  var spec = st.spec(e.Spec)
+  spec.record(e)
+
+  switch e.Type {
+  case EVENT_TASK_START:
+    spec.Tasks = append(spec.Tasks, tuiTask { Name: e.Task.Name, Start: e.Time, Running: true })
+  case EVENT_TASK_SKIP:
+    spec.Tasks = append(spec.Tasks, tuiTask { Name: e.Task.Name, Icon: "~", Start: e.Time })
+  case EVENT_TASK_END, EVENT_TASK_ERROR:
+    for i := len(spec.Tasks) - 1; i >= 0; i-- {
+      if task := &spec.Tasks[i]; task.Running && task.Name == e.Task.Name {
+        task.Running  = false
+        task.Duration = e.Task.Duration
+        task.Icon     = "✓"
+        if e.Type == EVENT_TASK_ERROR {
+          task.Icon = "✗"
+        }
+        break
+      }
+    }
+  case EVENT_ASSET_EMIT:
+    if e.Asset.Url != nil {
+      spec.Recent = append(spec.Recent, e.Asset.Url.Path)
+      if len(spec.Recent) > TUI_RECENT_ASSETS {
+        spec.Recent = spec.Recent[len(spec.Recent) - TUI_RECENT_ASSETS:]
+      }
+    }
+  }
+}
+
+
+/*
+  close writes the logs of specs through to stdout from then on,
+  once the TUI has exited.
+*/
+func (st *tuiState) close () {
+  st.lock.Lock()
+  defer st.lock.Unlock()
+  st.closed = true
+}
+
+
+/*
+  tuiLogWriter is the Console writer of a spec shown by the TUI,
+  which keeps the last lines written to it.
+*/
+type tuiLogWriter struct {
+  state  *tuiState
+  spec   *Spec
+}
+
+
+func (w tuiLogWriter) Write (p []byte) (int, error) {
+  w.state.lock.Lock()
+  defer w.state.lock.Unlock()
+
+  if w.state.closed {
+    return os.Stdout.Write(p)
+  }
+
+  var spec = w.state.spec(w.spec)
+  spec.partial = append(spec.partial, p...)
+  for {
+    var end = bytes.IndexByte(spec.partial, '\n')
+    if end < 0 {
+      break
+    }
+    spec.Log     = append(spec.Log, string(spec.partial[:end]))
+    spec.partial = spec.partial[end+1:]
+  }
+  if len(spec.Log) > TUI_LOG_LINES {
+    spec.Log = spec.Log[len(spec.Log) - TUI_LOG_LINES:]
+  }
+  return len(p), nil
+}
+
+
+type tuiTickMsg     time.Time
+type tuiFinishedMsg struct {}
+
+
+/*
+  tuiModel is the bubbletea model of the TUI. Specs are listed in
+  the order of the tree, each subspec after its parent, ordered by
+  name.
+*/
+type tuiModel struct {
+  root      *Spec
+  state     *tuiState
+  specs     []*Spec
+  depths    []int
+
+  selected  int
+  width     int
+  height    int
+  frame     int
+  finished  bool
+  message   string
+}
+
+
+/*
+  cmdStartTUI shows the run of a root spec in a full screen
+  terminal UI, with --tui. Each spec's output is kept for the UI
+  instead of being printed. It returns a function to call once the
+  run has finished, which waits for the user to exit the UI.
+*/
+func cmdStartTUI (root *Spec) (stop func ()) {
+  var state = & tuiState { specs: make(map[*Spec]*tuiSpec) }
+  var model = & tuiModel { root: root, state: state, width: 80, height: 24 }
+
+  var walk func (*Spec, int)
+  walk = func (s *Spec, depth int) {
+    model.specs  = append(model.specs, s)
+    model.depths = append(model.depths, depth)
+
+    var writer = tuiLogWriter { state: state, spec: s }
+    s.SetConsole(& Console { Stdout: writer, Stderr: writer, StripANSI: true })
+
+    var names = make([]string, 0, len(s.Subspecs))
+    for name := range s.Subspecs {
+      names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+      walk(s.Subspecs[name], depth + 1)
+    }
+  }
+  walk(root, 0)
+
+  root.OnEvent(state.record)
+
+  var program = tea.NewProgram(model, tea.WithAltScreen())
+  var done    = make(chan struct{})
+
+  go func () {
+    defer close(done)
+    if _, err := program.Run(); err != nil {
+      fmt.Fprintf(os.Stderr, "Error running the TUI: %v\n", err)
+    }
+    state.close()
+  }()
+
+  return func () {
+    program.Send(tuiFinishedMsg {})
+    <-done
+  }
+}
+
+
+func tuiTick () tea.Cmd {
+  return tea.Tick(100 * time.Millisecond, func (t time.Time) tea.Msg {
+    return tuiTickMsg(t)
+  })
+}
+
+
+func (m *tuiModel) Init () tea.Cmd {
+  return tuiTick()
+}
+
+
+func (m *tuiModel) Update (msg tea.Msg) (tea.Model, tea.Cmd) {
+  switch msg := msg.(type) {
+  case tea.WindowSizeMsg:
+    if msg.Width > 0 && msg.Height > 0 {
+      m.width, m.height = msg.Width, msg.Height
+    }
+
+  case tuiTickMsg:
+    m.frame++
+    return m, tuiTick()
+
+  case tuiFinishedMsg:
+    m.finished = true
+    m.message  = "Finished, press q to exit"
+
+  case tea.KeyMsg:
+    switch msg.String() {
+    case "up", "k":
+      if m.selected > 0 {
+        m.selected--
+      }
+    case "down", "j":
+      if m.selected < len(m.specs) - 1 {
+        m.selected++
+      }
+    case "c":
+      var spec = m.specs[m.selected]
+      if spec.Cancel() {
+        m.message = fmt.Sprintf("Cancelled %s", spec.SpecPath())
+      } else {
+        m.message = fmt.Sprintf("%s is not running", spec.SpecPath())
+      }
+    case "q", "ctrl+c":
+      // Quitting during the run cancels it, and the rest of its
+      // output is printed
+      //
+      if !m.finished {
+        m.root.Cancel()
+      }
+      return m, tea.Quit
+    }
+  }
+
+  return m, nil
+}
+
+
+func (m *tuiModel) View () string {
+  m.state.lock.Lock()
+  defer m.state.lock.Unlock()
+
+  var now   = time.Now()
+  var lines []string
+
+  // Spec tree
+  //
+  for i, s := range m.specs {
+    var marker = "  "
+    if i == m.selected {
+      marker = "▸ "
+    }
+    var spec = m.state.spec(s)
+    lines = append(lines, marker + strings.Repeat("  ", m.depths[i]) + spec.summary(s.Name, m.frame, now))
+  }
+
+  // The selected spec's tasks, recent assets, and log
+  //
+  var selected = m.specs[m.selected]
+  var spec     = m.state.spec(selected)
+  status, _   := selected.Status()
+
+  lines = append(lines, strings.Repeat("─", m.width), fmt.Sprintf("%s: %s", selected.SpecPath(), status))
+
+  if len(spec.Tasks) > 0 {
+    lines = append(lines, "Tasks:")
+    for _, task := range tail(spec.Tasks, TUI_TASK_LINES) {
+      var icon, duration = task.Icon, task.Duration
+      if task.Running {
+        icon     = progress_spinner[m.frame % len(progress_spinner)]
+        duration = now.Sub(task.Start)
+      }
+      var line = fmt.Sprintf("  %s %s", icon, task.Name)
+      if icon != "~" {
+        line += "  " + duration.Round(100 * time.Millisecond).String()
+      }
+      lines = append(lines, line)
+    }
+  }
+
+  if len(spec.Recent) > 0 {
+    lines = append(lines, "Recent assets:")
+    for _, asset_path := range tail(spec.Recent, TUI_ASSET_LINES) {
+      lines = append(lines, "  " + asset_path)
+    }
+  }
+
+  // The log fills the remaining height, above the help line
+  //
+  var help = "↑/↓ select  c cancel spec  q quit"
+  if m.message != "" {
+    help = m.message + "  ·  " + help
+  }
+
+  var log_lines = m.height - len(lines) - 3
+  if log_lines > 0 && len(spec.Log) > 0 {
+    lines = append(lines, "Log:")
+    for _, line := range tail(spec.Log, log_lines) {
+      lines = append(lines, "  " + line)
+    }
+  }
+
+  for i, line := range lines {
+    lines[i] = tuiTruncate(line, m.width)
+  }
+  if len(lines) > m.height - 1 && m.height > 1 {
+    lines = lines[:m.height - 1]
+  }
+  lines = append(lines, tuiTruncate(help, m.width))
+
+  return strings.Join(lines, "\n")
+}
+
+
+/*
+  tail returns the last n elements of a slice.
+*/
+func tail [T any] (items []T, n int) []T {
+  if len(items) > n {
+    return items[len(items) - n:]
+  }
+  return items
+}
+
+
+/*
+  tuiTruncate keeps a line within the terminal's width.
+*/
+func tuiTruncate (line string, width int) string {
+  var text = []rune(line)
+  if width > 1 && len(text) > width {
+    text = append(text[:width - 1], '…')
+  }
+  return string(text)
+}
@@ -0,0 +1,186 @@
+package interbuilder
+
+import (
+  "fmt"
+  "os/exec"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+
+/*
+  A CommandRunner creates the commands ran by Tasks, through
+  Task.Command and the methods built on it, such as
+  Task.CommandRun. Setting one on a Spec with SetCommandRunner
+  changes how the commands of the Spec and its descendants are
+  ran, such as to record them in tests with a MockCommandRunner,
+  rather than run real programs.
+*/
+type CommandRunner interface {
+  Command (t *Task, name string, args ...string) *exec.Cmd
+}
+
+
+/*
+  SetCommandRunner sets the CommandRunner of this Spec and its
+  descendants which do not set their own.
+*/
+func (s *Spec) SetCommandRunner (runner CommandRunner) {
+  s.command_runner = runner
+}
+
+
+/*
+  CommandRunner returns the CommandRunner set on this Spec or its
+  nearest ancestor, or an ExecCommandRunner.
+*/
+func (s *Spec) CommandRunner () CommandRunner {
+  for spec := s; spec != nil; spec = spec.Parent {
+    if spec.command_runner != nil {
+      return spec.command_runner
+    }
+  }
+  return ExecCommandRunner {}
+}
+
+
+/*
+  ExecCommandRunner is the default CommandRunner, which runs
+  programs in the Spec's source_dir, with the environment
+  described in Task.Environ, and in the sandbox described in
+  Task.CommandSandbox. Commands are killed if the Task's Context
+  is cancelled.
+*/
+type ExecCommandRunner struct {}
+
+
+func (ExecCommandRunner) Command (t *Task, name string, args ...string) *exec.Cmd {
+  cmd := exec.CommandContext(t.Context(), name, args...)
+
+  // Build the environment from the process, the env and
+  // clean_env props, and Task.Env. Errors are deferred until the
+  // command is started.
+  //
+  if environ, err := t.Environ(); err != nil {
+    cmd.Err = fmt.Errorf("Error creating command environment: %w", err)
+  } else {
+    cmd.Env = environ
+  }
+
+  // Inherity working directory from source_dir prop
+  //
+  if t.Spec != nil {
+    cmd.Dir, _, _ = t.Spec.InheritPropString("source_dir")
+  }
+
+  // Restrict the command to the sandbox props
+  //
+  if sandbox, err := t.CommandSandbox(); err != nil {
+    cmd.Err = err
+  } else if err := sandbox.Apply(cmd); err != nil {
+    cmd.Err = err
+  }
+
+  return cmd
+}
+
+
+/*
+  RecordedCommand is a command created by a MockCommandRunner,
+  with the directory and environment it would have ran with.
+*/
+type RecordedCommand struct {
+  Spec  string
+  Task  string
+  Name  string
+  Args  []string
+  Dir   string
+  Env   []string
+}
+
+
+/*
+  String returns the program and arguments of the command,
+  separated by spaces.
+*/
+func (c *RecordedCommand) String () string {
+  return strings.Join(append([]string { c.Name }, c.Args...), " ")
+}
+
+
+/*
+  MockCommandResult is the output and exit code of a command ran
+  by a MockCommandRunner.
+*/
+type MockCommandResult struct {
+  Stdout    string
+  Stderr    string
+  ExitCode  int
+}
+
+
+/*
+  MockCommandRunner is a CommandRunner which records the commands
+  it creates, instead of running their programs, so that
+  behaviors which run programs such as git and npm can be tested
+  without them. Each command is passed to Handler as it is
+  created, which returns what the command outputs and exits with
+  once it is ran, and which may make the command's side effects,
+  such as writing files into its Dir. Without a Handler, commands
+  succeed without output. The commands which are returned only
+  run /bin/sh, to write the result.
+*/
+type MockCommandRunner struct {
+  Handler  func (cmd *RecordedCommand) MockCommandResult
+
+  commands  []*RecordedCommand
+  lock      sync.Mutex
+}
+
+
+func (r *MockCommandRunner) Command (t *Task, name string, args ...string) *exec.Cmd {
+  var recorded = & RecordedCommand {
+    Task: t.Name,
+    Name: name,
+    Args: append([]string(nil), args...),
+  }
+
+  environ, env_err := t.Environ()
+  recorded.Env = environ
+
+  if t.Spec != nil {
+    recorded.Spec      = t.Spec.SpecPath()
+    recorded.Dir, _, _ = t.Spec.InheritPropString("source_dir")
+  }
+
+  r.lock.Lock()
+  r.commands = append(r.commands, recorded)
+  var handler = r.Handler
+  r.lock.Unlock()
+
+  var result MockCommandResult
+  if handler != nil {
+    result = handler(recorded)
+  }
+
+  cmd := exec.CommandContext(t.Context(),
+    "/bin/sh", "-c", `printf %s "$1"; printf %s "$2" >&2; exit "$3"`, "sh",
+    result.Stdout, result.Stderr, strconv.Itoa(result.ExitCode),
+  )
+  if env_err != nil {
+    cmd.Err = fmt.Errorf("Error creating command environment: %w", env_err)
+  }
+  return cmd
+}
+
+
+/*
+  Commands returns the commands created so far, in the order they
+  were created.
+*/
+func (r *MockCommandRunner) Commands () []*RecordedCommand {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+  return append([]*RecordedCommand(nil), r.commands...)
+}
@@ -0,0 +1,75 @@
+package interbuilder
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+)
+
+
+func TestMockCommandRunner (t *testing.T) {
+  var root  *Spec = NewSpec("root", nil)
+  var child *Spec = root.AddSubspec(NewSpec("child", nil))
+
+  root.Props["quiet"]      = true
+  root.Props["clean_env"]  = true
+  root.Props["env"]        = map[string]any { "NODE_ENV": "production" }
+  child.Props["source_dir"] = "/nonexistent/child"
+
+  var runner = & MockCommandRunner {
+    Handler: func (cmd *RecordedCommand) MockCommandResult {
+      switch cmd.Name {
+      case "git":
+        return MockCommandResult { Stdout: "0123abcd\n" }
+      case "npm":
+        return MockCommandResult { Stderr: "npm ERR! missing script: build\n", ExitCode: 1 }
+      }
+      return MockCommandResult {}
+    },
+  }
+  root.SetCommandRunner(runner)
+
+  child.EnqueueTaskFunc("commands", func (s *Spec, tk *Task) error {
+    head, err := tk.Command("git", "rev-parse", "HEAD").Output()
+    if err != nil {
+      return fmt.Errorf("Error running the mocked git: %w", err)
+    } else if string(head) != "0123abcd\n" {
+      return fmt.Errorf("Expected the mocked output of git, got %q", head)
+    }
+
+    if _, err := tk.CommandRun("npm", "run", "build"); err == nil {
+      return fmt.Errorf("Expected the mocked npm to fail")
+    }
+
+    _, err = tk.CommandRun("sass", "in.scss", "out's.css")
+    return err
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var commands = runner.Commands()
+  var got []string
+  for _, cmd := range commands {
+    got = append(got, cmd.String())
+  }
+  if expect := "git rev-parse HEAD|npm run build|sass in.scss out's.css"; strings.Join(got, "|") != expect {
+    t.Errorf("Expected commands %s, got %s", expect, strings.Join(got, "|"))
+  }
+
+  // Commands are recorded with where, and how, they would have ran
+  //
+  var git = commands[0]
+  if git.Spec != "root/child" || git.Task != "commands" || git.Dir != "/nonexistent/child" {
+    t.Errorf("Unexpected recorded command: %+v", git)
+  }
+  if strings.Join(git.Env, " ") != "NODE_ENV=production" {
+    t.Errorf("Expected the command's environment, got %v", git.Env)
+  }
+
+  // Other specs run real commands
+  //
+  var other = NewSpec("other", nil)
+  if _, ok := other.CommandRunner().(ExecCommandRunner); !ok {
+    t.Errorf("Expected the default command runner, got %T", other.CommandRunner())
+  }
+}
@@ -0,0 +1,342 @@
+package interbuilder
+
+import (
+  "bytes"
+  "fmt"
+  "hash/fnv"
+  "io"
+  "net/url"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strings"
+  "sync"
+)
+
+
+/*
+  A Console is where the lines of a Spec tree, its Tasks, and
+  their commands are printed. Lines are written whole, one at a
+  time, so that the output of concurrent commands is never
+  interleaved within a line. With Color, the "[spec/task] "
+  prefixes of command output are colored, with a stable color for
+  each Spec and Task. With StripANSI, escape sequences, such as
+  colors, are removed from command output.
+*/
+type Console struct {
+  Stdout     io.Writer
+  Stderr     io.Writer
+  Color      bool
+  StripANSI  bool
+
+  lock       sync.Mutex
+}
+
+
+var default_console      *Console
+var default_console_once sync.Once
+
+
+/*
+  NewConsole returns a Console which writes to stdout and stderr,
+  or the process's standard output and error if they are nil. If
+  stdout is a terminal, prefixes are colored, unless the NO_COLOR
+  environment variable is set. Otherwise, escape sequences are
+  stripped from command output.
+*/
+func NewConsole (stdout, stderr io.Writer) *Console {
+  if stdout == nil {
+    stdout = os.Stdout
+  }
+  if stderr == nil {
+    stderr = os.Stderr
+  }
+
+  var terminal = IsTerminal(stdout)
+  return & Console {
+    Stdout:    stdout,
+    Stderr:    stderr,
+    Color:     terminal && os.Getenv("NO_COLOR") == "",
+    StripANSI: !terminal,
+  }
+}
+
+
+/*
+  IsTerminal returns whether a writer is a terminal, rather than a
+  file, pipe, or buffer.
+*/
+func IsTerminal (w io.Writer) bool {
+  file, ok := w.(*os.File)
+  if !ok {
+    return false
+  }
+  stat, err := file.Stat()
+  if err != nil {
+    return false
+  }
+  return stat.Mode() & os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+
+/*
+  write writes to one of the Console's writers, or a writer of
+  it, while holding its lock.
+*/
+func (c *Console) write (w io.Writer, p []byte) (int, error) {
+  c.lock.Lock()
+  defer c.lock.Unlock()
+  return w.Write(p)
+}
+
+
+var console_colors = []string { "31", "32", "33", "34", "35", "36", "91", "92", "93", "94", "95", "96" }
+
+
+/*
+  colorize colors text, if the Console has Color, with the color
+  of a label, which is the same each time it is colored.
+*/
+func (c *Console) colorize (label, text string) string {
+  if !c.Color {
+    return text
+  }
+  var hash = fnv.New32a()
+  hash.Write([]byte(label))
+  var color = console_colors[hash.Sum32() % uint32(len(console_colors))]
+  return "\x1b[" + color + "m" + text + "\x1b[0m"
+}
+
+
+var ansi_escape_regexp = regexp.MustCompile(`\x1b(\[[0-9;?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_])`)
+
+
+/*
+  StripANSI removes ANSI escape sequences, such as colors and
+  cursor movement, from text.
+*/
+func StripANSI (p []byte) []byte {
+  if bytes.IndexByte(p, 0x1b) < 0 {
+    return p
+  }
+  return ansi_escape_regexp.ReplaceAll(p, nil)
+}
+
+
+/*
+  SetConsole sets the Console this Spec, its Tasks, their
+  commands, and its descendants which do not set their own print
+  to.
+*/
+func (s *Spec) SetConsole (console *Console) {
+  s.console = console
+}
+
+
+/*
+  SetOutput sets the writers this Spec, its Tasks, their commands,
+  and its descendants print to, in place of the process's standard
+  output and error, such as to render progress around log lines,
+  or to capture them, as in SetConsole with NewConsole. A nil
+  writer is the process's standard output or error. Writers may be
+  written to concurrently.
+*/
+func (s *Spec) SetOutput (stdout, stderr io.Writer) {
+  s.SetConsole(NewConsole(stdout, stderr))
+}
+
+
+/*
+  Console returns the Console set on this Spec or its nearest
+  ancestor, or one writing to the process's standard output and
+  error.
+*/
+func (s *Spec) Console () *Console {
+  for spec := s; spec != nil; spec = spec.Parent {
+    if spec.console != nil {
+      return spec.console
+    }
+  }
+  default_console_once.Do(func () {
+    default_console = NewConsole(os.Stdout, os.Stderr)
+  })
+  return default_console
+}
+
+
+/*
+  Stdout returns the standard output writer of this Spec's
+  Console. While the Spec has a log file, what is written is also
+  copied to it.
+*/
+func (s *Spec) Stdout () io.Writer {
+  return s.teeLogFile(s.Console().Stdout)
+}
+
+
+/*
+  Stderr returns the standard error writer of this Spec's Console,
+  which the commands of its Tasks print their standard error to.
+  While the Spec has a log file, what is written is also copied to
+  it.
+*/
+func (s *Spec) Stderr () io.Writer {
+  return s.teeLogFile(s.Console().Stderr)
+}
+
+
+/*
+  print writes text to this Spec's Console and log file, coloring
+  a leading "[name] " prefix on the Console.
+*/
+func (s *Spec) print (text string) (int, error) {
+  var console = s.Console()
+  s.writeLogFile([]byte(text))
+
+  var prefix = "[" + s.Name + "] "
+  if console.Color && strings.HasPrefix(text, prefix) {
+    text = console.colorize(prefix, prefix) + text[len(prefix):]
+  }
+  return console.write(console.Stdout, []byte(text))
+}
+
+
+/*
+  Stdout returns the writer of this Task's Spec, or os.Stdout if
+  it has none.
+*/
+func (t *Task) Stdout () io.Writer {
+  if t.Spec == nil {
+    return os.Stdout
+  }
+  return t.Spec.Stdout()
+}
+
+
+/*
+  Stderr returns the standard error writer of this Task's Spec,
+  or os.Stderr if it has none.
+*/
+func (t *Task) Stderr () io.Writer {
+  if t.Spec == nil {
+    return os.Stderr
+  }
+  return t.Spec.Stderr()
+}
+
+
+/*
+  consoleLineWriter writes each Write, expected to be a line from
+  a PrefixWriter, to a writer of a Console, preceded by a colored
+  prefix. The line is also written to the log file of its Spec,
+  with a plain prefix and escape sequences stripped.
+*/
+type consoleLineWriter struct {
+  console         *Console
+  w               io.Writer
+  spec            *Spec
+  prefix          string
+  colored_prefix  string
+}
+
+
+func (w consoleLineWriter) Write (p []byte) (int, error) {
+  var n = len(p)
+
+  if w.spec != nil {
+    w.spec.writeLogFile(append([]byte(w.prefix), StripANSI(p)...))
+  }
+
+  if w.console.StripANSI {
+    p = StripANSI(p)
+  }
+  if _, err := w.console.write(w.w, append([]byte(w.colored_prefix), p...)); err != nil {
+    return 0, err
+  }
+  return n, nil
+}
+
+
+/*
+  openLogFile starts the log file of this Spec, if the inherited
+  "log_files" prop is true, at logs/<spec path>.log in the
+  directory of the inherited "state_dir" prop. Everything the Spec,
+  its Tasks, and their commands print is copied to it, but not
+  what its subspecs print, which have log files of their own. The
+  returned function closes it.
+*/
+func (s *Spec) openLogFile () (closeLogFile func (), err error) {
+  var noop = func () {}
+
+  log_files, ok, found := s.InheritPropBool("log_files")
+  if !found || (ok && !log_files) {
+    return noop, nil
+  } else if !ok {
+    return noop, fmt.Errorf("Prop \"log_files\" in Spec %s is expected to be a boolean, got %v", s.Name, s.Props["log_files"])
+  }
+
+  state_dir, ok, found := s.InheritPropString("state_dir")
+  if !found || state_dir == "" {
+    return noop, fmt.Errorf("Prop \"log_files\" in Spec %s requires a \"state_dir\" prop", s.Name)
+  } else if !ok {
+    return noop, fmt.Errorf("Prop \"state_dir\" in Spec %s is not a string", s.Name)
+  }
+
+  var log_dir = filepath.Join(state_dir, "logs")
+  if err := os.MkdirAll(log_dir, 0755); err != nil {
+    return noop, fmt.Errorf("Error creating log directory of Spec %s: %w", s.Name, err)
+  }
+
+  file, err := os.Create(filepath.Join(log_dir, url.PathEscape(s.SpecPath()) + ".log"))
+  if err != nil {
+    return noop, fmt.Errorf("Error creating log file of Spec %s: %w", s.Name, err)
+  }
+
+  s.log_file.Store(file)
+  return func () {
+    s.log_file.Store(nil)
+    file.Close()
+  }, nil
+}
+
+
+/*
+  writeLogFile writes to the log file of this Spec, if it has one.
+  It is used for output which is not written to Stdout, such as
+  records sent to a logger.
+*/
+func (s *Spec) writeLogFile (p []byte) {
+  if file := s.log_file.Load(); file != nil {
+    file.Write(p)
+  }
+}
+
+
+/*
+  teeLogFile returns a writer which copies writes to w into the
+  log file of this Spec, or w itself if it has no log file.
+*/
+func (s *Spec) teeLogFile (w io.Writer) io.Writer {
+  var file = s.log_file.Load()
+  if file == nil {
+    return w
+  }
+  return logFileTee { w, file }
+}
+
+
+/*
+  logFileTee writes to a writer and a log file. Errors writing to
+  the log file, such as for a command outliving its Spec's log
+  file, are ignored, so that they do not interrupt the console.
+*/
+type logFileTee struct {
+  w     io.Writer
+  file  *os.File
+}
+
+
+func (t logFileTee) Write (p []byte) (int, error) {
+  t.file.Write(p)
+  return t.w.Write(p)
+}
@@ -0,0 +1,191 @@
+package interbuilder
+
+import (
+  "bytes"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "sync"
+  "testing"
+)
+
+
+type lockedBuffer struct {
+  lock    sync.Mutex
+  buffer  bytes.Buffer
+}
+
+
+func (b *lockedBuffer) Write (p []byte) (int, error) {
+  b.lock.Lock()
+  defer b.lock.Unlock()
+  return b.buffer.Write(p)
+}
+
+
+func (b *lockedBuffer) String () string {
+  b.lock.Lock()
+  defer b.lock.Unlock()
+  return b.buffer.String()
+}
+
+
+func TestSpecSetOutput (t *testing.T) {
+  if _, err := exec.LookPath("sh"); err != nil {
+    t.Skip("sh is not available")
+  }
+
+  var root    = NewSpec("root", nil)
+  var subspec = root.AddSubspec(NewSpec("sub", nil))
+
+  var stdout, stderr lockedBuffer
+  root.SetOutput(&stdout, &stderr)
+
+  if subspec.Stdout() != &stdout || subspec.Stderr() != &stderr {
+    t.Fatalf("Expected subspecs to inherit the output writers")
+  }
+
+  subspec.EnqueueTaskFunc("print", func (s *Spec, tk *Task) error {
+    tk.Println("from the task")
+    _, err := tk.CommandRun("sh", "-c", "echo from stdout; echo from stderr >&2")
+    return err
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  for _, expect := range []string { "[sub] Running", "[sub/print] from the task", "[sub/print] $ sh", "[sub/print] from stdout" } {
+    if !strings.Contains(stdout.String(), expect) {
+      t.Errorf("Expected stdout to contain %q, got:\n%s", expect, stdout.String())
+    }
+  }
+  if got := stderr.String(); !strings.Contains(got, "{sub/print} from stderr") {
+    t.Errorf("Expected stderr to contain the command's standard error, got:\n%s", got)
+  }
+}
+
+
+func TestSpecLogFiles (t *testing.T) {
+  if _, err := exec.LookPath("sh"); err != nil {
+    t.Skip("sh is not available")
+  }
+
+  var state_dir = t.TempDir()
+  var root      = NewSpec("root", nil)
+  root.Props["state_dir"] = state_dir
+  root.Props["log_files"] = true
+
+  var console lockedBuffer
+  root.SetOutput(&console, &console)
+
+  for _, name := range []string { "a", "b" } {
+    var subspec = root.AddSubspec(NewSpec(name, nil))
+    subspec.EnqueueTaskFunc("print", func (s *Spec, tk *Task) error {
+      tk.Println("task of " + s.Name)
+      _, err := tk.CommandRun("sh", "-c", "echo stdout of " + s.Name + "; echo stderr of " + s.Name + " >&2")
+      return err
+    })
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  for _, name := range []string { "a", "b" } {
+    var other = map[string]string { "a": "b", "b": "a" }[name]
+
+    content, err := os.ReadFile(filepath.Join(state_dir, "logs", "root%2F" + name + ".log"))
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    for _, expect := range []string {
+      "[" + name + "] Running",
+      "[" + name + "/print] task of " + name,
+      "[" + name + "/print] stdout of " + name,
+      "{" + name + "/print} stderr of " + name,
+    } {
+      if !strings.Contains(string(content), expect) {
+        t.Errorf("Expected the log of %s to contain %q, got:\n%s", name, expect, content)
+      }
+      if !strings.Contains(console.String(), expect) {
+        t.Errorf("Expected the console to contain %q, got:\n%s", expect, console.String())
+      }
+    }
+    if strings.Contains(string(content), "of " + other) {
+      t.Errorf("Expected the log of %s not to contain the output of %s, got:\n%s", name, other, content)
+    }
+  }
+
+  content, err := os.ReadFile(filepath.Join(state_dir, "logs", "root.log"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if strings.Contains(string(content), "task of") {
+    t.Errorf("Expected the log of the root not to contain the output of subspecs, got:\n%s", content)
+  }
+}
+
+
+func TestConsoleLines (t *testing.T) {
+  if _, err := exec.LookPath("sh"); err != nil {
+    t.Skip("sh is not available")
+  }
+
+  var root = NewSpec("root", nil)
+
+  var output lockedBuffer
+  root.SetConsole(& Console { Stdout: &output, Stderr: &output, Color: true, StripANSI: true })
+
+  var names = []string { "a", "b", "c" }
+  for _, name := range names {
+    var subspec = root.AddSubspec(NewSpec(name, nil))
+    subspec.EnqueueTaskFunc("print", func (s *Spec, tk *Task) error {
+      // Each line is written in parts, some with escape sequences
+      //
+      _, err := tk.CommandRun("sh", "-c", `for i in 1 2 3 4 5 6 7 8 9 10; do printf 'one '; printf '\033[1mtwo\033[0m '; printf 'three\n'; done`)
+      return err
+    })
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var colors = map[string]string {}
+  for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+    if !strings.HasPrefix(line, "\x1b[") {
+      t.Errorf("Expected a colored prefix, got %q", line)
+      continue
+    }
+
+    color, rest, _ := strings.Cut(line[2:], "m")
+    prefix, content, _ := strings.Cut(rest, "\x1b[0m")
+
+    if previous, found := colors[prefix]; found && previous != color {
+      t.Errorf("Expected %q to keep the color %s, got %s", prefix, previous, color)
+    }
+    colors[prefix] = color
+
+    if strings.Contains(prefix, "/print] ") && !strings.Contains(content, "$ sh") && content != "one two three" {
+      t.Errorf("Expected whole lines of command output without escape sequences, got %q", line)
+    }
+  }
+
+  for _, name := range names {
+    if _, found := colors["[" + name + "/print] "]; !found {
+      t.Errorf("Expected output from %s, got:\n%s", name, output.String())
+    }
+  }
+}
+
+
+func TestStripANSI (t *testing.T) {
+  for input, expect := range map[string]string {
+    "plain":                           "plain",
+    "\x1b[31mred\x1b[0m":              "red",
+    "\x1b[1;38;5;208mbold\x1b[m text": "bold text",
+    "\x1b[2K\x1b[1Gline":              "line",
+    "\x1b]0;title\x07after":           "after",
+  } {
+    if got := string(StripANSI([]byte(input))); got != expect {
+      t.Errorf("StripANSI(%q): expected %q, got %q", input, expect, got)
+    }
+  }
+}
@@ -0,0 +1,235 @@
+package interbuilder
+
+import (
+  "bytes"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+)
+
+
+/*
+  ContentStore is a content-addressable store of Asset content: a
+  directory of files named by the SHA-256 hashes of their content,
+  so that identical content is stored once, across Assets and
+  across runs which share the directory. Content is written to a
+  temporary file and renamed into place, so concurrent writers of
+  the same content do not conflict.
+*/
+type ContentStore struct {
+  Dir  string
+}
+
+
+/*
+  NewContentStore returns the ContentStore in a directory,
+  creating it if it does not exist.
+*/
+func NewContentStore (dir string) (*ContentStore, error) {
+  if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+    return nil, fmt.Errorf("Error creating content store: %w", err)
+  }
+  return & ContentStore { Dir: dir }, nil
+}
+
+
+/*
+  ContentHash returns the hash content is stored by, the
+  hexadecimal SHA-256 of it.
+*/
+func ContentHash (content []byte) string {
+  sum := sha256.Sum256(content)
+  return hex.EncodeToString(sum[:])
+}
+
+
+/*
+  Path returns the path of the file content with a hash is stored
+  at, in a subdirectory named by the hash's first two characters.
+*/
+func (cs *ContentStore) Path (hash string) string {
+  if len(hash) < 2 {
+    return filepath.Join(cs.Dir, hash)
+  }
+  return filepath.Join(cs.Dir, hash[:2], hash)
+}
+
+
+/*
+  Has returns whether content with a hash is stored.
+*/
+func (cs *ContentStore) Has (hash string) bool {
+  _, err := os.Stat(cs.Path(hash))
+  return err == nil
+}
+
+
+/*
+  Put stores content, unless identical content is already stored,
+  and returns its hash.
+*/
+func (cs *ContentStore) Put (content []byte) (string, error) {
+  var hash = ContentHash(content)
+  if cs.Has(hash) {
+    return hash, nil
+  }
+
+  var blob_path = cs.Path(hash)
+  if err := os.MkdirAll(filepath.Dir(blob_path), os.ModePerm); err != nil {
+    return "", fmt.Errorf("Error storing content %s: %w", hash, err)
+  }
+
+  err := replaceFileAtomic(blob_path, func (file *os.File) error {
+    _, err := file.Write(content)
+    return err
+  })
+  if err != nil {
+    return "", fmt.Errorf("Error storing content %s: %w", hash, err)
+  }
+  return hash, nil
+}
+
+
+/*
+  PutReader stores the content read from a reader, streaming it
+  to a temporary file while hashing it, rather than reading it
+  into memory, and returns its hash.
+*/
+func (cs *ContentStore) PutReader (reader io.Reader) (string, error) {
+  file, err := os.CreateTemp(cs.Dir, ".tmp-*")
+  if err != nil {
+    return "", fmt.Errorf("Error storing content: %w", err)
+  }
+  var temp_path = file.Name()
+  defer os.Remove(temp_path)
+
+  var hasher = sha256.New()
+  _, err = io.Copy(io.MultiWriter(file, hasher), reader)
+  if close_err := file.Close(); err == nil {
+    err = close_err
+  }
+  if err != nil {
+    return "", fmt.Errorf("Error storing content: %w", err)
+  }
+
+  var hash = hex.EncodeToString(hasher.Sum(nil))
+  if cs.Has(hash) {
+    return hash, nil
+  }
+
+  var blob_path = cs.Path(hash)
+  if err := os.MkdirAll(filepath.Dir(blob_path), os.ModePerm); err != nil {
+    return "", fmt.Errorf("Error storing content %s: %w", hash, err)
+  }
+  if err := os.Rename(temp_path, blob_path); err != nil {
+    return "", fmt.Errorf("Error storing content %s: %w", hash, err)
+  }
+  return hash, nil
+}
+
+
+/*
+  Get returns the content stored with a hash, verifying that it
+  matches it.
+*/
+func (cs *ContentStore) Get (hash string) ([]byte, error) {
+  content, err := os.ReadFile(cs.Path(hash))
+  if err != nil {
+    return nil, fmt.Errorf("Error reading stored content %s: %w", hash, err)
+  }
+  if ContentHash(content) != hash {
+    return nil, fmt.Errorf("Stored content %s does not match its hash", hash)
+  }
+  return content, nil
+}
+
+
+/*
+  replaceFileAtomic writes a file by writing a temporary file in
+  its directory and renaming it into place.
+*/
+func replaceFileAtomic (file_path string, write func (*os.File) error) error {
+  file, err := os.CreateTemp(filepath.Dir(file_path), ".tmp-*")
+  if err != nil { return err }
+  var temp_path = file.Name()
+
+  err = write(file)
+  if close_err := file.Close(); err == nil {
+    err = close_err
+  }
+  if err == nil {
+    err = os.Rename(temp_path, file_path)
+  }
+  if err != nil {
+    os.Remove(temp_path)
+  }
+  return err
+}
+
+
+/*
+  ContentStore returns the ContentStore in the directory of this
+  Spec's inherited "content_store" prop, or nil if it is not set.
+*/
+func (s *Spec) ContentStore () (*ContentStore, error) {
+  dir, ok, found := s.InheritPropString("content_store")
+  if found && !ok {
+    prop, _ := s.InheritProp("content_store")
+    return nil, fmt.Errorf("Prop \"content_store\" in spec %s is expected to be a string, got %T", s.Name, prop)
+  } else if dir == "" {
+    return nil, nil
+  }
+  return NewContentStore(dir)
+}
+
+
+/*
+  StoreContent releases a singular Asset's in-memory byte content,
+  as in Stage, putting it in a ContentStore and recording its
+  ContentHash, from which it is read back lazily. Identical
+  content of other Assets is only stored once. Returns whether
+  the asset was stored.
+*/
+func (a *Asset) StoreContent (cs *ContentStore) (bool, error) {
+  if !a.IsSingle() || a.ContentBytes == nil || a.ContentData != nil {
+    return false, nil
+  }
+
+  // The content is stored encoded, as it is decoded when read
+  // back through the reader function
+  //
+  content, err := a.EncodedContentBytes()
+  if err != nil {
+    return false, fmt.Errorf("Error storing asset %s: %w", a.Url, err)
+  }
+
+  hash, err := cs.Put(content)
+  if err != nil {
+    return false, fmt.Errorf("Error storing asset %s: %w", a.Url, err)
+  }
+
+  if err := a.setContentStoreReader(cs, hash); err != nil {
+    return false, err
+  }
+
+  a.ContentBytes = nil
+  return true, nil
+}
+
+
+/*
+  setContentStoreReader sets an Asset's ContentHash, and its reader
+  function to read the content with that hash from a
+  ContentStore.
+*/
+func (a *Asset) setContentStoreReader (cs *ContentStore, hash string) error {
+  a.ContentHash = hash
+  return a.SetContentBytesGetReaderFunc(func (*Asset) (io.Reader, error) {
+    content, err := cs.Get(hash)
+    if err != nil { return nil, err }
+    return bytes.NewReader(content), nil
+  })
+}
@@ -0,0 +1,176 @@
+package interbuilder
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+func countStoredBlobs (t *testing.T, dir string) int {
+  t.Helper()
+  blobs, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+  if err != nil { t.Fatal(err) }
+  return len(blobs)
+}
+
+
+func TestContentStore (t *testing.T) {
+  cs, err := NewContentStore(filepath.Join(t.TempDir(), "cas"))
+  if err != nil { t.Fatal(err) }
+
+  hash, err := cs.Put([]byte("shared content"))
+  if err != nil { t.Fatal(err) }
+  if hash != ContentHash([]byte("shared content")) || !cs.Has(hash) {
+    t.Errorf("Expected the content to be stored by its hash, got %s", hash)
+  }
+
+  if again, err := cs.Put([]byte("shared content")); err != nil || again != hash {
+    t.Errorf("Expected identical content to have the same hash, got %s (%v)", again, err)
+  }
+  if _, err := cs.Put([]byte("other content")); err != nil {
+    t.Fatal(err)
+  }
+  if streamed, err := cs.PutReader(strings.NewReader("shared content")); err != nil || streamed != hash {
+    t.Errorf("Expected streamed content to have the same hash, got %s (%v)", streamed, err)
+  }
+  if blobs := countStoredBlobs(t, cs.Dir); blobs != 2 {
+    t.Errorf("Expected 2 stored blobs, got %d", blobs)
+  }
+
+  if content, err := cs.Get(hash); err != nil || string(content) != "shared content" {
+    t.Errorf("Expected the stored content, got %q (%v)", content, err)
+  }
+
+  // Content which no longer matches its hash is not read
+  //
+  if err := os.WriteFile(cs.Path(hash), []byte("corrupted"), 0o644); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := cs.Get(hash); err == nil {
+    t.Errorf("Expected an error reading corrupted content")
+  }
+  if _, err := cs.Get(ContentHash([]byte("missing"))); err == nil {
+    t.Errorf("Expected an error reading content which is not stored")
+  }
+}
+
+
+func TestTaskAssetBufferContentStore (t *testing.T) {
+  var store_dir = filepath.Join(t.TempDir(), "cas")
+
+  var root = NewSpec("root", nil)
+  root.Props["quiet"]              = true
+  root.Props["asset_buffer_limit"] = float64(1)
+  root.Props["content_store"]      = store_dir
+
+  // Assets beyond the limit are staged in the content store, with
+  // identical content stored once
+  //
+  var contents = []string { "first", "duplicate", "duplicate", "last" }
+
+  root.EnqueueTaskFunc("produce", func (s *Spec, tk *Task) error {
+    for i, content := range contents {
+      var asset = s.MakeAsset(fmt.Sprintf("%d.txt", i))
+      asset.SetContentBytes([]byte(content))
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    for i, asset := range tk.Assets {
+      if stored, expect := asset.ContentHash != "", i > 0; stored != expect {
+        return fmt.Errorf("Expected asset %d to be stored: %t, got %t", i, expect, stored)
+      }
+
+      content, err := asset.GetContentBytes()
+      if err != nil {
+        return err
+      } else if string(content) != contents[i] {
+        return fmt.Errorf("Expected asset %d content %q, got %q", i, contents[i], content)
+      }
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if blobs := countStoredBlobs(t, store_dir); blobs != 2 {
+    t.Errorf("Expected 2 stored blobs, got %d", blobs)
+  }
+
+  root.Props["content_store"] = true
+  if _, err := root.ContentStore(); err == nil {
+    t.Errorf("Expected an error from a boolean content_store prop")
+  }
+}
+
+
+func TestSpecCheckpointContentStore (t *testing.T) {
+  var state_dir = t.TempDir()
+  var store_dir = filepath.Join(t.TempDir(), "cas")
+  var runs int
+
+  var makeTree = func (resume bool) (*Spec, *string) {
+    root  := NewSpec("root", nil)
+    child := root.AddSubspec(NewSpec("child", nil))
+    root.Props["quiet"]         = true
+    root.Props["state_dir"]     = state_dir
+    root.Props["content_store"] = store_dir
+    root.Props["resume"]        = resume
+
+    child.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+      runs++
+      for _, name := range []string { "a.txt", "b.txt" } {
+        asset := s.MakeAsset(name)
+        asset.SetContentBytes([]byte("same content"))
+        if err := s.EmitAsset(asset); err != nil {
+          return err
+        }
+      }
+      return nil
+    })
+
+    var received string
+    root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+      for asset := range s.Input {
+        content, err := asset.GetContentBytes()
+        if err != nil {
+          return err
+        }
+        received += asset.Url.Path + "=" + string(content) + " "
+      }
+      return nil
+    })
+
+    return root, &received
+  }
+
+  root, _ := makeTree(false)
+  TestWrapTimeoutError(t, root.Run)
+
+  // Checkpointed content is recorded in the store, rather than the
+  // state directory
+  //
+  if blobs := countStoredBlobs(t, store_dir); blobs != 1 {
+    t.Errorf("Expected the checkpointed content to be stored once, got %d blobs", blobs)
+  }
+  if assets, _ := filepath.Glob(filepath.Join(state_dir, "*", "assets", "*")); len(assets) != 0 {
+    t.Errorf("Expected no asset files in the state directory, got %v", assets)
+  }
+
+  root, received := makeTree(true)
+  TestWrapTimeoutError(t, root.Run)
+
+  if runs != 1 {
+    t.Errorf("Expected the child to run once, ran %d times", runs)
+  }
+  if expect := "@emit/a.txt=same content @emit/b.txt=same content "; *received != expect {
+    t.Errorf("Expected the resumed assets %q, got %q", expect, *received)
+  }
+}
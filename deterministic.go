@@ -0,0 +1,153 @@
+package interbuilder
+
+import (
+  "fmt"
+  "sort"
+  "sync"
+)
+
+
+const (
+  ORDER_URL      = "url"
+  ORDER_SUBSPECS = "subspecs"
+)
+
+
+/*
+  deterministicOrder returns how this Spec orders the Assets it
+  receives from its subspecs, from the inherited "deterministic"
+  prop: ORDER_URL if it is true or "url", ORDER_SUBSPECS if it is
+  "subspecs", and an empty string if it is false or unset, in
+  which case Assets are received in the order they arrive.
+*/
+func (s *Spec) deterministicOrder () (string, error) {
+  order_any, found := s.InheritProp("deterministic")
+  if !found {
+    return "", nil
+  }
+
+  switch order := order_any.(type) {
+  case bool:
+    if order {
+      return ORDER_URL, nil
+    }
+    return "", nil
+  case string:
+    if order == ORDER_URL || order == ORDER_SUBSPECS {
+      return order, nil
+    }
+  }
+
+  return "", fmt.Errorf(
+    "Prop \"deterministic\" in Spec %s is expected to be a boolean, \"url\", or \"subspecs\", got %v",
+    s.Name, order_any,
+  )
+}
+
+
+/*
+  reorderInput routes the output of this Spec's subspecs into
+  buffers, and once every subspec has finished, sends the
+  buffered Assets to this Spec's Input in a reproducible order,
+  rather than the order goroutine scheduling delivers them in.
+  With ORDER_URL, multi-assets are flattened and all Assets are
+  sorted by URL. With ORDER_SUBSPECS, the Assets of each subspec
+  are sent together, in the order of the subspecs' names, and in
+  the order each subspec emitted them. Assets overridden under
+  the "merge_conflicts" policy are not sent. It must be called
+  before the subspecs run, and returns the function which closes
+  the Input, to be called once they have finished.
+*/
+func (s *Spec) reorderInput (order string) (closeInput func ()) {
+  var names = make([]string, 0, len(s.Subspecs))
+  for name := range s.Subspecs {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  var inputs   = make([]chan *Asset, len(names))
+  var received = make([][]*Asset, len(names))
+  var group sync.WaitGroup
+
+  for name_i, name := range names {
+    inputs[name_i] = make(chan *Asset)
+
+    var subspec = s.Subspecs[name]
+    for output_i, output := range subspec.OutputChannels {
+      if output == &s.Input {
+        subspec.OutputChannels[output_i] = &inputs[name_i]
+      }
+    }
+
+    group.Add(1)
+    go func () {
+      defer group.Done()
+      for asset := range inputs[name_i] {
+        received[name_i] = append(received[name_i], asset)
+      }
+    }()
+  }
+
+  var all_closed = make(chan struct{})
+
+  go func () {
+    <-all_closed
+    group.Wait()
+
+    var assets []*Asset
+    for _, subspec_assets := range received {
+      for _, asset := range subspec_assets {
+        if !s.mergeOverridden(asset) {
+          assets = append(assets, asset)
+        }
+      }
+    }
+
+    if order == ORDER_URL {
+      assets = flattenForOrdering(assets)
+      sort.SliceStable(assets, func (i, j int) bool {
+        return orderingKey(assets[i]) < orderingKey(assets[j])
+      })
+    }
+
+    for _, asset := range assets {
+      s.Input <- asset
+    }
+    close(s.Input)
+  }()
+
+  return func () {
+    for _, input := range inputs {
+      close(input)
+    }
+    close(all_closed)
+  }
+}
+
+
+/*
+  flattenForOrdering flattens multi-assets so that their Assets
+  can be ordered individually. Multi-assets which cannot be
+  flattened are kept, and ordered by their own URL.
+*/
+func flattenForOrdering (assets []*Asset) []*Asset {
+  var flattened = make([]*Asset, 0, len(assets))
+  for _, asset := range assets {
+    if asset.IsSingle() {
+      flattened = append(flattened, asset)
+    } else if assets, err := asset.Flatten(); err == nil {
+      flattened = append(flattened, assets...)
+    } else {
+      flattened = append(flattened, asset)
+    }
+  }
+  return flattened
+}
+
+
+func orderingKey (a *Asset) string {
+  if a.Url == nil {
+    return ""
+  }
+  return a.Url.String()
+}
@@ -0,0 +1,74 @@
+package interbuilder
+
+import (
+  "strings"
+  "testing"
+  "time"
+)
+
+
+func TestDeterministicOrder (t *testing.T) {
+  // Subspecs emit their assets in reverse order of their names,
+  // with later subspecs emitting first
+  //
+  var run = func (deterministic any) ([]string, error) {
+    var root = NewSpec("root", nil)
+    root.Props["quiet"] = true
+    if deterministic != nil {
+      root.Props["deterministic"] = deterministic
+    }
+
+    for spec_i, name := range []string { "a", "b", "c" } {
+      var subspec = root.AddSubspec(NewSpec(name, nil))
+      var delay   = time.Duration(2 - spec_i) * 10 * time.Millisecond
+      subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+        time.Sleep(delay)
+        for _, key := range []string { "2.html", "1.html" } {
+          asset := s.MakeAsset(name + "/" + key)
+          if err := tk.EmitAsset(asset); err != nil {
+            return err
+          }
+        }
+        return nil
+      })
+    }
+
+    var received []string
+    root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+      if err := tk.PoolSpecInputAssets(); err != nil {
+        return err
+      }
+      for _, chunk := range tk.Assets {
+        assets, err := chunk.Flatten()
+        if err != nil { return err }
+        for _, asset := range assets {
+          received = append(received, strings.TrimPrefix(asset.Url.Path, "@emit/"))
+        }
+      }
+      return nil
+    })
+
+    return received, root.Run()
+  }
+
+  for _, test_case := range []struct {
+    deterministic  any
+    expect         string
+  } {
+    { true,       "a/1.html,a/2.html,b/1.html,b/2.html,c/1.html,c/2.html" },
+    { "url",      "a/1.html,a/2.html,b/1.html,b/2.html,c/1.html,c/2.html" },
+    { "subspecs", "a/2.html,a/1.html,b/2.html,b/1.html,c/2.html,c/1.html" },
+  } {
+    received, err := run(test_case.deterministic)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if got := strings.Join(received, ","); got != test_case.expect {
+      t.Errorf("With deterministic %v, expected the order %s, got %s", test_case.deterministic, test_case.expect, got)
+    }
+  }
+
+  if _, err := run("random"); err == nil || !strings.Contains(err.Error(), "deterministic") {
+    t.Errorf("Expected an invalid deterministic prop to be an error, got %v", err)
+  }
+}
@@ -0,0 +1,132 @@
+package interbuilder
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "runtime"
+  "runtime/pprof"
+  "sort"
+  "sync"
+  "text/tabwriter"
+)
+
+
+/*
+  running_roots are the root Specs which are running, for
+  WriteDiagnostics to report on.
+*/
+var running_roots      = make(map[*Spec]struct{})
+var running_roots_lock sync.Mutex
+
+
+func addRunningRoot (s *Spec) {
+  running_roots_lock.Lock()
+  defer running_roots_lock.Unlock()
+  running_roots[s] = struct{}{}
+}
+
+
+func removeRunningRoot (s *Spec) {
+  running_roots_lock.Lock()
+  defer running_roots_lock.Unlock()
+  delete(running_roots, s)
+}
+
+
+/*
+  RunningRoots returns the root Specs which are running, ordered
+  by name.
+*/
+func RunningRoots () []*Spec {
+  running_roots_lock.Lock()
+  var roots = make([]*Spec, 0, len(running_roots))
+  for root := range running_roots {
+    roots = append(roots, root)
+  }
+  running_roots_lock.Unlock()
+
+  sort.Slice(roots, func (i, j int) bool {
+    return roots[i].Name < roots[j].Name
+  })
+  return roots
+}
+
+
+/*
+  labelSpecContext labels a Spec's goroutine with its path, so
+  that goroutine profiles and dumps, such as those of
+  WriteDiagnostics, show which Spec a goroutine belongs to.
+  Goroutines started by the Spec inherit the label.
+*/
+func labelSpecContext (ctx context.Context, s *Spec) context.Context {
+  ctx = pprof.WithLabels(ctx, pprof.Labels("spec", s.SpecPath()))
+  pprof.SetGoroutineLabels(ctx)
+  return ctx
+}
+
+
+/*
+  labelTaskContext labels a Spec's goroutine with the Task it is
+  running, in addition to its Spec.
+*/
+func labelTaskContext (ctx context.Context, tk *Task) context.Context {
+  ctx = pprof.WithLabels(ctx, pprof.Labels("task", tk.Name))
+  pprof.SetGoroutineLabels(ctx)
+  return ctx
+}
+
+
+/*
+  CurrentTaskName returns the name of the Task this Spec is
+  running, or an empty string.
+*/
+func (s *Spec) CurrentTaskName () string {
+  s.task_queue_lock.Lock()
+  defer s.task_queue_lock.Unlock()
+  if s.CurrentTask == nil || !s.Running {
+    return ""
+  }
+  return s.CurrentTask.Name
+}
+
+
+/*
+  WriteDiagnostics writes a report for diagnosing stuck or
+  memory-hungry pipelines: the Go runtime's memory statistics,
+  the status, current Task, and buffered Asset memory of each
+  Spec of the running roots, and a dump of every goroutine. The
+  goroutines of Specs are labeled with their "spec" path and the
+  "task" they are running.
+*/
+func WriteDiagnostics (w io.Writer) error {
+  var mem runtime.MemStats
+  runtime.ReadMemStats(&mem)
+
+  fmt.Fprintf(w, "Runtime:\n")
+  fmt.Fprintf(w, "  goroutines:  %d\n", runtime.NumGoroutine())
+  fmt.Fprintf(w, "  heap alloc:  %d\n", mem.HeapAlloc)
+  fmt.Fprintf(w, "  heap inuse:  %d\n", mem.HeapInuse)
+  fmt.Fprintf(w, "  sys:         %d\n", mem.Sys)
+  fmt.Fprintf(w, "  gc cycles:   %d\n", mem.NumGC)
+
+  fmt.Fprintf(w, "\nSpecs:\n")
+  var tw = tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+  fmt.Fprintf(tw, "  PATH\tSTATUS\tTASK\tASSETS\tBYTES\tEVICTED\n")
+  for _, root := range RunningRoots() {
+    for _, report := range root.StatusReport() {
+      var stats = report.Spec.MemoryStats()
+      fmt.Fprintf(
+        tw, "  %s\t%s\t%s\t%d\t%d\t%d\n",
+        report.Path, report.Status, report.Spec.CurrentTaskName(),
+        stats.Assets, stats.ContentBytes + stats.ContentData, stats.Evicted,
+      )
+    }
+  }
+  if err := tw.Flush(); err != nil {
+    return err
+  }
+
+  fmt.Fprintf(w, "\nGoroutines:\n")
+  return pprof.Lookup("goroutine").WriteTo(w, 1)
+}
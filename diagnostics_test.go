@@ -0,0 +1,54 @@
+package interbuilder
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+)
+
+
+func TestWriteDiagnostics (t *testing.T) {
+  var root = NewSpec("diagnosed", nil)
+  var site = root.AddSubspec(NewSpec("site", nil))
+  root.Props["quiet"] = true
+
+  var report bytes.Buffer
+  var written = make(chan error)
+
+  site.EnqueueTaskFunc("stuck", func (s *Spec, tk *Task) error {
+    // Diagnose the pipeline while this task is running
+    //
+    report.Reset()
+    written <- WriteDiagnostics(&report)
+    return nil
+  })
+
+  var run_err = make(chan error)
+  go func () { run_err <- root.Run() }()
+
+  if err := <-written; err != nil {
+    t.Fatal(err)
+  }
+  if err := <-run_err; err != nil {
+    t.Fatal(err)
+  }
+
+  var output = report.String()
+  for _, expect := range []string {
+    "goroutines:",
+    "diagnosed/site",
+    "stuck",
+    `"spec":"diagnosed/site"`,
+    `"task":"stuck"`,
+  } {
+    if !strings.Contains(output, expect) {
+      t.Errorf("Expected the diagnostics to contain %s, got:\n%s", expect, output)
+    }
+  }
+
+  for _, running := range RunningRoots() {
+    if running == root {
+      t.Errorf("Expected the root not to be listed as running after it finished")
+    }
+  }
+}
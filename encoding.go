@@ -0,0 +1,225 @@
+package interbuilder
+
+import (
+  "github.com/andybalholm/brotli"
+
+  "bytes"
+  "compress/gzip"
+  "errors"
+  "fmt"
+  "io"
+  "strings"
+)
+
+
+/*
+  contentEncodings parses a Content-Encoding, a comma-separated
+  list of encodings in the order they were applied, such as
+  "gzip" or "gzip, br", returning its encodings, other than
+  "identity", and an error for those which are not supported:
+  "gzip", "x-gzip", and "br".
+*/
+func contentEncodings (encoding string) ([]string, error) {
+  var encodings []string
+
+  for _, name := range strings.Split(encoding, ",") {
+    name = strings.ToLower(strings.TrimSpace(name))
+
+    switch name {
+    case "", "identity":
+      continue
+    case "gzip", "x-gzip", "br":
+      encodings = append(encodings, name)
+    default:
+      return nil, fmt.Errorf("Unsupported content encoding %q", name)
+    }
+  }
+
+  return encodings, nil
+}
+
+
+/*
+  contentReadCloser closes the decoders of a content reader, and
+  the reader they read from, if it is a closer.
+*/
+type contentReadCloser struct {
+  io.Reader
+  closers  []io.Closer
+}
+
+
+func (r *contentReadCloser) Close () error {
+  var errs []error
+  for i := len(r.closers) - 1; i >= 0; i-- {
+    errs = append(errs, r.closers[i].Close())
+  }
+  return errors.Join(errs...)
+}
+
+
+/*
+  DecodeContentReader returns a reader of the content read from r,
+  decoded from a Content-Encoding, as in Asset.ContentEncoding.
+  If the encoding is empty or "identity", r is returned. Otherwise,
+  the returned reader is an io.ReadCloser, which closes r if it is
+  an io.Closer.
+*/
+func DecodeContentReader (encoding string, r io.Reader) (io.Reader, error) {
+  encodings, err := contentEncodings(encoding)
+  if err != nil || len(encodings) == 0 {
+    return r, err
+  }
+
+  var decoded = & contentReadCloser { Reader: r }
+  if closer, ok := r.(io.Closer); ok {
+    decoded.closers = append(decoded.closers, closer)
+  }
+
+  // Encodings are removed in the reverse of the order they were
+  // applied in
+  //
+  for i := len(encodings) - 1; i >= 0; i-- {
+    switch encodings[i] {
+    case "gzip", "x-gzip":
+      reader, err := gzip.NewReader(decoded.Reader)
+      if err != nil {
+        decoded.Close()
+        return nil, fmt.Errorf("Error decoding gzip content: %w", err)
+      }
+      decoded.Reader  = reader
+      decoded.closers = append(decoded.closers, reader)
+    case "br":
+      decoded.Reader = brotli.NewReader(decoded.Reader)
+    }
+  }
+
+  return decoded, nil
+}
+
+
+/*
+  contentWriteCloser flushes the encoders of a content writer, and
+  closes the writer they write to, if it is a closer.
+*/
+type contentWriteCloser struct {
+  io.Writer
+  closers  []io.Closer
+}
+
+
+func (w *contentWriteCloser) Close () error {
+  var errs []error
+  for i := len(w.closers) - 1; i >= 0; i-- {
+    errs = append(errs, w.closers[i].Close())
+  }
+  return errors.Join(errs...)
+}
+
+
+/*
+  EncodeContentWriter returns a writer which encodes the content
+  written to it with a Content-Encoding, as in
+  Asset.ContentEncoding, and writes it to w. If the encoding is
+  empty or "identity", w is returned. Otherwise, the returned writer
+  is an io.WriteCloser, which must be closed to flush the encoded
+  content, and which closes w if it is an io.Closer.
+*/
+func EncodeContentWriter (encoding string, w io.Writer) (io.Writer, error) {
+  encodings, err := contentEncodings(encoding)
+  if err != nil || len(encodings) == 0 {
+    return w, err
+  }
+
+  var encoded = & contentWriteCloser { Writer: w }
+  if closer, ok := w.(io.Closer); ok {
+    encoded.closers = append(encoded.closers, closer)
+  }
+
+  // The last encoding applied is the outermost, so it writes to w
+  //
+  for i := len(encodings) - 1; i >= 0; i-- {
+    var writer io.WriteCloser
+    switch encodings[i] {
+    case "gzip", "x-gzip":
+      writer = gzip.NewWriter(encoded.Writer)
+    case "br":
+      writer = brotli.NewWriter(encoded.Writer)
+    }
+    encoded.Writer  = writer
+    encoded.closers = append(encoded.closers, writer)
+  }
+
+  return encoded, nil
+}
+
+
+/*
+  EncodedContentBytes returns the content of a singular Asset
+  encoded with its ContentEncoding, as it would be stored, such as
+  when writing it to a file. Without a ContentEncoding, this is
+  the same as GetContentBytes.
+*/
+func (a *Asset) EncodedContentBytes () ([]byte, error) {
+  content, err := a.GetContentBytes()
+  if err != nil || a.ContentEncoding == "" {
+    return content, err
+  }
+
+  var buffer bytes.Buffer
+  writer, err := EncodeContentWriter(a.ContentEncoding, &buffer)
+  if err != nil {
+    return nil, fmt.Errorf("Cannot encode content of asset %s: %w", a.Url, err)
+  }
+  if _, err := writer.Write(content); err != nil {
+    return nil, err
+  }
+  if closer, ok := writer.(io.Closer); ok {
+    if err := closer.Close(); err != nil {
+      return nil, err
+    }
+  }
+
+  return buffer.Bytes(), nil
+}
+
+
+/*
+  ContentReader returns a reader of the content of a singular
+  Asset, as in GetContentBytes. Content which is not already in
+  memory, such as that of a file asset, is streamed from
+  ContentBytesGetReader without being read into ContentBytes, so
+  large files may be hashed or copied through their memory-mapped
+  readers. Readers which are io.Closers should be closed.
+*/
+func (a *Asset) ContentReader () (io.Reader, error) {
+  if a.ContentBytes == nil && a.ContentData == nil && a.content_bytes_get_reader_func != nil {
+    return a.ContentBytesGetReader()
+  }
+
+  content, err := a.GetContentBytes()
+  if err != nil {
+    return nil, err
+  }
+  return bytes.NewReader(content), nil
+}
+
+
+/*
+  EncodedContentReader returns a reader of the content of a
+  singular Asset encoded with its ContentEncoding, as in
+  EncodedContentBytes. Content which is not already in memory is
+  streamed from the Asset's reader function, without reading it
+  into the Asset, as in ContentReader.
+*/
+func (a *Asset) EncodedContentReader () (io.Reader, error) {
+  if a.ContentBytes == nil && a.ContentData == nil && a.content_bytes_get_reader_func != nil {
+    return a.content_bytes_get_reader_func(a)
+  }
+
+  content, err := a.EncodedContentBytes()
+  if err != nil {
+    return nil, err
+  }
+  return bytes.NewReader(content), nil
+}
@@ -0,0 +1,157 @@
+package interbuilder
+
+import (
+  "bytes"
+  "compress/gzip"
+  "io"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+
+func TestContentEncoding (t *testing.T) {
+  var content = []byte("<p>Compressed content</p>")
+
+  for _, encoding := range []string { "", "identity", "gzip", "x-gzip", "br", "gzip, br" } {
+    var encoded bytes.Buffer
+    writer, err := EncodeContentWriter(encoding, &encoded)
+    if err != nil { t.Fatal(err) }
+    writer.Write(content)
+    if closer, ok := writer.(io.Closer); ok {
+      if err := closer.Close(); err != nil { t.Fatal(err) }
+    }
+
+    if encoding != "" && encoding != "identity" && bytes.Equal(encoded.Bytes(), content) {
+      t.Errorf("Expected content to be encoded with %q", encoding)
+    }
+
+    reader, err := DecodeContentReader(encoding, &encoded)
+    if err != nil { t.Fatal(err) }
+    decoded, err := io.ReadAll(reader)
+    if err != nil || !bytes.Equal(decoded, content) {
+      t.Errorf("Expected content decoded from %q to be %q, got %q (%v)", encoding, content, decoded, err)
+    }
+  }
+
+  if _, err := DecodeContentReader("compress", bytes.NewReader(content)); err == nil {
+    t.Errorf("Expected an error decoding an unsupported encoding")
+  }
+  if _, err := DecodeContentReader("gzip", bytes.NewReader(content)); err == nil {
+    t.Errorf("Expected an error decoding content which is not gzipped")
+  }
+}
+
+
+func TestAssetContentEncoding (t *testing.T) {
+  var source_dir = t.TempDir()
+  var file_path  = filepath.Join(source_dir, "sitemap.xml.gz")
+
+  var compressed bytes.Buffer
+  var writer = gzip.NewWriter(&compressed)
+  writer.Write([]byte("<urlset></urlset>"))
+  writer.Close()
+  if err := os.WriteFile(file_path, compressed.Bytes(), 0o644); err != nil {
+    t.Fatal(err)
+  }
+
+  root := NewSpec("root", nil)
+  root.Props["source_dir"] = source_dir
+
+  asset, err := root.MakeFileKeyAsset("sitemap.xml.gz")
+  if err != nil { t.Fatal(err) }
+  asset.ContentEncoding = "gzip"
+
+  // Content is read decoded, and stored encoded
+  //
+  content, err := asset.GetContentBytes()
+  if err != nil || string(content) != "<urlset></urlset>" {
+    t.Fatalf("Expected the decoded content, got %q (%v)", content, err)
+  }
+
+  asset.SetContentBytes([]byte("<urlset><url/></urlset>"))
+  encoded, err := asset.EncodedContentBytes()
+  if err != nil { t.Fatal(err) }
+
+  reader, err := gzip.NewReader(bytes.NewReader(encoded))
+  if err != nil { t.Fatal(err) }
+  if decoded, _ := io.ReadAll(reader); string(decoded) != "<urlset><url/></urlset>" {
+    t.Errorf("Expected the encoded content to be the modified content, got %q", decoded)
+  }
+
+  // Writing through the asset encodes the file
+  //
+  file_writer, err := asset.ContentBytesGetWriter()
+  if err != nil { t.Fatal(err) }
+  file_writer.Write([]byte("<urlset>written</urlset>"))
+  if err := file_writer.(io.Closer).Close(); err != nil {
+    t.Fatal(err)
+  }
+
+  asset.ContentBytes = nil
+  if content, err := asset.GetContentBytes(); err != nil || string(content) != "<urlset>written</urlset>" {
+    t.Errorf("Expected the written content to be read back, got %q (%v)", content, err)
+  }
+
+  asset.ContentEncoding = "compress"
+  if _, err := asset.EncodedContentBytes(); err == nil {
+    t.Errorf("Expected an error encoding with an unsupported encoding")
+  }
+}
+
+
+func TestAssetContentEncodingStage (t *testing.T) {
+  root := NewSpec("root", nil)
+
+  var asset = root.MakeAsset("data.json.gz")
+  asset.ContentEncoding = "gzip"
+  asset.SetContentBytes([]byte(`{"staged": true}`))
+
+  if staged, err := asset.Stage(t.TempDir()); err != nil || !staged {
+    t.Fatalf("Expected the asset to be staged, got %v (%v)", staged, err)
+  }
+  if content, err := asset.GetContentBytes(); err != nil || string(content) != `{"staged": true}` {
+    t.Errorf("Expected the staged content to be read back decoded, got %q (%v)", content, err)
+  }
+}
+
+
+func TestAssetContentReader (t *testing.T) {
+  var source_dir = t.TempDir()
+
+  root := NewSpec("root", nil)
+  root.Props["source_dir"] = source_dir
+  if err := root.WriteFile("page.html", []byte("<p>Page</p>"), 0o644); err != nil {
+    t.Fatal(err)
+  }
+
+  asset, err := root.MakeFileKeyAsset(filepath.Join(source_dir, "page.html"))
+  if err != nil { t.Fatal(err) }
+
+  var readAll = func () string {
+    t.Helper()
+    reader, err := asset.ContentReader()
+    if err != nil { t.Fatal(err) }
+    if closer, ok := reader.(io.Closer); ok {
+      defer closer.Close()
+    }
+    content, err := io.ReadAll(reader)
+    if err != nil { t.Fatal(err) }
+    return string(content)
+  }
+
+  // File content is streamed without being read into the asset,
+  // and content in memory is read from it
+  //
+  if content := readAll(); content != "<p>Page</p>" {
+    t.Errorf("Expected the content of the file, got %q", content)
+  }
+  if asset.ContentBytes != nil {
+    t.Errorf("Expected the content of the file not to be read into the asset")
+  }
+
+  asset.SetContentBytes([]byte("<p>Modified</p>"))
+  if content := readAll(); content != "<p>Modified</p>" {
+    t.Errorf("Expected the modified content, got %q", content)
+  }
+}
@@ -0,0 +1,101 @@
+package interbuilder
+
+import (
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+)
+
+
+/*
+  InheritEnv merges the "env" props of this Spec and its parents
+  into a map of environment variable names to values, with the
+  values of child Specs overriding those of their parents. Prop
+  values may be strings, numbers, or booleans.
+*/
+func (s *Spec) InheritEnv () (map[string]string, error) {
+  var env = make(map[string]string)
+
+  // Collect the spec chain, so that it can be applied from the
+  // root downwards
+  //
+  var specs []*Spec
+  for spec := s ; spec != nil ; spec = spec.Parent {
+    specs = append(specs, spec)
+  }
+
+  for i := len(specs) - 1 ; i >= 0 ; i-- {
+    var spec = specs[i]
+
+    env_any, found := spec.Props["env"]
+    if !found || env_any == nil {
+      continue
+    }
+
+    env_prop, ok := env_any.(map[string]any)
+    if !ok {
+      return nil, fmt.Errorf("Prop \"env\" in Spec %s is expected to be an object, got %T", spec.Name, env_any)
+    }
+
+    for key, value := range env_prop {
+      switch value := value.(type) {
+        case string:
+          env[key] = value
+        case float64, int, bool:
+          env[key] = fmt.Sprint(value)
+        default:
+          return nil, fmt.Errorf("Prop \"env\" in Spec %s has a value for %s of unsupported type %T", spec.Name, key, value)
+      }
+    }
+  }
+
+  return env, nil
+}
+
+
+/*
+  Environ returns the environment for commands ran by this Task,
+  as "KEY=value" strings. It begins with the process environment,
+  or an empty one if the "clean_env" prop is inherited as true,
+  then applies the Spec's inherited "env" prop, and then the
+  Task's Env, each overriding the last. The result is sorted by
+  variable name.
+*/
+func (t *Task) Environ () ([]string, error) {
+  var env = make(map[string]string)
+
+  var clean_env bool
+  if t.Spec != nil {
+    clean_env, _, _ = t.Spec.InheritPropBool("clean_env")
+  }
+
+  if !clean_env {
+    for _, entry := range os.Environ() {
+      if key, value, found := strings.Cut(entry, "="); found {
+        env[key] = value
+      }
+    }
+  }
+
+  if t.Spec != nil {
+    spec_env, err := t.Spec.InheritEnv()
+    if err != nil { return nil, err }
+
+    for key, value := range spec_env {
+      env[key] = value
+    }
+  }
+
+  for key, value := range t.Env {
+    env[key] = value
+  }
+
+  var environ = make([]string, 0, len(env))
+  for key, value := range env {
+    environ = append(environ, key + "=" + value)
+  }
+  sort.Strings(environ)
+
+  return environ, nil
+}
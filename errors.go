@@ -0,0 +1,204 @@
+package interbuilder
+
+import (
+  "errors"
+  "fmt"
+  "strings"
+)
+
+
+/*
+  Sentinel errors for the categories of typed errors, for use with
+  errors.Is. The typed errors themselves can be inspected with
+  errors.As.
+*/
+var (
+  ErrMaskViolation      = errors.New("Task mask violation")
+  ErrPropMissing        = errors.New("Prop missing")
+  ErrStall              = errors.New("Input stalled")
+  ErrMergeConflict      = errors.New("Merge conflict")
+  ErrDuplicateOutput    = errors.New("Duplicate output path")
+  ErrCancelled          = errors.New("Spec cancelled")
+  ErrSpecAlreadyRunning = errors.New("Spec already running")
+)
+
+
+/*
+  A SpecError is an error which occurred while running a Spec,
+  outside of any one of its Tasks, or which was returned by one of
+  its subspecs, in which case Subspec is true.
+*/
+type SpecError struct {
+  Spec     string
+  Subspec  bool
+  Err      error
+}
+
+
+func (e *SpecError) Error () string {
+  if e.Subspec {
+    return fmt.Sprintf("Error in subspec \"%s\": %v", e.Spec, e.Err)
+  }
+  return fmt.Sprintf("Error in spec %s: %v", e.Spec, e.Err)
+}
+
+
+func (e *SpecError) Unwrap () error {
+  return e.Err
+}
+
+
+/*
+  A TaskError is an error returned by a Task, or by its SkipFunc.
+  ResolverId is the ID of the TaskResolver which created the
+  Task, if any.
+*/
+type TaskError struct {
+  Spec        string
+  Task        string
+  ResolverId  string
+  Err         error
+}
+
+
+func (e *TaskError) Error () string {
+  if e.ResolverId != "" {
+    return fmt.Sprintf("Error in spec %s, in task %s (%s): %v", e.Spec, e.Task, e.ResolverId, e.Err)
+  }
+  return fmt.Sprintf("Error in spec %s, in task %s: %v", e.Spec, e.Task, e.Err)
+}
+
+
+func (e *TaskError) Unwrap () error {
+  return e.Err
+}
+
+
+/*
+  A MaskViolationError is returned when a Task attempts an
+  operation its Task.Mask does not permit, such as emitting
+  assets without TASK_ASSETS_EMIT. Required is the mask of the
+  attempted operation.
+*/
+type MaskViolationError struct {
+  Spec       string
+  Task       string
+  Operation  string
+  Mask       TaskMask
+  Required   TaskMask
+}
+
+
+func (e *MaskViolationError) Error () string {
+  if e.Spec != "" {
+    return fmt.Sprintf(
+      "Task with name '%s' in spec '%s' cannot %s, Task.Mask is %s",
+      e.Task, e.Spec, e.Operation, e.Mask,
+    )
+  }
+  return fmt.Sprintf(
+    "Task with name '%s' cannot %s, Task.Mask is %s",
+    e.Task, e.Operation, e.Mask,
+  )
+}
+
+
+func (e *MaskViolationError) Is (target error) bool {
+  return target == ErrMaskViolation
+}
+
+
+func (tk *Task) maskViolation (operation string, required TaskMask) *MaskViolationError {
+  var spec_name string
+  if tk.Spec != nil {
+    spec_name = tk.Spec.Name
+  }
+
+  return & MaskViolationError {
+    Spec:      spec_name,
+    Task:      tk.Name,
+    Operation: operation,
+    Mask:      tk.Mask,
+    Required:  required,
+  }
+}
+
+
+/*
+  A MergeConflictError is returned when subspecs of a Spec with
+  the "error" merge conflict policy output Assets at the same
+  path. Subspecs are the names of the subspecs, in the order they
+  output the path.
+*/
+type MergeConflictError struct {
+  Spec      string
+  Path      string
+  Subspecs  []string
+}
+
+
+func (e *MergeConflictError) Error () string {
+  return fmt.Sprintf(
+    "Subspecs %s and %s of spec %s both output an asset at %s",
+    e.Subspecs[0], e.Subspecs[1], e.Spec, e.Path,
+  )
+}
+
+
+func (e *MergeConflictError) Is (target error) bool {
+  return target == ErrMergeConflict
+}
+
+
+/*
+  A DuplicateOutputError is returned when a Spec outputs two
+  Assets from different sources whose transformed paths are the
+  same, which would otherwise overwrite one another when written.
+  Provenance holds the history of each Asset, in the order they
+  were output, as URLs from the Asset itself back to its origins.
+*/
+type DuplicateOutputError struct {
+  Spec        string
+  Path        string
+  Provenance  [2][]string
+}
+
+
+func (e *DuplicateOutputError) Error () string {
+  return fmt.Sprintf(
+    "Spec %s output two different assets at %s:\n  first:  %s\n  second: %s",
+    e.Spec, e.Path,
+    strings.Join(e.Provenance[0], " <- "),
+    strings.Join(e.Provenance[1], " <- "),
+  )
+}
+
+
+func (e *DuplicateOutputError) Is (target error) bool {
+  return target == ErrDuplicateOutput
+}
+
+
+/*
+  A PropMissingError is returned when a required prop is not
+  defined. If Inherited is true, the prop was also not defined by
+  any of the Spec's parents.
+*/
+type PropMissingError struct {
+  Spec       string
+  Prop       string
+  Inherited  bool
+}
+
+
+func (e *PropMissingError) Error () string {
+  if e.Inherited {
+    return fmt.Sprintf("Inherited prop \"%s\" required in spec %s", e.Prop, e.Spec)
+  }
+  return fmt.Sprintf("Prop \"%s\" required in spec %s", e.Prop, e.Spec)
+}
+
+
+func (e *PropMissingError) Is (target error) bool {
+  return target == ErrPropMissing
+}
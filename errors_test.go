@@ -0,0 +1,80 @@
+package interbuilder
+
+import (
+  "testing"
+  "errors"
+  "fmt"
+)
+
+
+func TestTypedErrors (t *testing.T) {
+  // Task errors in subspecs
+  //
+  var root    = NewSpec("root", nil)
+  var subspec = root.AddSubspec(NewSpec("subspec", nil))
+  root.Props["quiet"] = true
+
+  var task_err = fmt.Errorf("Task failure")
+
+  subspec.EnqueueTaskFunc("fail", func (*Spec, *Task) error {
+    return task_err
+  })
+
+  var err error
+  TestWrapTimeout(t, func () { err = root.Run() })
+
+  var spec_error *SpecError
+  if !errors.As(err, &spec_error) || spec_error.Spec != "subspec" || !spec_error.Subspec {
+    t.Errorf("Expected a SpecError from subspec, got %#v", err)
+  }
+
+  var task_error *TaskError
+  if !errors.As(err, &task_error) || task_error.Spec != "subspec" || task_error.Task != "fail" {
+    t.Errorf("Expected a TaskError from task fail, got %#v", err)
+  }
+
+  if !errors.Is(err, task_err) {
+    t.Errorf("Expected the error to wrap the task's error")
+  }
+
+  // Mask violations
+  //
+  var mask_spec = NewSpec("mask-spec", nil)
+  mask_spec.Props["quiet"] = true
+
+  mask_spec.EnqueueTask(& Task {
+    Name: "no-emit",
+    Mask: TASK_ASSETS_CONSUME,
+    Func: func (s *Spec, tk *Task) error {
+      return tk.EmitAsset(s.MakeAsset("asset"))
+    },
+  })
+
+  err = mask_spec.Run()
+
+  var mask_error *MaskViolationError
+  if !errors.Is(err, ErrMaskViolation) || !errors.As(err, &mask_error) {
+    t.Fatalf("Expected a MaskViolationError, got %v", err)
+  }
+  if mask_error.Task != "no-emit" || mask_error.Spec != "mask-spec" || mask_error.Required != TASK_ASSETS_EMIT {
+    t.Errorf("Unexpected MaskViolationError fields: %#v", mask_error)
+  }
+
+  // Missing props
+  //
+  var prop_spec = NewSpec("prop-spec", nil)
+
+  _, err = prop_spec.RequireInheritPropString("missing")
+
+  var prop_error *PropMissingError
+  if !errors.Is(err, ErrPropMissing) || !errors.As(err, &prop_error) {
+    t.Fatalf("Expected a PropMissingError, got %v", err)
+  }
+  if prop_error.Prop != "missing" || prop_error.Spec != "prop-spec" || !prop_error.Inherited {
+    t.Errorf("Unexpected PropMissingError fields: %#v", prop_error)
+  }
+
+  if _, err := prop_spec.RequireProp("missing"); errors.Is(err, ErrMaskViolation) || !errors.Is(err, ErrPropMissing) {
+    t.Errorf("Expected only a PropMissingError, got %v", err)
+  }
+}
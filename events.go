@@ -0,0 +1,120 @@
+package interbuilder
+
+import (
+  "time"
+)
+
+
+type EventType int
+
+const (
+  EVENT_SPEC_START EventType = iota
+  EVENT_SPEC_END
+  EVENT_TASK_START
+  EVENT_TASK_END
+  EVENT_TASK_ERROR
+  EVENT_ASSET_EMIT
+  EVENT_TASK_SKIP
+  EVENT_SPEC_RESUME
+)
+
+
+func (et EventType) String () string {
+  switch et {
+    case EVENT_SPEC_START:  return "spec-start"
+    case EVENT_SPEC_END:    return "spec-end"
+    case EVENT_TASK_START:  return "task-start"
+    case EVENT_TASK_END:    return "task-end"
+    case EVENT_TASK_ERROR:  return "task-error"
+    case EVENT_ASSET_EMIT:  return "asset-emit"
+    case EVENT_TASK_SKIP:   return "task-skip"
+    case EVENT_SPEC_RESUME: return "spec-resume"
+  }
+  return "unknown"
+}
+
+
+/*
+  An Event describes a change in the lifecycle of a Spec run. Task
+  is set for task events, Asset for asset events, and Err for
+  EVENT_TASK_ERROR and for EVENT_SPEC_END if the run failed. A
+  Task whose SkipFunc skips it has an EVENT_TASK_SKIP event
+  instead of starting and ending, and a Spec which outputs the
+  Assets of its checkpoint instead of running has an
+  EVENT_SPEC_RESUME event after it starts.
+*/
+type Event struct {
+  Type   EventType
+  Time   time.Time
+  Spec   *Spec
+  Task   *Task
+  Asset  *Asset
+  Err    error
+}
+
+
+type EventHandler func (Event)
+
+
+/*
+  OnEvent adds a handler for the events of this Spec and its
+  subspecs. Handlers are called synchronously from the goroutine
+  of the Spec the event occurred in, so they may be called
+  concurrently, and should return quickly. Handlers of a Spec are
+  called before those of its parent.
+*/
+func (s *Spec) OnEvent (handler EventHandler) {
+  s.onEvent(handler)
+}
+
+
+/*
+  onEvent adds a handler as in OnEvent, and returns a function
+  which removes it.
+*/
+func (s *Spec) onEvent (handler EventHandler) (remove func ()) {
+  var entry = & handler
+
+  s.event_lock.Lock()
+  defer s.event_lock.Unlock()
+  s.event_handlers = append(s.event_handlers, entry)
+
+  return func () {
+    s.event_lock.Lock()
+    defer s.event_lock.Unlock()
+
+    // The slice is replaced rather than modified, as emitEvent may
+    // be iterating over it
+    //
+    var handlers = make([]*EventHandler, 0, len(s.event_handlers))
+    for _, existing := range s.event_handlers {
+      if existing != entry {
+        handlers = append(handlers, existing)
+      }
+    }
+    s.event_handlers = handlers
+  }
+}
+
+
+/*
+  emitEvent passes an event to the handlers of this Spec and each
+  of its parents.
+*/
+func (s *Spec) emitEvent (event Event) {
+  event.Spec = s
+  if event.Time.IsZero() {
+    event.Time = time.Now()
+  }
+  s.logEvent(event)
+
+  for spec := s; spec != nil; spec = spec.Parent {
+    spec.event_lock.RLock()
+    handlers := spec.event_handlers
+    spec.event_lock.RUnlock()
+
+    for _, handler := range handlers {
+      (*handler)(event)
+    }
+  }
+}
@@ -0,0 +1,79 @@
+package interbuilder
+
+import (
+  "testing"
+  "fmt"
+  "strings"
+  "sync"
+)
+
+
+func TestSpecOnEvent (t *testing.T) {
+  var root    = NewSpec("root", nil)
+  var subspec = root.AddSubspec(NewSpec("subspec", nil))
+  root.Props["quiet"] = true
+
+  var events_lock sync.Mutex
+  var events = make(map[string][]string)
+
+  root.OnEvent(func (e Event) {
+    events_lock.Lock()
+    defer events_lock.Unlock()
+
+    var event_str = e.Type.String()
+    if e.Task != nil {
+      event_str += ":" + e.Task.Name
+    }
+    if e.Asset != nil {
+      event_str += ":" + e.Asset.Url.Path
+    }
+    if e.Err != nil {
+      event_str += ":error"
+    }
+    events[e.Spec.Name] = append(events[e.Spec.Name], event_str)
+  })
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    return s.EmitAsset(s.MakeAsset("asset"))
+  })
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    for range s.Input {}
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var expect = map[string]string {
+    "subspec": "spec-start task-start:emit asset-emit:@emit/asset task-end:emit spec-end",
+    "root":    "spec-start task-start:consume task-end:consume spec-end",
+  }
+
+  for spec_name, expect_events := range expect {
+    if got := strings.Join(events[spec_name], " "); got != expect_events {
+      t.Errorf("Expected events of spec %s to be:\n%s\ngot:\n%s", spec_name, expect_events, got)
+    }
+  }
+
+  // Task errors are reported, and the spec ends with an error
+  //
+  var error_spec = NewSpec("error-spec", nil)
+  error_spec.Props["quiet"] = true
+
+  var error_events []string
+  error_spec.OnEvent(func (e Event) {
+    error_events = append(error_events, fmt.Sprintf("%s:%v", e.Type, e.Err != nil))
+  })
+
+  error_spec.EnqueueTaskFunc("fail", func (*Spec, *Task) error {
+    return fmt.Errorf("Expected error")
+  })
+
+  if err := error_spec.Run(); err == nil {
+    t.Fatal("Expected an error")
+  }
+
+  if got, expect := strings.Join(error_events, " "), "spec-start:false task-start:false task-error:true spec-end:true"; got != expect {
+    t.Errorf("Expected error events %s, got %s", expect, got)
+  }
+}
@@ -0,0 +1,445 @@
+package interbuilder
+
+import (
+  "context"
+  "fmt"
+  "path"
+  "slices"
+  "sort"
+  "strings"
+  "sync"
+)
+
+
+/*
+  An AssetFrame is the set of asset paths a Spec has output so
+  far. It lets Tasks ask whether an asset will exist, such as a
+  link checker verifying references across sibling Specs, and
+  wait for assets which have not been output yet. A frame is
+  closed when its Spec finishes, after which its set of paths is
+  final.
+
+  Paths are the URL paths of output Assets, without their @emit/
+  directive, and with a leading slash, such as "/about/index.html".
+  Multi-assets are not recorded, only singular Assets.
+
+  Tasks which will output many Assets can reserve a glob pattern of
+  their paths with ReserveGlob, and release it once they have
+  output all of them. This lets callers tell a path which is not
+  output yet from one which never will be, before the frame is
+  closed.
+*/
+type AssetFrame struct {
+  Spec          *Spec
+
+  lock          sync.Mutex
+  assets        map[string]*Asset
+  reservations  []*frameReservation
+  closed        bool
+  changed       chan struct{}
+}
+
+
+/*
+  A frameReservation is a glob pattern of paths reserved in an
+  AssetFrame, split into its path segments.
+*/
+type frameReservation struct {
+  pattern   string
+  segments  []string
+  released  bool
+}
+
+
+/*
+  A FrameKeyState is the state of a path in an AssetFrame, as
+  returned by AssetFrame.KeyState.
+*/
+type FrameKeyState int
+
+const (
+  FRAME_KEY_UNKNOWN FrameKeyState = iota  // Not output, and the frame is open
+  FRAME_KEY_PENDING                       // Not output, but matches a held reservation
+  FRAME_KEY_PRESENT                       // Output
+  FRAME_KEY_ABSENT                        // Never output: the frame is closed, or the path only
+                                          // matches released reservations
+)
+
+
+func (state FrameKeyState) String () string {
+  switch state {
+    case FRAME_KEY_UNKNOWN: return "unknown"
+    case FRAME_KEY_PENDING: return "pending"
+    case FRAME_KEY_PRESENT: return "present"
+    case FRAME_KEY_ABSENT:  return "absent"
+  }
+  return fmt.Sprintf("FrameKeyState(%d)", int(state))
+}
+
+
+func newAssetFrame (s *Spec) *AssetFrame {
+  return & AssetFrame {
+    Spec:    s,
+    assets:  make(map[string]*Asset),
+    changed: make(chan struct{}),
+  }
+}
+
+
+/*
+  Frame returns this Spec's AssetFrame, creating it if needed.
+*/
+func (s *Spec) Frame () *AssetFrame {
+  s.frame_lock.Lock()
+  defer s.frame_lock.Unlock()
+
+  if s.frame == nil {
+    s.frame = newAssetFrame(s)
+  }
+  return s.frame
+}
+
+
+/*
+  framePath normalizes an asset URL path into a frame key.
+*/
+func framePath (p string) string {
+  p = strings.TrimPrefix(p, "/")
+  p = strings.TrimPrefix(p, "@emit/")
+  return "/" + strings.TrimLeft(p, "/")
+}
+
+
+/*
+  add records an Asset in the frame, and wakes waiting callers. If
+  an Asset with a different provenance was already output at the
+  same path, such as one transformed from another source file, a
+  DuplicateOutputError is returned, and the first Asset is kept.
+  An Asset with the same provenance, such as the same file emitted
+  again after it changed, replaces the earlier one.
+*/
+func (f *AssetFrame) add (a *Asset) error {
+  if a.Url == nil || !a.IsSingle() {
+    return nil
+  }
+
+  f.lock.Lock()
+  defer f.lock.Unlock()
+
+  if f.closed {
+    return nil
+  }
+
+  var key = framePath(a.Url.Path)
+  if existing, found := f.assets[key]; found && existing != a {
+    if existing.History == nil || existing.History != a.History {
+      var provenance = [2][]string { existing.provenance(), a.provenance() }
+      if !slices.Equal(provenance[0], provenance[1]) {
+        return & DuplicateOutputError {
+          Spec:       f.specName(),
+          Path:       key,
+          Provenance: provenance,
+        }
+      }
+    }
+  }
+
+  f.assets[key] = a
+  f.notify()
+  return nil
+}
+
+
+/*
+  notify wakes waiting callers, with the lock held.
+*/
+func (f *AssetFrame) notify () {
+  close(f.changed)
+  f.changed = make(chan struct{})
+}
+
+
+/*
+  close marks the frame as final, and wakes waiting callers.
+*/
+func (f *AssetFrame) close () {
+  f.lock.Lock()
+  defer f.lock.Unlock()
+
+  if f.closed {
+    return
+  }
+  f.closed = true
+  close(f.changed)
+}
+
+
+/*
+  lookup finds the Asset at a path, with the lock held. A path
+  ending with a slash also matches an index.html file in that
+  directory.
+*/
+func (f *AssetFrame) lookup (p string) (*Asset, bool) {
+  key := framePath(p)
+
+  if asset, found := f.assets[key]; found {
+    return asset, true
+  }
+  if strings.HasSuffix(key, "/") {
+    asset, found := f.assets[key + "index.html"]
+    return asset, found
+  }
+  return nil, false
+}
+
+
+/*
+  ReserveGlob reserves a glob pattern of paths, such as "blog/**",
+  declaring that the Assets matching it are still being output.
+  Patterns are matched against paths as in path.Match, one path
+  segment at a time, with "**" matching any number of segments.
+  The returned function releases the reservation, promising that
+  no more Assets matching it will be output; paths which match it,
+  and no other held reservation, and have not been output, are
+  then absent. Reservations are released when the frame closes.
+*/
+func (f *AssetFrame) ReserveGlob (pattern string) (release func (), err error) {
+  var segments = strings.Split(framePath(pattern), "/")
+  for _, segment := range segments {
+    if _, err := path.Match(segment, ""); err != nil {
+      return nil, fmt.Errorf("Cannot reserve glob pattern \"%s\": %w", pattern, err)
+    }
+  }
+
+  f.lock.Lock()
+  defer f.lock.Unlock()
+
+  if f.closed {
+    return nil, fmt.Errorf("Cannot reserve glob pattern \"%s\", the frame of Spec %s is closed", pattern, f.specName())
+  }
+
+  var reservation = & frameReservation { pattern: pattern, segments: segments }
+  f.reservations = append(f.reservations, reservation)
+
+  return func () {
+    f.lock.Lock()
+    defer f.lock.Unlock()
+    if !reservation.released && !f.closed {
+      reservation.released = true
+      f.notify()
+    }
+  }, nil
+}
+
+
+/*
+  Reservations returns the glob patterns of the held reservations
+  of the frame, in the order they were reserved.
+*/
+func (f *AssetFrame) Reservations () []string {
+  f.lock.Lock()
+  defer f.lock.Unlock()
+
+  var patterns []string
+  for _, reservation := range f.reservations {
+    if !reservation.released && !f.closed {
+      patterns = append(patterns, reservation.pattern)
+    }
+  }
+  return patterns
+}
+
+
+/*
+  keyState returns the state of a path, with the lock held.
+*/
+func (f *AssetFrame) keyState (p string) FrameKeyState {
+  if _, found := f.lookup(p); found {
+    return FRAME_KEY_PRESENT
+  } else if f.closed {
+    return FRAME_KEY_ABSENT
+  }
+
+  var segments = strings.Split(framePath(p), "/")
+  var released bool
+  for _, reservation := range f.reservations {
+    if !matchGlobSegments(reservation.segments, segments) {
+      continue
+    } else if !reservation.released {
+      return FRAME_KEY_PENDING
+    }
+    released = true
+  }
+
+  if released {
+    return FRAME_KEY_ABSENT
+  }
+  return FRAME_KEY_UNKNOWN
+}
+
+
+/*
+  KeyState returns whether an Asset at a path has been output, is
+  pending in a held reservation, will never be output, or is
+  unknown.
+*/
+func (f *AssetFrame) KeyState (p string) FrameKeyState {
+  f.lock.Lock()
+  defer f.lock.Unlock()
+
+  return f.keyState(p)
+}
+
+
+/*
+  matchGlobSegments matches the segments of a path against those
+  of a glob pattern, where a "**" segment matches any number of
+  segments.
+*/
+func matchGlobSegments (pattern, segments []string) bool {
+  if len(pattern) == 0 {
+    return len(segments) == 0
+  }
+
+  if pattern[0] == "**" {
+    for i := 0; i <= len(segments); i++ {
+      if matchGlobSegments(pattern[1:], segments[i:]) {
+        return true
+      }
+    }
+    return false
+  }
+
+  if len(segments) == 0 {
+    return false
+  }
+  if matched, _ := path.Match(pattern[0], segments[0]); !matched {
+    return false
+  }
+  return matchGlobSegments(pattern[1:], segments[1:])
+}
+
+
+/*
+  specName returns the name of the frame's Spec, for errors.
+*/
+func (f *AssetFrame) specName () string {
+  if f.Spec == nil {
+    return "<nil>"
+  }
+  return f.Spec.Name
+}
+
+
+/*
+  Keys returns the sorted paths of the Assets in the frame.
+*/
+func (f *AssetFrame) Keys () []string {
+  f.lock.Lock()
+  defer f.lock.Unlock()
+
+  keys := make([]string, 0, len(f.assets))
+  for key := range f.assets {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  return keys
+}
+
+
+/*
+  Has returns whether an Asset with a path has been output.
+*/
+func (f *AssetFrame) Has (p string) bool {
+  f.lock.Lock()
+  defer f.lock.Unlock()
+
+  _, found := f.lookup(p)
+  return found
+}
+
+
+/*
+  Get returns the Asset output at a path, if there is one.
+*/
+func (f *AssetFrame) Get (p string) (*Asset, bool) {
+  f.lock.Lock()
+  defer f.lock.Unlock()
+
+  return f.lookup(p)
+}
+
+
+/*
+  Len returns the number of Assets in the frame.
+*/
+func (f *AssetFrame) Len () int {
+  f.lock.Lock()
+  defer f.lock.Unlock()
+
+  return len(f.assets)
+}
+
+
+/*
+  Closed returns whether the frame's Spec has finished, meaning
+  its set of Assets is final.
+*/
+func (f *AssetFrame) Closed () bool {
+  f.lock.Lock()
+  defer f.lock.Unlock()
+
+  return f.closed
+}
+
+
+/*
+  WaitForKey blocks until an Asset at a path is output, returning
+  true, or until it is absent, returning false: the frame is
+  closed without one, or the reservations it matches are
+  released. If ctx is done first, its error is returned.
+*/
+func (f *AssetFrame) WaitForKey (ctx context.Context, p string) (bool, error) {
+  for {
+    f.lock.Lock()
+    state   := f.keyState(p)
+    changed := f.changed
+    f.lock.Unlock()
+
+    switch state {
+    case FRAME_KEY_PRESENT:
+      return true, nil
+    case FRAME_KEY_ABSENT:
+      return false, nil
+    }
+
+    select {
+    case <-ctx.Done():
+      return false, ctx.Err()
+    case <-changed:
+    }
+  }
+}
+
+
+/*
+  InputAssetFrames returns the AssetFrames of this Task's Spec's
+  subspecs, which are the sources of its input, ordered by name.
+*/
+func (tk *Task) InputAssetFrames () []*AssetFrame {
+  if tk.Spec == nil {
+    return nil
+  }
+
+  names := make([]string, 0, len(tk.Spec.Subspecs))
+  for name := range tk.Spec.Subspecs {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  frames := make([]*AssetFrame, 0, len(names))
+  for _, name := range names {
+    frames = append(frames, tk.Spec.Subspecs[name].Frame())
+  }
+  return frames
+}
@@ -0,0 +1,211 @@
+package interbuilder
+
+import (
+  "testing"
+  "context"
+  "errors"
+  "fmt"
+  "strings"
+)
+
+
+func TestAssetFrame (t *testing.T) {
+  var root  = NewSpec("root", nil)
+  var pages = root.AddSubspec(NewSpec("pages", nil))
+  var other = root.AddSubspec(NewSpec("other", nil))
+  root.Props["quiet"] = true
+
+  pages.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for _, key := range []string { "about/index.html", "style.css" } {
+      if err := s.EmitAsset(s.MakeAsset(key)); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  other.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    return s.EmitAsset(s.MakeAsset("other.txt"))
+  })
+
+  root.EnqueueTaskFunc("check", func (s *Spec, tk *Task) error {
+    go func () {
+      for range s.Input {}
+    }()
+
+    frames := tk.InputAssetFrames()
+    if len(frames) != 2 || frames[0].Spec != other || frames[1].Spec != pages {
+      return fmt.Errorf("Expected the frames of other and pages, in order, got %v", frames)
+    }
+
+    var pages_frame = frames[1]
+
+    // Directory paths match index files
+    //
+    if found, err := pages_frame.WaitForKey(tk.Context(), "/about/"); err != nil || !found {
+      return fmt.Errorf("Expected /about/ to be found, got %v, %v", found, err)
+    }
+
+    // Waiting for a path which is never output returns once the
+    // spec finishes
+    //
+    if found, err := pages_frame.WaitForKey(tk.Context(), "missing.html"); err != nil || found {
+      return fmt.Errorf("Expected missing.html not to be found, got %v, %v", found, err)
+    }
+
+    if !pages_frame.Closed() {
+      return fmt.Errorf("Expected the frame to be closed")
+    }
+
+    if got := fmt.Sprint(pages_frame.Keys()); got != "[/about/index.html /style.css]" {
+      return fmt.Errorf("Unexpected frame keys: %s", got)
+    }
+
+    if pages_frame.Len() != 2 || !pages_frame.Has("style.css") || pages_frame.Has("other.txt") {
+      return fmt.Errorf("Unexpected frame contents: %v", pages_frame.Keys())
+    }
+
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  // Waiting is cancelled with its context
+  //
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+
+  if _, err := NewSpec("spec", nil).Frame().WaitForKey(ctx, "/asset"); err == nil {
+    t.Errorf("Expected an error waiting with a cancelled context")
+  }
+}
+
+
+func TestAssetFrameReserveGlob (t *testing.T) {
+  var spec  = NewSpec("spec", nil)
+  var frame = spec.Frame()
+
+  release, err := frame.ReserveGlob("blog/**")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if _, err := frame.ReserveGlob("blog/[a-"); err == nil {
+    t.Error("Expected an error reserving a malformed pattern")
+  }
+
+  for key, expect := range map[string]FrameKeyState {
+    "/blog/post.html":     FRAME_KEY_PENDING,
+    "blog/2024/post.html": FRAME_KEY_PENDING,
+    "/about.html":         FRAME_KEY_UNKNOWN,
+  } {
+    if state := frame.KeyState(key); state != expect {
+      t.Errorf("Expected %s to be %s, got %s", key, expect, state)
+    }
+  }
+
+  // A caller waiting for a reserved path which is never output
+  // returns once the reservation is released
+  //
+  var waited = make(chan bool)
+  go func () {
+    found, _ := frame.WaitForKey(context.Background(), "blog/missing.html")
+    waited <- found
+  }()
+
+  frame.add(spec.MakeAsset("blog/post.html"))
+  if state := frame.KeyState("blog/post.html"); state != FRAME_KEY_PRESENT {
+    t.Errorf("Expected an output path to be present, got %s", state)
+  }
+  if got := fmt.Sprint(frame.Reservations()); got != "[blog/**]" {
+    t.Errorf("Expected the held reservation to be listed, got %s", got)
+  }
+
+  release()
+
+  if found := <-waited; found {
+    t.Errorf("Expected a path in a released reservation not to be found")
+  }
+  if state := frame.KeyState("blog/missing.html"); state != FRAME_KEY_ABSENT {
+    t.Errorf("Expected a path in a released reservation to be absent, got %s", state)
+  }
+  if state := frame.KeyState("about.html"); state != FRAME_KEY_UNKNOWN {
+    t.Errorf("Expected a path outside reservations to be unknown, got %s", state)
+  }
+  if len(frame.Reservations()) != 0 {
+    t.Errorf("Expected no held reservations, got %v", frame.Reservations())
+  }
+
+  // Closing the frame makes every missing path absent
+  //
+  frame.close()
+  if state := frame.KeyState("about.html"); state != FRAME_KEY_ABSENT {
+    t.Errorf("Expected a missing path to be absent once the frame closes, got %s", state)
+  }
+  if _, err := frame.ReserveGlob("docs/**"); err == nil {
+    t.Error("Expected an error reserving a pattern in a closed frame")
+  }
+}
+
+
+func TestAssetFrameDuplicateOutput (t *testing.T) {
+  var root = NewSpec("root", nil)
+  var spec = root.AddSubspec(NewSpec("spec", nil))
+  root.Props["quiet"] = true
+
+  transformation, err := PathTransformationFromExtension(".md", ".html")
+  if err != nil {
+    t.Fatal(err)
+  }
+  spec.PathTransformations = append(spec.PathTransformations, transformation)
+
+  spec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    var page = s.MakeAsset("page.html")
+    if err := s.EmitAsset(page); err != nil {
+      return err
+    }
+
+    // Emitting the same asset again is not a duplicate
+    //
+    if err := s.EmitAsset(page); err != nil {
+      return err
+    }
+
+    // Nor is another asset from the same source, such as a file
+    // which changed, which replaces the first
+    //
+    var changed = s.MakeAsset("page.html")
+    changed.SetContentBytes([]byte("changed"))
+    if err := s.EmitAsset(changed); err != nil {
+      return err
+    }
+    if output, _ := s.Frame().Get("page.html"); output == nil || output.ContentBytes == nil {
+      return fmt.Errorf("Expected the changed asset to replace the first in the frame")
+    }
+
+    return s.EmitAsset(s.MakeAsset("page.md"))
+  })
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    return tk.PoolSpecInputAssets()
+  })
+
+  err = root.Run()
+
+  var duplicate_error *DuplicateOutputError
+  if !errors.Is(err, ErrDuplicateOutput) || !errors.As(err, &duplicate_error) {
+    t.Fatalf("Expected a duplicate output error, got: %v", err)
+  }
+  if duplicate_error.Spec != "spec" || duplicate_error.Path != "/page.html" {
+    t.Errorf("Expected a duplicate of /page.html in spec, got: %v", duplicate_error)
+  }
+
+  // Each provenance chain leads from the output path back to the
+  // spec, through the asset's source path
+  //
+  for i, source := range []string { "ib://spec/page.html", "ib://spec/page.md" } {
+    var chain = strings.Join(duplicate_error.Provenance[i], " <- ")
+    if expect := "ib://spec/@emit/page.html <- " + source + " <- ib://spec"; chain != expect {
+      t.Errorf("Expected provenance %d to be %s, got %s", i, expect, chain)
+    }
+  }
+}
@@ -0,0 +1,125 @@
+package interbuilder
+
+import (
+  "fmt"
+  "io"
+  "strconv"
+  "time"
+)
+
+
+/*
+  WalkParents calls fn for each ancestor of this HistoryEntry,
+  depth-first, visiting each entry once, as histories form a
+  directed acyclic graph in which entries may share parents. If
+  fn returns an error, the walk stops and the error is returned.
+*/
+func (h *HistoryEntry) WalkParents (fn func (*HistoryEntry) error) error {
+  var visited = make(map[*HistoryEntry]bool)
+
+  var walk func (*HistoryEntry) error
+  walk = func (entry *HistoryEntry) error {
+    for _, parent := range entry.Parents {
+      if parent == nil || visited[parent] {
+        continue
+      }
+      visited[parent] = true
+
+      if err := fn(parent); err != nil {
+        return err
+      }
+      if err := walk(parent); err != nil {
+        return err
+      }
+    }
+    return nil
+  }
+
+  return walk(h)
+}
+
+
+/*
+  provenance returns the URLs of an Asset's history, from the
+  Asset itself back to its origins, for describing where an Asset
+  came from in errors.
+*/
+func (a *Asset) provenance () []string {
+  var chain []string
+  if a.Url != nil {
+    chain = append(chain, a.Url.String())
+  }
+  if a.History == nil {
+    return chain
+  }
+
+  var add = func (entry *HistoryEntry) error {
+    if entry.Url != nil && (len(chain) == 0 || chain[len(chain)-1] != entry.Url.String()) {
+      chain = append(chain, entry.Url.String())
+    }
+    return nil
+  }
+  add(a.History)
+  a.History.WalkParents(add)
+  return chain
+}
+
+
+/*
+  HistoryToDOT writes the provenance graph of history entries, and
+  their ancestors, in the Graphviz DOT language. Edges point from
+  parents to the entries derived from them. Nodes are labeled with
+  their URLs and times.
+*/
+func HistoryToDOT (w io.Writer, entries ...*HistoryEntry) error {
+  var ids   = make(map[*HistoryEntry]string)
+  var order []*HistoryEntry
+
+  var add = func (entry *HistoryEntry) error {
+    if _, found := ids[entry]; !found {
+      ids[entry] = fmt.Sprintf("n%d", len(ids))
+      order = append(order, entry)
+    }
+    return nil
+  }
+
+  for _, entry := range entries {
+    if entry == nil {
+      continue
+    }
+    add(entry)
+    entry.WalkParents(add)
+  }
+
+  if _, err := fmt.Fprintln(w, "digraph history {"); err != nil {
+    return err
+  }
+
+  for _, entry := range order {
+    var label string
+    if entry.Url != nil {
+      label = entry.Url.String()
+    }
+    if !entry.Time.IsZero() {
+      label += "\n" + entry.Time.Format(time.RFC3339Nano)
+    }
+
+    if _, err := fmt.Fprintf(w, "  %s [label=%s];\n", ids[entry], strconv.Quote(label)); err != nil {
+      return err
+    }
+  }
+
+  for _, entry := range order {
+    for _, parent := range entry.Parents {
+      if parent == nil {
+        continue
+      }
+      if _, err := fmt.Fprintf(w, "  %s -> %s;\n", ids[parent], ids[entry]); err != nil {
+        return err
+      }
+    }
+  }
+
+  _, err := fmt.Fprintln(w, "}")
+  return err
+}
@@ -0,0 +1,68 @@
+package interbuilder
+
+import (
+  "testing"
+  "fmt"
+  "net/url"
+  "strings"
+)
+
+
+func TestHistoryWalkParentsAndDOT (t *testing.T) {
+  var makeEntry = func (u string, parents ...*HistoryEntry) *HistoryEntry {
+    entry_url, _ := url.Parse(u)
+    return & HistoryEntry { Url: entry_url, Parents: parents }
+  }
+
+  // A diamond: both a and b derive from root, and c from both
+  //
+  var root = makeEntry("ib://root")
+  var a    = makeEntry("ib://a", root)
+  var b    = makeEntry("ib://b", root)
+  var c    = makeEntry("ib://c", a, b)
+
+  var visited []string
+  err := c.WalkParents(func (entry *HistoryEntry) error {
+    visited = append(visited, entry.Url.String())
+    return nil
+  })
+  if err != nil { t.Fatal(err) }
+
+  if got, expect := strings.Join(visited, " "), "ib://a ib://root ib://b"; got != expect {
+    t.Errorf("Expected to visit %s, got %s", expect, got)
+  }
+
+  // Errors stop the walk
+  //
+  var count int
+  err = c.WalkParents(func (*HistoryEntry) error {
+    count++
+    return fmt.Errorf("Stop")
+  })
+  if err == nil || count != 1 {
+    t.Errorf("Expected the walk to stop with an error after one entry, got %v after %d", err, count)
+  }
+
+  var dot strings.Builder
+  if err := HistoryToDOT(&dot, c, nil, b); err != nil {
+    t.Fatal(err)
+  }
+
+  var expect = strings.Join([]string {
+    `digraph history {`,
+    `  n0 [label="ib://c"];`,
+    `  n1 [label="ib://a"];`,
+    `  n2 [label="ib://root"];`,
+    `  n3 [label="ib://b"];`,
+    `  n1 -> n0;`,
+    `  n3 -> n0;`,
+    `  n2 -> n1;`,
+    `  n2 -> n3;`,
+    `}`,
+    ``,
+  }, "\n")
+
+  if got := dot.String(); got != expect {
+    t.Errorf("Expected DOT output:\n%s\ngot:\n%s", expect, got)
+  }
+}
@@ -0,0 +1,315 @@
+package interbuilder
+
+import (
+  "bufio"
+  "fmt"
+  "io/fs"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strings"
+)
+
+
+/*
+  IGNORE_FILE is the name of the ignore file read from directories
+  expanded into assets, unless the "ignore_files" prop names
+  others.
+*/
+const IGNORE_FILE = ".ibignore"
+
+
+/*
+  default_ignore_patterns are the patterns every spec's
+  IgnoreRules begin with, which version control directories are
+  ignored by, unless a later pattern negates them.
+*/
+var default_ignore_patterns = []string { ".git/", ".hg/", ".svn/" }
+
+
+/*
+  IgnoreRules matches asset keys against patterns in the syntax of
+  .gitignore files: blank lines and lines starting with "#" are
+  skipped, "!" negates a pattern, a trailing "/" only matches
+  directories, a pattern containing a "/" other than a trailing
+  one is relative to the directory it was read from, and other
+  patterns match a name at any depth. "*", "?", and "[...]" match
+  within a path segment, and "**" matches across them. The last
+  pattern matching a key decides whether it is ignored, and the
+  contents of an ignored directory are ignored.
+*/
+type IgnoreRules struct {
+  rules  []ignoreRule
+}
+
+
+type ignoreRule struct {
+  base      string
+  regexp    *regexp.Regexp
+  negate    bool
+  dir_only  bool
+}
+
+
+/*
+  Add adds a pattern, relative to the directory key base, which is
+  "" or "." for the directory being matched in.
+*/
+func (r *IgnoreRules) Add (base, pattern string) error {
+  pattern = strings.TrimRight(pattern, " \t\r")
+  if pattern == "" || strings.HasPrefix(pattern, "#") {
+    return nil
+  }
+
+  var rule = ignoreRule { base: strings.Trim(PathToKey(base), "/") }
+  if rule.base == "." {
+    rule.base = ""
+  }
+
+  if strings.HasPrefix(pattern, "!") {
+    rule.negate = true
+    pattern     = pattern[1:]
+  } else if strings.HasPrefix(pattern, `\`) {
+    pattern = pattern[1:]
+  }
+
+  if strings.HasSuffix(pattern, "/") {
+    rule.dir_only = true
+    pattern       = strings.TrimRight(pattern, "/")
+  }
+  if pattern == "" {
+    return nil
+  }
+
+  var anchored = strings.Contains(pattern, "/")
+  pattern = strings.TrimPrefix(pattern, "/")
+
+  expression, err := ignorePatternRegexp(pattern, anchored)
+  if err != nil {
+    return fmt.Errorf("Invalid ignore pattern %q: %w", pattern, err)
+  }
+  rule.regexp = expression
+
+  r.rules = append(r.rules, rule)
+  return nil
+}
+
+
+/*
+  AddFile adds the patterns in an ignore file, relative to the
+  directory key base. A file which does not exist adds nothing.
+*/
+func (r *IgnoreRules) AddFile (base, file_path string) error {
+  file, err := os.Open(file_path)
+  if os.IsNotExist(err) {
+    return nil
+  } else if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  var scanner = bufio.NewScanner(file)
+  for scanner.Scan() {
+    if err := r.Add(base, scanner.Text()); err != nil {
+      return fmt.Errorf("%w in %s", err, file_path)
+    }
+  }
+  return scanner.Err()
+}
+
+
+/*
+  Ignored returns whether an asset key, relative to the directory
+  being matched in, is ignored, either by a pattern or by being
+  within an ignored directory.
+*/
+func (r *IgnoreRules) Ignored (key string, is_dir bool) bool {
+  if r == nil || len(r.rules) == 0 {
+    return false
+  }
+
+  key = strings.Trim(PathToKey(key), "/")
+  var segments = strings.Split(key, "/")
+  for i := 1; i < len(segments); i++ {
+    if r.ignored(strings.Join(segments[:i], "/"), true) {
+      return true
+    }
+  }
+  return r.ignored(key, is_dir)
+}
+
+
+func (r *IgnoreRules) ignored (key string, is_dir bool) bool {
+  var ignored = false
+
+  for _, rule := range r.rules {
+    if rule.dir_only && !is_dir {
+      continue
+    }
+
+    var relative = key
+    if rule.base != "" {
+      if !strings.HasPrefix(key, rule.base + "/") {
+        continue
+      }
+      relative = key[len(rule.base) + 1:]
+    }
+
+    if rule.regexp.MatchString(relative) {
+      ignored = !rule.negate
+    }
+  }
+
+  return ignored
+}
+
+
+/*
+  ignorePatternRegexp compiles a gitignore pattern into a regular
+  expression matching the whole of a relative key. Patterns which
+  are not anchored match at any depth.
+*/
+func ignorePatternRegexp (pattern string, anchored bool) (*regexp.Regexp, error) {
+  var expression strings.Builder
+  expression.WriteString("^")
+  if !anchored {
+    expression.WriteString("(?:.*/)?")
+  }
+
+  for i := 0; i < len(pattern); i++ {
+    var c = pattern[i]
+
+    switch {
+    case strings.HasPrefix(pattern[i:], "**/"):
+      expression.WriteString("(?:.*/)?")
+      i += 2
+    case strings.HasPrefix(pattern[i:], "/**") && i + 3 == len(pattern):
+      expression.WriteString("/.*")
+      i += 2
+    case strings.HasPrefix(pattern[i:], "**"):
+      expression.WriteString(".*")
+      i += 1
+    case c == '*':
+      expression.WriteString("[^/]*")
+    case c == '?':
+      expression.WriteString("[^/]")
+    case c == '\\' && i + 1 < len(pattern):
+      i++
+      expression.WriteString(regexp.QuoteMeta(pattern[i:i+1]))
+    case c == '[':
+      var end = strings.IndexByte(pattern[i+1:], ']')
+      if end < 0 {
+        expression.WriteString(`\[`)
+        continue
+      }
+      var class = pattern[i+1 : i+1+end]
+      if strings.HasPrefix(class, "!") {
+        class = "^" + class[1:]
+      }
+      expression.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+      i += end + 1
+    default:
+      expression.WriteString(regexp.QuoteMeta(string(c)))
+    }
+  }
+
+  expression.WriteString("$")
+  return regexp.Compile(expression.String())
+}
+
+
+/*
+  IgnoreRules returns the ignore rules of this Spec's directory
+  assets, from the default_ignore_patterns and its inherited
+  "ignore" prop, an array of patterns, and the names of the ignore
+  files to read from each directory, from its inherited
+  "ignore_files" prop, which defaults to IGNORE_FILE.
+*/
+func (s *Spec) IgnoreRules () (*IgnoreRules, []string, error) {
+  var rules IgnoreRules
+  for _, pattern := range default_ignore_patterns {
+    rules.Add("", pattern)
+  }
+
+  if patterns_any, found := s.InheritProp("ignore"); found {
+    patterns, ok := patterns_any.([]any)
+    if !ok {
+      return nil, nil, fmt.Errorf("Prop \"ignore\" in spec %s is expected to be an array of patterns, got %T", s.Name, patterns_any)
+    }
+    for _, pattern_any := range patterns {
+      pattern, ok := pattern_any.(string)
+      if !ok {
+        return nil, nil, fmt.Errorf("Prop \"ignore\" in spec %s is expected to be an array of patterns, got %T in its array", s.Name, pattern_any)
+      }
+      if err := rules.Add("", pattern); err != nil {
+        return nil, nil, fmt.Errorf("Prop \"ignore\" in spec %s: %w", s.Name, err)
+      }
+    }
+  }
+
+  var files = []string { IGNORE_FILE }
+  if files_any, found := s.InheritProp("ignore_files"); found {
+    names, ok := files_any.([]any)
+    if !ok {
+      return nil, nil, fmt.Errorf("Prop \"ignore_files\" in spec %s is expected to be an array of file names, got %T", s.Name, files_any)
+    }
+    files = files[:0]
+    for _, name_any := range names {
+      name, ok := name_any.(string)
+      if !ok || name == "" || strings.ContainsAny(name, `/\`) {
+        return nil, nil, fmt.Errorf("Prop \"ignore_files\" in spec %s is expected to be an array of file names, got %v in its array", s.Name, name_any)
+      }
+      files = append(files, name)
+    }
+  }
+
+  return &rules, files, nil
+}
+
+
+/*
+  WalkIgnoring walks the files in a directory, as in
+  filepath.WalkDir, calling fn with the path and asset key of each
+  file which is not ignored by the Spec's IgnoreRules, or by the
+  ignore files read from the directory and its subdirectories,
+  whose patterns are relative to where they are read from.
+  Ignored directories are not walked, and the ignore files
+  themselves are skipped.
+*/
+func (s *Spec) WalkIgnoring (dir string, fn func (file_path, key string, entry fs.DirEntry) error) error {
+  rules, ignore_files, err := s.IgnoreRules()
+  if err != nil { return err }
+
+  var is_ignore_file = make(map[string]bool, len(ignore_files))
+  for _, name := range ignore_files {
+    is_ignore_file[name] = true
+  }
+
+  return filepath.WalkDir(dir, func (file_path string, entry fs.DirEntry, err error) error {
+    if err != nil { return err }
+
+    key, err := PathKey(dir, file_path)
+    if err != nil { return err }
+
+    if key != "." && rules.Ignored(key, entry.IsDir()) {
+      if entry.IsDir() {
+        return filepath.SkipDir
+      }
+      return nil
+    }
+
+    if entry.IsDir() {
+      for _, name := range ignore_files {
+        if err := rules.AddFile(key, filepath.Join(file_path, name)); err != nil {
+          return err
+        }
+      }
+      return nil
+    }
+
+    if is_ignore_file[entry.Name()] {
+      return nil
+    }
+    return fn(file_path, key, entry)
+  })
+}
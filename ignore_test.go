@@ -0,0 +1,131 @@
+package interbuilder
+
+import (
+  "io/fs"
+  "sort"
+  "strings"
+  "testing"
+)
+
+
+func TestIgnoreRules (t *testing.T) {
+  var rules IgnoreRules
+  for _, pattern := range []string {
+    "# build output",
+    "",
+    "*.log",
+    "!keep.log",
+    "node_modules/",
+    "/dist",
+    "docs/**/draft-*.md",
+    "cache[0-9]",
+  } {
+    if err := rules.Add("", pattern); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if err := rules.Add("sub", "/local.txt"); err != nil {
+    t.Fatal(err)
+  }
+
+  var cases = []struct {
+    key      string
+    is_dir   bool
+    ignored  bool
+  } {
+    { "debug.log",                  false, true  },
+    { "logs/debug.log",             false, true  },
+    { "keep.log",                   false, false },
+    { "node_modules",               true,  true  },
+    { "node_modules",               false, false },
+    { "lib/node_modules/x.js",      false, true  },
+    { "dist/index.html",            false, true  },
+    { "lib/dist/index.html",        false, false },
+    { "docs/draft-a.md",            false, true  },
+    { "docs/2024/05/draft-b.md",    false, true  },
+    { "docs/final.md",              false, false },
+    { "cache1/a.css",               false, true  },
+    { "cachex/a.css",               false, false },
+    { "sub/local.txt",              false, true  },
+    { "local.txt",                  false, false },
+    { "sub/deeper/local.txt",       false, false },
+    { "index.html",                 false, false },
+  }
+  for _, c := range cases {
+    if got := rules.Ignored(c.key, c.is_dir); got != c.ignored {
+      t.Errorf("Expected %s (directory: %v) to be ignored: %v, got %v", c.key, c.is_dir, c.ignored, got)
+    }
+  }
+
+  var empty *IgnoreRules
+  if empty.Ignored("debug.log", false) {
+    t.Errorf("Expected nil rules to ignore nothing")
+  }
+}
+
+
+func TestSpecWalkIgnoring (t *testing.T) {
+  var root *Spec = NewSpec("root", nil)
+  var spec *Spec = root.AddSubspec(NewSpec("site", nil))
+  var dir = t.TempDir()
+
+  root.Props["ignore"]       = []any { "*.tmp" }
+  root.Props["ignore_files"] = []any { ".ibignore", ".gitignore" }
+  spec.Props["source_dir"]   = dir
+
+  for key, content := range map[string]string {
+    "index.html":        "<p>Home</p>",
+    "draft.tmp":         "draft",
+    ".git/HEAD":         "ref: refs/heads/main",
+    ".gitignore":        "node_modules/\n",
+    "node_modules/a.js": "a",
+    "posts/.ibignore":   "*.md\n",
+    "posts/a.md":        "# A",
+    "posts/a.html":      "<p>A</p>",
+    "a.md":              "# Root",
+  } {
+    if err := spec.WriteFile(key, []byte(content), 0o644); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  var keys []string
+  err := spec.WalkIgnoring(dir, func (_, key string, _ fs.DirEntry) error {
+    keys = append(keys, key)
+    return nil
+  })
+  if err != nil { t.Fatal(err) }
+
+  sort.Strings(keys)
+  if expect := "a.md index.html posts/a.html"; strings.Join(keys, " ") != expect {
+    t.Errorf("Expected the files %s, got %s", expect, strings.Join(keys, " "))
+  }
+
+  // Directory assets are expanded with the same rules
+  //
+  asset, err := spec.MakeFileKeyAsset(dir, "site")
+  if err != nil { t.Fatal(err) }
+  assets, err := asset.Flatten()
+  if err != nil { t.Fatal(err) }
+  if len(assets) != 3 {
+    t.Errorf("Expected 3 assets in the directory asset, got %d", len(assets))
+  }
+
+  // Version control directories can be included by negating them
+  //
+  spec.Props["ignore"] = []any { "!.git/" }
+  keys = nil
+  err = spec.WalkIgnoring(dir, func (_, key string, _ fs.DirEntry) error {
+    keys = append(keys, key)
+    return nil
+  })
+  if err != nil { t.Fatal(err) }
+  if !strings.Contains(strings.Join(keys, " "), ".git/HEAD") {
+    t.Errorf("Expected .git/HEAD to be walked, got %v", keys)
+  }
+
+  spec.Props["ignore"] = "*.tmp"
+  if _, _, err := spec.IgnoreRules(); err == nil {
+    t.Errorf("Expected an error for a string ignore prop")
+  }
+}
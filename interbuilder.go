@@ -1,6 +1,8 @@
 package interbuilder
 
 import (
+  "context"
+  "errors"
   "fmt"
   "sync"
   "net/url"
@@ -8,7 +10,13 @@ import (
   "time"
   "reflect"
   "runtime"
+  "runtime/pprof"
   "io"
+  "log/slog"
+  "os"
+  "sync/atomic"
+
+  "golang.org/x/sync/semaphore"
 )
 
 
@@ -46,12 +54,58 @@ type Spec struct {
 
   Running bool
 
+  // StartedAt and FinishedAt are the wall-clock times at which
+  // this Spec's most recent run started and finished, including
+  // its subspecs, and Duration is the time between them.
+  //
+  StartedAt   time.Time
+  FinishedAt  time.Time
+  Duration    time.Duration
+
   Tasks              *Task
   CurrentTask        *Task
   tasks_enqueue_end  *Task
   tasks_push_queue   *Task
   tasks_push_end     *Task
   task_queue_lock    sync.Mutex
+
+  staging_dir        string
+  staging_lock       sync.Mutex
+
+  console            *Console
+  logger             *slog.Logger
+  command_runner     CommandRunner
+  log_file           atomic.Pointer[os.File]
+
+  memory             memoryCounters
+  merge              *mergeState
+
+  spec_semaphore       *semaphore.Weighted
+  spec_semaphore_lock  sync.Mutex
+
+  output_queues       []*outputQueue
+  output_queues_lock  sync.Mutex
+
+  checkpoint  *checkpoint
+
+  event_handlers  []*EventHandler
+  event_lock      sync.RWMutex
+
+  emit_middleware       []EmitMiddleware
+  emit_chain            func (*Asset) error
+  emit_middleware_lock  sync.RWMutex
+
+  status       SpecStatus
+  status_err   error
+  status_lock  sync.Mutex
+
+  run_context      context.Context
+  run_cancel       context.CancelCauseFunc
+  run_cancelled    bool
+  run_cancel_lock  sync.Mutex
+
+  frame       *AssetFrame
+  frame_lock  sync.Mutex
 }
 
 
@@ -162,6 +216,12 @@ func (s *Spec) AddOutputSpec (o *Spec) {
 
 
 func (sp *Spec) Done () {
+  // Queued Assets must reach their outputs before the outputs are
+  // told this Spec is finished, as they may close their inputs
+  //
+  sp.closeOutputQueues()
+  sp.Frame().close()
+
   for _, output_group := range sp.OutputGroups {
     output_group.Done()
   }
@@ -175,7 +235,12 @@ func (s *Spec) Printf (format string, a ...any) (n int, err error) {
     return 0, nil
   }
 
-  return fmt.Printf(format, a...)
+  if logger := s.Logger(); logger != nil {
+    s.logLine(logger, fmt.Sprintf(format, a...))
+    return 0, nil
+  }
+
+  return s.print(fmt.Sprintf(format, a...))
 }
 
 
@@ -184,45 +249,316 @@ func (s *Spec) Println (a ...any) (n int, err error) {
     return 0, nil
   }
 
-  return fmt.Println(a...)
+  if logger := s.Logger(); logger != nil {
+    s.logLine(logger, fmt.Sprintln(a...))
+    return 0, nil
+  }
+
+  return s.print(fmt.Sprintln(a...))
 }
 
 
+/*
+  Run runs the Spec and its subspecs, as in Spec.RunContext, with
+  a background context.
+*/
 func (s *Spec) Run () error {
-  // Only run the Spec if is not already running.
+  return s.RunContext(context.Background())
+}
+
+
+/*
+  Cancel aborts this Spec's run, if it is running, cancelling the
+  contexts of its Tasks and its subspecs. The run returns an error
+  matching ErrCancelled, unless it finishes first, and its status
+  is SPEC_STATUS_CANCELLED. Like any failing subspec, a cancelled
+  subspec cancels its parent, unless the parent's inherited
+  "fail_fast" prop is false. Returns whether the Spec was running.
+*/
+func (s *Spec) Cancel () bool {
+  if status, _ := s.Status(); status != SPEC_STATUS_RUNNING {
+    return false
+  }
+
+  s.run_cancel_lock.Lock()
+  defer s.run_cancel_lock.Unlock()
+
+  s.run_cancelled = true
+  if s.run_cancel != nil {
+    s.run_cancel(& SpecError { Spec: s.Name, Err: ErrCancelled })
+  }
+  return true
+}
+
+
+/*
+  Context returns the context of this Spec's run, or of its
+  nearest running ancestor, which is cancelled when the run is
+  aborted. Outside of a run, context.Background() is returned.
+*/
+func (s *Spec) Context () context.Context {
+  for spec := s; spec != nil; spec = spec.Parent {
+    spec.run_cancel_lock.Lock()
+    var ctx = spec.run_context
+    spec.run_cancel_lock.Unlock()
+
+    if ctx != nil {
+      return ctx
+    }
+  }
+  return context.Background()
+}
+
+
+/*
+  setRunCancel sets the context of this Spec's run and the
+  function cancelling it, once they are created, cancelling it
+  right away if Cancel was called before then.
+*/
+func (s *Spec) setRunCancel (ctx context.Context, cancel context.CancelCauseFunc) {
+  s.run_cancel_lock.Lock()
+  defer s.run_cancel_lock.Unlock()
+
+  s.run_context = ctx
+  s.run_cancel  = cancel
+  if cancel != nil && s.run_cancelled {
+    cancel(& SpecError { Spec: s.Name, Err: ErrCancelled })
+  }
+}
+
+
+func (s *Spec) cancelRequested () bool {
+  s.run_cancel_lock.Lock()
+  defer s.run_cancel_lock.Unlock()
+  return s.run_cancelled
+}
+
+
+/*
+  specSemaphore returns the semaphore limiting how many Specs run
+  at once, belonging to the nearest Spec in the parental chain
+  which defines the "max_concurrent_specs" prop, or nil if there
+  is no limit. The semaphore is created on first use.
+*/
+func (s *Spec) specSemaphore () (*semaphore.Weighted, error) {
+  for owner := s; owner != nil; owner = owner.Parent {
+    limit_any, found := owner.Props["max_concurrent_specs"]
+    if !found {
+      continue
+    }
+
+    limit, ok := propInt(limit_any)
+    if !ok || limit < 0 {
+      return nil, fmt.Errorf(
+        "Prop \"max_concurrent_specs\" in Spec %s is expected to be a non-negative integer, got %v",
+        owner.Name, limit_any,
+      )
+    }
+    if limit == 0 {
+      return nil, nil
+    }
+
+    owner.spec_semaphore_lock.Lock()
+    defer owner.spec_semaphore_lock.Unlock()
+    if owner.spec_semaphore == nil {
+      owner.spec_semaphore = semaphore.NewWeighted(int64(limit))
+    }
+    return owner.spec_semaphore, nil
+  }
+
+  return nil, nil
+}
+
+
+/*
+  runLimited runs a subspec with RunContext, first waiting for a
+  slot from the semaphore of the "max_concurrent_specs" prop.
+  Only Specs without subspecs take a slot: a parent waits on its
+  children, so holding a slot while they wait for one could
+  deadlock the tree.
+*/
+func (s *Spec) runLimited (ctx context.Context) error {
+  if len(s.Subspecs) > 0 {
+    return s.RunContext(ctx)
+  }
+
+  sem, err := s.specSemaphore()
+  if err != nil {
+    // Release the parent's input group, as RunContext would
+    //
+    s.Done()
+    return err
+  }
+  if sem == nil {
+    return s.RunContext(ctx)
+  }
+
+  if err := sem.Acquire(ctx, 1); err != nil {
+    s.Done()
+    return err
+  }
+  defer sem.Release(1)
+
+  return s.RunContext(ctx)
+}
+
+
+/*
+  RunContext runs this Spec's task queue while running its
+  subspecs concurrently, and returns once its tasks are finished
+  and its subspecs have exited. If a subspec returns an error,
+  the context given to tasks and subspecs is cancelled, and the
+  run is aborted. Cancelling ctx aborts the run in the same way.
+  If the inherited "fail_fast" prop is false, failing subspecs do
+  not cancel the run, and their errors are joined with
+  errors.Join. The outcome of each Spec is available from
+  StatusReport.
+*/
+func (s *Spec) RunContext (ctx context.Context) (err error) {
+  // Only run the Spec if it has not already been started.
   //
   s.task_queue_lock.Lock()
   if s.Running {
     s.task_queue_lock.Unlock()
-    return fmt.Errorf("Spec with name \"%s\" is already running", s.Name)
+    return & SpecError { Spec: s.Name, Err: ErrSpecAlreadyRunning }
   }
   s.Running = true
   s.task_queue_lock.Unlock()
 
+  // The log file is opened first, so that it has every line the
+  // Spec prints, and closed last. An error opening it fails the
+  // Spec once it has started.
+  //
+  closeLogFile, log_file_err := s.openLogFile()
+  defer closeLogFile()
+
   s.Printf("[%s] Running\n", s.Name)
   defer s.Printf("[%s] Exit\n", s.Name)
   defer s.Done()
 
+  s.run_cancel_lock.Lock()
+  s.run_cancelled = false
+  s.run_cancel_lock.Unlock()
+
+  s.StartedAt  = time.Now()
+  s.FinishedAt = time.Time {}
+  s.Duration   = 0
+
+  s.setStatus(SPEC_STATUS_RUNNING, nil)
+  s.emitEvent(Event { Type: EVENT_SPEC_START, Time: s.StartedAt })
+
+  var caller_ctx = ctx
+  defer func () {
+    if err != nil && s.cancelRequested() {
+      err = & SpecError { Spec: s.Name, Err: ErrCancelled }
+    }
+    s.FinishedAt = time.Now()
+    s.Duration   = s.FinishedAt.Sub(s.StartedAt)
+    s.finishStatus(caller_ctx, err)
+    s.emitEvent(Event { Type: EVENT_SPEC_END, Err: err, Time: s.FinishedAt })
+  }()
+
+  if log_file_err != nil {
+    return & SpecError { Spec: s.Name, Err: log_file_err }
+  }
+
+  // Label this Spec's goroutine for diagnostics, restoring the
+  // caller's labels when it returns
+  //
+  ctx = labelSpecContext(ctx, s)
+  defer pprof.SetGoroutineLabels(caller_ctx)
+
+  // Staged asset content is shared by the Spec tree, so only the
+  // root removes it. Running roots are listed by WriteDiagnostics.
+  //
+  if s.Root == s {
+    defer s.removeStagingDir()
+    addRunningRoot(s)
+    defer removeRunningRoot(s)
+  }
+
+  // Before anything in the Spec tree runs, check its Task queues
+  // for masks which could not work
+  //
+  if s.Root == s {
+    if err := s.VerifyMasks(); err != nil {
+      return err
+    }
+  }
+
+  // A Spec whose checkpoint is complete re-outputs its recorded
+  // assets, rather than running again
+  //
+  if resumed, err := s.startCheckpoint(); err != nil || resumed {
+    return err
+  }
+
   var num_subspecs = len(s.Subspecs)
 
-  // Error and cancel channels. These are buffered with the
-  // number of subspecs, because sending to an unbuffered channel
-  // blocks, which can prevent the goroutines of subspecs from
-  // exiting if they are trying to send an error.
+  // The error channel is buffered with the number of subspecs,
+  // because sending to an unbuffered channel blocks, which can
+  // prevent the goroutines of subspecs from exiting if they are
+  // trying to send an error.
   //
-  var error_chan       = make(chan error, num_subspecs)
-  var cancel_task_chan = make(chan bool,  num_subspecs)
+  var error_chan = make(chan error, num_subspecs)
+
+  // Cancelling this context aborts the task loop, the tasks in
+  // it, and subspecs.
+  //
+  ctx, cancel := context.WithCancelCause(ctx)
+  defer cancel(nil)
+  s.setRunCancel(ctx, cancel)
+  defer s.setRunCancel(nil, nil)
+
+  // Unless the "fail_fast" prop is false, a failing subspec
+  // cancels the run. Otherwise, its siblings and this Spec's
+  // tasks continue, and the errors of failed subspecs are
+  // returned together once they finish.
+  //
+  var fail_fast = true
+  if value, ok, found := s.InheritPropBool("fail_fast"); found && ok {
+    fail_fast = value
+  }
+  var subspec_errors []error
+
+  // If the inherited "deterministic" prop is set, the assets of
+  // subspecs are buffered and received in a reproducible order
+  // once they finish, rather than as they arrive
+  //
+  order, err := s.deterministicOrder()
+  if err != nil {
+    return & SpecError { Spec: s.Name, Err: err }
+  }
+
+  // The "merge_conflicts" prop sets which Asset is received when
+  // subspecs output Assets at the same path
+  //
+  if err := s.startMerge(); err != nil {
+    return & SpecError { Spec: s.Name, Err: err }
+  }
+
+  var closeInput = func () { close(s.Input) }
+  if order != "" && num_subspecs > 0 {
+    closeInput = s.reorderInput(order)
+  }
 
-  // Run subspecs in parallel goroutines
+  // Run subspecs in parallel goroutines. Errors are sent before
+  // cancelling, so that the error which caused the cancellation
+  // is received before those of cancelled sibling subspecs.
   //
+  var subspec_group sync.WaitGroup
+
   for _, subspec := range s.Subspecs {
+    subspec_group.Add(1)
     go func () {
-      err := subspec.Run()
+      defer subspec_group.Done()
+      err := subspec.runLimited(ctx)
       if err != nil {
-        error_chan <- fmt.Errorf(
-          "Error in subspec \"%s\": %w", subspec.Name, err,
-        )
-        cancel_task_chan <- true
+        err = & SpecError { Spec: subspec.Name, Subspec: true, Err: err }
+        error_chan <- err
+        if fail_fast {
+          cancel(err)
+        }
       }
     }()
   }
@@ -232,7 +568,20 @@ func (s *Spec) Run () error {
   //
   go func () {
     s.InputGroup.Wait()
-    close(s.Input)
+    closeInput()
+  }()
+
+  // If this run fails, cancel subspecs and wait for them to exit,
+  // discarding their assets, so that their status is final when
+  // this returns
+  //
+  defer func () {
+    if err == nil {
+      return
+    }
+    cancel(err)
+    for range s.Input {}
+    subspec_group.Wait()
   }()
 
   //
@@ -240,27 +589,28 @@ func (s *Spec) Run () error {
   //
   s.task_queue_lock.Lock()
   s.flushTaskPushQueue()
+  if err := s.sortTaskQueueUnsafe(nil); err != nil {
+    s.task_queue_lock.Unlock()
+    return err
+  }
   var task *Task = s.Tasks
   s.CurrentTask = task
   s.task_queue_lock.Unlock()
 
   TASK_LOOP:
   for task != nil {
-    select {
-    default:
-      // pass
-    case <-cancel_task_chan:
-      // TODO: instead of cancelling the task loop, perhaps this should skip to deferred tasks to allow cleanup tasks.
+    // TODO: instead of cancelling the task loop, perhaps this should skip to deferred tasks to allow cleanup tasks.
+    if ctx.Err() != nil {
       break TASK_LOOP
     }
 
     // Check there's a valid task queue, going forward
 
     if t := s.Tasks.GetCircularTask(); t != nil {
-      return fmt.Errorf(
-        "Error in spec %s: repeating (circular) task entry in task list: %s",
-        s.Name, t.ResolverId,
-      )
+      return & SpecError {
+        Spec: s.Name,
+        Err:  fmt.Errorf("Repeating (circular) task entry in task list: %s", t.ResolverId),
+      }
     }
 
     if task.Started {
@@ -268,39 +618,87 @@ func (s *Spec) Run () error {
     }
 
     if (task.Func == nil) && (task.MapFunc == nil) {
-      err := fmt.Errorf(
-        "Error in spec %s: task \"%s\" doesn't have a Func or MapFunc defined",
-        s.Name, task.Name,
-      )
-      return err
+      return & TaskError {
+        Spec:       s.Name,
+        Task:       task.Name,
+        ResolverId: task.ResolverId,
+        Err:        fmt.Errorf("Task doesn't have a Func or MapFunc defined"),
+      }
+    }
+
+    // Evaluate whether to skip the task
+    //
+    var skip bool
+    if task.SkipFunc != nil {
+      var err error
+      if skip, err = task.SkipFunc(s, task); err != nil {
+        return & TaskError {
+          Spec:       s.Name,
+          Task:       task.Name,
+          ResolverId: task.ResolverId,
+          Err:        fmt.Errorf("Skip function error: %w", err),
+        }
+      }
     }
 
     // Run the Task Func
     //
+    var skip_label string
+    if skip {
+      skip_label = " [skipped]"
+    }
+
     if task.ResolverId == "" {
-      s.Printf("[%s] task: %s\n", s.Name, task.Name)
+      s.Printf("[%s] task: %s%s\n", s.Name, task.Name, skip_label)
     } else {
-      s.Printf("[%s] task: %s (%s)\n", s.Name, task.Name, task.ResolverId)
+      s.Printf("[%s] task: %s (%s)%s\n", s.Name, task.Name, task.ResolverId, skip_label)
     }
 
-    task.CancelChan = cancel_task_chan  // Pass by reference
+    task.context = labelTaskContext(ctx, task)
+    task.startTiming()
 
-    if err := task.Run(s); err != nil {
-      if task.ResolverId != "" {
-        return fmt.Errorf(
-          "Error in spec %s, in task %s (%s): %w\n",
-          s.Name, task.Name, task.ResolverId, err,
-        )
-      } else {
-        return fmt.Errorf(
-          "Error in spec %s, in task %s: %w\n",
-          s.Name, task.Name, err,
-        )
+    if skip {
+      task.finishTiming()
+      s.emitEvent(Event { Type: EVENT_TASK_SKIP, Task: task, Time: task.StartedAt })
+    } else {
+      s.emitEvent(Event { Type: EVENT_TASK_START, Task: task, Time: task.StartedAt })
+    }
+
+    if skip {
+      if err := task.Skip(); err != nil {
+        return & SpecError { Spec: s.Name, Err: err }
+      }
+    } else if err := task.Run(s); err != nil {
+      task.finishTiming()
+      s.emitEvent(Event { Type: EVENT_TASK_ERROR, Task: task, Err: err, Time: task.FinishedAt })
+
+      // If the run was cancelled, the task likely failed because
+      // of it, so report the cause of the cancellation instead.
+      //
+      if ctx.Err() != nil {
+        return context.Cause(ctx)
+      }
+
+      return & TaskError {
+        Spec:       s.Name,
+        Task:       task.Name,
+        ResolverId: task.ResolverId,
+        Err:        err,
       }
     }
 
-    task.CancelChan = nil
-    task.Assets     = nil // Let un-emitted assets get freed
+    if !skip {
+      task.finishTiming()
+      s.emitEvent(Event { Type: EVENT_TASK_END, Task: task, Time: task.FinishedAt })
+    }
+
+    task.context = nil
+    task.takeAssets() // Let un-emitted assets get freed
+    pprof.SetGoroutineLabels(ctx)
+
+    if err := s.checkpointTask(task); err != nil {
+      return & SpecError { Spec: s.Name, Err: err }
+    }
 
     // Flush the push queue and advance to the next task. Merge
     // the internal asset buffer into the next task.
@@ -309,6 +707,10 @@ func (s *Spec) Run () error {
     //
     s.task_queue_lock.Lock()
     s.flushTaskPushQueue()
+    if err := s.sortTaskQueueUnsafe(task); err != nil {
+      s.task_queue_lock.Unlock()
+      return err
+    }
     task          = task.Next
     s.CurrentTask = task
     s.task_queue_lock.Unlock()
@@ -328,32 +730,72 @@ func (s *Spec) Run () error {
   // Done, in turn causing the asset consumption in the loop
   // below to finish.
 
+  stall_timeout, err := s.stallTimeout()
+  if err != nil {
+    return & SpecError { Spec: s.Name, Err: err }
+  }
+  var stall_timer = newStallTimer(stall_timeout)
+  if stall_timer != nil {
+    defer stall_timer.Stop()
+  }
+
   CONSUME_INPUT_AND_ERRORS:
   for {
     select {
+    case <-stallChan(stall_timer):
+      return s.stallError(stall_timeout)
+
     case err := <-error_chan:
       s.Println(err)
-      return err
+      if fail_fast {
+        return err
+      }
+      subspec_errors = append(subspec_errors, err)
 
     case asset, ok := <-s.Input:
       if ok == false {
         // Subspecs may have finished executing, but they may
-        // still be sending an error. Wait a tiny bit before
-        // closing the error channel.
+        // still be sending an error. Wait for their goroutines to
+        // exit before checking for errors.
         //
-        time.Sleep(5 * time.Millisecond)
-        close(error_chan)
+        subspec_group.Wait()
         break CONSUME_INPUT_AND_ERRORS
       }
 
       if err := s.EmitAsset(asset); err != nil {
         return err
       }
+      resetStallTimer(stall_timer, stall_timeout)
     }
   }
 
-  if err := <- error_chan; err != nil {
-    return err
+  // Collect errors sent after the input channel closed
+  //
+  COLLECT_ERRORS:
+  for {
+    select {
+    case err := <-error_chan:
+      if fail_fast {
+        return err
+      }
+      subspec_errors = append(subspec_errors, err)
+    default:
+      break COLLECT_ERRORS
+    }
+  }
+
+  if len(subspec_errors) > 0 {
+    return errors.Join(subspec_errors...)
+  }
+
+  // The run may have been aborted by the caller's context
+  //
+  if err := ctx.Err(); err != nil {
+    return & SpecError { Spec: s.Name, Err: fmt.Errorf("Run was cancelled: %w", err) }
+  }
+
+  if err := s.completeCheckpoint(); err != nil {
+    return & SpecError { Spec: s.Name, Err: err }
   }
 
   return nil
@@ -366,12 +808,16 @@ func specFormat (w io.Writer, s *Spec, level int) {
   var align_1 string = align_0 + tab
   var align_2 string = align_1 + tab
 
-  fmt.Fprint(w, align_0, s.Url, "\n")
+  if s.Duration > 0 {
+    fmt.Fprint(w, align_0, s.Url, "  ", formatDuration(s.Duration), "\n")
+  } else {
+    fmt.Fprint(w, align_0, s.Url, "\n")
+  }
 
   // Properties
   //
   if len(s.Props) > 0 {
-    fmt.Sprint(w, align_1, "Properties:\n")
+    fmt.Fprint(w, align_1, "Properties:\n")
     for key, value := range s.Props {
       fmt.Fprintf(w, "%s%s  \t%T  \t%v\n", align_2, key, value, value)
     }
@@ -390,6 +836,8 @@ func specFormat (w io.Writer, s *Spec, level int) {
     bullet := "-"
     if task.Started {
       bullet = ">"
+    } else if task.Skipped {
+      bullet = "~"
     }
 
     // Check for task uniqueness and terminate circular task lists
@@ -401,7 +849,11 @@ func specFormat (w io.Writer, s *Spec, level int) {
     }
     task_pointers[task] = true
 
-    fmt.Fprintf(w, "%s%s %s (%s)\n", align_2, bullet, task.Name, task.ResolverId)
+    if task.Duration > 0 {
+      fmt.Fprintf(w, "%s%s %s (%s)  %s\n", align_2, bullet, task.Name, task.ResolverId, formatDuration(task.Duration))
+    } else {
+      fmt.Fprintf(w, "%s%s %s (%s)\n", align_2, bullet, task.Name, task.ResolverId)
+    }
   }
 
   // Subspecs
@@ -415,6 +867,22 @@ func specFormat (w io.Writer, s *Spec, level int) {
 }
 
 
+/*
+  formatDuration rounds a duration for display, to milliseconds if
+  it is at least a second, or to microseconds if it is at least a
+  millisecond.
+*/
+func formatDuration (d time.Duration) string {
+  switch {
+  case d >= time.Second:
+    return d.Round(time.Millisecond).String()
+  case d >= time.Millisecond:
+    return d.Round(time.Microsecond).String()
+  }
+  return d.String()
+}
+
+
 func SprintSpec (s *Spec) string {
   var builder strings.Builder
   specFormat(&builder, s, 0)
@@ -2,9 +2,13 @@ package interbuilder
 
 import (
   "testing"
+  "context"
+  "errors"
   "fmt"
   "strings"
   "io"
+  "sync/atomic"
+  "time"
 )
 
 
@@ -177,14 +181,13 @@ func TestSpecTaskCancelledBySubspecError (t *testing.T) {
 
   root.EnqueueTaskFunc("cancellable-consume", func (s *Spec, tk *Task) error {
     for { select {
-      case <-tk.CancelChan:
+      case <-tk.Context().Done():
         return nil
       case asset_chunk, ok := <-s.Input:
         if ok {
           t.Fatalf("Spec received unexpected asset chunk: %v", asset_chunk)
         }
     }}
-    return nil
   })
 
   subspec.EnqueueTaskFunc("error", func (s *Spec, tk *Task) error {
@@ -204,6 +207,158 @@ func TestSpecTaskCancelledBySubspecError (t *testing.T) {
 }
 
 
+func TestSpecRunContextKillsCommands (t *testing.T) {
+  root    := NewSpec("root", nil)
+  subspec := root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"] = true
+
+  var ready = make(chan bool)
+
+  root.EnqueueTaskFunc("sleep", func (s *Spec, tk *Task) error {
+    close(ready)
+    _, err := tk.CommandRun("sleep", "10")
+    return err
+  })
+
+  subspec.EnqueueTaskFunc("error", func (s *Spec, tk *Task) error {
+    <-ready
+    return fmt.Errorf("Expected error")
+  })
+
+  TestWrapTimeout(t, func () {
+    err := root.Run()
+    if err == nil {
+      t.Error("Expected spec to error, but no error was returned")
+    } else if !strings.Contains(err.Error(), "Expected error") {
+      t.Errorf("Spec exited with an error, but it was not the expected error: %v", err)
+    }
+  })
+}
+
+
+func TestSpecRunContextCancel (t *testing.T) {
+  root := NewSpec("root", nil)
+  root.Props["quiet"] = true
+
+  ctx, cancel := context.WithCancel(context.Background())
+
+  root.EnqueueTaskFunc("cancel", func (s *Spec, tk *Task) error {
+    cancel()
+    <-tk.Context().Done()
+    return nil
+  })
+
+  root.EnqueueTaskFunc("unreached", func (s *Spec, tk *Task) error {
+    t.Error("Task ran after the context was cancelled")
+    return nil
+  })
+
+  TestWrapTimeout(t, func () {
+    if err := root.RunContext(ctx); !errors.Is(err, context.Canceled) {
+      t.Errorf("Expected a context.Canceled error, got %v", err)
+    }
+  })
+}
+
+
+func TestSpecRunContextAlreadyRunning (t *testing.T) {
+  root := NewSpec("root", nil)
+  root.Props["quiet"] = true
+
+  var started = make(chan struct{})
+  var release = make(chan struct{})
+
+  root.EnqueueTaskFunc("block", func (s *Spec, tk *Task) error {
+    close(started)
+    <-release
+    return nil
+  })
+
+  TestWrapTimeout(t, func () {
+    var done = make(chan error)
+    go func () { done <- root.Run() }()
+
+    <-started
+    if err := root.RunContext(context.Background()); !errors.Is(err, ErrSpecAlreadyRunning) {
+      t.Errorf("Expected ErrSpecAlreadyRunning from a second run, got %v", err)
+    }
+
+    close(release)
+    if err := <-done; err != nil {
+      t.Errorf("Expected the first run to finish, got %v", err)
+    }
+  })
+}
+
+
+func TestSpecMaxConcurrentSpecs (t *testing.T) {
+  root := NewSpec("root", nil)
+  root.Props["quiet"] = true
+
+  // Numbers decoded from JSON are float64
+  //
+  root.Props["max_concurrent_specs"] = float64(2)
+
+  var running, max_running atomic.Int32
+
+  var leafTask = func (s *Spec, tk *Task) error {
+    n := running.Add(1)
+    for {
+      m := max_running.Load()
+      if n <= m || max_running.CompareAndSwap(m, n) {
+        break
+      }
+    }
+    time.Sleep(10 * time.Millisecond)
+    running.Add(-1)
+    return nil
+  }
+
+  // Leaves under an intermediate spec share the root's limit, and
+  // the intermediate spec does not hold a slot itself
+  //
+  middle := root.AddSubspec(NewSpec("middle", nil))
+
+  for i := 0 ; i < 3 ; i++ {
+    root.AddSubspec(NewSpec(fmt.Sprintf("leaf-%d", i), nil)).
+      EnqueueTaskFunc("run", leafTask)
+    middle.AddSubspec(NewSpec(fmt.Sprintf("middle-leaf-%d", i), nil)).
+      EnqueueTaskFunc("run", leafTask)
+  }
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if got := max_running.Load(); got > 2 {
+    t.Errorf("Expected at most 2 specs running at once, got %d", got)
+  }
+
+  // A limit of one does not deadlock nested specs
+  //
+  serial := NewSpec("serial", nil)
+  serial.Props["quiet"] = true
+  serial.Props["max_concurrent_specs"] = 1
+  serial_middle := serial.AddSubspec(NewSpec("middle", nil))
+  serial_middle.AddSubspec(NewSpec("leaf-a", nil))
+  serial_middle.AddSubspec(NewSpec("leaf-b", nil))
+
+  TestWrapTimeoutError(t, serial.Run)
+
+  // Invalid limits are errors
+  //
+  invalid := NewSpec("invalid", nil)
+  invalid.Props["quiet"] = true
+  invalid.Props["max_concurrent_specs"] = "two"
+  invalid.AddSubspec(NewSpec("leaf", nil))
+
+  TestWrapTimeout(t, func () {
+    if err := invalid.Run(); err == nil {
+      t.Error("Expected an error from an invalid max_concurrent_specs prop")
+    }
+  })
+}
+
+
 func TestSpecChainTransformAssetPaths (t *testing.T) {
   root    := NewSpec("root", nil)
   level_3 :=    root.AddSubspec( NewSpec("level_3", nil ) )
@@ -348,6 +503,65 @@ func TestSprintSpec (t *testing.T) {
 }
 
 
+func TestSpecTiming (t *testing.T) {
+  var root    = NewSpec("root", nil)
+  var subspec = root.AddSubspec(NewSpec("subspec", nil))
+  root.Props["quiet"] = true
+
+  var sleep = func (*Spec, *Task) error {
+    time.Sleep(20 * time.Millisecond)
+    return nil
+  }
+  subspec.EnqueueTaskFunc("slow", sleep)
+
+  member := & Task { Name: "member", Mask: TASK_ASSETS_GENERATE, Func: sleep }
+  group, err := NewTaskGroup("group", member)
+  if err != nil {
+    t.Fatal(err)
+  }
+  subspec.EnqueueTask(group)
+
+  var before = time.Now()
+  report, err := root.RunReport(context.Background())
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for _, task := range []*Task { subspec.Tasks, group, member } {
+    if task.StartedAt.Before(before) || task.FinishedAt.Before(task.StartedAt) {
+      t.Errorf("Task %s has unexpected times: %v to %v", task.Name, task.StartedAt, task.FinishedAt)
+    }
+    if task.Duration < 20 * time.Millisecond || task.Duration != task.FinishedAt.Sub(task.StartedAt) {
+      t.Errorf("Task %s has an unexpected duration: %v", task.Name, task.Duration)
+    }
+  }
+
+  if subspec.Duration < 40 * time.Millisecond || root.Duration < subspec.Duration {
+    t.Errorf("Unexpected spec durations: root %v, subspec %v", root.Duration, subspec.Duration)
+  }
+  if root.StartedAt.After(subspec.StartedAt) || root.FinishedAt.Before(subspec.FinishedAt) {
+    t.Errorf("Expected the run of the root to contain the run of its subspec")
+  }
+
+  // The timings are shown by SprintSpec, and are those of the
+  // build report
+  //
+  var specs_string = SprintSpec(root)
+  if !strings.Contains(specs_string, "slow ()  " + formatDuration(subspec.Tasks.Duration)) {
+    t.Errorf("Expected SprintSpec to show the duration of task slow, got:\n%s", specs_string)
+  }
+
+  for _, spec_report := range report.Specs {
+    if spec_report.Path != "root/subspec" {
+      continue
+    }
+    if spec_report.Duration != subspec.Duration || spec_report.Tasks[0].Duration != subspec.Tasks.Duration {
+      t.Errorf("Expected the report to have the durations of the spec and its tasks, got %+v", spec_report)
+    }
+  }
+}
+
+
 func TestTaskPassAssetsToSpec (t *testing.T) {
   var root    *Spec = NewSpec("root", nil)
   var subspec *Spec = root.AddSubspec(NewSpec("subspec", nil))
@@ -378,7 +592,7 @@ func TestTaskPassAssetsToSpec (t *testing.T) {
 
   root.EnqueueTaskFunc("consume-assert", func (s *Spec, tk *Task) error {
     for { select {
-    case <-tk.CancelChan:
+    case <-tk.Context().Done():
       return nil
 
     case asset_chunk, ok := <-s.Input:
@@ -0,0 +1,223 @@
+package interbuilder
+
+import (
+  "fmt"
+  "path"
+  "path/filepath"
+  "runtime"
+  "strings"
+)
+
+
+/*
+  pathStyle holds the filesystem path rules of a platform, so that
+  the Windows rules can be tested elsewhere. Asset keys, the paths
+  of asset URLs, are always separated by forward slashes and
+  compared case-sensitively, whereas filesystem paths use the
+  separators, volumes, and case sensitivity of the platform. The
+  functions in this file convert between the two, so that
+  pipelines behave the same on Windows, with its drive letters,
+  backslashes, and case-insensitive paths, as elsewhere.
+*/
+type pathStyle struct {
+  windows  bool
+}
+
+
+var host_path_style = pathStyle { windows: runtime.GOOS == "windows" }
+
+
+/*
+  PathToKey converts a relative filesystem path into an asset key,
+  separated by forward slashes and cleaned, as in path.Clean.
+*/
+func PathToKey (file_path string) string {
+  return host_path_style.pathToKey(file_path)
+}
+
+
+/*
+  KeyToPath converts an asset key, or the path of an asset URL,
+  into a filesystem path relative to the directory the key is
+  within. Leading slashes are removed, so that "/css/site.css" is
+  "css\site.css" on Windows, and the key "/" is ".".
+*/
+func KeyToPath (key string) string {
+  return host_path_style.keyToPath(key)
+}
+
+
+/*
+  PathKey returns the asset key of a filesystem path within a
+  directory, or an error if it is not within it. If only one of
+  the paths is absolute, both are made absolute first. On
+  Windows, drive letters and paths are compared case-insensitively,
+  and either separator may be used.
+*/
+func PathKey (dir, file_path string) (string, error) {
+  dir, file_path, err := absPathPair(dir, file_path)
+  if err != nil { return "", err }
+
+  key, within := host_path_style.pathKey(dir, file_path)
+  if !within {
+    return "", fmt.Errorf("Path %s is not within %s", file_path, dir)
+  }
+  return key, nil
+}
+
+
+/*
+  PathWithin returns whether a filesystem path is a directory, or
+  is within it, as in PathKey.
+*/
+func PathWithin (dir, file_path string) bool {
+  _, err := PathKey(dir, file_path)
+  return err == nil
+}
+
+
+/*
+  FileUrlPath converts the path of a file URL into a filesystem
+  path. On Windows, the slash before a drive letter is removed, so
+  that the path of "file:///C:/site" is "C:\site".
+*/
+func FileUrlPath (url_path string) string {
+  return host_path_style.fileUrlPath(url_path)
+}
+
+
+func absPathPair (a, b string) (string, string, error) {
+  if filepath.IsAbs(a) == filepath.IsAbs(b) {
+    return a, b, nil
+  }
+
+  a, err := filepath.Abs(a)
+  if err != nil { return "", "", err }
+  b, err = filepath.Abs(b)
+  if err != nil { return "", "", err }
+  return a, b, nil
+}
+
+
+func (st pathStyle) toSlash (p string) string {
+  if st.windows {
+    return strings.ReplaceAll(p, `\`, "/")
+  }
+  return p
+}
+
+
+func (st pathStyle) fromSlash (p string) string {
+  if st.windows {
+    return strings.ReplaceAll(p, "/", `\`)
+  }
+  return p
+}
+
+
+/*
+  volumeLen returns the length of the volume at the start of a
+  path separated by forward slashes: a drive letter, such as "C:",
+  or a UNC share, such as "//server/share", on Windows.
+*/
+func (st pathStyle) volumeLen (p string) int {
+  if !st.windows {
+    return 0
+  }
+
+  if len(p) >= 2 && p[1] == ':' && isDriveLetter(p[0]) {
+    return 2
+  }
+
+  if strings.HasPrefix(p, "//") && len(p) > 2 && p[2] != '/' {
+    server_end := strings.IndexByte(p[2:], '/')
+    if server_end < 0 {
+      return len(p)
+    }
+    share_start := 2 + server_end + 1
+    share_end   := strings.IndexByte(p[share_start:], '/')
+    if share_end < 0 {
+      return len(p)
+    }
+    return share_start + share_end
+  }
+
+  return 0
+}
+
+
+func isDriveLetter (c byte) bool {
+  return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+
+/*
+  clean converts a path to forward slashes and cleans it, keeping
+  its volume.
+*/
+func (st pathStyle) clean (p string) string {
+  p = st.toSlash(p)
+  var volume_len = st.volumeLen(p)
+  var volume, rest = p[:volume_len], p[volume_len:]
+
+  if rest == "" {
+    return volume
+  }
+  return volume + path.Clean(rest)
+}
+
+
+func (st pathStyle) equal (a, b string) bool {
+  if st.windows {
+    return strings.EqualFold(a, b)
+  }
+  return a == b
+}
+
+
+func (st pathStyle) pathToKey (file_path string) string {
+  return path.Clean(st.toSlash(file_path))
+}
+
+
+func (st pathStyle) keyToPath (key string) string {
+  key = strings.TrimLeft(st.toSlash(key), "/")
+  if key == "" {
+    return "."
+  }
+  return st.fromSlash(path.Clean(key))
+}
+
+
+func (st pathStyle) pathKey (dir, file_path string) (string, bool) {
+  dir       = st.clean(dir)
+  file_path = st.clean(file_path)
+
+  if st.equal(dir, file_path) {
+    return ".", true
+  }
+
+  if dir == "." {
+    if file_path == ".." || strings.HasPrefix(file_path, "../") || strings.HasPrefix(file_path, "/") || st.volumeLen(file_path) > 0 {
+      return "", false
+    }
+    return file_path, true
+  }
+
+  var prefix = dir
+  if !strings.HasSuffix(prefix, "/") {
+    prefix += "/"
+  }
+  if len(file_path) > len(prefix) && st.equal(file_path[:len(prefix)], prefix) {
+    return file_path[len(prefix):], true
+  }
+  return "", false
+}
+
+
+func (st pathStyle) fileUrlPath (url_path string) string {
+  if st.windows && len(url_path) >= 3 && url_path[0] == '/' && url_path[2] == ':' && isDriveLetter(url_path[1]) {
+    url_path = url_path[1:]
+  }
+  return st.fromSlash(url_path)
+}
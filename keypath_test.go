@@ -0,0 +1,139 @@
+package interbuilder
+
+import (
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+func TestPathStyle (t *testing.T) {
+  var unix    = pathStyle {}
+  var windows = pathStyle { windows: true }
+
+  var key_cases = []struct {
+    style   pathStyle
+    path    string
+    expect  string
+  } {
+    { unix,    "css/site.css",       "css/site.css" },
+    { unix,    `css\site.css`,       `css\site.css` },
+    { unix,    "./css//site.css",    "css/site.css" },
+    { windows, `css\site.css`,       "css/site.css" },
+    { windows, `.\css\..\index.html`, "index.html" },
+  }
+  for _, c := range key_cases {
+    if got := c.style.pathToKey(c.path); got != c.expect {
+      t.Errorf("Expected the key of %q (windows: %v) to be %q, got %q", c.path, c.style.windows, c.expect, got)
+    }
+  }
+
+  var path_cases = []struct {
+    style   pathStyle
+    key     string
+    expect  string
+  } {
+    { unix,    "/@emit/css/site.css", "@emit/css/site.css" },
+    { unix,    "/",                   "." },
+    { unix,    "css//site.css",       "css/site.css" },
+    { windows, "/css/site.css",       `css\site.css` },
+    { windows, `\css/site.css`,       `css\site.css` },
+    { windows, "",                    "." },
+  }
+  for _, c := range path_cases {
+    if got := c.style.keyToPath(c.key); got != c.expect {
+      t.Errorf("Expected the path of key %q (windows: %v) to be %q, got %q", c.key, c.style.windows, c.expect, got)
+    }
+  }
+
+  var within_cases = []struct {
+    style   pathStyle
+    dir     string
+    path    string
+    expect  string
+    within  bool
+  } {
+    { unix,    "/srv/site",           "/srv/site/css/site.css",     "css/site.css", true  },
+    { unix,    "/srv/site/",          "/srv/site",                  ".",            true  },
+    { unix,    "/srv/site",           "/srv/site-old/index.html",   "",             false },
+    { unix,    "/srv/site",           "/srv/site/../other",         "",             false },
+    { unix,    "/srv/Site",           "/srv/site/index.html",       "",             false },
+    { unix,    "/",                   "/index.html",                "index.html",   true  },
+    { unix,    ".",                   "css/site.css",               "css/site.css", true  },
+    { unix,    ".",                   "../site",                    "",             false },
+    { windows, `C:\Sites\Blog`,       `c:\sites\blog\Posts\a.html`, "Posts/a.html", true  },
+    { windows, `C:\Sites\Blog`,       `C:/Sites/Blog/index.html`,   "index.html",   true  },
+    { windows, `C:\Sites\Blog`,       `D:\Sites\Blog\index.html`,   "",             false },
+    { windows, `C:\`,                 `C:\index.html`,              "index.html",   true  },
+    { windows, `\\server\share\site`, `\\SERVER\share\site\a.css`,  "a.css",        true  },
+    { windows, `\\server\share`,      `\\server\other\a.css`,       "",             false },
+    { windows, ".",                   `C:\index.html`,              "",             false },
+  }
+  for _, c := range within_cases {
+    key, within := c.style.pathKey(c.dir, c.path)
+    if key != c.expect || within != c.within {
+      t.Errorf(
+        "Expected the key of %q in %q (windows: %v) to be %q (within: %v), got %q (within: %v)",
+        c.path, c.dir, c.style.windows, c.expect, c.within, key, within,
+      )
+    }
+  }
+
+  var url_cases = []struct {
+    style   pathStyle
+    path    string
+    expect  string
+  } {
+    { unix,    "/srv/site",   "/srv/site" },
+    { unix,    "/C:/site",    "/C:/site" },
+    { windows, "/C:/site",    `C:\site` },
+    { windows, "../site",     `..\site` },
+  }
+  for _, c := range url_cases {
+    if got := c.style.fileUrlPath(c.path); got != c.expect {
+      t.Errorf("Expected the file URL path %q (windows: %v) to be %q, got %q", c.path, c.style.windows, c.expect, got)
+    }
+  }
+}
+
+
+func TestPathKey (t *testing.T) {
+  var dir = t.TempDir()
+
+  // A relative path is made absolute against the working
+  // directory to be compared to an absolute one
+  //
+  working_dir, err := os.Getwd()
+  if err != nil { t.Fatal(err) }
+
+  if key, err := PathKey(working_dir, filepath.Join("css", "site.css")); err != nil || key != "css/site.css" {
+    t.Errorf("Expected the key css/site.css, got %q (%v)", key, err)
+  }
+  if key, err := PathKey(dir, filepath.Join(dir, "a", "b.html")); err != nil || key != "a/b.html" {
+    t.Errorf("Expected the key a/b.html, got %q (%v)", key, err)
+  }
+  if PathWithin(dir, filepath.Dir(dir)) {
+    t.Errorf("Expected the parent of %s not to be within it", dir)
+  }
+
+  // Directory assets are keyed by slash-separated paths
+  //
+  var spec = NewSpec("site", nil)
+  spec.Props["source_dir"] = dir
+  if err := spec.WriteFile("/docs/guide/index.html", []byte("<p>Guide</p>"), 0o644); err != nil {
+    t.Fatal(err)
+  }
+
+  if key, err := spec.GetPathKey(filepath.Join(dir, "docs", "guide", "index.html")); err != nil || key != "docs/guide/index.html" {
+    t.Errorf("Expected the key docs/guide/index.html, got %q (%v)", key, err)
+  }
+
+  asset, err := spec.MakeFileKeyAsset("docs", "docs")
+  if err != nil { t.Fatal(err) }
+  assets, err := asset.Flatten()
+  if err != nil { t.Fatal(err) }
+  if len(assets) != 1 || strings.TrimLeft(assets[0].Url.Path, "/") != "docs/guide/index.html" {
+    t.Errorf("Expected the asset docs/guide/index.html, got %d assets, the first at %s", len(assets), assets[0].Url)
+  }
+}
@@ -0,0 +1,175 @@
+package interbuilder
+
+import (
+  "context"
+  "log/slog"
+  "strings"
+)
+
+
+/*
+  SetLogger sets the structured logger of this Spec and its
+  descendants which do not set their own. A Spec with a logger
+  logs a record for each of its Events, and logs the output of
+  its Tasks and their commands as records, with "spec" and "task"
+  attributes, rather than printing prefixed lines. The lines
+  Spec.Printf and Spec.Println print, which mostly describe the
+  same lifecycle as its Events, are logged at the debug level.
+*/
+func (s *Spec) SetLogger (logger *slog.Logger) {
+  s.logger = logger
+}
+
+
+/*
+  Logger returns the logger set by SetLogger on this Spec or its
+  nearest ancestor, or nil if there is none.
+*/
+func (s *Spec) Logger () *slog.Logger {
+  for spec := s; spec != nil; spec = spec.Parent {
+    if spec.logger != nil {
+      return spec.logger
+    }
+  }
+  return nil
+}
+
+
+/*
+  logLine logs a line printed by the Spec at the debug level,
+  without its "[name] " prefix, and writes it to the Spec's log
+  file.
+*/
+func (s *Spec) logLine (logger *slog.Logger, line string) {
+  s.writeLogFile([]byte(line))
+  line = strings.TrimRight(line, "\n")
+  line = strings.TrimPrefix(line, "[" + s.Name + "] ")
+  logger.Debug(line, "spec", s.SpecPath())
+}
+
+
+/*
+  logEvent logs an Event to the logger of its Spec, if it has
+  one. Asset events are logged at the debug level, and errors at
+  the error level.
+*/
+func (s *Spec) logEvent (e Event) {
+  var logger = s.Logger()
+  if logger == nil {
+    return
+  }
+
+  var level = slog.LevelInfo
+  var attrs = []slog.Attr { slog.String("spec", s.SpecPath()) }
+
+  if e.Task != nil {
+    attrs = append(attrs, slog.String("task", e.Task.Name))
+    if e.Task.ResolverId != "" {
+      attrs = append(attrs, slog.String("resolver", e.Task.ResolverId))
+    }
+  }
+  if e.Asset != nil {
+    level = slog.LevelDebug
+    if e.Asset.Url != nil {
+      attrs = append(attrs, slog.String("asset", e.Asset.Url.String()))
+    }
+  }
+  if e.Err != nil {
+    level = slog.LevelError
+    attrs = append(attrs, slog.String("error", e.Err.Error()))
+  }
+
+  logger.LogAttrs(context.Background(), level, e.Type.String(), attrs...)
+}
+
+
+/*
+  logWriter logs each Write, expected to be a line, such as from a
+  PrefixWriter, as a record, without escape sequences. The line is
+  also written to the log file of its Spec, if it has one,
+  preceded by a prefix.
+*/
+type logWriter struct {
+  logger  *slog.Logger
+  level   slog.Level
+  spec    *Spec
+  prefix  string
+}
+
+
+func (w logWriter) Write (p []byte) (int, error) {
+  p = StripANSI(p)
+  w.logger.Log(context.Background(), w.level, strings.TrimRight(string(p), "\n"))
+  if w.spec != nil {
+    w.spec.writeLogFile(append([]byte(w.prefix), p...))
+  }
+  return len(p), nil
+}
+
+
+/*
+  taskLogger returns the logger of this Task's Spec, with "spec"
+  and "task" attributes, or nil if it has none.
+*/
+func (t *Task) taskLogger () *slog.Logger {
+  if t.Spec == nil {
+    return nil
+  }
+  var logger = t.Spec.Logger()
+  if logger == nil {
+    return nil
+  }
+  return logger.With("spec", t.Spec.SpecPath(), "task", t.Name)
+}
+
+
+/*
+  CommandOutput returns a writer for the "stdout" or "stderr"
+  stream of one of this Task's commands. If the Spec has a
+  logger, each line is logged as a record with a "stream"
+  attribute. Otherwise, whole lines are written to the Spec's
+  Console, prefixed with "[spec/task] " for stdout, or
+  "{spec/task} " for stderr. Flush it after the command exits.
+*/
+func (t *Task) CommandOutput (stream string) *PrefixWriter {
+  var prefix = t.outputPrefix(stream)
+
+  if logger := t.taskLogger(); logger != nil {
+    return NewPrefixWriter(logWriter { logger.With("stream", stream), slog.LevelInfo, t.Spec, prefix }, "")
+  }
+
+  var console = NewConsole(nil, nil)
+  if t.Spec != nil {
+    console = t.Spec.Console()
+  }
+
+  var w = console.Stdout
+  if stream == "stderr" {
+    w = console.Stderr
+  }
+
+  return NewPrefixWriter(consoleLineWriter {
+    console:        console,
+    w:              w,
+    spec:           t.Spec,
+    prefix:         prefix,
+    colored_prefix: console.colorize(t.outputPrefix("stdout"), prefix),
+  }, "")
+}
+
+
+/*
+  outputPrefix returns the prefix of lines this Task prints to a
+  stream: "[spec/task] " for "stdout", or "{spec/task} " for
+  "stderr".
+*/
+func (t *Task) outputPrefix (stream string) string {
+  var spec_name = "<nil>"
+  if t.Spec != nil {
+    spec_name = t.Spec.Name
+  }
+  if stream == "stderr" {
+    return "{" + spec_name + "/" + t.Name + "} "
+  }
+  return "[" + spec_name + "/" + t.Name + "] "
+}
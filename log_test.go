@@ -0,0 +1,76 @@
+package interbuilder
+
+import (
+  "encoding/json"
+  "errors"
+  "log/slog"
+  "os/exec"
+  "strings"
+  "testing"
+)
+
+
+func TestSpecLogger (t *testing.T) {
+  if _, err := exec.LookPath("sh"); err != nil {
+    t.Skip("sh is not available")
+  }
+
+  var root    = NewSpec("root", nil)
+  var subspec = root.AddSubspec(NewSpec("sub", nil))
+
+  var output lockedBuffer
+  root.SetLogger(slog.New(slog.NewJSONHandler(&output, nil)))
+
+  subspec.EnqueueTaskFunc("print", func (s *Spec, tk *Task) error {
+    tk.Println("from the task")
+    _, err := tk.CommandRun("sh", "-c", "echo from stderr >&2")
+    return err
+  })
+  subspec.EnqueueTaskFunc("fail", func (s *Spec, tk *Task) error {
+    return errors.New("Failed on purpose")
+  })
+
+  if err := root.Run(); err == nil {
+    t.Fatal("Expected the run to fail")
+  }
+
+  var records []map[string]any
+  for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+    var record map[string]any
+    if err := json.Unmarshal([]byte(line), &record); err != nil {
+      t.Fatalf("Expected each line to be a JSON record, got %q: %v", line, err)
+    }
+    records = append(records, record)
+  }
+
+  var find = func (expect map[string]any) map[string]any {
+    for _, record := range records {
+      var matches = true
+      for key, value := range expect {
+        if record[key] != value {
+          matches = false
+          break
+        }
+      }
+      if matches {
+        return record
+      }
+    }
+    t.Errorf("Expected a record matching %v, got:\n%s", expect, output.String())
+    return nil
+  }
+
+  find(map[string]any { "msg": "spec-start", "spec": "root/sub", "level": "INFO" })
+  find(map[string]any { "msg": "task-start", "spec": "root/sub", "task": "print" })
+  find(map[string]any { "msg": "from the task", "spec": "root/sub", "task": "print" })
+  find(map[string]any { "msg": "from stderr", "task": "print", "stream": "stderr" })
+  find(map[string]any { "msg": "task-error", "task": "fail", "level": "ERROR" })
+
+  // Printed lifecycle lines are below the default level
+  //
+  for _, record := range records {
+    if msg, _ := record["msg"].(string); strings.Contains(msg, "Running") {
+      t.Errorf("Expected printed lines to be logged at the debug level, got %v", record)
+    }
+  }
+}
@@ -0,0 +1,170 @@
+package interbuilder
+
+import (
+  "errors"
+  "fmt"
+  "sort"
+)
+
+
+/*
+  VerifyMasks checks the Task queues of this Spec and its
+  subspecs, before they run, for Tasks whose Masks could not work,
+  and returns an error describing each of them. It is called by
+  RunContext on the root Spec, so that such a pipeline fails
+  before any of its Tasks run, rather than partway through. The
+  following are errors:
+
+    - A Task with neither a Func nor a MapFunc.
+    - A Task with a MapFunc whose Mask neither consumes Assets
+      nor modifies the queue, which would never receive any.
+    - A Task with a MapFunc but no Func whose Mask cannot emit
+      Assets, which could not pass on the Assets it maps.
+    - A Task with a MapFunc after a Task which consumes Assets but
+      cannot emit them, with no Task between them which can emit
+      Assets or modify the queue, which would never receive any.
+    - A Task whose Mask, with its TaskResolver's AcceptMask, is
+      not within the AcceptMask of an ancestor of its
+      TaskResolver, such as when a Mask is changed after the Task
+      is resolved.
+
+  Task queues of Specs which are not running are flushed and
+  sorted first, as RunContext would, so that Tasks are checked
+  against the neighbors they will run with.
+*/
+func (s *Spec) VerifyMasks () error {
+  var errs = s.verifyTaskQueueMasks()
+
+  var names = make([]string, 0, len(s.Subspecs))
+  for name := range s.Subspecs {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  for _, name := range names {
+    if err := s.Subspecs[name].VerifyMasks(); err != nil {
+      errs = append(errs, err)
+    }
+  }
+
+  return errors.Join(errs...)
+}
+
+
+/*
+  verifyTaskQueueMasks returns the errors of VerifyMasks for this
+  Spec's own Task queue.
+*/
+func (s *Spec) verifyTaskQueueMasks () []error {
+  s.task_queue_lock.Lock()
+  defer s.task_queue_lock.Unlock()
+
+  if !s.Running {
+    s.flushTaskPushQueue()
+    if err := s.sortTaskQueueUnsafe(nil); err != nil {
+      return []error { & SpecError { Spec: s.Name, Err: err } }
+    }
+  }
+
+  if t := s.Tasks.GetCircularTask(); t != nil {
+    return []error { & SpecError {
+      Spec: s.Name,
+      Err:  fmt.Errorf("Repeating (circular) task entry in task list: %s", t.ResolverId),
+    }}
+  }
+
+  var errs []error
+  var taskError = func (task *Task, format string, a ...any) {
+    errs = append(errs, & TaskError {
+      Spec:       s.Name,
+      Task:       task.Name,
+      ResolverId: task.ResolverId,
+      Err:        fmt.Errorf(format, a...),
+    })
+  }
+
+  var ancestors = s.taskResolverAncestors()
+
+  // sink is the last Task which consumes Assets without being
+  // able to pass any on
+  //
+  var sink *Task
+
+  for task := s.Tasks; task != nil; task = task.Next {
+    if task.Func == nil && task.MapFunc == nil {
+      taskError(task, "Task doesn't have a Func or MapFunc defined")
+    }
+
+    if task.MapFunc != nil && !task.IgnoreAssets {
+      switch {
+      case !TaskMaskContains(task.Mask, TASK_ASSETS_CONSUME) && !TaskMaskContains(task.Mask, TASK_TASKS_QUEUE):
+        taskError(task,
+          "Task has a MapFunc, but its mask (%s) does not consume assets, so it would never receive any",
+          task.Mask,
+        )
+      case task.Func == nil && !TaskMaskContains(task.Mask, TASK_ASSETS_EMIT):
+        taskError(task,
+          "Task has a MapFunc and no Func, but its mask (%s) cannot emit the assets it maps",
+          task.Mask,
+        )
+      case sink != nil:
+        taskError(task,
+          "Task has a MapFunc, but task %s before it consumes assets without emitting any (mask %s), so it would never receive any",
+          sink.Name, sink.Mask,
+        )
+      }
+    }
+
+    if task.Resolver != nil {
+      var test_mask = task.Mask | task.Resolver.AcceptMask
+      if test_mask == 0 {
+        test_mask = TASK_FIELDS
+      }
+      for _, ancestor := range ancestors[task.Resolver] {
+        var accept_mask = ancestor.AcceptMask | ancestor.TaskPrototype.Mask
+        if accept_mask != 0 && !TaskMaskValid(accept_mask, test_mask) {
+          taskError(task,
+            "Task mask of %s is not valid within the acceptance mask %s of resolver %s",
+            test_mask, accept_mask, ancestor.Id,
+          )
+        }
+      }
+    }
+
+    // Assets only continue past a Task which can emit them, or
+    // which can insert Tasks which do. A Task with a SkipFunc may
+    // be skipped, leaving its Assets to pass by.
+    //
+    switch {
+    case task.IgnoreAssets, task.SkipFunc != nil:
+    case TaskMaskContains(task.Mask, TASK_ASSETS_EMIT), TaskMaskContains(task.Mask, TASK_TASKS_QUEUE):
+      sink = nil
+    case TaskMaskContains(task.Mask, TASK_ASSETS_CONSUME):
+      sink = task
+    }
+  }
+
+  return errs
+}
+
+
+/*
+  taskResolverAncestors maps each TaskResolver available to this
+  Spec to its ancestors in the resolver tree, nearest first.
+*/
+func (s *Spec) taskResolverAncestors () map[*TaskResolver][]*TaskResolver {
+  var ancestors = make(map[*TaskResolver][]*TaskResolver)
+  var path []*TaskResolver
+
+  for _, info := range s.ListTaskResolvers() {
+    path = append(path[:info.Depth], info.Resolver)
+
+    var resolver_ancestors = make([]*TaskResolver, 0, info.Depth)
+    for i := info.Depth - 1; i >= 0; i-- {
+      resolver_ancestors = append(resolver_ancestors, path[i])
+    }
+    ancestors[info.Resolver] = resolver_ancestors
+  }
+
+  return ancestors
+}
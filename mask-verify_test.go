@@ -0,0 +1,147 @@
+package interbuilder
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+
+func TestSpecVerifyMasks (t *testing.T) {
+  var noop     = func (*Spec, *Task) error { return nil }
+  var identity = func (a *Asset) (*Asset, error) { return a, nil }
+
+  var test_cases = []struct {
+    Name    string
+    Tasks   []*Task
+    Expect  string
+  } {
+    {
+      Name:  "valid",
+      Tasks: []*Task {
+        { Name: "generate", Mask: NewTaskMask().Generate(), Func: noop },
+        { Name: "map",      Mask: NewTaskMask().Mutate(),   MapFunc: identity },
+        { Name: "deploy",   Mask: NewTaskMask().Consume(),  Func: noop },
+        { Name: "undefined",                                Func: noop },
+      },
+    },
+    {
+      Name:   "no-func",
+      Tasks:  []*Task { { Name: "empty" } },
+      Expect: "doesn't have a Func or MapFunc",
+    },
+    {
+      Name:   "map-without-consume",
+      Tasks:  []*Task { { Name: "map", Mask: NewTaskMask().Generate(), MapFunc: identity } },
+      Expect: "its mask (generate) does not consume assets",
+    },
+    {
+      Name:   "map-without-emit",
+      Tasks:  []*Task { { Name: "map", Mask: NewTaskMask().Consume(), MapFunc: identity } },
+      Expect: "its mask (consume) cannot emit",
+    },
+    {
+      Name:  "map-after-sink",
+      Tasks: []*Task {
+        { Name: "deploy", Mask: NewTaskMask().Consume(), Func: noop },
+        { Name: "map",    Mask: NewTaskMask().Mutate(),  MapFunc: identity },
+      },
+      Expect: "task deploy before it consumes assets without emitting any",
+    },
+    {
+      Name:  "map-after-sink-and-emitter",
+      Tasks: []*Task {
+        { Name: "deploy",   Mask: NewTaskMask().Consume(),  Func: noop },
+        { Name: "generate", Mask: NewTaskMask().Generate(), Func: noop },
+        { Name: "map",      Mask: NewTaskMask().Mutate(),   MapFunc: identity },
+      },
+    },
+  }
+
+  for _, test_case := range test_cases {
+    var root    = NewSpec("root", nil)
+    var subspec = root.AddSubspec(NewSpec("sub", nil))
+    root.Props["quiet"] = true
+
+    // The root's own tasks must not run if a subspec is invalid
+    //
+    var root_ran bool
+    root.EnqueueTaskFunc("root-task", func (*Spec, *Task) error {
+      root_ran = true
+      return nil
+    })
+
+    for _, task := range test_case.Tasks {
+      if err := subspec.EnqueueTask(task); err != nil {
+        t.Fatal(err)
+      }
+    }
+
+    var err = root.VerifyMasks()
+    if test_case.Expect == "" {
+      if err != nil {
+        t.Errorf("Test case %s: unexpected error: %v", test_case.Name, err)
+      }
+      continue
+    }
+
+    if err == nil || !strings.Contains(err.Error(), test_case.Expect) {
+      t.Errorf("Test case %s: expected an error containing %q, got: %v", test_case.Name, test_case.Expect, err)
+    }
+
+    var task_error *TaskError
+    if err != nil && test_case.Name != "no-func" && (!errors.As(err, &task_error) || task_error.Spec != "sub") {
+      t.Errorf("Test case %s: expected a TaskError in spec sub, got: %v", test_case.Name, err)
+    }
+
+    if err := root.Run(); err == nil {
+      t.Errorf("Test case %s: expected Run to fail", test_case.Name)
+    } else if root_ran {
+      t.Errorf("Test case %s: expected Run to fail before any task ran", test_case.Name)
+    }
+  }
+}
+
+
+func TestSpecVerifyMasksResolverAncestors (t *testing.T) {
+  var noop = func (*Spec, *Task) error { return nil }
+
+  var parent = & TaskResolver {
+    Id:          "generators",
+    NamePattern: "*",
+    AcceptMask:  TASK_ASSETS_GENERATE,
+  }
+  if err := parent.AddTaskResolver(& TaskResolver {
+    Id:            "generate",
+    Name:          "generate",
+    TaskPrototype: Task { Mask: TASK_ASSETS_GENERATE, Func: noop },
+  }); err != nil {
+    t.Fatal(err)
+  }
+
+  var spec = NewSpec("spec", nil)
+  spec.AddTaskResolver(parent)
+
+  task, err := spec.GetTask("generate", spec)
+  if err != nil {
+    t.Fatal(err)
+  } else if task == nil {
+    t.Fatal("Expected a task to be resolved")
+  }
+  if err := spec.EnqueueTask(task); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := spec.VerifyMasks(); err != nil {
+    t.Fatalf("Unexpected error: %v", err)
+  }
+
+  // Widening the mask after it is resolved escapes the
+  // acceptance mask of the resolver's parent
+  //
+  task.Mask = TASK_ASSETS_MUTATE
+  err = spec.VerifyMasks()
+  if err == nil || !strings.Contains(err.Error(), "acceptance mask generate of resolver generators") {
+    t.Errorf("Expected an error about the acceptance mask of the parent resolver, got: %v", err)
+  }
+}
@@ -0,0 +1,221 @@
+package interbuilder
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "sync/atomic"
+)
+
+
+/*
+  MemoryStats reports the Asset content held in memory by the
+  buffers of a Spec's Tasks. ContentBytes is the size of the
+  Assets' byte content, and ContentData an estimate of the size of
+  their content data, of which only byte slices, strings, and
+  values with a Len method are counted. Evicted is the number of
+  Assets whose byte content was released to keep a Spec tree
+  within its memory budget, and EvictedBytes their total size.
+  Assets forwarded from one Task to the next may briefly be
+  counted in both.
+*/
+type MemoryStats struct {
+  Assets        int64  `json:"assets"`
+  ContentBytes  int64  `json:"content_bytes"`
+  ContentData   int64  `json:"content_data"`
+  Evicted       int64  `json:"evicted"`
+  EvictedBytes  int64  `json:"evicted_bytes"`
+}
+
+
+/*
+  memoryCounters are the atomic counters behind a Spec's
+  MemoryStats. The root Spec's total counts the buffers of its
+  whole tree, against which its memory budget is compared.
+*/
+type memoryCounters struct {
+  assets         atomic.Int64
+  content_bytes  atomic.Int64
+  content_data   atomic.Int64
+  evicted        atomic.Int64
+  evicted_bytes  atomic.Int64
+  total          atomic.Int64
+}
+
+
+/*
+  ContentSize returns the size of a singular Asset's content held
+  in memory: the length of its ContentBytes, and an estimate of
+  the size of its ContentData. Content which is only available
+  from a reader function is not counted.
+*/
+func (a *Asset) ContentSize () (content_bytes, content_data int64) {
+  if !a.IsSingle() {
+    return 0, 0
+  }
+
+  content_bytes = int64(len(a.ContentBytes))
+
+  switch data := a.ContentData.(type) {
+  case []byte:
+    content_data = int64(len(data))
+  case string:
+    content_data = int64(len(data))
+  case interface { Len () int }:
+    content_data = int64(data.Len())
+  }
+
+  return content_bytes, content_data
+}
+
+
+/*
+  MemoryStats returns the Asset content held by this Spec's Task
+  buffers, and how much has been evicted from them.
+*/
+func (s *Spec) MemoryStats () MemoryStats {
+  return MemoryStats {
+    Assets:       s.memory.assets.Load(),
+    ContentBytes: s.memory.content_bytes.Load(),
+    ContentData:  s.memory.content_data.Load(),
+    Evicted:      s.memory.evicted.Load(),
+    EvictedBytes: s.memory.evicted_bytes.Load(),
+  }
+}
+
+
+/*
+  MemoryTotal returns the bytes of Asset content held by the Task
+  buffers of this Spec's whole tree.
+*/
+func (s *Spec) MemoryTotal () int64 {
+  var root = s.Root
+  if root == nil {
+    root = s
+  }
+  return root.memory.total.Load()
+}
+
+
+func (s *Spec) addMemory (assets, content_bytes, content_data int64) {
+  s.memory.assets.Add(assets)
+  s.memory.content_bytes.Add(content_bytes)
+  s.memory.content_data.Add(content_data)
+
+  var root = s.Root
+  if root == nil {
+    root = s
+  }
+  root.memory.total.Add(content_bytes + content_data)
+}
+
+
+/*
+  parseMemorySize parses a number of bytes, as an integer, or a
+  string with an optional unit, such as "512KB", "64MB", or
+  "1GiB". Decimal and binary units are both powers of 1024.
+*/
+func parseMemorySize (size_any any) (int64, bool) {
+  if size, ok := propInt(size_any); ok {
+    return int64(size), size >= 0
+  }
+
+  size_str, ok := size_any.(string)
+  if !ok {
+    return 0, false
+  }
+
+  size_str = strings.ToUpper(strings.TrimSpace(size_str))
+  size_str = strings.TrimSuffix(strings.TrimSuffix(size_str, "B"), "I")
+
+  var multiplier int64 = 1
+  for unit_i, unit := range []string { "K", "M", "G", "T" } {
+    if strings.HasSuffix(size_str, unit) {
+      size_str   = strings.TrimSpace(strings.TrimSuffix(size_str, unit))
+      multiplier = 1 << (10 * (unit_i + 1))
+      break
+    }
+  }
+
+  size, err := strconv.ParseFloat(size_str, 64)
+  if err != nil || size < 0 {
+    return 0, false
+  }
+  return int64(size * float64(multiplier)), true
+}
+
+
+/*
+  memoryBudget returns the bytes of Asset content this Spec's tree
+  may hold in Task buffers, from the inherited "memory_budget"
+  prop. Zero means there is no budget.
+*/
+func (s *Spec) memoryBudget () (int64, error) {
+  budget_any, found := s.InheritProp("memory_budget")
+  if !found {
+    return 0, nil
+  }
+
+  budget, ok := parseMemorySize(budget_any)
+  if !ok {
+    return 0, fmt.Errorf(
+      "Prop \"memory_budget\" in Spec %s is expected to be a number of bytes, or a size such as \"64MB\", got %v",
+      s.Name, budget_any,
+    )
+  }
+  return budget, nil
+}
+
+
+/*
+  collectAssetMemory evicts the byte content of Assets in this
+  Task's buffer, oldest first, while its Spec tree holds more than
+  its memory budget. Evicted content is dropped if it can be read
+  again from its source, or otherwise staged to disk, and read
+  back through a reader function when it is next needed. Assets
+  with content data are not evicted. Only the buffering Task's
+  own Assets are evicted, as those of other Tasks may be in use.
+  The caller holds the Task's Asset lock.
+*/
+func (tk *Task) collectAssetMemory (budget int64) error {
+  var s = tk.Spec
+
+  for _, asset := range tk.Assets {
+    if s.MemoryTotal() <= budget {
+      return nil
+    }
+    if asset.ContentBytes == nil || asset.ContentData != nil {
+      continue
+    }
+
+    var size = int64(len(asset.ContentBytes))
+    staged, err := s.stageAsset(asset)
+    if err != nil {
+      return err
+    } else if !staged {
+      continue
+    }
+
+    tk.held_bytes -= size
+    s.addMemory(0, -size, 0)
+    s.memory.evicted.Add(1)
+    s.memory.evicted_bytes.Add(size)
+  }
+
+  return nil
+}
+
+
+/*
+  releaseAssetMemory removes this Task's buffered Assets from its
+  Spec's memory accounting, once the buffer is released.
+*/
+func (tk *Task) releaseAssetMemory () {
+  if tk.Spec == nil || tk.held_assets == 0 {
+    return
+  }
+  tk.Spec.addMemory(-tk.held_assets, -tk.held_bytes, -tk.held_data)
+  tk.held_assets = 0
+  tk.held_bytes  = 0
+  tk.held_data   = 0
+}
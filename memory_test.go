@@ -0,0 +1,101 @@
+package interbuilder
+
+import (
+  "bytes"
+  "fmt"
+  "testing"
+)
+
+
+func TestMemoryBudgetEviction (t *testing.T) {
+  var root = NewSpec("root", nil)
+  root.Props["quiet"] = true
+  root.Props["memory_budget"] = "250B"
+
+  const num_assets = 5
+
+  var contentOf = func (i int) []byte {
+    return bytes.Repeat([]byte { byte('a' + i) }, 100)
+  }
+
+  root.EnqueueTaskFunc("produce", func (s *Spec, tk *Task) error {
+    for i := 0 ; i < num_assets ; i++ {
+      var asset = s.MakeAsset(fmt.Sprintf("%d.txt", i))
+      if err := asset.SetContentBytes(contentOf(i)); err != nil {
+        return err
+      }
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    // The oldest assets are evicted to stay within the budget
+    //
+    var stats = s.MemoryStats()
+    if stats.Assets != num_assets || stats.ContentBytes != 200 || stats.Evicted != 3 || stats.EvictedBytes != 300 {
+      return fmt.Errorf("Expected 200 bytes held and 3 assets evicted, got %+v", stats)
+    }
+    if total := s.MemoryTotal(); total != 200 {
+      return fmt.Errorf("Expected the tree to hold 200 bytes, got %d", total)
+    }
+
+    for i, asset := range tk.Assets {
+      if in_memory, expect := asset.ContentBytes != nil, i >= 3; in_memory != expect {
+        return fmt.Errorf("Expected asset %d to be in memory: %t, got %t", i, expect, in_memory)
+      }
+
+      // Evicted content is read back
+      //
+      content, err := asset.GetContentBytes()
+      if err != nil {
+        return err
+      }
+      if !bytes.Equal(content, contentOf(i)) {
+        return fmt.Errorf("Expected asset %d content %q, got %q", i, contentOf(i), content)
+      }
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  // Released buffers are no longer counted
+  //
+  if stats := root.MemoryStats(); stats.Assets != 0 || stats.ContentBytes != 0 || stats.Evicted != 3 {
+    t.Errorf("Expected no assets to be held after running, got %+v", stats)
+  }
+  if total := root.MemoryTotal(); total != 0 {
+    t.Errorf("Expected the tree to hold no bytes after running, got %d", total)
+  }
+}
+
+
+func TestParseMemorySize (t *testing.T) {
+  for input, expect := range map[any]int64 {
+    1024:       1024,
+    float64(8): 8,
+    "512":      512,
+    "2KB":      2048,
+    "1.5 MiB":  3 << 19,
+    "1g":       1 << 30,
+  } {
+    if got, ok := parseMemorySize(input); !ok || got != expect {
+      t.Errorf("Expected %v to be %d bytes, got %d (%t)", input, expect, got, ok)
+    }
+  }
+
+  for _, input := range []any { "lots", -1, "-5MB", true } {
+    if _, ok := parseMemorySize(input); ok {
+      t.Errorf("Expected %v not to be a memory size", input)
+    }
+  }
+
+  var spec = NewSpec("spec", nil)
+  spec.Props["memory_budget"] = "lots"
+  if _, err := spec.memoryBudget(); err == nil {
+    t.Errorf("Expected an invalid memory_budget to be an error")
+  }
+}
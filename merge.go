@@ -0,0 +1,228 @@
+package interbuilder
+
+import (
+  "fmt"
+  "sync"
+)
+
+
+const (
+  MERGE_ERROR      = "error"
+  MERGE_FIRST_WINS = "first-wins"
+  MERGE_LAST_WINS  = "last-wins"
+  MERGE_PRIORITY   = "priority"
+)
+
+
+/*
+  A MergeConflict is a path which more than one subspec of a Spec
+  output an Asset at, and which of their Assets was kept.
+*/
+type MergeConflict struct {
+  Path     string  `json:"path"`
+  Kept     string  `json:"kept"`
+  Dropped  string  `json:"dropped"`
+}
+
+
+/*
+  mergeState is the conflict policy of a running Spec, and the
+  subspec whose Asset it keeps at each path.
+*/
+type mergeState struct {
+  policy      string
+  priority    map[string]int
+
+  lock        sync.Mutex
+  owners      map[string]mergeOwner
+  overridden  map[*Asset]bool
+  conflicts   []MergeConflict
+}
+
+
+type mergeOwner struct {
+  spec   *Spec
+  asset  *Asset
+}
+
+
+/*
+  startMerge reads this Spec's "merge_conflicts" prop, which sets
+  what happens when more than one of its subspecs outputs an Asset
+  at the same path:
+
+    - "error":      The Spec fails.
+    - "first-wins": The first Asset output is kept.
+    - "last-wins":  The last Asset output is kept.
+    - "priority":   The Asset of the subspec listed first in the
+                    "merge_priority" prop is kept. Subspecs which
+                    are not listed rank below those which are, and
+                    between them, the first Asset is kept.
+
+  Without the prop, every Asset is received. The prop is not
+  inherited, as it applies to the Spec's own subspecs. It must be
+  called before the subspecs run.
+*/
+func (s *Spec) startMerge () error {
+  s.merge = nil
+
+  policy, ok, found := s.GetPropString("merge_conflicts")
+  if !found {
+    return nil
+  }
+
+  switch policy {
+  case MERGE_ERROR, MERGE_FIRST_WINS, MERGE_LAST_WINS, MERGE_PRIORITY:
+  default:
+    ok = false
+  }
+  if !ok {
+    return fmt.Errorf(
+      "Prop \"merge_conflicts\" in Spec %s is expected to be \"%s\", \"%s\", \"%s\", or \"%s\", got %v",
+      s.Name, MERGE_ERROR, MERGE_FIRST_WINS, MERGE_LAST_WINS, MERGE_PRIORITY, s.Props["merge_conflicts"],
+    )
+  }
+
+  var merge = & mergeState {
+    policy:     policy,
+    priority:   make(map[string]int),
+    owners:     make(map[string]mergeOwner),
+    overridden: make(map[*Asset]bool),
+  }
+
+  if priority_any, found := s.Props["merge_priority"]; found {
+    var names, ok = priority_any.([]any)
+    if !ok {
+      return fmt.Errorf("Prop \"merge_priority\" in Spec %s is expected to be an array of subspec names, got %v", s.Name, priority_any)
+    }
+    for rank, name_any := range names {
+      name, ok := name_any.(string)
+      if !ok {
+        return fmt.Errorf("Prop \"merge_priority\" in Spec %s is expected to be an array of subspec names, got %v", s.Name, priority_any)
+      } else if _, found := s.Subspecs[name]; !found {
+        return fmt.Errorf("Prop \"merge_priority\" in Spec %s names \"%s\", which is not one of its subspecs", s.Name, name)
+      }
+      merge.priority[name] = len(names) - rank
+    }
+  }
+
+  s.merge = merge
+  return nil
+}
+
+
+/*
+  mergeInputAsset applies this Spec's conflict policy to an Asset
+  its subspec is outputting to it, and returns whether the Asset
+  should be sent. An Asset which overrides one already sent is
+  sent after it, so that outputs written in order keep it, and the
+  overridden Asset is left out of pooled and ordered input.
+*/
+func (s *Spec) mergeInputAsset (from *Spec, a *Asset) (bool, error) {
+  var merge = s.merge
+  if merge == nil || a.Url == nil || !a.IsSingle() {
+    return true, nil
+  }
+
+  var key = framePath(a.Url.Path)
+
+  merge.lock.Lock()
+  defer merge.lock.Unlock()
+
+  owner, found := merge.owners[key]
+  if !found || owner.spec == from {
+    merge.owners[key] = mergeOwner { from, a }
+    return true, nil
+  }
+
+  var keep bool
+  switch merge.policy {
+  case MERGE_ERROR:
+    return false, & MergeConflictError {
+      Spec:     s.Name,
+      Path:     key,
+      Subspecs: []string { owner.spec.Name, from.Name },
+    }
+  case MERGE_LAST_WINS:
+    keep = true
+  case MERGE_PRIORITY:
+    keep = merge.priority[from.Name] > merge.priority[owner.spec.Name]
+  }
+
+  var conflict = MergeConflict { Path: key, Kept: owner.spec.Name, Dropped: from.Name }
+  if keep {
+    conflict.Kept, conflict.Dropped = from.Name, owner.spec.Name
+    merge.overridden[owner.asset] = true
+    merge.owners[key] = mergeOwner { from, a }
+  }
+  merge.conflicts = append(merge.conflicts, conflict)
+
+  s.Printf(
+    "[%s] Merge conflict at %s, keeping the asset of %s over %s (%s)\n",
+    s.Name, key, conflict.Kept, conflict.Dropped, merge.policy,
+  )
+  return keep, nil
+}
+
+
+/*
+  mergeOverridden returns whether an Asset this Spec received was
+  overridden by another subspec's Asset at the same path.
+*/
+func (s *Spec) mergeOverridden (a *Asset) bool {
+  var merge = s.merge
+  if merge == nil {
+    return false
+  }
+
+  merge.lock.Lock()
+  defer merge.lock.Unlock()
+  return merge.overridden[a]
+}
+
+
+/*
+  MergeConflicts returns the conflicts between the Assets of this
+  Spec's subspecs in its current or last run, in the order they
+  occurred, if it has a "merge_conflicts" policy.
+*/
+func (s *Spec) MergeConflicts () []MergeConflict {
+  var merge = s.merge
+  if merge == nil {
+    return nil
+  }
+
+  merge.lock.Lock()
+  defer merge.lock.Unlock()
+  return append([]MergeConflict(nil), merge.conflicts...)
+}
+
+
+/*
+  dropOverriddenInput removes the Assets pooled from this Task's
+  Spec's Input which were overridden by a later Asset at the same
+  path.
+*/
+func (tk *Task) dropOverriddenInput () {
+  if tk.Spec == nil || tk.Spec.merge == nil {
+    return
+  }
+
+  tk.lockAssets()
+  defer tk.unlockAssets()
+
+  var kept = tk.Assets[:0]
+  for _, asset := range tk.Assets {
+    if !tk.Spec.mergeOverridden(asset) {
+      kept = append(kept, asset)
+      continue
+    }
+
+    content_bytes, content_data := asset.ContentSize()
+    tk.held_assets -= 1
+    tk.held_bytes  -= content_bytes
+    tk.held_data   -= content_data
+    tk.Spec.addMemory(-1, -content_bytes, -content_data)
+  }
+  tk.Assets = kept
+}
@@ -0,0 +1,134 @@
+package interbuilder
+
+import (
+  "errors"
+  "sort"
+  "strings"
+  "testing"
+)
+
+
+func TestMergeConflicts (t *testing.T) {
+  // Subspecs a, b, and c each output page.html and then a file
+  // of their own, and each waits for the file of the one before
+  // it, so that their page.html Assets arrive in order
+  //
+  var run = func (props map[string]any) (*Spec, []string, error) {
+    var root = NewSpec("root", nil)
+    root.Props["quiet"] = true
+    for key, value := range props {
+      root.Props[key] = value
+    }
+
+    var previous *Spec
+    for _, name := range []string { "a", "b", "c" } {
+      var subspec = root.AddSubspec(NewSpec(name, nil))
+      var after   = previous
+      previous    = subspec
+
+      subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+        if after != nil {
+          if _, err := after.Frame().WaitForKey(tk.Context(), after.Name + ".html"); err != nil {
+            return err
+          }
+        }
+        for _, key := range []string { "page.html", name + ".html" } {
+          asset := s.MakeAsset(key)
+          asset.SetContentBytes([]byte(name))
+          if err := tk.EmitAsset(asset); err != nil {
+            return err
+          }
+        }
+        return nil
+      })
+    }
+
+    var received []string
+    root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+      if err := tk.PoolSpecInputAssets(); err != nil {
+        return err
+      }
+      for _, chunk := range tk.Assets {
+        assets, err := chunk.Flatten()
+        if err != nil { return err }
+        for _, asset := range assets {
+          content, _ := asset.GetContentBytes()
+          received = append(received, strings.TrimPrefix(asset.Url.Path, "@emit/") + "=" + string(content))
+        }
+      }
+      sort.Strings(received)
+      return nil
+    })
+
+    return root, received, root.Run()
+  }
+
+  for _, test_case := range []struct {
+    props      map[string]any
+    expect     string
+    conflicts  string
+  } {
+    {
+      props:  nil,
+      expect: "a.html=a b.html=b c.html=c page.html=a page.html=b page.html=c",
+    },
+    {
+      props:     map[string]any { "merge_conflicts": "first-wins" },
+      expect:    "a.html=a b.html=b c.html=c page.html=a",
+      conflicts: "[{/page.html a b} {/page.html a c}]",
+    },
+    {
+      props:     map[string]any { "merge_conflicts": "last-wins" },
+      expect:    "a.html=a b.html=b c.html=c page.html=c",
+      conflicts: "[{/page.html b a} {/page.html c b}]",
+    },
+    {
+      props:     map[string]any { "merge_conflicts": "priority", "merge_priority": []any { "b" } },
+      expect:    "a.html=a b.html=b c.html=c page.html=b",
+      conflicts: "[{/page.html b a} {/page.html b c}]",
+    },
+    {
+      props:     map[string]any { "merge_conflicts": "priority", "merge_priority": []any { "c", "b" }, "deterministic": "url" },
+      expect:    "a.html=a b.html=b c.html=c page.html=c",
+      conflicts: "[{/page.html b a} {/page.html c b}]",
+    },
+  } {
+    root, received, err := run(test_case.props)
+    if err != nil {
+      t.Errorf("Props %v: unexpected error: %v", test_case.props, err)
+      continue
+    }
+    if got := strings.Join(received, " "); got != test_case.expect {
+      t.Errorf("Props %v: expected %s, got %s", test_case.props, test_case.expect, got)
+    }
+    if test_case.conflicts == "" {
+      continue
+    }
+
+    var conflicts []string
+    for _, conflict := range root.MergeConflicts() {
+      conflicts = append(conflicts, "{" + conflict.Path + " " + conflict.Kept + " " + conflict.Dropped + "}")
+    }
+    if got := "[" + strings.Join(conflicts, " ") + "]"; got != test_case.conflicts {
+      t.Errorf("Props %v: expected conflicts %s, got %s", test_case.props, test_case.conflicts, got)
+    }
+  }
+
+  _, _, err := run(map[string]any { "merge_conflicts": "error" })
+  var conflict_error *MergeConflictError
+  if !errors.Is(err, ErrMergeConflict) || !errors.As(err, &conflict_error) {
+    t.Errorf("Expected a merge conflict error, got: %v", err)
+  } else if conflict_error.Path != "/page.html" || strings.Join(conflict_error.Subspecs, ",") != "a,b" {
+    t.Errorf("Expected a conflict between a and b at /page.html, got: %v", conflict_error)
+  }
+
+  for _, props := range []map[string]any {
+    { "merge_conflicts": "newest" },
+    { "merge_conflicts": "priority", "merge_priority": []any { "d" } },
+    { "merge_conflicts": "priority", "merge_priority": "a" },
+  } {
+    if _, _, err := run(props); err == nil || !strings.Contains(err.Error(), "Prop \"merge_") {
+      t.Errorf("Props %v: expected a prop error, got: %v", props, err)
+    }
+  }
+}
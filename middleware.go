@@ -0,0 +1,42 @@
+package interbuilder
+
+
+/*
+  An EmitMiddleware wraps Spec.EmitAsset. It receives each Asset
+  the Spec emits and the next step of emission, which it may call
+  with the same Asset, a modified one, or not at all to drop the
+  Asset. Middleware can implement concerns such as validation,
+  metrics, or content stamping without adding Tasks to each
+  queue.
+*/
+type EmitMiddleware func (a *Asset, next func (*Asset) error) error
+
+
+/*
+  UseEmitMiddleware adds middleware to this Spec's EmitAsset.
+  Middleware is applied in the order it is added, with the first
+  receiving Assets first, and runs before path transformations.
+  It is not inherited by subspecs. Middleware may be called
+  concurrently when Tasks emit concurrently.
+*/
+func (s *Spec) UseEmitMiddleware (middleware EmitMiddleware) {
+  s.emit_middleware_lock.Lock()
+  defer s.emit_middleware_lock.Unlock()
+
+  s.emit_middleware = append(s.emit_middleware, middleware)
+
+  // Rebuild the chain from the innermost step, emitAsset, out to
+  // the first middleware
+  //
+  var chain func (*Asset) error = s.emitAsset
+
+  for i := len(s.emit_middleware) - 1 ; i >= 0 ; i-- {
+    var m    = s.emit_middleware[i]
+    var next = chain
+    chain = func (a *Asset) error {
+      return m(a, next)
+    }
+  }
+
+  s.emit_chain = chain
+}
@@ -0,0 +1,60 @@
+package interbuilder
+
+import (
+  "testing"
+  "fmt"
+  "strings"
+)
+
+
+func TestSpecEmitMiddleware (t *testing.T) {
+  var spec   = NewSpec("spec", nil)
+  var output = make(chan *Asset, 4)
+  spec.AddOutput(&output, nil)
+
+  var calls []string
+
+  // The first middleware is applied first, and stamps assets
+  //
+  spec.UseEmitMiddleware(func (a *Asset, next func (*Asset) error) error {
+    calls = append(calls, "stamp")
+    a.Mimetype = "text/plain"
+    return next(a)
+  })
+
+  // The second drops some assets, and rejects others
+  //
+  spec.UseEmitMiddleware(func (a *Asset, next func (*Asset) error) error {
+    calls = append(calls, "validate")
+    switch a.Url.Path {
+      case "drop":
+        return nil
+      case "invalid":
+        return fmt.Errorf("Invalid asset")
+    }
+    return next(a)
+  })
+
+  for _, key := range []string { "keep", "drop" } {
+    if err := spec.EmitAsset(spec.MakeAsset(key)); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if err := spec.EmitAsset(spec.MakeAsset("invalid")); err == nil {
+    t.Errorf("Expected an error from middleware")
+  }
+
+  if got, expect := strings.Join(calls, " "), "stamp validate stamp validate stamp validate"; got != expect {
+    t.Errorf("Expected middleware calls %s, got %s", expect, got)
+  }
+
+  if got := len(output); got != 1 {
+    t.Fatalf("Expected 1 asset to be output, got %d", got)
+  }
+
+  asset := <-output
+  if asset.Url.Path != "@emit/keep" || asset.Mimetype != "text/plain" {
+    t.Errorf("Expected a stamped asset at @emit/keep, got %s with MIME type %q", asset.Url.Path, asset.Mimetype)
+  }
+}
@@ -1,20 +1,74 @@
 package interbuilder
 
 import (
-  "bufio"
+  "bytes"
   "io"
+  "sync"
 )
 
 
-func StreamPrefix (r io.ReadCloser, w io.WriteCloser, prefix string) {
-  prefix_bytes := []byte(prefix)
-  go func () {
-    scanner := bufio.NewScanner(r)
-    for scanner.Scan() {
-      line := append(prefix_bytes, []byte(scanner.Text() + "\n")...)
-      w.Write(line)
+/*
+  A PrefixWriter writes each line written to it to another
+  writer, preceded by a prefix. Incomplete lines are held until
+  they are completed, or until Flush is called, so that each line
+  is written whole. It can be used as the output of an exec.Cmd,
+  so that its output has been written when the command returns.
+*/
+type PrefixWriter struct {
+  W       io.Writer
+  Prefix  string
+
+  lock     sync.Mutex
+  partial  []byte
+}
+
+
+func NewPrefixWriter (w io.Writer, prefix string) *PrefixWriter {
+  return & PrefixWriter { W: w, Prefix: prefix }
+}
+
+
+func (pw *PrefixWriter) Write (p []byte) (int, error) {
+  pw.lock.Lock()
+  defer pw.lock.Unlock()
+
+  var n = len(p)
+  for len(p) > 0 {
+    newline := bytes.IndexByte(p, '\n')
+    if newline < 0 {
+      pw.partial = append(pw.partial, p...)
+      break
     }
-  }()
+
+    line := make([]byte, 0, len(pw.Prefix) + len(pw.partial) + newline + 1)
+    line  = append(line, pw.Prefix...)
+    line  = append(line, pw.partial...)
+    line  = append(line, p[:newline + 1]...)
+    pw.partial = pw.partial[:0]
+    p = p[newline + 1:]
+
+    if _, err := pw.W.Write(line); err != nil {
+      return n - len(p), err
+    }
+  }
+  return n, nil
+}
+
+
+/*
+  Flush writes an incomplete last line, followed by a newline.
+*/
+func (pw *PrefixWriter) Flush () error {
+  pw.lock.Lock()
+  defer pw.lock.Unlock()
+
+  if len(pw.partial) == 0 {
+    return nil
+  }
+  line := append([]byte(pw.Prefix), pw.partial...)
+  pw.partial = pw.partial[:0]
+  _, err := pw.W.Write(append(line, '\n'))
+  return err
 }
 
 
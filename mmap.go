@@ -0,0 +1,220 @@
+package interbuilder
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "os"
+  "runtime"
+  "runtime/debug"
+)
+
+
+/*
+  MMAP_THRESHOLD is the size, in bytes, from which the content of
+  file assets is read by memory-mapping their files, unless the
+  "mmap_threshold" prop is set.
+*/
+const MMAP_THRESHOLD = 16 << 20
+
+
+/*
+  mmapThreshold returns the size from which this Spec's file
+  assets are memory-mapped, from the inherited "mmap_threshold"
+  prop, a number of bytes. A threshold of zero or less disables
+  memory-mapping.
+*/
+func (s *Spec) mmapThreshold () (int64, error) {
+  threshold_any, found := s.InheritProp("mmap_threshold")
+  if !found {
+    return MMAP_THRESHOLD, nil
+  }
+
+  switch value := threshold_any.(type) {
+    case float64:
+      return int64(value), nil
+    case int:
+      return int64(value), nil
+    case int64:
+      return value, nil
+  }
+  return 0, fmt.Errorf(
+    "Prop \"mmap_threshold\" in spec %s is expected to be a number of bytes, got %T",
+    s.Name, threshold_any,
+  )
+}
+
+
+/*
+  openFileReader opens a file for reading its content. Files at
+  least threshold bytes in size are memory-mapped, if threshold is
+  positive, and read through a mappedReader, so that their content
+  is not copied through read buffers. If the file cannot be
+  mapped, such as on platforms or filesystems which do not support
+  it, it is read as a regular file instead.
+*/
+func openFileReader (file_path string, threshold int64) (io.Reader, error) {
+  file, err := os.Open(file_path)
+  if err != nil { return nil, err }
+
+  if threshold <= 0 {
+    return file, nil
+  }
+
+  info, err := file.Stat()
+  if err != nil {
+    file.Close()
+    return nil, err
+  }
+  if !info.Mode().IsRegular() || info.Size() < threshold {
+    return file, nil
+  }
+
+  data, err := mmapFile(file, info.Size())
+  if err != nil {
+    return file, nil
+  }
+
+  // The mapping remains valid after the file is closed
+  //
+  file.Close()
+  return newMappedReader(file_path, data), nil
+}
+
+
+/*
+  mappedReader reads the content of a memory-mapped file. It is an
+  io.ReadSeekCloser, an io.ReaderAt, and an io.WriterTo, so that
+  io.Copy writes from the mapping without an intermediate buffer.
+  If the file is truncated while it is mapped, reading the missing
+  pages faults, which is returned as an error rather than crashing
+  the process. Close unmaps the file; mappings which are not closed
+  are unmapped once the reader is garbage collected.
+*/
+type mappedReader struct {
+  file_path  string
+  data       []byte
+  reader     *bytes.Reader
+}
+
+
+func newMappedReader (file_path string, data []byte) *mappedReader {
+  var r = & mappedReader {
+    file_path: file_path,
+    data:      data,
+    reader:    bytes.NewReader(data),
+  }
+  runtime.SetFinalizer(r, (*mappedReader).Close)
+  return r
+}
+
+
+/*
+  recoverFault recovers from a memory fault while reading the
+  mapping, setting err to describe it. Other panics are not
+  recovered.
+*/
+func (r *mappedReader) recoverFault (err *error) {
+  recovered := recover()
+  if recovered == nil {
+    return
+  }
+  if _, is_fault := recovered.(interface { Addr () uintptr }); !is_fault {
+    panic(recovered)
+  }
+  *err = fmt.Errorf("Error reading memory-mapped file %s, which may have been truncated: %v", r.file_path, recovered)
+}
+
+
+func (r *mappedReader) Read (p []byte) (n int, err error) {
+  if r.data == nil {
+    return 0, os.ErrClosed
+  }
+  defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+  defer r.recoverFault(&err)
+  return r.reader.Read(p)
+}
+
+
+func (r *mappedReader) ReadAt (p []byte, offset int64) (n int, err error) {
+  if r.data == nil {
+    return 0, os.ErrClosed
+  }
+  defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+  defer r.recoverFault(&err)
+  return r.reader.ReadAt(p, offset)
+}
+
+
+func (r *mappedReader) WriteTo (w io.Writer) (n int64, err error) {
+  if r.data == nil {
+    return 0, os.ErrClosed
+  }
+  defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+  defer r.recoverFault(&err)
+  return r.reader.WriteTo(w)
+}
+
+
+func (r *mappedReader) Seek (offset int64, whence int) (int64, error) {
+  if r.data == nil {
+    return 0, os.ErrClosed
+  }
+  return r.reader.Seek(offset, whence)
+}
+
+
+/*
+  Len returns the number of bytes remaining to be read.
+*/
+func (r *mappedReader) Len () int {
+  if r.data == nil {
+    return 0
+  }
+  return r.reader.Len()
+}
+
+
+func (r *mappedReader) Close () error {
+  if r.data == nil {
+    return nil
+  }
+  var data = r.data
+  r.data   = nil
+  r.reader = nil
+  runtime.SetFinalizer(r, nil)
+  return munmapFile(data)
+}
+
+
+/*
+  readAllContent reads the content of a reader, as in io.ReadAll.
+  Readers which report their remaining length, such as
+  bytes.Reader and mappedReader, are read into a single
+  allocation of that length, rather than through growing buffers.
+
+  The content of a mappedReader is still copied to the heap, since
+  Asset.GetContentBytes keeps it in ContentBytes, which outlives
+  the mapping and may be modified, while the mapping is read-only.
+  Memory-mapping only avoids copies for consumers which stream
+  content from Asset.ContentBytesGetReader, such as with io.Copy;
+  see BenchmarkFileAssetContent.
+*/
+func readAllContent (reader io.Reader) ([]byte, error) {
+  sized, ok := reader.(interface { Len () int })
+  if !ok {
+    return io.ReadAll(reader)
+  }
+
+  var content = make([]byte, sized.Len())
+  if _, err := io.ReadFull(reader, content); err != nil {
+    return nil, err
+  }
+
+  // Read anything past the reported length, such as from a
+  // reader which grew
+  //
+  rest, err := io.ReadAll(reader)
+  if err != nil { return nil, err }
+  return append(content, rest...), nil
+}
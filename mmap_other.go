@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package interbuilder
+
+import (
+  "errors"
+  "os"
+)
+
+
+/*
+  mmapFile is only supported on Unix-like platforms. Elsewhere, it
+  returns an error, so that files are read instead.
+*/
+func mmapFile (file *os.File, size int64) ([]byte, error) {
+  return nil, errors.New("Memory-mapping files is not supported on this platform")
+}
+
+
+func munmapFile (data []byte) error {
+  return nil
+}
@@ -0,0 +1,184 @@
+package interbuilder
+
+import (
+  "bytes"
+  "crypto/sha256"
+  "hash/crc32"
+  "io"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+
+func TestSpecMakeFileKeyAssetMmap (t *testing.T) {
+  var source_dir = t.TempDir()
+  var content    = bytes.Repeat([]byte("0123456789abcdef"), 1024)
+
+  root := NewSpec("root", nil)
+  root.Props["source_dir"]     = source_dir
+  root.Props["mmap_threshold"] = 1024
+
+  if err := root.WriteFile("large.bin", content, 0o644); err != nil {
+    t.Fatal(err)
+  }
+  if err := root.WriteFile("small.txt", []byte("small"), 0o644); err != nil {
+    t.Fatal(err)
+  }
+
+  large, err := root.MakeFileKeyAsset(filepath.Join(source_dir, "large.bin"))
+  if err != nil { t.Fatal(err) }
+
+  reader, err := large.ContentBytesGetReader()
+  if err != nil { t.Fatal(err) }
+  mapped, is_mapped := reader.(*mappedReader)
+  if !is_mapped {
+    t.Skipf("Expected a memory-mapped reader, got %T; memory-mapping may be unsupported", reader)
+  }
+
+  // Hashing with io.Copy writes from the mapping
+  //
+  var hash = sha256.New()
+  if _, err := io.Copy(hash, mapped); err != nil {
+    t.Fatal(err)
+  }
+  if expect := sha256.Sum256(content); !bytes.Equal(hash.Sum(nil), expect[:]) {
+    t.Errorf("Expected the hash of the mapped content to match the file's")
+  }
+
+  if err := mapped.Close(); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := mapped.Read(make([]byte, 1)); err == nil {
+    t.Errorf("Expected an error reading a closed mapped reader")
+  }
+
+  got, err := large.GetContentBytes()
+  if err != nil {
+    t.Fatal(err)
+  } else if !bytes.Equal(got, content) {
+    t.Errorf("Expected the content of large.bin, got %d bytes", len(got))
+  }
+
+  small, err := root.MakeFileKeyAsset(filepath.Join(source_dir, "small.txt"))
+  if err != nil { t.Fatal(err) }
+  reader, err = small.ContentBytesGetReader()
+  if err != nil { t.Fatal(err) }
+  if file, is_file := reader.(*os.File); !is_file {
+    t.Errorf("Expected a file below the threshold to be read as a file, got %T", reader)
+  } else {
+    file.Close()
+  }
+
+  // A threshold of zero disables memory-mapping
+  //
+  root.Props["mmap_threshold"] = 0
+  unmapped, err := root.MakeFileKeyAsset(filepath.Join(source_dir, "large.bin"))
+  if err != nil { t.Fatal(err) }
+  reader, err = unmapped.ContentBytesGetReader()
+  if err != nil { t.Fatal(err) }
+  if closer, ok := reader.(io.Closer); ok {
+    closer.Close()
+  }
+  if _, is_mapped := reader.(*mappedReader); is_mapped {
+    t.Errorf("Expected a threshold of zero not to memory-map files")
+  }
+
+  root.Props["mmap_threshold"] = "large"
+  if _, err := root.MakeFileKeyAsset(filepath.Join(source_dir, "large.bin")); err == nil {
+    t.Errorf("Expected an error from a string mmap_threshold prop")
+  }
+}
+
+
+func TestMappedReaderTruncated (t *testing.T) {
+  var file_path = filepath.Join(t.TempDir(), "truncated.bin")
+  if err := os.WriteFile(file_path, make([]byte, 1 << 16), 0o644); err != nil {
+    t.Fatal(err)
+  }
+
+  reader, err := openFileReader(file_path, 1)
+  if err != nil { t.Fatal(err) }
+  mapped, is_mapped := reader.(*mappedReader)
+  if !is_mapped {
+    if closer, ok := reader.(io.Closer); ok {
+      closer.Close()
+    }
+    t.Skipf("Expected a memory-mapped reader, got %T; memory-mapping may be unsupported", reader)
+  }
+  defer mapped.Close()
+
+  // Reading pages of a mapping past the end of its file faults,
+  // which is returned as an error
+  //
+  if err := os.Truncate(file_path, 0); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := readAllContent(mapped); err == nil {
+    t.Errorf("Expected an error reading a truncated memory-mapped file")
+  }
+}
+
+
+/*
+  BenchmarkFileAssetContent compares reading a large file asset
+  with GetContentBytes, which copies its content to the heap
+  whether or not it is memory-mapped, with streaming it from its
+  content reader into a checksum, which reads from the mapping
+  without copying it.
+*/
+func BenchmarkFileAssetContent (b *testing.B) {
+  var source_dir = b.TempDir()
+  var content    = bytes.Repeat([]byte("0123456789abcdef"), 4 << 20)
+
+  root := NewSpec("root", nil)
+  root.Props["source_dir"] = source_dir
+  if err := root.WriteFile("large.bin", content, 0o644); err != nil {
+    b.Fatal(err)
+  }
+  var file_path = filepath.Join(source_dir, "large.bin")
+
+  var benchmarks = []struct {
+    Name       string
+    Threshold  int
+    Read       func (*Asset) error
+  }{
+    { Name: "GetContentBytes/mapped", Threshold: 1, Read: benchmarkGetContentBytes },
+    { Name: "GetContentBytes/read",   Threshold: 0, Read: benchmarkGetContentBytes },
+    { Name: "Stream/mapped",          Threshold: 1, Read: benchmarkStreamContent },
+    { Name: "Stream/read",            Threshold: 0, Read: benchmarkStreamContent },
+  }
+
+  for _, benchmark := range benchmarks {
+    b.Run(benchmark.Name, func (b *testing.B) {
+      root.Props["mmap_threshold"] = benchmark.Threshold
+      b.SetBytes(int64(len(content)))
+      b.ReportAllocs()
+
+      for i := 0; i < b.N; i++ {
+        asset, err := root.MakeFileKeyAsset(file_path)
+        if err != nil { b.Fatal(err) }
+        if err := benchmark.Read(asset); err != nil {
+          b.Fatal(err)
+        }
+      }
+    })
+  }
+}
+
+
+func benchmarkGetContentBytes (asset *Asset) error {
+  _, err := asset.GetContentBytes()
+  return err
+}
+
+
+func benchmarkStreamContent (asset *Asset) error {
+  reader, err := asset.ContentBytesGetReader()
+  if err != nil { return err }
+  if closer, ok := reader.(io.Closer); ok {
+    defer closer.Close()
+  }
+  _, err = io.Copy(crc32.NewIEEE(), reader)
+  return err
+}
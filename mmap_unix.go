@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package interbuilder
+
+import (
+  "fmt"
+  "os"
+  "syscall"
+)
+
+
+/*
+  mmapFile maps the first size bytes of a file into memory,
+  read-only.
+*/
+func mmapFile (file *os.File, size int64) ([]byte, error) {
+  if size <= 0 || int64(int(size)) != size {
+    return nil, fmt.Errorf("Cannot memory-map %d bytes of %s", size, file.Name())
+  }
+  return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+
+func munmapFile (data []byte) error {
+  return syscall.Munmap(data)
+}
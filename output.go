@@ -0,0 +1,192 @@
+package interbuilder
+
+import (
+  "fmt"
+  "sync/atomic"
+  "time"
+)
+
+
+/*
+  An outputQueue is a bounded queue of Assets in front of one of
+  a Spec's output channels. A goroutine forwards queued Assets to
+  the output, so a slow consumer only blocks the producing Spec
+  once its own queue is full, rather than on every Asset, and
+  without holding back the Spec's other outputs.
+*/
+type outputQueue struct {
+  output  *chan *Asset
+  queue   chan *Asset
+  done    chan struct{}
+  closed  bool
+
+  sent          atomic.Int64
+  blocked       atomic.Int64
+  blocked_time  atomic.Int64
+  max_depth     atomic.Int64
+}
+
+
+/*
+  OutputStats reports backpressure metrics for one of a Spec's
+  output queues. Blocked is the number of Assets the Spec waited
+  to queue because the queue was full, and BlockedTime is the
+  total time spent waiting.
+*/
+type OutputStats struct {
+  Capacity     int
+  Sent         int64
+  Blocked      int64
+  BlockedTime  time.Duration
+  MaxDepth     int
+}
+
+
+func newOutputQueue (output *chan *Asset, capacity int) *outputQueue {
+  q := & outputQueue {
+    output: output,
+    queue:  make(chan *Asset, capacity),
+    done:   make(chan struct{}),
+  }
+
+  go func () {
+    defer close(q.done)
+    for asset := range q.queue {
+      (*q.output) <- asset
+    }
+  }()
+
+  return q
+}
+
+
+func (q *outputQueue) push (a *Asset) {
+  select {
+  case q.queue <- a:
+  default:
+    // The queue is full; wait for the consumer
+    //
+    start := time.Now()
+    q.queue <- a
+    q.blocked.Add(1)
+    q.blocked_time.Add(int64(time.Since(start)))
+  }
+
+  q.sent.Add(1)
+
+  depth := int64(len(q.queue))
+  for {
+    max_depth := q.max_depth.Load()
+    if depth <= max_depth || q.max_depth.CompareAndSwap(max_depth, depth) {
+      break
+    }
+  }
+}
+
+
+/*
+  close stops accepting Assets and waits for the queue to be
+  forwarded to its output.
+*/
+func (q *outputQueue) close () {
+  if q.closed {
+    return
+  }
+  q.closed = true
+  close(q.queue)
+  <-q.done
+}
+
+
+func (q *outputQueue) stats () OutputStats {
+  return OutputStats {
+    Capacity:    cap(q.queue),
+    Sent:        q.sent.Load(),
+    Blocked:     q.blocked.Load(),
+    BlockedTime: time.Duration(q.blocked_time.Load()),
+    MaxDepth:    int(q.max_depth.Load()),
+  }
+}
+
+
+/*
+  outputBufferSize returns the capacity of each output queue, from
+  the inherited "output_buffer" prop. Zero means Assets are sent
+  directly to each output in turn.
+*/
+func (s *Spec) outputBufferSize () (int, error) {
+  size_any, found := s.InheritProp("output_buffer")
+  if !found {
+    return 0, nil
+  }
+
+  size, ok := propInt(size_any)
+  if !ok || size < 0 {
+    return 0, fmt.Errorf(
+      "Prop \"output_buffer\" in Spec %s is expected to be a non-negative integer, got %v",
+      s.Name, size_any,
+    )
+  }
+  return size, nil
+}
+
+
+/*
+  outputQueues returns the queues for this Spec's outputs,
+  starting them on first use, or nil if output is unbuffered.
+*/
+func (s *Spec) outputQueues () ([]*outputQueue, error) {
+  s.output_queues_lock.Lock()
+  defer s.output_queues_lock.Unlock()
+
+  if len(s.output_queues) > 0 && !s.output_queues[0].closed {
+    return s.output_queues, nil
+  }
+
+  size, err := s.outputBufferSize()
+  if err != nil || size == 0 {
+    return nil, err
+  }
+
+  s.output_queues = make([]*outputQueue, 0, len(s.OutputChannels))
+  for _, output := range s.OutputChannels {
+    s.output_queues = append(s.output_queues, newOutputQueue(output, size))
+  }
+
+  return s.output_queues, nil
+}
+
+
+/*
+  closeOutputQueues flushes and stops this Spec's output queues.
+  Their statistics remain available from OutputStats.
+*/
+func (s *Spec) closeOutputQueues () {
+  s.output_queues_lock.Lock()
+  defer s.output_queues_lock.Unlock()
+
+  for _, q := range s.output_queues {
+    q.close()
+  }
+}
+
+
+/*
+  OutputStats returns backpressure metrics for each of this Spec's
+  output queues, in the order of OutputChannels, or nil if output
+  is unbuffered.
+*/
+func (s *Spec) OutputStats () []OutputStats {
+  s.output_queues_lock.Lock()
+  defer s.output_queues_lock.Unlock()
+
+  if len(s.output_queues) == 0 {
+    return nil
+  }
+
+  stats := make([]OutputStats, len(s.output_queues))
+  for i, q := range s.output_queues {
+    stats[i] = q.stats()
+  }
+  return stats
+}
@@ -0,0 +1,102 @@
+package interbuilder
+
+import (
+  "testing"
+  "fmt"
+  "time"
+)
+
+
+func TestSpecOutputBuffer (t *testing.T) {
+  // With buffered output, a subspec can emit assets its parent
+  // has not yet started to consume, which would block otherwise
+  //
+  var root    = NewSpec("root", nil)
+  var subspec = root.AddSubspec(NewSpec("subspec", nil))
+
+  root.Props["quiet"]         = true
+  root.Props["output_buffer"] = float64(3)
+
+  var emitted = make(chan bool)
+
+  subspec.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for i := 0 ; i < 3 ; i++ {
+      if err := s.EmitAsset(s.MakeAsset(fmt.Sprintf("%d", i))); err != nil {
+        return err
+      }
+    }
+    close(emitted)
+    return nil
+  })
+
+  var consumed []*Asset
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    <-emitted
+    for asset := range s.Input {
+      consumed = append(consumed, asset)
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if len(consumed) != 3 {
+    t.Errorf("Expected 3 assets to be consumed, got %d", len(consumed))
+  }
+
+  for i, asset := range consumed {
+    if expect := fmt.Sprintf("@emit/%d", i); asset.Url.Path != expect {
+      t.Errorf("Expected asset %d to have path %s, got %s", i, expect, asset.Url.Path)
+    }
+  }
+
+  stats := subspec.OutputStats()
+  if len(stats) != 1 {
+    t.Fatalf("Expected stats for 1 output queue, got %d", len(stats))
+  }
+  if stats[0].Sent != 3 || stats[0].Blocked != 0 || stats[0].Capacity != 3 {
+    t.Errorf("Unexpected output queue stats: %+v", stats[0])
+  }
+
+  // A full queue blocks the producer, which is recorded
+  //
+  var slow_root = NewSpec("slow-root", nil)
+  var producer  = slow_root.AddSubspec(NewSpec("producer", nil))
+
+  slow_root.Props["quiet"]         = true
+  slow_root.Props["output_buffer"] = 1
+
+  producer.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    for i := 0 ; i < 4 ; i++ {
+      if err := s.EmitAsset(s.MakeAsset(fmt.Sprintf("%d", i))); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  slow_root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    for range s.Input {
+      time.Sleep(5 * time.Millisecond)
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, slow_root.Run)
+
+  if stats := producer.OutputStats(); len(stats) != 1 || stats[0].Blocked == 0 || stats[0].BlockedTime == 0 {
+    t.Errorf("Expected the producer to record blocking on a full queue, got %+v", stats)
+  }
+
+  // Invalid buffer sizes are errors
+  //
+  var invalid        = NewSpec("invalid", nil)
+  var invalid_output = make(chan *Asset, 1)
+  invalid.Props["output_buffer"] = -1
+  invalid.AddOutput(&invalid_output, nil)
+
+  if err := invalid.EmitAsset(invalid.MakeAsset("asset")); err == nil {
+    t.Errorf("Expected an error emitting with a negative output_buffer")
+  }
+}
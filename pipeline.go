@@ -0,0 +1,285 @@
+package interbuilder
+
+import (
+  "context"
+  "errors"
+  "fmt"
+)
+
+
+/*
+  A Pipeline constructs a Spec tree fluently, for programs using
+  interbuilder as a library:
+
+    pipeline := NewPipelineFrom(root)
+    pipeline.Spec("app").
+      Source("git://example.com/app").
+      Transform("s`^`app/`").
+      TaskFunc("report", report)
+    err := pipeline.Run()
+
+  Methods return their receiver, or the spec they create, so that
+  calls can be chained. Errors, such as from parsing path
+  transformations, are collected rather than returned, and are
+  returned by Err, Build, and Run. Props such as "source" are only
+  interpreted by the builders of the root spec, such as those of
+  the behaviors package.
+*/
+type Pipeline struct {
+  Root   *Spec
+
+  specs  []*Spec
+  errs   []error
+  built  bool
+}
+
+
+/*
+  A PipelineSpec is a Spec in a Pipeline, whose methods configure
+  it fluently.
+*/
+type PipelineSpec struct {
+  Spec      *Spec
+  pipeline  *Pipeline
+}
+
+
+/*
+  NewPipeline returns a Pipeline with a new root Spec named
+  "root", with no builders.
+*/
+func NewPipeline () *Pipeline {
+  return NewPipelineFrom(NewSpec("root", nil))
+}
+
+
+/*
+  NewPipelineFrom returns a Pipeline whose root is an existing
+  Spec, such as one with the default behaviors.
+*/
+func NewPipelineFrom (root *Spec) *Pipeline {
+  return & Pipeline { Root: root }
+}
+
+
+func (p *Pipeline) addError (err error) {
+  if err != nil {
+    p.errs = append(p.errs, err)
+  }
+}
+
+
+/*
+  Err returns the errors collected while constructing the
+  Pipeline, joined with errors.Join.
+*/
+func (p *Pipeline) Err () error {
+  return errors.Join(p.errs...)
+}
+
+
+/*
+  Spec returns the subspec of the root with a name, adding it if
+  the Pipeline has not already.
+*/
+func (p *Pipeline) Spec (name string) *PipelineSpec {
+  return p.wrap(p.Root).Subspec(name)
+}
+
+
+/*
+  Prop sets a prop of the root Spec.
+*/
+func (p *Pipeline) Prop (key string, value any) *Pipeline {
+  p.Root.Props[key] = value
+  return p
+}
+
+
+/*
+  Builder adds a SpecBuilder to the root Spec, which builds it
+  and each of its subspecs.
+*/
+func (p *Pipeline) Builder (builders ...SpecBuilder) *Pipeline {
+  for _, builder := range builders {
+    p.Root.AddSpecBuilder(builder)
+  }
+  return p
+}
+
+
+func (p *Pipeline) wrap (s *Spec) *PipelineSpec {
+  return & PipelineSpec { Spec: s, pipeline: p }
+}
+
+
+/*
+  Build builds the root Spec, and then each subspec added by the
+  Pipeline, parents before their children. A Pipeline is only
+  built once; later calls return nil.
+*/
+func (p *Pipeline) Build () error {
+  if err := p.Err(); err != nil {
+    return err
+  }
+  if p.built {
+    return nil
+  }
+  p.built = true
+
+  if err := p.Root.Build(); err != nil {
+    return err
+  }
+  for _, spec := range p.specs {
+    if err := spec.Build(); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+
+/*
+  Run builds the Pipeline, if it has not been, and runs its root
+  Spec.
+*/
+func (p *Pipeline) Run () error {
+  return p.RunContext(context.Background())
+}
+
+
+func (p *Pipeline) RunContext (ctx context.Context) error {
+  if err := p.Build(); err != nil {
+    return err
+  }
+  return p.Root.RunContext(ctx)
+}
+
+
+/*
+  Subspec returns the subspec with a name, adding it if the
+  Pipeline has not already.
+*/
+func (ps *PipelineSpec) Subspec (name string) *PipelineSpec {
+  if subspec, found := ps.Spec.Subspecs[name]; found {
+    return ps.pipeline.wrap(subspec)
+  }
+
+  var subspec = ps.Spec.AddSubspec(NewSpec(name, nil))
+  ps.pipeline.specs = append(ps.pipeline.specs, subspec)
+  return ps.pipeline.wrap(subspec)
+}
+
+
+/*
+  Parent returns the PipelineSpec of the Spec's parent, or nil if
+  it is the root.
+*/
+func (ps *PipelineSpec) Parent () *PipelineSpec {
+  if ps.Spec.Parent == nil {
+    return nil
+  }
+  return ps.pipeline.wrap(ps.Spec.Parent)
+}
+
+
+/*
+  Pipeline returns the Pipeline the Spec is in, to continue
+  configuring it from the root.
+*/
+func (ps *PipelineSpec) Pipeline () *Pipeline {
+  return ps.pipeline
+}
+
+
+func (ps *PipelineSpec) Prop (key string, value any) *PipelineSpec {
+  ps.Spec.Props[key] = value
+  return ps
+}
+
+
+/*
+  Source sets the "source" prop, the URL or path the Spec's
+  content comes from.
+*/
+func (ps *PipelineSpec) Source (source string) *PipelineSpec {
+  return ps.Prop("source", source)
+}
+
+
+/*
+  SourceDir sets the "source_dir" prop, the Spec's working
+  directory.
+*/
+func (ps *PipelineSpec) SourceDir (source_dir string) *PipelineSpec {
+  return ps.Prop("source_dir", source_dir)
+}
+
+
+/*
+  Transform parses path transformations, as in
+  PathTransformationsFromAny, and adds them to the Spec's.
+*/
+func (ps *PipelineSpec) Transform (transformations ...any) *PipelineSpec {
+  for _, src := range transformations {
+    parsed, err := PathTransformationsFromAny(src)
+    if err != nil {
+      ps.pipeline.addError(fmt.Errorf("Cannot parse transformation of spec %s: %w", ps.Spec.Name, err))
+      continue
+    }
+    ps.Spec.PathTransformations = append(ps.Spec.PathTransformations, parsed...)
+  }
+  return ps
+}
+
+
+/*
+  Builder adds SpecBuilders to the Spec, which build it and each
+  of its subspecs.
+*/
+func (ps *PipelineSpec) Builder (builders ...SpecBuilder) *PipelineSpec {
+  for _, builder := range builders {
+    ps.Spec.AddSpecBuilder(builder)
+  }
+  return ps
+}
+
+
+/*
+  Task enqueues Tasks in the Spec.
+*/
+func (ps *PipelineSpec) Task (tasks ...*Task) *PipelineSpec {
+  for _, task := range tasks {
+    ps.pipeline.addError(ps.Spec.EnqueueTask(task))
+  }
+  return ps
+}
+
+
+func (ps *PipelineSpec) TaskFunc (name string, fn TaskFunc) *PipelineSpec {
+  ps.pipeline.addError(ps.Spec.EnqueueTaskFunc(name, fn))
+  return ps
+}
+
+
+func (ps *PipelineSpec) TaskMapFunc (name string, fn TaskMapFunc) *PipelineSpec {
+  ps.pipeline.addError(ps.Spec.EnqueueTaskMapFunc(name, fn))
+  return ps
+}
+
+
+/*
+  Defer defers Tasks in the Spec, to run after its other Tasks.
+*/
+func (ps *PipelineSpec) Defer (tasks ...*Task) *PipelineSpec {
+  for _, task := range tasks {
+    ps.pipeline.addError(ps.Spec.DeferTask(task))
+  }
+  return ps
+}
+
+
+func (ps *PipelineSpec) DeferFunc (name string, fn TaskFunc) *PipelineSpec {
+  ps.pipeline.addError(ps.Spec.DeferTaskFunc(name, fn))
+  return ps
+}
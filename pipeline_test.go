@@ -0,0 +1,87 @@
+package interbuilder
+
+import (
+  "sort"
+  "strings"
+  "testing"
+)
+
+
+func TestPipeline (t *testing.T) {
+  var built []string
+  var received []string
+
+  var emit = func (keys ...string) TaskFunc {
+    return func (s *Spec, tk *Task) error {
+      for _, key := range keys {
+        asset := s.MakeAsset(key)
+        asset.SetContentBytes([]byte(key))
+        if err := tk.EmitAsset(asset); err != nil {
+          return err
+        }
+      }
+      return nil
+    }
+  }
+
+  var pipeline = NewPipeline().
+    Prop("quiet", true).
+    Builder(func (s *Spec) error {
+      built = append(built, s.SpecPath())
+      return nil
+    })
+
+  pipeline.Spec("app").
+    Source("git://example.com/app").
+    Transform("s`^`app/`").
+    TaskFunc("emit", emit("index.html")).
+    Subspec("docs").
+      Transform("s`^`docs/`").
+      TaskFunc("emit", emit("guide.html"))
+
+  pipeline.Spec("blog").
+    Prop("title", "Blog").
+    TaskFunc("emit", emit("post.html"))
+
+  pipeline.Root.DeferTaskFunc("root-consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    for _, input := range tk.Assets {
+      assets, err := input.Flatten()
+      if err != nil { return err }
+      for _, asset := range assets {
+        received = append(received, strings.TrimPrefix(asset.Url.Path, "@emit/"))
+      }
+    }
+    return nil
+  })
+
+  if err := pipeline.Run(); err != nil {
+    t.Fatal(err)
+  }
+
+  if got, expect := strings.Join(built, ","), "root,root/app,root/app/docs,root/blog"; got != expect {
+    t.Errorf("Expected specs to be built in the order %s, got %s", expect, got)
+  }
+
+  sort.Strings(received)
+  if got, expect := strings.Join(received, ","), "app/docs/guide.html,app/index.html,post.html"; got != expect {
+    t.Errorf("Expected the assets %s, got %s", expect, got)
+  }
+
+  if source := pipeline.Spec("app").Spec.Props["source"]; source != "git://example.com/app" {
+    t.Errorf("Expected the source prop to be set, got %v", source)
+  }
+  if pipeline.Spec("app").Subspec("docs").Parent().Spec != pipeline.Root.Subspecs["app"] {
+    t.Errorf("Expected existing subspecs to be returned")
+  }
+
+  // Construction errors are returned when building
+  //
+  var broken = NewPipeline()
+  broken.Spec("bad").Transform(42)
+  if err := broken.Build(); err == nil || !strings.Contains(err.Error(), "bad") {
+    t.Errorf("Expected the transformation error, got %v", err)
+  }
+}
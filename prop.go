@@ -36,10 +36,7 @@ func (s *Spec) RequireProp (key string) (value any, err error) {
     return value, nil
   }
 
-  return nil, fmt.Errorf(
-    "Prop \"%s\" required in spec %s",
-    key, s.Name,
-  )
+  return nil, & PropMissingError { Spec: s.Name, Prop: key }
 }
 
 
@@ -92,10 +89,7 @@ func (s *Spec) RequireInheritProp (key string) (value any, err error) {
     return value, nil
   }
 
-  return nil, fmt.Errorf(
-    "Inherited prop \"%s\" required in spec %s", 
-    key, s.Name,
-  )
+  return nil, & PropMissingError { Spec: s.Name, Prop: key, Inherited: true }
 }
 
 
@@ -112,10 +106,9 @@ func (s *Spec) RequireInheritPropType (key string, prop_type reflect.Type) (valu
   }
 
   if s.Parent == nil {
-    return reflect.Zero(prop_type).Interface(), fmt.Errorf(
-      "Inherited prop \"%s\" in Spec %s of type %v not found",
-      key, s.Name, prop_type,
-    )
+    return reflect.Zero(prop_type).Interface(), & PropMissingError {
+      Spec: s.Name, Prop: key, Inherited: true,
+    }
   }
 
   return s.Parent.RequireInheritPropType(key, prop_type)
@@ -174,28 +167,55 @@ func (s *Spec) RequirePropBool (k string) (value bool, err error) {
 
 
 /*
-  Boolean prop access methods
+  Integer prop access methods. Props decoded from JSON hold
+  numbers as float64, so whole-number floats are accepted as
+  integers.
 */
 
+func propInt (value_any any) (value int, ok bool) {
+  switch value := value_any.(type) {
+    case int:
+      return value, true
+    case int64:
+      return int(value), true
+    case float64:
+      if value == float64(int(value)) {
+        return int(value), true
+      }
+  }
+  return 0, false
+}
 func (s *Spec) GetPropInt (k string) (value int, ok, found bool) {
   value_any, found := s.Props[k]
-  value, ok = value_any.(int)
+  value, ok = propInt(value_any)
   return value, ok, found
 }
 func (s *Spec) InheritPropInt (k string) (value int, ok, found bool) {
   value_any, found := s.InheritProp(k)
-  value, ok = value_any.(int)
+  value, ok = propInt(value_any)
   return value, ok, found
 }
 func (s *Spec) RequireInheritPropInt (k string) (value int, err error) {
-  value_any, err := s.RequireInheritPropType(k, reflect.TypeOf(value))
+  value_any, err := s.RequireInheritProp(k)
   if err != nil { return }
-  return value_any.(int), nil
+  if value, ok := propInt(value_any); ok {
+    return value, nil
+  }
+  return 0, fmt.Errorf(
+    "Inherited prop \"%s\" in Spec %s is expected to be an integer, got %T",
+    k, s.Name, value_any,
+  )
 }
 func (s *Spec) RequirePropInt (k string) (value int, err error) {
-  value_any, err := s.RequirePropType(k, reflect.TypeOf(value))
-  if err == nil { return value_any.(int), nil }
-  return 0, err
+  value_any, err := s.RequireProp(k)
+  if err != nil { return }
+  if value, ok := propInt(value_any); ok {
+    return value, nil
+  }
+  return 0, fmt.Errorf(
+    "Prop \"%s\" in Spec %s is expected to be an integer, got %T",
+    k, s.Name, value_any,
+  )
 }
 
 /*
@@ -247,3 +267,29 @@ func (s *Spec) RequirePropJson (k string) (value map[string]any, err error) {
   if err == nil { return value_any.(map[string]any), nil }
   return nil, err
 }
+
+
+/*
+  copyPropValue returns a copy of a prop value, with copies of the
+  objects and arrays nested in it.
+*/
+func copyPropValue (value any) any {
+  switch value := value.(type) {
+  case map[string]any:
+    var value_copy = make(map[string]any, len(value))
+    for key, element := range value {
+      value_copy[key] = copyPropValue(element)
+    }
+    return value_copy
+  case []any:
+    var value_copy = make([]any, len(value))
+    for i, element := range value {
+      value_copy[i] = copyPropValue(element)
+    }
+    return value_copy
+  case *url.URL:
+    var value_copy = *value
+    return &value_copy
+  }
+  return value
+}
@@ -0,0 +1,230 @@
+// Asset exchange between interbuilder processes.
+//
+// This schema mirrors the NDJSON asset encoding of the CLI's
+// --input and --output flags, so that assets keep their URL, MIME
+// type, content, and provenance between hosts. The Go bindings in
+// this directory are generated with "make proto", and the
+// AssetExchange server and client are in the behaviors package.
+//
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: interbuilder.proto
+
+package interbuilderpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	AssetExchange_Ingest_FullMethodName = "/interbuilder.v1.AssetExchange/Ingest"
+	AssetExchange_Emit_FullMethodName   = "/interbuilder.v1.AssetExchange/Emit"
+)
+
+// AssetExchangeClient is the client API for AssetExchange service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// AssetExchange streams assets into and out of an interbuilder
+// process. Ingest feeds assets to the input of a pipeline, as the
+// CLI's --input flag does, and Emit streams the assets a pipeline
+// outputs, as its --output flag does. Each call runs the stored
+// pipeline, named by the "pipeline" metadata of an Ingest call.
+type AssetExchangeClient interface {
+	Ingest(ctx context.Context, opts ...grpc.CallOption) (AssetExchange_IngestClient, error)
+	Emit(ctx context.Context, in *EmitRequest, opts ...grpc.CallOption) (AssetExchange_EmitClient, error)
+}
+
+type assetExchangeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAssetExchangeClient(cc grpc.ClientConnInterface) AssetExchangeClient {
+	return &assetExchangeClient{cc}
+}
+
+func (c *assetExchangeClient) Ingest(ctx context.Context, opts ...grpc.CallOption) (AssetExchange_IngestClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AssetExchange_ServiceDesc.Streams[0], AssetExchange_Ingest_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &assetExchangeIngestClient{ClientStream: stream}
+	return x, nil
+}
+
+type AssetExchange_IngestClient interface {
+	Send(*Asset) error
+	CloseAndRecv() (*IngestSummary, error)
+	grpc.ClientStream
+}
+
+type assetExchangeIngestClient struct {
+	grpc.ClientStream
+}
+
+func (x *assetExchangeIngestClient) Send(m *Asset) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *assetExchangeIngestClient) CloseAndRecv() (*IngestSummary, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(IngestSummary)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *assetExchangeClient) Emit(ctx context.Context, in *EmitRequest, opts ...grpc.CallOption) (AssetExchange_EmitClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AssetExchange_ServiceDesc.Streams[1], AssetExchange_Emit_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &assetExchangeEmitClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AssetExchange_EmitClient interface {
+	Recv() (*Asset, error)
+	grpc.ClientStream
+}
+
+type assetExchangeEmitClient struct {
+	grpc.ClientStream
+}
+
+func (x *assetExchangeEmitClient) Recv() (*Asset, error) {
+	m := new(Asset)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AssetExchangeServer is the server API for AssetExchange service.
+// All implementations must embed UnimplementedAssetExchangeServer
+// for forward compatibility
+//
+// AssetExchange streams assets into and out of an interbuilder
+// process. Ingest feeds assets to the input of a pipeline, as the
+// CLI's --input flag does, and Emit streams the assets a pipeline
+// outputs, as its --output flag does. Each call runs the stored
+// pipeline, named by the "pipeline" metadata of an Ingest call.
+type AssetExchangeServer interface {
+	Ingest(AssetExchange_IngestServer) error
+	Emit(*EmitRequest, AssetExchange_EmitServer) error
+	mustEmbedUnimplementedAssetExchangeServer()
+}
+
+// UnimplementedAssetExchangeServer must be embedded to have forward compatible implementations.
+type UnimplementedAssetExchangeServer struct {
+}
+
+func (UnimplementedAssetExchangeServer) Ingest(AssetExchange_IngestServer) error {
+	return status.Errorf(codes.Unimplemented, "method Ingest not implemented")
+}
+func (UnimplementedAssetExchangeServer) Emit(*EmitRequest, AssetExchange_EmitServer) error {
+	return status.Errorf(codes.Unimplemented, "method Emit not implemented")
+}
+func (UnimplementedAssetExchangeServer) mustEmbedUnimplementedAssetExchangeServer() {}
+
+// UnsafeAssetExchangeServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AssetExchangeServer will
+// result in compilation errors.
+type UnsafeAssetExchangeServer interface {
+	mustEmbedUnimplementedAssetExchangeServer()
+}
+
+func RegisterAssetExchangeServer(s grpc.ServiceRegistrar, srv AssetExchangeServer) {
+	s.RegisterService(&AssetExchange_ServiceDesc, srv)
+}
+
+func _AssetExchange_Ingest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AssetExchangeServer).Ingest(&assetExchangeIngestServer{ServerStream: stream})
+}
+
+type AssetExchange_IngestServer interface {
+	SendAndClose(*IngestSummary) error
+	Recv() (*Asset, error)
+	grpc.ServerStream
+}
+
+type assetExchangeIngestServer struct {
+	grpc.ServerStream
+}
+
+func (x *assetExchangeIngestServer) SendAndClose(m *IngestSummary) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *assetExchangeIngestServer) Recv() (*Asset, error) {
+	m := new(Asset)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _AssetExchange_Emit_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EmitRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AssetExchangeServer).Emit(m, &assetExchangeEmitServer{ServerStream: stream})
+}
+
+type AssetExchange_EmitServer interface {
+	Send(*Asset) error
+	grpc.ServerStream
+}
+
+type assetExchangeEmitServer struct {
+	grpc.ServerStream
+}
+
+func (x *assetExchangeEmitServer) Send(m *Asset) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// AssetExchange_ServiceDesc is the grpc.ServiceDesc for AssetExchange service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AssetExchange_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "interbuilder.v1.AssetExchange",
+	HandlerType: (*AssetExchangeServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Ingest",
+			Handler:       _AssetExchange_Ingest_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Emit",
+			Handler:       _AssetExchange_Emit_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "interbuilder.proto",
+}
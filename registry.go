@@ -0,0 +1,221 @@
+package interbuilder
+
+import (
+  "fmt"
+  "sort"
+  "sync"
+)
+
+
+/*
+  The registry is a package-level collection of TaskResolvers and
+  SpecBuilders, contributed by behavior packages, typically in
+  their init() functions. Programs constructing a root Spec, such
+  as the Interbuilder CLI, apply the registry to it with
+  ApplyRegistry, allowing external Go modules to add behaviors
+  without modifying the program itself.
+*/
+var registry struct {
+  lock                 sync.Mutex
+  task_resolvers       []*TaskResolver
+  child_task_resolvers []registeredChildTaskResolver
+  spec_builders        []SpecBuilder
+  presets              map[string]*SpecPreset
+}
+
+
+type registeredChildTaskResolver struct {
+  ParentId string
+  Resolver *TaskResolver
+}
+
+
+/*
+  RegisterTaskResolver adds a TaskResolver to the registry, to be
+  added to root Specs by ApplyRegistry.
+*/
+func RegisterTaskResolver (tr *TaskResolver) {
+  registry.lock.Lock()
+  defer registry.lock.Unlock()
+  registry.task_resolvers = append(registry.task_resolvers, tr)
+}
+
+
+/*
+  RegisterChildTaskResolver adds a TaskResolver to the registry,
+  to be added by ApplyRegistry as a child of the TaskResolver
+  with the ID parent_id, such as "source-infer-root".
+*/
+func RegisterChildTaskResolver (parent_id string, tr *TaskResolver) {
+  registry.lock.Lock()
+  defer registry.lock.Unlock()
+  registry.child_task_resolvers = append(
+    registry.child_task_resolvers,
+    registeredChildTaskResolver { ParentId: parent_id, Resolver: tr },
+  )
+}
+
+
+/*
+  RegisterSpecBuilder adds a SpecBuilder to the registry, to be
+  added to root Specs by ApplyRegistry.
+*/
+func RegisterSpecBuilder (b SpecBuilder) {
+  registry.lock.Lock()
+  defer registry.lock.Unlock()
+  registry.spec_builders = append(registry.spec_builders, b)
+}
+
+
+/*
+  ApplyRegistry adds registered TaskResolvers and SpecBuilders to
+  a Spec, in the order they were registered. Registered
+  TaskResolvers are copied, along with their children, before
+  being added, because adding a resolver links it into the
+  Spec's resolver list; sharing them would link the lists of
+  separate Specs together. TaskResolvers with an ID already
+  reachable from the Spec are skipped. Child TaskResolvers are
+  added after top-level ones, and an error is returned if their
+  parent cannot be found. SpecBuilders cannot be compared, and
+  are added on every call, so the registry should only be
+  applied once per Spec.
+*/
+func ApplyRegistry (s *Spec) error {
+  registry.lock.Lock()
+  defer registry.lock.Unlock()
+
+  for _, tr := range registry.task_resolvers {
+    if tr.Id != "" && s.GetTaskResolverById(tr.Id) != nil {
+      continue
+    }
+    s.AddTaskResolver(copyTaskResolverTree(tr))
+  }
+
+  for _, child := range registry.child_task_resolvers {
+    parent := s.GetTaskResolverById(child.ParentId)
+    if parent == nil {
+      return fmt.Errorf(
+        "Cannot add registered TaskResolver %s, parent TaskResolver %s was not found",
+        child.Resolver.Id, child.ParentId,
+      )
+    }
+
+    if child.Resolver.Id != "" && parent.GetTaskResolverById(child.Resolver.Id) != nil {
+      continue
+    }
+
+    if err := parent.AddTaskResolver(copyTaskResolverTree(child.Resolver)); err != nil {
+      return fmt.Errorf("Cannot add registered TaskResolver %s: %w", child.Resolver.Id, err)
+    }
+  }
+
+  for _, builder := range registry.spec_builders {
+    s.AddSpecBuilder(builder)
+  }
+
+  return nil
+}
+
+
+/*
+  copyTaskResolverTree returns a shallow copy of a TaskResolver
+  which is unlinked from its siblings, with copies of its
+  children, in the same order.
+*/
+func copyTaskResolverTree (tr *TaskResolver) *TaskResolver {
+  var tr_copy = *tr
+  tr_copy.Next     = nil
+  tr_copy.Children = nil
+
+  var last_child *TaskResolver
+  for child := tr.Children ; child != nil ; child = child.Next {
+    var child_copy = copyTaskResolverTree(child)
+    if last_child == nil {
+      tr_copy.Children = child_copy
+    } else {
+      last_child.Next = child_copy
+    }
+    last_child = child_copy
+  }
+
+  return &tr_copy
+}
+
+
+/*
+  A SpecPreset is a named pipeline setup, such as "static-site",
+  which a spec selects with its "preset" prop. Applying a preset
+  sets its Props as defaults, which the spec's own props
+  override, and then runs its Builders, to add tasks or props
+  which depend on the spec's other props.
+*/
+type SpecPreset struct {
+  Name         string
+  Description  string
+  Props        map[string]any
+  Builders     []SpecBuilder
+}
+
+
+/*
+  RegisterPreset adds a SpecPreset to the registry, replacing any
+  registered preset with the same name.
+*/
+func RegisterPreset (p *SpecPreset) {
+  registry.lock.Lock()
+  defer registry.lock.Unlock()
+  if registry.presets == nil {
+    registry.presets = make(map[string]*SpecPreset)
+  }
+  registry.presets[p.Name] = p
+}
+
+
+/*
+  GetPreset returns the registered SpecPreset with a name, or nil.
+*/
+func GetPreset (name string) *SpecPreset {
+  registry.lock.Lock()
+  defer registry.lock.Unlock()
+  return registry.presets[name]
+}
+
+
+/*
+  PresetNames returns the names of the registered SpecPresets, in
+  alphabetical order.
+*/
+func PresetNames () []string {
+  registry.lock.Lock()
+  defer registry.lock.Unlock()
+
+  var names = make([]string, 0, len(registry.presets))
+  for name := range registry.presets {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+
+/*
+  Apply sets the preset's Props in a Spec which does not already
+  have them, and runs its Builders on the Spec. Props are copied,
+  including their nested objects and arrays, so that builders
+  modifying them do not modify the preset.
+*/
+func (p *SpecPreset) Apply (s *Spec) error {
+  for key, value := range p.Props {
+    if _, found := s.Props[key]; !found {
+      s.Props[key] = copyPropValue(value)
+    }
+  }
+
+  for _, builder := range p.Builders {
+    if err := builder(s); err != nil {
+      return fmt.Errorf("Preset %s: %w", p.Name, err)
+    }
+  }
+  return nil
+}
+
@@ -0,0 +1,170 @@
+package interbuilder
+
+import (
+  "testing"
+  "fmt"
+)
+
+
+func TestApplyRegistry (t *testing.T) {
+  // Restore the registry after this test, so registered test
+  // behaviors do not leak into other tests
+  //
+  registry.lock.Lock()
+  var saved_registry = registry.task_resolvers
+  var saved_children = registry.child_task_resolvers
+  var saved_builders = registry.spec_builders
+  registry.lock.Unlock()
+
+  defer func () {
+    registry.lock.Lock()
+    registry.task_resolvers       = saved_registry
+    registry.child_task_resolvers = saved_children
+    registry.spec_builders        = saved_builders
+    registry.lock.Unlock()
+  }()
+
+  var noop = func (*Spec, *Task) error { return nil }
+
+  var parent = & TaskResolver {
+    Id: "registry-test-parent", Name: "registry-test",
+    TaskPrototype: Task { Func: noop },
+  }
+
+  var child = & TaskResolver {
+    Id: "registry-test-child", Name: "registry-test",
+    TaskPrototype: Task { Func: noop },
+  }
+
+  var builder_calls int
+  var builder = func (s *Spec) error {
+    builder_calls++
+    return nil
+  }
+
+  RegisterTaskResolver(parent)
+  RegisterChildTaskResolver("registry-test-parent", child)
+  RegisterSpecBuilder(builder)
+
+  // Apply the registry to two root Specs, each with their own
+  // resolver, asserting registered resolvers are not linked into
+  // either resolver list
+  //
+  var roots []*Spec
+
+  for i := 0 ; i < 2 ; i++ {
+    var root = NewSpec(fmt.Sprintf("root-%d", i), nil)
+    root.Props["quiet"] = true
+    roots = append(roots, root)
+
+    root.AddTaskResolver(& TaskResolver {
+      Id: fmt.Sprintf("root-%d-own", i), Name: fmt.Sprintf("root-%d-own", i),
+      TaskPrototype: Task { Func: noop },
+    })
+
+    if err := ApplyRegistry(root); err != nil {
+      t.Fatal(err)
+    }
+
+    if err := ApplyRegistry(root); err != nil {
+      t.Fatal(err)
+    }
+
+    if got := root.GetTaskResolverById("registry-test-child"); got == nil {
+      t.Fatalf("Registered child TaskResolver was not found under its parent")
+    } else if got == child {
+      t.Fatalf("Registered child TaskResolver was added without being copied")
+    }
+
+    if parent.Next != nil || parent.Children != nil || child.Next != nil {
+      t.Fatalf("Registered TaskResolvers were linked to other resolvers")
+    }
+
+    if task, err := root.GetTask("registry-test", root); err != nil {
+      t.Fatal(err)
+    } else if task == nil || task.ResolverId != "registry-test-child" {
+      t.Fatalf("Expected registered child TaskResolver to resolve the task")
+    }
+
+    if err := root.Build(); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  // Neither root can resolve the other's own resolver
+  //
+  for i, root := range roots {
+    var other_name = fmt.Sprintf("root-%d-own", 1 - i)
+    if task, err := root.GetTask(other_name, root); err != nil {
+      t.Fatal(err)
+    } else if task != nil {
+      t.Fatalf("Spec %s resolved task %s from another root Spec", root.Name, other_name)
+    }
+  }
+
+  if builder_calls == 0 {
+    t.Fatalf("Registered SpecBuilder was not called when building")
+  }
+
+  // Children with a missing parent produce an error
+  //
+  RegisterChildTaskResolver("registry-test-missing", & TaskResolver { Id: "orphan" })
+  if err := ApplyRegistry(NewSpec("root", nil)); err == nil {
+    t.Fatalf("Expected an error applying a registry with an orphaned child TaskResolver")
+  }
+}
+
+
+func TestSpecPresetApply (t *testing.T) {
+  var preset = & SpecPreset {
+    Name:  "registry-test-preset",
+    Props: map[string]any {
+      "minify": true,
+      "emit":   map[string]any { "dir": "dist" },
+      "tags":   []any { "a" },
+    },
+    Builders: []SpecBuilder {
+      func (s *Spec) error {
+        s.Props["built"] = s.Props["minify"]
+        return nil
+      },
+    },
+  }
+
+  RegisterPreset(preset)
+  defer func () {
+    registry.lock.Lock()
+    delete(registry.presets, preset.Name)
+    registry.lock.Unlock()
+  }()
+
+  if GetPreset("registry-test-preset") != preset {
+    t.Fatalf("Expected the registered preset to be found by name")
+  }
+  var listed bool
+  for _, name := range PresetNames() {
+    listed = listed || name == preset.Name
+  }
+  if !listed {
+    t.Errorf("Expected the registered preset in %v", PresetNames())
+  }
+
+  // The spec's own props take precedence, and the preset's are
+  // copied
+  //
+  var spec = NewSpec("site", nil)
+  spec.Props["minify"] = false
+  if err := preset.Apply(spec); err != nil {
+    t.Fatal(err)
+  }
+
+  if spec.Props["minify"] != false || spec.Props["built"] != false {
+    t.Errorf("Expected the spec's minify prop to be kept, got %v", spec.Props)
+  }
+
+  spec.Props["emit"].(map[string]any)["dir"] = "build"
+  spec.Props["tags"].([]any)[0] = "b"
+  if preset.Props["emit"].(map[string]any)["dir"] != "dist" || preset.Props["tags"].([]any)[0] != "a" {
+    t.Errorf("Expected modifying the spec's props not to modify the preset, got %v", preset.Props)
+  }
+}
@@ -0,0 +1,191 @@
+package interbuilder
+
+import (
+  "context"
+  "os"
+  "sync"
+  "time"
+)
+
+
+/*
+  A BuildReport describes a run of a Spec tree, as returned by
+  Spec.RunReport, and can be encoded as JSON. Specs are ordered by
+  their SpecPath, and include those which did not run.
+*/
+type BuildReport struct {
+  Spec      string             `json:"spec"`
+  Start     time.Time          `json:"start"`
+  Duration  time.Duration      `json:"duration_ns"`
+  Status    string             `json:"status"`
+  Error     string             `json:"error,omitempty"`
+  Specs     []SpecBuildReport  `json:"specs"`
+}
+
+
+/*
+  A SpecBuildReport describes the run of one Spec in a
+  BuildReport: how long it took, the Assets it output, and its
+  Tasks, in the order they started or were skipped. Cached is true
+  if the Spec output the Assets of its checkpoint instead of
+  running. Bytes is the size of the output Assets' content, where
+  it is known without reading it.
+*/
+type SpecBuildReport struct {
+  Path      string             `json:"path"`
+  Status    string             `json:"status"`
+  Cached    bool               `json:"cached,omitempty"`
+  Start     time.Time          `json:"start"`
+  Duration  time.Duration      `json:"duration_ns"`
+  Assets    int                `json:"assets"`
+  Bytes     int64              `json:"bytes"`
+  Error     string             `json:"error,omitempty"`
+  Tasks     []TaskBuildReport  `json:"tasks"`
+}
+
+
+/*
+  A TaskBuildReport describes the run of one Task in a
+  SpecBuildReport. A skipped Task has no duration.
+*/
+type TaskBuildReport struct {
+  Name      string         `json:"name"`
+  Resolver  string         `json:"resolver,omitempty"`
+  Skipped   bool           `json:"skipped,omitempty"`
+  Start     time.Time      `json:"start"`
+  Duration  time.Duration  `json:"duration_ns"`
+  Error     string         `json:"error,omitempty"`
+}
+
+
+/*
+  RunReport runs this Spec as in RunContext, and returns a
+  BuildReport of the run, which is also returned if the run
+  fails.
+*/
+func (s *Spec) RunReport (ctx context.Context) (*BuildReport, error) {
+  var recorder = reportRecorder {
+    specs:      make(map[*Spec]*SpecBuildReport),
+    spec_tasks: make(map[*Spec][]*TaskBuildReport),
+    tasks:      make(map[*Task]*TaskBuildReport),
+  }
+  var remove = s.onEvent(recorder.record)
+
+  var start = time.Now()
+  var err   = s.RunContext(ctx)
+  remove()
+
+  status, _ := s.Status()
+  var report = & BuildReport {
+    Spec:     s.SpecPath(),
+    Start:    start,
+    Duration: time.Since(start),
+    Status:   status.String(),
+  }
+  if err != nil {
+    report.Error = err.Error()
+  }
+
+  recorder.lock.Lock()
+  defer recorder.lock.Unlock()
+
+  for _, status_report := range s.StatusReport() {
+    var spec_report SpecBuildReport
+    if recorded, found := recorder.specs[status_report.Spec]; found {
+      spec_report = *recorded
+    }
+
+    spec_report.Path   = status_report.Path
+    spec_report.Status = status_report.Status.String()
+    if status_report.Err != nil {
+      spec_report.Error = status_report.Err.Error()
+    }
+
+    var tasks = recorder.spec_tasks[status_report.Spec]
+    spec_report.Tasks = make([]TaskBuildReport, 0, len(tasks))
+    for _, task := range tasks {
+      spec_report.Tasks = append(spec_report.Tasks, *task)
+    }
+
+    report.Specs = append(report.Specs, spec_report)
+  }
+
+  return report, err
+}
+
+
+/*
+  reportRecorder collects the events of a run for RunReport.
+*/
+type reportRecorder struct {
+  lock        sync.Mutex
+  specs       map[*Spec]*SpecBuildReport
+  spec_tasks  map[*Spec][]*TaskBuildReport
+  tasks       map[*Task]*TaskBuildReport
+}
+
+
+func (r *reportRecorder) record (e Event) {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+
+  var spec = r.specs[e.Spec]
+  if spec == nil {
+    spec = & SpecBuildReport {}
+    r.specs[e.Spec] = spec
+  }
+
+  // Timings are read from the Spec and Task fields set by the
+  // scheduler, as they are set before their events are emitted
+  //
+  switch e.Type {
+  case EVENT_SPEC_START:
+    spec.Start = e.Spec.StartedAt
+  case EVENT_SPEC_END:
+    spec.Duration = e.Spec.Duration
+  case EVENT_SPEC_RESUME:
+    spec.Cached = true
+
+  case EVENT_TASK_START, EVENT_TASK_SKIP:
+    var task = & TaskBuildReport {
+      Name:     e.Task.Name,
+      Resolver: e.Task.ResolverId,
+      Skipped:  e.Type == EVENT_TASK_SKIP,
+      Start:    e.Task.StartedAt,
+    }
+    r.spec_tasks[e.Spec] = append(r.spec_tasks[e.Spec], task)
+    r.tasks[e.Task] = task
+
+  case EVENT_TASK_END, EVENT_TASK_ERROR:
+    if task := r.tasks[e.Task]; task != nil {
+      task.Duration = e.Task.Duration
+      if e.Err != nil {
+        task.Error = e.Err.Error()
+      }
+    }
+
+  case EVENT_ASSET_EMIT:
+    spec.Assets++
+    spec.Bytes += reportAssetSize(e.Asset)
+  }
+}
+
+
+/*
+  reportAssetSize returns the size of an Asset's content, from its
+  content in memory, or its source file, without reading it.
+*/
+func reportAssetSize (a *Asset) int64 {
+  content_bytes, content_data := a.ContentSize()
+  switch {
+  case content_bytes > 0:
+    return content_bytes
+  case content_data > 0:
+    return content_data
+  case a.FileSource != "":
+    if info, err := os.Stat(a.FileSource); err == nil {
+      return info.Size()
+    }
+  }
+  return 0
+}
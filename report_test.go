@@ -0,0 +1,115 @@
+package interbuilder
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "testing"
+)
+
+
+func TestSpecRunReport (t *testing.T) {
+  var state_dir = t.TempDir()
+
+  var makeRoot = func (resume bool) *Spec {
+    var root = NewSpec("root", nil)
+    root.Props["quiet"]     = true
+    root.Props["fail_fast"] = false
+    root.Props["state_dir"] = state_dir
+    root.Props["resume"]    = resume
+
+    var site = root.AddSubspec(NewSpec("site", nil))
+    site.EnqueueTask(& Task {
+      Name:     "optional",
+      Func:     func (*Spec, *Task) error { return nil },
+      SkipFunc: func (*Spec, *Task) (bool, error) { return true, nil },
+    })
+    site.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+      for key, content := range map[string]string { "a.txt": "hello", "b.txt": "world!" } {
+        var asset = s.MakeAsset(key)
+        asset.SetContentBytes([]byte(content))
+        if err := tk.EmitAsset(asset); err != nil {
+          return err
+        }
+      }
+      return nil
+    })
+
+    var broken = root.AddSubspec(NewSpec("broken", nil))
+    broken.EnqueueTaskFunc("fail", func (*Spec, *Task) error {
+      return errors.New("Broken task")
+    })
+
+    root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+      return tk.PoolSpecInputAssets()
+    })
+    return root
+  }
+
+  var specReport = func (report *BuildReport, path string) SpecBuildReport {
+    for _, spec_report := range report.Specs {
+      if spec_report.Path == path {
+        return spec_report
+      }
+    }
+    t.Fatalf("Expected a report of spec %s in %v", path, report.Specs)
+    return SpecBuildReport {}
+  }
+
+  report, err := makeRoot(false).RunReport(context.Background())
+  if err == nil {
+    t.Fatal("Expected the run to fail")
+  }
+  if report == nil {
+    t.Fatal("Expected a report of the failed run")
+  }
+
+  if report.Spec != "root" || report.Status != "failed" || report.Error != err.Error() {
+    t.Errorf("Unexpected report of the root: %+v", report)
+  }
+  if len(report.Specs) != 3 {
+    t.Errorf("Expected reports of three specs, got %d", len(report.Specs))
+  }
+
+  var site = specReport(report, "root/site")
+  if site.Status != "succeeded" || site.Cached || site.Assets != 2 || site.Bytes != 11 {
+    t.Errorf("Unexpected report of spec site: %+v", site)
+  }
+  if site.Duration <= 0 {
+    t.Errorf("Expected spec site to have a duration, got %v", site.Duration)
+  }
+  if len(site.Tasks) != 2 || !site.Tasks[0].Skipped || site.Tasks[1].Name != "emit" || site.Tasks[1].Skipped {
+    t.Errorf("Expected the skipped task optional, then emit, got %+v", site.Tasks)
+  }
+
+  var broken = specReport(report, "root/broken")
+  if broken.Status != "failed" || broken.Error == "" {
+    t.Errorf("Expected spec broken to have failed with an error, got %+v", broken)
+  }
+  if len(broken.Tasks) != 1 || broken.Tasks[0].Error == "" {
+    t.Errorf("Expected the failed task of spec broken to have an error, got %+v", broken.Tasks)
+  }
+
+  // The report can be encoded as JSON
+  //
+  report_json, err := json.Marshal(report)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var decoded BuildReport
+  if err := json.Unmarshal(report_json, &decoded); err != nil {
+    t.Fatal(err)
+  }
+  if got := specReport(&decoded, "root/site"); got.Assets != 2 || got.Tasks[1].Duration != site.Tasks[1].Duration {
+    t.Errorf("Expected the decoded report to match, got %+v", got)
+  }
+
+  // A spec resumed from its checkpoint is cached, and does not
+  // run its tasks
+  //
+  report, _ = makeRoot(true).RunReport(context.Background())
+  site = specReport(report, "root/site")
+  if !site.Cached || site.Assets != 2 || len(site.Tasks) != 0 {
+    t.Errorf("Expected spec site to be cached, got %+v", site)
+  }
+}
@@ -0,0 +1,171 @@
+package interbuilder
+
+import (
+  "fmt"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "runtime"
+  "strings"
+)
+
+
+/*
+  CommandSandbox describes the restrictions placed on the commands
+  ran by a Task, from the inherited "sandbox_network" and
+  "sandbox_read_only" props. Along with the "clean_env" prop, it
+  keeps the build scripts of untrusted projects from reaching the
+  network or writing outside of where they are allowed to.
+*/
+type CommandSandbox struct {
+  Network   bool
+  ReadOnly  []string
+}
+
+
+/*
+  Restricted returns whether the sandbox places any restriction on
+  commands.
+*/
+func (sb *CommandSandbox) Restricted () bool {
+  return !sb.Network || len(sb.ReadOnly) > 0
+}
+
+
+/*
+  CommandSandbox reads the sandbox of the commands ran by this
+  Task's Spec from its inherited props:
+
+    - sandbox_network:   If false, commands have no network access,
+      other than a loopback interface of their own. Defaults to
+      true.
+    - sandbox_read_only: If true, the source_dir is mounted
+      read-only for commands. May also be an array of paths,
+      relative to source_dir, which are mounted read-only.
+*/
+func (t *Task) CommandSandbox () (*CommandSandbox, error) {
+  var sandbox = & CommandSandbox { Network: true }
+  if t.Spec == nil {
+    return sandbox, nil
+  }
+
+  var s = t.Spec
+
+  if network, ok, found := s.InheritPropBool("sandbox_network"); found && !ok {
+    prop, _ := s.InheritProp("sandbox_network")
+    return nil, fmt.Errorf("Prop \"sandbox_network\" in spec %s is expected to be a boolean, got %T", s.Name, prop)
+  } else if found {
+    sandbox.Network = network
+  }
+
+  read_only_any, found := s.InheritProp("sandbox_read_only")
+  if !found || read_only_any == nil {
+    return sandbox, nil
+  }
+
+  var read_only []string
+  switch value := read_only_any.(type) {
+  case bool:
+    if value {
+      read_only = []string { "." }
+    }
+  case []any:
+    for _, path_any := range value {
+      path, ok := path_any.(string)
+      if !ok || path == "" {
+        return nil, fmt.Errorf("Prop \"sandbox_read_only\" in spec %s is expected to be a boolean or an array of paths, got %T in its array", s.Name, path_any)
+      }
+      read_only = append(read_only, path)
+    }
+  default:
+    return nil, fmt.Errorf("Prop \"sandbox_read_only\" in spec %s is expected to be a boolean or an array of paths, got %T", s.Name, read_only_any)
+  }
+
+  if len(read_only) == 0 {
+    return sandbox, nil
+  }
+
+  source_dir, _, _ := s.InheritPropString("source_dir")
+  for _, path := range read_only {
+    if !filepath.IsAbs(path) {
+      path = filepath.Join(source_dir, path)
+    }
+    abs_path, err := filepath.Abs(path)
+    if err != nil { return nil, err }
+    sandbox.ReadOnly = append(sandbox.ReadOnly, abs_path)
+  }
+
+  return sandbox, nil
+}
+
+
+/*
+  Apply wraps a command so that it runs in the sandbox, using
+  unshare(1) to run it in new user, network, and mount namespaces.
+  Read-only paths are bind-mounted over themselves and remounted
+  read-only before the command is executed. This is only
+  supported on Linux, with unprivileged user namespaces enabled;
+  elsewhere, the command fails rather than run unrestricted.
+*/
+func (sb *CommandSandbox) Apply (cmd *exec.Cmd) error {
+  if !sb.Restricted() || cmd.Err != nil {
+    return nil
+  }
+  if runtime.GOOS != "linux" {
+    return fmt.Errorf("Command sandboxing is not supported on %s", runtime.GOOS)
+  }
+
+  unshare, err := exec.LookPath("unshare")
+  if err != nil {
+    return fmt.Errorf("Command sandboxing requires unshare: %w", err)
+  }
+
+  // The command is ran by the path it was resolved to, as its
+  // environment may not have a PATH
+  //
+  var command = append([]string { cmd.Path }, cmd.Args[1:]...)
+
+  var args = []string { unshare, "--user", "--map-current-user" }
+  if !sb.Network {
+    args = append(args, "--net")
+  }
+
+  if len(sb.ReadOnly) == 0 {
+    args = append(args, "--")
+    cmd.Args = append(args, command...)
+    cmd.Path = unshare
+    return nil
+  }
+
+  mount, err := exec.LookPath("mount")
+  if err != nil {
+    return fmt.Errorf("Command sandboxing requires mount: %w", err)
+  }
+
+  // The working directory is entered again once the mounts are
+  // made, as the process would otherwise keep writing to the
+  // directory beneath them
+  //
+  var dir = cmd.Dir
+  if dir == "" {
+    if dir, err = os.Getwd(); err != nil { return err }
+  }
+  if dir, err = filepath.Abs(dir); err != nil {
+    return err
+  }
+
+  var script []string
+  for _, path := range sb.ReadOnly {
+    var quoted = ShellQuote(path)
+    script = append(script,
+      ShellQuote(mount) + " --bind " + quoted + " " + quoted,
+      ShellQuote(mount) + " -o remount,bind,ro " + quoted,
+    )
+  }
+  script = append(script, "cd " + ShellQuote(dir), `exec "$@"`)
+
+  args = append(args, "--mount", "--", "/bin/sh", "-c", strings.Join(script, " && "), "sh")
+  cmd.Args = append(args, command...)
+  cmd.Path = unshare
+  return nil
+}
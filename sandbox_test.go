@@ -0,0 +1,75 @@
+package interbuilder
+
+import (
+  "fmt"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+
+func TestTaskCommandSandbox (t *testing.T) {
+  if err := exec.Command("unshare", "--user", "--map-current-user", "--net", "--mount", "true").Run(); err != nil {
+    t.Skip("Namespaces are not available:", err)
+  }
+
+  var root *Spec = NewSpec("root", nil)
+  var site *Spec = root.AddSubspec(NewSpec("site", nil))
+
+  root.Props["quiet"]           = true
+  root.Props["sandbox_network"] = false
+  site.Props["source_dir"]      = t.TempDir()
+
+  if err := os.Mkdir(filepath.Join(site.Props["source_dir"].(string), "public"), 0o755); err != nil {
+    t.Fatal(err)
+  }
+
+  var run = func (tk *Task, script string) (string, error) {
+    var output strings.Builder
+    cmd := tk.Command("sh", "-c", script)
+    cmd.Stdout = &output
+    cmd.Stderr = &output
+    err := cmd.Run()
+    return strings.TrimSpace(output.String()), err
+  }
+
+  site.EnqueueTaskFunc("sandboxed", func (s *Spec, tk *Task) error {
+    // Without a network, only a loopback interface is listed
+    //
+    interfaces, err := run(tk, "tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d ' '")
+    if err != nil {
+      return fmt.Errorf("Error listing network interfaces: %w: %s", err, interfaces)
+    }
+    if interfaces != "lo" {
+      return fmt.Errorf("Expected only a loopback interface, got %q", interfaces)
+    }
+
+    // Read-only paths are relative to source_dir, and the rest of
+    // it is still writable
+    //
+    s.Props["sandbox_read_only"] = []any { "public" }
+    if output, err := run(tk, "touch built && touch public/built"); err == nil {
+      return fmt.Errorf("Expected public/ to be read-only")
+    } else if exists, _ := s.PathExists("built"); !exists {
+      return fmt.Errorf("Expected source_dir to be writable: %s", output)
+    }
+
+    s.Props["sandbox_read_only"] = true
+    if _, err := run(tk, "touch index.html"); err == nil {
+      return fmt.Errorf("Expected source_dir to be read-only")
+    }
+
+    s.Props["sandbox_read_only"] = "yes"
+    if _, err := run(tk, "true"); err == nil || !strings.Contains(err.Error(), "sandbox_read_only") {
+      return fmt.Errorf("Expected an error from an invalid sandbox_read_only prop, got %v", err)
+    }
+
+    return nil
+  })
+
+  if err := root.Run(); err != nil {
+    t.Fatal(err)
+  }
+}
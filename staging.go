@@ -0,0 +1,196 @@
+package interbuilder
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+)
+
+
+/*
+  StagingDir returns a temporary directory, shared by the Spec
+  tree, for staging Asset content to disk. It is created on first
+  use, and removed when the root Spec finishes running.
+*/
+func (s *Spec) StagingDir () (string, error) {
+  var root = s.Root
+  if root == nil {
+    root = s
+  }
+
+  root.staging_lock.Lock()
+  defer root.staging_lock.Unlock()
+
+  if root.staging_dir != "" {
+    return root.staging_dir, nil
+  }
+
+  staging_dir, err := os.MkdirTemp("", "interbuilder-staging-")
+  if err != nil {
+    return "", fmt.Errorf("Error creating asset staging directory: %w", err)
+  }
+
+  root.staging_dir = staging_dir
+  return staging_dir, nil
+}
+
+
+/*
+  removeStagingDir removes this Spec's staging directory, if it
+  was created.
+*/
+func (s *Spec) removeStagingDir () error {
+  s.staging_lock.Lock()
+  defer s.staging_lock.Unlock()
+
+  if s.staging_dir == "" {
+    return nil
+  }
+
+  err := os.RemoveAll(s.staging_dir)
+  s.staging_dir = ""
+  return err
+}
+
+
+/*
+  Stage releases a singular Asset's in-memory byte content,
+  writing it to a file in dir if it cannot otherwise be read
+  again, such as when it is modified. The content is read back
+  lazily the next time it is requested. Assets whose unmodified
+  content can be re-read from their FileSource only have their
+  cache cleared. Multi-assets, assets without byte content, and
+  assets with content data are not staged. Returns whether the
+  asset was staged.
+*/
+func (a *Asset) Stage (dir string) (bool, error) {
+  if !a.IsSingle() || a.ContentBytes == nil || a.ContentData != nil {
+    return false, nil
+  }
+
+  // Unmodified file content can be rehydrated from its source
+  //
+  if !a.ContentModified && a.FileSource != "" && a.content_bytes_get_reader_func != nil {
+    a.ContentBytes = nil
+    return true, nil
+  }
+
+  // The content is staged encoded, as it is decoded when read
+  // back through the reader function
+  //
+  content, err := a.EncodedContentBytes()
+  if err != nil {
+    return false, fmt.Errorf("Error staging asset %s: %w", a.Url, err)
+  }
+
+  file, err := os.CreateTemp(dir, "asset-*" + filepath.Ext(a.Url.Path))
+  if err != nil {
+    return false, fmt.Errorf("Error staging asset %s: %w", a.Url, err)
+  }
+  defer file.Close()
+
+  if _, err := file.Write(content); err != nil {
+    return false, fmt.Errorf("Error staging asset %s: %w", a.Url, err)
+  }
+
+  var staged_path = file.Name()
+
+  // Read the whole file, rather than returning it as the reader,
+  // so that it is closed
+  //
+  err = a.SetContentBytesGetReaderFunc(func (*Asset) (io.Reader, error) {
+    content, err := os.ReadFile(staged_path)
+    if err != nil {
+      return nil, fmt.Errorf("Error reading staged asset content: %w", err)
+    }
+    return bytes.NewReader(content), nil
+  })
+  if err != nil {
+    return false, err
+  }
+
+  a.ContentBytes = nil
+  return true, nil
+}
+
+
+/*
+  stageAsset stages an Asset's content, as in Asset.Stage, in this
+  Spec's StagingDir, or in its ContentStore if it has one, as in
+  Asset.StoreContent, so that identical content is only written
+  once. Returns whether the asset was staged.
+*/
+func (s *Spec) stageAsset (a *Asset) (bool, error) {
+  cs, err := s.ContentStore()
+  if err != nil {
+    return false, err
+  }
+
+  // Unmodified file content is rehydrated from its source by
+  // Stage, rather than stored
+  //
+  var from_source = !a.ContentModified && a.FileSource != "" && a.content_bytes_get_reader_func != nil
+  if cs != nil && !from_source {
+    return a.StoreContent(cs)
+  }
+
+  staging_dir, err := s.StagingDir()
+  if err != nil {
+    return false, err
+  }
+  return a.Stage(staging_dir)
+}
+
+
+/*
+  assetBufferLimit returns the number of Assets this Task may hold
+  in memory before staging them to disk, from the inherited
+  "asset_buffer_limit" prop. Zero means there is no limit.
+*/
+func (tk *Task) assetBufferLimit () int {
+  if tk.AssetBufferLimit != 0 || tk.Spec == nil {
+    return tk.AssetBufferLimit
+  }
+
+  limit, _, _ := tk.Spec.InheritPropInt("asset_buffer_limit")
+  return limit
+}
+
+
+/*
+  bufferAsset appends an Asset to this Task's buffer. If the
+  buffer exceeds the Task's asset buffer limit, the Asset's
+  content is staged to disk. If the Spec tree holds more Asset
+  content than its memory budget, the buffer's Assets are evicted
+  until it does not, see Task.collectAssetMemory.
+*/
+func (tk *Task) bufferAsset (a *Asset) error {
+  tk.lockAssets()
+  defer tk.unlockAssets()
+
+  tk.Assets = append(tk.Assets, a)
+  if tk.Spec == nil {
+    return nil
+  }
+
+  var limit = tk.assetBufferLimit()
+  if limit > 0 && len(tk.Assets) > limit {
+    if _, err := tk.Spec.stageAsset(a); err != nil {
+      return err
+    }
+  }
+
+  content_bytes, content_data := a.ContentSize()
+  tk.held_assets += 1
+  tk.held_bytes  += content_bytes
+  tk.held_data   += content_data
+  tk.Spec.addMemory(1, content_bytes, content_data)
+
+  budget, err := tk.Spec.memoryBudget()
+  if err != nil || budget == 0 || tk.Spec.MemoryTotal() <= budget {
+    return err
+  }
+  return tk.collectAssetMemory(budget)
+}
@@ -0,0 +1,66 @@
+package interbuilder
+
+import (
+  "testing"
+  "fmt"
+  "os"
+)
+
+
+func TestTaskAssetBufferStaging (t *testing.T) {
+  var root = NewSpec("root", nil)
+  root.Props["quiet"] = true
+  root.Props["asset_buffer_limit"] = float64(2)
+
+  const num_assets = 5
+
+  root.EnqueueTaskFunc("produce", func (s *Spec, tk *Task) error {
+    for i := 0 ; i < num_assets ; i++ {
+      var asset = s.MakeAsset(fmt.Sprintf("%d.txt", i))
+      if err := asset.SetContentBytes([]byte(fmt.Sprintf("content %d", i))); err != nil {
+        return err
+      }
+      if err := tk.EmitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+
+  var staging_dir string
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    var err error
+    if staging_dir, err = s.StagingDir(); err != nil {
+      return err
+    }
+
+    for i, asset := range tk.Assets {
+      // Assets beyond the limit are not held in memory
+      //
+      if in_memory, expect := asset.ContentBytes != nil, i < 2; in_memory != expect {
+        return fmt.Errorf("Expected asset %d to be in memory: %t, got %t", i, expect, in_memory)
+      }
+
+      content, err := asset.GetContentBytes()
+      if err != nil {
+        return err
+      }
+      if got, expect := string(content), fmt.Sprintf("content %d", i); got != expect {
+        return fmt.Errorf("Expected asset %d content %q, got %q", i, expect, got)
+      }
+    }
+
+    if len(tk.Assets) != num_assets {
+      return fmt.Errorf("Expected %d assets, got %d", num_assets, len(tk.Assets))
+    }
+
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if _, err := os.Stat(staging_dir); !os.IsNotExist(err) {
+    t.Errorf("Expected the staging directory to be removed after running, got %v", err)
+  }
+}
@@ -0,0 +1,141 @@
+package interbuilder
+
+import (
+  "fmt"
+  "strings"
+  "time"
+)
+
+
+/*
+  A StallError is returned when a Spec waits longer than its stall
+  timeout for input from its subspecs. Waiting describes the
+  subspecs which had not finished, and what they were doing.
+*/
+type StallError struct {
+  Spec     string
+  Timeout  time.Duration
+  Waiting  []string
+}
+
+
+func (e *StallError) Error () string {
+  if len(e.Waiting) == 0 {
+    return fmt.Sprintf("Spec %s received no input for %v", e.Spec, e.Timeout)
+  }
+  return fmt.Sprintf(
+    "Spec %s received no input for %v, waiting on:\n\t%s",
+    e.Spec, e.Timeout, strings.Join(e.Waiting, "\n\t"),
+  )
+}
+
+
+func (e *StallError) Is (target error) bool {
+  return target == ErrStall
+}
+
+
+/*
+  stallTimeout returns the longest time this Spec waits for input
+  without receiving any, from the inherited "stall_timeout" prop,
+  as a number of seconds or a duration string such as "90s".
+  Zero means there is no limit.
+*/
+func (s *Spec) stallTimeout () (time.Duration, error) {
+  timeout_any, found := s.InheritProp("stall_timeout")
+  if !found {
+    return 0, nil
+  }
+
+  var timeout time.Duration
+
+  switch value := timeout_any.(type) {
+    case string:
+      var err error
+      if timeout, err = time.ParseDuration(value); err != nil {
+        return 0, fmt.Errorf("Prop \"stall_timeout\" in Spec %s: %w", s.Name, err)
+      }
+    case float64:
+      timeout = time.Duration(value * float64(time.Second))
+    case int:
+      timeout = time.Duration(value) * time.Second
+    default:
+      return 0, fmt.Errorf(
+        "Prop \"stall_timeout\" in Spec %s is expected to be a number of seconds or a duration string, got %T",
+        s.Name, timeout_any,
+      )
+  }
+
+  if timeout < 0 {
+    return 0, fmt.Errorf("Prop \"stall_timeout\" in Spec %s is negative", s.Name)
+  }
+  return timeout, nil
+}
+
+
+/*
+  newStallTimer returns a timer for a stall timeout, or nil if the
+  timeout is zero. The channel of a nil timer, from stallChan,
+  never receives.
+*/
+func newStallTimer (timeout time.Duration) *time.Timer {
+  if timeout == 0 {
+    return nil
+  }
+  return time.NewTimer(timeout)
+}
+
+
+func stallChan (timer *time.Timer) <-chan time.Time {
+  if timer == nil {
+    return nil
+  }
+  return timer.C
+}
+
+
+func resetStallTimer (timer *time.Timer, timeout time.Duration) {
+  if timer == nil {
+    return
+  }
+  if !timer.Stop() {
+    select {
+    case <-timer.C:
+    default:
+    }
+  }
+  timer.Reset(timeout)
+}
+
+
+/*
+  stallError describes the unfinished subspecs of this Spec, and
+  their descendants, for a StallError.
+*/
+func (s *Spec) stallError (timeout time.Duration) *StallError {
+  var waiting []string
+
+  for _, report := range s.StatusReport() {
+    if report.Spec == s || report.Status == SPEC_STATUS_SUCCEEDED {
+      continue
+    }
+
+    var description = fmt.Sprintf("%s: %s", report.Path, report.Status)
+
+    if report.Status == SPEC_STATUS_RUNNING {
+      report.Spec.task_queue_lock.Lock()
+      task := report.Spec.CurrentTask
+      report.Spec.task_queue_lock.Unlock()
+
+      if task != nil {
+        description += fmt.Sprintf(", in task %s", task.Name)
+      } else if len(report.Spec.Subspecs) > 0 {
+        description += ", waiting on subspecs"
+      }
+    }
+
+    waiting = append(waiting, description)
+  }
+
+  return & StallError { Spec: s.Name, Timeout: timeout, Waiting: waiting }
+}
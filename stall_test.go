@@ -0,0 +1,59 @@
+package interbuilder
+
+import (
+  "testing"
+  "errors"
+  "strings"
+)
+
+
+func TestSpecStallTimeout (t *testing.T) {
+  // A task pooling input, and a spec waiting on its subspecs,
+  // both time out
+  //
+  for _, pool := range []bool { true, false } {
+    var root = NewSpec("root", nil)
+    root.Props["quiet"]         = true
+    root.Props["stall_timeout"] = "50ms"
+
+    root.AddSubspec(NewSpec("slow", nil)).
+      EnqueueTaskFunc("block", func (s *Spec, tk *Task) error {
+        <-tk.Context().Done()
+        return nil
+      })
+
+    if pool {
+      root.EnqueueTask(& Task {
+        Name: "pool",
+        Mask: TASK_ASSETS_CONSUME,
+        Func: func (s *Spec, tk *Task) error {
+          return tk.PoolSpecInputAssets()
+        },
+      })
+    }
+
+    var err error
+    TestWrapTimeout(t, func () { err = root.Run() })
+
+    var stall_error *StallError
+    if !errors.Is(err, ErrStall) || !errors.As(err, &stall_error) {
+      t.Fatalf("Expected a StallError with pool=%v, got %v", pool, err)
+    }
+
+    if got := strings.Join(stall_error.Waiting, "; "); got != "root/slow: running, in task block" {
+      t.Errorf("Unexpected stall diagnostics with pool=%v: %s", pool, got)
+    }
+  }
+
+  // Invalid timeouts are errors
+  //
+  var invalid = NewSpec("invalid", nil)
+  invalid.Props["quiet"]         = true
+  invalid.Props["stall_timeout"] = "soon"
+
+  TestWrapTimeout(t, func () {
+    if err := invalid.Run(); err == nil {
+      t.Error("Expected an error from an invalid stall_timeout")
+    }
+  })
+}
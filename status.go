@@ -0,0 +1,110 @@
+package interbuilder
+
+import (
+  "context"
+  "errors"
+  "sort"
+)
+
+
+type SpecStatus int
+
+const (
+  SPEC_STATUS_PENDING SpecStatus = iota
+  SPEC_STATUS_RUNNING
+  SPEC_STATUS_SUCCEEDED
+  SPEC_STATUS_FAILED
+  SPEC_STATUS_CANCELLED
+)
+
+
+func (ss SpecStatus) String () string {
+  switch ss {
+    case SPEC_STATUS_PENDING:   return "pending"
+    case SPEC_STATUS_RUNNING:   return "running"
+    case SPEC_STATUS_SUCCEEDED: return "succeeded"
+    case SPEC_STATUS_FAILED:    return "failed"
+    case SPEC_STATUS_CANCELLED: return "cancelled"
+  }
+  return "unknown"
+}
+
+
+/*
+  A SpecReport is the outcome of a Spec's run. Err is the error
+  the Spec's run returned, if any.
+*/
+type SpecReport struct {
+  Path    string
+  Spec    *Spec
+  Status  SpecStatus
+  Err     error
+}
+
+
+/*
+  Status returns the status of this Spec's most recent run, and
+  the error it returned.
+*/
+func (s *Spec) Status () (SpecStatus, error) {
+  s.status_lock.Lock()
+  defer s.status_lock.Unlock()
+  return s.status, s.status_err
+}
+
+
+func (s *Spec) setStatus (status SpecStatus, err error) {
+  s.status_lock.Lock()
+  defer s.status_lock.Unlock()
+  s.status     = status
+  s.status_err = err
+}
+
+
+/*
+  finishStatus sets the status of a finished run. A failed run is
+  considered cancelled if the context it was given by its caller
+  was cancelled, such as by a failing sibling Spec, or if it was
+  cancelled with Spec.Cancel.
+*/
+func (s *Spec) finishStatus (ctx context.Context, err error) {
+  switch {
+    case err == nil:
+      s.setStatus(SPEC_STATUS_SUCCEEDED, nil)
+    case ctx.Err() != nil, errors.Is(err, ErrCancelled):
+      s.setStatus(SPEC_STATUS_CANCELLED, err)
+    default:
+      s.setStatus(SPEC_STATUS_FAILED, err)
+  }
+}
+
+
+/*
+  StatusReport returns the status of this Spec and each of its
+  descendants, ordered by their SpecPath.
+*/
+func (s *Spec) StatusReport () []SpecReport {
+  var reports []SpecReport
+
+  var walk func (*Spec)
+  walk = func (spec *Spec) {
+    status, err := spec.Status()
+    reports = append(reports, SpecReport {
+      Path:   spec.SpecPath(),
+      Spec:   spec,
+      Status: status,
+      Err:    err,
+    })
+
+    for _, subspec := range spec.Subspecs {
+      walk(subspec)
+    }
+  }
+  walk(s)
+
+  sort.Slice(reports, func (i, j int) bool {
+    return reports[i].Path < reports[j].Path
+  })
+
+  return reports
+}
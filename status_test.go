@@ -0,0 +1,150 @@
+package interbuilder
+
+import (
+  "testing"
+  "errors"
+  "fmt"
+  "strings"
+)
+
+
+func TestSpecContinueOnError (t *testing.T) {
+  var root = NewSpec("root", nil)
+  root.Props["quiet"]     = true
+  root.Props["fail_fast"] = false
+
+  var a      = root.AddSubspec(NewSpec("a", nil))
+  var b      = root.AddSubspec(NewSpec("b", nil))
+  var c      = root.AddSubspec(NewSpec("c", nil))
+  var c_leaf = c.AddSubspec(NewSpec("leaf", nil))
+
+  var a_failed = make(chan bool)
+
+  a.EnqueueTaskFunc("fail", func (*Spec, *Task) error {
+    defer close(a_failed)
+    return fmt.Errorf("Error in a")
+  })
+
+  c_leaf.EnqueueTaskFunc("fail", func (*Spec, *Task) error {
+    return fmt.Errorf("Error in leaf")
+  })
+
+  // The sibling of a failed subspec finishes, after the failure
+  //
+  b.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    <-a_failed
+    if err := tk.Context().Err(); err != nil {
+      return err
+    }
+    return s.EmitAsset(s.MakeAsset("b"))
+  })
+
+  var received []string
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    for asset := range s.Input {
+      received = append(received, asset.Url.Path)
+    }
+    return nil
+  })
+
+  var err error
+  TestWrapTimeout(t, func () { err = root.Run() })
+
+  if err == nil {
+    t.Fatal("Expected an error")
+  }
+  for _, expect := range []string { "Error in a", "Error in leaf" } {
+    if !strings.Contains(err.Error(), expect) {
+      t.Errorf("Expected the error to contain %q, got: %v", expect, err)
+    }
+  }
+
+  if fmt.Sprint(received) != "[@emit/b]" {
+    t.Errorf("Expected the root to receive the asset of b, got %v", received)
+  }
+
+  var statuses []string
+  for _, report := range root.StatusReport() {
+    statuses = append(statuses, fmt.Sprintf("%s=%s", report.Path, report.Status))
+  }
+
+  if got, expect := strings.Join(statuses, " "), "root=failed root/a=failed root/b=succeeded root/c=failed root/c/leaf=failed"; got != expect {
+    t.Errorf("Expected statuses:\n%s\ngot:\n%s", expect, got)
+  }
+
+  // By default, failures cancel sibling specs
+  //
+  var fast = NewSpec("fast", nil)
+  fast.Props["quiet"] = true
+
+  fast.AddSubspec(NewSpec("fail", nil)).
+    EnqueueTaskFunc("fail", func (*Spec, *Task) error {
+      return fmt.Errorf("Expected error")
+    })
+
+  var waiting = fast.AddSubspec(NewSpec("wait", nil))
+  waiting.EnqueueTaskFunc("wait", func (s *Spec, tk *Task) error {
+    <-tk.Context().Done()
+    return tk.Context().Err()
+  })
+
+  TestWrapTimeout(t, func () {
+    if err := fast.Run(); err == nil {
+      t.Error("Expected an error")
+    }
+  })
+
+  if status, _ := waiting.Status(); status != SPEC_STATUS_CANCELLED {
+    t.Errorf("Expected the sibling of a failing spec to be cancelled, got %s", status)
+  }
+}
+
+
+func TestSpecCancel (t *testing.T) {
+  var root = NewSpec("root", nil)
+  root.Props["quiet"]     = true
+  root.Props["fail_fast"] = false
+
+  var slow  = root.AddSubspec(NewSpec("slow", nil))
+  var other = root.AddSubspec(NewSpec("other", nil))
+
+  if slow.Cancel() {
+    t.Error("Expected a spec which is not running not to be cancelled")
+  }
+
+  var started = make(chan struct{})
+  slow.EnqueueTaskFunc("wait", func (s *Spec, tk *Task) error {
+    close(started)
+    <-tk.Context().Done()
+    return tk.Context().Err()
+  })
+
+  other.EnqueueTaskFunc("emit", func (s *Spec, tk *Task) error {
+    <-started
+    return s.EmitAsset(s.MakeAsset("other"))
+  })
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    <-started
+    if !slow.Cancel() {
+      return fmt.Errorf("Expected the running spec to be cancelled")
+    }
+    return tk.PoolSpecInputAssets()
+  })
+
+  var err error
+  TestWrapTimeout(t, func () { err = root.Run() })
+
+  if !errors.Is(err, ErrCancelled) {
+    t.Errorf("Expected the run to return a cancellation error, got: %v", err)
+  }
+
+  var statuses []string
+  for _, report := range root.StatusReport() {
+    statuses = append(statuses, fmt.Sprintf("%s=%s", report.Path, report.Status))
+  }
+  if got, expect := strings.Join(statuses, " "), "root=cancelled root/other=succeeded root/slow=cancelled"; got != expect {
+    t.Errorf("Expected statuses:\n%s\ngot:\n%s", expect, got)
+  }
+}
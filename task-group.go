@@ -0,0 +1,94 @@
+package interbuilder
+
+import (
+  "fmt"
+  "errors"
+  "sync"
+)
+
+
+/*
+  NewTaskGroup creates a Task which runs its member Tasks
+  concurrently, and finishes once all members have finished,
+  acting as a barrier in the Task queue: the next Task does not
+  start until the whole group completes. This allows for
+  fan-out/fan-in within a Spec.
+
+  Because members run concurrently, they cannot safely share an
+  asset buffer or modify the Task queue, so each member must have
+  a Func and a defined Task Mask which neither consumes Assets nor
+  modifies the queue. Members may emit Assets, which are sent to
+  the Tasks after the group; those Tasks' asset buffers are
+  locked while the group runs, but their MapFuncs may be called
+  concurrently, and must be safe to do so. Assets emitted to the
+  group itself are forwarded once all members have finished. If
+  any members return errors, they are joined into the error
+  returned by the group.
+*/
+func NewTaskGroup (name string, members ...*Task) (*Task, error) {
+  for _, member := range members {
+    if member.Func == nil {
+      return nil, fmt.Errorf("Cannot add task %s to task group %s, member tasks require a Func", member.Name, name)
+    }
+
+    const rejected_mask = (TASK_ASSETS_CONSUME | TASK_TASKS_QUEUE) &^ TASK_MASK_DEFINED
+
+    if member.Mask == 0 || member.Mask & rejected_mask != 0 {
+      return nil, fmt.Errorf(
+        "Cannot add task %s to task group %s, member tasks require a defined Task Mask which does not consume assets or modify the task queue, got %s",
+        member.Name, name, member.Mask,
+      )
+    }
+  }
+
+  var group = & Task {
+    Name:    name,
+    Members: members,
+  }
+
+  group.Func = func (s *Spec, tk *Task) error {
+    var wait_group sync.WaitGroup
+    var errs = make([]error, len(tk.Members))
+
+    // Members emit to the Tasks after this group concurrently, so
+    // guard their asset buffers. This is done before any members
+    // run, so the locks themselves are not raced.
+    //
+    s.task_queue_lock.Lock()
+    for next := tk.Next ; next != nil ; next = next.Next {
+      if next.assets_lock == nil {
+        next.assets_lock = new(sync.Mutex)
+      }
+    }
+    s.task_queue_lock.Unlock()
+
+    for member_i, member := range tk.Members {
+      member.Spec    = s
+      member.Next    = tk.Next
+      member.context = tk.context
+
+      wait_group.Add(1)
+      go func () {
+        defer wait_group.Done()
+        member.startTiming()
+        defer member.finishTiming()
+        if err := member.Run(s); err != nil {
+          errs[member_i] = fmt.Errorf("Error in task %s: %w", member.Name, err)
+        }
+      }()
+    }
+
+    wait_group.Wait()
+
+    if err := errors.Join(errs...); err != nil {
+      return fmt.Errorf("Error in task group %s: %w", tk.Name, err)
+    }
+
+    if len(tk.Assets) == 0 {
+      return nil
+    }
+    return tk.ForwardAssets()
+  }
+
+  return group, nil
+}
@@ -0,0 +1,106 @@
+package interbuilder
+
+import (
+  "testing"
+  "fmt"
+  "sync"
+  "sync/atomic"
+)
+
+
+func TestTaskGroup (t *testing.T) {
+  var root *Spec = NewSpec("root", nil)
+  root.Props["quiet"] = true
+
+  const num_members = 3
+
+  // Each member waits for all members to start before finishing,
+  // which only completes if the members run concurrently
+  //
+  var started   sync.WaitGroup
+  var finished  atomic.Int32
+  started.Add(num_members)
+
+  var members []*Task
+
+  for i := 0 ; i < num_members ; i++ {
+    members = append(members, & Task {
+      Name: fmt.Sprintf("member-%d", i),
+      Mask: TASK_ASSETS_GENERATE,
+      Func: func (s *Spec, tk *Task) error {
+        started.Done()
+        started.Wait()
+
+        asset := s.MakeAsset(tk.Name)
+        asset.SetContentBytes([]byte(tk.Name))
+        if err := tk.EmitAsset(asset); err != nil {
+          return err
+        }
+
+        finished.Add(1)
+        return nil
+      },
+    })
+  }
+
+  group, err := NewTaskGroup("group", members...)
+  if err != nil { t.Fatal(err) }
+
+  // Emit an asset into the group, which the group forwards
+  //
+  root.EnqueueTaskFunc("produce", func (s *Spec, tk *Task) error {
+    asset := s.MakeAsset("produced")
+    asset.SetContentBytes([]byte("produced"))
+    return tk.EmitAsset(asset)
+  })
+
+  root.EnqueueTask(group)
+
+  var consumed []*Asset
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    if got := finished.Load(); got != num_members {
+      return fmt.Errorf("Task after group started with %d of %d members finished", got, num_members)
+    }
+    consumed = tk.Assets
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if got, expect := len(consumed), num_members + 1; got != expect {
+    t.Errorf("Expected the task after the group to receive %d assets, got %d", expect, got)
+  }
+
+  // Member errors are returned by the group
+  //
+  var error_spec *Spec = NewSpec("error-spec", nil)
+  error_spec.Props["quiet"] = true
+
+  error_group, err := NewTaskGroup("error-group",
+    & Task { Name: "ok",   Mask: TASK_MASK_DEFINED, Func: func (*Spec, *Task) error { return nil } },
+    & Task { Name: "fail", Mask: TASK_MASK_DEFINED, Func: func (*Spec, *Task) error { return fmt.Errorf("failure") } },
+  )
+  if err != nil { t.Fatal(err) }
+  error_spec.EnqueueTask(error_group)
+
+  if err := error_spec.Run(); err == nil {
+    t.Errorf("Expected an error from a task group with a failing member")
+  }
+
+  // Members which may consume assets or modify the task queue
+  // are rejected
+  //
+  var noop = func (*Spec, *Task) error { return nil }
+
+  for _, member := range []*Task {
+    { Name: "undefined-mask", Func: noop },
+    { Name: "consumes",       Func: noop, Mask: TASK_ASSETS_CONSUME },
+    { Name: "queues",         Func: noop, Mask: TASK_TASKS_QUEUE | TASK_ASSETS_EMIT },
+    { Name: "no-func",        Mask: TASK_MASK_DEFINED },
+  } {
+    if _, err := NewTaskGroup("invalid-group", member); err == nil {
+      t.Errorf("Expected an error creating a task group with member %s", member.Name)
+    }
+  }
+}
@@ -3,6 +3,11 @@ package interbuilder
 import (
   "fmt"
   "net/url"
+  "path"
+  "regexp"
+  "sort"
+  "strings"
+  "sync"
 )
 
 
@@ -12,6 +17,12 @@ import (
   is told to search for a Task, it will navigate its own
   TaskResolver tree, and if a match isn't found, it will look
   among its parents. 
+
+  Among sibling TaskResolvers, the matching resolver with the
+  highest Priority is used. Ties are broken by order, with
+  later-added resolvers taking precedence. Priorities do not
+  cross Spec boundaries: a Spec's own resolvers are always
+  searched before those of its parents.
 */
 type TaskResolver struct {
   Name          string
@@ -27,13 +38,21 @@ type TaskResolver struct {
 
   MatchBlocks   bool
   MatchFunc     TaskMatchFunc
+  Priority      int
+
+  // NamePattern, if defined, is used in place of an exact Name
+  // comparison when MatchFunc is nil. It is a glob pattern, as
+  // in path.Match (such as "deploy-*"), or a regular expression
+  // when enclosed in slashes (such as "/^deploy-(s3|rsync)$/").
+  //
+  NamePattern   string
 
   // The TaskResolver Mask is a Task Mask which if defined, only allows
   // children which have masks inside this one. If a child
   // resolver has mask bits outside of this one's, it should be
   // rejected.
   //
-  AcceptMask uint64
+  AcceptMask TaskMask
 }
 
 
@@ -90,7 +109,9 @@ func (tr *TaskResolver) GetTaskResolverById (id string) *TaskResolver {
 */
 func (tr *TaskResolver) Match (name string, s *Spec) (*TaskResolver, error) {
   if tr.MatchFunc == nil {
-    if tr.Name != name {
+    if name_matches, err := tr.MatchName(name); err != nil {
+      return nil, fmt.Errorf("Error matching name in TaskResolver %s: %w", tr.Id, err)
+    } else if ! name_matches {
       return nil, nil
     }
 
@@ -122,18 +143,79 @@ func (tr *TaskResolver) Match (name string, s *Spec) (*TaskResolver, error) {
 }
 
 
+/*
+  MatchName compares a task name with this resolver's
+  NamePattern, or its Name if no pattern is defined.
+*/
+func (tr *TaskResolver) MatchName (name string) (bool, error) {
+  if tr.NamePattern == "" {
+    return tr.Name == name, nil
+  }
+  return MatchNamePattern(tr.NamePattern, name)
+}
+
+
+var name_pattern_regexps sync.Map // map[string]*regexp.Regexp
+
+
+/*
+  MatchNamePattern matches a name against a glob pattern, or a
+  regular expression if the pattern is enclosed in slashes.
+  Compiled regular expressions are cached.
+*/
+func MatchNamePattern (pattern, name string) (bool, error) {
+  if len(pattern) < 2 || !strings.HasPrefix(pattern, "/") || !strings.HasSuffix(pattern, "/") {
+    return path.Match(pattern, name)
+  }
+
+  if rgx, ok := name_pattern_regexps.Load(pattern); ok {
+    return rgx.(*regexp.Regexp).MatchString(name), nil
+  }
+
+  rgx, err := regexp.Compile(pattern[1:len(pattern)-1])
+  if err != nil {
+    return false, fmt.Errorf("Invalid name pattern regular expression %s: %w", pattern, err)
+  }
+
+  name_pattern_regexps.Store(pattern, rgx)
+  return rgx.MatchString(name), nil
+}
+
+
 func (tr *TaskResolver) MatchChildren (name string, spec *Spec) (*TaskResolver, error) {
-  for child := tr.Children ; child != nil ; child = child.Next {
-    child_match, err := child.Match(name, spec)
+  _, match, err := matchTaskResolverSiblings(tr.Children, func (child *TaskResolver) (*TaskResolver, error) {
+    return child.Match(name, spec)
+  })
+  return match, err
+}
+
+
+/*
+  matchTaskResolverSiblings calls match_func on a list of sibling
+  TaskResolvers, returning the sibling with the highest Priority
+  whose match_func returns a resolver, along with that resolver.
+  Ties are broken by list order. Once a match is found, only
+  siblings with a greater Priority are tested, so with equal
+  priorities the first match short-circuits the search.
+*/
+func matchTaskResolverSiblings (first *TaskResolver, match_func func (*TaskResolver) (*TaskResolver, error)) (sibling, match *TaskResolver, err error) {
+  for resolver := first ; resolver != nil ; resolver = resolver.Next {
+    if sibling != nil && resolver.Priority <= sibling.Priority {
+      continue
+    }
+
+    resolver_match, err := match_func(resolver)
     if err != nil {
-      return nil, err
+      return resolver, nil, err
     }
-    if child_match != nil {
-      return child_match, nil
+
+    if resolver_match != nil {
+      sibling = resolver
+      match   = resolver_match
     }
   }
 
-  return nil, nil
+  return sibling, match, nil
 }
 
 
@@ -153,10 +235,25 @@ func (tr *TaskResolver) GetTask (name string, s *Spec) (*Task, error) {
   if resolver == nil || err != nil {
     return nil, err
   }
-  if resolver.TaskPrototype.Func == nil && resolver.TaskPrototype.MapFunc == nil {
+  return resolver.newMatchedTask(name)
+}
+
+
+/*
+  newMatchedTask creates a Task from a resolver which matched a
+  task name. Resolvers without a Name, such as those matching
+  with a NamePattern, give the Task the name that was matched.
+*/
+func (tr *TaskResolver) newMatchedTask (name string) (*Task, error) {
+  if tr.TaskPrototype.Func == nil && tr.TaskPrototype.MapFunc == nil {
     return nil, fmt.Errorf("Task resolver has a nil Func and MapFunc")
   }
-  return resolver.NewTask(), nil
+
+  var task = tr.NewTask()
+  if task.Name == "" {
+    task.Name = name
+  }
+  return task, nil
 }
 
 
@@ -179,7 +276,7 @@ func (tr *TaskResolver) AddTaskResolver (add *TaskResolver) error {
 
     if TaskMaskValid(accept_mask, test_mask) == false {
       return fmt.Errorf(
-        "Cannot add TaskResolver with id '%s' to '%s', Task mask of %04O not valid within acceptance mask %04O",
+        "Cannot add TaskResolver with id '%s' to '%s', Task mask of %s not valid within acceptance mask %s",
         add.Id, tr.Id, test_mask, accept_mask,
       )
     }
@@ -199,15 +296,20 @@ func (tr *TaskResolver) AddTaskResolver (add *TaskResolver) error {
 
 
 func (s *Spec) GetTask (name string, spec *Spec) (*Task, error) {
-  for resolver := s.TaskResolvers ; resolver != nil ; resolver = resolver.Next {
-    task, err := resolver.GetTask(name, spec)
+  sibling, match, err := matchTaskResolverSiblings(s.TaskResolvers, func (resolver *TaskResolver) (*TaskResolver, error) {
+    return resolver.Match(name, spec)
+  })
+  if err != nil {
+    return nil, fmt.Errorf("Error getting task in TaskResolver %s: %w", sibling.Id, err)
+  }
+
+  if match != nil {
+    task, err := match.newMatchedTask(name)
     if err != nil {
-      return nil, fmt.Errorf("Error getting task in TaskResolver %s: %w", resolver.Id, err)
-    }
-    if task != nil {
-      task.Spec = s
-      return task, nil
+      return nil, fmt.Errorf("Error getting task in TaskResolver %s: %w", sibling.Id, err)
     }
+    task.Spec = s
+    return task, nil
   }
 
   if s.Parent == nil {
@@ -261,12 +363,115 @@ func (tr *TaskResolver) MatchWithAsset (a *Asset) (*TaskResolver, error) {
 
 
 func (tr *TaskResolver) MatchChildrenWithAsset (a *Asset) (*TaskResolver, error) {
-  for child := tr.Children; child != nil; child = child.Next {
-    if child_match, err := child.MatchWithAsset(a); err != nil {
-      return nil, err
-    } else if child_match != nil {
-      return child_match, nil
+  _, match, err := matchTaskResolverSiblings(tr.Children, func (child *TaskResolver) (*TaskResolver, error) {
+    return child.MatchWithAsset(a)
+  })
+  return match, err
+}
+
+
+/*
+  TaskResolverInfo is a flattened description of a TaskResolver
+  and its position within a Spec's resolver hierarchy, for
+  presenting resolvers to users and tooling.
+*/
+type TaskResolverInfo struct {
+  Id          string
+  Name        string
+  NamePattern string
+  Priority    int
+  AcceptMask  TaskMask
+
+  // Depth is the resolver's depth in its tree, with zero being a
+  // top-level resolver in a Spec's TaskResolvers list
+  //
+  Depth       int
+
+  // Spec is the Spec whose TaskResolvers list contains this
+  // resolver, or one of its ancestors
+  //
+  Spec        *Spec
+  Resolver    *TaskResolver
+}
+
+
+/*
+  Walk calls walk_func on this TaskResolver and each of its
+  descendants, in depth-first pre-order, with the depth of each
+  resolver relative to this one. Siblings of this resolver
+  (through its Next field) are not walked. If walk_func returns
+  an error, walking stops and the error is returned.
+*/
+func (tr *TaskResolver) Walk (walk_func func (tr *TaskResolver, depth int) error) error {
+  return tr.walk(walk_func, 0)
+}
+
+
+func (tr *TaskResolver) walk (walk_func func (tr *TaskResolver, depth int) error, depth int) error {
+  if err := walk_func(tr, depth); err != nil {
+    return err
+  }
+
+  for child := tr.Children ; child != nil ; child = child.Next {
+    if err := child.walk(walk_func, depth + 1); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+
+/*
+  ListTaskResolvers returns a flattened list of the TaskResolvers
+  available to this Spec, in the order they are searched: this
+  Spec's resolvers first, followed by those of its parents.
+  Siblings are listed by descending Priority, with ties in list
+  order, as in Spec.GetTask, and each resolver is followed by its
+  children.
+*/
+func (s *Spec) ListTaskResolvers () []TaskResolverInfo {
+  var infos []TaskResolverInfo
+
+  var list func (first *TaskResolver, spec *Spec, depth int)
+  list = func (first *TaskResolver, spec *Spec, depth int) {
+    for _, tr := range sortTaskResolverSiblings(first) {
+      infos = append(infos, TaskResolverInfo {
+        Id:          tr.Id,
+        Name:        tr.Name,
+        NamePattern: tr.NamePattern,
+        Priority:    tr.Priority,
+        AcceptMask:  tr.AcceptMask,
+        Depth:       depth,
+        Spec:        spec,
+        Resolver:    tr,
+      })
+      list(tr.Children, spec, depth + 1)
     }
   }
-  return nil, nil
+
+  for spec := s ; spec != nil ; spec = spec.Parent {
+    list(spec.TaskResolvers, spec, 0)
+  }
+
+  return infos
+}
+
+
+/*
+  sortTaskResolverSiblings returns a list of sibling
+  TaskResolvers in the order matchTaskResolverSiblings prefers
+  them: by descending Priority, with ties in list order.
+*/
+func sortTaskResolverSiblings (first *TaskResolver) []*TaskResolver {
+  var siblings []*TaskResolver
+  for tr := first ; tr != nil ; tr = tr.Next {
+    siblings = append(siblings, tr)
+  }
+
+  sort.SliceStable(siblings, func (i, j int) bool {
+    return siblings[i].Priority > siblings[j].Priority
+  })
+
+  return siblings
 }
@@ -250,3 +250,204 @@ func TestTaskResolverAcceptMask (t *testing.T) {
     t.Fatal(err)
   }
 }
+
+
+func TestTaskResolverPriority (t *testing.T) {
+  var noop = func (*Spec, *Task) error { return nil }
+
+  var newResolver = func (id string, priority int) *TaskResolver {
+    return & TaskResolver {
+      Name:     "task",
+      Id:       id,
+      Priority: priority,
+      TaskPrototype: Task { Func: noop },
+    }
+  }
+
+  var root = NewSpec("root", nil)
+
+  // Later-added resolvers take precedence over earlier ones of
+  // equal priority
+  //
+  root.AddTaskResolver(newResolver("low",      -1))
+  root.AddTaskResolver(newResolver("default-a", 0))
+  root.AddTaskResolver(newResolver("default-b", 0))
+
+  if task, err := root.GetTask("task", root); err != nil {
+    t.Fatal(err)
+  } else if got, expect := task.ResolverId, "default-b"; got != expect {
+    t.Fatalf("Expected task resolver %s, got %s", expect, got)
+  }
+
+  // A higher priority resolver wins regardless of insertion order
+  //
+  root.AddTaskResolver(newResolver("high-a", 10))
+  root.AddTaskResolver(newResolver("mid",     5))
+  root.AddTaskResolver(newResolver("high-b", 10))
+
+  if task, err := root.GetTask("task", root); err != nil {
+    t.Fatal(err)
+  } else if got, expect := task.ResolverId, "high-b"; got != expect {
+    t.Fatalf("Expected task resolver %s, got %s", expect, got)
+  }
+
+  // Priority is also honored among child resolvers
+  //
+  var parent = & TaskResolver {
+    Name: "child",
+    Id:   "parent",
+    TaskPrototype: Task { Func: noop },
+  }
+
+  var child_high = newResolver("child-high", 1)
+  var child_low  = newResolver("child-low",  0)
+  child_high.Name = "child"
+  child_low.Name  = "child"
+
+  parent.AddTaskResolver(child_high)
+  parent.AddTaskResolver(child_low)
+
+  if match, err := parent.Match("child", root); err != nil {
+    t.Fatal(err)
+  } else if match != child_high {
+    t.Fatalf("Expected child task resolver %s, got %s", child_high.Id, match.Id)
+  }
+
+  // A subspec's own resolvers are searched before its parent's,
+  // regardless of priority
+  //
+  var subspec = root.AddSubspec(NewSpec("subspec", nil))
+  subspec.AddTaskResolver(newResolver("subspec", -100))
+
+  if task, err := subspec.GetTask("task", subspec); err != nil {
+    t.Fatal(err)
+  } else if got, expect := task.ResolverId, "subspec"; got != expect {
+    t.Fatalf("Expected task resolver %s, got %s", expect, got)
+  }
+}
+
+
+func TestTaskResolverNamePattern (t *testing.T) {
+  var noop = func (*Spec, *Task) error { return nil }
+
+  var root = NewSpec("root", nil)
+
+  root.AddTaskResolver(& TaskResolver {
+    Id:            "deploy-glob",
+    NamePattern:   "deploy-*",
+    TaskPrototype: Task { Func: noop },
+  })
+
+  root.AddTaskResolver(& TaskResolver {
+    Id:            "build-regexp",
+    NamePattern:   "/^build-(css|js)$/",
+    TaskPrototype: Task { Func: noop },
+  })
+
+  var test_cases = []struct { Name string; ResolverId string } {
+    { Name: "deploy-s3",    ResolverId: "deploy-glob"  },
+    { Name: "deploy-rsync", ResolverId: "deploy-glob"  },
+    { Name: "build-css",    ResolverId: "build-regexp" },
+    { Name: "build-js",     ResolverId: "build-regexp" },
+    { Name: "deploy",       ResolverId: ""             },
+    { Name: "build-html",   ResolverId: ""             },
+  }
+
+  for _, test_case := range test_cases {
+    task, err := root.GetTask(test_case.Name, root)
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    if test_case.ResolverId == "" {
+      if task != nil {
+        t.Errorf("Expected task name %s not to match, matched resolver %s", test_case.Name, task.ResolverId)
+      }
+      continue
+    }
+
+    if task == nil {
+      t.Errorf("Expected task name %s to match resolver %s, got <nil>", test_case.Name, test_case.ResolverId)
+    } else if task.ResolverId != test_case.ResolverId {
+      t.Errorf("Expected task name %s to match resolver %s, got %s", test_case.Name, test_case.ResolverId, task.ResolverId)
+    } else if task.Name != test_case.Name {
+      t.Errorf("Expected task matched by a name pattern to be named %s, got %s", test_case.Name, task.Name)
+    }
+  }
+
+  // Invalid patterns produce errors
+  //
+  for _, pattern := range []string { "[", "/(/" } {
+    var resolver = & TaskResolver { Id: "invalid", NamePattern: pattern }
+    if _, err := resolver.Match("name", root); err == nil {
+      t.Errorf("Expected an error matching with invalid name pattern %s", pattern)
+    }
+  }
+}
+
+
+func TestSpecListTaskResolvers (t *testing.T) {
+  var root    = NewSpec("root", nil)
+  var subspec = root.AddSubspec(NewSpec("subspec", nil))
+
+  var grandchild = & TaskResolver { Id: "grandchild" }
+  var child_a    = & TaskResolver { Id: "child-a" }
+  var child_b    = & TaskResolver { Id: "child-b", AcceptMask: TASK_ASSETS_MUTATE }
+  var child_c    = & TaskResolver { Id: "child-c", Priority: 1 }
+  var top        = & TaskResolver { Id: "top" }
+
+  child_a.AddTaskResolver(grandchild)
+  top.AddTaskResolver(child_b)
+  top.AddTaskResolver(child_c)
+  top.AddTaskResolver(child_a)
+
+  root.AddTaskResolver(top)
+  subspec.AddTaskResolver(& TaskResolver { Id: "subspec-top" })
+
+  var expected = []struct { Id string; Depth int; Spec *Spec } {
+    { Id: "subspec-top", Depth: 0, Spec: subspec },
+    { Id: "top",         Depth: 0, Spec: root    },
+    { Id: "child-c",     Depth: 1, Spec: root    },
+    { Id: "child-a",     Depth: 1, Spec: root    },
+    { Id: "grandchild",  Depth: 2, Spec: root    },
+    { Id: "child-b",     Depth: 1, Spec: root    },
+  }
+
+  var infos = subspec.ListTaskResolvers()
+
+  if len(infos) != len(expected) {
+    t.Fatalf("Expected %d task resolvers, got %d", len(expected), len(infos))
+  }
+
+  for i, info := range infos {
+    if info.Id != expected[i].Id || info.Depth != expected[i].Depth || info.Spec != expected[i].Spec {
+      t.Errorf(
+        "Task resolver %d is %s (depth %d, spec %s), expected %s (depth %d, spec %s)",
+        i, info.Id, info.Depth, info.Spec.Name, expected[i].Id, expected[i].Depth, expected[i].Spec.Name,
+      )
+    }
+  }
+
+  if infos[5].AcceptMask != TASK_ASSETS_MUTATE {
+    t.Errorf("Expected child-b accept mask to be listed")
+  }
+
+  // Walking stops when the walk function errors
+  //
+  var walked int
+  err := top.Walk(func (tr *TaskResolver, depth int) error {
+    walked++
+    if tr.Id == "child-a" {
+      return fmt.Errorf("stop")
+    }
+    return nil
+  })
+
+  if err == nil {
+    t.Errorf("Expected Walk to return the walk function's error")
+  }
+
+  if walked != 2 {
+    t.Errorf("Expected Walk to stop after 2 resolvers, walked %d", walked)
+  }
+}
@@ -1,13 +1,30 @@
 package interbuilder
 
 import (
+  "bytes"
+  "context"
   "fmt"
+  "io"
+  "mime"
+  "path"
   "os/exec"
-  "os"
   "strings"
+  "sync"
+  "text/template"
+  "time"
 )
 
 
+/*
+  A TaskMask is a bitmask which defines how a Task works with
+  Assets, whether it produces or consumes them, and what kind of
+  modifications it makes to them. Masks can be combined with the
+  | operator, built with NewTaskMask and its methods, or parsed
+  from names with ParseTaskMask.
+*/
+type TaskMask uint64
+
+
 /*
   The Task Mask constants are bitmasks which define how tasks
   work with Assets, whether they produce or consume, and what
@@ -16,28 +33,46 @@ import (
   be skipped when emitting Assets.
 */
 const (
-  TASK_FIELDS          uint64 = 0b_001_011_111_001  // All bits used by Task masks
-  TASK_FIELDS_ASSETS   uint64 = 0b_000_001_111_000  // Bits in Tasks masks for Asset behaviors
-
-  TASK_MASK_DEFINED    uint64 = 0b_000_000_000_001  // This bit distinguishes a Task
-                                                    // mask with no permissions from
-                                                    // one with undefined permissions,
-                                                    // allowing masks with a zero
-                                                    // value to act like a null value,
-                                                    // rather than restrictive
-                                                    // permission set.
-
-  TASK_ASSETS_EMIT     uint64 = 0b_000_001_000_001  // Task emits new Assets
-  TASK_ASSETS_CONSUME  uint64 = 0b_000_010_000_001  // Task relies on Assets
-  TASK_ASSETS_GENERATE uint64 = 0b_000_001_001_001  // Task creates new assets
-  TASK_ASSETS_FILTER   uint64 = 0b_000_011_010_001  // Task may not emit all Assets it consumes
-  TASK_ASSETS_MUTATE   uint64 = 0b_000_011_100_001  // Task changes the content of existing assets
-
-  TASK_TASKS_QUEUE     uint64 = 0b_001_000_000_001  // Task modifies the Task queue
+  TASK_FIELDS          TaskMask = 0b_001_011_111_001  // All bits used by Task masks
+  TASK_FIELDS_ASSETS   TaskMask = 0b_000_001_111_000  // Bits in Tasks masks for Asset behaviors
+
+  TASK_MASK_DEFINED    TaskMask = 0b_000_000_000_001  // This bit distinguishes a Task
+                                                      // mask with no permissions from
+                                                      // one with undefined permissions,
+                                                      // allowing masks with a zero
+                                                      // value to act like a null value,
+                                                      // rather than restrictive
+                                                      // permission set.
+
+  TASK_ASSETS_EMIT     TaskMask = 0b_000_001_000_001  // Task emits new Assets
+  TASK_ASSETS_CONSUME  TaskMask = 0b_000_010_000_001  // Task relies on Assets
+  TASK_ASSETS_GENERATE TaskMask = 0b_000_001_001_001  // Task creates new assets
+  TASK_ASSETS_FILTER   TaskMask = 0b_000_011_010_001  // Task may not emit all Assets it consumes
+  TASK_ASSETS_MUTATE   TaskMask = 0b_000_011_100_001  // Task changes the content of existing assets
+
+  TASK_TASKS_QUEUE     TaskMask = 0b_001_000_000_001  // Task modifies the Task queue
 ) 
 
 
-func TaskMaskContains (accept_mask, test_mask uint64) bool {
+/*
+  task_mask_names are the names of the Task Mask constants, as
+  parsed by ParseTaskMask and formatted by TaskMask.String, in the
+  order they are formatted.
+*/
+var task_mask_names = []struct {
+  Name  string
+  Mask  TaskMask
+} {
+  { "emit",     TASK_ASSETS_EMIT     },
+  { "consume",  TASK_ASSETS_CONSUME  },
+  { "generate", TASK_ASSETS_GENERATE },
+  { "filter",   TASK_ASSETS_FILTER   },
+  { "mutate",   TASK_ASSETS_MUTATE   },
+  { "queue",    TASK_TASKS_QUEUE     },
+}
+
+
+func TaskMaskContains (accept_mask, test_mask TaskMask) bool {
   if test_mask == 0 {
     return accept_mask == 0 || (accept_mask & TASK_FIELDS == TASK_FIELDS)
   }
@@ -45,7 +80,7 @@ func TaskMaskContains (accept_mask, test_mask uint64) bool {
 }
 
 
-func TaskMaskValid (accept_mask, test_mask uint64) bool {
+func TaskMaskValid (accept_mask, test_mask TaskMask) bool {
   if test_mask == 0 {
     return (accept_mask ^ TASK_FIELDS) & TASK_FIELDS == 0
   }
@@ -53,9 +88,112 @@ func TaskMaskValid (accept_mask, test_mask uint64) bool {
 }
 
 
+/*
+  ParseTaskMask parses a list of Task Mask names, separated by
+  commas or "|", into a Task Mask. Recognized names are "emit",
+  "consume", "generate", "filter", "mutate", and "queue", which
+  correspond to their TASK_ASSETS_* and TASK_TASKS_* constants,
+  and "none" which only defines the mask, granting no
+  permissions. An empty string returns an undefined (zero) mask.
+  The result of TaskMask.String is parsed to the same mask.
+*/
+func ParseTaskMask (src string) (TaskMask, error) {
+  var mask TaskMask
+
+  var names = strings.FieldsFunc(src, func (r rune) bool {
+    return r == ',' || r == '|'
+  })
+
+  for _, name := range names {
+    switch strings.TrimSpace(strings.ToLower(name)) {
+      case "":         continue
+      case "none":     mask |= TASK_MASK_DEFINED
+      case "emit":     mask |= TASK_ASSETS_EMIT
+      case "consume":  mask |= TASK_ASSETS_CONSUME
+      case "generate": mask |= TASK_ASSETS_GENERATE
+      case "filter":   mask |= TASK_ASSETS_FILTER
+      case "mutate":   mask |= TASK_ASSETS_MUTATE
+      case "queue":    mask |= TASK_TASKS_QUEUE
+      default:
+        return 0, fmt.Errorf("Cannot parse Task Mask, unrecognized mask name \"%s\"", name)
+    }
+  }
+
+  return mask, nil
+}
+
+
+/*
+  NewTaskMask returns a defined Task Mask which grants no
+  permissions. Its methods return it with permissions added, such
+  as NewTaskMask().Consume().Emit().
+*/
+func NewTaskMask () TaskMask {
+  return TASK_MASK_DEFINED
+}
+
+
+func (m TaskMask) Emit     () TaskMask { return m | TASK_ASSETS_EMIT     }
+func (m TaskMask) Consume  () TaskMask { return m | TASK_ASSETS_CONSUME  }
+func (m TaskMask) Generate () TaskMask { return m | TASK_ASSETS_GENERATE }
+func (m TaskMask) Filter   () TaskMask { return m | TASK_ASSETS_FILTER   }
+func (m TaskMask) Mutate   () TaskMask { return m | TASK_ASSETS_MUTATE   }
+func (m TaskMask) Queue    () TaskMask { return m | TASK_TASKS_QUEUE     }
+
+
+/*
+  Contains returns whether this mask permits everything test_mask
+  does, as in TaskMaskContains.
+*/
+func (m TaskMask) Contains (test_mask TaskMask) bool {
+  return TaskMaskContains(m, test_mask)
+}
+
+
+/*
+  String formats the Task Mask as the fewest names which make it
+  up, separated by "|" in the order of their bits, such as
+  "generate|mutate". A defined mask with no permissions is "none",
+  and an undefined mask is "undefined". Bits which no name covers
+  are formatted in octal.
+*/
+func (m TaskMask) String () string {
+  switch m {
+    case 0:                 return "undefined"
+    case TASK_MASK_DEFINED: return "none"
+  }
+
+  // Names are chosen from the widest masks down, skipping those
+  // whose bits are already covered, such as "emit" by "mutate"
+  //
+  var covered  = TASK_MASK_DEFINED
+  var selected = make([]bool, len(task_mask_names))
+  for i := len(task_mask_names) - 1; i >= 0; i-- {
+    var name_mask = task_mask_names[i].Mask
+    if m & name_mask == name_mask && name_mask &^ covered != 0 {
+      selected[i] = true
+      covered |= name_mask
+    }
+  }
+
+  var names []string
+  for i, name := range task_mask_names {
+    if selected[i] {
+      names = append(names, name.Name)
+    }
+  }
+  if rest := m &^ covered; rest != 0 {
+    names = append(names, fmt.Sprintf("0o%o", uint64(rest)))
+  }
+
+  return strings.Join(names, "|")
+}
+
+
 type TaskFunc      func (*Spec, *Task) error
 type TaskMapFunc   func (*Asset) (*Asset, error)
 type TaskMatchFunc func (name string, spec *Spec) (bool, error)
+type TaskSkipFunc  func (*Spec, *Task) (bool, error)
 
 
 /*
@@ -75,11 +213,37 @@ type Task struct {
   Next       *Task
   History    HistoryEntry
 
+  // StartedAt and FinishedAt are the wall-clock times at which
+  // the scheduler started and finished running this Task, and
+  // Duration is the time between them. A skipped Task finishes
+  // when it starts. They are zero until the Task runs.
+  //
+  StartedAt   time.Time
+  FinishedAt  time.Time
+  Duration    time.Duration
+
   // The Task Mask optionally specifies whether this task emits
   // or consumes assets, and other more specific safety
   // constraints.
   //
-  Mask uint64
+  Mask TaskMask
+
+  // After and Before are ordering constraints, listing names of
+  // other Tasks in the same Spec which this Task must run after
+  // or before. When a Spec runs, and after its push queue is
+  // flushed, the unstarted portion of the Task queue is stably
+  // sorted to satisfy these constraints. Enqueued and deferred
+  // Tasks are sorted separately, and names of Tasks which are not
+  // in the remaining queue, or on the other side of the boundary
+  // between them, are ignored.
+  //
+  After  []string
+  Before []string
+
+  // Members are the Tasks ran concurrently by a Task group, as
+  // created with NewTaskGroup.
+  //
+  Members []*Task
 
   Assets     []*Asset
 
@@ -95,11 +259,59 @@ type Task struct {
   // MapFunc task callback functions are ran over every Asset
   // emitted to this Task, and can be executed as part of the
   // emitting algorithm before a task is reached within the Task
-  // queue.
+  // queue. MapFuncs of Tasks after a Task group may be called
+  // concurrently by the group's members.
   //
   MapFunc TaskMapFunc
 
-  CancelChan chan bool
+  // MapConcurrency, if greater than one, allows this Task's
+  // MapFunc to be applied to multiple Assets concurrently, when
+  // they are emitted together, such as with Task.EmitAssets,
+  // ForwardAssets, or flattened multi-assets. Results are still
+  // passed on in their original order. The MapFunc must be safe
+  // to call concurrently.
+  //
+  MapConcurrency int
+
+  // AssetBufferLimit is the number of Assets this Task buffers in
+  // memory, after which the content of additional Assets is
+  // staged to disk, and read back when needed. If zero, the
+  // inherited "asset_buffer_limit" prop is used, and if that is
+  // undefined, there is no limit.
+  //
+  AssetBufferLimit int
+
+  // SkipFunc, if defined, is evaluated just before this Task's
+  // Func would run. If it returns true, the Func is not ran,
+  // Skipped is set, and any Assets in this Task's buffer are
+  // forwarded untouched.
+  //
+  SkipFunc TaskSkipFunc
+  Skipped  bool
+
+  // Env holds environment variables for commands ran by this
+  // Task, overriding those from the process and Spec props.
+  //
+  Env map[string]string
+
+  // The context of the Spec run this Task is executing in, see
+  // Task.Context.
+  //
+  context context.Context
+
+  // assets_lock guards the Assets buffer when it may be accessed
+  // concurrently. It is set up by Task groups, on the Tasks after
+  // them, before their members run, and is otherwise nil.
+  //
+  assets_lock *sync.Mutex
+
+  // The number and content size of the Assets this Task has
+  // buffered, counted towards its Spec's MemoryStats until the
+  // buffer is released.
+  //
+  held_assets  int64
+  held_bytes   int64
+  held_data    int64
 
   /*
     Asset matching: used in conjunction with a MapFunc, the
@@ -142,20 +354,28 @@ type Task struct {
 
 
 func (t *Task) Println (a ...any) (n int, err error) {
-  var spec_name = "<nil>"
-
   if t.Spec != nil {
     if quiet, _, _ := t.Spec.InheritPropBool("quiet"); quiet {
       return 0, nil
     }
-    spec_name = t.Spec.Name
   }
 
-  var stdout_prefix = "[" + spec_name + "/" + t.Name + "] "
   var content string = fmt.Sprintln(a...)
-  content = content[:len(content)-1]  // Trip newline
-  content = stdout_prefix + strings.ReplaceAll(content, "\n", "\n"+stdout_prefix)
-  return fmt.Println(content)
+
+  if logger := t.taskLogger(); logger != nil {
+    logger.Info(content[:len(content)-1])
+    var prefix = t.outputPrefix("stdout")
+    t.Spec.writeLogFile([]byte(prefix + strings.ReplaceAll(content[:len(content)-1], "\n", "\n" + prefix) + "\n"))
+    return len(content), nil
+  }
+
+  // Lines are printed like those of the Task's commands
+  //
+  var stdout = t.CommandOutput("stdout")
+  if n, err = io.WriteString(stdout, content); err != nil {
+    return n, err
+  }
+  return n, stdout.Flush()
 }
 
 
@@ -198,45 +418,335 @@ func (t *Task) GetCircularTask () *Task {
 }
 
 
+/*
+  Context returns the context of the Spec run this Task is
+  executing in. It is cancelled when the run is aborted, such as
+  by an error in a subspec, so Tasks which block, such as those
+  reading from their Spec's Input channel, should also select on
+  its Done channel. Outside of a run, context.Background() is
+  returned.
+*/
+func (t *Task) Context () context.Context {
+  if t.context == nil {
+    return context.Background()
+  }
+  return t.context
+}
+
+
+/*
+  Command creates a command with the CommandRunner of the Task's
+  Spec, which by default runs in the Spec's source_dir, with the
+  environment described in Task.Environ, and in the sandbox
+  described in Task.CommandSandbox, as in ExecCommandRunner.
+*/
 func (t *Task) Command (name string, args ...string) *exec.Cmd {
-  cmd := exec.Command(name, args...)
+  var runner CommandRunner = ExecCommandRunner {}
+  if t.Spec != nil {
+    runner = t.Spec.CommandRunner()
+  }
+  return runner.Command(t, name, args...)
+}
 
-  // TODO: get/inherit environment variables
 
-  // Inherity working directory from source_dir prop
+func (t *Task) CommandRun (name string, args ...string) (*exec.Cmd, error) {
+  cmd := t.Command(name, args...)
+
+  // Redirect output to prefixed lines of the Spec's output, or
+  // to its logger
   //
-  if t.Spec != nil {
-    cmd.Dir, _, _ = t.Spec.InheritPropString("source_dir")
+  stdout := t.CommandOutput("stdout")
+  stderr := t.CommandOutput("stderr")
+  cmd.Stdout = stdout
+  cmd.Stderr = stderr
+
+  fmt.Fprint(stdout, "$ ", name, " ", strings.Join(args, " "), "\n")
+  err := cmd.Run()
+  stdout.Flush()
+  stderr.Flush()
+  return cmd, err
+}
+
+
+/*
+  ExpandTemplate expands a text/template string using values from
+  the Task's Spec. The following functions are available:
+
+    - prop "key": The value of an inherited prop, formatted as a
+      string. Missing props are an error.
+    - source_dir: The inherited source_dir prop.
+    - spec:       The name of the Spec.
+    - task:       The name of the Task.
+    - quote:      Quotes a value for use as a single POSIX shell
+      word, as in {{prop "dest" | quote}}.
+    - raw_prop, raw_source_dir: The same as prop and source_dir,
+      but never quoted, see Task.ExpandShellTemplate.
+
+  Values are inserted as-is, which suits commands ran without a
+  shell, where each argument is expanded separately. Use
+  ExpandShellTemplate for shell command strings.
+*/
+func (t *Task) ExpandTemplate (src string) (string, error) {
+  return t.expandTemplate(src, false)
+}
+
+
+/*
+  ExpandShellTemplate expands a template, as in ExpandTemplate,
+  for use as a shell command string, such as with `sh -c`. The
+  values of prop, source_dir, spec, and task are quoted as single
+  shell words, so that configuration cannot inject shell syntax.
+  Values which are already quoted are not quoted again by quote.
+  Where a value is meant to be interpreted by the shell, raw_prop
+  and raw_source_dir insert it unquoted.
+*/
+func (t *Task) ExpandShellTemplate (src string) (string, error) {
+  return t.expandTemplate(src, true)
+}
+
+
+/*
+  shellWord is a template value which has already been quoted
+  for a shell, so that the quote template function leaves it
+  unchanged.
+*/
+type shellWord string
+
+
+func (t *Task) expandTemplate (src string, shell bool) (string, error) {
+  // Skip parsing strings without any actions
+  //
+  if !strings.Contains(src, "{{") {
+    return src, nil
+  }
+
+  var word = func (value string) any {
+    if shell {
+      return shellWord(ShellQuote(value))
+    }
+    return value
+  }
+
+  var get_prop = func (key string) (string, error) {
+    if t.Spec == nil {
+      return "", fmt.Errorf("Cannot get prop %s, task has no Spec", key)
+    }
+    value, found := t.Spec.InheritProp(key)
+    if !found {
+      return "", fmt.Errorf("Prop %s is not defined", key)
+    }
+    return fmt.Sprint(value), nil
+  }
+
+  var get_source_dir = func () (string, error) {
+    if t.Spec == nil {
+      return "", fmt.Errorf("Cannot get source_dir, task has no Spec")
+    }
+    source_dir, ok, found := t.Spec.InheritPropString("source_dir")
+    if !found {
+      return "", fmt.Errorf("Prop source_dir is not defined")
+    } else if !ok {
+      return "", fmt.Errorf("Prop source_dir is not a string")
+    }
+    return source_dir, nil
+  }
+
+  var funcs = template.FuncMap {
+    "prop": func (key string) (any, error) {
+      value, err := get_prop(key)
+      return word(value), err
+    },
+
+    "source_dir": func () (any, error) {
+      source_dir, err := get_source_dir()
+      return word(source_dir), err
+    },
+
+    "spec": func () any {
+      if t.Spec == nil {
+        return word("")
+      }
+      return word(t.Spec.Name)
+    },
+
+    "task": func () any { return word(t.Name) },
+
+    "quote": func (value any) shellWord {
+      if quoted, ok := value.(shellWord); ok {
+        return quoted
+      }
+      return shellWord(ShellQuote(fmt.Sprint(value)))
+    },
+
+    "raw_prop":       get_prop,
+    "raw_source_dir": get_source_dir,
+  }
+
+  tmpl, err := template.New(t.Name).Funcs(funcs).Parse(src)
+  if err != nil {
+    return "", fmt.Errorf("Error parsing command template: %w", err)
+  }
+
+  var expanded strings.Builder
+  if err := tmpl.Execute(&expanded, nil); err != nil {
+    return "", fmt.Errorf("Error expanding command template: %w", err)
   }
 
-  return cmd
+  return expanded.String(), nil
 }
 
 
-func (t *Task) CommandRun (name string, args ...string) (*exec.Cmd, error) {
+/*
+  CommandTemplate creates a command, as in Task.Command, after
+  expanding the program name and each argument as a template with
+  Task.ExpandTemplate.
+*/
+func (t *Task) CommandTemplate (name string, args ...string) (*exec.Cmd, error) {
+  name, args, err := t.expandCommandTemplate(name, args)
+  if err != nil {
+    return nil, err
+  }
+  return t.Command(name, args...), nil
+}
+
+
+/*
+  CommandTemplateRun expands a command template, as in
+  Task.CommandTemplate, and runs it, as in Task.CommandRun.
+*/
+func (t *Task) CommandTemplateRun (name string, args ...string) (*exec.Cmd, error) {
+  name, args, err := t.expandCommandTemplate(name, args)
+  if err != nil {
+    return nil, err
+  }
+  return t.CommandRun(name, args...)
+}
+
+
+/*
+  CommandCapture runs a command, as in Task.Command, and creates
+  an Asset in the Task's Spec at key whose content is the
+  command's standard output. If mimetype is empty, it is inferred
+  from the key's file extension. Standard error is written to the
+  Task's prefixed output, and the Asset is not emitted.
+*/
+func (t *Task) CommandCapture (key, mimetype, name string, args ...string) (*Asset, error) {
+  return t.commandCapture(false, key, mimetype, name, args...)
+}
+
+
+/*
+  CommandCaptureCombined is like Task.CommandCapture, but the
+  Asset's content includes both standard output and standard
+  error.
+*/
+func (t *Task) CommandCaptureCombined (key, mimetype, name string, args ...string) (*Asset, error) {
+  return t.commandCapture(true, key, mimetype, name, args...)
+}
+
+
+func (t *Task) commandCapture (combined bool, key, mimetype, name string, args ...string) (*Asset, error) {
+  if t.Spec == nil {
+    return nil, fmt.Errorf("Cannot capture command output as an asset, task has no Spec")
+  }
+
+  var output bytes.Buffer
   cmd := t.Command(name, args...)
+  cmd.Stdout = &output
 
-  spec_name := "<nil>"
-  if t.Spec != nil {
-    spec_name = t.Spec.Name
+  if combined {
+    cmd.Stderr = &output
+  } else {
+    stderr := t.CommandOutput("stderr")
+    cmd.Stderr = stderr
+    defer stderr.Flush()
   }
 
-  // Redirect output to prefixed wrapper of STDOUT/STDERR
-  // TODO: break this out into its own method
-  //
-  stdout_prefix := "[" + spec_name + "/" + t.Name + "] "
-  stderr_prefix := "{" + spec_name + "/" + t.Name + "} "
+  if err := cmd.Run(); err != nil {
+    return nil, fmt.Errorf("Error capturing output of command %s: %w", name, err)
+  }
 
-  stdout, err := cmd.StdoutPipe()
-  if err != nil { return cmd, err }
-  stderr, err := cmd.StderrPipe()
-  if err != nil { return cmd, err }
+  if mimetype == "" {
+    mimetype = mime.TypeByExtension(path.Ext(key))
+  }
 
-  StreamPrefix(stdout, os.Stdout, stdout_prefix)
-  StreamPrefix(stderr, os.Stderr, stderr_prefix)
+  asset := t.Spec.MakeAsset(key)
+  asset.Mimetype = mimetype
+  if err := asset.SetContentBytes(output.Bytes()); err != nil {
+    return nil, err
+  }
+
+  return asset, nil
+}
+
+
+func (t *Task) expandCommandTemplate (name string, args []string) (string, []string, error) {
+  name, err := t.ExpandTemplate(name)
+  if err != nil {
+    return "", nil, err
+  }
+
+  var expanded_args = make([]string, len(args))
+  for i, arg := range args {
+    if expanded_args[i], err = t.ExpandTemplate(arg); err != nil {
+      return "", nil, err
+    }
+  }
 
-  fmt.Print(stdout_prefix, "$ ", name, " ", strings.Join(args, " "), "\n")
-  return cmd, cmd.Run()
+  return name, expanded_args, nil
+}
+
+
+/*
+  ShellQuote quotes a string as a single POSIX shell word, using
+  single quotes.
+*/
+func ShellQuote (src string) string {
+  return "'" + strings.ReplaceAll(src, "'", `'\''`) + "'"
+}
+
+
+/*
+  SkipIfPathExists returns a TaskSkipFunc which skips a Task if a
+  path, relative to the Spec's source_dir, exists.
+*/
+func SkipIfPathExists (key string) TaskSkipFunc {
+  return func (s *Spec, tk *Task) (bool, error) {
+    return s.PathExists(key)
+  }
+}
+
+
+/*
+  SkipUnlessProp returns a TaskSkipFunc which skips a Task unless
+  an inherited prop is truthy.
+*/
+func SkipUnlessProp (key string) TaskSkipFunc {
+  return func (s *Spec, tk *Task) (bool, error) {
+    value, found := s.InheritProp(key)
+    return !found || !IsTruthy(value), nil
+  }
+}
+
+
+/*
+  Skip marks this Task as skipped, and forwards the Assets in its
+  buffer untouched, regardless of its Task Mask, since a skipped
+  Task does not consume its Assets.
+*/
+func (tk *Task) Skip () error {
+  tk.Skipped = true
+
+  var assets = tk.Assets
+  tk.Assets = nil
+
+  for _, asset := range assets {
+    if err := tk.emitAsset(asset); err != nil {
+      return fmt.Errorf("Error forwarding assets from skipped task %s: %w", tk.Name, err)
+    }
+  }
+
+  return nil
 }
 
 
@@ -254,6 +764,23 @@ func (tk *Task) Run (s *Spec) error {
 }
 
 
+/*
+  startTiming and finishTiming record the wall-clock times of this
+  Task's run, in StartedAt, FinishedAt, and Duration.
+*/
+func (tk *Task) startTiming () {
+  tk.StartedAt  = time.Now()
+  tk.FinishedAt = time.Time {}
+  tk.Duration   = 0
+}
+
+
+func (tk *Task) finishTiming () {
+  tk.FinishedAt = time.Now()
+  tk.Duration   = tk.FinishedAt.Sub(tk.StartedAt)
+}
+
+
 /*
   Insert a task into the task queue, before deferred tasks.
   Enqueued tasks are executed in first-in, first-out order, like
@@ -390,8 +917,13 @@ func (sp *Spec) deferTaskUnsafe (tk *Task) error {
     return nil
   }
 
+  // Without enqueued tasks, the enqueue end point is the top of
+  // the task list
+  //
   if sp.tasks_enqueue_end == nil {
-    sp.tasks_enqueue_end = sp.Tasks.End()
+    end.Next = sp.Tasks
+    sp.Tasks = tk
+    return nil
   }
 
   sp.tasks_enqueue_end.insertRange(tk, end)
@@ -612,6 +1144,162 @@ func (s *Spec) flushTaskPushQueue () *Task {
 }
 
 
+/*
+  sortTaskQueueUnsafe reorders the Task queue after the Task
+  `prev` (or the whole queue, if prev is nil) to satisfy Task
+  After and Before constraints. This is a stable topological
+  sort: among Tasks which are free to run, the one earliest in
+  the existing queue goes first. An error is returned if the
+  constraints form a cycle.
+
+  Enqueued and deferred Tasks are sorted separately, so that
+  ordering constraints never move a Task across the boundary
+  between the two. Constraints naming a Task on the other side
+  of the boundary are already satisfied by the boundary itself,
+  or cannot be, and are ignored.
+
+  This method does not lock the task queue.
+*/
+func (s *Spec) sortTaskQueueUnsafe (prev *Task) error {
+  var head *Task = s.Tasks
+  if prev != nil {
+    head = prev.Next
+  }
+
+  var tasks         []*Task
+  var has_ordering  bool
+  var visited       = make(map[*Task]bool)
+
+  // The number of enqueued Tasks at the start of the list, or
+  // zero if the enqueue end is not after prev.
+  //
+  var num_enqueued int
+
+  for task := head ; task != nil ; task = task.Next {
+    // Circular task lists are reported by Spec.Run; leave them
+    // unsorted
+    //
+    if visited[task] {
+      return nil
+    }
+    visited[task] = true
+
+    tasks = append(tasks, task)
+    if task == s.tasks_enqueue_end {
+      num_enqueued = len(tasks)
+    }
+    if len(task.After) > 0 || len(task.Before) > 0 {
+      has_ordering = true
+    }
+  }
+
+  if !has_ordering {
+    return nil
+  }
+
+  enqueued, err := sortTasksByOrdering(tasks[:num_enqueued])
+  if err != nil {
+    return fmt.Errorf("Error in spec %s ordering tasks: %w", s.Name, err)
+  }
+
+  deferred, err := sortTasksByOrdering(tasks[num_enqueued:])
+  if err != nil {
+    return fmt.Errorf("Error in spec %s ordering deferred tasks: %w", s.Name, err)
+  }
+
+  sorted := append(enqueued, deferred...)
+
+  // Relink the sorted tasks
+  //
+  for i := 0 ; i < len(sorted) - 1 ; i++ {
+    sorted[i].Next = sorted[i+1]
+  }
+  sorted[len(sorted)-1].Next = nil
+
+  if prev == nil {
+    s.Tasks = sorted[0]
+  } else {
+    prev.Next = sorted[0]
+  }
+
+  // Keep the boundary between enqueued and deferred tasks at the
+  // same position in the queue
+  //
+  if num_enqueued > 0 {
+    s.tasks_enqueue_end = enqueued[num_enqueued-1]
+  }
+
+  return nil
+}
+
+
+func sortTasksByOrdering (tasks []*Task) ([]*Task, error) {
+  var indices = make(map[string][]int)
+  for i, task := range tasks {
+    indices[task.Name] = append(indices[task.Name], i)
+  }
+
+  // Build an adjacency list of edges from tasks which must run
+  // first, to tasks which must run after them
+  //
+  var edges      = make([][]int, len(tasks))
+  var in_degrees = make([]int,   len(tasks))
+
+  for i, task := range tasks {
+    for _, name := range task.After {
+      for _, j := range indices[name] {
+        if j == i { continue }
+        edges[j] = append(edges[j], i)
+        in_degrees[i]++
+      }
+    }
+
+    for _, name := range task.Before {
+      for _, j := range indices[name] {
+        if j == i { continue }
+        edges[i] = append(edges[i], j)
+        in_degrees[j]++
+      }
+    }
+  }
+
+  // Repeatedly take the earliest task with no unsatisfied
+  // constraints
+  //
+  var sorted = make([]*Task, 0, len(tasks))
+  var taken  = make([]bool,  len(tasks))
+
+  for len(sorted) < len(tasks) {
+    var next int = -1
+    for i := range tasks {
+      if !taken[i] && in_degrees[i] == 0 {
+        next = i
+        break
+      }
+    }
+
+    if next == -1 {
+      var cycle_names []string
+      for i, task := range tasks {
+        if !taken[i] {
+          cycle_names = append(cycle_names, task.Name)
+        }
+      }
+      return nil, fmt.Errorf("Task ordering constraints form a cycle among tasks: %s", strings.Join(cycle_names, ", "))
+    }
+
+    taken[next] = true
+    sorted = append(sorted, tasks[next])
+
+    for _, j := range edges[next] {
+      in_degrees[j]--
+    }
+  }
+
+  return sorted, nil
+}
+
+
 /*
   PassAsset sends an Asset to subsequent assets. As long
   as there are Tasks with only MapFuncs, the asset will have
@@ -626,25 +1314,22 @@ func (tk *Task) EmitAsset (a *Asset) error {
   // (zero) mask is okay.
   //
   if TaskMaskContains(tk.Mask, TASK_ASSETS_EMIT) == false {
-    return fmt.Errorf("Task cannot emit asset, Task.Mask has a value of %O", tk.Mask)
+    return tk.maskViolation("emit assets", TASK_ASSETS_EMIT)
   }
 
+  return tk.emitAsset(a)
+}
+
+
+/*
+  emitAsset performs Task.EmitAsset without checking whether this
+  Task's Mask permits emitting assets.
+*/
+func (tk *Task) emitAsset (a *Asset) error {
   var asset *Asset = a
   var err   error
 
-  var next *Task = tk.Next
-
-  // Don't emit to Tasks which cannot consume Assets due to their
-  // Mask. Search the task chain for a Task which can accept
-  // assets, or leave the `next` variable nil from trying.
-  //
-  for next = tk.Next; next != nil; next = next.Next {
-    if (!next.IgnoreAssets                               &&(
-        TaskMaskContains(next.Mask, TASK_TASKS_QUEUE)     ||
-        TaskMaskContains(next.Mask, TASK_ASSETS_CONSUME) )){
-      break
-    }
-  }
+  var next *Task = tk.receivingTask()
 
   // If this is the final task, the only place left for the asset
   // to go is being emitted by the Spec. Do so if it exists.
@@ -675,13 +1360,18 @@ func (tk *Task) EmitAsset (a *Asset) error {
     // multi-asset was not found.
     //
     if next.RejectFlattenMultiAssets == false {
-      if assets, err := a.Flatten(); err != nil {
+      // Pass on the assets of a channel as they are received,
+      // rather than waiting for it to close
+      //
+      if a.IsChannel() {
+        return a.ReceiveAssets(tk.Context(), tk.emitAsset)
+      }
+
+      if assets, err := a.FlattenContext(tk.Context()); err != nil {
         return err
       } else {
-        for _, asset := range assets {
-          if err := tk.EmitAsset(asset); err != nil {
-            return err
-          }
+        if err := tk.emitAssets(assets); err != nil {
+          return err
         }
       }
       return nil
@@ -720,24 +1410,154 @@ func (tk *Task) EmitAsset (a *Asset) error {
   if err != nil {
     return fmt.Errorf("Error in task %s MapFunc: %w", next.Name, err)
   }
+
+  return next.receiveMappedAsset(asset)
+}
+
+
+/*
+  EmitAssets emits multiple Assets, as in Task.EmitAsset. If the
+  Task receiving them has a MapFunc and a MapConcurrency greater
+  than one, the MapFunc is applied to the Assets concurrently,
+  and the results are passed on in their original order.
+*/
+func (tk *Task) EmitAssets (assets []*Asset) error {
+  if TaskMaskContains(tk.Mask, TASK_ASSETS_EMIT) == false {
+    return tk.maskViolation("emit assets", TASK_ASSETS_EMIT)
+  }
+
+  return tk.emitAssets(assets)
+}
+
+
+/*
+  emitAssets performs Task.EmitAssets without checking whether
+  this Task's Mask permits emitting assets.
+*/
+func (tk *Task) emitAssets (assets []*Asset) error {
+  var next = tk.receivingTask()
+
+  var concurrent = next != nil && next.MapFunc != nil && next.MapConcurrency > 1 && len(assets) > 1
+  if concurrent {
+    for _, asset := range assets {
+      if !asset.IsSingle() {
+        concurrent = false
+        break
+      }
+    }
+  }
+
+  if !concurrent {
+    for _, asset := range assets {
+      if err := tk.emitAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  }
+
+  // Apply the MapFunc with a pool of MapConcurrency goroutines.
+  // Assets which do not match the receiving Task are passed
+  // through it unmapped, in order, along with the mapped Assets.
+  //
+  type mapResult struct {
+    asset   *Asset
+    matched bool
+    err     error
+  }
+
+  var results = make([]mapResult, len(assets))
+  var indexes = make(chan int)
+  var wait_group sync.WaitGroup
+
+  for worker := 0 ; worker < min(next.MapConcurrency, len(assets)) ; worker++ {
+    wait_group.Add(1)
+    go func () {
+      defer wait_group.Done()
+      for i := range indexes {
+        var asset = assets[i]
+
+        matched, err := next.MatchAsset(asset)
+        if err != nil || !matched {
+          results[i] = mapResult { asset: asset, err: err }
+          continue
+        }
+
+        mapped, err := next.MapFunc(asset)
+        if err != nil {
+          err = fmt.Errorf("Error in task %s MapFunc: %w", next.Name, err)
+        }
+        results[i] = mapResult { asset: mapped, matched: true, err: err }
+      }
+    }()
+  }
+
+  for i := range assets {
+    indexes <- i
+  }
+  close(indexes)
+  wait_group.Wait()
+
+  for _, result := range results {
+    if result.err != nil {
+      return result.err
+    }
+
+    var err error
+    if result.matched {
+      err = next.receiveMappedAsset(result.asset)
+    } else {
+      err = next.EmitAsset(result.asset)
+    }
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+
+/*
+  receivingTask returns the first Task after this one which can
+  receive Assets, or nil if there is none. Tasks which cannot
+  consume Assets due to their Mask are skipped.
+*/
+func (tk *Task) receivingTask () *Task {
+  for next := tk.Next; next != nil; next = next.Next {
+    if (!next.IgnoreAssets                               &&(
+        TaskMaskContains(next.Mask, TASK_TASKS_QUEUE)     ||
+        TaskMaskContains(next.Mask, TASK_ASSETS_CONSUME) )){
+      return next
+    }
+  }
+  return nil
+}
+
+
+/*
+  receiveMappedAsset passes an Asset, which this Task's MapFunc
+  has been applied to, to its destination: this Task's buffer if
+  it has a Func, otherwise the Tasks after it.
+*/
+func (tk *Task) receiveMappedAsset (asset *Asset) error {
   if asset == nil { return nil }
 
-  // With the new asset, if the next task has a Func, then it is
+  // With the new asset, if this task has a Func, then it is
   // the destination, since the Func may mutate the asset via its
   // task buffer.
   //
-  if next.Func != nil {
-    next.AddAsset(asset)
+  if tk.Func != nil {
+    tk.AddAsset(asset)
     return nil
   }
 
-  // There is a next task, we have a valid (map-function-applied)
-  // asset for it, and this task has no Func to mutate the asset
-  // further. Recurse, sending the asset as far as it can go in
-  // the Task without requiring the task queue to synchronize up
-  // until that point.
+  // We have a valid (map-function-applied) asset, and this task
+  // has no Func to mutate the asset further. Recurse, sending the
+  // asset as far as it can go in the Task without requiring the
+  // task queue to synchronize up until that point.
   //
-  return next.EmitAsset(asset)
+  return tk.EmitAsset(asset)
 }
 
 
@@ -745,23 +1565,55 @@ func (tk *Task) EmitAsset (a *Asset) error {
   PoolSpecInputAssets reads the Spec input channel for asset
   chunks and inserts them into the Task's Asset array. Note:
   because this blocks until all input is received, it can be less
-  efficient than using a range over the Input channel.
+  efficient than using a range over the Input channel. If no input
+  is received within the inherited "stall_timeout", a StallError
+  describing the unfinished subspecs is returned. Assets
+  overridden under the Spec's "merge_conflicts" policy are
+  dropped.
 */
 func (tk *Task) PoolSpecInputAssets () error {
   // If the Task mask is defined but not set to emit, error. An undefined
   // (zero) mask is okay.
   //
   if TaskMaskContains(tk.Mask, TASK_ASSETS_CONSUME) == false {
-    return fmt.Errorf("Task cannot pool assets, Task.Mask has a value of %03O, and is not set to consume assets", tk.Mask)
+    return tk.maskViolation("pool assets", TASK_ASSETS_CONSUME)
   }
 
   if tk.Spec == nil {
     return fmt.Errorf("Task Spec is nil")
   }
 
-  for asset_chunk := range tk.Spec.Input {
+  stall_timeout, err := tk.Spec.stallTimeout()
+  if err != nil {
+    return err
+  }
+  var stall_timer = newStallTimer(stall_timeout)
+  if stall_timer != nil {
+    defer stall_timer.Stop()
+  }
+
+  for {
+    var asset_chunk *Asset
+    var ok bool
+
+    select {
+    case <-tk.Context().Done():
+      return context.Cause(tk.Context())
+    case <-stallChan(stall_timer):
+      return tk.Spec.stallError(stall_timeout)
+    case asset_chunk, ok = <-tk.Spec.Input:
+    }
+
+    if !ok {
+      tk.dropOverriddenInput()
+      return nil
+    }
+    resetStallTimer(stall_timer, stall_timeout)
+
     if asset_chunk.IsSingle() || tk.AcceptMultiAssets {
-      tk.Assets = append(tk.Assets, asset_chunk)
+      if err := tk.bufferAsset(asset_chunk); err != nil {
+        return fmt.Errorf("Cannot pool assets: %w", err)
+      }
       continue
     }
 
@@ -769,28 +1621,64 @@ func (tk *Task) PoolSpecInputAssets () error {
     // multi-assets.
 
     if ! tk.RejectFlattenMultiAssets {
-      if assets, err := asset_chunk.Flatten(); err != nil {
+      if asset_chunk.IsChannel() {
+        if err := tk.poolAssetChannel(asset_chunk); err != nil {
+          return fmt.Errorf("Cannot pool assets: %w", err)
+        }
+        continue
+      }
+
+      if assets, err := asset_chunk.FlattenContext(tk.Context()); err != nil {
         return fmt.Errorf(
           `Cannot pool assets, asset chunk with URL "%s" returned an error while flattening: %w"`,
           asset_chunk.Url, err,
         )
       } else {
-        tk.Assets = append(tk.Assets, assets...)
+        for _, asset := range assets {
+          if err := tk.bufferAsset(asset); err != nil {
+            return fmt.Errorf("Cannot pool assets: %w", err)
+          }
+        }
       }
       continue
     }
 
     return fmt.Errorf("This task does not have a way of receiving a multi-asset")
   }
+}
 
-  return nil
+
+/*
+  poolAssetChannel buffers the assets of a channel multi-asset as
+  they are received, flattening the multi-assets among them, as
+  PoolSpecInputAssets does.
+*/
+func (tk *Task) poolAssetChannel (chunk *Asset) error {
+  return chunk.ReceiveAssets(tk.Context(), func (asset *Asset) error {
+    if asset.IsSingle() {
+      return tk.bufferAsset(asset)
+    } else if asset.IsChannel() {
+      return tk.poolAssetChannel(asset)
+    }
+
+    assets, err := asset.FlattenContext(tk.Context())
+    if err != nil { return err }
+    for _, asset := range assets {
+      if err := tk.bufferAsset(asset); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
 }
 
 
 /*
   ForwardAssets emits all assets from this Task's internal Assets
   array into the next task or spec, returning an error if one
-  occurs.
+  occurs. Assets forwarded out of the Spec are emitted
+  individually, so that each is given the Spec's @emit directive
+  and path transformations.
 */
 func (tk *Task) ForwardAssets () error {
   if tk.Spec == nil {
@@ -803,19 +1691,21 @@ func (tk *Task) ForwardAssets () error {
   //
   // TODO: the first task after this which receives assets is not necessarily tk.Next. This should read ahead for valid tasks, enabling more shortcutting of Assets.
   //
-  if tk.Next == nil || tk.Next.AcceptMultiAssets {
+  tk.lockAssets()
+  var assets = tk.Assets
+  tk.unlockAssets()
+
+  if next := tk.receivingTask(); next != nil && next.AcceptMultiAssets {
     asset := tk.Spec.MakeAsset("")
-    asset.SetAssetArray(tk.Assets)
+    asset.SetAssetArray(assets)
     return tk.EmitAsset(asset)
   }
 
-  // There is a next task, it does not accept multi-assets.
+  // There is no next task, or it does not accept multi-assets.
   // Emit all assets.
   //
-  for _, asset := range tk.Assets {
-    if err := tk.EmitAsset(asset); err != nil {
-      return fmt.Errorf("Error while forwarding an asset: %w", err)
-    }
+  if err := tk.EmitAssets(assets); err != nil {
+    return fmt.Errorf("Error while forwarding an asset: %w", err)
   }
 
   return nil
@@ -829,12 +1719,43 @@ func (tk *Task) ForwardAssets () error {
 */
 func (tk *Task) AddAsset (a *Asset) *Asset {
   if a != nil {
-    tk.Assets = append(tk.Assets, a)
+    // Staging errors leave the asset in memory, which is still
+    // correct, so they are not reported
+    //
+    tk.bufferAsset(a)
   }
   return a
 }
 
 
+/*
+  takeAssets returns this Task's Asset buffer, and clears it.
+*/
+func (tk *Task) takeAssets () []*Asset {
+  tk.lockAssets()
+  defer tk.unlockAssets()
+
+  var assets = tk.Assets
+  tk.Assets  = nil
+  tk.releaseAssetMemory()
+  return assets
+}
+
+
+func (tk *Task) lockAssets () {
+  if tk.assets_lock != nil {
+    tk.assets_lock.Lock()
+  }
+}
+
+
+func (tk *Task) unlockAssets () {
+  if tk.assets_lock != nil {
+    tk.assets_lock.Unlock()
+  }
+}
+
+
 /*
   MatchAsset compares an asset with multiple matching operands,
   defined inside the Task. If any defined matching operands do
@@ -872,10 +1793,7 @@ func (tk *Task) AssertTaskQueuing () error {
   }
 
   if TaskMaskContains(tk.Mask, TASK_TASKS_QUEUE) == false {
-    return fmt.Errorf(
-      "Task with name '%s' in spec '%s' cannot modify task queue, Task.Mask has a value of %O",
-      tk.Name, spec.Name, tk.Mask,
-    )
+    return tk.maskViolation("modify task queue", TASK_TASKS_QUEUE)
   }
 
   return nil
@@ -910,7 +1828,7 @@ func (tk *Task) AssertTaskIsQueueable (task *Task) error {
 
   if TaskMaskValid(accept_mask, test_mask) == false {
     return fmt.Errorf(
-      "Task '%s (%s)' cannot add a Task '%s (%s)', added Task's Mask (%04O) is not a subset of (%04O)",
+      "Task '%s (%s)' cannot add a Task '%s (%s)', added Task's Mask (%s) is not a subset of (%s)",
       tk.Name, accept_resolver_name, task.Name, test_resolver_name, accept_mask, test_mask,
     )
   }
@@ -7,6 +7,9 @@ import (
   "os"
   "path/filepath"
   "sort"
+  "path"
+  "sync"
+  "time"
 )
 
 
@@ -198,7 +201,7 @@ func TestTaskEmitAsset (t *testing.T) {
       var num_assets = 0
 
       for { select {
-      case <- tk.CancelChan:
+      case <- tk.Context().Done():
         return nil
 
       case asset_chunk, ok := <- s.Input:
@@ -510,6 +513,67 @@ func TestTaskEmitMultiAsset (t *testing.T) {
 }
 
 
+func TestTaskMaskString (t *testing.T) {
+  var test_cases = []struct {
+    Mask    TaskMask
+    Expect  string
+  } {
+    { 0,                                                       "undefined"             },
+    { NewTaskMask(),                                           "none"                  },
+    { NewTaskMask().Emit(),                                    "emit"                  },
+    { NewTaskMask().Consume().Emit(),                          "emit|consume"          },
+    { TASK_ASSETS_MUTATE | TASK_ASSETS_GENERATE,               "generate|mutate"       },
+    { NewTaskMask().Mutate().Filter().Generate(),              "generate|filter|mutate" },
+    { TASK_ASSETS_CONSUME | TASK_TASKS_QUEUE,                  "consume|queue"         },
+    { TASK_FIELDS,                                             "generate|filter|mutate|queue" },
+  }
+
+  for _, test_case := range test_cases {
+    if got := test_case.Mask.String(); got != test_case.Expect {
+      t.Errorf("Expected mask %04O to format as %q, got %q", uint64(test_case.Mask), test_case.Expect, got)
+    }
+
+    // Formatted masks parse to the same mask
+    //
+    if test_case.Mask == 0 {
+      continue
+    }
+    parsed, err := ParseTaskMask(test_case.Expect)
+    if err != nil {
+      t.Errorf("Error parsing %q: %v", test_case.Expect, err)
+    } else if parsed != test_case.Mask {
+      t.Errorf("Expected %q to parse as %s, got %s", test_case.Expect, test_case.Mask, parsed)
+    }
+  }
+
+  if got := TaskMask(0b_100_000_000_001).String(); got != "0o4000" {
+    t.Errorf("Expected unnamed bits to be formatted in octal, got %q", got)
+  }
+}
+
+
+func TestParseTaskMask (t *testing.T) {
+  for src, expect := range map[string]TaskMask {
+    "":                0,
+    "none":            TASK_MASK_DEFINED,
+    "emit|mutate":     TASK_ASSETS_EMIT | TASK_ASSETS_MUTATE,
+    "consume, emit":   NewTaskMask().Consume().Emit(),
+    " Queue | filter": TASK_TASKS_QUEUE | TASK_ASSETS_FILTER,
+  } {
+    mask, err := ParseTaskMask(src)
+    if err != nil {
+      t.Errorf("Error parsing %q: %v", src, err)
+    } else if mask != expect {
+      t.Errorf("Expected %q to parse as %s, got %s", src, expect, mask)
+    }
+  }
+
+  if _, err := ParseTaskMask("emit|teleport"); err == nil {
+    t.Error("Expected an error parsing an unrecognized mask name")
+  }
+}
+
+
 func TestTaskMaskEmit (t *testing.T) {
   // Create a task which cannot emit assets, and make sure it
   // errors when emitting an asset.
@@ -587,3 +651,564 @@ func TestTaskMaskEmit (t *testing.T) {
     t.Fatalf("Spec exitted with an error: %v", err)
   }
 }
+
+
+func TestTaskOrderingConstraints (t *testing.T) {
+  var root *Spec = NewSpec("root", nil)
+  root.Props["quiet"] = true
+
+  var task_log []string
+  var task_func = func (sp *Spec, tk *Task) error {
+    task_log = append(task_log, tk.Name)
+    return nil
+  }
+
+  // Without constraints, these would run in the order:
+  // build, install, lint, test, cleanup, report
+  //
+  root.EnqueueTask(& Task { Name: "build",   Func: task_func, After: []string { "install" } })
+  root.EnqueueTask(& Task { Name: "install", Func: task_func })
+  root.EnqueueTask(& Task { Name: "lint",    Func: task_func })
+  root.DeferTask(  & Task { Name: "report",  Func: task_func })
+  root.DeferTask(  & Task { Name: "cleanup", Func: task_func, After: []string { "report" } })
+  root.EnqueueTask(& Task { Name: "test",    Func: task_func, Before: []string { "lint", "missing" } })
+
+  // A task pushed during execution is also ordered
+  //
+  root.EnqueueTask(& Task {
+    Name: "push", After: []string { "lint" },
+    Func: func (sp *Spec, tk *Task) error {
+      task_log = append(task_log, tk.Name)
+      return tk.PushTask(& Task { Name: "pushed", Func: task_func, After: []string { "report" } })
+    },
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var expect = []string { "install", "build", "test", "lint", "push", "report", "pushed", "cleanup" }
+
+  if strings.Join(task_log, ",") != strings.Join(expect, ",") {
+    t.Errorf("Expected tasks to run in the order %v, got %v", expect, task_log)
+  }
+
+  // Cyclic constraints produce an error
+  //
+  var cyclic *Spec = NewSpec("cyclic", nil)
+  cyclic.Props["quiet"] = true
+  cyclic.EnqueueTask(& Task { Name: "a", Func: task_func, After:  []string { "b" } })
+  cyclic.EnqueueTask(& Task { Name: "b", Func: task_func, After:  []string { "c" } })
+  cyclic.EnqueueTask(& Task { Name: "c", Func: task_func, Before: []string { "a" }, After: []string { "a" } })
+
+  if err := cyclic.Run(); err == nil {
+    t.Errorf("Expected an error running a Spec with cyclic task ordering constraints")
+  }
+}
+
+
+func TestTaskOrderingDeferBoundary (t *testing.T) {
+  var root *Spec = NewSpec("root", nil)
+  root.Props["quiet"] = true
+
+  var task_log []string
+  var task_func = func (sp *Spec, tk *Task) error {
+    task_log = append(task_log, tk.Name)
+    return nil
+  }
+
+  // Constraints naming a task across the enqueue/defer boundary
+  // do not move tasks across it, while constraints within each
+  // side are still applied.
+  //
+  root.DeferTask(  & Task { Name: "report",  Func: task_func, Before: []string { "build" } })
+  root.EnqueueTask(& Task { Name: "build",   Func: task_func, After:  []string { "install", "cleanup" } })
+  root.EnqueueTask(& Task { Name: "install", Func: task_func })
+  root.DeferTask(  & Task { Name: "cleanup", Func: task_func, Before: []string { "report" } })
+  root.EnqueueTask(& Task { Name: "test",    Func: task_func, Before: []string { "install" } })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  var expect = []string { "test", "install", "build", "cleanup", "report" }
+
+  if strings.Join(task_log, ",") != strings.Join(expect, ",") {
+    t.Errorf("Expected tasks to run in the order %v, got %v", expect, task_log)
+  }
+
+  // Tasks deferred before any are enqueued remain deferred, and
+  // are ordered among themselves
+  //
+  task_log = nil
+  var deferred *Spec = NewSpec("deferred", nil)
+  deferred.Props["quiet"] = true
+  deferred.DeferTask(  & Task { Name: "consume",  Func: task_func })
+  deferred.DeferTask(  & Task { Name: "manifest", Func: task_func, After: []string { "consume" } })
+  deferred.EnqueueTask(& Task { Name: "build",    Func: task_func, After: []string { "manifest" } })
+
+  TestWrapTimeoutError(t, deferred.Run)
+
+  expect = []string { "build", "consume", "manifest" }
+
+  if strings.Join(task_log, ",") != strings.Join(expect, ",") {
+    t.Errorf("Expected tasks to run in the order %v, got %v", expect, task_log)
+  }
+}
+
+
+func TestTaskSkipFunc (t *testing.T) {
+  var root *Spec = NewSpec("root", nil)
+  root.Props["quiet"]      = true
+  root.Props["source_dir"] = t.TempDir()
+  root.Props["enabled"]    = false
+
+  if err := root.WriteFile("exists.txt", []byte("exists"), 0o660); err != nil {
+    t.Fatal(err)
+  }
+
+  var ran []string
+  var consumed []*Asset
+
+  var record = func (s *Spec, tk *Task) error {
+    ran = append(ran, tk.Name)
+    return nil
+  }
+
+  root.EnqueueTaskFunc("produce", func (s *Spec, tk *Task) error {
+    asset := s.MakeAsset("asset.txt")
+    asset.SetContentBytes([]byte("content"))
+    return tk.EmitAsset(asset)
+  })
+
+  // This task would discard assets, but it is skipped, and does
+  // not have permission to emit, so the skipped task must forward
+  // its assets regardless of its mask
+  //
+  var skipped_path = & Task {
+    Name:     "skipped-path",
+    Mask:     TASK_ASSETS_CONSUME,
+    SkipFunc: SkipIfPathExists("exists.txt"),
+    Func:     record,
+  }
+  root.EnqueueTask(skipped_path)
+
+  root.EnqueueTask(& Task { Name: "skipped-prop", SkipFunc: SkipUnlessProp("enabled"), Func: record })
+  root.EnqueueTask(& Task { Name: "not-skipped",  SkipFunc: SkipIfPathExists("missing.txt"), Func: func (s *Spec, tk *Task) error {
+    ran = append(ran, tk.Name)
+    return tk.ForwardAssets()
+  }})
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    consumed = tk.Assets
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if got, expect := strings.Join(ran, ","), "not-skipped"; got != expect {
+    t.Errorf("Expected only task %s to run, ran %s", expect, got)
+  }
+
+  if !skipped_path.Skipped {
+    t.Errorf("Expected task skipped-path to be marked as skipped")
+  }
+
+  if len(consumed) != 1 {
+    t.Errorf("Expected skipped tasks to forward 1 asset, consumed %d", len(consumed))
+  }
+
+  // Errors in skip functions are returned
+  //
+  var error_spec = NewSpec("error-spec", nil)
+  error_spec.Props["quiet"] = true
+  error_spec.EnqueueTask(& Task {
+    Name: "skip-error", Func: record,
+    SkipFunc: func (*Spec, *Task) (bool, error) { return false, fmt.Errorf("skip error") },
+  })
+
+  if err := error_spec.Run(); err == nil {
+    t.Errorf("Expected an error from a task's skip function")
+  }
+}
+
+
+func TestTaskCommandEnv (t *testing.T) {
+  t.Setenv("IB_TEST_PROCESS_VAR", "process")
+
+  var root       *Spec  = NewSpec("root", nil)
+  var source_dir string = t.TempDir()
+  root.Props["source_dir"] = source_dir
+  root.Props["quiet"] = true
+  root.Props["env"] = map[string]any {
+    "IB_TEST_ROOT_VAR":     "root",
+    "IB_TEST_OVERRIDE_VAR": "root",
+  }
+
+  var child = NewSpec("child", nil)
+  root.AddSubspec(child)
+  child.Props["env"] = map[string]any {
+    "IB_TEST_OVERRIDE_VAR": "child",
+    "IB_TEST_NUMBER_VAR":   float64(3),
+  }
+
+  var echo = func (tk *Task, variable string) (string, error) {
+    var output strings.Builder
+    cmd := tk.Command("/bin/sh", "-c", "printf %s \"$" + variable + "\"")
+    cmd.Stdout = &output
+    err := cmd.Run()
+    return output.String(), err
+  }
+
+  var cases = []struct {
+    variable  string
+    expect    string
+    clean_env bool
+  } {
+    { "IB_TEST_PROCESS_VAR",  "process", false },
+    { "IB_TEST_ROOT_VAR",     "root",    false },
+    { "IB_TEST_OVERRIDE_VAR", "task",    false },
+    { "IB_TEST_NUMBER_VAR",   "3",       false },
+    { "IB_TEST_TASK_VAR",     "task",    false },
+    { "IB_TEST_PROCESS_VAR",  "",        true  },
+    { "IB_TEST_ROOT_VAR",     "root",    true  },
+  }
+
+  child.EnqueueTaskFunc("env", func (s *Spec, tk *Task) error {
+    tk.Env = map[string]string {
+      "IB_TEST_OVERRIDE_VAR": "task",
+      "IB_TEST_TASK_VAR":     "task",
+    }
+
+    for _, c := range cases {
+      child.Props["clean_env"] = c.clean_env
+
+      got, err := echo(tk, c.variable)
+      if err != nil {
+        return fmt.Errorf("Error echoing %s: %w", c.variable, err)
+      }
+      if got != c.expect {
+        return fmt.Errorf("Expected %s to be %q (clean_env: %v), got %q", c.variable, c.expect, c.clean_env, got)
+      }
+    }
+
+    // An invalid env prop should cause the command to fail
+    //
+    child.Props["env"] = map[string]any { "IB_TEST_INVALID_VAR": []any{} }
+    if _, err := echo(tk, "IB_TEST_INVALID_VAR"); err == nil {
+      return fmt.Errorf("Expected an error from an invalid env prop")
+    }
+
+    return nil
+  })
+
+  if err := root.Run(); err != nil {
+    t.Fatal(err)
+  }
+}
+
+
+func TestTaskCommandTemplate (t *testing.T) {
+  var root       *Spec  = NewSpec("root", nil)
+  var source_dir string = t.TempDir()
+  root.Props["source_dir"] = source_dir
+  root.Props["quiet"] = true
+  root.Props["file_name"] = "it's a file.txt"
+  root.Props["count"] = float64(2)
+
+  root.EnqueueTaskFunc("template", func (s *Spec, tk *Task) error {
+    var cases = []struct {
+      src    string
+      expect string
+    } {
+      { "plain",                          "plain"                      },
+      { `{{prop "count"}}`,               "2"                          },
+      { `{{prop "file_name" | quote}}`,   `'it'\''s a file.txt'`       },
+      { `{{source_dir}}/out`,             source_dir + "/out"          },
+      { `{{spec}}/{{task}}`,              "root/template"              },
+    }
+
+    for _, c := range cases {
+      got, err := tk.ExpandTemplate(c.src)
+      if err != nil {
+        return fmt.Errorf("Error expanding %q: %w", c.src, err)
+      }
+      if got != c.expect {
+        return fmt.Errorf("Expected %q to expand to %q, got %q", c.src, c.expect, got)
+      }
+    }
+
+    if _, err := tk.ExpandTemplate(`{{prop "undefined"}}`); err == nil {
+      return fmt.Errorf("Expected an error expanding an undefined prop")
+    }
+
+    // Shell templates quote values unless they are raw, and quote
+    // does not quote them twice
+    //
+    var shell_cases = []struct {
+      src    string
+      expect string
+    } {
+      { `cat {{prop "file_name"}}`,           `cat 'it'\''s a file.txt'`  },
+      { `cat {{prop "file_name" | quote}}`,   `cat 'it'\''s a file.txt'`  },
+      { `echo {{raw_prop "count"}}`,          "echo 2"                    },
+      { `cd {{source_dir}}`,                  "cd '" + source_dir + "'"   },
+      { `cd {{raw_source_dir}}`,              "cd " + source_dir          },
+    }
+
+    for _, c := range shell_cases {
+      got, err := tk.ExpandShellTemplate(c.src)
+      if err != nil {
+        return fmt.Errorf("Error expanding shell template %q: %w", c.src, err)
+      }
+      if got != c.expect {
+        return fmt.Errorf("Expected shell template %q to expand to %q, got %q", c.src, c.expect, got)
+      }
+    }
+
+    // Write a file through a shell, with a quoted name containing
+    // spaces and a single quote
+    //
+    _, err := tk.CommandTemplateRun("sh", "-c", `echo content > {{prop "file_name" | quote}}`)
+    if err != nil {
+      return err
+    }
+
+    if _, err := os.Stat(filepath.Join(source_dir, "it's a file.txt")); err != nil {
+      return err
+    }
+
+    return nil
+  })
+
+  if err := root.Run(); err != nil {
+    t.Fatal(err)
+  }
+}
+
+
+func TestTaskCommandCapture (t *testing.T) {
+  var root *Spec = NewSpec("root", nil)
+  root.Props["source_dir"] = t.TempDir()
+  root.Props["quiet"] = true
+
+  root.EnqueueTaskFunc("capture", func (s *Spec, tk *Task) error {
+    var cases = []struct {
+      combined        bool
+      key             string
+      mimetype        string
+      expect          string
+      expect_mimetype string
+    } {
+      { false, "out.html", "",           "out\n",      "text/html; charset=utf-8"  },
+      { false, "out",      "text/plain", "out\n",      "text/plain"                },
+      { true,  "out.txt",  "",           "out\nerr\n", "text/plain; charset=utf-8" },
+    }
+
+    for _, c := range cases {
+      var asset *Asset
+      var err   error
+      var args = []string { "sh", "-c", "echo out; echo err >&2" }
+
+      if c.combined {
+        asset, err = tk.CommandCaptureCombined(c.key, c.mimetype, args[0], args[1:]...)
+      } else {
+        asset, err = tk.CommandCapture(c.key, c.mimetype, args[0], args[1:]...)
+      }
+      if err != nil {
+        return err
+      }
+
+      if got, expect := asset.Url.Path, c.key; got != expect {
+        return fmt.Errorf("Expected asset path %s, got %s", expect, got)
+      }
+      if asset.Mimetype != c.expect_mimetype {
+        return fmt.Errorf("Expected mimetype %s, got %s", c.expect_mimetype, asset.Mimetype)
+      }
+
+      content, err := asset.GetContentBytes()
+      if err != nil {
+        return err
+      }
+      if string(content) != c.expect {
+        return fmt.Errorf("Expected content %q, got %q", c.expect, content)
+      }
+    }
+
+    if _, err := tk.CommandCapture("fail", "", "sh", "-c", "exit 1"); err == nil {
+      return fmt.Errorf("Expected an error from a failing command")
+    }
+
+    return nil
+  })
+
+  if err := root.Run(); err != nil {
+    t.Fatal(err)
+  }
+}
+
+
+func TestTaskMapConcurrency (t *testing.T) {
+  var root = NewSpec("root", nil)
+  root.Props["quiet"] = true
+
+  const num_assets = 20
+
+  root.EnqueueTaskFunc("produce", func (s *Spec, tk *Task) error {
+    var assets = make([]*Asset, num_assets)
+    for i := range assets {
+      assets[i] = s.MakeAsset(fmt.Sprintf("%02d.txt", i))
+      assets[i].Mimetype = "text/plain"
+    }
+    if err := assets[3].SetContentBytes([]byte("unmatched")); err != nil {
+      return err
+    }
+    assets[3].Mimetype = "text/html"
+    return tk.EmitAssets(assets)
+  })
+
+  var running, max_running int
+  var running_lock sync.Mutex
+
+  var mapper = & Task {
+    Name:            "map",
+    MapConcurrency:  4,
+    MatchMimePrefix: "text/plain",
+    MapFunc: func (a *Asset) (*Asset, error) {
+      running_lock.Lock()
+      running++
+      max_running = max(max_running, running)
+      running_lock.Unlock()
+
+      // Finish later assets sooner, to exercise reordering
+      //
+      var index int
+      fmt.Sscanf(path.Base(a.Url.Path), "%02d", &index)
+      time.Sleep(time.Duration(num_assets - index) * time.Millisecond)
+
+      running_lock.Lock()
+      running--
+      running_lock.Unlock()
+
+      return a, a.SetContentBytes([]byte("mapped"))
+    },
+  }
+
+  if err := root.EnqueueTask(mapper); err != nil {
+    t.Fatal(err)
+  }
+
+  var received []string
+
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    for _, asset := range tk.Assets {
+      content, err := asset.GetContentBytes()
+      if err != nil {
+        return err
+      }
+      received = append(received, path.Base(asset.Url.Path) + ":" + string(content))
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+
+  if len(received) != num_assets {
+    t.Fatalf("Expected %d assets, got %d", num_assets, len(received))
+  }
+
+  for i, got := range received {
+    var expect = fmt.Sprintf("%02d.txt:mapped", i)
+    if i == 3 {
+      expect = "03.txt:unmatched"
+    }
+    if got != expect {
+      t.Errorf("Expected asset %d to be %s, got %s", i, expect, got)
+    }
+  }
+
+  if max_running < 2 || max_running > 4 {
+    t.Errorf("Expected between 2 and 4 concurrent MapFunc calls, got %d", max_running)
+  }
+}
+
+
+func TestTaskEmitChannelAsset (t *testing.T) {
+  // Streamed assets are passed on as they are received: each is
+  // only sent once the root has received the one before it
+  //
+  var root  = NewSpec("root", nil)
+  var child = root.AddSubspec(NewSpec("child", nil))
+  root.Props["quiet"] = true
+
+  var received_signal = make(chan struct{}, 3)
+
+  child.EnqueueTaskFunc("produce", func (s *Spec, tk *Task) error {
+    var ch    = make(chan *Asset)
+    var asset = s.MakeAsset("stream")
+    if err := asset.SetAssetChannel(ch); err != nil {
+      return err
+    }
+
+    go func () {
+      defer close(ch)
+      for _, name := range []string { "a", "b", "c" } {
+        ch <- s.MakeAsset(name)
+        <-received_signal
+      }
+    }()
+
+    return tk.EmitAsset(asset)
+  })
+  child.EnqueueTask(& Task {
+    Name:    "map",
+    MapFunc: func (a *Asset) (*Asset, error) { return a, nil },
+  })
+
+  var received []string
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    for asset := range s.Input {
+      received = append(received, strings.TrimLeft(asset.Url.Path, "/"))
+      received_signal <- struct{}{}
+    }
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+  if got := strings.Join(received, " "); got != "@emit/a @emit/b @emit/c" {
+    t.Errorf("Expected the streamed assets @emit/a @emit/b @emit/c, got %s", got)
+  }
+
+  // A channel asset output to the parent is pooled as its assets
+  //
+  root  = NewSpec("root", nil)
+  child = root.AddSubspec(NewSpec("child", nil))
+  root.Props["quiet"] = true
+
+  child.EnqueueTaskFunc("produce", func (s *Spec, tk *Task) error {
+    var ch    = make(chan *Asset)
+    var asset = s.MakeAsset("stream")
+    asset.SetAssetChannel(ch)
+
+    go func () {
+      defer close(ch)
+      ch <- s.MakeAsset("a")
+
+      var nested = s.MakeAsset("nested")
+      nested.SetAssetArray([]*Asset { s.MakeAsset("b"), s.MakeAsset("c") })
+      ch <- nested
+    }()
+
+    return s.OutputAsset(asset)
+  })
+
+  var num_pooled int
+  root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+    if err := tk.PoolSpecInputAssets(); err != nil {
+      return err
+    }
+    num_pooled = len(tk.Assets)
+    return nil
+  })
+
+  TestWrapTimeoutError(t, root.Run)
+  if num_pooled != 3 {
+    t.Errorf("Expected 3 pooled assets, got %d", num_pooled)
+  }
+}
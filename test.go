@@ -30,22 +30,21 @@ func TestWrapTimeoutError (t *testing.T, f func () error) {
   t.Helper()
 
   timeout := time.After(TIMEOUT)
-  done    := make(chan bool)
+  done    := make(chan error, 1)
 
   go func () {
-    err := f()
-    done <- true
-
-    if err != nil {
-      t.Fatal("Function exited with error: ", err)
-    }
+    done <- f()
   }()
 
+  // Fatal must be called from the test's goroutine
+  //
   select {
   case <- timeout:
     t.Fatal("Exceeded timeout")
-  case <- done:
-    // NO-OP
+  case err := <- done:
+    if err != nil {
+      t.Fatal("Function exited with error: ", err)
+    }
   }
 }
 
@@ -5,6 +5,14 @@ import (
   "regexp"
   "strings"
   "path"
+  "path/filepath"
+  "os"
+  "io"
+  "encoding/json"
+  "encoding/csv"
+  "unicode"
+  "net/url"
+  "mime"
 )
 
 
@@ -54,10 +62,124 @@ func (sm *StringMatcher) ReplaceString (str string) (string) {
 }
 
 
+/*
+  Path case operations, which are applied to a path after regexp
+  replacements, for normalizing asset URLs.
+*/
+const (
+  PATH_CASE_NONE    uint64 = iota
+  PATH_CASE_LOWER          // Convert the path to lowercase
+  PATH_CASE_UPPER          // Convert the path to uppercase
+  PATH_CASE_SLUGIFY        // Convert each path segment into a URL slug
+)
+
+
+/*
+  AssetCondition restricts a PathTransformation to assets with a
+  matching MIME type prefix and/or a path matching a regular
+  expression. Undefined fields always match.
+*/
+type AssetCondition struct {
+  MimePrefix  string
+  PathMatcher *StringMatcher
+}
+
+
+/*
+  MatchPath returns whether a path and MIME type satisfy this
+  condition. If mimetype is empty, it is inferred from the
+  path's file extension. Paths are matched without their leading
+  slash, so that the same condition applies to emitted asset
+  paths and to absolute URL references in content, such as
+  "images/photo.jpeg" for both "/images/photo.jpeg" and
+  "images/photo.jpeg".
+*/
+func (c *AssetCondition) MatchPath (src, mimetype string) bool {
+  if c.MimePrefix != "" {
+    if mimetype == "" {
+      mimetype = mime.TypeByExtension(path.Ext(src))
+    }
+
+    if ! strings.HasPrefix(mimetype, c.MimePrefix) {
+      return false
+    }
+  }
+
+  if c.PathMatcher != nil && ! c.PathMatcher.MatchString(strings.TrimLeft(src, "/")) {
+    return false
+  }
+
+  return true
+}
+
+
+func (c *AssetCondition) MatchAsset (a *Asset) bool {
+  var asset_path string
+  if a.Url != nil {
+    asset_path = a.Url.Path
+  }
+  return c.MatchPath(asset_path, a.Mimetype)
+}
+
+
+func AssetConditionFromProp (prop map[string]any) (*AssetCondition, error) {
+  var condition AssetCondition
+
+  for key, value := range prop {
+    value_str, ok := value.(string)
+    if !ok {
+      return nil, fmt.Errorf("Error parsing asset condition, property \"%s\" expects a string, got %T", key, value)
+    }
+
+    switch key {
+      case "mime":
+        condition.MimePrefix = value_str
+
+      case "path":
+        path_matcher, err := parseMatcherMatchExpressionString(value_str)
+        if err != nil {
+          return nil, fmt.Errorf("Error parsing asset condition path property: %w", err)
+        }
+        condition.PathMatcher = path_matcher
+
+      default:
+        return nil, fmt.Errorf("Error parsing asset condition, unrecognized property \"%s\"", key)
+    }
+  }
+
+  return &condition, nil
+}
+
+
 type PathTransformation struct {
   Matcher              *StringMatcher
   Replacer             *StringMatcher
 
+  // When is an optional condition restricting which assets this
+  // transformation applies to. TransformPath does not check it;
+  // callers with an asset or URL in context should check it with
+  // MatchCondition.
+  //
+  When                 *AssetCondition
+
+  // RewriteMap holds exact-match path rewrites, from old paths to
+  // new paths, with leading slashes removed from both. When a
+  // path is found in the map, it is rewritten and the Replacer
+  // is skipped; otherwise, the Replacer is used as a fallback.
+  //
+  RewriteMap           map[string]string
+
+  // When ExtFrom is defined, paths ending in the ExtFrom file
+  // extension have it replaced with ExtTo.
+  //
+  ExtFrom              string
+  ExtTo                string
+
+  // CaseOperation is one of the PATH_CASE_* constants, and is
+  // applied after regexp replacements and extension rewrites.
+  //
+  CaseOperation        uint64
+
   do_normalize         bool
   do_prefix            bool
 
@@ -98,10 +220,30 @@ func (pt *PathTransformation) TransformPath (src string) string {
     trailing_slash = (src[len(src)-1] == '/')
   }
 
-  if pt.Replacer != nil {
+  if rewritten, found := pt.rewriteMapLookup(src); found {
+    src = rewritten
+
+    // The rewrite map defines the exact destination path, so its
+    // trailing slash (or lack thereof) is kept as-is.
+    //
+    trailing_slash = false
+  } else if pt.Replacer != nil {
     src = pt.Replacer.ReplaceString(src)
   }
 
+  if pt.ExtFrom != "" {
+    src = ReplacePathExtension(src, pt.ExtFrom, pt.ExtTo)
+  }
+
+  switch pt.CaseOperation {
+    case PATH_CASE_LOWER:
+      src = strings.ToLower(src)
+    case PATH_CASE_UPPER:
+      src = strings.ToUpper(src)
+    case PATH_CASE_SLUGIFY:
+      src = SlugifyPath(src)
+  }
+
   if pt.Prefix != "" {
     var prefix_path = pt.Prefix
     if leading_slash {
@@ -131,6 +273,301 @@ func (pt *PathTransformation) TransformPath (src string) string {
 }
 
 
+/*
+  MatchCondition returns whether this transformation's When
+  condition, if any, accepts a path and MIME type. If mimetype is
+  empty, it is inferred from the path's file extension.
+*/
+func (pt *PathTransformation) MatchCondition (src, mimetype string) bool {
+  if pt.When == nil {
+    return true
+  }
+  return pt.When.MatchPath(src, mimetype)
+}
+
+
+/*
+  SplitUrlPath splits a URL path string at its first '?' or '#'
+  character, returning the path, and the query string and
+  fragment suffix with its leading delimiter.
+*/
+func SplitUrlPath (src string) (url_path, suffix string) {
+  if i := strings.IndexAny(src, "?#"); i >= 0 {
+    return src[:i], src[i:]
+  }
+  return src, ""
+}
+
+
+/*
+  TransformUrlReference resolves a URL reference, such as an HTML
+  href or CSS url() value, against base_url and applies
+  transformations to its path, preserving its query string and
+  fragment. Transformations operate on the decoded path, and the
+  result is escaped again, so escaped characters such as "%3F"
+  are not mistaken for delimiters. References to other hosts or
+  schemes, opaque URLs such as "mailto:" and "data:", and
+  references without a path are not modified. Protocol-relative
+  references, such as "//cdn.example.com/app.js", are on whichever
+  host serves the page, so they are not modified either. The
+  resulting reference, and whether it was changed, are returned.
+
+  Transformation conditions are evaluated against the referenced
+  path, with a MIME type inferred from its file extension, rather
+  than the asset containing the reference. A rule with a "when"
+  condition of {"mime": "image/"} rewrites references to images
+  within HTML and CSS, in the same way it renames image assets.
+*/
+func TransformUrlReference (base_url *url.URL, ref_src string, transformations []*PathTransformation) (string, bool) {
+  if strings.HasPrefix(ref_src, "//") {
+    return ref_src, false
+  }
+
+  ref, err := url.Parse(ref_src)
+  if err != nil {
+    return ref_src, false
+  }
+
+  if ref.Opaque != "" || (ref.Scheme != "" && ref.Scheme != base_url.Scheme) {
+    return ref_src, false
+  }
+
+  if ref.Host != "" && ref.Host != base_url.Host {
+    return ref_src, false
+  }
+
+  if ref.Path == "" {
+    return ref_src, false
+  }
+
+  var resolved = base_url.ResolveReference(ref)
+
+  var transformed_path string = resolved.Path
+  for _, transformation := range transformations {
+    if ! transformation.MatchCondition(transformed_path, "") {
+      continue
+    }
+    transformed_path = transformation.TransformPath(transformed_path)
+  }
+
+  if transformed_path == resolved.Path {
+    return ref_src, false
+  }
+
+  // References with a host keep it in their transformed form
+  //
+  if ref.Host != "" {
+    resolved.Path    = transformed_path
+    resolved.RawPath = ""
+    return resolved.String(), true
+  }
+
+  // Escape the transformed path, and append the query string and
+  // fragment from the original reference, which are still in
+  // their escaped form
+  //
+  var escaped_url = url.URL { Path: transformed_path }
+  _, suffix := SplitUrlPath(ref_src)
+
+  return escaped_url.EscapedPath() + suffix, true
+}
+
+
+/*
+  ReplacePathExtension replaces the file extension of a path, if
+  it ends with ext_from, with ext_to. Extensions are given with
+  their leading period, and paths ending in a slash are treated
+  as directories and not modified.
+*/
+func ReplacePathExtension (src, ext_from, ext_to string) string {
+  if ext_from == "" || strings.HasSuffix(src, "/") {
+    return src
+  }
+
+  if path.Ext(src) != ext_from {
+    return src
+  }
+
+  return strings.TrimSuffix(src, ext_from) + ext_to
+}
+
+
+/*
+  SlugifyPath converts each segment of a slash-separated path
+  into a lowercase URL slug with Slugify, preserving slashes and
+  each segment's file extension. Segments which would slugify to
+  nothing are left unchanged.
+*/
+func SlugifyPath (src string) string {
+  var segments = strings.Split(src, "/")
+
+  for i, segment := range segments {
+    var ext = path.Ext(segment)
+    var base = strings.TrimSuffix(segment, ext)
+
+    // Dotfiles, such as .htaccess, have no base name
+    if base == "" {
+      segments[i] = strings.ToLower(segment)
+      continue
+    }
+
+    // Segments which have no characters to keep, such as "!!!",
+    // are left unchanged rather than producing empty segments or
+    // dotfiles
+    //
+    var slug = Slugify(base)
+    if slug == "" {
+      continue
+    }
+
+    if ext != "" {
+      if ext_slug := Slugify(ext[1:]); ext_slug != "" {
+        slug += "." + ext_slug
+      } else {
+        slug += ext
+      }
+    }
+    segments[i] = slug
+  }
+
+  return strings.Join(segments, "/")
+}
+
+
+/*
+  Slugify converts a string into a lowercase URL slug. Unicode
+  letters and digits are kept, and every run of other characters
+  is replaced with a single hyphen. Leading and trailing hyphens
+  are removed.
+*/
+func Slugify (src string) string {
+  var builder strings.Builder
+  var pending_hyphen bool
+
+  for _, char := range src {
+    if unicode.IsLetter(char) || unicode.IsDigit(char) || unicode.Is(unicode.Mn, char) {
+      if pending_hyphen && builder.Len() > 0 {
+        builder.WriteByte('-')
+      }
+      pending_hyphen = false
+      builder.WriteRune(unicode.ToLower(char))
+    } else {
+      pending_hyphen = true
+    }
+  }
+
+  return builder.String()
+}
+
+
+func (pt *PathTransformation) rewriteMapLookup (src string) (string, bool) {
+  if len(pt.RewriteMap) == 0 {
+    return "", false
+  }
+
+  rewritten, found := pt.RewriteMap[ strings.TrimLeft(src, "/") ]
+  return rewritten, found
+}
+
+
+/*
+  LoadRewriteMapFile reads a mapping of old paths to new paths
+  from a JSON or CSV file, determined by the file's extension.
+  JSON files can either contain an object of old paths to new
+  paths, or an array of two-string arrays. CSV files contain two
+  columns, the old path and the new path, with an optional
+  "from,to" header row. Leading slashes are removed from all
+  paths.
+*/
+func LoadRewriteMapFile (file_path string) (map[string]string, error) {
+  file, err := os.Open(file_path)
+  if err != nil {
+    return nil, fmt.Errorf("Error opening rewrite map file: %w", err)
+  }
+  defer file.Close()
+
+  switch ext := strings.ToLower(filepath.Ext(file_path)); ext {
+    case ".json":
+      return readRewriteMapJson(file)
+    case ".csv":
+      return readRewriteMapCsv(file)
+    default:
+      return nil, fmt.Errorf("Cannot load rewrite map file \"%s\", unrecognized file extension \"%s\", expected .json or .csv", file_path, ext)
+  }
+}
+
+
+func readRewriteMapJson (r io.Reader) (map[string]string, error) {
+  var rewrite_any any
+  if err := json.NewDecoder(r).Decode(&rewrite_any); err != nil {
+    return nil, fmt.Errorf("Error parsing rewrite map JSON: %w", err)
+  }
+
+  var rewrite_map = make(map[string]string)
+
+  switch rewrite_any := rewrite_any.(type) {
+    case map[string]any:
+      for from, to_any := range rewrite_any {
+        to, ok := to_any.(string)
+        if !ok {
+          return nil, fmt.Errorf("Error parsing rewrite map JSON, value for \"%s\" expects a string, got %T", from, to_any)
+        }
+        rewrite_map[ strings.TrimLeft(from, "/") ] = strings.TrimLeft(to, "/")
+      }
+
+    case []any:
+      for entry_i, entry_any := range rewrite_any {
+        entry, ok := entry_any.([]any)
+        if !ok || len(entry) != 2 {
+          return nil, fmt.Errorf("Error parsing rewrite map JSON, entry %d expects an array of two strings", entry_i)
+        }
+
+        from, from_ok := entry[0].(string)
+        to,   to_ok   := entry[1].(string)
+        if !from_ok || !to_ok {
+          return nil, fmt.Errorf("Error parsing rewrite map JSON, entry %d expects an array of two strings", entry_i)
+        }
+        rewrite_map[ strings.TrimLeft(from, "/") ] = strings.TrimLeft(to, "/")
+      }
+
+    default:
+      return nil, fmt.Errorf("Error parsing rewrite map JSON, expected an object or array, got %T", rewrite_any)
+  }
+
+  return rewrite_map, nil
+}
+
+
+func readRewriteMapCsv (r io.Reader) (map[string]string, error) {
+  var reader = csv.NewReader(r)
+  reader.Comment          = '#'
+  reader.FieldsPerRecord  = 2
+  reader.TrimLeadingSpace = true
+
+  records, err := reader.ReadAll()
+  if err != nil {
+    return nil, fmt.Errorf("Error parsing rewrite map CSV: %w", err)
+  }
+
+  // Skip a header row, if one is present
+  //
+  if len(records) > 0 {
+    switch strings.ToLower(records[0][0]) + "," + strings.ToLower(records[0][1]) {
+      case "from,to", "old,new", "source,destination":
+        records = records[1:]
+    }
+  }
+
+  var rewrite_map = make(map[string]string, len(records))
+
+  for _, record := range records {
+    rewrite_map[ strings.TrimLeft(record[0], "/") ] = strings.TrimLeft(record[1], "/")
+  }
+
+  return rewrite_map, nil
+}
+
+
 func tokenizeMatcherExpression (src string) (tokens []string, err error) {
   if len(src) < 2 {
     return nil, fmt.Errorf("Cannot parse match expression from string \"%s\"", src)
@@ -287,7 +724,80 @@ func parseMatcherSubstitutionExpressionString (src string) (*StringMatcher, erro
 }
 
 
+/*
+  PathTransformationFromExtension creates a PathTransformation
+  which replaces the ext_from file extension with ext_to. A
+  leading period is added to either extension if it is missing.
+*/
+func PathTransformationFromExtension (ext_from, ext_to string) (*PathTransformation, error) {
+  ext_from = normalizeExtension(ext_from)
+  ext_to   = normalizeExtension(ext_to)
+
+  if ext_from == "" {
+    return nil, fmt.Errorf("Error creating extension path transformation, source extension is empty")
+  }
+
+  if strings.Contains(ext_from, "/") || strings.Contains(ext_to, "/") {
+    return nil, fmt.Errorf("Error creating extension path transformation, extensions cannot contain slashes")
+  }
+
+  return &PathTransformation {
+    ExtFrom: ext_from,
+    ExtTo:   ext_to,
+  }, nil
+}
+
+
+func normalizeExtension (ext string) string {
+  if ext == "" || ext[0] == '.' {
+    return ext
+  }
+  return "." + ext
+}
+
+
+/*
+  parseExtensionExpression parses the `ext:.from:.to` shorthand
+  into its two extensions.
+*/
+func parseExtensionExpression (src string) (ext_from, ext_to string, err error) {
+  fields := strings.Split(strings.TrimPrefix(src, "ext:"), ":")
+  if len(fields) != 2 {
+    return "", "", fmt.Errorf("Error parsing extension expression \"%s\", expected the form ext:.from:.to", src)
+  }
+  return fields[0], fields[1], nil
+}
+
+
+/*
+  parsePathCaseOperation returns the PATH_CASE_* constant for a
+  case operation name: "lower", "upper", or "slugify".
+*/
+func parsePathCaseOperation (src string) (uint64, error) {
+  switch src {
+    case "lower":   return PATH_CASE_LOWER,   nil
+    case "upper":   return PATH_CASE_UPPER,   nil
+    case "slugify": return PATH_CASE_SLUGIFY, nil
+    default:
+      return PATH_CASE_NONE, fmt.Errorf("Unrecognized path case operation \"%s\", expected lower, upper, or slugify", src)
+  }
+}
+
+
 func PathTransformationFromString (src string) (*PathTransformation, error) {
+  // Keyword transformations are checked prior to tokenizing, as
+  // they would otherwise be read as match expressions
+  //
+  if case_operation, err := parsePathCaseOperation(src); err == nil {
+    return &PathTransformation { CaseOperation: case_operation }, nil
+  }
+
+  if strings.HasPrefix(src, "ext:") {
+    ext_from, ext_to, err := parseExtensionExpression(src)
+    if err != nil { return nil, err }
+    return PathTransformationFromExtension(ext_from, ext_to)
+  }
+
   string_matcher, err := parseMatcherExpressionString(src)
   if err != nil { return nil, err }
 
@@ -304,10 +814,22 @@ func PathTransformationFromString (src string) (*PathTransformation, error) {
 
 
 func PathTransformationFromProp (prop map[string]any) (*PathTransformation, error) {
+  return PathTransformationFromPropDir(prop, "")
+}
+
+
+/*
+  PathTransformationFromPropDir parses a path transformation
+  object, as in PathTransformationFromProp, resolving relative
+  file paths, such as map_file, against base_dir. Specs use their
+  source_dir, so that spec files do not depend on the working
+  directory of the process.
+*/
+func PathTransformationFromPropDir (prop map[string]any, base_dir string) (*PathTransformation, error) {
   var transformation PathTransformation
 
-  var match_src,   find_src,   replace_src,  prefix_src   string
-  var match_found, find_found, replace_found,prefix_found bool
+  var match_src,   find_src,   replace_src,  prefix_src,   map_file_src,   case_src   string
+  var match_found, find_found, replace_found,prefix_found, map_file_found, case_found bool
 
   for key, value := range prop {
     var string_ok bool
@@ -325,6 +847,40 @@ func PathTransformationFromProp (prop map[string]any) (*PathTransformation, erro
       case "prefix":
         prefix_src, string_ok = value.(string)
         prefix_found = true
+      case "map_file":
+        map_file_src, string_ok = value.(string)
+        map_file_found = true
+        if !string_ok || map_file_src == "" {
+          return nil, fmt.Errorf("Error parsing path transformation object, property \"map_file\" expects a file path, got %#v", value)
+        }
+      case "case":
+        case_src, string_ok = value.(string)
+        case_found = true
+
+      case "ext":
+        ext_from, ext_to, err := parseExtensionProp(value)
+        if err != nil { return nil, err }
+
+        ext_transformation, err := PathTransformationFromExtension(ext_from, ext_to)
+        if err != nil { return nil, err }
+
+        transformation.ExtFrom = ext_transformation.ExtFrom
+        transformation.ExtTo   = ext_transformation.ExtTo
+        continue
+
+      case "when":
+        condition_prop, ok := value.(map[string]any)
+        if !ok {
+          return nil, fmt.Errorf("Error parsing path transformation object, property \"when\" expects an object, got %T", value)
+        }
+
+        condition, err := AssetConditionFromProp(condition_prop)
+        if err != nil {
+          return nil, fmt.Errorf("Error parsing path transformation when property: %w", err)
+        }
+
+        transformation.When = condition
+        continue
 
       default:
         return nil, fmt.Errorf("Error parsing path transformation object, unrecognized property \"%s\"", key)
@@ -392,10 +948,73 @@ func PathTransformationFromProp (prop map[string]any) (*PathTransformation, erro
     transformation.Prefix = prefix_src
   }
 
+  if map_file_found {
+    if base_dir != "" && !filepath.IsAbs(map_file_src) {
+      map_file_src = filepath.Join(base_dir, map_file_src)
+    }
+
+    rewrite_map, err := LoadRewriteMapFile(map_file_src)
+    if err != nil {
+      return nil, fmt.Errorf("Error parsing path transformation map_file property: %w", err)
+    }
+    transformation.RewriteMap = rewrite_map
+  }
+
+  if case_found {
+    case_operation, err := parsePathCaseOperation(case_src)
+    if err != nil {
+      return nil, fmt.Errorf("Error parsing path transformation case property: %w", err)
+    }
+    transformation.CaseOperation = case_operation
+  }
+
   return &transformation, nil
 }
 
+/*
+  parseExtensionProp reads the value of an `ext` path
+  transformation property, which is either an object with "from"
+  and "to" attributes, or a string in the form ".from:.to".
+*/
+func parseExtensionProp (value any) (ext_from, ext_to string, err error) {
+  switch value := value.(type) {
+    case string:
+      return parseExtensionExpression(value)
+
+    case map[string]any:
+      for key, ext_any := range value {
+        ext, ok := ext_any.(string)
+        if !ok {
+          return "", "", fmt.Errorf("Error parsing path transformation ext property, attribute \"%s\" expects a string, got %T", key, ext_any)
+        }
+
+        switch key {
+          case "from": ext_from = ext
+          case "to":   ext_to   = ext
+          default:
+            return "", "", fmt.Errorf("Error parsing path transformation ext property, unrecognized attribute \"%s\"", key)
+        }
+      }
+      return ext_from, ext_to, nil
+
+    default:
+      return "", "", fmt.Errorf("Error parsing path transformation ext property, expected an object or string, got %T", value)
+  }
+}
+
+
 func PathTransformationsFromAny (src any) ([]*PathTransformation, error) {
+  return PathTransformationsFromAnyDir(src, "")
+}
+
+
+/*
+  PathTransformationsFromAnyDir parses a string, object, or array
+  of path transformations, resolving relative file paths in
+  transformation objects against base_dir, as in
+  PathTransformationFromPropDir.
+*/
+func PathTransformationsFromAnyDir (src any, base_dir string) ([]*PathTransformation, error) {
   if src == nil {
     return nil, nil
   }
@@ -407,14 +1026,14 @@ func PathTransformationsFromAny (src any) ([]*PathTransformation, error) {
       return []*PathTransformation { transformation }, nil
 
     case map[string]any:
-      transformation, err := PathTransformationFromProp(src)
+      transformation, err := PathTransformationFromPropDir(src, base_dir)
       if err != nil { return nil, err }
       return []*PathTransformation { transformation }, nil
 
     case []any:
       transformations := make([]*PathTransformation, 0, len(src))
       for _, item_src := range src {
-        transformations_append, err := PathTransformationsFromAny(item_src)
+        transformations_append, err := PathTransformationsFromAnyDir(item_src, base_dir)
         if err != nil { return nil, err }
         transformations = append(transformations, transformations_append...)
       }
@@ -5,6 +5,9 @@ import (
   "testing"
   "encoding/json"
   "regexp"
+  "os"
+  "path/filepath"
+  "net/url"
 )
 
 
@@ -49,6 +52,9 @@ func TestInvalidPathTransformationsFromString (t *testing.T) {
     "",                  // Empty string
     "k/find/repl/flag",  // Unsupported mode: k
     "s`find`replace",    // Lacks a delimiter to denote flags
+    "ext:.md",           // Extension shorthand lacks a target extension
+    "ext::.html",        // Extension shorthand lacks a source extension
+    "title",             // Unrecognized case operation keyword
   }
 
   // Assert that bad transformation strings
@@ -121,6 +127,41 @@ func TestPathTransformationsFromString (t *testing.T) {
       "one, many",
       "many, many",
     },
+    {
+      "ext:.md:.html",
+      "/posts/post.md",
+      "/posts/post.html",
+    },
+    {
+      "ext:scss:css",
+      "style.scss",
+      "style.css",
+    },
+    {
+      "ext:.md:.html",
+      "/posts/post.md.txt",
+      "/posts/post.md.txt",
+    },
+    {
+      "ext:.md:.html",
+      "/posts.md/",
+      "/posts.md/",
+    },
+    {
+      "lower",
+      "/Blog/Post.HTML",
+      "/blog/post.html",
+    },
+    {
+      "upper",
+      "/blog/readme.md",
+      "/BLOG/README.MD",
+    },
+    {
+      "slugify",
+      "/Blog Posts/My First Post!.HTML",
+      "/blog-posts/my-first-post.html",
+    },
   }
 
   for _, test_data := range valid_transform_data {
@@ -157,6 +198,15 @@ func TestInvalidPathTransformationsFromProp (t *testing.T) {
 
     // Unrecognized properties
     `{ "this-property-doesnt-exist??!!": "value" }`,
+
+    // Malformed extension rewrites
+    `{ "ext": { "to": ".html" } }`,
+    `{ "ext": { "from": ".md", "to": ".html", "unknown": "" } }`,
+    `{ "ext": ".md" }`,
+    `{ "ext": 5 }`,
+
+    // Unrecognized case operation
+    `{ "case": "title" }`,
   }
 
   for _, test_case_src := range test_cases_src {
@@ -188,6 +238,13 @@ func TestPathTransformationsFromProp (t *testing.T) {
 
     { Src: `{ "match": "/^match$/", "prefix": "prefix" }`, Path: "match",    Expect: "prefix/match" },
     { Src: `{ "match": "/^match$/", "prefix": "prefix" }`, Path: "no-match", NoMatch: true },
+
+    { Src: `{ "ext": { "from": ".md", "to": ".html" } }`, Path: "/post.md",  Expect: "/post.html" },
+    { Src: `{ "ext": { "from": ".md", "to": ".html" } }`, Path: "/post.txt", Expect: "=" },
+    { Src: `{ "ext": ".md:.html", "prefix": "blog" }`,    Path: "/post.md",  Expect: "/blog/post.html" },
+
+    { Src: `{ "case": "lower", "prefix": "Prefix" }`, Path: "/Path/", Expect: "/Prefix/path/" },
+    { Src: `{ "replace": "s/_/ /g", "case": "slugify" }`, Path: "/CMS_Export/Page_One.html", Expect: "/cms-export/page-one.html" },
   }
 
   for _, test_case := range test_cases_src {
@@ -226,3 +283,235 @@ func TestPathTransformationsFromProp (t *testing.T) {
     }
   }
 }
+
+
+func TestPathTransformationRewriteMapFile (t *testing.T) {
+  var temp_dir = t.TempDir()
+
+  var map_files = map[string]string {
+    "redirects.json":       `{ "/old/page.html": "/new/page.html", "old/dir/": "new/dir/" }`,
+    "redirects-pairs.json": `[ ["/old/page.html", "/new/page.html"], ["old/dir/", "new/dir/"] ]`,
+    "redirects.csv":        "from,to\n/old/page.html,/new/page.html\nold/dir/,new/dir/\n",
+  }
+
+  var test_cases = []struct { Path string; Expect string } {
+    { Path: "/old/page.html", Expect: "/new/page.html" },
+    { Path: "old/page.html",  Expect: "new/page.html"  },
+    { Path: "/old/dir/",      Expect: "/new/dir/"      },
+
+    // Not in the map, falls back to the regexp replacer
+    { Path: "/old/other.html", Expect: "/fallback/other.html" },
+
+    // Not in the map, and not matched by the replacer
+    { Path: "/unrelated.html", Expect: "/unrelated.html" },
+  }
+
+  for file_name, file_content := range map_files {
+    var map_file_path = filepath.Join(temp_dir, file_name)
+    if err := os.WriteFile(map_file_path, []byte(file_content), 0o660); err != nil {
+      t.Fatal(err)
+    }
+
+    path_transformation, err := PathTransformationFromProp(map[string]any {
+      "map_file": map_file_path,
+      "replace":  "s`^/?old/`/fallback/`",
+    })
+    if err != nil {
+      t.Fatalf("Error parsing path transformation with map file %s: %s", file_name, err)
+    }
+
+    if got, expect := len(path_transformation.RewriteMap), 2; got != expect {
+      t.Fatalf("Map file %s produced %d rewrite entries, expected %d", file_name, got, expect)
+    }
+
+    for _, test_case := range test_cases {
+      if got := path_transformation.TransformPath(test_case.Path); got != test_case.Expect {
+        t.Errorf("Map file %s transformed path %s into %s, expected %s", file_name, test_case.Path, got, test_case.Expect)
+      }
+    }
+  }
+
+  // Assert unsupported and missing map files produce errors
+  //
+  if err := os.WriteFile(filepath.Join(temp_dir, "redirects.txt"), []byte("old new"), 0o660); err != nil {
+    t.Fatal(err)
+  }
+
+  for _, map_file_path := range []any {
+    filepath.Join(temp_dir, "redirects.txt"),
+    filepath.Join(temp_dir, "does-not-exist.json"),
+    nil,
+    "",
+  } {
+    if _, err := PathTransformationFromProp(map[string]any { "map_file": map_file_path }); err == nil {
+      t.Errorf("Expected an error parsing path transformation with map file %#v", map_file_path)
+    }
+  }
+
+  // Relative map files are resolved against a base directory
+  //
+  transformations, err := PathTransformationsFromAnyDir(map[string]any { "map_file": "redirects.json" }, temp_dir)
+  if err != nil {
+    t.Fatalf("Error parsing path transformation with a relative map file: %s", err)
+  }
+  if got, expect := transformations[0].TransformPath("/old/page.html"), "/new/page.html"; got != expect {
+    t.Errorf("Relative map file transformed path /old/page.html into %s, expected %s", got, expect)
+  }
+}
+
+
+func TestSlugify (t *testing.T) {
+  var test_cases = [][2]string {
+    { "Hello, World!",         "hello-world"       },
+    { "  --leading trailing--", "leading-trailing" },
+    { "Crème Brûlée",          "crème-brûlée"      },
+    { "Ünïcödé_Straße 2024",   "ünïcödé-straße-2024" },
+    { "日本語 ページ",           "日本語-ページ"       },
+    { "!!!",                   ""                  },
+  }
+
+  for _, test_case := range test_cases {
+    if got, expect := Slugify(test_case[0]), test_case[1]; got != expect {
+      t.Errorf("Slugify(\"%s\") returned \"%s\", expected \"%s\"", test_case[0], got, expect)
+    }
+  }
+
+  var path_test_cases = [][2]string {
+    { "/Docs/Getting Started.MD", "/docs/getting-started.md" },
+    { "/Static/.htaccess",        "/static/.htaccess"        },
+    { "Dir Name/",                "dir-name/"                },
+    { "/archive.tar.GZ",          "/archive-tar.gz"          },
+    { "/!!!/page.html",           "/!!!/page.html"           },
+    { "!!!.html",                 "!!!.html"                 },
+    { "/Page.!!!",                "/page.!!!"                },
+  }
+
+  for _, test_case := range path_test_cases {
+    if got, expect := SlugifyPath(test_case[0]), test_case[1]; got != expect {
+      t.Errorf("SlugifyPath(\"%s\") returned \"%s\", expected \"%s\"", test_case[0], got, expect)
+    }
+  }
+}
+
+
+func TestTransformUrlReference (t *testing.T) {
+  transformations, err := PathTransformationsFromAny([]any { "s`^/?`prefix/`", "ext:.md:.html" })
+  if err != nil { t.Fatal(err) }
+
+  base_url, err := url.Parse("ib://spec/dir/index.html")
+  if err != nil { t.Fatal(err) }
+
+  var test_cases = []struct { Ref string; Expect string; Changed bool } {
+    { Ref: "/page.md",                 Expect: "/prefix/page.html",                 Changed: true },
+    { Ref: "/page.md?query=a/b#frag",  Expect: "/prefix/page.html?query=a/b#frag",  Changed: true },
+    { Ref: "page.md#section",          Expect: "/prefix/dir/page.html#section",     Changed: true },
+    { Ref: "ib://spec/page.md?q=1",    Expect: "ib://spec/prefix/page.html?q=1",    Changed: true },
+
+    // Escaped characters remain escaped, and are not mistaken for
+    // query strings or fragments
+    //
+    { Ref: "/a%3Fb.md?x=1",            Expect: "/prefix/a%3Fb.html?x=1",            Changed: true },
+    { Ref: "/a%23b.md#frag",           Expect: "/prefix/a%23b.html#frag",           Changed: true },
+    { Ref: "/a%20b.md",                Expect: "/prefix/a%20b.html",                Changed: true },
+    { Ref: "ib://spec/a%3Fb.md?q=1",   Expect: "ib://spec/prefix/a%3Fb.html?q=1",   Changed: true },
+
+    { Ref: "#fragment",                  Expect: "#fragment"                 },
+    { Ref: "?query=only",                Expect: "?query=only"               },
+    { Ref: "http://example.com/page.md", Expect: "http://example.com/page.md" },
+    { Ref: "//example.com/page.md",      Expect: "//example.com/page.md"     },
+    { Ref: "//spec/page.md",             Expect: "//spec/page.md"            },
+    { Ref: "mailto:user@example.com",    Expect: "mailto:user@example.com"   },
+    { Ref: "data:image/png;base64,AAAA", Expect: "data:image/png;base64,AAAA" },
+  }
+
+  for _, test_case := range test_cases {
+    got, changed := TransformUrlReference(base_url, test_case.Ref, transformations)
+
+    if got != test_case.Expect {
+      t.Errorf("Transforming URL reference %s returned %s, expected %s", test_case.Ref, got, test_case.Expect)
+    }
+
+    if changed != test_case.Changed {
+      t.Errorf("Transforming URL reference %s returned changed=%t, expected %t", test_case.Ref, changed, test_case.Changed)
+    }
+  }
+}
+
+
+func TestPathTransformationWhenCondition (t *testing.T) {
+  transformations, err := PathTransformationsFromAny([]any {
+    map[string]any {
+      "prefix": "pages",
+      "when":   map[string]any { "mime": "text/html" },
+    },
+    map[string]any {
+      "ext":  ".jpeg:.jpg",
+      "when": map[string]any { "path": "m`^images/`" },
+    },
+  })
+  if err != nil { t.Fatal(err) }
+
+  // Assert conditions are honored when transforming URL
+  // references, inferring MIME types from file extensions
+  //
+  base_url, _ := url.Parse("ib://spec/")
+
+  var reference_test_cases = [][2]string {
+    { "/index.html",        "/pages/index.html" },
+    { "/style.css",         "/style.css"        },
+    { "/images/photo.jpeg", "/images/photo.jpg" },
+    { "/other/photo.jpeg",  "/other/photo.jpeg" },
+  }
+
+  for _, test_case := range reference_test_cases {
+    if got, _ := TransformUrlReference(base_url, test_case[0], transformations); got != test_case[1] {
+      t.Errorf("Transforming URL reference %s returned %s, expected %s", test_case[0], got, test_case[1])
+    }
+  }
+
+  // Assert conditions are honored when emitting assets, using
+  // the asset's MIME type
+  //
+  var spec = NewSpec("spec", nil)
+  spec.PathTransformations = transformations
+
+  var output = make(chan *Asset, 1)
+  spec.OutputChannels = append(spec.OutputChannels, &output)
+
+  var emit_test_cases = []struct { Path string; Mimetype string; Expect string } {
+    { Path: "/index.html", Mimetype: "text/html",  Expect: "@emit/pages/index.html" },
+    { Path: "/index",      Mimetype: "text/html",  Expect: "@emit/pages/index"      },
+    { Path: "/index.html", Mimetype: "text/plain", Expect: "@emit/index.html"       },
+
+    // The same path condition matches emitted assets and URL
+    // references
+    //
+    { Path: "/images/photo.jpeg", Mimetype: "image/jpeg", Expect: "@emit/images/photo.jpg"  },
+    { Path: "/other/photo.jpeg",  Mimetype: "image/jpeg", Expect: "@emit/other/photo.jpeg"  },
+  }
+
+  for _, test_case := range emit_test_cases {
+    var asset = & Asset { Url: spec.MakeUrl(test_case.Path), Mimetype: test_case.Mimetype }
+
+    if err := spec.EmitAsset(asset); err != nil {
+      t.Fatal(err)
+    }
+
+    if got := (<-output).Url.Path; got != test_case.Expect {
+      t.Errorf("Emitting asset %s with MIME type %s produced path %s, expected %s", test_case.Path, test_case.Mimetype, got, test_case.Expect)
+    }
+  }
+
+  // Invalid conditions
+  //
+  for _, condition_prop := range []any {
+    "text/html",
+    map[string]any { "mime": 5 },
+    map[string]any { "unknown": "value" },
+    map[string]any { "path": "s/a/b/" },
+  } {
+    if _, err := PathTransformationFromProp(map[string]any { "when": condition_prop }); err == nil {
+      t.Errorf("Expected an error parsing path transformation condition %v", condition_prop)
+    }
+  }
+}
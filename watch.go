@@ -0,0 +1,181 @@
+package interbuilder
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io/fs"
+  "path"
+  "path/filepath"
+  "sort"
+  "time"
+)
+
+
+/*
+  WATCH_INTERVAL is how often a watched directory is scanned for
+  changes, unless the "watch_interval" prop is set.
+*/
+const WATCH_INTERVAL = 500 * time.Millisecond
+
+
+/*
+  watchInterval returns how often this Spec's watched directories
+  are scanned, from the inherited "watch_interval" prop, as a
+  number of seconds or a duration string such as "250ms".
+*/
+func (s *Spec) watchInterval () (time.Duration, error) {
+  interval_any, found := s.InheritProp("watch_interval")
+  if !found {
+    return WATCH_INTERVAL, nil
+  }
+
+  var interval time.Duration
+
+  switch value := interval_any.(type) {
+    case string:
+      var err error
+      if interval, err = time.ParseDuration(value); err != nil {
+        return 0, fmt.Errorf("Prop \"watch_interval\" in Spec %s: %w", s.Name, err)
+      }
+    case float64:
+      interval = time.Duration(value * float64(time.Second))
+    case int:
+      interval = time.Duration(value) * time.Second
+    default:
+      return 0, fmt.Errorf(
+        "Prop \"watch_interval\" in Spec %s is expected to be a number of seconds or a duration string, got %T",
+        s.Name, interval_any,
+      )
+  }
+
+  if interval <= 0 {
+    return 0, fmt.Errorf("Prop \"watch_interval\" in Spec %s is expected to be positive", s.Name)
+  }
+  return interval, nil
+}
+
+
+type watchedFile struct {
+  size      int64
+  mod_time  time.Time
+}
+
+
+/*
+  MakeWatchAsset creates a generator asset which watches a
+  directory within this Spec's source_dir, relative to it if not
+  absolute, and yields file assets, as made by MakeFileKeyAsset,
+  keyed by their paths relative to the directory joined to
+  key_prefix. It first yields the files in the directory, and then
+  blocks until a file is created, or its size or modification time
+  changes, and yields it, until ctx is cancelled, when the
+  generator ends, or the context of the generation is, when it
+  returns its cause. Removed files are not yielded. The directory is
+  scanned every "watch_interval", and files ignored as in
+  Spec.WalkIgnoring are not watched.
+
+  Each call to GenerateAssets starts a new watch, and since the
+  generator does not end on its own, consumers should read it with
+  GenerateAssets rather than Expand or Flatten.
+*/
+func (s *Spec) MakeWatchAsset (ctx context.Context, dir string, key_prefix ...string) (*Asset, error) {
+  source_dir, err := s.RequirePropString("source_dir")
+  if err != nil { return nil, err }
+
+  if !filepath.IsAbs(dir) {
+    dir = filepath.Join(source_dir, dir)
+  }
+  if !PathWithin(source_dir, dir) {
+    return nil, fmt.Errorf("Cannot watch %s, which is not within the source_dir of spec %s", dir, s.Name)
+  }
+
+  interval, err := s.watchInterval()
+  if err != nil { return nil, err }
+
+  var prefix = path.Join(key_prefix...)
+  var asset  = s.MakeAsset(prefix)
+  asset.Mimetype = "inode/directory"
+
+  err = asset.SetAssetGenerator(func (generate_ctx context.Context, _ *Asset) (func () (*Asset, error), error) {
+    var files   = make(map[string]watchedFile)
+    var pending []string
+    var scanned = false
+
+    // scan walks the directory, queueing the keys of new and
+    // changed files
+    //
+    var scan = func () error {
+      var changed []string
+
+      err := s.WalkIgnoring(dir, func (_, key string, entry fs.DirEntry) error {
+        info, err := entry.Info()
+        if errors.Is(err, fs.ErrNotExist) {
+          return nil
+        } else if err != nil {
+          return err
+        }
+
+        var file = watchedFile { size: info.Size(), mod_time: info.ModTime() }
+        if previous, found := files[key]; !found || previous.size != file.size || !previous.mod_time.Equal(file.mod_time) {
+          files[key] = file
+          changed = append(changed, key)
+        }
+        return nil
+      })
+
+      // Files removed while scanning are picked up by the next scan
+      //
+      if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return err
+      }
+
+      sort.Strings(changed)
+      pending = append(pending, changed...)
+      return nil
+    }
+
+    var next = func () (*Asset, error) {
+      for {
+        for len(pending) == 0 {
+          if scanned {
+            var timer = time.NewTimer(interval)
+            select {
+            case <-ctx.Done():
+              timer.Stop()
+              return nil, nil
+            case <-generate_ctx.Done():
+              timer.Stop()
+              return nil, context.Cause(generate_ctx)
+            case <-timer.C:
+            }
+          } else if ctx.Err() != nil {
+            return nil, nil
+          }
+
+          scanned = true
+          if err := scan(); err != nil {
+            return nil, fmt.Errorf("Error watching %s: %w", dir, err)
+          }
+        }
+
+        var key = pending[0]
+        pending = pending[1:]
+
+        asset, err := s.MakeFileKeyAsset(filepath.Join(dir, KeyToPath(key)), prefix, key)
+        if errors.Is(err, fs.ErrNotExist) {
+          delete(files, key)
+          continue
+        } else if err != nil {
+          return nil, err
+        }
+        return asset, nil
+      }
+    }
+
+    return next, nil
+  })
+  if err != nil { return nil, err }
+
+  return asset, nil
+}
@@ -0,0 +1,221 @@
+package interbuilder
+
+import (
+  "context"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+
+func TestSpecMakeWatchAsset (t *testing.T) {
+  var source_dir = t.TempDir()
+
+  root := NewSpec("root", nil)
+  root.Props["source_dir"]     = source_dir
+  root.Props["watch_interval"] = "5ms"
+
+  var write = func (key, content string) {
+    t.Helper()
+    if err := root.WriteFile(key, []byte(content), 0o644); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  write("site/index.html",   "<p>Home</p>")
+  write("site/css/site.css", "body {}")
+  write("site/.ibignore",    "*.tmp\n")
+  write("other.html",        "<p>Other</p>")
+
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+
+  asset, err := root.MakeWatchAsset(ctx, "site", "@emit")
+  if err != nil { t.Fatal(err) }
+  if !asset.IsMulti() {
+    t.Fatalf("Expected a multi-asset, got type mask %O", asset.TypeMask)
+  }
+
+  TestWrapTimeout(t, func () {
+    next, err := asset.GenerateAssets()
+    if err != nil { t.Fatal(err) }
+
+    var expectNext = func (expect, content string) {
+      t.Helper()
+      asset, err := next()
+      if err != nil {
+        t.Fatal(err)
+      } else if asset == nil {
+        t.Fatalf("Expected the asset %s, the generator ended", expect)
+      }
+
+      if key := strings.TrimLeft(asset.Url.Path, "/"); key != expect {
+        t.Errorf("Expected the asset %s, got %s", expect, key)
+      }
+      if got, err := asset.GetContentBytes(); err != nil || string(got) != content {
+        t.Errorf("Expected the content %q of %s, got %q (%v)", content, expect, got, err)
+      }
+    }
+
+    // Existing files are yielded first
+    //
+    expectNext("@emit/css/site.css", "body {}")
+    expectNext("@emit/index.html",   "<p>Home</p>")
+
+    // Then new and changed files, but not ignored ones
+    //
+    write("site/draft.tmp", "draft")
+    write("site/about.html", "<p>About</p>")
+    expectNext("@emit/about.html", "<p>About</p>")
+
+    write("site/index.html", "<p>New home</p>")
+    expectNext("@emit/index.html", "<p>New home</p>")
+
+    // A file whose content changes without changing its size is
+    // yielded by its modification time
+    //
+    var mod_time = time.Now().Add(time.Hour)
+    write("site/css/site.css", "main {}")
+    if err := os.Chtimes(filepath.Join(source_dir, "site", "css", "site.css"), mod_time, mod_time); err != nil {
+      t.Fatal(err)
+    }
+    expectNext("@emit/css/site.css", "main {}")
+
+    // Cancelling the context ends the generator
+    //
+    cancel()
+    if asset, err := next(); asset != nil || err != nil {
+      t.Errorf("Expected the generator to end, got %v, %v", asset, err)
+    }
+  })
+
+  if _, err := root.MakeWatchAsset(ctx, filepath.Dir(source_dir)); err == nil {
+    t.Errorf("Expected an error watching a directory outside of source_dir")
+  }
+
+  root.Props["watch_interval"] = -1
+  if _, err := root.MakeWatchAsset(ctx, "site"); err == nil {
+    t.Errorf("Expected an error for a negative watch_interval")
+  }
+}
+
+
+func TestWatchAssetThroughSpec (t *testing.T) {
+  // The watch asset is emitted by a subspec either as itself, and
+  // read by the root's consumer, or as each asset it yields
+  //
+  var test_cases = []struct {
+    EmitEach  bool
+  }{
+    { EmitEach: false },
+    { EmitEach: true  },
+  }
+
+  for test_case_i, test_case := range test_cases {
+    var source_dir = t.TempDir()
+
+    root := NewSpec("root", nil)
+    root.Props["quiet"] = true
+
+    site := root.AddSubspec(NewSpec("site", nil))
+    site.Props["source_dir"]     = source_dir
+    site.Props["watch_interval"] = "5ms"
+
+    var write = func (key, content string) error {
+      return site.WriteFile(key, []byte(content), 0o644)
+    }
+    if err := write("site/index.html", "<p>Home</p>"); err != nil {
+      t.Fatal(err)
+    }
+    if err := write("site/about.html", "<p>About</p>"); err != nil {
+      t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    site.EnqueueTaskFunc("watch", func (s *Spec, tk *Task) error {
+      asset, err := s.MakeWatchAsset(ctx, "site", "@emit")
+      if err != nil { return err }
+
+      if !test_case.EmitEach {
+        return tk.EmitAsset(asset)
+      }
+
+      next, err := asset.GenerateAssetsContext(tk.Context())
+      if err != nil { return err }
+      for {
+        asset, err := next()
+        if err != nil || asset == nil {
+          return err
+        }
+        if err := tk.EmitAsset(asset); err != nil {
+          return err
+        }
+      }
+    })
+
+    // The consumer modifies a file once the existing files are
+    // received, and stops the watch once the change is
+    //
+    var received []string
+    var receive = func (asset *Asset) error {
+      content, err := asset.GetContentBytes()
+      if err != nil { return err }
+
+      var key = strings.TrimLeft(asset.Url.Path, "/")
+      received = append(received, key + " " + string(content))
+
+      if len(received) == 2 {
+        return write("site/index.html", "<p>New home</p>")
+      } else if string(content) == "<p>New home</p>" {
+        cancel()
+      }
+      return nil
+    }
+
+    root.EnqueueTaskFunc("consume", func (s *Spec, tk *Task) error {
+      for chunk := range s.Input {
+        if chunk.IsSingle() {
+          if err := receive(chunk); err != nil {
+            return err
+          }
+          continue
+        }
+
+        next, err := chunk.GenerateAssetsContext(tk.Context())
+        if err != nil { return err }
+        for {
+          asset, err := next()
+          if err != nil {
+            return err
+          } else if asset == nil {
+            break
+          }
+          if err := receive(asset); err != nil {
+            return err
+          }
+        }
+      }
+      return nil
+    })
+
+    var err error
+    TestWrapTimeout(t, func () { err = root.Run() })
+    if err != nil {
+      t.Errorf("Test case %d: unexpected error: %v", test_case_i, err)
+      continue
+    }
+
+    var expect = []string {
+      "@emit/about.html <p>About</p>",
+      "@emit/index.html <p>Home</p>",
+      "@emit/index.html <p>New home</p>",
+    }
+    if strings.Join(received, "\n") != strings.Join(expect, "\n") {
+      t.Errorf("Test case %d: expected the assets %q, got %q", test_case_i, expect, received)
+    }
+  }
+}